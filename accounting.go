@@ -0,0 +1,182 @@
+package veneur
+
+import (
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// workerAccounting counts a worker's samples at each stage of its
+// pipeline, so that the accounting check can tell when samples go
+// missing between them: when they're handed to the worker, when the
+// worker records them in its samplers (or rejects them), and when it's
+// done with them. The counts are cumulative, and each is taken in a
+// different place, so that a change that skips one of the places shows
+// up as a discrepancy instead of going unnoticed.
+type workerAccounting struct {
+	// ingested counts the samples handed to the worker. It's updated
+	// atomically; the other fields are guarded by the worker's mutex.
+	ingested int64
+	// aggregated and rejected count the samples that the worker
+	// recorded in its samplers, and those it refused, up to its last
+	// flush; the samples since are in its processed and imported
+	// counts.
+	aggregated int64
+	rejected   int64
+	// settled counts the samples that the worker took off its queues
+	// and finished processing. It can never exceed aggregated and
+	// rejected: every sample that settles without being counted by one
+	// of them was lost.
+	settled int64
+
+	// last holds the totals at the previous flush.
+	last accountingTotals
+	lost int64
+}
+
+type accountingTotals struct {
+	ingested, aggregated, rejected int64
+}
+
+// accountingReport is what a worker's samples went through in a flush
+// interval.
+type accountingReport struct {
+	// Ingested, Aggregated and Rejected are the samples handed to the
+	// worker, recorded in its samplers and refused in the interval.
+	Ingested   int64
+	Aggregated int64
+	Rejected   int64
+	// Pending are the samples handed to the worker that it hasn't
+	// finished processing yet. They're negative if it processed
+	// samples that weren't counted as handed to it.
+	Pending int64
+	// Lost are the samples that the worker processed in the interval
+	// without recording or rejecting them.
+	Lost int64
+}
+
+// ingest counts samples handed to the worker, if it's accounting.
+func (w *Worker) ingest(n int) {
+	if w.accounting != nil {
+		atomic.AddInt64(&w.accounting.ingested, int64(n))
+	}
+}
+
+// reject counts a sample that the worker refused, if it's accounting.
+// The worker's mutex must be held.
+func (w *Worker) reject() {
+	if w.accounting != nil {
+		w.accounting.rejected++
+	}
+}
+
+// settle counts the samples that the worker finished processing, if
+// it's accounting.
+func (w *Worker) settle(n int) {
+	if w.accounting == nil {
+		return
+	}
+	w.mutex.Lock()
+	w.accounting.settled += int64(n)
+	w.mutex.Unlock()
+}
+
+// report returns the accounting of the interval that ends with the
+// flush of processed and imported samples. The worker's mutex must be
+// held.
+func (a *workerAccounting) report(processed, imported int64) *accountingReport {
+	a.aggregated += processed + imported
+	settled := a.settled
+	// every sample is counted as ingested before it can settle, so
+	// reading ingested last keeps pending from going negative unless
+	// a sample really bypassed the count:
+	totals := accountingTotals{
+		aggregated: a.aggregated,
+		rejected:   a.rejected,
+		ingested:   atomic.LoadInt64(&a.ingested),
+	}
+
+	r := &accountingReport{
+		Ingested:   totals.ingested - a.last.ingested,
+		Aggregated: totals.aggregated - a.last.aggregated,
+		Rejected:   totals.rejected - a.last.rejected,
+		Pending:    totals.ingested - settled,
+	}
+	if lost := settled - totals.aggregated - totals.rejected; lost > a.lost {
+		r.Lost = lost - a.lost
+		a.lost = lost
+	}
+	a.last = totals
+	return r
+}
+
+// setAccounting makes the worker count its samples for the accounting
+// check.
+func (w *Worker) setAccounting() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.accounting = &workerAccounting{}
+}
+
+// checkAccounting reconciles the workers' accounting of a flush
+// interval, and checks that the flush turned every series that the
+// workers handed to it into InterMetrics: series is how many of their
+// series the flush has to turn into InterMetrics here, rather than
+// only forward, flushed how many it did, and handed the number of
+// InterMetrics that it hands to the sinks. It reports a discrepancy
+// for every stage where samples or series went missing, and returns
+// the discrepancies by stage.
+func (s *Server) checkAccounting(reports []*accountingReport, series, flushed, handed int64) map[string]int64 {
+	var total accountingReport
+	var bypassed int64
+	for _, r := range reports {
+		if r == nil {
+			continue
+		}
+		total.Ingested += r.Ingested
+		total.Aggregated += r.Aggregated
+		total.Rejected += r.Rejected
+		total.Pending += r.Pending
+		total.Lost += r.Lost
+		if r.Pending < 0 {
+			bypassed -= r.Pending
+		}
+	}
+
+	s.Statsd.Count("accounting.ingested_total", total.Ingested, nil, 1.0)
+	s.Statsd.Count("accounting.aggregated_total", total.Aggregated, nil, 1.0)
+	s.Statsd.Count("accounting.rejected_total", total.Rejected, nil, 1.0)
+	s.Statsd.Count("accounting.series_total", series, nil, 1.0)
+	s.Statsd.Count("accounting.flushed_total", handed, nil, 1.0)
+	s.Statsd.Gauge("accounting.pending", float64(total.Pending), nil, 1.0)
+
+	discrepancies := map[string]int64{}
+	discrepancy := func(stage string, missing int64, msg string) {
+		discrepancies[stage] = missing
+		s.Statsd.Count("accounting.discrepancies_total", missing, []string{"stage:" + stage}, 1.0)
+		log.WithFields(logrus.Fields{
+			"stage":      stage,
+			"missing":    missing,
+			"ingested":   total.Ingested,
+			"aggregated": total.Aggregated,
+			"rejected":   total.Rejected,
+			"pending":    total.Pending,
+			"series":     series,
+			"flushed":    flushed,
+		}).Error(msg)
+	}
+	if bypassed > 0 {
+		discrepancy("ingest", bypassed, "Workers processed samples that weren't counted as ingested")
+	}
+	if total.Lost > 0 {
+		discrepancy("aggregate", total.Lost, "Workers lost samples between ingesting and aggregating them")
+	}
+	if flushed != series {
+		missing := series - flushed
+		if missing < 0 {
+			missing = -missing
+		}
+		discrepancy("flush", missing, "The flush turned a different number of series into InterMetrics than the workers handed to it")
+	}
+	return discrepancies
+}
@@ -0,0 +1,151 @@
+package veneur
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/samplers/metricpb"
+)
+
+// drainWorker processes what's queued for w the way its Work loop
+// does.
+func drainWorker(w *Worker) {
+	for len(w.PacketChan) > 0 {
+		m := <-w.PacketChan
+		w.ProcessMetric(&m)
+		w.settle(1)
+	}
+	for len(w.ImportMetricChan) > 0 {
+		ms := <-w.ImportMetricChan
+		for _, m := range ms {
+			w.ImportMetricGRPC(m)
+		}
+		w.settle(len(ms))
+	}
+}
+
+func TestWorkerAccounting(t *testing.T) {
+	w := NewWorker(1, nil, logrus.New(), nil)
+	w.setAccounting()
+
+	for _, name := range []string{"a", "b", "c"} {
+		w.IngestUDP(samplers.UDPMetric{
+			MetricKey:  samplers.MetricKey{Name: name, Type: "counter"},
+			Value:      1.0,
+			SampleRate: 1.0,
+		})
+	}
+	w.IngestMetrics([]*metricpb.Metric{{
+		Name:  "d",
+		Type:  metricpb.Type_Counter,
+		Value: &metricpb.Metric_Counter{Counter: &metricpb.CounterValue{Value: 1}},
+	}, {
+		Name:  "e",
+		Type:  metricpb.Type_Histogram,
+		Scope: metricpb.Scope_Local,
+		Value: &metricpb.Metric_Histogram{Histogram: &metricpb.HistogramValue{}},
+	}})
+	// leave one of the packets queued:
+	m := <-w.PacketChan
+	w.ProcessMetric(&m)
+	w.settle(1)
+	ms := <-w.ImportMetricChan
+	for _, m := range ms {
+		w.ImportMetricGRPC(m)
+	}
+	w.settle(len(ms))
+
+	wm := w.Flush()
+	require.NotNil(t, wm.accounting)
+	assert.Equal(t, accountingReport{Ingested: 5, Aggregated: 2, Rejected: 1, Pending: 2}, *wm.accounting,
+		"the local histogram should be rejected over gRPC")
+
+	drainWorker(w)
+	wm = w.Flush()
+	assert.Equal(t, accountingReport{Aggregated: 2}, *wm.accounting)
+
+	// a sample that the worker finishes without aggregating or
+	// rejecting it is lost:
+	w.ingest(1)
+	w.settle(1)
+	wm = w.Flush()
+	assert.Equal(t, accountingReport{Ingested: 1, Lost: 1}, *wm.accounting)
+	wm = w.Flush()
+	assert.Equal(t, accountingReport{}, *wm.accounting, "losses should be reported once")
+}
+
+func TestCheckAccounting(t *testing.T) {
+	s := &Server{cpuGroups: [][]int{{0}, {1}}, accountingCheck: true}
+	for i := 0; i < 4; i++ {
+		w := NewWorker(i+1, nil, logrus.New(), nil)
+		w.setAccounting()
+		s.Workers = append(s.Workers, w)
+	}
+	for group := 0; group < 2; group++ {
+		for _, packet := range []string{"a.b.c:1|c", "a.histo:10|h", "a.set:foo|s"} {
+			require.NoError(t, s.handleMetricPacket([]byte(packet), s.groupWorkers(group)))
+		}
+	}
+	for _, w := range s.Workers {
+		drainWorker(w)
+	}
+
+	var reports []*accountingReport
+	for _, w := range s.Workers {
+		reports = append(reports, w.Flush().accounting)
+	}
+	assert.Empty(t, s.checkAccounting(reports, 3, 3, 5))
+	assert.Equal(t, map[string]int64{"flush": 1}, s.checkAccounting(reports, 3, 2, 4),
+		"series dropped between the workers and the flush should be caught")
+
+	lost := []*accountingReport{{Ingested: 1, Lost: 1}, {Ingested: 1, Pending: -1, Aggregated: 2}}
+	assert.Equal(t, map[string]int64{"aggregate": 1, "ingest": 1}, s.checkAccounting(lost, 0, 0, 0))
+
+	// the flush reconciles the workers' accounting after merging the
+	// CPU groups:
+	for group := 0; group < 2; group++ {
+		require.NoError(t, s.handleMetricPacket([]byte("a.b.c:1|c"), s.groupWorkers(group)))
+	}
+	for _, w := range s.Workers {
+		drainWorker(w)
+	}
+	wms, ms := s.tallyMetrics(nil)
+	assert.Equal(t, int64(2), ms.totalIngested)
+	assert.Len(t, ms.accounting, 4)
+
+	// the flush turns every series into InterMetrics:
+	_, _, flushed := s.generateInterMetrics(context.Background(), nil, s.HistogramAggregates, wms, ms)
+	assert.Equal(t, ms.seriesToFlush(s.IsLocal()), flushed)
+	assert.Equal(t, 1, flushed, "the counter should be merged across the CPU groups")
+}
+
+func TestImportAccounting(t *testing.T) {
+	w := NewWorker(1, nil, logrus.New(), nil)
+	w.setAccounting()
+
+	c := samplers.Counter{Name: "a"}
+	c.Sample(1, 1)
+	good, err := c.Export()
+	require.NoError(t, err)
+	bad := good
+	bad.Value = []byte("garbage")
+	unknown := good
+	unknown.Type = "nope"
+
+	w.ingest(3)
+	w.ImportMetric(good)
+	w.ImportMetric(bad)
+	w.ImportMetric(unknown)
+	w.settle(3)
+	assert.Equal(t, accountingReport{Ingested: 3, Aggregated: 1, Rejected: 2}, *w.Flush().accounting,
+		"metrics that can't be merged should be counted as rejected")
+
+	w.ingest(1)
+	assert.Error(t, w.ImportMetricGRPC(&metricpb.Metric{Name: "b", Type: metricpb.Type_Counter}))
+	w.settle(1)
+	assert.Equal(t, accountingReport{Ingested: 1, Rejected: 1}, *w.Flush().accounting)
+}
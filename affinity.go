@@ -0,0 +1,102 @@
+package veneur
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// parseCPUList parses a list of CPUs in the format used by the Linux
+// kernel (see cpuset(7)), e.g. "0-3,8,10-11".
+func parseCPUList(list string) ([]int, error) {
+	var cpus []int
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid CPU list %q: %v", list, err)
+		}
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.Atoi(bounds[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid CPU list %q: %v", list, err)
+			}
+		}
+		if first < 0 || last < first {
+			return nil, fmt.Errorf("invalid CPU range %q in CPU list %q", part, list)
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// cpuGroupCount returns the number of CPU groups the server's
+// readers and workers are divided into. Without CPU affinity
+// configured, everything is in one group.
+func (s *Server) cpuGroupCount() int {
+	if len(s.cpuGroups) == 0 {
+		return 1
+	}
+	return len(s.cpuGroups)
+}
+
+// groupWorkers returns the workers that belong to the given CPU
+// group.
+func (s *Server) groupWorkers(group int) []*Worker {
+	n := len(s.Workers) / s.cpuGroupCount()
+	return s.Workers[group*n : (group+1)*n]
+}
+
+// pinToCPUGroup locks the calling goroutine to its OS thread and
+// restricts that thread to the CPUs of the given group. It does
+// nothing if no CPU groups are configured.
+func (s *Server) pinToCPUGroup(group int) {
+	if len(s.cpuGroups) == 0 {
+		return
+	}
+	runtime.LockOSThread()
+	if err := setThreadAffinity(s.cpuGroups[group]); err != nil {
+		log.WithError(err).WithFields(logrus.Fields{
+			"group": group,
+			"cpus":  s.cpuGroups[group],
+		}).Warn("Could not pin goroutine to CPUs")
+	}
+}
+
+// mergeCPUGroups combines the metrics flushed by the workers of all
+// CPU groups. Readers in each group only hand metrics to their own
+// group's workers, using the same digest-based assignment in each
+// group, so a metric can only appear on workers at the same index in
+// different groups; those are merged, so every metric is flushed
+// exactly once. Merging goes through the same sampler snapshots as
+// restarts, which carry all of a sampler's state.
+func (s *Server) mergeCPUGroups(wms []WorkerMetrics) []WorkerMetrics {
+	groups := s.cpuGroupCount()
+	if groups < 2 {
+		return wms
+	}
+	n := len(wms) / groups
+	merged := wms[:n]
+	for g := 1; g < groups; g++ {
+		for i, wm := range wms[g*n : (g+1)*n] {
+			merged[i].ingested += wm.ingested
+			for _, snap := range wm.samplerSnapshots() {
+				if err := merged[i].restoreSamplerSnapshot(snap); err != nil {
+					log.WithError(err).WithField("name", snap.Name).
+						Warn("Could not merge metric across CPU groups")
+				}
+			}
+			for mk, sc := range wm.localStatusChecks {
+				merged[i].localStatusChecks[mk] = sc
+			}
+		}
+	}
+	return merged
+}
@@ -0,0 +1,29 @@
+package veneur
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// setThreadAffinity restricts the calling OS thread to the given
+// CPUs.
+func setThreadAffinity(cpus []int) error {
+	max := 0
+	for _, cpu := range cpus {
+		if cpu > max {
+			max = cpu
+		}
+	}
+	mask := make([]uint64, max/64+1)
+	for _, cpu := range cpus {
+		mask[cpu/64] |= 1 << uint(cpu%64)
+	}
+	// A pid of 0 refers to the calling thread:
+	_, _, errno := unix.RawSyscall(unix.SYS_SCHED_SETAFFINITY, 0,
+		uintptr(len(mask)*8), uintptr(unsafe.Pointer(&mask[0])))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
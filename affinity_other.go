@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package veneur
+
+import "errors"
+
+// setThreadAffinity is only supported on Linux.
+func setThreadAffinity(cpus []int) error {
+	return errors.New("CPU affinity is not supported on this platform")
+}
@@ -0,0 +1,136 @@
+package veneur
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+)
+
+func TestParseCPUList(t *testing.T) {
+	tests := []struct {
+		list string
+		cpus []int
+		ok   bool
+	}{
+		{"0", []int{0}, true},
+		{"0-3", []int{0, 1, 2, 3}, true},
+		{"0-1,8, 10-11", []int{0, 1, 8, 10, 11}, true},
+		{"", nil, false},
+		{"3-1", nil, false},
+		{"a-b", nil, false},
+		{"-1", nil, false},
+	}
+	for _, elt := range tests {
+		test := elt
+		t.Run(test.list, func(t *testing.T) {
+			cpus, err := parseCPUList(test.list)
+			if !test.ok {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.cpus, cpus)
+		})
+	}
+}
+
+func TestMergeCPUGroups(t *testing.T) {
+	s := &Server{cpuGroups: [][]int{{0}, {1}}}
+	for i := 0; i < 4; i++ {
+		s.Workers = append(s.Workers, NewWorker(i+1, nil, logrus.New(), nil))
+	}
+
+	// Both groups receive the same metrics:
+	for group := 0; group < 2; group++ {
+		workers := s.groupWorkers(group)
+		require.Len(t, workers, 2)
+		for _, packet := range []string{"a.b.c:1|c", "a.b.d:2|c", "a.histo:10|h", "a.set:foo|s"} {
+			require.NoError(t, s.handleMetricPacket([]byte(packet), workers))
+		}
+		s.handleMetricPacket([]byte("a.set:group"+strconv.Itoa(group)+"|s"), workers)
+	}
+	for _, w := range s.Workers {
+		for len(w.PacketChan) > 0 {
+			m := <-w.PacketChan
+			w.ProcessMetric(&m)
+		}
+	}
+
+	wms, ms := s.tallyMetrics(nil)
+	assert.Len(t, wms, 2)
+	assert.Equal(t, 2, ms.totalCounters, "each counter should only be flushed once")
+	assert.Equal(t, 1, ms.totalHistograms)
+	assert.Equal(t, 1, ms.totalSets)
+	assert.Equal(t, int64(10), ms.totalIngested)
+
+	for _, wm := range wms {
+		for mk, c := range wm.counters {
+			expected := 2.0
+			if mk.Name == "a.b.d" {
+				expected = 4.0
+			}
+			assert.Equal(t, expected, c.Flush(s.interval)[0].Value, "counter %s", mk.Name)
+		}
+		for _, h := range wm.histograms {
+			assert.Equal(t, 2.0, h.LocalWeight)
+		}
+		for _, set := range wm.sets {
+			assert.Equal(t, 3.0, set.Flush()[0].Value)
+		}
+	}
+}
+
+func TestMergeCPUGroupsMetadata(t *testing.T) {
+	s := &Server{cpuGroups: [][]int{{0}, {1}}}
+	for i := 0; i < 4; i++ {
+		w := NewWorker(i+1, nil, logrus.New(), nil)
+		w.setCounterSummaries(true)
+		s.Workers = append(s.Workers, w)
+	}
+
+	// The counter is sampled in both groups, but only group 1's
+	// samples carry its unit and priority; the histogram is only
+	// seen in group 1:
+	for group := 0; group < 2; group++ {
+		workers := s.groupWorkers(group)
+		for _, packet := range []string{"a.b.c:2|c|@0.5", "a.histo:10|h"} {
+			m, err := samplers.ParseMetric([]byte(packet))
+			require.NoError(t, err)
+			if m.Type == "histogram" && group == 0 {
+				continue
+			}
+			if group == 1 {
+				m.Unit = "byte"
+				m.Priority = samplers.PriorityHigh
+				m.Exemplar = &samplers.Exemplar{TraceID: 1, SpanID: 2, Value: 10}
+			}
+			workers[m.Digest%uint32(len(workers))].ProcessMetric(m)
+		}
+	}
+
+	wms, ms := s.tallyMetrics(nil)
+	assert.Equal(t, 1, ms.totalCounters)
+	assert.Equal(t, 1, ms.totalHistograms)
+	for _, wm := range wms {
+		for _, c := range wm.counters {
+			assert.Equal(t, "byte", c.Unit)
+			assert.Equal(t, samplers.PriorityHigh, c.Priority)
+			assert.Equal(t, samplers.CounterSummary{Samples: 2, RawSum: 4, ScaledSum: 8}, c.Summary())
+			flushed := c.Flush(s.interval)
+			require.Len(t, flushed, 3)
+			assert.Equal(t, 8.0, flushed[0].Value)
+			assert.Equal(t, 2.0, flushed[1].Value, "%s", flushed[1].Name)
+			assert.Equal(t, 2.0, flushed[2].Value, "%s", flushed[2].Name)
+		}
+		for _, h := range wm.histograms {
+			assert.Equal(t, "byte", h.Unit)
+			assert.Equal(t, samplers.PriorityHigh, h.Priority)
+			require.NotNil(t, h.Exemplar)
+			assert.Equal(t, int64(1), h.Exemplar.TraceID)
+		}
+	}
+}
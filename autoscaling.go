@@ -0,0 +1,122 @@
+package veneur
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// AutoscalingSignals are indicators of how loaded a veneur instance
+// is, meant for driving horizontal autoscalers. Their semantics are
+// stable across releases; see the "Autoscaling" section of the
+// README.
+type AutoscalingSignals struct {
+	// The number of metric samples processed or imported per
+	// second, averaged over the last flush interval.
+	MetricsPerSecond float64 `json:"metrics_per_second"`
+
+	// The configured autoscaling_capacity_per_second, or
+	// zero if none is configured.
+	CapacityMetricsPerSecond float64 `json:"capacity_metrics_per_second"`
+
+	// The fill level (from 0 to 1) of the fullest queue between
+	// the listeners and the workers.
+	QueueSaturation float64 `json:"queue_saturation"`
+
+	// The fraction of the flush interval that was left over after
+	// the last flush completed. Zero or negative values mean that
+	// flushes can't keep up.
+	FlushHeadroom float64 `json:"flush_headroom"`
+
+	// The single value to scale on: the largest of the ingest rate
+	// relative to capacity, the queue saturation and the fraction
+	// of the interval spent flushing. 1 means the instance is
+	// saturated.
+	Load float64 `json:"load"`
+}
+
+// recordIngested updates the ingest rate with the number of metrics
+// the workers ingested since the previous flush.
+func (s *Server) recordIngested(ingested int64) {
+	s.autoscalingMtx.Lock()
+	defer s.autoscalingMtx.Unlock()
+
+	now := time.Now()
+	elapsed := s.interval
+	if !s.lastTally.IsZero() {
+		elapsed = now.Sub(s.lastTally)
+	}
+	s.lastTally = now
+	if elapsed > 0 {
+		s.ingestRate = float64(ingested) / elapsed.Seconds()
+	}
+}
+
+// recordFlushDuration records how long the last flush took.
+func (s *Server) recordFlushDuration(d time.Duration) {
+	s.autoscalingMtx.Lock()
+	defer s.autoscalingMtx.Unlock()
+	s.lastFlushDuration = d
+}
+
+// queueSaturation returns the fill level of the fullest channel
+// that feeds the workers.
+func (s *Server) queueSaturation() float64 {
+	saturation := 0.0
+	fill := func(length, capacity int) {
+		if capacity > 0 {
+			saturation = maxFloat(saturation, float64(length)/float64(capacity))
+		}
+	}
+	for _, w := range s.Workers {
+		fill(len(w.PacketChan), cap(w.PacketChan))
+		fill(len(w.ImportChan), cap(w.ImportChan))
+		fill(len(w.ImportMetricChan), cap(w.ImportMetricChan))
+	}
+	fill(len(s.SpanChan), cap(s.SpanChan))
+	return saturation
+}
+
+// AutoscalingSignals returns the server's current autoscaling
+// signals.
+func (s *Server) AutoscalingSignals() AutoscalingSignals {
+	s.autoscalingMtx.Lock()
+	signals := AutoscalingSignals{
+		MetricsPerSecond:         s.ingestRate,
+		CapacityMetricsPerSecond: s.autoscalingCapacity,
+		FlushHeadroom:            1,
+	}
+	if s.interval > 0 {
+		signals.FlushHeadroom = 1 - s.lastFlushDuration.Seconds()/s.interval.Seconds()
+	}
+	s.autoscalingMtx.Unlock()
+
+	signals.QueueSaturation = s.queueSaturation()
+	signals.Load = maxFloat(signals.QueueSaturation, 1-signals.FlushHeadroom)
+	if signals.CapacityMetricsPerSecond > 0 {
+		signals.Load = maxFloat(signals.Load, signals.MetricsPerSecond/signals.CapacityMetricsPerSecond)
+	}
+	return signals
+}
+
+// reportAutoscalingSignals emits the autoscaling signals as gauges,
+// for autoscalers that read from a metrics backend.
+func (s *Server) reportAutoscalingSignals() {
+	signals := s.AutoscalingSignals()
+	s.Statsd.Gauge("autoscaling.metrics_per_second", signals.MetricsPerSecond, nil, 1.0)
+	s.Statsd.Gauge("autoscaling.queue_saturation", signals.QueueSaturation, nil, 1.0)
+	s.Statsd.Gauge("autoscaling.flush_headroom", signals.FlushHeadroom, nil, 1.0)
+	s.Statsd.Gauge("autoscaling.load", signals.Load, nil, 1.0)
+}
+
+// handleAutoscaling serves the server's autoscaling signals as JSON.
+func handleAutoscaling(s *Server) func(context.Context, http.ResponseWriter, *http.Request) {
+	return func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s.AutoscalingSignals()); err != nil {
+			log.WithError(err).Warn("Could not encode autoscaling signals")
+		}
+	}
+}
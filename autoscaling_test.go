@@ -0,0 +1,61 @@
+package veneur
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+)
+
+func TestAutoscalingSignals(t *testing.T) {
+	s := &Server{
+		interval:            10 * time.Second,
+		autoscalingCapacity: 100,
+		Workers:             []*Worker{NewWorker(1, nil, logrus.New(), nil)},
+	}
+	for i := 0; i < 1000; i++ {
+		s.Workers[0].ProcessMetric(&samplers.UDPMetric{
+			MetricKey: samplers.MetricKey{Name: "a.b.c", Type: "counter"},
+			Value:     1.0,
+			Scope:     samplers.MixedScope,
+		})
+	}
+	s.tallyMetrics(nil)
+	s.recordFlushDuration(2 * time.Second)
+
+	signals := s.AutoscalingSignals()
+	assert.InDelta(t, 100, signals.MetricsPerSecond, 0.01)
+	assert.Equal(t, 100.0, signals.CapacityMetricsPerSecond)
+	assert.InDelta(t, 0.8, signals.FlushHeadroom, 0.001)
+	assert.Equal(t, 0.0, signals.QueueSaturation)
+	assert.InDelta(t, 1, signals.Load, 0.01, "at capacity, the load should be 1")
+
+	// Fill up half of a worker queue:
+	for i := 0; i < cap(s.Workers[0].PacketChan)/2; i++ {
+		s.Workers[0].PacketChan <- samplers.UDPMetric{}
+	}
+	s.autoscalingCapacity = 0
+	signals = s.AutoscalingSignals()
+	assert.Equal(t, 0.5, signals.QueueSaturation)
+	assert.Equal(t, 0.5, signals.Load, "without a capacity, the load should follow the queues")
+}
+
+func TestAutoscalingEndpoint(t *testing.T) {
+	config := localConfig()
+	s := setupVeneurServer(t, config, nil, nil, nil)
+	defer s.Shutdown()
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/autoscaling", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var signals AutoscalingSignals
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&signals))
+	assert.Equal(t, 1.0, signals.FlushHeadroom, "a fresh server has not flushed yet")
+}
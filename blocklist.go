@@ -0,0 +1,181 @@
+package veneur
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/ssf"
+	"golang.org/x/net/context"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// maxBlocklistBodySize is the largest blocklist that can be pushed
+// through the HTTP API.
+const maxBlocklistBodySize = 1 << 20
+
+// blocklistRule is a rule of a metric or span blocklist.
+type blocklistRule interface {
+	// validate returns an error if the rule can't match anything.
+	validate() error
+	// dropped returns the counter of what the rule dropped since
+	// the last report, updated atomically.
+	dropped() *int64
+	// String describes the rule in the tags of its counter.
+	String() string
+}
+
+// blocklistFile is the format of a blocklist, in YAML or JSON.
+type blocklistFile[R blocklistRule] struct {
+	Rules []R `yaml:"rules" json:"rules"`
+}
+
+// parseBlocklist parses and validates a blocklist.
+func parseBlocklist[R blocklistRule](bts []byte) ([]R, error) {
+	var file blocklistFile[R]
+	if err := yaml.UnmarshalStrict(bts, &file); err != nil {
+		return nil, err
+	}
+	for _, rule := range file.Rules {
+		if err := rule.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return file.Rules, nil
+}
+
+// blocklist holds the rules of a metric or span blocklist. They can be
+// replaced at runtime through the HTTP API, or by editing a file that
+// the blocklist watches, and take effect immediately.
+type blocklist[R blocklistRule] struct {
+	// kind is "metric" or "span", for logs and tags
+	kind string
+	// counter is the name of the counter of what the rules dropped
+	counter string
+	file    string
+
+	rules atomic.Value // []R
+	// modified is when the file was last modified, when it was
+	// last read
+	modified time.Time
+}
+
+// newBlocklist returns a blocklist without rules that watches file, if
+// it's not empty.
+func newBlocklist[R blocklistRule](kind, counter, file string) *blocklist[R] {
+	b := &blocklist[R]{kind: kind, counter: counter, file: file}
+	b.rules.Store([]R(nil))
+	return b
+}
+
+// inEffect returns the rules in effect.
+func (b *blocklist[R]) inEffect() []R {
+	return b.rules.Load().([]R)
+}
+
+// replace replaces the rules in effect.
+func (b *blocklist[R]) replace(rules []R) {
+	b.rules.Store(rules)
+}
+
+// reload reads the blocklist's file if it was modified since it was
+// last read, and replaces the rules in effect with its rules. If that
+// fails, the rules in effect stay in effect and the returned error's
+// cause (see ssf.Failure) says why.
+func (b *blocklist[R]) reload() (cause string, err error) {
+	info, err := os.Stat(b.file)
+	if err != nil {
+		return ssf.CauseIOError, err
+	}
+	if info.ModTime().Equal(b.modified) {
+		return "", nil
+	}
+	bts, err := ioutil.ReadFile(b.file)
+	if err != nil {
+		return ssf.CauseIOError, err
+	}
+	rules, err := parseBlocklist[R](bts)
+	if err != nil {
+		return ssf.CauseParseError, err
+	}
+	b.modified = info.ModTime()
+	b.replace(rules)
+	log.WithFields(logrus.Fields{
+		"file":  b.file,
+		"rules": len(rules),
+	}).Infof("Loaded %s blocklist", b.kind)
+	return "", nil
+}
+
+// report returns counters of what each rule dropped since the last
+// report.
+func (b *blocklist[R]) report() []*ssf.SSFSample {
+	var samples []*ssf.SSFSample
+	for _, rule := range b.inEffect() {
+		if n := atomic.SwapInt64(rule.dropped(), 0); n > 0 {
+			samples = append(samples, ssf.Count(b.counter, float32(n),
+				map[string]string{"rule": rule.String()}))
+		}
+	}
+	return samples
+}
+
+// watchBlocklist re-reads a blocklist's file whenever it changes,
+// until the server shuts down.
+func watchBlocklist[R blocklistRule](s *Server, b *blocklist[R], interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.shutdown:
+			return
+		case <-ticker.C:
+			if cause, err := b.reload(); err != nil {
+				log.WithError(err).WithField("file", b.file).
+					Warnf("Could not reload %s blocklist, keeping the previous rules", b.kind)
+				s.Statsd.Count("blocklist.reload_errors_total", 1,
+					failureTags("blocklist", cause, "kind:"+b.kind+"s"), 1.0)
+			}
+		}
+	}
+}
+
+// handleBlocklist serves the rules of a blocklist as JSON.
+func handleBlocklist[R blocklistRule](b *blocklist[R]) func(context.Context, http.ResponseWriter, *http.Request) {
+	return func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		rules := b.inEffect()
+		if rules == nil {
+			rules = []R{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(blocklistFile[R]{Rules: rules}); err != nil {
+			log.WithError(err).Warnf("Could not encode %s blocklist", b.kind)
+		}
+	}
+}
+
+// handleBlocklistReplace replaces the rules of a blocklist with those
+// in the request body, in the same YAML or JSON format as the
+// blocklist file. The rules stay in effect until the next request, or
+// until the file changes.
+func handleBlocklistReplace[R blocklistRule](b *blocklist[R]) func(context.Context, http.ResponseWriter, *http.Request) {
+	return func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		bts, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBlocklistBodySize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rules, err := parseBlocklist[R](bts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		b.replace(rules)
+		log.WithField("rules", len(rules)).Warnf("Replaced %s blocklist", b.kind)
+		w.Write([]byte("ok\n"))
+	}
+}
@@ -0,0 +1,241 @@
+package veneur
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/ssf"
+)
+
+const (
+	// canaryMetricName is the name of the gauge that the canary
+	// injects. Its value is the time it was injected, in seconds
+	// since the epoch.
+	canaryMetricName = "veneur.canary"
+	// canaryService is the service of the spans that the canary
+	// injects.
+	canaryService = "veneur-canary"
+	// canaryTag identifies the veneur that injected a canary metric
+	// or span, by its hostname.
+	canaryTag = "veneur_canary"
+)
+
+// canaryPrecision is how far the injection time carried by a canary
+// metric's value may be from the actual injection time, since it goes
+// through a float64.
+const canaryPrecision = time.Millisecond
+
+// canaryLossIntervals is how many intervals an injected metric or span
+// may take to be handed to the sinks before it counts as lost.
+const canaryLossIntervals = 3
+
+// canaryKey identifies the hand-off latencies of a kind of canary
+// ("metric" or "span") as measured in a tier ("local" or "global").
+type canaryKey struct {
+	kind, tier string
+}
+
+// canary periodically injects a metric and a span into veneur's own
+// ingest path, and measures how long they take to reach the point
+// where they are handed to the sinks, and how many never do. It
+// doesn't measure whether the sinks deliver them to their backends.
+// The metric can also be forwarded, so that the global veneur measures
+// the hand-off latency across both tiers; only the injecting veneur
+// knows what it sent, so forwarded canaries that never arrive aren't
+// counted as lost (see reporterTracker for that).
+type canary struct {
+	hostname string
+	interval time.Duration
+	forward  bool
+	// lossAfter is how long after being injected a canary that
+	// wasn't handed to the sinks counts as lost.
+	lossAfter time.Duration
+
+	mtx sync.Mutex
+	// pending holds the injection times of the metrics and spans
+	// that weren't handed to the sinks yet, by kind ("metric" or
+	// "span") and span ID; metrics are keyed by their injection
+	// time.
+	pending map[string]map[int64]time.Time
+	// latencies holds the hand-off latencies measured since the
+	// last report.
+	latencies map[canaryKey][]time.Duration
+	sent      map[string]int
+	lost      map[string]int
+}
+
+// newCanary returns a canary that injects every interval. Canaries
+// that aren't handed to the sinks within canaryLossIntervals of the
+// longer of interval and the flush interval count as lost.
+func newCanary(hostname string, interval, flushInterval time.Duration, forward bool) *canary {
+	lossAfter := interval
+	if flushInterval > lossAfter {
+		lossAfter = flushInterval
+	}
+	return &canary{
+		hostname:  hostname,
+		interval:  interval,
+		forward:   forward,
+		lossAfter: canaryLossIntervals * lossAfter,
+		pending: map[string]map[int64]time.Time{
+			"metric": {},
+			"span":   {},
+		},
+		latencies: map[canaryKey][]time.Duration{},
+		sent:      map[string]int{},
+		lost:      map[string]int{},
+	}
+}
+
+// metricPackets returns the statsd packets of the canary metric
+// injected at now: one that is flushed locally, and one that is
+// forwarded to the global veneur if forwarding is enabled.
+func (c *canary) metricPackets(now time.Time) [][]byte {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.pending["metric"][now.UnixNano()] = now
+	c.sent["metric"]++
+
+	value := strconv.FormatFloat(float64(now.UnixNano())/float64(time.Second), 'f', -1, 64)
+	packet := fmt.Sprintf("%s:%s|g|#%s:%s", canaryMetricName, value, canaryTag, c.hostname)
+	packets := [][]byte{[]byte(packet + ",veneurlocalonly")}
+	if c.forward {
+		packets = append(packets, []byte(packet+",veneurglobalonly"))
+	}
+	return packets
+}
+
+// span returns the canary span injected at now.
+func (c *canary) span(now time.Time) *ssf.SSFSpan {
+	id := rand.Int63()
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.pending["span"][id] = now
+	c.sent["span"]++
+	return &ssf.SSFSpan{
+		Id:             id,
+		TraceId:        id,
+		Name:           "canary",
+		Service:        canaryService,
+		StartTimestamp: now.UnixNano(),
+		EndTimestamp:   now.UnixNano(),
+		Tags:           map[string]string{canaryTag: c.hostname},
+	}
+}
+
+// observeMetrics looks for canary metrics among the metrics about to be
+// handed to the sinks. Canaries that this veneur injected are handed
+// off; those that other veneurs forwarded to this one only have their
+// latency measured, in the global tier.
+func (c *canary) observeMetrics(metrics []samplers.InterMetric, now time.Time) {
+	for _, m := range metrics {
+		if m.Name != canaryMetricName {
+			continue
+		}
+		injected := time.Unix(0, int64(m.Value*float64(time.Second)))
+		source := ""
+		for _, tag := range m.Tags {
+			if strings.HasPrefix(tag, canaryTag+":") {
+				source = tag[len(canaryTag)+1:]
+			}
+		}
+		c.mtx.Lock()
+		if source == c.hostname {
+			c.deliver("metric", "local", injected, now)
+		} else {
+			key := canaryKey{"metric", "global"}
+			c.latencies[key] = append(c.latencies[key], now.Sub(injected))
+		}
+		c.mtx.Unlock()
+	}
+}
+
+// deliver marks the metrics that were injected up to a handed-off
+// canary's injection time as handed off, since a gauge only keeps the
+// last value it received in an interval. It must be called with the
+// lock held.
+func (c *canary) deliver(kind, tier string, injected, now time.Time) {
+	found := false
+	for key, t := range c.pending[kind] {
+		if !t.After(injected.Add(canaryPrecision)) {
+			delete(c.pending[kind], key)
+			found = true
+		}
+	}
+	if found {
+		key := canaryKey{kind, tier}
+		c.latencies[key] = append(c.latencies[key], now.Sub(injected))
+	}
+}
+
+// observeSpan records the hand-off of a span to the span sinks, if it's
+// a canary span that this veneur injected.
+func (c *canary) observeSpan(span *ssf.SSFSpan, now time.Time) {
+	if span.Service != canaryService || span.Tags[canaryTag] != c.hostname {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if injected, ok := c.pending["span"][span.Id]; ok {
+		delete(c.pending["span"], span.Id)
+		key := canaryKey{"span", "local"}
+		c.latencies[key] = append(c.latencies[key], now.Sub(injected))
+	}
+}
+
+// report returns the canary's hand-off latencies, and the number of
+// canaries sent and lost since the last report.
+func (c *canary) report(now time.Time) []*ssf.SSFSample {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	var samples []*ssf.SSFSample
+	for key, latencies := range c.latencies {
+		tags := map[string]string{"kind": key.kind, "tier": key.tier}
+		for _, latency := range latencies {
+			samples = append(samples, ssf.Timing("canary.handoff_latency_ns", latency, time.Nanosecond, tags))
+		}
+	}
+	for kind, pending := range c.pending {
+		for key, injected := range pending {
+			if now.Sub(injected) > c.lossAfter {
+				delete(pending, key)
+				c.lost[kind]++
+			}
+		}
+		tags := map[string]string{"kind": kind}
+		samples = append(samples,
+			ssf.Count("canary.sent_total", float32(c.sent[kind]), tags),
+			ssf.Count("canary.lost_total", float32(c.lost[kind]), tags))
+	}
+	c.latencies = map[canaryKey][]time.Duration{}
+	c.sent = map[string]int{}
+	c.lost = map[string]int{}
+	return samples
+}
+
+// runCanary injects a canary metric and, if there are span sinks, a
+// canary span every interval, until the server shuts down.
+func (s *Server) runCanary() {
+	ticker := time.NewTicker(s.canary.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.shutdown:
+			return
+		case now := <-ticker.C:
+			for _, packet := range s.canary.metricPackets(now) {
+				if err := s.HandleMetricPacket(packet); err != nil {
+					log.WithError(err).Warn("Could not inject canary metric")
+				}
+			}
+			if len(s.spanSinks) > 0 {
+				s.handleSSF(s.canary.span(now), "canary")
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package veneur
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/ssf"
+)
+
+// canarySamples indexes the samples that a canary reported by name
+// and kind, and tier for latencies.
+func canarySamples(samples []*ssf.SSFSample) map[string]float32 {
+	values := map[string]float32{}
+	for _, s := range samples {
+		key := s.Name + "/" + s.Tags["kind"]
+		if tier, ok := s.Tags["tier"]; ok {
+			key += "/" + tier
+		}
+		values[key] += s.Value
+	}
+	return values
+}
+
+func TestCanaryMetricPackets(t *testing.T) {
+	c := newCanary("host-a", time.Second, 10*time.Second, true)
+	now := time.Now()
+	packets := c.metricPackets(now)
+	require.Len(t, packets, 2)
+
+	local, err := samplers.ParseMetric(packets[0])
+	require.NoError(t, err)
+	assert.Equal(t, canaryMetricName, local.Name)
+	assert.Equal(t, "gauge", local.Type)
+	assert.Equal(t, samplers.LocalOnly, local.Scope)
+	assert.Equal(t, []string{"veneur_canary:host-a"}, local.Tags)
+	assert.InDelta(t, float64(now.UnixNano())/float64(time.Second), local.Value, 0.001)
+
+	global, err := samplers.ParseMetric(packets[1])
+	require.NoError(t, err)
+	assert.Equal(t, samplers.GlobalOnly, global.Scope)
+}
+
+func TestCanaryMetrics(t *testing.T) {
+	c := newCanary("host-a", time.Second, 10*time.Second, false)
+	start := time.Now()
+	c.metricPackets(start)
+	c.metricPackets(start.Add(time.Second))
+	c.metricPackets(start.Add(2 * time.Second))
+
+	injected := start.Add(time.Second)
+	flushed := start.Add(3 * time.Second)
+	c.observeMetrics([]samplers.InterMetric{
+		{Name: "other", Value: 1},
+		{
+			Name:  canaryMetricName,
+			Value: float64(injected.UnixNano()) / float64(time.Second),
+			Tags:  []string{"veneur_canary:host-a"},
+		},
+		{
+			Name:  canaryMetricName,
+			Value: float64(start.UnixNano()) / float64(time.Second),
+			Tags:  []string{"veneur_canary:host-b"},
+		},
+	}, flushed)
+
+	values := canarySamples(c.report(flushed))
+	assert.Equal(t, float32(3), values["canary.sent_total/metric"])
+	assert.Equal(t, float32(0), values["canary.lost_total/metric"])
+	assert.InDelta(t, float64(2*time.Second), values["canary.handoff_latency_ns/metric/local"], float64(time.Millisecond))
+	assert.InDelta(t, float64(3*time.Second), values["canary.handoff_latency_ns/metric/global"], float64(time.Millisecond),
+		"canaries forwarded by other veneurs are measured in the global tier")
+
+	// The last canary was never handed off:
+	values = canarySamples(c.report(start.Add(time.Minute)))
+	assert.Equal(t, float32(0), values["canary.sent_total/metric"])
+	assert.Equal(t, float32(1), values["canary.lost_total/metric"])
+}
+
+func TestCanarySpans(t *testing.T) {
+	c := newCanary("host-a", time.Second, 10*time.Second, false)
+	start := time.Now()
+	delivered := c.span(start)
+	assert.Equal(t, canaryService, delivered.Service)
+	c.span(start)
+
+	c.observeSpan(&ssf.SSFSpan{Id: delivered.Id, Service: "other"}, start)
+	c.observeSpan(delivered, start.Add(time.Second))
+
+	values := canarySamples(c.report(start.Add(time.Second)))
+	assert.Equal(t, float32(2), values["canary.sent_total/span"])
+	assert.Equal(t, float32(time.Second), values["canary.handoff_latency_ns/span/local"])
+
+	values = canarySamples(c.report(start.Add(time.Minute)))
+	assert.Equal(t, float32(1), values["canary.lost_total/span"])
+}
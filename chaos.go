@@ -0,0 +1,279 @@
+package veneur
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/stripe/veneur/ssf"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The faults that chaos mode injects.
+const (
+	chaosSinkError = iota
+	chaosSinkTimeout
+	chaosSinkDelay
+	chaosForwardError
+	chaosPacketDrop
+	chaosFaults
+)
+
+var chaosFaultNames = [chaosFaults]string{"sink_error", "sink_timeout", "sink_delay", "forward_error", "packet_drop"}
+
+// chaosForwardSink is the name that forwarding has among the sinks,
+// like in sinkHTTPClient.
+const chaosForwardSink = "forward"
+
+// chaosHTTPSinks are the sinks that send their requests through
+// sinkHTTPClient, which chaos mode can inject faults into. The other
+// sinks, like Splunk's, which keeps connection pools of its own, and
+// Kafka's, LightStep's and Falconer's, which don't speak HTTP, are left
+// alone.
+var chaosHTTPSinks = map[string]bool{
+	"datadog":    true,
+	"signalfx":   true,
+	"prometheus": true,
+	"loki":       true,
+	"tempo":      true,
+}
+
+// chaosStatus is the status of the responses that chaos mode makes up
+// for the requests it fails. Sinks retry it by default.
+const chaosStatus = http.StatusServiceUnavailable
+
+// chaosConfig is the configuration of chaos mode. Rates are the
+// fraction of requests or packets that a fault is injected into,
+// between 0 and 1.
+type chaosConfig struct {
+	// sinks are the names of the sinks that faults are injected
+	// into; all of them, if it's empty.
+	sinks            []string
+	sinkErrorRate    float64
+	sinkTimeoutRate  float64
+	sinkDelayRate    float64
+	sinkDelay        time.Duration
+	forwardErrorRate float64
+	packetDropRate   float64
+}
+
+// chaos injects failures into the requests that veneur sends to its
+// sinks' backends and to the veneur it forwards to, and into the
+// datagrams it receives, so that the retries, shedding and alerting
+// that are meant to handle them can be tried out in staging before
+// they're needed. Faults are injected beneath the retries and circuit
+// breakers, like the backend or the network would cause them. Its
+// methods are safe to call on a nil *chaos, which injects nothing.
+type chaos struct {
+	conf  chaosConfig
+	sinks map[string]bool
+
+	// roll returns a number in [0, 1); it's rand.Float64 except in
+	// tests.
+	roll func() float64
+
+	mtx sync.Mutex
+	// injected counts the faults injected into each sink since the
+	// last report, by fault, updated atomically. Faults that aren't
+	// injected into a sink are counted under "".
+	injected map[string]*[chaosFaults]int64
+	// unattributed are the counters under "", which dropPacket
+	// updates without taking the lock.
+	unattributed *[chaosFaults]int64
+}
+
+func newChaos(conf chaosConfig) (*chaos, error) {
+	for _, rate := range []struct {
+		name  string
+		value float64
+	}{
+		{"chaos_sink_error_rate", conf.sinkErrorRate},
+		{"chaos_sink_timeout_rate", conf.sinkTimeoutRate},
+		{"chaos_sink_delay_rate", conf.sinkDelayRate},
+		{"chaos_forward_error_rate", conf.forwardErrorRate},
+		{"chaos_packet_drop_rate", conf.packetDropRate},
+	} {
+		if rate.value < 0 || rate.value > 1 {
+			return nil, fmt.Errorf("%s: %v is not between 0 and 1", rate.name, rate.value)
+		}
+	}
+	c := &chaos{
+		conf:         conf,
+		sinks:        map[string]bool{},
+		roll:         rand.Float64,
+		unattributed: &[chaosFaults]int64{},
+	}
+	c.injected = map[string]*[chaosFaults]int64{"": c.unattributed}
+	for _, sink := range conf.sinks {
+		c.sinks[sink] = true
+	}
+	return c, nil
+}
+
+// unsupportedSinks returns the sinks in chaos_sinks that chaos mode
+// can't inject faults into.
+func (c *chaos) unsupportedSinks() []string {
+	var unsupported []string
+	for _, sink := range c.conf.sinks {
+		if !chaosHTTPSinks[sink] {
+			unsupported = append(unsupported, sink)
+		}
+	}
+	return unsupported
+}
+
+// counters returns the counters of the faults injected into a sink.
+func (c *chaos) counters(sink string) *[chaosFaults]int64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	counters, ok := c.injected[sink]
+	if !ok {
+		counters = &[chaosFaults]int64{}
+		c.injected[sink] = counters
+	}
+	return counters
+}
+
+// inject returns whether to inject a fault that happens at rate.
+func (c *chaos) inject(rate float64) bool {
+	return rate > 0 && c.roll() < rate
+}
+
+// dropPacket returns whether to drop a datagram that veneur received.
+func (c *chaos) dropPacket() bool {
+	if c == nil || !c.inject(c.conf.packetDropRate) {
+		return false
+	}
+	atomic.AddInt64(&c.unattributed[chaosPacketDrop], 1)
+	return true
+}
+
+// client returns a client that sends the requests of the named sink
+// like inner does, injecting faults into them.
+func (c *chaos) client(inner *http.Client, sink string) *http.Client {
+	if c == nil {
+		return inner
+	}
+	rt := &chaosRoundTripper{
+		inner:    inner.Transport,
+		chaos:    c,
+		injected: c.counters(sink),
+	}
+	if sink == chaosForwardSink {
+		rt.errorRate = c.conf.forwardErrorRate
+		rt.errorFault = chaosForwardError
+	} else if len(c.sinks) == 0 || c.sinks[sink] {
+		rt.errorRate = c.conf.sinkErrorRate
+		rt.errorFault = chaosSinkError
+		rt.timeoutRate = c.conf.sinkTimeoutRate
+		rt.delayRate = c.conf.sinkDelayRate
+	} else {
+		return inner
+	}
+	withChaos := *inner
+	withChaos.Transport = rt
+	return &withChaos
+}
+
+type chaosRoundTripper struct {
+	inner    http.RoundTripper
+	chaos    *chaos
+	injected *[chaosFaults]int64
+
+	errorRate   float64
+	errorFault  int
+	timeoutRate float64
+	delayRate   float64
+}
+
+func (rt *chaosRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	inner := rt.inner
+	if inner == nil {
+		inner = http.DefaultTransport
+	}
+	c := rt.chaos
+	switch {
+	case c.inject(rt.timeoutRate):
+		// the backend never answers:
+		atomic.AddInt64(&rt.injected[chaosSinkTimeout], 1)
+		closeBody(req)
+		<-req.Context().Done()
+		return nil, req.Context().Err()
+	case c.inject(rt.errorRate):
+		atomic.AddInt64(&rt.injected[rt.errorFault], 1)
+		closeBody(req)
+		return &http.Response{
+			Status:     fmt.Sprintf("%d %s", chaosStatus, http.StatusText(chaosStatus)),
+			StatusCode: chaosStatus,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader("veneur chaos mode injected this failure")),
+			Request:    req,
+		}, nil
+	case c.inject(rt.delayRate):
+		atomic.AddInt64(&rt.injected[chaosSinkDelay], 1)
+		timer := time.NewTimer(c.conf.sinkDelay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			closeBody(req)
+			return nil, req.Context().Err()
+		}
+	}
+	return inner.RoundTrip(req)
+}
+
+// closeBody closes the body of a request that isn't sent, as
+// RoundTrippers must.
+func closeBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+// unaryClientInterceptor returns an interceptor that fails calls
+// that forward metrics over gRPC at the forward error rate.
+func (c *chaos) unaryClientInterceptor() grpc.UnaryClientInterceptor {
+	injected := c.counters(chaosForwardSink)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if c.inject(c.conf.forwardErrorRate) {
+			atomic.AddInt64(&injected[chaosForwardError], 1)
+			return status.Error(codes.Unavailable, "veneur chaos mode injected this failure")
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// report returns counters of the faults injected since the last
+// report, tagged by fault and, for the faults injected into sinks and
+// forwarding, by sink.
+func (c *chaos) report() []*ssf.SSFSample {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	var samples []*ssf.SSFSample
+	for sink, counters := range c.injected {
+		for fault := range counters {
+			n := atomic.SwapInt64(&counters[fault], 0)
+			if n == 0 {
+				continue
+			}
+			tags := map[string]string{"fault": chaosFaultNames[fault]}
+			if sink != "" {
+				tags["sink"] = sink
+			}
+			samples = append(samples, ssf.Count("chaos.faults_injected_total", float32(n), tags))
+		}
+	}
+	return samples
+}
@@ -0,0 +1,138 @@
+package veneur
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// chaosSamples returns the faults_injected_total counts of a report,
+// keyed by fault and sink.
+func chaosSamples(c *chaos) map[string]float32 {
+	counts := map[string]float32{}
+	for _, s := range c.report() {
+		counts[s.Tags["fault"]+"/"+s.Tags["sink"]] += s.Value
+	}
+	return counts
+}
+
+func TestNewChaos(t *testing.T) {
+	_, err := newChaos(chaosConfig{sinkErrorRate: 1.5})
+	assert.Error(t, err)
+	_, err = newChaos(chaosConfig{packetDropRate: -0.1})
+	assert.Error(t, err)
+
+	c, err := newChaos(chaosConfig{sinks: []string{"datadog", "splunk", "kafka"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"splunk", "kafka"}, c.unsupportedSinks())
+
+	var nilChaos *chaos
+	assert.False(t, nilChaos.dropPacket())
+	client := &http.Client{}
+	assert.Equal(t, client, nilChaos.client(client, "datadog"))
+}
+
+func TestChaosSinkFaults(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer srv.Close()
+
+	c, err := newChaos(chaosConfig{sinks: []string{"datadog"}, sinkErrorRate: 1})
+	require.NoError(t, err)
+
+	resp, err := c.client(http.DefaultClient, "datadog").Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, 0, requests, "the failed request shouldn't reach the backend")
+
+	assert.Equal(t, http.DefaultClient, c.client(http.DefaultClient, "signalfx"),
+		"sinks that aren't listed shouldn't get faults")
+	resp, err = c.client(http.DefaultClient, "forward").Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "forwarding only gets forward errors")
+	assert.Equal(t, 1, requests)
+
+	assert.Equal(t, map[string]float32{"sink_error/datadog": 1}, chaosSamples(c))
+	assert.Empty(t, chaosSamples(c), "the counters should reset on every report")
+}
+
+func TestChaosSinkTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	c, err := newChaos(chaosConfig{sinkTimeoutRate: 1})
+	require.NoError(t, err)
+	client := c.client(&http.Client{Timeout: 50 * time.Millisecond}, "datadog")
+	start := time.Now()
+	_, err = client.Get(srv.URL)
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond, "the request should hang until it times out")
+	assert.Equal(t, map[string]float32{"sink_timeout/datadog": 1}, chaosSamples(c))
+}
+
+func TestChaosSinkDelay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	c, err := newChaos(chaosConfig{sinkDelayRate: 1, sinkDelay: 30 * time.Millisecond})
+	require.NoError(t, err)
+	start := time.Now()
+	resp, err := c.client(http.DefaultClient, "datadog").Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, time.Since(start) >= 30*time.Millisecond, "the response should be delayed")
+	assert.Equal(t, map[string]float32{"sink_delay/datadog": 1}, chaosSamples(c))
+}
+
+func TestChaosForwardErrors(t *testing.T) {
+	c, err := newChaos(chaosConfig{forwardErrorRate: 0.5})
+	require.NoError(t, err)
+	rolls := []float64{0.2, 0.7}
+	c.roll = func() float64 {
+		r := rolls[0]
+		rolls = rolls[1:]
+		return r
+	}
+
+	intercept := c.unaryClientInterceptor()
+	invoked := 0
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		invoked++
+		return nil
+	}
+	err = intercept(context.Background(), "/forwardrpc.Forward/SendMetrics", nil, nil, nil, invoker)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.NoError(t, intercept(context.Background(), "/forwardrpc.Forward/SendMetrics", nil, nil, nil, invoker))
+	assert.Equal(t, 1, invoked)
+	assert.Equal(t, map[string]float32{"forward_error/forward": 1}, chaosSamples(c))
+}
+
+func TestChaosPacketDrops(t *testing.T) {
+	c, err := newChaos(chaosConfig{packetDropRate: 1})
+	require.NoError(t, err)
+	s := &Server{chaos: c, metricMaxLength: 4096}
+	s.Workers = []*Worker{NewWorker(1, nil, logrus.New(), nil)}
+
+	s.handleMetricDatagram([]byte("a.b.c:1|c\na.b.d:1|c"), s.Workers)
+	assert.Empty(t, s.Workers[0].PacketChan)
+	assert.Equal(t, map[string]float32{"packet_drop/": 1}, chaosSamples(c))
+
+	c.conf.packetDropRate = 0
+	s.handleMetricDatagram([]byte("a.b.c:1|c\na.b.d:1|c"), s.Workers)
+	assert.Len(t, s.Workers[0].PacketChan, 2)
+	assert.Empty(t, chaosSamples(c))
+}
@@ -0,0 +1,175 @@
+package veneur
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/ssf"
+)
+
+// ntpEpochOffset is the number of seconds between the NTP epoch (1900)
+// and the unix epoch (1970).
+const ntpEpochOffset = 2208988800
+
+// clockCheckTimeout bounds how long an NTP query may take.
+const clockCheckTimeout = 5 * time.Second
+
+// clockCheck periodically measures how far the local clock is from an
+// NTP server's, so that hosts whose clocks aren't synchronized show up
+// on dashboards, and so that the timestamps veneur assigns to flushed
+// metrics can be corrected, keeping the aggregation tiers that bucket
+// metrics by time from putting them in the wrong interval.
+type clockCheck struct {
+	server     string
+	compensate bool
+	now        func() time.Time
+
+	// skew is how far the reference clock is ahead of the local
+	// clock, in nanoseconds; checked says whether it was ever
+	// measured, and errors counts the failed checks since the last
+	// report. They are all updated atomically.
+	skew    int64
+	checked int32
+	errors  int64
+}
+
+func newClockCheck(server string, compensate bool) *clockCheck {
+	return &clockCheck{server: server, compensate: compensate, now: time.Now}
+}
+
+// check measures the skew of the local clock, and keeps it for
+// compensation and reporting.
+func (c *clockCheck) check() error {
+	skew, err := c.queryNTP()
+	if err != nil {
+		atomic.AddInt64(&c.errors, 1)
+		return err
+	}
+	atomic.StoreInt64(&c.skew, int64(skew))
+	atomic.StoreInt32(&c.checked, 1)
+	return nil
+}
+
+// queryNTP sends an SNTP request (RFC 4330) to the server, and returns
+// the offset of the server's clock from the local clock.
+func (c *clockCheck) queryNTP() (time.Duration, error) {
+	conn, err := net.Dial("udp", c.server)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(clockCheckTimeout)); err != nil {
+		return 0, err
+	}
+
+	req := make([]byte, 48)
+	// leap indicator 0, version 4, mode 3 (client):
+	req[0] = 0<<6 | 4<<3 | 3
+	sent := c.now()
+	binary.BigEndian.PutUint64(req[40:], toNTPTime(sent))
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	if err != nil {
+		return 0, err
+	}
+	received := c.now()
+	if n < 48 {
+		return 0, fmt.Errorf("short NTP response of %d bytes", n)
+	}
+	if mode := resp[0] & 0x7; mode != 4 {
+		return 0, fmt.Errorf("unexpected NTP response mode %d", mode)
+	}
+	if stratum := resp[1]; stratum == 0 {
+		return 0, fmt.Errorf("NTP server %s sent a kiss-of-death", c.server)
+	}
+	if binary.BigEndian.Uint64(resp[24:]) != binary.BigEndian.Uint64(req[40:]) {
+		return 0, fmt.Errorf("NTP response doesn't match the request")
+	}
+	serverReceived := fromNTPTime(binary.BigEndian.Uint64(resp[32:]))
+	serverSent := fromNTPTime(binary.BigEndian.Uint64(resp[40:]))
+	return (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2, nil
+}
+
+func toNTPTime(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / 1e9
+	return secs<<32 | frac
+}
+
+func fromNTPTime(ts uint64) time.Time {
+	secs := int64(ts>>32) - ntpEpochOffset
+	nanos := int64((ts & 0xffffffff) * 1e9 >> 32)
+	return time.Unix(secs, nanos)
+}
+
+// measured returns the last measured skew, and whether it was ever
+// measured.
+func (c *clockCheck) measured() (time.Duration, bool) {
+	if atomic.LoadInt32(&c.checked) == 0 {
+		return 0, false
+	}
+	return time.Duration(atomic.LoadInt64(&c.skew)), true
+}
+
+// correct shifts the timestamps that the samplers assigned to flushed
+// metrics by the measured skew, rounded to the second, if compensation
+// is on.
+func (c *clockCheck) correct(metrics []samplers.InterMetric) {
+	skew, ok := c.measured()
+	if !c.compensate || !ok {
+		return
+	}
+	secs := int64(math.Round(skew.Seconds()))
+	if secs == 0 {
+		return
+	}
+	for i := range metrics {
+		metrics[i].Timestamp += secs
+	}
+}
+
+// report returns a gauge of the measured skew, and a counter of the
+// checks that failed since the last report.
+func (c *clockCheck) report() []*ssf.SSFSample {
+	var samples []*ssf.SSFSample
+	if skew, ok := c.measured(); ok {
+		samples = append(samples, ssf.Gauge("clock.skew_seconds", float32(skew.Seconds()), nil))
+	}
+	if n := atomic.SwapInt64(&c.errors, 0); n > 0 {
+		samples = append(samples, ssf.Count("clock.check_errors_total", float32(n), nil,
+			ssf.Failure("clock", ssf.CauseIOError)))
+	}
+	return samples
+}
+
+// watchClock measures the skew of the local clock every interval,
+// until the server shuts down.
+func (s *Server) watchClock(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := s.clockCheck.check(); err != nil {
+			log.WithError(err).WithField("server", s.clockCheck.server).
+				Warn("Could not check the clock against the NTP server")
+		} else if skew, _ := s.clockCheck.measured(); skew > time.Second || skew < -time.Second {
+			log.WithFields(logrus.Fields{
+				"server": s.clockCheck.server,
+				"skew":   skew,
+			}).Warn("Local clock is skewed")
+		}
+		select {
+		case <-s.shutdown:
+			return
+		case <-ticker.C:
+		}
+	}
+}
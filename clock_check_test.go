@@ -0,0 +1,92 @@
+package veneur
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+)
+
+// serveNTP answers SNTP requests on a local UDP socket with a clock
+// that's ahead of the local one by skew.
+func serveNTP(t *testing.T, skew time.Duration) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		buf := make([]byte, 48)
+		for {
+			_, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			resp := make([]byte, 48)
+			resp[0] = 4<<3 | 4
+			resp[1] = 1
+			copy(resp[24:32], buf[40:48])
+			now := toNTPTime(time.Now().Add(skew))
+			binary.BigEndian.PutUint64(resp[32:], now)
+			binary.BigEndian.PutUint64(resp[40:], now)
+			conn.WriteTo(resp, addr)
+		}
+	}()
+	return conn
+}
+
+func TestNTPTime(t *testing.T) {
+	now := time.Unix(1500000000, 250000000)
+	assert.Equal(t, now, fromNTPTime(toNTPTime(now)))
+}
+
+func TestClockCheck(t *testing.T) {
+	server := serveNTP(t, time.Hour)
+	defer server.Close()
+
+	c := newClockCheck(server.LocalAddr().String(), true)
+	_, ok := c.measured()
+	assert.False(t, ok)
+	require.NoError(t, c.check())
+	skew, ok := c.measured()
+	require.True(t, ok)
+	assert.InDelta(t, time.Hour.Seconds(), skew.Seconds(), 1)
+
+	metrics := []samplers.InterMetric{{Name: "a", Timestamp: 1500000000}}
+	c.correct(metrics)
+	assert.Equal(t, int64(1500003600), metrics[0].Timestamp)
+
+	samples := c.report()
+	require.Len(t, samples, 1)
+	assert.Equal(t, "clock.skew_seconds", samples[0].Name)
+	assert.InDelta(t, time.Hour.Seconds(), samples[0].Value, 1)
+}
+
+func TestClockCheckWithoutCompensation(t *testing.T) {
+	server := serveNTP(t, -time.Minute)
+	defer server.Close()
+
+	c := newClockCheck(server.LocalAddr().String(), false)
+	require.NoError(t, c.check())
+	metrics := []samplers.InterMetric{{Name: "a", Timestamp: 1500000000}}
+	c.correct(metrics)
+	assert.Equal(t, int64(1500000000), metrics[0].Timestamp)
+}
+
+func TestClockCheckError(t *testing.T) {
+	// a socket that never answers:
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := conn.LocalAddr().String()
+	conn.Close()
+
+	c := newClockCheck(addr, true)
+	assert.Error(t, c.check())
+	_, ok := c.measured()
+	assert.False(t, ok)
+
+	samples := c.report()
+	require.Len(t, samples, 1)
+	assert.Equal(t, "clock.check_errors_total", samples[0].Name)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
+)
+
+// batchSpanSamples is the most metrics that -batch puts on one span.
+const batchSpanSamples = 1000
+
+// batchSample parses a DogStatsD-formatted metric into an SSF sample,
+// with tags added to the metric's own.
+func batchSample(line []byte, tags map[string]string) (*ssf.SSFSample, error) {
+	metric, err := samplers.ParseMetric(line)
+	if err != nil {
+		return nil, err
+	}
+	sampleTags := make(map[string]string, len(tags)+len(metric.Tags)+1)
+	for k, v := range tags {
+		sampleTags[k] = v
+	}
+	for _, tag := range metric.Tags {
+		kv := strings.SplitN(tag, ":", 2)
+		if len(kv) == 2 {
+			sampleTags[kv[0]] = kv[1]
+		} else {
+			sampleTags[kv[0]] = ""
+		}
+	}
+	switch metric.Scope {
+	case samplers.LocalOnly:
+		sampleTags["veneurlocalonly"] = ""
+	case samplers.GlobalOnly:
+		sampleTags["veneurglobalonly"] = ""
+	}
+
+	rate := ssf.SampleRate(metric.SampleRate)
+	switch metric.Type {
+	case "counter":
+		return ssf.Count(metric.Name, float32(metric.Value.(float64)), sampleTags, rate), nil
+	case "gauge":
+		return ssf.Gauge(metric.Name, float32(metric.Value.(float64)), sampleTags, rate), nil
+	case "histogram":
+		return ssf.Histogram(metric.Name, float32(metric.Value.(float64)), sampleTags, rate), nil
+	case "timer":
+		return ssf.Histogram(metric.Name, float32(metric.Value.(float64)), sampleTags, rate, ssf.Unit("ms")), nil
+	case "set":
+		return ssf.Set(metric.Name, metric.Value.(string), sampleTags, rate), nil
+	}
+	return nil, fmt.Errorf("unsupported metric type %q", metric.Type)
+}
+
+// sendBatch reads DogStatsD-formatted metrics from in, one per line,
+// and sends them on as many spans as it takes. Lines that can't be
+// parsed are logged and skipped; if there were any, sendBatch returns
+// an error once it has sent the others.
+func sendBatch(client *trace.Client, in io.Reader, tags map[string]string) error {
+	span := &ssf.SSFSpan{}
+	invalid := 0
+	scanner := bufio.NewScanner(in)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		sample, err := batchSample(line, tags)
+		if err != nil {
+			logrus.WithError(err).WithField("line", lineno).Warn("Skipping invalid metric")
+			invalid++
+			continue
+		}
+		span.Metrics = append(span.Metrics, sample)
+		if len(span.Metrics) == batchSpanSamples {
+			if err := sendSSF(client, span); err != nil {
+				return err
+			}
+			span = &ssf.SSFSpan{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(span.Metrics) > 0 {
+		if err := sendSSF(client, span); err != nil {
+			return err
+		}
+	}
+	if invalid > 0 {
+		return fmt.Errorf("skipped %d invalid metrics", invalid)
+	}
+	return nil
+}
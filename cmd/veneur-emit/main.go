@@ -35,13 +35,15 @@ type Flags struct {
 	Command   bool
 	ExtraArgs []string
 
-	Name   string
-	Gauge  float64
-	Timing time.Duration
-	Count  int64
-	Set    string
-	Tag    string
-	ToSSF  bool
+	Name     string
+	Gauge    float64
+	Timing   time.Duration
+	Count    int64
+	Set      string
+	Tag      string
+	ToSSF    bool
+	Batch    bool
+	Compress bool
 
 	Event struct {
 		Title      string
@@ -114,6 +116,8 @@ func init() {
 			"set",
 			"tag",
 			"ssf",
+			"batch",
+			"compress",
 		},
 		EventMode: []string{
 			"e_title",
@@ -258,8 +262,22 @@ func Main(args []string) int {
 		logrus.WithError(err).Error("Error creating metrics.")
 		return 1
 	}
+	if (flagStruct.Batch || flagStruct.Compress) && !flagStruct.ToSSF {
+		logrus.Error("-batch and -compress require -ssf.")
+		return 1
+	}
 	if flagStruct.ToSSF {
-		client, err := trace.NewClient(addr)
+		var opts []trace.ClientParam
+		if flagStruct.Compress {
+			if netAddr.Network() != "unix" {
+				logrus.WithField("address", addr).
+					WithField("network", netAddr.Network()).
+					Error("hostport must be a unix:// address for compressed batches")
+				return 1
+			}
+			opts = append(opts, trace.ParallelBackends(1), trace.CompressedBatches(0))
+		}
+		client, err := trace.NewClient(addr, opts...)
 		if err != nil {
 			logrus.WithError(err).
 				WithField("address", addr).
@@ -267,10 +285,28 @@ func Main(args []string) int {
 			return 1
 		}
 		defer client.Close()
-		err = sendSSF(client, span)
-		if err != nil {
-			logrus.WithError(err).Error("Could not send SSF span")
-			return 1
+		if flagStruct.Batch {
+			err = sendBatch(client, os.Stdin, tagsFromString(flagStruct.Tag))
+			if err != nil {
+				logrus.WithError(err).Error("Could not send metrics from stdin")
+				status = 1
+			}
+		}
+		// In batch mode, the main span is only worth sending if
+		// it has anything on it.
+		if !flagStruct.Batch || len(span.Metrics) > 0 || span.TraceId != 0 {
+			err = sendSSF(client, span)
+			if err != nil {
+				logrus.WithError(err).Error("Could not send SSF span")
+				return 1
+			}
+		}
+		if flagStruct.Compress {
+			err = trace.Flush(client)
+			if err != nil {
+				logrus.WithError(err).Error("Could not send SSF spans")
+				return 1
+			}
 		}
 	} else {
 		if netAddr.Network() != "udp" {
@@ -310,6 +346,8 @@ func flags(args []string) (Flags, map[string]flag.Value, error) {
 	flagset.StringVar(&flagStruct.Set, "set", "", "Report a 'set' metric with an arbitrary string value.")
 	flagset.StringVar(&flagStruct.Tag, "tag", "", "Tag(s) for metric, comma separated. Ex: 'service:airflow'. Note: Any tags here are applied to all emitted data. See also mode-specific tag options (e.g. span_tags)")
 	flagset.BoolVar(&flagStruct.ToSSF, "ssf", false, "Sends packets via SSF instead of StatsD. (https://github.com/stripe/veneur/blob/master/ssf/)")
+	flagset.BoolVar(&flagStruct.Batch, "batch", false, "Also reads DogStatsD-formatted metrics from stdin, one per line, and sends them via SSF. Requires -ssf.")
+	flagset.BoolVar(&flagStruct.Compress, "compress", false, "Sends spans in compressed batches, which is much cheaper when sending many metrics with -batch. Requires -ssf and a unix:// hostport, and a Veneur that reads batches.")
 
 	// Event flags
 	// TODO: what should flags be called?
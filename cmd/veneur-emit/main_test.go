@@ -340,6 +340,54 @@ func TestBadSendSpan(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestBatchSample(t *testing.T) {
+	tags := map[string]string{"service": "emit", "env": "prod"}
+	sample, err := batchSample([]byte("a.b:3|c|@0.5|#env:dev,veneurlocalonly"), tags)
+	require.NoError(t, err)
+	assert.Equal(t, ssf.SSFSample_COUNTER, sample.Metric)
+	assert.Equal(t, float32(3), sample.Value)
+	assert.Equal(t, float32(0.5), sample.SampleRate)
+	assert.Equal(t, "dev", sample.Tags["env"], "the metric's tags should override -tag")
+	assert.Contains(t, sample.Tags, "veneurlocalonly")
+
+	sample, err = batchSample([]byte("a.b:12|ms"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, ssf.SSFSample_HISTOGRAM, sample.Metric)
+	assert.Equal(t, "ms", sample.Unit)
+
+	sample, err = batchSample([]byte("a.b:someone|s"), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "someone", sample.Message)
+
+	_, err = batchSample([]byte("a.b|c"), nil)
+	assert.Error(t, err)
+}
+
+func TestSendBatch(t *testing.T) {
+	ch := make(chan *ssf.SSFSpan, 3)
+	errors := make(chan error, 3)
+	be := &testBackend{t: t, ch: ch, errors: errors}
+	defer be.Close()
+	cl, err := trace.NewBackendClient(be)
+	require.NoError(t, err)
+
+	lines := make([]string, 0, batchSpanSamples+2)
+	for i := 0; i < batchSpanSamples+1; i++ {
+		lines = append(lines, fmt.Sprintf("a.b:%d|g", i))
+	}
+	lines = append(lines, "", "invalid")
+	errors <- nil
+	errors <- nil
+	err = sendBatch(cl, strings.NewReader(strings.Join(lines, "\n")), map[string]string{"service": "batch"})
+	assert.Error(t, err, "invalid lines should be reported")
+
+	first := <-ch
+	assert.Len(t, first.Metrics, batchSpanSamples)
+	assert.Equal(t, "batch", first.Metrics[0].Tags["service"])
+	second := <-ch
+	assert.Len(t, second.Metrics, 1)
+}
+
 func TestBuildEventPacketError(t *testing.T) {
 	testFlag := make(map[string]flag.Value)
 	_, err := buildEventPacket(testFlag)
@@ -61,7 +61,10 @@ func main() {
 			}
 		}
 
-		hostname, _ := os.Hostname()
+		hostname := conf.Hostname
+		if hostname == "" {
+			hostname, _ = os.Hostname()
+		}
 
 		p := raven.NewPacket(e.Error())
 		if hostname != "" {
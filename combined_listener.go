@@ -0,0 +1,151 @@
+package veneur
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/protocol"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// StartCombined starts listening on the address a for datagrams that
+// each hold either statsd metrics or an SSF span, and returns the
+// concrete address that the server is listening on. As this is a setup
+// routine, if any error occurs, it panics.
+func StartCombined(s *Server, a net.Addr, packetPool *sync.Pool) net.Addr {
+	switch addr := a.(type) {
+	case *net.UDPAddr:
+		a = startProcessingOnUDP(s, "combined", addr, packetPool, func(group int) udpProcessor {
+			workers := s.groupWorkers(group)
+			return func(sock net.PacketConn, pool *sync.Pool) {
+				s.readCombinedSocket(sock, pool, workers)
+			}
+		})
+	case *net.UnixAddr:
+		if addr.Network() != "unixgram" {
+			panic(fmt.Sprintf("Can't listen for combined traffic on %v: only udp:// and unixgram:// are supported", a))
+		}
+		a = startCombinedUnixgram(s, addr, packetPool)
+	default:
+		panic(fmt.Sprintf("Can't listen for combined traffic on %v: only udp:// and unixgram:// are supported", a))
+	}
+	log.WithFields(logrus.Fields{
+		"address": a.String(),
+		"network": a.Network(),
+	}).Info("Listening for statsd metrics and SSF traces")
+	return a
+}
+
+// startCombinedUnixgram listens for datagrams on a UNIX domain socket
+// until the server shuts down.
+func startCombinedUnixgram(s *Server, addr *net.UnixAddr, packetPool *sync.Pool) net.Addr {
+	// clear away any old socket:
+	_ = os.Remove(addr.String())
+	conn, err := net.ListenUnixgram(addr.Network(), addr)
+	if err != nil {
+		panic(fmt.Sprintf("Couldn't listen on UNIX socket %v: %v", addr, err))
+	}
+	// Make the socket writable by everyone with access to the socket pathname:
+	if err := os.Chmod(addr.String(), 0666); err != nil {
+		panic(fmt.Sprintf("Couldn't set permissions on %v: %v", addr, err))
+	}
+	go func() {
+		<-s.shutdown
+		conn.Close()
+	}()
+	go func() {
+		defer func() {
+			ConsumePanic(s.Sentry, s.TraceClient, s.Hostname, recover())
+		}()
+		s.readCombinedSocket(conn, packetPool, s.Workers)
+	}()
+	return conn.LocalAddr()
+}
+
+// readCombinedSocket reads datagrams off a packet connection, and
+// handles each of them as statsd metrics or as an SSF span, whichever
+// it holds.
+func (s *Server) readCombinedSocket(serverConn net.PacketConn, packetPool *sync.Pool, workers []*Worker) {
+	capture := s.packetCaptureFor(serverConn.LocalAddr())
+	mirror := s.trafficMirrorFor(serverConn.LocalAddr())
+	for {
+		buf := packetPool.Get().([]byte)
+		n, _, err := serverConn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-s.shutdown:
+				log.WithError(err).Info("Ignoring ReadFrom error while shutting down")
+				return
+			default:
+				log.WithError(err).Error("Error reading from combined socket")
+				continue
+			}
+		}
+		atomic.AddInt64(&s.receivedBytes, int64(n))
+		capture.capture(buf[:n])
+		mirror.mirror(buf[:n])
+		s.handleCombinedDatagram(buf[:n], workers)
+		packetPool.Put(buf)
+	}
+}
+
+// handleCombinedDatagram detects whether a datagram holds statsd
+// metrics or an SSF span, and handles it accordingly. Datagrams that
+// hold neither are dropped.
+func (s *Server) handleCombinedDatagram(datagram []byte, workers []*Worker) {
+	if looksLikeStatsd(datagram) {
+		s.handleMetricDatagram(datagram, workers)
+		return
+	}
+	if len(datagram) > s.traceMaxLengthBytes {
+		s.reportTooLongSpan()
+		return
+	}
+	span, err := protocol.ParseSSF(datagram)
+	if err == nil && !isEmptySpan(span) {
+		s.Statsd.Histogram("ssf.packet_size", float64(len(datagram)), nil, .1)
+		s.handleTraceSpan(span)
+		return
+	}
+	metrics.ReportOne(s.TraceClient, ssf.Count("packet.error_total", 1,
+		map[string]string{"packet_type": "unknown", "reason": "undetectable"},
+		ssf.Failure("combined", ssf.CauseParseError)))
+}
+
+// looksLikeStatsd returns whether the first line of a datagram is a
+// statsd metric, event or service check: printable text, holding the
+// ':' and '|' separators of a metric or starting like an event or a
+// service check does. Encoded SSF spans start with a field key or
+// length below 0x20 in all but contrived cases, so they don't look
+// like statsd.
+func looksLikeStatsd(datagram []byte) bool {
+	line := datagram
+	if i := bytes.IndexByte(datagram, '\n'); i >= 0 {
+		line = datagram[:i]
+	}
+	if len(line) == 0 {
+		return false
+	}
+	for _, b := range line {
+		if (b < 0x20 && b != '\t') || b == 0x7f {
+			return false
+		}
+	}
+	if bytes.HasPrefix(line, []byte("_e{")) || bytes.HasPrefix(line, []byte("_sc|")) {
+		return true
+	}
+	colon := bytes.IndexByte(line, ':')
+	return colon > 0 && bytes.IndexByte(line[colon:], '|') > 0
+}
+
+// isEmptySpan returns whether an SSF span holds nothing that veneur
+// could process. Arbitrary bytes can happen to decode as such a span.
+func isEmptySpan(span *ssf.SSFSpan) bool {
+	return span.Id == 0 && span.TraceId == 0 && len(span.Metrics) == 0
+}
@@ -0,0 +1,89 @@
+package veneur
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/ssf"
+)
+
+func TestLooksLikeStatsd(t *testing.T) {
+	span := &ssf.SSFSpan{
+		Id:      1,
+		TraceId: 1,
+		Service: "a:b|c",
+		Metrics: []*ssf.SSFSample{ssf.Count("foo.bar", 1, nil)},
+	}
+	encoded, err := proto.Marshal(span)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		datagram []byte
+		statsd   bool
+	}{
+		{"counter", []byte("foo.bar:1|c|#baz:gorch"), true},
+		{"multiple lines", []byte("foo.bar:1|c\nfoo.baz:2|g"), true},
+		{"event", []byte("_e{5,4}:title|text"), true},
+		{"service check", []byte("_sc|check|0"), true},
+		{"tab in tags", []byte("foo.bar:1|c|#baz:a\tb"), true},
+		{"no separators", []byte("foo.bar"), false},
+		{"pipe before colon", []byte("foo|bar:1"), false},
+		{"empty", []byte{}, false},
+		{"leading newline", []byte("\nfoo.bar:1|c"), false},
+		{"control bytes", []byte("foo.bar:1|c\x00"), false},
+		{"ssf span", encoded, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.statsd, looksLikeStatsd(tt.datagram))
+		})
+	}
+}
+
+func TestCombinedUDPListener(t *testing.T) {
+	config := localConfig()
+	config.NumWorkers = 1
+	config.Interval = "60s"
+	config.CombinedListenAddresses = []string{"udp://127.0.0.1:0"}
+	ch := make(chan []samplers.InterMetric, 20)
+	sink, _ := NewChannelMetricSink(ch)
+	f := newFixture(t, config, sink, nil)
+	defer f.Close()
+
+	addr := f.server.CombinedListenAddrs[0]
+	conn := connectToAddress(t, "udp", addr.String(), 20*time.Millisecond)
+	defer conn.Close()
+
+	span := &ssf.SSFSpan{
+		Metrics: []*ssf.SSFSample{ssf.Count("ssf.metric", 1, nil)},
+	}
+	packet, err := proto.Marshal(span)
+	require.NoError(t, err)
+	conn.Write([]byte("statsd.metric:1|c"))
+	conn.Write(packet)
+	// garbage is dropped rather than handled as either protocol:
+	conn.Write([]byte{0xff, 0xff, 0xff})
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 2*time.Second)
+	defer cancel()
+	keepFlushing(ctx, f.server)
+
+	seen := map[string]bool{}
+	for len(seen) < 2 {
+		select {
+		case metrics := <-ch:
+			for _, m := range metrics {
+				seen[m.Name] = true
+			}
+		case <-ctx.Done():
+			t.Fatalf("timed out waiting for metrics, got %v", seen)
+		}
+	}
+	assert.Equal(t, map[string]bool{"statsd.metric": true, "ssf.metric": true}, seen)
+}
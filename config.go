@@ -1,98 +1,288 @@
 package veneur
 
+import "github.com/stripe/veneur/sinks/retry"
+
 type Config struct {
-	Aggregates                    []string  `yaml:"aggregates"`
-	AwsAccessKeyID                string    `yaml:"aws_access_key_id"`
-	AwsRegion                     string    `yaml:"aws_region"`
-	AwsS3Bucket                   string    `yaml:"aws_s3_bucket"`
-	AwsSecretAccessKey            string    `yaml:"aws_secret_access_key"`
-	BlockProfileRate              int       `yaml:"block_profile_rate"`
-	DatadogAPIHostname            string    `yaml:"datadog_api_hostname"`
-	DatadogAPIKey                 string    `yaml:"datadog_api_key"`
-	DatadogFlushMaxPerBody        int       `yaml:"datadog_flush_max_per_body"`
-	DatadogSpanBufferSize         int       `yaml:"datadog_span_buffer_size"`
-	DatadogTraceAPIAddress        string    `yaml:"datadog_trace_api_address"`
-	Debug                         bool      `yaml:"debug"`
-	DebugFlushedMetrics           bool      `yaml:"debug_flushed_metrics"`
-	DebugIngestedSpans            bool      `yaml:"debug_ingested_spans"`
-	EnableProfiling               bool      `yaml:"enable_profiling"`
-	FalconerAddress               string    `yaml:"falconer_address"`
-	FlushFile                     string    `yaml:"flush_file"`
-	FlushMaxPerBody               int       `yaml:"flush_max_per_body"`
-	ForwardAddress                string    `yaml:"forward_address"`
-	ForwardUseGrpc                bool      `yaml:"forward_use_grpc"`
-	GrpcAddress                   string    `yaml:"grpc_address"`
-	Hostname                      string    `yaml:"hostname"`
-	HTTPAddress                   string    `yaml:"http_address"`
-	IndicatorSpanTimerName        string    `yaml:"indicator_span_timer_name"`
-	Interval                      string    `yaml:"interval"`
-	KafkaBroker                   string    `yaml:"kafka_broker"`
-	KafkaCheckTopic               string    `yaml:"kafka_check_topic"`
-	KafkaEventTopic               string    `yaml:"kafka_event_topic"`
-	KafkaMetricBufferBytes        int       `yaml:"kafka_metric_buffer_bytes"`
-	KafkaMetricBufferFrequency    string    `yaml:"kafka_metric_buffer_frequency"`
-	KafkaMetricBufferMessages     int       `yaml:"kafka_metric_buffer_messages"`
-	KafkaMetricRequireAcks        string    `yaml:"kafka_metric_require_acks"`
-	KafkaMetricTopic              string    `yaml:"kafka_metric_topic"`
-	KafkaPartitioner              string    `yaml:"kafka_partitioner"`
-	KafkaRetryMax                 int       `yaml:"kafka_retry_max"`
-	KafkaSpanBufferBytes          int       `yaml:"kafka_span_buffer_bytes"`
-	KafkaSpanBufferFrequency      string    `yaml:"kafka_span_buffer_frequency"`
-	KafkaSpanBufferMesages        int       `yaml:"kafka_span_buffer_mesages"`
-	KafkaSpanRequireAcks          string    `yaml:"kafka_span_require_acks"`
-	KafkaSpanSampleRatePercent    int       `yaml:"kafka_span_sample_rate_percent"`
-	KafkaSpanSampleTag            string    `yaml:"kafka_span_sample_tag"`
-	KafkaSpanSerializationFormat  string    `yaml:"kafka_span_serialization_format"`
-	KafkaSpanTopic                string    `yaml:"kafka_span_topic"`
-	LightstepAccessToken          string    `yaml:"lightstep_access_token"`
-	LightstepCollectorHost        string    `yaml:"lightstep_collector_host"`
-	LightstepMaximumSpans         int       `yaml:"lightstep_maximum_spans"`
-	LightstepNumClients           int       `yaml:"lightstep_num_clients"`
-	LightstepReconnectPeriod      string    `yaml:"lightstep_reconnect_period"`
-	MetricMaxLength               int       `yaml:"metric_max_length"`
-	MutexProfileFraction          int       `yaml:"mutex_profile_fraction"`
-	NumReaders                    int       `yaml:"num_readers"`
-	NumSpanWorkers                int       `yaml:"num_span_workers"`
-	NumWorkers                    int       `yaml:"num_workers"`
-	OmitEmptyHostname             bool      `yaml:"omit_empty_hostname"`
-	Percentiles                   []float64 `yaml:"percentiles"`
-	ReadBufferSizeBytes           int       `yaml:"read_buffer_size_bytes"`
-	SentryDsn                     string    `yaml:"sentry_dsn"`
-	SignalfxAPIKey                string    `yaml:"signalfx_api_key"`
-	SignalfxEndpointBase          string    `yaml:"signalfx_endpoint_base"`
-	SignalfxHostnameTag           string    `yaml:"signalfx_hostname_tag"`
-	SignalfxMetricNamePrefixDrops []string  `yaml:"signalfx_metric_name_prefix_drops"`
-	SignalfxMetricTagPrefixDrops  []string  `yaml:"signalfx_metric_tag_prefix_drops"`
+	AccountingCheckEnabled bool     `yaml:"accounting_check_enabled"`
+	Aggregates             []string `yaml:"aggregates"`
+	ApdexMetricName        string   `yaml:"apdex_metric_name"`
+	ApdexThresholds        []struct {
+		Operation string `yaml:"operation"`
+		Service   string `yaml:"service"`
+		Threshold string `yaml:"threshold"`
+	} `yaml:"apdex_thresholds"`
+	AwsAccessKeyID                 string   `yaml:"aws_access_key_id"`
+	AwsRegion                      string   `yaml:"aws_region"`
+	AwsS3Bucket                    string   `yaml:"aws_s3_bucket"`
+	AwsS3RollupInterval            string   `yaml:"aws_s3_rollup_interval"`
+	AwsSecretAccessKey             string   `yaml:"aws_secret_access_key"`
+	AutoscalingCapacityPerSecond   int      `yaml:"autoscaling_capacity_per_second"`
+	BlockProfileRate               int      `yaml:"block_profile_rate"`
+	CanaryForward                  bool     `yaml:"canary_forward"`
+	CanaryInterval                 string   `yaml:"canary_interval"`
+	ChaosEnabled                   bool     `yaml:"chaos_enabled"`
+	ChaosForwardErrorRate          float64  `yaml:"chaos_forward_error_rate"`
+	ChaosPacketDropRate            float64  `yaml:"chaos_packet_drop_rate"`
+	ChaosSinkDelay                 string   `yaml:"chaos_sink_delay"`
+	ChaosSinkDelayRate             float64  `yaml:"chaos_sink_delay_rate"`
+	ChaosSinkErrorRate             float64  `yaml:"chaos_sink_error_rate"`
+	ChaosSinkTimeoutRate           float64  `yaml:"chaos_sink_timeout_rate"`
+	ChaosSinks                     []string `yaml:"chaos_sinks"`
+	CPUAffinityGroups              []string `yaml:"cpu_affinity_groups"`
+	ClockCheckInterval             string   `yaml:"clock_check_interval"`
+	ClockCheckNtpServer            string   `yaml:"clock_check_ntp_server"`
+	ClockSkewCompensation          bool     `yaml:"clock_skew_compensation"`
+	CombinedListenAddresses        []string `yaml:"combined_listen_addresses"`
+	CompactDuplicateMetrics        bool     `yaml:"compact_duplicate_metrics"`
+	CounterRateMetricPrefixes      []string `yaml:"counter_rate_metric_prefixes"`
+	CounterSampleSummaries         bool     `yaml:"counter_sample_summaries"`
+	DatadogAPIHostname             string   `yaml:"datadog_api_hostname"`
+	DatadogAPIKey                  string   `yaml:"datadog_api_key"`
+	DatadogAPIKeySecondary         string   `yaml:"datadog_api_key_secondary"`
+	DatadogApplicationKey          string   `yaml:"datadog_application_key"`
+	DatadogDistributions           bool     `yaml:"datadog_distributions"`
+	DatadogFlushMaxPerBody         int      `yaml:"datadog_flush_max_per_body"`
+	DatadogSite                    string   `yaml:"datadog_site"`
+	DatadogSpanBufferSize          int      `yaml:"datadog_span_buffer_size"`
+	DatadogTraceAPIAddress         string   `yaml:"datadog_trace_api_address"`
+	Debug                          bool     `yaml:"debug"`
+	DebugFlushedMetrics            bool     `yaml:"debug_flushed_metrics"`
+	DebugIngestedSpans             bool     `yaml:"debug_ingested_spans"`
+	DeterministicOutput            bool     `yaml:"deterministic_output"`
+	EnableProfiling                bool     `yaml:"enable_profiling"`
+	FalconerAddress                string   `yaml:"falconer_address"`
+	FlushFile                      string   `yaml:"flush_file"`
+	FlushFileRollupInterval        string   `yaml:"flush_file_rollup_interval"`
+	FlushJitter                    float64  `yaml:"flush_jitter"`
+	FlushMaxPerBody                int      `yaml:"flush_max_per_body"`
+	ForwardAddress                 string   `yaml:"forward_address"`
+	ForwardGrpcCompression         string   `yaml:"forward_grpc_compression"`
+	ForwardGrpcMaxSendMsgSize      int      `yaml:"forward_grpc_max_send_msg_size"`
+	ForwardReporterID              string   `yaml:"forward_reporter_id"`
+	ForwardUseGrpc                 bool     `yaml:"forward_use_grpc"`
+	GrpcAddress                    string   `yaml:"grpc_address"`
+	GrpcMaxRecvMsgSize             int      `yaml:"grpc_max_recv_msg_size"`
+	HistogramCompression           float64  `yaml:"histogram_compression"`
+	HostRollupMetricPrefixes       []string `yaml:"host_rollup_metric_prefixes"`
+	HostRollupTag                  string   `yaml:"host_rollup_tag"`
+	Hostname                       string   `yaml:"hostname"`
+	HostnameCloudMetadata          string   `yaml:"hostname_cloud_metadata"`
+	HostnameEnvVar                 string   `yaml:"hostname_env_var"`
+	HostnameFqdn                   bool     `yaml:"hostname_fqdn"`
+	HostnameStripDomain            bool     `yaml:"hostname_strip_domain"`
+	HTTPAddress                    string   `yaml:"http_address"`
+	ImportDedupWindow              string   `yaml:"import_dedup_window"`
+	ImportMaxBodyBytes             int      `yaml:"import_max_body_bytes"`
+	ImportMaxDecompressedBytes     int      `yaml:"import_max_decompressed_bytes"`
+	ImportMaxMetrics               int      `yaml:"import_max_metrics"`
+	ImportReporterExpiry           string   `yaml:"import_reporter_expiry"`
+	IndicatorSpanTimerName         string   `yaml:"indicator_span_timer_name"`
+	Interval                       string   `yaml:"interval"`
+	KafkaBroker                    string   `yaml:"kafka_broker"`
+	KafkaCheckTopic                string   `yaml:"kafka_check_topic"`
+	KafkaEventTopic                string   `yaml:"kafka_event_topic"`
+	KafkaIdempotent                bool     `yaml:"kafka_idempotent"`
+	KafkaMetricBufferBytes         int      `yaml:"kafka_metric_buffer_bytes"`
+	KafkaMetricBufferFrequency     string   `yaml:"kafka_metric_buffer_frequency"`
+	KafkaMetricBufferMessages      int      `yaml:"kafka_metric_buffer_messages"`
+	KafkaMetricRequireAcks         string   `yaml:"kafka_metric_require_acks"`
+	KafkaMetricTopic               string   `yaml:"kafka_metric_topic"`
+	KafkaPartitioner               string   `yaml:"kafka_partitioner"`
+	KafkaRetryMax                  int      `yaml:"kafka_retry_max"`
+	KafkaSpanBufferBytes           int      `yaml:"kafka_span_buffer_bytes"`
+	KafkaSpanBufferFrequency       string   `yaml:"kafka_span_buffer_frequency"`
+	KafkaSpanBufferMesages         int      `yaml:"kafka_span_buffer_mesages"`
+	KafkaSpanRequireAcks           string   `yaml:"kafka_span_require_acks"`
+	KafkaSpanSampleRatePercent     int      `yaml:"kafka_span_sample_rate_percent"`
+	KafkaSpanSampleTag             string   `yaml:"kafka_span_sample_tag"`
+	KafkaSpanSerializationFormat   string   `yaml:"kafka_span_serialization_format"`
+	KafkaSpanTopic                 string   `yaml:"kafka_span_topic"`
+	LeaderElectionBackend          string   `yaml:"leader_election_backend"`
+	LeaderElectionKey              string   `yaml:"leader_election_key"`
+	LeaderElectionLeaseDuration    string   `yaml:"leader_election_lease_duration"`
+	LightstepAccessToken           string   `yaml:"lightstep_access_token"`
+	LightstepCollectorHost         string   `yaml:"lightstep_collector_host"`
+	LightstepMaximumSpans          int      `yaml:"lightstep_maximum_spans"`
+	LightstepNumClients            int      `yaml:"lightstep_num_clients"`
+	LightstepReconnectPeriod       string   `yaml:"lightstep_reconnect_period"`
+	LokiAddress                    string   `yaml:"loki_address"`
+	LokiBatchSize                  int      `yaml:"loki_batch_size"`
+	LokiLabelTags                  []string `yaml:"loki_label_tags"`
+	LokiSpanBufferSize             int      `yaml:"loki_span_buffer_size"`
+	LokiTenant                     string   `yaml:"loki_tenant"`
+	MetricBlocklistEnabled         bool     `yaml:"metric_blocklist_enabled"`
+	MetricBlocklistFile            string   `yaml:"metric_blocklist_file"`
+	MetricBlocklistRefreshInterval string   `yaml:"metric_blocklist_refresh_interval"`
+	MetricExpressions              []struct {
+		Expression string `yaml:"expression"`
+		Name       string `yaml:"name"`
+	} `yaml:"metric_expressions"`
+	MetricMaxLength      int `yaml:"metric_max_length"`
+	MetricMaxPerDatagram int `yaml:"metric_max_per_datagram"`
+	MetricPriorities     []struct {
+		NamePrefix string `yaml:"name_prefix"`
+		Priority   string `yaml:"priority"`
+		Tag        string `yaml:"tag"`
+	} `yaml:"metric_priorities"`
+	MetricPriorityShedThreshold float64 `yaml:"metric_priority_shed_threshold"`
+	MetricSchemaMode            string  `yaml:"metric_schema_mode"`
+	MetricSchemaRefreshInterval string  `yaml:"metric_schema_refresh_interval"`
+	MetricSchemaSource          string  `yaml:"metric_schema_source"`
+	MetricScopes                []struct {
+		NamePrefix string `yaml:"name_prefix"`
+		Scope      string `yaml:"scope"`
+		Tag        string `yaml:"tag"`
+		Type       string `yaml:"type"`
+	} `yaml:"metric_scopes"`
+	MutexProfileFraction               int               `yaml:"mutex_profile_fraction"`
+	NumReaders                         int               `yaml:"num_readers"`
+	NumSpanWorkers                     int               `yaml:"num_span_workers"`
+	NumWorkers                         int               `yaml:"num_workers"`
+	OmitEmptyHostname                  bool              `yaml:"omit_empty_hostname"`
+	PacketCaptureEnabled               bool              `yaml:"packet_capture_enabled"`
+	PacketCaptureMaxPackets            int               `yaml:"packet_capture_max_packets"`
+	Percentiles                        []float64         `yaml:"percentiles"`
+	PreAggregatedTag                   string            `yaml:"preaggregated_tag"`
+	PrometheusRemoteWriteAddress       string            `yaml:"prometheus_remote_write_address"`
+	PrometheusRemoteWriteBatchSize     int               `yaml:"prometheus_remote_write_batch_size"`
+	PrometheusRemoteWriteHostnameLabel string            `yaml:"prometheus_remote_write_hostname_label"`
+	PrometheusRemoteWriteTenant        string            `yaml:"prometheus_remote_write_tenant"`
+	PrometheusRemoteWriteTenantTag     string            `yaml:"prometheus_remote_write_tenant_tag"`
+	ReadBufferSizeBytes                int               `yaml:"read_buffer_size_bytes"`
+	SamplerSnapshotInterval            string            `yaml:"sampler_snapshot_interval"`
+	SamplerSnapshotPath                string            `yaml:"sampler_snapshot_path"`
+	SentryDsn                          string            `yaml:"sentry_dsn"`
+	ServiceMapEnabled                  bool              `yaml:"service_map_enabled"`
+	ShutdownOrder                      []string          `yaml:"shutdown_order"`
+	ShutdownTimeouts                   map[string]string `yaml:"shutdown_timeouts"`
+	SignalfxAPIEndpoint                string            `yaml:"signalfx_api_endpoint"`
+	SignalfxAPIKey                     string            `yaml:"signalfx_api_key"`
+	SignalfxAPIKeySecondary            string            `yaml:"signalfx_api_key_secondary"`
+	SignalfxDimensionRules             []struct {
+		Dimension                string            `yaml:"dimension"`
+		Properties               map[string]string `yaml:"properties"`
+		PropertiesFromDimensions []string          `yaml:"properties_from_dimensions"`
+		Tags                     []string          `yaml:"tags"`
+		ValuePrefix              string            `yaml:"value_prefix"`
+	} `yaml:"signalfx_dimension_rules"`
+	SignalfxEndpointBase          string   `yaml:"signalfx_endpoint_base"`
+	SignalfxHostnameTag           string   `yaml:"signalfx_hostname_tag"`
+	SignalfxMetricNamePrefixDrops []string `yaml:"signalfx_metric_name_prefix_drops"`
+	SignalfxMetricTagPrefixDrops  []string `yaml:"signalfx_metric_tag_prefix_drops"`
 	SignalfxPerTagAPIKeys         []struct {
 		APIKey string `yaml:"api_key"`
 		Name   string `yaml:"name"`
 	} `yaml:"signalfx_per_tag_api_keys"`
-	SignalfxVaryKeyBy                 string   `yaml:"signalfx_vary_key_by"`
-	SpanChannelCapacity               int      `yaml:"span_channel_capacity"`
-	SplunkHecAddress                  string   `yaml:"splunk_hec_address"`
-	SplunkHecBatchSize                int      `yaml:"splunk_hec_batch_size"`
-	SplunkHecConnectionLifetimeJitter string   `yaml:"splunk_hec_connection_lifetime_jitter"`
-	SplunkHecIngestTimeout            string   `yaml:"splunk_hec_ingest_timeout"`
-	SplunkHecMaxConnectionLifetime    string   `yaml:"splunk_hec_max_connection_lifetime"`
-	SplunkHecSendTimeout              string   `yaml:"splunk_hec_send_timeout"`
-	SplunkHecSubmissionWorkers        int      `yaml:"splunk_hec_submission_workers"`
-	SplunkHecTLSValidateHostname      string   `yaml:"splunk_hec_tls_validate_hostname"`
-	SplunkHecToken                    string   `yaml:"splunk_hec_token"`
-	SplunkSpanSampleRate              int      `yaml:"splunk_span_sample_rate"`
-	SsfBufferSize                     int      `yaml:"ssf_buffer_size"`
-	SsfListenAddresses                []string `yaml:"ssf_listen_addresses"`
-	StatsAddress                      string   `yaml:"stats_address"`
-	StatsdListenAddresses             []string `yaml:"statsd_listen_addresses"`
-	SynchronizeWithInterval           bool     `yaml:"synchronize_with_interval"`
-	Tags                              []string `yaml:"tags"`
-	TagsExclude                       []string `yaml:"tags_exclude"`
-	TLSAuthorityCertificate           string   `yaml:"tls_authority_certificate"`
-	TLSCertificate                    string   `yaml:"tls_certificate"`
-	TLSKey                            string   `yaml:"tls_key"`
-	TraceLightstepAccessToken         string   `yaml:"trace_lightstep_access_token"`
-	TraceLightstepCollectorHost       string   `yaml:"trace_lightstep_collector_host"`
-	TraceLightstepMaximumSpans        int      `yaml:"trace_lightstep_maximum_spans"`
-	TraceLightstepNumClients          int      `yaml:"trace_lightstep_num_clients"`
-	TraceLightstepReconnectPeriod     string   `yaml:"trace_lightstep_reconnect_period"`
-	TraceMaxLengthBytes               int      `yaml:"trace_max_length_bytes"`
+	SignalfxUnitDimension                   string                        `yaml:"signalfx_unit_dimension"`
+	SignalfxVaryKeyBy                       string                        `yaml:"signalfx_vary_key_by"`
+	SinkMigrationFrom                       string                        `yaml:"sink_migration_from"`
+	SinkMigrationTo                         string                        `yaml:"sink_migration_to"`
+	SinkMigrationTolerance                  float64                       `yaml:"sink_migration_tolerance"`
+	SinkPauseBufferSize                     int                           `yaml:"sink_pause_buffer_size"`
+	SinkPauseEnabled                        bool                          `yaml:"sink_pause_enabled"`
+	SinkPausePolicy                         string                        `yaml:"sink_pause_policy"`
+	SinkRetryPolicies                       map[string]retry.PolicyConfig `yaml:"sink_retry_policies"`
+	SpanBlocklistEnabled                    bool                          `yaml:"span_blocklist_enabled"`
+	SpanBlocklistFile                       string                        `yaml:"span_blocklist_file"`
+	SpanBlocklistRefreshInterval            string                        `yaml:"span_blocklist_refresh_interval"`
+	SpanChannelCapacity                     int                           `yaml:"span_channel_capacity"`
+	SpanDurationServices                    []string                      `yaml:"span_duration_services"`
+	SpanDurationTimerName                   string                        `yaml:"span_duration_timer_name"`
+	SpanMaxNameLength                       int                           `yaml:"span_max_name_length"`
+	SpanMaxTagValueLength                   int                           `yaml:"span_max_tag_value_length"`
+	SpanMaxTags                             int                           `yaml:"span_max_tags"`
+	SpanSinkQueueSize                       int                           `yaml:"span_sink_queue_size"`
+	SpanSinkQueueWorkers                    int                           `yaml:"span_sink_queue_workers"`
+	SplunkHecAckTimeout                     string                        `yaml:"splunk_hec_ack_timeout"`
+	SplunkHecAddress                        string                        `yaml:"splunk_hec_address"`
+	SplunkHecAddresses                      []string                      `yaml:"splunk_hec_addresses"`
+	SplunkHecBatchSize                      int                           `yaml:"splunk_hec_batch_size"`
+	SplunkHecConnectionLifetimeJitter       string                        `yaml:"splunk_hec_connection_lifetime_jitter"`
+	SplunkHecGzip                           bool                          `yaml:"splunk_hec_gzip"`
+	SplunkHecHealthCheck                    bool                          `yaml:"splunk_hec_health_check"`
+	SplunkHecHealthCheckWarnOnly            bool                          `yaml:"splunk_hec_health_check_warn_only"`
+	SplunkHecIndexes                        map[string]string             `yaml:"splunk_hec_indexes"`
+	SplunkHecIndexTag                       string                        `yaml:"splunk_hec_index_tag"`
+	SplunkHecIngestTimeout                  string                        `yaml:"splunk_hec_ingest_timeout"`
+	SplunkHecKeepaliveInterval              string                        `yaml:"splunk_hec_keepalive_interval"`
+	SplunkHecMaxBatchAge                    string                        `yaml:"splunk_hec_max_batch_age"`
+	SplunkHecMaxBatchBytes                  int                           `yaml:"splunk_hec_max_batch_bytes"`
+	SplunkHecMaxSubmissionWorkers           int                           `yaml:"splunk_hec_max_submission_workers"`
+	SplunkHecMaxConnectionLifetime          string                        `yaml:"splunk_hec_max_connection_lifetime"`
+	SplunkHecMetricsBatchSize               int                           `yaml:"splunk_hec_metrics_batch_size"`
+	SplunkHecMetricsEnabled                 bool                          `yaml:"splunk_hec_metrics_enabled"`
+	SplunkHecMetricsIndex                   string                        `yaml:"splunk_hec_metrics_index"`
+	SplunkHecRaw                            bool                          `yaml:"splunk_hec_raw"`
+	SplunkHecRawSourcetype                  string                        `yaml:"splunk_hec_raw_sourcetype"`
+	SplunkHecRetryBufferBytes               int                           `yaml:"splunk_hec_retry_buffer_bytes"`
+	SplunkHecSendTimeout                    string                        `yaml:"splunk_hec_send_timeout"`
+	SplunkHecSpillDir                       string                        `yaml:"splunk_hec_spill_dir"`
+	SplunkHecSpillMaxBytes                  int                           `yaml:"splunk_hec_spill_max_bytes"`
+	SplunkHecSubmissionWorkers              int                           `yaml:"splunk_hec_submission_workers"`
+	SplunkHecSubmissionWorkersScaleInterval string                        `yaml:"splunk_hec_submission_workers_scale_interval"`
+	SplunkHecTLSAuthorityCertificate        string                        `yaml:"splunk_hec_tls_authority_certificate"`
+	SplunkHecTLSCertificate                 string                        `yaml:"splunk_hec_tls_certificate"`
+	SplunkHecTLSKey                         string                        `yaml:"splunk_hec_tls_key"`
+	SplunkHecTLSMinVersion                  string                        `yaml:"splunk_hec_tls_min_version"`
+	SplunkHecTLSValidateHostname            string                        `yaml:"splunk_hec_tls_validate_hostname"`
+	SplunkHecToken                          string                        `yaml:"splunk_hec_token"`
+	SplunkHecTokenFile                      string                        `yaml:"splunk_hec_token_file"`
+	SplunkHecTokenFileRefreshInterval       string                        `yaml:"splunk_hec_token_file_refresh_interval"`
+	SplunkHecTokenSecondary                 string                        `yaml:"splunk_hec_token_secondary"`
+	SplunkSpanSampleAlwaysKeep              []string                      `yaml:"splunk_span_sample_always_keep"`
+	SplunkSpanSampleDecisionTag             string                        `yaml:"splunk_span_sample_decision_tag"`
+	SplunkSpanSampleRate                    int                           `yaml:"splunk_span_sample_rate"`
+	SplunkSpanSampleRateOverrides           []struct {
+		Name       string `yaml:"name"`
+		SampleRate int    `yaml:"sample_rate"`
+		Service    string `yaml:"service"`
+	} `yaml:"splunk_span_sample_rate_overrides"`
+	SplunkSpanSourceTemplate      string   `yaml:"splunk_span_source_template"`
+	SplunkSpanSourcetypeTemplate  string   `yaml:"splunk_span_sourcetype_template"`
+	SplunkSpanTagAllowlist        []string `yaml:"splunk_span_tag_allowlist"`
+	SplunkSpanTagDenylist         []string `yaml:"splunk_span_tag_denylist"`
+	SsfBufferSize                 int      `yaml:"ssf_buffer_size"`
+	SsfDedupWindow                string   `yaml:"ssf_dedup_window"`
+	SsfListenAddresses            []string `yaml:"ssf_listen_addresses"`
+	StatsAddress                  string   `yaml:"stats_address"`
+	StatsdListenAddresses         []string `yaml:"statsd_listen_addresses"`
+	StatsdRepeaterAddress         string   `yaml:"statsd_repeater_address"`
+	StatsdRepeaterMaxDatagramSize int      `yaml:"statsd_repeater_max_datagram_size"`
+	StatsdRepeaterMetrics         []string `yaml:"statsd_repeater_metrics"`
+	StatsdXdpInterface            string   `yaml:"statsd_xdp_interface"`
+	StatsdXdpQueues               int      `yaml:"statsd_xdp_queues"`
+	SynchronizeWithInterval       bool     `yaml:"synchronize_with_interval"`
+	Tags                          []string `yaml:"tags"`
+	TagsExclude                   []string `yaml:"tags_exclude"`
+	TempoAddress                  string   `yaml:"tempo_address"`
+	TempoPerServiceTenants        []struct {
+		Service string `yaml:"service"`
+		Tenant  string `yaml:"tenant"`
+	} `yaml:"tempo_per_service_tenants"`
+	TempoSpanBufferSize           int    `yaml:"tempo_span_buffer_size"`
+	TempoTenant                   string `yaml:"tempo_tenant"`
+	TimestampMaxAge               string `yaml:"timestamp_max_age"`
+	TimestampMaxFuture            string `yaml:"timestamp_max_future"`
+	TimestampRejectSkewed         bool   `yaml:"timestamp_reject_skewed"`
+	TLSAuthorityCertificate       string `yaml:"tls_authority_certificate"`
+	TLSCertificate                string `yaml:"tls_certificate"`
+	TLSKey                        string `yaml:"tls_key"`
+	TraceLightstepAccessToken     string `yaml:"trace_lightstep_access_token"`
+	TraceLightstepCollectorHost   string `yaml:"trace_lightstep_collector_host"`
+	TraceLightstepMaximumSpans    int    `yaml:"trace_lightstep_maximum_spans"`
+	TraceLightstepNumClients      int    `yaml:"trace_lightstep_num_clients"`
+	TraceLightstepReconnectPeriod string `yaml:"trace_lightstep_reconnect_period"`
+	TraceMaxLengthBytes           int    `yaml:"trace_max_length_bytes"`
+	TrafficMirrors                []struct {
+		Destination string `yaml:"destination"`
+		Listener    string `yaml:"listener"`
+	} `yaml:"traffic_mirrors"`
+	TuningEnabled            bool `yaml:"tuning_enabled"`
+	TuningForwardBatchMax    int  `yaml:"tuning_forward_batch_max"`
+	TuningForwardBatchMin    int  `yaml:"tuning_forward_batch_min"`
+	TuningGogcMax            int  `yaml:"tuning_gogc_max"`
+	TuningGogcMin            int  `yaml:"tuning_gogc_min"`
+	TuningMemoryTargetBytes  int  `yaml:"tuning_memory_target_bytes"`
+	TuningReadBufferMaxBytes int  `yaml:"tuning_read_buffer_max_bytes"`
+	WeightedDigestMerging    bool `yaml:"weighted_digest_merging"`
 }
@@ -7,19 +7,38 @@ import (
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
+	"github.com/stripe/veneur/samplers"
 
 	"gopkg.in/yaml.v2"
 )
 
 var defaultConfig = Config{
-	Aggregates:                     []string{"min", "max", "count"},
-	DatadogFlushMaxPerBody:         25000,
-	Interval:                       "10s",
-	MetricMaxLength:                4096,
-	ReadBufferSizeBytes:            1048576 * 2, // 2 MiB
-	SpanChannelCapacity:            100,
-	SplunkHecBatchSize:             100,
-	SplunkHecMaxConnectionLifetime: "10s", // same as Interval
+	Aggregates:                        []string{"min", "max", "count"},
+	ClockCheckInterval:                "1m",
+	DatadogFlushMaxPerBody:            25000,
+	HistogramCompression:              100,
+	Interval:                          "10s",
+	LeaderElectionKey:                 "veneur-global-leader",
+	LeaderElectionLeaseDuration:       "15s",
+	MetricBlocklistRefreshInterval:    "10s",
+	MetricMaxLength:                   4096,
+	MetricSchemaMode:                  "warn",
+	MetricSchemaRefreshInterval:       "1m",
+	PacketCaptureMaxPackets:           10000,
+	PreAggregatedTag:                  samplers.DefaultPreAggregatedTag,
+	ReadBufferSizeBytes:               1048576 * 2, // 2 MiB
+	SamplerSnapshotInterval:           "1s",
+	SinkPauseBufferSize:               100000,
+	SpanBlocklistRefreshInterval:      "10s",
+	SpanChannelCapacity:               100,
+	SplunkHecBatchSize:                100,
+	SplunkHecMaxConnectionLifetime:    "10s",          // same as Interval
+	SplunkHecRetryBufferBytes:         1048576 * 64,   // 64 MiB
+	SplunkHecSpillMaxBytes:            1048576 * 1024, // 1 GiB
+	SplunkHecTokenFileRefreshInterval: "10s",
+	TuningGogcMax:                     400,
+	TuningGogcMin:                     50,
+	TuningReadBufferMaxBytes:          1048576 * 16, // 16 MiB
 }
 
 var defaultProxyConfig = ProxyConfig{
@@ -153,18 +172,42 @@ func (c *Config) applyDefaults() {
 	if len(c.Aggregates) == 0 {
 		c.Aggregates = defaultConfig.Aggregates
 	}
+	if c.ClockCheckInterval == "" {
+		c.ClockCheckInterval = defaultConfig.ClockCheckInterval
+	}
+	if c.HistogramCompression == 0 {
+		c.HistogramCompression = defaultConfig.HistogramCompression
+	}
 	if c.Hostname == "" && !c.OmitEmptyHostname {
-		c.Hostname, _ = os.Hostname()
+		c.Hostname, _ = newHostnameResolver(c).resolve()
 	}
 	if c.Interval == "" {
 		c.Interval = defaultConfig.Interval
 	}
+	if c.MetricBlocklistRefreshInterval == "" {
+		c.MetricBlocklistRefreshInterval = defaultConfig.MetricBlocklistRefreshInterval
+	}
 	if c.MetricMaxLength == 0 {
 		c.MetricMaxLength = defaultConfig.MetricMaxLength
 	}
+	if c.MetricSchemaMode == "" {
+		c.MetricSchemaMode = defaultConfig.MetricSchemaMode
+	}
+	if c.MetricSchemaRefreshInterval == "" {
+		c.MetricSchemaRefreshInterval = defaultConfig.MetricSchemaRefreshInterval
+	}
+	if c.PacketCaptureMaxPackets == 0 {
+		c.PacketCaptureMaxPackets = defaultConfig.PacketCaptureMaxPackets
+	}
+	if c.PreAggregatedTag == "" {
+		c.PreAggregatedTag = defaultConfig.PreAggregatedTag
+	}
 	if c.ReadBufferSizeBytes == 0 {
 		c.ReadBufferSizeBytes = defaultConfig.ReadBufferSizeBytes
 	}
+	if c.SinkPauseBufferSize == 0 {
+		c.SinkPauseBufferSize = defaultConfig.SinkPauseBufferSize
+	}
 	if c.SsfBufferSize != 0 {
 		log.Warn("ssf_buffer_size configuration option has been replaced by datadog_span_buffer_size and will be removed in the next version")
 		if c.DatadogSpanBufferSize == 0 {
@@ -212,6 +255,19 @@ func (c *Config) applyDefaults() {
 		c.DatadogFlushMaxPerBody = defaultConfig.DatadogFlushMaxPerBody
 	}
 
+	if c.LeaderElectionKey == "" {
+		c.LeaderElectionKey = defaultConfig.LeaderElectionKey
+	}
+	if c.LeaderElectionLeaseDuration == "" {
+		c.LeaderElectionLeaseDuration = defaultConfig.LeaderElectionLeaseDuration
+	}
+	if c.SamplerSnapshotInterval == "" {
+		c.SamplerSnapshotInterval = defaultConfig.SamplerSnapshotInterval
+	}
+
+	if c.SpanBlocklistRefreshInterval == "" {
+		c.SpanBlocklistRefreshInterval = defaultConfig.SpanBlocklistRefreshInterval
+	}
 	if c.SpanChannelCapacity == 0 {
 		c.SpanChannelCapacity = defaultConfig.SpanChannelCapacity
 	}
@@ -223,6 +279,30 @@ func (c *Config) applyDefaults() {
 	if c.SplunkHecMaxConnectionLifetime == "" {
 		c.SplunkHecMaxConnectionLifetime = defaultConfig.SplunkHecMaxConnectionLifetime
 	}
+
+	if c.SplunkHecRetryBufferBytes == 0 {
+		c.SplunkHecRetryBufferBytes = defaultConfig.SplunkHecRetryBufferBytes
+	}
+
+	if c.SplunkHecSpillMaxBytes == 0 {
+		c.SplunkHecSpillMaxBytes = defaultConfig.SplunkHecSpillMaxBytes
+	}
+
+	if c.SplunkHecTokenFileRefreshInterval == "" {
+		c.SplunkHecTokenFileRefreshInterval = defaultConfig.SplunkHecTokenFileRefreshInterval
+	}
+
+	if c.TuningGogcMax == 0 {
+		c.TuningGogcMax = defaultConfig.TuningGogcMax
+	}
+
+	if c.TuningGogcMin == 0 {
+		c.TuningGogcMin = defaultConfig.TuningGogcMin
+	}
+
+	if c.TuningReadBufferMaxBytes == 0 {
+		c.TuningReadBufferMaxBytes = defaultConfig.TuningReadBufferMaxBytes
+	}
 }
 
 // ParseInterval handles parsing the flush interval as a time.Duration
@@ -1,27 +1,35 @@
 package veneur
 
 type ProxyConfig struct {
-	ConsulForwardGrpcServiceName string `yaml:"consul_forward_grpc_service_name"`
-	ConsulForwardServiceName     string `yaml:"consul_forward_service_name"`
-	ConsulRefreshInterval        string `yaml:"consul_refresh_interval"`
-	ConsulTraceServiceName       string `yaml:"consul_trace_service_name"`
-	Debug                        bool   `yaml:"debug"`
-	EnableProfiling              bool   `yaml:"enable_profiling"`
-	ForwardAddress               string `yaml:"forward_address"`
-	ForwardTimeout               string `yaml:"forward_timeout"`
-	GrpcAddress                  string `yaml:"grpc_address"`
-	GrpcForwardAddress           string `yaml:"grpc_forward_address"`
-	HTTPAddress                  string `yaml:"http_address"`
-	IdleConnectionTimeout        string `yaml:"idle_connection_timeout"`
-	MaxIdleConns                 int    `yaml:"max_idle_conns"`
-	MaxIdleConnsPerHost          int    `yaml:"max_idle_conns_per_host"`
-	RuntimeMetricsInterval       string `yaml:"runtime_metrics_interval"`
-	SentryDsn                    string `yaml:"sentry_dsn"`
-	SsfDestinationAddress        string `yaml:"ssf_destination_address"`
-	StatsAddress                 string `yaml:"stats_address"`
-	TraceAddress                 string `yaml:"trace_address"`
-	TraceAPIAddress              string `yaml:"trace_api_address"`
-	TracingClientCapacity        int    `yaml:"tracing_client_capacity"`
-	TracingClientFlushInterval   string `yaml:"tracing_client_flush_interval"`
-	TracingClientMetricsInterval string `yaml:"tracing_client_metrics_interval"`
+	ConsulForwardGrpcServiceName string    `yaml:"consul_forward_grpc_service_name"`
+	ConsulForwardServiceName     string    `yaml:"consul_forward_service_name"`
+	ConsulRefreshInterval        string    `yaml:"consul_refresh_interval"`
+	ConsulTraceServiceName       string    `yaml:"consul_trace_service_name"`
+	Debug                        bool      `yaml:"debug"`
+	DegradedModeAggregates       []string  `yaml:"degraded_mode_aggregates"`
+	DegradedModeEnabled          bool      `yaml:"degraded_mode_enabled"`
+	DegradedModeInterval         string    `yaml:"degraded_mode_interval"`
+	DegradedModePercentiles      []float64 `yaml:"degraded_mode_percentiles"`
+	EnableProfiling              bool      `yaml:"enable_profiling"`
+	ForwardAddress               string    `yaml:"forward_address"`
+	ForwardGrpcCompression       string    `yaml:"forward_grpc_compression"`
+	ForwardGrpcMaxSendMsgSize    int       `yaml:"forward_grpc_max_send_msg_size"`
+	ForwardTimeout               string    `yaml:"forward_timeout"`
+	GrpcAddress                  string    `yaml:"grpc_address"`
+	GrpcForwardAddress           string    `yaml:"grpc_forward_address"`
+	GrpcMaxRecvMsgSize           int       `yaml:"grpc_max_recv_msg_size"`
+	HTTPAddress                  string    `yaml:"http_address"`
+	IdleConnectionTimeout        string    `yaml:"idle_connection_timeout"`
+	MaxIdleConns                 int       `yaml:"max_idle_conns"`
+	MaxIdleConnsPerHost          int       `yaml:"max_idle_conns_per_host"`
+	RuntimeMetricsInterval       string    `yaml:"runtime_metrics_interval"`
+	SentryDsn                    string    `yaml:"sentry_dsn"`
+	SsfDestinationAddress        string    `yaml:"ssf_destination_address"`
+	SsfDestinationMaxPacketSize  int       `yaml:"ssf_destination_max_packet_size"`
+	StatsAddress                 string    `yaml:"stats_address"`
+	TraceAddress                 string    `yaml:"trace_address"`
+	TraceAPIAddress              string    `yaml:"trace_api_address"`
+	TracingClientCapacity        int       `yaml:"tracing_client_capacity"`
+	TracingClientFlushInterval   string    `yaml:"tracing_client_flush_interval"`
+	TracingClientMetricsInterval string    `yaml:"tracing_client_metrics_interval"`
 }
@@ -3,6 +3,7 @@ package veneur
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/hashicorp/consul/api"
 )
@@ -45,3 +46,50 @@ func (c *Consul) GetDestinationsForService(serviceName string) ([]string, error)
 
 	return hosts, nil
 }
+
+// ConsulElector is an Elector that uses a Consul lock to elect
+// a leader. Leadership is lost when the lock's session expires.
+type ConsulElector struct {
+	lock *api.Lock
+}
+
+// NewConsulElector creates an Elector that holds the lock on the
+// given Consul KV key while leading. The lock's session is renewed
+// automatically, and invalidated after ttl if this instance stops
+// renewing it.
+func NewConsulElector(config *api.Config, key string, identity string, ttl time.Duration) (*ConsulElector, error) {
+	consulClient, err := api.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	lock, err := consulClient.LockOpts(&api.LockOptions{
+		Key:         key,
+		Value:       []byte(identity),
+		SessionName: "veneur leader election",
+		SessionTTL:  ttl.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ConsulElector{lock: lock}, nil
+}
+
+// Campaign blocks until the Consul lock is acquired.
+func (c *ConsulElector) Campaign(stop <-chan struct{}) (<-chan struct{}, error) {
+	// A lock whose session was lost still counts as held, and
+	// can't be acquired again until it's unlocked:
+	if err := c.lock.Unlock(); err != nil && err != api.ErrLockNotHeld {
+		return nil, err
+	}
+	return c.lock.Lock(stop)
+}
+
+// Resign releases the Consul lock.
+func (c *ConsulElector) Resign() error {
+	err := c.lock.Unlock()
+	if err == api.ErrLockNotHeld {
+		return nil
+	}
+	return err
+}
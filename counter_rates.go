@@ -0,0 +1,77 @@
+package veneur
+
+import (
+	"strings"
+	"time"
+
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/ssf"
+)
+
+// counterRates flushes selected counters as per-second rates over the
+// interval that their counts were actually taken in, measured between
+// flushes, rather than over the configured flush interval. A flush
+// that runs late has counted for longer than the flush interval, and
+// a rate computed from the nominal interval, like the Datadog sink's,
+// spikes, and the next one dips; dividing by the measured interval
+// keeps the rate flat.
+type counterRates struct {
+	prefixes []string
+
+	// last is the end of the previous interval, or zero before the
+	// first flush.
+	last time.Time
+	// elapsed is the length of the last interval, and converted the
+	// counters flushed as rates in it.
+	elapsed   time.Duration
+	converted int64
+}
+
+func newCounterRates(prefixes []string) *counterRates {
+	return &counterRates{prefixes: prefixes}
+}
+
+func (r *counterRates) selects(name string) bool {
+	for _, prefix := range r.prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// convert ends the interval at end, and replaces the value of every
+// selected counter in metrics with its per-second rate over the
+// interval, flushed as a gauge. The first interval, whose start isn't
+// known, is taken to be nominal long.
+func (r *counterRates) convert(metrics []samplers.InterMetric, end time.Time, nominal time.Duration) {
+	elapsed := nominal
+	if !r.last.IsZero() && end.After(r.last) {
+		elapsed = end.Sub(r.last)
+	}
+	r.last = end
+	r.elapsed = elapsed
+	if elapsed <= 0 {
+		return
+	}
+	for i := range metrics {
+		m := &metrics[i]
+		if m.Type != samplers.CounterMetric || !r.selects(m.Name) {
+			continue
+		}
+		m.Value /= elapsed.Seconds()
+		m.Type = samplers.GaugeMetric
+		r.converted++
+	}
+}
+
+// report returns the length of the last interval and the number of
+// counters flushed as rates since the last report.
+func (r *counterRates) report() []*ssf.SSFSample {
+	converted := r.converted
+	r.converted = 0
+	return []*ssf.SSFSample{
+		ssf.Gauge("flush.counter_rates.interval_seconds", float32(r.elapsed.Seconds()), nil),
+		ssf.Count("flush.counter_rates.converted_total", float32(converted), nil),
+	}
+}
@@ -0,0 +1,41 @@
+package veneur
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/veneur/samplers"
+)
+
+func rateMetrics() []samplers.InterMetric {
+	return []samplers.InterMetric{
+		{Name: "api.requests", Value: 120, Type: samplers.CounterMetric},
+		{Name: "api.latency", Value: 3, Type: samplers.GaugeMetric},
+		{Name: "db.queries", Value: 120, Type: samplers.CounterMetric},
+	}
+}
+
+func TestCounterRates(t *testing.T) {
+	r := newCounterRates([]string{"api."})
+	start := time.Now()
+
+	metrics := rateMetrics()
+	r.convert(metrics, start, 10*time.Second)
+	assert.Equal(t, samplers.GaugeMetric, metrics[0].Type)
+	assert.Equal(t, float64(12), metrics[0].Value, "the first interval should be taken to be nominal long")
+	assert.Equal(t, float64(3), metrics[1].Value, "gauges shouldn't be converted")
+	assert.Equal(t, samplers.CounterMetric, metrics[2].Type, "unselected counters shouldn't be converted")
+	assert.Equal(t, float64(120), metrics[2].Value)
+
+	// a late flush:
+	metrics = rateMetrics()
+	r.convert(metrics, start.Add(12*time.Second), 10*time.Second)
+	assert.Equal(t, float64(10), metrics[0].Value, "the rate should be over the measured interval")
+
+	samples := r.report()
+	assert.Equal(t, "flush.counter_rates.interval_seconds", samples[0].Name)
+	assert.Equal(t, float32(12), samples[0].Value)
+	assert.Equal(t, float32(2), samples[1].Value)
+	assert.Equal(t, float32(0), r.report()[1].Value, "the counter should reset on every report")
+}
@@ -0,0 +1,42 @@
+package veneur
+
+import (
+	"sync"
+	"time"
+)
+
+// dedupGenerations remembers the keys it has seen within a short
+// window, in two generations that are rotated every window, so a key
+// is remembered for between one and two windows. Its zero value is
+// ready to use.
+type dedupGenerations[K comparable] struct {
+	mtx      sync.Mutex
+	rotated  time.Time
+	current  map[K]struct{}
+	previous map[K]struct{}
+}
+
+// seen returns whether the key was seen within the window, and
+// remembers it if it wasn't.
+func (g *dedupGenerations[K]) seen(key K, now time.Time, window time.Duration) bool {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	if since := now.Sub(g.rotated); since >= window {
+		if since >= 2*window {
+			// Everything remembered is older than the window.
+			g.previous = nil
+		} else {
+			g.previous = g.current
+		}
+		g.current = map[K]struct{}{}
+		g.rotated = now
+	}
+	if _, ok := g.current[key]; ok {
+		return true
+	}
+	if _, ok := g.previous[key]; ok {
+		return true
+	}
+	g.current[key] = struct{}{}
+	return false
+}
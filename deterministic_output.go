@@ -0,0 +1,60 @@
+package veneur
+
+import (
+	"sort"
+
+	"github.com/stripe/veneur/samplers"
+)
+
+// canonicalizeInterMetrics puts metrics into a canonical form, so that
+// sinks that encode metrics in the order they're handed them, with
+// their tags in order, send the same bytes for the same metrics on every
+// flush: every metric's tags are sorted, negative zero values become
+// zero, and metrics are ordered by name, tags, type and hostname within
+// each priority class.
+func canonicalizeInterMetrics(metrics []samplers.InterMetric) {
+	for i := range metrics {
+		m := &metrics[i]
+		if !sort.StringsAreSorted(m.Tags) {
+			// Metrics can share their tags with others, so sort
+			// a copy.
+			tags := make([]string, len(m.Tags))
+			copy(tags, m.Tags)
+			sort.Strings(tags)
+			m.Tags = tags
+		}
+		if m.Value == 0 {
+			// This turns -0 into 0.
+			m.Value = 0
+		}
+	}
+	sort.SliceStable(metrics, func(i, j int) bool {
+		a, b := &metrics[i], &metrics[j]
+		if a.Priority != b.Priority {
+			return a.Priority.Outranks(b.Priority)
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if c := compareTags(a.Tags, b.Tags); c != 0 {
+			return c < 0
+		}
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		return a.HostName < b.HostName
+	})
+}
+
+// compareTags compares two sorted lists of tags lexicographically.
+func compareTags(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
@@ -0,0 +1,52 @@
+package veneur
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+)
+
+func TestCanonicalizeInterMetrics(t *testing.T) {
+	shared := []string{"b:2", "a:1"}
+	metrics := []samplers.InterMetric{
+		{Name: "b", Tags: []string{"x:1"}, Type: samplers.CounterMetric},
+		{Name: "a", Tags: shared, Type: samplers.GaugeMetric, Value: math.Copysign(0, -1)},
+		{Name: "a", Tags: []string{"a:1"}, Type: samplers.GaugeMetric},
+		{Name: "z", Priority: samplers.PriorityHigh, Type: samplers.CounterMetric},
+		{Name: "a", Tags: []string{"a:1"}, Type: samplers.CounterMetric},
+		{Name: "a", Priority: samplers.PriorityLow, Type: samplers.CounterMetric},
+	}
+	canonicalizeInterMetrics(metrics)
+
+	type key struct {
+		name string
+		tags []string
+		typ  samplers.MetricType
+	}
+	var got []key
+	for _, m := range metrics {
+		got = append(got, key{m.Name, m.Tags, m.Type})
+	}
+	assert.Equal(t, []key{
+		{"z", nil, samplers.CounterMetric},
+		{"a", []string{"a:1"}, samplers.CounterMetric},
+		{"a", []string{"a:1"}, samplers.GaugeMetric},
+		{"a", []string{"a:1", "b:2"}, samplers.GaugeMetric},
+		{"b", []string{"x:1"}, samplers.CounterMetric},
+		{"a", nil, samplers.CounterMetric},
+	}, got)
+
+	require.Equal(t, "a", metrics[3].Name)
+	assert.False(t, math.Signbit(metrics[3].Value), "negative zero should become zero")
+	assert.Equal(t, []string{"b:2", "a:1"}, shared, "shared tags shouldn't be sorted in place")
+}
+
+func TestCompareTags(t *testing.T) {
+	assert.Equal(t, 0, compareTags(nil, nil))
+	assert.True(t, compareTags([]string{"a:1"}, []string{"a:2"}) < 0)
+	assert.True(t, compareTags([]string{"a:1", "b:1"}, []string{"a:1"}) > 0)
+	assert.True(t, compareTags(nil, []string{"a:1"}) < 0)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/url"
 	"reflect"
 	"runtime"
 	"strings"
@@ -20,6 +21,7 @@ import (
 	"github.com/stripe/veneur/ssf"
 	"github.com/stripe/veneur/trace"
 	"github.com/stripe/veneur/trace/metrics"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
@@ -27,9 +29,33 @@ import (
 func (s *Server) Flush(ctx context.Context) {
 	span := tracer.StartSpan("flush").(*trace.Span)
 	defer span.ClientFinish(s.TraceClient)
+	defer func() {
+		s.recordFlushDuration(time.Since(span.Start))
+	}()
+
+	// Sinks, plugins and forwarding have until the next flush to send
+	// what this one hands them, so that a hung backend can't hold
+	// their goroutines any longer. Some of them outlive this function,
+	// so the context is only released once they're all done.
+	ctx, cancel := s.flushContext(ctx)
+	var pending sync.WaitGroup
+	defer func() {
+		go func() {
+			pending.Wait()
+			cancel()
+		}()
+	}()
+	async := func(f func()) {
+		pending.Add(1)
+		go func() {
+			defer pending.Done()
+			f()
+		}()
+	}
 
 	mem := &runtime.MemStats{}
 	runtime.ReadMemStats(mem)
+	s.tune(mem)
 
 	s.Statsd.Gauge("worker.span_chan.total_elements", float64(len(s.SpanChan)), nil, 1.0)
 	s.Statsd.Gauge("worker.span_chan.total_capacity", float64(cap(s.SpanChan)), nil, 1.0)
@@ -41,10 +67,14 @@ func (s *Server) Flush(ctx context.Context) {
 
 	// TODO Concurrency
 	for _, sink := range s.metricSinks {
+		if pause := s.sinkPauses.lookup(sink.Name()); pause.isPaused() {
+			pause.drop(len(samples))
+			continue
+		}
 		sink.FlushOtherSamples(span.Attach(ctx), samples)
 	}
 
-	go s.flushTraces(span.Attach(ctx))
+	async(func() { s.flushTraces(span.Attach(ctx)) })
 
 	var finalMetrics []samplers.InterMetric
 
@@ -64,20 +94,98 @@ func (s *Server) Flush(ctx context.Context) {
 	}
 
 	tempMetrics, ms := s.tallyMetrics(percentiles)
+	s.reportAutoscalingSignals()
+	if s.metricSchemas != nil {
+		span.Add(s.metricSchemas.report()...)
+	}
+	if s.metricPriorities != nil {
+		span.Add(s.metricPriorities.report()...)
+	}
+	if s.metricScopes != nil {
+		span.Add(s.metricScopes.report()...)
+	}
+	if s.hostRollup != nil {
+		span.Add(s.hostRollup.report()...)
+	}
+	if s.reporters != nil {
+		span.Add(s.reporters.report(time.Now())...)
+	}
+	for _, tm := range s.trafficMirrors {
+		span.Add(tm.report()...)
+	}
+	if s.metricBlocklist != nil {
+		span.Add(s.metricBlocklist.report()...)
+	}
+	if s.spanBlocklist != nil {
+		span.Add(s.spanBlocklist.report()...)
+	}
+	if s.sinkPauses != nil {
+		span.Add(s.sinkPauses.report()...)
+	}
+	if s.statsdRepeater != nil {
+		span.Add(s.statsdRepeater.report()...)
+	}
+	if s.timestampSkew != nil {
+		span.Add(s.timestampSkew.report()...)
+	}
+	if s.clockCheck != nil {
+		span.Add(s.clockCheck.report()...)
+	}
+	if s.chaos != nil {
+		span.Add(s.chaos.report()...)
+	}
+	if s.serviceMap != nil {
+		span.Add(s.serviceMap.report()...)
+	}
 
-	finalMetrics = s.generateInterMetrics(span.Attach(ctx), percentiles, aggregates, tempMetrics, ms)
+	finalMetrics, distributions, flushedSeries := s.generateInterMetrics(span.Attach(ctx), percentiles, aggregates, tempMetrics, ms)
+	if s.compactDuplicateMetrics {
+		var merged int
+		finalMetrics, merged = compactInterMetrics(finalMetrics, s.compactionExcludedTags)
+		span.Add(ssf.Count("flush.duplicate_metrics_merged_total", float32(merged), nil))
+	}
+	if len(s.metricExpressions) > 0 {
+		computed := computeMetricExpressions(s.metricExpressions, finalMetrics)
+		span.Add(ssf.Count("flush.metric_expressions_computed_total", float32(len(computed)), nil))
+		finalMetrics = append(finalMetrics, computed...)
+	}
+	if s.counterRates != nil {
+		s.counterRates.convert(finalMetrics, span.Start, s.interval)
+		span.Add(s.counterRates.report()...)
+	}
+	if s.clockCheck != nil {
+		s.clockCheck.correct(finalMetrics)
+		s.clockCheck.correct(distributions)
+	}
+	if s.canary != nil {
+		now := time.Now()
+		s.canary.observeMetrics(finalMetrics, now)
+		span.Add(s.canary.report(now)...)
+	}
+	if s.deterministicOutput {
+		canonicalizeInterMetrics(finalMetrics)
+	} else {
+		sortInterMetricsByPriority(finalMetrics)
+	}
 
 	s.reportMetricsFlushCounts(ms)
 
 	if s.IsLocal() {
 		// Forward over gRPC or HTTP depending on the configuration
 		if s.forwardUseGRPC {
-			go s.forwardGRPC(span.Attach(ctx), tempMetrics)
+			async(func() { s.forwardGRPC(span.Attach(ctx), tempMetrics, span.Start) })
 		} else {
-			go s.flushForward(span.Attach(ctx), tempMetrics)
+			async(func() { s.flushForward(span.Attach(ctx), tempMetrics, span.Start) })
 		}
 	} else {
 		s.reportGlobalMetricsFlushCounts(ms)
+		if s.weightedDigestMerging {
+			s.reportMergeErrors(tempMetrics)
+		}
+	}
+
+	if s.accountingCheck {
+		s.checkAccounting(ms.accounting, int64(ms.seriesToFlush(s.IsLocal())), int64(flushedSeries), int64(len(finalMetrics)))
 	}
 
 	// If there's nothing to flush, don't bother calling the plugins and stuff.
@@ -89,16 +197,29 @@ func (s *Server) Flush(ctx context.Context) {
 	for _, sink := range s.metricSinks {
 		wg.Add(1)
 		go func(ms sinks.MetricSink) {
-			err := ms.Flush(span.Attach(ctx), finalMetrics)
+			defer wg.Done()
+			toFlush, flush := s.sinkPauses.lookup(ms.Name()).metricsToFlush(finalMetrics)
+			if !flush {
+				return
+			}
+			var err error
+			if ds, ok := ms.(sinks.DistributionSink); ok && len(distributions) > 0 {
+				err = ds.FlushWithDistributions(span.Attach(ctx), toFlush, distributions)
+			} else {
+				err = ms.Flush(span.Attach(ctx), toFlush)
+			}
 			if err != nil {
 				log.WithError(err).WithField("sink", ms.Name()).Warn("Error flushing sink")
 			}
-			wg.Done()
 		}(sink)
 	}
 	wg.Wait()
 
-	go func() {
+	if s.sinkMigration != nil {
+		span.Add(s.sinkMigration.report(s.sinkMigration.compare())...)
+	}
+
+	async(func() {
 		samples := &ssf.Samples{}
 		defer metrics.Report(s.TraceClient, samples)
 
@@ -108,11 +229,12 @@ func (s *Server) Flush(ctx context.Context) {
 			err := p.Flush(span.Attach(ctx), finalMetrics)
 			samples.Add(ssf.Timing(fmt.Sprintf("flush.plugins.%s.total_duration_ns", p.Name()), time.Since(start), time.Nanosecond, tags))
 			if err != nil {
-				samples.Add(ssf.Count(fmt.Sprintf("flush.plugins.%s.error_total", p.Name()), 1, nil))
+				samples.Add(ssf.Count(fmt.Sprintf("flush.plugins.%s.error_total", p.Name()), 1, nil,
+					ssf.Failure("plugin", ssf.CauseIOError)))
 			}
 			samples.Add(ssf.Gauge(fmt.Sprintf("flush.plugins.%s.post_metrics_total", p.Name()), float32(len(finalMetrics)), nil))
 		}
-	}()
+	})
 }
 
 type metricsSummary struct {
@@ -132,7 +254,25 @@ type metricsSummary struct {
 	totalLocalTimers       int
 	totalLocalStatusChecks int
 
+	totalIngested int64
+
 	totalLength int
+
+	// accounting holds the workers' accounting of the interval, with
+	// accounting_check_enabled.
+	accounting []*accountingReport
+}
+
+// seriesToFlush returns the number of series that the flush turns
+// into InterMetrics here, rather than only forwarding them.
+func (ms metricsSummary) seriesToFlush(local bool) int {
+	series := ms.totalCounters + ms.totalGauges + ms.totalHistograms + ms.totalTimers +
+		ms.totalLocalHistograms + ms.totalLocalSets + ms.totalLocalTimers + ms.totalLocalStatusChecks
+	if !local {
+		series += ms.totalSets + ms.totalGlobalCounters + ms.totalGlobalGauges +
+			ms.totalGlobalHistograms + ms.totalGlobalTimers
+	}
+	return series
 }
 
 // tallyMetrics gives a slight overestimate of the number
@@ -146,10 +286,28 @@ func (s *Server) tallyMetrics(percentiles []float64) ([]WorkerMetrics, metricsSu
 
 	ms := metricsSummary{}
 
+	// Once the workers are flushed, any snapshot of their state
+	// refers to data that is about to be sent to the sinks; make
+	// sure it can't be restored after a restart.
+	if s.snapshotPath != "" {
+		s.snapshotMtx.Lock()
+		defer s.snapshotMtx.Unlock()
+		defer s.discardSnapshot()
+	}
+
 	for i, w := range s.Workers {
 		log.WithField("worker", i).Debug("Flushing")
-		wm := w.Flush()
-		tempMetrics = append(tempMetrics, wm)
+		tempMetrics = append(tempMetrics, w.Flush())
+	}
+	if s.accountingCheck {
+		ms.accounting = make([]*accountingReport, len(tempMetrics))
+		for i, wm := range tempMetrics {
+			ms.accounting[i] = wm.accounting
+		}
+	}
+	tempMetrics = s.mergeCPUGroups(tempMetrics)
+
+	for _, wm := range tempMetrics {
 
 		ms.totalCounters += len(wm.counters)
 		ms.totalGauges += len(wm.gauges)
@@ -167,7 +325,10 @@ func (s *Server) tallyMetrics(percentiles []float64) ([]WorkerMetrics, metricsSu
 		ms.totalLocalTimers += len(wm.localTimers)
 
 		ms.totalLocalStatusChecks += len(wm.localStatusChecks)
+
+		ms.totalIngested += wm.ingested
 	}
+	s.recordIngested(ms.totalIngested)
 
 	ms.totalLength = ms.totalCounters + ms.totalGauges +
 		// histograms and timers each report a metric point for each percentile
@@ -195,79 +356,145 @@ func (s *Server) tallyMetrics(percentiles []float64) ([]WorkerMetrics, metricsSu
 // generateInterMetrics calls the Flush method on each
 // counter/gauge/histogram/timer/set in order to
 // generate an InterMetric corresponding to that value
-func (s *Server) generateInterMetrics(ctx context.Context, percentiles []float64, aggregates samplers.HistogramAggregates, tempMetrics []WorkerMetrics, ms metricsSummary) []samplers.InterMetric {
+//
+// Flushing the samplers, and computing the histograms' percentiles
+// above all, is most of the work of a flush, so each worker's shard
+// is flushed on a core of its own, and the shards are concatenated in
+// order: the result is the same as if they were flushed one after
+// another. It also returns the distributions that histograms and timers
+// were flushed as, which only sinks.DistributionSinks get, and the
+// number of series that it flushed.
+func (s *Server) generateInterMetrics(ctx context.Context, percentiles []float64, aggregates samplers.HistogramAggregates, tempMetrics []WorkerMetrics, ms metricsSummary) ([]samplers.InterMetric, []samplers.InterMetric, int) {
 
 	span, _ := trace.StartSpanFromContext(ctx, "")
 	defer span.ClientFinish(s.TraceClient)
 
+	shards := make([][]samplers.InterMetric, len(tempMetrics))
+	shardDistributions := make([][]samplers.InterMetric, len(tempMetrics))
+	series := make([]int, len(tempMetrics))
+	cores := make(chan struct{}, runtime.GOMAXPROCS(0))
+	wg := sync.WaitGroup{}
+	for i := range tempMetrics {
+		wg.Add(1)
+		cores <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			shards[i], shardDistributions[i], series[i] = s.flushShard(tempMetrics[i], percentiles, aggregates)
+			<-cores
+		}(i)
+	}
+	wg.Wait()
+
 	finalMetrics := make([]samplers.InterMetric, 0, ms.totalLength)
-	for _, wm := range tempMetrics {
-		for _, c := range wm.counters {
-			finalMetrics = append(finalMetrics, c.Flush(s.interval)...)
-		}
-		for _, g := range wm.gauges {
-			finalMetrics = append(finalMetrics, g.Flush()...)
-		}
-		// if we're a local veneur, then percentiles=nil, and only the local
-		// parts (count, min, max) will be flushed
-		//
-		// if we're a global veneur, aggregates will be nil.
-		for _, h := range wm.histograms {
-			finalMetrics = append(finalMetrics, h.Flush(s.interval, percentiles, aggregates, false)...)
-		}
-		for _, t := range wm.timers {
-			finalMetrics = append(finalMetrics, t.Flush(s.interval, percentiles, aggregates, false)...)
-		}
+	var distributions []samplers.InterMetric
+	flushed := 0
+	for i, shard := range shards {
+		finalMetrics = append(finalMetrics, shard...)
+		distributions = append(distributions, shardDistributions[i]...)
+		flushed += series[i]
+	}
+	return finalMetrics, distributions, flushed
+}
 
-		// local-only samplers should be flushed in their entirety, since they
-		// will not be forwarded
-		// we still want percentiles for these, even if we're a local veneur, so
-		// we use the original percentile list when flushing them
-		for _, h := range wm.localHistograms {
-			finalMetrics = append(finalMetrics, h.Flush(s.interval, s.HistogramPercentiles, s.HistogramAggregates, false)...)
-		}
-		for _, s := range wm.localSets {
-			finalMetrics = append(finalMetrics, s.Flush()...)
-		}
-		for _, t := range wm.localTimers {
-			finalMetrics = append(finalMetrics, t.Flush(s.interval, s.HistogramPercentiles, s.HistogramAggregates, false)...)
+// flushShard generates the InterMetrics of a worker's samplers, and
+// returns them with the distributions of its histograms and timers and
+// the number of series that it flushed.
+func (s *Server) flushShard(wm WorkerMetrics, percentiles []float64, aggregates samplers.HistogramAggregates) ([]samplers.InterMetric, []samplers.InterMetric, int) {
+	var finalMetrics, distributions []samplers.InterMetric
+	series := 0
+	add := func(metrics []samplers.InterMetric) {
+		finalMetrics = append(finalMetrics, metrics...)
+		series++
+	}
+	addHistogram := func(metrics, ds []samplers.InterMetric) {
+		add(metrics)
+		distributions = append(distributions, ds...)
+	}
+	for _, c := range wm.counters {
+		add(c.Flush(s.interval))
+	}
+	for _, g := range wm.gauges {
+		add(g.Flush())
+	}
+	// if we're a local veneur, then percentiles=nil, and only the local
+	// parts (count, min, max) will be flushed
+	//
+	// if we're a global veneur, aggregates will be nil.
+	for _, h := range wm.histograms {
+		addHistogram(s.flushHistogram(h, percentiles, aggregates, false))
+	}
+	for _, t := range wm.timers {
+		addHistogram(s.flushHistogram(t, percentiles, aggregates, false))
+	}
+
+	// local-only samplers should be flushed in their entirety, since they
+	// will not be forwarded
+	// we still want percentiles for these, even if we're a local veneur, so
+	// we use the original percentile list when flushing them
+	for _, h := range wm.localHistograms {
+		addHistogram(s.flushHistogram(h, s.HistogramPercentiles, s.HistogramAggregates, false))
+	}
+	for _, s := range wm.localSets {
+		add(s.Flush())
+	}
+	for _, t := range wm.localTimers {
+		addHistogram(s.flushHistogram(t, s.HistogramPercentiles, s.HistogramAggregates, false))
+	}
+
+	for _, status := range wm.localStatusChecks {
+		add(status.Flush())
+	}
+
+	// TODO (aditya) refactor this out so we don't
+	// have to call IsLocal again
+	if !s.IsLocal() {
+		// sets have no local parts, so if we're a local veneur, there's
+		// nothing to flush at all
+		for _, s := range wm.sets {
+			add(s.Flush())
 		}
 
-		for _, status := range wm.localStatusChecks {
-			finalMetrics = append(finalMetrics, status.Flush()...)
+		// also do this for global counters
+		// global counters have no local parts, so if we're a local veneur,
+		// there's nothing to flush
+		for _, gc := range wm.globalCounters {
+			add(gc.Flush(s.interval))
 		}
 
-		// TODO (aditya) refactor this out so we don't
-		// have to call IsLocal again
-		if !s.IsLocal() {
-			// sets have no local parts, so if we're a local veneur, there's
-			// nothing to flush at all
-			for _, s := range wm.sets {
-				finalMetrics = append(finalMetrics, s.Flush()...)
-			}
+		// and global gauges
+		for _, gg := range wm.globalGauges {
+			add(gg.Flush())
+		}
 
-			// also do this for global counters
-			// global counters have no local parts, so if we're a local veneur,
-			// there's nothing to flush
-			for _, gc := range wm.globalCounters {
-				finalMetrics = append(finalMetrics, gc.Flush(s.interval)...)
-			}
+		for _, h := range wm.globalHistograms {
+			addHistogram(s.flushHistogram(h, s.HistogramPercentiles, s.HistogramAggregates, true))
+		}
+		for _, h := range wm.globalTimers {
+			addHistogram(s.flushHistogram(h, s.HistogramPercentiles, s.HistogramAggregates, true))
+		}
+	}
 
-			// and global gauges
-			for _, gg := range wm.globalGauges {
-				finalMetrics = append(finalMetrics, gg.Flush()...)
-			}
+	return finalMetrics, distributions, series
+}
 
-			for _, h := range wm.globalHistograms {
-				finalMetrics = append(finalMetrics, h.Flush(s.interval, s.HistogramPercentiles, s.HistogramAggregates, true)...)
-			}
-			for _, h := range wm.globalTimers {
-				finalMetrics = append(finalMetrics, h.Flush(s.interval, s.HistogramPercentiles, s.HistogramAggregates, true)...)
-			}
+// flushHistogram flushes a histogram or timer. With
+// datadog_distributions, it also returns the histogram as a
+// distribution for the Datadog sink whenever its percentiles are
+// flushed.
+func (s *Server) flushHistogram(h *samplers.Histo, percentiles []float64, aggregates samplers.HistogramAggregates, global bool) ([]samplers.InterMetric, []samplers.InterMetric) {
+	metrics := h.Flush(s.interval, percentiles, aggregates, global)
+	if !s.datadogDistributions || len(percentiles) == 0 {
+		return metrics, nil
+	}
+	var distributions []samplers.InterMetric
+	for _, d := range h.FlushDistribution() {
+		if !d.Sinks.RouteTo("datadog") {
+			continue
 		}
+		d.Sinks = samplers.RouteInformation{"datadog": struct{}{}}
+		distributions = append(distributions, d)
 	}
-
-	return finalMetrics
+	return metrics, distributions
 }
 
 const flushTotalMetric = "worker.metrics_flushed_total"
@@ -308,7 +535,30 @@ func (s *Server) reportGlobalMetricsFlushCounts(ms metricsSummary) {
 	s.Statsd.Count(flushTotalMetric, int64(ms.totalTimers), []string{"metric_type:timer"}, 1.0)
 }
 
-func (s *Server) flushForward(ctx context.Context, wms []WorkerMetrics) {
+// reportMergeErrors reports the largest error estimated while merging
+// imported t-digests into each histogram and timer, as a fraction of
+// a quantile.
+func (s *Server) reportMergeErrors(wms []WorkerMetrics) {
+	report := func(histos map[samplers.MetricKey]*samplers.Histo, metricType string) {
+		for _, h := range histos {
+			if h.MergeError == 0 {
+				continue
+			}
+			s.Statsd.Gauge("histogram.merge_error", h.MergeError,
+				[]string{"metric:" + h.Name, "metric_type:" + metricType}, 1.0)
+		}
+	}
+	for _, wm := range wms {
+		report(wm.histograms, "histogram")
+		report(wm.globalHistograms, "global_histogram")
+		report(wm.timers, "timer")
+		report(wm.globalTimers, "global_timer")
+	}
+}
+
+// flushForward forwards all input metrics to a downstream Veneur, over
+// HTTP, along with the end of the interval they're from.
+func (s *Server) flushForward(ctx context.Context, wms []WorkerMetrics, intervalEnd time.Time) {
 	span, _ := trace.StartSpanFromContext(ctx, "")
 	defer span.ClientFinish(s.TraceClient)
 	jmLength := 0
@@ -392,16 +642,23 @@ func (s *Server) flushForward(ctx context.Context, wms []WorkerMetrics) {
 		log.Debug("Nothing to forward, skipping.")
 		return
 	}
+	sortJSONMetricsByPriority(jsonMetrics)
 
 	// the error has already been logged (if there was one), so we only care
 	// about the success case
-	endpoint := fmt.Sprintf("%s/import", s.ForwardAddr)
-	if vhttp.PostHelper(span.Attach(ctx), s.HTTPClient, s.TraceClient, http.MethodPost, endpoint, jsonMetrics, "forward", true, nil, log) == nil {
-		log.WithFields(logrus.Fields{
-			"metrics":     len(jsonMetrics),
-			"endpoint":    endpoint,
-			"forwardAddr": s.ForwardAddr,
-		}).Info("Completed forward to upstream Veneur")
+	for _, batch := range s.forwardBatches(len(jsonMetrics)) {
+		body := jsonMetrics[batch[0]:batch[1]]
+		endpoint := fmt.Sprintf("%s/import?%s=%s&%s=%d&%s=%d", s.ForwardAddr,
+			importReporterParam, url.QueryEscape(s.forwardReporter),
+			importIntervalEndParam, intervalEnd.UnixNano(),
+			importBatchParam, batch[0])
+		if vhttp.PostHelper(span.Attach(ctx), s.sinkHTTPClient("forward"), s.TraceClient, http.MethodPost, endpoint, body, "forward", true, nil, log) == nil {
+			log.WithFields(logrus.Fields{
+				"metrics":     len(body),
+				"endpoint":    endpoint,
+				"forwardAddr": s.ForwardAddr,
+			}).Info("Completed forward to upstream Veneur")
+		}
 	}
 }
 
@@ -435,14 +692,33 @@ func (s *Server) flushTraces(ctx context.Context) {
 
 		spansReceivedTotal := atomic.SwapInt64(&value.ssfSpansReceivedTotal, 0)
 		s.Statsd.Count("ssf.spans.received_total", spansReceivedTotal, tags, 1.0)
+		if duplicates := atomic.SwapInt64(&value.ssfSpansDuplicateTotal, 0); duplicates > 0 {
+			s.Statsd.Count("ssf.spans.duplicates_total", duplicates, tags, 1.0)
+		}
+		for field := range value.ssfSpansTruncated {
+			if truncated := atomic.SwapInt64(&value.ssfSpansTruncated[field], 0); truncated > 0 {
+				s.Statsd.Count("ssf.spans.truncated_total", truncated,
+					append([]string{"field:" + spanFieldNames[field]}, tags...), 1.0)
+			}
+		}
 		return true
 	})
 
-	s.SpanWorker.Flush()
+	s.SpanWorker.Flush(ctx)
 }
 
-// forwardGRPC forwards all input metrics to a downstream Veneur, over gRPC.
-func (s *Server) forwardGRPC(ctx context.Context, wms []WorkerMetrics) {
+// flushContext returns a context for the parts of a flush, which
+// expires once the next flush is due.
+func (s *Server) flushContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.interval <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.interval)
+}
+
+// forwardGRPC forwards all input metrics to a downstream Veneur, over
+// gRPC, along with the end of the interval they're from.
+func (s *Server) forwardGRPC(ctx context.Context, wms []WorkerMetrics, intervalEnd time.Time) {
 	span, _ := trace.StartSpanFromContext(ctx, "")
 	span.SetTag("protocol", "grpc")
 	defer span.ClientFinish(s.TraceClient)
@@ -467,35 +743,48 @@ func (s *Server) forwardGRPC(ctx context.Context, wms []WorkerMetrics) {
 		log.Debug("Nothing to forward, skipping.")
 		return
 	}
-
-	entry := log.WithFields(logrus.Fields{
-		"metrics":     len(metrics),
-		"destination": s.ForwardAddr,
-		"protocol":    "grpc",
-		"grpcstate":   s.grpcForwardConn.GetState().String(),
-	})
-
-	c := forwardrpc.NewForwardClient(s.grpcForwardConn)
+	sortForwardMetricsByPriority(metrics)
 
 	grpcStart := time.Now()
-	_, err := c.SendMetrics(ctx, &forwardrpc.MetricList{Metrics: metrics})
-	if err != nil {
-		if statErr, ok := status.FromError(err); ok && (statErr.Message() == "all SubConns are in TransientFailure" || statErr.Message() == "transport is closing") {
-			// We could check statErr.Code() == codes.Unavailable, but we don't know all of the cases that
-			// could return that code. These two particular cases are fairly safe and usually associated
-			// with connection rebalancing or host replacement, so we don't want them going to sentry.
-			span.Add(ssf.Count("forward.error_total", 1, map[string]string{"cause": "transient_unavailable"}))
+	messages := 0
+	ctx = forwardrpc.ContextWithIntervalEnd(ctx, intervalEnd)
+	for _, batch := range s.forwardBatches(len(metrics)) {
+		entry := log.WithFields(logrus.Fields{
+			"metrics":     batch[1] - batch[0],
+			"destination": s.ForwardAddr,
+			"protocol":    "grpc",
+			"grpcstate":   s.grpcForwardConn.GetState().String(),
+		})
+
+		// The client splits batches that are too large for a single
+		// message, so this may send several.
+		sent, err := s.grpcForwardClient.Send(ctx, metrics[batch[0]:batch[1]])
+		messages += sent
+		if err != nil {
+			if statErr, ok := status.FromError(err); ok && (statErr.Message() == "all SubConns are in TransientFailure" || statErr.Message() == "transport is closing") {
+				// We could check statErr.Code() == codes.Unavailable, but we don't know all of the cases that
+				// could return that code. These two particular cases are fairly safe and usually associated
+				// with connection rebalancing or host replacement, so we don't want them going to sentry.
+				span.Add(ssf.Count("forward.error_total", 1, map[string]string{"reason": "transient_unavailable"},
+					ssf.Failure("forward", ssf.CauseIOError)))
+			} else {
+				cause := ssf.CauseIOError
+				if status.Code(err) == codes.DeadlineExceeded {
+					cause = ssf.CauseSinkTimeout
+				}
+				span.Add(ssf.Count("forward.error_total", 1, map[string]string{"reason": "send"},
+					ssf.Failure("forward", cause)))
+				entry.WithError(err).Error("Failed to forward to an upstream Veneur")
+			}
 		} else {
-			span.Add(ssf.Count("forward.error_total", 1, map[string]string{"cause": "send"}))
-			entry.WithError(err).Error("Failed to forward to an upstream Veneur")
+			entry.Info("Completed forward to an upstream Veneur")
 		}
-	} else {
-		entry.Info("Completed forward to an upstream Veneur")
 	}
 
 	span.Add(
 		ssf.Timing("forward.duration_ns", time.Since(grpcStart), time.Nanosecond,
 			map[string]string{"part": "grpc"}),
+		ssf.Count("forward.grpc_messages_total", float32(messages), nil),
 		ssf.Count("forward.error_total", 0, nil),
 	)
 }
@@ -2,12 +2,17 @@ package veneur
 
 import (
 	"context"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stripe/veneur/internal/forwardtest"
+	"github.com/stripe/veneur/samplers"
 	"github.com/stripe/veneur/samplers/metricpb"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
 )
 
 func TestServerFlushGRPC(t *testing.T) {
@@ -65,3 +70,102 @@ func TestServerFlushGRPCBadAddress(t *testing.T) {
 	local.Workers[0].ProcessMetric(forwardGRPCTestMetrics()[0])
 	local.Flush(context.Background())
 }
+
+// hangingMetricSink is a metric sink whose backend never answers: its
+// flushes only return once their context is done.
+type hangingMetricSink struct {
+	errs chan error
+}
+
+func (h *hangingMetricSink) Name() string {
+	return "hanging"
+}
+
+func (h *hangingMetricSink) Start(*trace.Client) error {
+	return nil
+}
+
+func (h *hangingMetricSink) Flush(ctx context.Context, metrics []samplers.InterMetric) error {
+	<-ctx.Done()
+	select {
+	case h.errs <- ctx.Err():
+	default:
+	}
+	return ctx.Err()
+}
+
+func (h *hangingMetricSink) FlushOtherSamples(ctx context.Context, events []ssf.SSFSample) {}
+
+func TestServerFlushDeadline(t *testing.T) {
+	config := globalConfig()
+	config.Interval = "100ms"
+	sink := &hangingMetricSink{errs: make(chan error, 1)}
+	server := setupVeneurServer(t, config, nil, sink, nil)
+	defer server.Shutdown()
+
+	server.Workers[0].ProcessMetric(&samplers.UDPMetric{
+		MetricKey: samplers.MetricKey{
+			Name: "a.b.c",
+			Type: "gauge",
+		},
+		Value:      1.0,
+		SampleRate: 1.0,
+		Scope:      samplers.GlobalOnly,
+	})
+
+	start := time.Now()
+	server.Flush(context.Background())
+	assert.True(t, time.Since(start) < 5*time.Second, "Flush should give up on the sink once the flush interval passes")
+
+	select {
+	case err := <-sink.errs:
+		assert.Equal(t, context.DeadlineExceeded, err)
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the sink's flush to be cancelled")
+	}
+}
+
+func TestFlushHistogramDistributions(t *testing.T) {
+	s := &Server{interval: 10 * time.Second}
+	h := samplers.NewHist("a.b.c", nil)
+	h.Sample(5, 1)
+	percentiles := []float64{0.5}
+	_, distributions := s.flushHistogram(h, percentiles, samplers.HistogramAggregates{}, false)
+	assert.Empty(t, distributions, "distributions should only be flushed if enabled")
+
+	s.datadogDistributions = true
+	_, distributions = s.flushHistogram(h, nil, samplers.HistogramAggregates{}, false)
+	assert.Empty(t, distributions, "distributions should only be flushed where percentiles are")
+	metrics, distributions := s.flushHistogram(h, percentiles, samplers.HistogramAggregates{}, false)
+	assert.Len(t, metrics, 1, "distributions shouldn't be among the metrics that every sink gets")
+	require.Len(t, distributions, 1)
+	assert.Equal(t, samplers.DistributionMetric, distributions[0].Type)
+	assert.True(t, distributions[0].Sinks.RouteTo("datadog"))
+	assert.False(t, distributions[0].Sinks.RouteTo("signalfx"), "only the Datadog sink should get distributions")
+
+	h = samplers.NewHist("a.b.c", []string{"veneursinkonly:signalfx"})
+	h.Sample(5, 1)
+	_, distributions = s.flushHistogram(h, percentiles, samplers.HistogramAggregates{}, false)
+	assert.Empty(t, distributions, "metrics that aren't routed to Datadog shouldn't be flushed as distributions")
+}
+
+func TestGenerateInterMetricsShards(t *testing.T) {
+	s := &Server{interval: 10 * time.Second}
+	shards := make([]WorkerMetrics, 20)
+	for i := range shards {
+		shards[i] = NewWorkerMetrics()
+		name := fmt.Sprintf("shard.%d", i)
+		mk := samplers.MetricKey{Name: name, Type: "histogram"}
+		shards[i].Upsert(mk, samplers.LocalOnly, nil)
+		shards[i].localHistograms[mk].Sample(float64(i), 1)
+	}
+	s.HistogramPercentiles = []float64{0.5}
+
+	metrics, _, series := s.generateInterMetrics(context.Background(), nil, samplers.HistogramAggregates{}, shards, metricsSummary{})
+	require.Len(t, metrics, len(shards))
+	assert.Equal(t, len(shards), series)
+	for i, m := range metrics {
+		assert.Equal(t, fmt.Sprintf("shard.%d.50percentile", i), m.Name, "the shards should be flushed in order")
+		assert.Equal(t, float64(i), m.Value)
+	}
+}
@@ -27,7 +27,8 @@ type forwardFixture struct {
 
 // newForwardingFixture constructs and returns a chain of veneur
 // servers that represent a fairly typical metrics pipeline:
-//     [local veneur] -> [veneur proxy] -> [global veneur]
+//
+//	[local veneur] -> [veneur proxy] -> [global veneur]
 //
 // The globalSink argument is the global veneur's metric sink, and the
 // localConfig argument is the local veneur agent's config, which will
@@ -0,0 +1,224 @@
+package forwardrpc
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"golang.org/x/net/context" // This can be replace with "context" after Go 1.8 support is dropped
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/stripe/veneur/samplers/metricpb"
+)
+
+const (
+	// DefaultMaxMsgSize is the largest message that gRPC servers
+	// receive, and so the largest that clients send, by default.
+	DefaultMaxMsgSize = 4 << 20
+
+	// MaxRecvMsgSizeHeader is the response header that servers of the
+	// Forward service use to tell clients the largest message they
+	// receive.
+	MaxRecvMsgSizeHeader = "veneur-max-recv-msg-size"
+
+	// ReporterHeader is the request header that clients of the Forward
+	// service use to identify the Veneur whose metrics they send.
+	ReporterHeader = "veneur-reporter"
+
+	// IntervalEndHeader is the request header that clients of the
+	// Forward service use to send the end of the flush interval whose
+	// metrics they send, in nanoseconds since the Unix epoch.
+	IntervalEndHeader = "veneur-interval-end"
+)
+
+// Client sends metrics to a Forward service. It splits the metrics into
+// messages that are no larger than both its own limit and the limit
+// that the server last advertised, and optionally compresses them.
+type Client struct {
+	client         ForwardClient
+	compression    string
+	maxSendMsgSize int
+	reporter       string
+	// serverMaxRecvMsgSize is the limit the server advertised in its
+	// last response, or 0 if it hasn't advertised one.
+	serverMaxRecvMsgSize int64
+}
+
+// ClientOption modifies a Client.
+type ClientOption func(*Client)
+
+// WithCompression sets the name of the compressor that the client
+// compresses messages with. By default, messages aren't compressed.
+func WithCompression(name string) ClientOption {
+	return func(c *Client) {
+		c.compression = name
+	}
+}
+
+// WithMaxSendMsgSize sets the largest message that the client sends.
+// The default is DefaultMaxMsgSize.
+func WithMaxSendMsgSize(n int) ClientOption {
+	return func(c *Client) {
+		if n > 0 {
+			c.maxSendMsgSize = n
+		}
+	}
+}
+
+// WithReporter sets the ID that the client identifies the Veneur whose
+// metrics it sends with. By default, the client sends no ID, and
+// passes on the one in the context it sends with, if any.
+func WithReporter(id string) ClientOption {
+	return func(c *Client) {
+		c.reporter = id
+	}
+}
+
+// NewClient returns a Client that sends metrics over conn.
+func NewClient(conn *grpc.ClientConn, opts ...ClientOption) *Client {
+	c := &Client{
+		client:         NewForwardClient(conn),
+		maxSendMsgSize: DefaultMaxMsgSize,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// MaxMsgSize returns the largest message that the client currently
+// sends.
+func (c *Client) MaxMsgSize() int {
+	max := c.maxSendMsgSize
+	if server := int(atomic.LoadInt64(&c.serverMaxRecvMsgSize)); server > 0 && server < max {
+		max = server
+	}
+	return max
+}
+
+// Send sends metrics to the server, in as many messages as it takes to
+// keep each of them within the size limit. If the server rejects a
+// message as too large anyway, the message is split in half and each
+// half is retried. Send returns the number of messages it sent, and the
+// first error that a message failed with; the messages after a failed
+// one are still sent.
+func (c *Client) Send(ctx context.Context, metrics []*metricpb.Metric) (int, error) {
+	if c.reporter != "" {
+		ctx = ContextWithReporter(ctx, c.reporter)
+	}
+	var firstErr error
+	sent := 0
+	queue := SplitMetrics(metrics, c.MaxMsgSize())
+	for len(queue) > 0 {
+		batch := queue[0]
+		queue = queue[1:]
+
+		var header metadata.MD
+		opts := []grpc.CallOption{
+			grpc.Header(&header),
+			grpc.MaxCallSendMsgSize(c.maxSendMsgSize),
+		}
+		if c.compression != "" {
+			opts = append(opts, grpc.UseCompressor(c.compression))
+		}
+		_, err := c.client.SendMetrics(ctx, &MetricList{Metrics: batch}, opts...)
+		c.negotiate(header)
+		if status.Code(err) == codes.ResourceExhausted && len(batch) > 1 {
+			half := len(batch) / 2
+			queue = append([][]*metricpb.Metric{batch[:half], batch[half:]}, queue...)
+			continue
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		sent++
+	}
+	return sent, firstErr
+}
+
+// negotiate records the limit the server advertised in a response's
+// header.
+func (c *Client) negotiate(header metadata.MD) {
+	values := header[MaxRecvMsgSizeHeader]
+	if len(values) == 0 {
+		return
+	}
+	if n, err := strconv.ParseInt(values[0], 10, 64); err == nil && n > 0 {
+		atomic.StoreInt64(&c.serverMaxRecvMsgSize, n)
+	}
+}
+
+// ContextWithReporter returns a context that makes clients send id as
+// the ID of the Veneur whose metrics they send, so that proxies can pass
+// on the ID they received.
+func ContextWithReporter(ctx context.Context, id string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, ReporterHeader, id)
+}
+
+// ReporterFromContext returns the ID of the Veneur whose metrics a
+// server is receiving, or "" if the client didn't send one.
+func ReporterFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if values := md[ReporterHeader]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// ContextWithIntervalEnd returns a context that makes clients send end
+// as the end of the flush interval whose metrics they send, so that
+// servers can tell how late the metrics arrive.
+func ContextWithIntervalEnd(ctx context.Context, end time.Time) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, IntervalEndHeader, strconv.FormatInt(end.UnixNano(), 10))
+}
+
+// IntervalEndFromContext returns the end of the flush interval whose
+// metrics a server is receiving, or the zero time if the client didn't
+// send one.
+func IntervalEndFromContext(ctx context.Context) time.Time {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return time.Time{}
+	}
+	values := md[IntervalEndHeader]
+	if len(values) == 0 {
+		return time.Time{}
+	}
+	nanos, err := strconv.ParseInt(values[0], 10, 64)
+	if err != nil || nanos <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// SplitMetrics splits metrics into lists whose MetricList messages are
+// at most maxSize bytes long when encoded. A metric that is larger than
+// maxSize by itself ends up in a list of its own.
+func SplitMetrics(metrics []*metricpb.Metric, maxSize int) [][]*metricpb.Metric {
+	var lists [][]*metricpb.Metric
+	start, size := 0, 0
+	for i, m := range metrics {
+		n := m.Size()
+		// each metric is a length-delimited field of the list:
+		n += 1 + proto.SizeVarint(uint64(n))
+		if i > start && size+n > maxSize {
+			lists = append(lists, metrics[start:i])
+			start, size = i, 0
+		}
+		size += n
+	}
+	if start < len(metrics) {
+		lists = append(lists, metrics[start:])
+	}
+	return lists
+}
@@ -0,0 +1,195 @@
+package forwardrpc
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/stripe/veneur/samplers/metricpb"
+	metrictest "github.com/stripe/veneur/samplers/metricpb/testutils"
+)
+
+// testServer records the messages it receives, and advertises its
+// message size limit if it's set to.
+type testServer struct {
+	sync.Mutex
+	messages     [][]*metricpb.Metric
+	reporters    []string
+	intervalEnds []time.Time
+	advertise    int
+}
+
+func (s *testServer) SendMetrics(ctx context.Context, mlist *MetricList) (*empty.Empty, error) {
+	if s.advertise > 0 {
+		grpc.SetHeader(ctx, metadata.Pairs(MaxRecvMsgSizeHeader, strconv.Itoa(s.advertise)))
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.messages = append(s.messages, mlist.Metrics)
+	s.reporters = append(s.reporters, ReporterFromContext(ctx))
+	s.intervalEnds = append(s.intervalEnds, IntervalEndFromContext(ctx))
+	return &empty.Empty{}, nil
+}
+
+func (s *testServer) received() (messages int, metrics []*metricpb.Metric) {
+	s.Lock()
+	defer s.Unlock()
+	for _, ms := range s.messages {
+		metrics = append(metrics, ms...)
+	}
+	return len(s.messages), metrics
+}
+
+// startTestServer starts serving ts, and returns a connection to it and
+// a function that stops both.
+func startTestServer(t *testing.T, ts *testServer, opts ...grpc.ServerOption) (*grpc.ClientConn, func()) {
+	srv := grpc.NewServer(opts...)
+	RegisterForwardServer(srv, ts)
+	lis, err := net.Listen("tcp", "127.0.0.1:")
+	require.NoError(t, err)
+	go srv.Serve(lis)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	return conn, func() {
+		conn.Close()
+		srv.Stop()
+	}
+}
+
+func listSize(metrics []*metricpb.Metric) int {
+	return (&MetricList{Metrics: metrics}).Size()
+}
+
+func TestSplitMetrics(t *testing.T) {
+	metrics := metrictest.RandomForwardMetrics(100)
+	total := listSize(metrics)
+
+	assert.Len(t, SplitMetrics(metrics, total), 1)
+	assert.Empty(t, SplitMetrics(nil, total))
+
+	lists := SplitMetrics(metrics, total/4)
+	assert.True(t, len(lists) >= 4, "expected at least 4 lists, got %d", len(lists))
+	var rejoined []*metricpb.Metric
+	for _, list := range lists {
+		assert.True(t, listSize(list) <= total/4, "a list is larger than the limit")
+		rejoined = append(rejoined, list...)
+	}
+	assert.Equal(t, metrics, rejoined)
+
+	lists = SplitMetrics(metrics[:3], 1)
+	assert.Len(t, lists, 3, "metrics larger than the limit should be sent alone")
+}
+
+func TestValidCompression(t *testing.T) {
+	assert.NoError(t, ValidCompression(""))
+	assert.NoError(t, ValidCompression(CompressionSnappy))
+	assert.NoError(t, ValidCompression(CompressionZstd))
+	assert.Error(t, ValidCompression("lz4"))
+}
+
+func TestClientCompression(t *testing.T) {
+	for _, compression := range []string{"", CompressionSnappy, CompressionZstd} {
+		t.Run(compression, func(t *testing.T) {
+			ts := &testServer{}
+			conn, stop := startTestServer(t, ts)
+			defer stop()
+			c := NewClient(conn, WithCompression(compression))
+			expected := metrictest.RandomForwardMetrics(100)
+
+			// twice, to reuse the pooled encoders and decoders:
+			for i := 0; i < 2; i++ {
+				sent, err := c.Send(context.Background(), expected)
+				require.NoError(t, err)
+				assert.Equal(t, 1, sent)
+			}
+			messages, actual := ts.received()
+			assert.Equal(t, 2, messages)
+			assert.Equal(t, append(expected, expected...), actual)
+		})
+	}
+}
+
+func TestClientNegotiatesMaxMsgSize(t *testing.T) {
+	expected := metrictest.RandomForwardMetrics(100)
+	limit := listSize(expected) / 3
+	ts := &testServer{advertise: limit}
+	conn, stop := startTestServer(t, ts)
+	defer stop()
+	c := NewClient(conn, WithCompression(CompressionZstd))
+	assert.Equal(t, DefaultMaxMsgSize, c.MaxMsgSize())
+
+	sent, err := c.Send(context.Background(), expected)
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, limit, c.MaxMsgSize(), "the client should use the server's limit")
+
+	sent, err = c.Send(context.Background(), expected)
+	require.NoError(t, err)
+	assert.True(t, sent >= 3, "expected the batch to be split, got %d messages", sent)
+	_, actual := ts.received()
+	assert.Equal(t, append(expected, expected...), actual)
+}
+
+func TestClientSplitsRejectedMessages(t *testing.T) {
+	expected := metrictest.RandomForwardMetrics(100)
+	// the server doesn't advertise its limit:
+	ts := &testServer{}
+	conn, stop := startTestServer(t, ts, grpc.MaxRecvMsgSize(listSize(expected)/3))
+	defer stop()
+	c := NewClient(conn)
+
+	sent, err := c.Send(context.Background(), expected)
+	require.NoError(t, err)
+	assert.True(t, sent >= 3, "expected the batch to be split, got %d messages", sent)
+	messages, actual := ts.received()
+	assert.Equal(t, sent, messages)
+	assert.Equal(t, expected, actual)
+}
+
+func TestClientReporter(t *testing.T) {
+	ts := &testServer{}
+	conn, stop := startTestServer(t, ts)
+	defer stop()
+
+	_, err := NewClient(conn, WithReporter("local-1")).Send(context.Background(), metrictest.RandomForwardMetrics(1))
+	require.NoError(t, err)
+	// a proxy passes on the ID it received:
+	ctx := ContextWithReporter(context.Background(), "local-2")
+	_, err = NewClient(conn).Send(ctx, metrictest.RandomForwardMetrics(1))
+	require.NoError(t, err)
+	_, err = NewClient(conn).Send(context.Background(), metrictest.RandomForwardMetrics(1))
+	require.NoError(t, err)
+
+	ts.Lock()
+	defer ts.Unlock()
+	assert.Equal(t, []string{"local-1", "local-2", ""}, ts.reporters)
+}
+
+func TestClientIntervalEnd(t *testing.T) {
+	ts := &testServer{}
+	conn, stop := startTestServer(t, ts)
+	defer stop()
+
+	end := time.Unix(1500000000, 123456789)
+	ctx := ContextWithIntervalEnd(context.Background(), end)
+	_, err := NewClient(conn).Send(ctx, metrictest.RandomForwardMetrics(1))
+	require.NoError(t, err)
+	_, err = NewClient(conn).Send(context.Background(), metrictest.RandomForwardMetrics(1))
+	require.NoError(t, err)
+
+	ts.Lock()
+	defer ts.Unlock()
+	require.Len(t, ts.intervalEnds, 2)
+	assert.True(t, end.Equal(ts.intervalEnds[0]), "expected %v, got %v", end, ts.intervalEnds[0])
+	assert.True(t, ts.intervalEnds[1].IsZero(), "clients that send no interval end shouldn't have one")
+}
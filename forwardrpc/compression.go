@@ -0,0 +1,125 @@
+package forwardrpc
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/grpc/encoding"
+)
+
+const (
+	// CompressionSnappy is the name of the snappy compressor.
+	CompressionSnappy = "snappy"
+	// CompressionZstd is the name of the zstd compressor.
+	CompressionZstd = "zstd"
+)
+
+// The compressors are registered with gRPC when this package is
+// imported, so that any server that implements the Forward service can
+// decompress the messages that clients compress with them.
+func init() {
+	encoding.RegisterCompressor(&snappyCompressor{})
+	encoding.RegisterCompressor(newZstdCompressor())
+}
+
+// ValidCompression returns an error if name is not the name of a
+// compressor that forwarding clients can use. The empty name disables
+// compression.
+func ValidCompression(name string) error {
+	switch name {
+	case "", CompressionSnappy, CompressionZstd:
+		return nil
+	}
+	return fmt.Errorf("unknown gRPC compression %q, must be one of %q or %q",
+		name, CompressionSnappy, CompressionZstd)
+}
+
+// snappyCompressor compresses messages with the snappy framing format.
+type snappyCompressor struct{}
+
+func (c *snappyCompressor) Name() string {
+	return CompressionSnappy
+}
+
+func (c *snappyCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return snappy.NewBufferedWriter(w), nil
+}
+
+func (c *snappyCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	return snappy.NewReader(r), nil
+}
+
+// zstdCompressor compresses messages with zstd. Encoders and decoders
+// allocate large windows, so they are pooled between messages.
+type zstdCompressor struct {
+	encoders sync.Pool
+	decoders sync.Pool
+}
+
+func newZstdCompressor() *zstdCompressor {
+	c := &zstdCompressor{}
+	c.encoders.New = func() interface{} {
+		// Forwarded batches are compressed on the flush path, so
+		// trade a little ratio for speed:
+		enc, _ := zstd.NewWriter(nil,
+			zstd.WithEncoderLevel(zstd.SpeedFastest),
+			zstd.WithEncoderConcurrency(1))
+		return &zstdWriter{Encoder: enc, pool: &c.encoders}
+	}
+	c.decoders.New = func() interface{} {
+		// A decoder with a concurrency of 1 decodes synchronously,
+		// and so doesn't need to be closed:
+		dec, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		return &zstdReader{Decoder: dec, pool: &c.decoders}
+	}
+	return c
+}
+
+func (c *zstdCompressor) Name() string {
+	return CompressionZstd
+}
+
+func (c *zstdCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	zw := c.encoders.Get().(*zstdWriter)
+	zw.Reset(w)
+	return zw, nil
+}
+
+func (c *zstdCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	zr := c.decoders.Get().(*zstdReader)
+	if err := zr.Reset(r); err != nil {
+		c.decoders.Put(zr)
+		return nil, err
+	}
+	return zr, nil
+}
+
+// zstdWriter returns its encoder to the pool when it's closed.
+type zstdWriter struct {
+	*zstd.Encoder
+	pool *sync.Pool
+}
+
+func (w *zstdWriter) Close() error {
+	err := w.Encoder.Close()
+	w.pool.Put(w)
+	return err
+}
+
+// zstdReader returns its decoder to the pool once it has read the whole
+// message, since gRPC doesn't close the readers it decompresses with.
+type zstdReader struct {
+	*zstd.Decoder
+	pool *sync.Pool
+}
+
+func (r *zstdReader) Read(p []byte) (int, error) {
+	n, err := r.Decoder.Read(p)
+	if err == io.EOF {
+		r.pool.Put(r)
+	}
+	return n, err
+}
@@ -2,13 +2,15 @@
 // source: forwardrpc/forward.proto
 
 /*
-	Package forwardrpc is a generated protocol buffer package.
+Package forwardrpc is a generated protocol buffer package.
 
-	It is generated from these files:
-		forwardrpc/forward.proto
+It is generated from these files:
 
-	It has these top-level messages:
-		MetricList
+	forwardrpc/forward.proto
+
+It has these top-level messages:
+
+	MetricList
 */
 package forwardrpc
 
@@ -5,13 +5,16 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"reflect"
 	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/protocol"
 	"github.com/stripe/veneur/samplers"
 	"github.com/stripe/veneur/ssf"
 	"github.com/stripe/veneur/trace"
@@ -31,14 +34,16 @@ func handleProxy(p *Proxy) http.Handler {
 			"path": r.URL.Path,
 			"host": r.URL.Host,
 		}).Debug("Importing metrics on proxy")
-		span, jsonMetrics, err := unmarshalMetricsFromHTTP(ctx, p.TraceClient, w, r)
+		span, jsonMetrics, err := unmarshalMetricsFromHTTP(ctx, p.TraceClient, w, r, importLimits{})
 		if err != nil {
 			log.WithError(err).Error("Error unmarshalling metrics in proxy import")
 			return
 		}
 		// the server usually waits for this to return before finalizing the
 		// response, so this part must be done asynchronously
-		go p.ProxyMetrics(span.Attach(ctx), jsonMetrics, strings.SplitN(r.RemoteAddr, ":", 2)[0])
+		query := r.URL.Query()
+		go p.ProxyMetrics(span.Attach(ctx), jsonMetrics, strings.SplitN(r.RemoteAddr, ":", 2)[0],
+			query.Get(importReporterParam), query.Get(importIntervalEndParam), query.Get(importBatchParam))
 	})
 }
 
@@ -59,10 +64,24 @@ func handleSpans(p *Proxy) http.Handler {
 // metrics to the global veneur instance.
 func handleImport(s *Server) http.Handler {
 	return contextHandler(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
-		span, jsonMetrics, err := unmarshalMetricsFromHTTP(ctx, s.TraceClient, w, r)
+		span, jsonMetrics, err := unmarshalMetricsFromHTTP(ctx, s.TraceClient, w, r, s.importLimits)
 		if err != nil {
 			log.WithError(err).Error("Error unmarshalling metrics in global import")
-			span.Add(ssf.Count("import.unmarshal.errors_total", 1, nil))
+			span.Add(ssf.Count("import.unmarshal.errors_total", 1, nil,
+				ssf.Failure("import", ssf.CauseParseError)))
+			return
+		}
+		query := r.URL.Query()
+		if s.reporters != nil {
+			s.reporters.seen(query.Get(importReporterParam), intervalEndFromQuery(query), time.Now())
+		}
+		if s.importDeduper != nil && s.importDeduper.duplicate(importKey(query), time.Now()) {
+			log.WithFields(logrus.Fields{
+				"reporter":     query.Get(importReporterParam),
+				"interval_end": query.Get(importIntervalEndParam),
+				"batch":        query.Get(importBatchParam),
+			}).Debug("Dropping duplicate import")
+			metrics.ReportOne(s.TraceClient, ssf.Count("import.duplicates_total", 1, nil))
 			return
 		}
 		// the server usually waits for this to return before finalizing the
@@ -71,6 +90,49 @@ func handleImport(s *Server) http.Handler {
 	})
 }
 
+// handleSSFImport generates the handler that responds to POST requests
+// submitting SSF spans, encoded as protobuf, JSON or MessagePack
+// according to their Content-Type.
+func handleSSFImport(s *Server) http.Handler {
+	return contextHandler(func(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+		contentType := r.Header.Get("Content-Type")
+		innerLogger := log.WithFields(logrus.Fields{
+			"client":       r.RemoteAddr,
+			"content_type": contentType,
+		})
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, int64(protocol.MaxSSFPacketLength)))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			innerLogger.WithError(err).Warn("Could not read /ssf request")
+			s.Statsd.Count("ssf.error_total", 1, failureTags("ssf", ssf.CauseIOError, "ssf_format:http", "packet_type:unknown", "reason:read"), 1.0)
+			return
+		}
+		s.Statsd.Histogram("ssf.packet_size", float64(len(body)), []string{"ssf_format:http"}, .1)
+
+		spans, err := protocol.ParseSSFBody(contentType, body)
+		if err != nil {
+			if _, ok := err.(*protocol.UnsupportedContentType); ok {
+				http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+				s.Statsd.Count("ssf.error_total", 1, failureTags("ssf", ssf.CauseParseError, "ssf_format:http", "packet_type:unknown", "reason:unknown_content_type"), 1.0)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			innerLogger.WithError(err).Warn("Could not decode /ssf request")
+			s.Statsd.Count("ssf.error_total", 1, failureTags("ssf", ssf.CauseParseError, "ssf_format:http", "packet_type:ssf_metric", "reason:decode"), 1.0)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+		// the server usually waits for this to return before finalizing the
+		// response, so this part must be done asynchronously
+		go func() {
+			for _, span := range spans {
+				s.handleSSF(span, "http")
+			}
+		}()
+	})
+}
+
 func handleTraceRequest(ctx context.Context, client *trace.Client, w http.ResponseWriter, r *http.Request) (*trace.Span, []DatadogTraceSpan, error) {
 	var (
 		traces []DatadogTraceSpan
@@ -93,7 +155,8 @@ func handleTraceRequest(ctx context.Context, client *trace.Client, w http.Respon
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		span.Error(err)
 		innerLogger.WithError(err).Error("Could not decode /spans request")
-		metrics.ReportOne(client, ssf.Count("import.request_error_total", 1, map[string]string{"cause": "json"}))
+		metrics.ReportOne(client, ssf.Count("import.request_error_total", 1, map[string]string{"reason": "json"},
+			ssf.Failure("import", ssf.CauseParseError)))
 		return nil, nil, err
 	}
 
@@ -112,9 +175,60 @@ func handleTraceRequest(ctx context.Context, client *trace.Client, w http.Respon
 	return span, traces, nil
 }
 
+// importLimits bound the /import requests that are decoded; 0 means
+// unbounded.
+type importLimits struct {
+	// maxBodyBytes bounds the size of the request body, as sent
+	// (that is, compressed, if it is).
+	maxBodyBytes int64
+	// maxDecompressedBytes bounds the size of a compressed request
+	// body once it's decompressed.
+	maxDecompressedBytes int64
+	// maxMetrics bounds the number of metrics in a request.
+	maxMetrics int
+}
+
+// errTooManyMetrics is returned by decodeJSONMetrics for requests that
+// hold more metrics than allowed.
+var errTooManyMetrics = errors.New("too many metrics in request")
+
+// errDecompressedTooLarge is returned while decoding requests whose
+// body decompresses to more bytes than allowed.
+var errDecompressedTooLarge = errors.New("decompressed request body is too large")
+
+// limitedReader reads from r until more than n bytes were read, and
+// then fails with err, unlike io.LimitReader, which ends with io.EOF.
+type limitedReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, l.err
+	}
+	// read one byte more than allowed, to tell a body of exactly n
+	// bytes from a larger one:
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) <= l.n {
+		l.n -= int64(n)
+		return n, err
+	}
+	n = int(l.n)
+	l.n = -1
+	return n, l.err
+}
+
 // unmarshalMetricsFromHTTP takes care of the common need to unmarshal a slice of metrics from a request body,
 // dealing with error handling, decoding, tracing, and the associated metrics.
-func unmarshalMetricsFromHTTP(ctx context.Context, client *trace.Client, w http.ResponseWriter, r *http.Request) (*trace.Span, []samplers.JSONMetric, error) {
+// The body is decoded one metric at a time, so that only the metrics
+// and not the whole body are held in memory, and decoding stops as soon
+// as the body turns out to be malformed or over the limits.
+func unmarshalMetricsFromHTTP(ctx context.Context, client *trace.Client, w http.ResponseWriter, r *http.Request, limits importLimits) (*trace.Span, []samplers.JSONMetric, error) {
 	var (
 		jsonMetrics []samplers.JSONMetric
 		body        io.ReadCloser
@@ -133,6 +247,19 @@ func unmarshalMetricsFromHTTP(ctx context.Context, client *trace.Client, w http.
 
 	innerLogger := log.WithField("client", r.RemoteAddr)
 
+	if limits.maxBodyBytes > 0 {
+		if r.ContentLength > limits.maxBodyBytes {
+			err = fmt.Errorf("request body of %d bytes exceeds the limit of %d bytes", r.ContentLength, limits.maxBodyBytes)
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			span.Error(err)
+			innerLogger.WithError(err).Error("Rejecting /import request")
+			span.Add(ssf.Count("import.request_error_total", 1, map[string]string{"reason": "too_large"},
+				ssf.Failure("import", ssf.CauseRejected)))
+			return span, nil, err
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limits.maxBodyBytes)
+	}
+
 	switch encLogger := innerLogger.WithField("encoding", encoding); encoding {
 	case "":
 		body = r.Body
@@ -143,24 +270,38 @@ func unmarshalMetricsFromHTTP(ctx context.Context, client *trace.Client, w http.
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			span.Error(err)
 			encLogger.WithError(err).Error("Could not read compressed request body")
-			span.Add(ssf.Count("import.request_error_total", 1, map[string]string{"cause": "deflate"}))
+			span.Add(ssf.Count("import.request_error_total", 1, map[string]string{"reason": "deflate"},
+				ssf.Failure("import", ssf.CauseParseError)))
 			return span, nil, err
 		}
 		defer body.Close()
+		if limits.maxDecompressedBytes > 0 {
+			// don't let a small request inflate into more
+			// than the limit (a "deflate bomb"):
+			body = ioutil.NopCloser(&limitedReader{r: body, n: limits.maxDecompressedBytes, err: errDecompressedTooLarge})
+		}
 	default:
 		http.Error(w, encoding, http.StatusUnsupportedMediaType)
 		span.Error(errors.New("Could not determine content-encoding of request"))
 		encLogger.Error("Could not determine content-encoding of request")
-		span.Add(ssf.Count("import.request_error_total", 1, map[string]string{"cause": "unknown_content_encoding"}))
+		span.Add(ssf.Count("import.request_error_total", 1, map[string]string{"reason": "unknown_content_encoding"},
+			ssf.Failure("import", ssf.CauseParseError)))
 		return span, nil, err
 	}
 	span.Add(ssf.Count("import.bytes", float32(r.ContentLength), nil))
 
-	if err = json.NewDecoder(body).Decode(&jsonMetrics); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if jsonMetrics, err = decodeJSONMetrics(body, limits.maxMetrics); err != nil {
+		status, reason, cause := http.StatusBadRequest, "json", ssf.CauseParseError
+		if _, tooLarge := err.(*http.MaxBytesError); tooLarge || err == errDecompressedTooLarge {
+			status, reason, cause = http.StatusRequestEntityTooLarge, "too_large", ssf.CauseRejected
+		} else if err == errTooManyMetrics {
+			status, reason, cause = http.StatusRequestEntityTooLarge, "too_many_metrics", ssf.CauseRejected
+		}
+		http.Error(w, err.Error(), status)
 		span.Error(err)
 		innerLogger.WithError(err).Error("Could not decode /import request")
-		span.Add(ssf.Count("import.request_error_total", 1, map[string]string{"cause": "json"}))
+		span.Add(ssf.Count("import.request_error_total", 1, map[string]string{"reason": reason},
+			ssf.Failure("import", cause)))
 		return span, nil, err
 	}
 
@@ -195,6 +336,39 @@ func unmarshalMetricsFromHTTP(ctx context.Context, client *trace.Client, w http.
 	return span, jsonMetrics, nil
 }
 
+// decodeJSONMetrics decodes a JSON array of metrics one element at a
+// time. It returns errTooManyMetrics once the array holds more than
+// maxMetrics elements, unless maxMetrics is 0.
+func decodeJSONMetrics(r io.Reader, maxMetrics int) ([]samplers.JSONMetric, error) {
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if tok == nil {
+		// null is an empty request
+		return nil, nil
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+		return nil, fmt.Errorf("expected an array of metrics, got %v", tok)
+	}
+	var jsonMetrics []samplers.JSONMetric
+	for dec.More() {
+		if maxMetrics > 0 && len(jsonMetrics) >= maxMetrics {
+			return nil, errTooManyMetrics
+		}
+		jsonMetrics = append(jsonMetrics, samplers.JSONMetric{})
+		if err := dec.Decode(&jsonMetrics[len(jsonMetrics)-1]); err != nil {
+			return nil, err
+		}
+	}
+	// consume the closing bracket:
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return jsonMetrics, nil
+}
+
 // nonEmpty returns true if there is at least one non-empty
 // metric
 func nonEmpty(ctx context.Context, client *trace.Client, jsonMetrics []samplers.JSONMetric) bool {
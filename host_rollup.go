@@ -0,0 +1,107 @@
+package veneur
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/samplers/metricpb"
+	"github.com/stripe/veneur/ssf"
+)
+
+// defaultHostRollupTag is the tag whose values are rolled up if
+// host_rollup_tag isn't set.
+const defaultHostRollupTag = "host"
+
+// hostRollup makes a global veneur flush service-level aggregates of
+// per-host metrics: every imported metric that's selected for rollup
+// and tagged with the host tag is imported a second time without that
+// tag, so that it's merged with the same metric from every other host
+// like any other global metric. Counters are summed, histograms and
+// timers have their digests merged, sets are unioned and gauges keep
+// the last value imported.
+type hostRollup struct {
+	tagPrefix string
+	prefixes  []string
+
+	// rolledUp counts the metrics rolled up since the last report.
+	rolledUp int64
+}
+
+func newHostRollup(tag string, prefixes []string) *hostRollup {
+	if tag == "" {
+		tag = defaultHostRollupTag
+	}
+	return &hostRollup{tagPrefix: tag + ":", prefixes: prefixes}
+}
+
+func (r *hostRollup) selects(name string) bool {
+	for _, prefix := range r.prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// withoutHost returns tags without the host tag, or ok=false if they
+// have no host tag. It doesn't modify tags.
+func (r *hostRollup) withoutHost(tags []string) (rolledUp []string, ok bool) {
+	for i, tag := range tags {
+		if !strings.HasPrefix(tag, r.tagPrefix) {
+			continue
+		}
+		rolledUp = make([]string, 0, len(tags)-1)
+		rolledUp = append(rolledUp, tags[:i]...)
+		for _, tag := range tags[i+1:] {
+			if !strings.HasPrefix(tag, r.tagPrefix) {
+				rolledUp = append(rolledUp, tag)
+			}
+		}
+		return rolledUp, true
+	}
+	return nil, false
+}
+
+// rollUpJSON returns metrics followed by the rolled-up copies of the
+// ones selected for rollup.
+func (r *hostRollup) rollUpJSON(metrics []samplers.JSONMetric) []samplers.JSONMetric {
+	n := len(metrics)
+	for i := 0; i < n; i++ {
+		m := metrics[i]
+		if !r.selects(m.Name) {
+			continue
+		}
+		tags, ok := r.withoutHost(m.Tags)
+		if !ok {
+			continue
+		}
+		m.Tags = tags
+		m.JoinedTags = strings.Join(tags, ",")
+		metrics = append(metrics, m)
+	}
+	atomic.AddInt64(&r.rolledUp, int64(len(metrics)-n))
+	return metrics
+}
+
+// rollUpGRPC returns the rolled-up copy of m, or nil if m isn't
+// selected for rollup.
+func (r *hostRollup) rollUpGRPC(m *metricpb.Metric) *metricpb.Metric {
+	if !r.selects(m.Name) {
+		return nil
+	}
+	tags, ok := r.withoutHost(m.Tags)
+	if !ok {
+		return nil
+	}
+	rolledUp := *m
+	rolledUp.Tags = tags
+	atomic.AddInt64(&r.rolledUp, 1)
+	return &rolledUp
+}
+
+func (r *hostRollup) report() []*ssf.SSFSample {
+	return []*ssf.SSFSample{
+		ssf.Count("import.host_rollup_metrics_total", float32(atomic.SwapInt64(&r.rolledUp, 0)), nil),
+	}
+}
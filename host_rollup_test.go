@@ -0,0 +1,50 @@
+package veneur
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/samplers/metricpb"
+)
+
+func TestHostRollupJSON(t *testing.T) {
+	r := newHostRollup("", []string{"api."})
+	metrics := []samplers.JSONMetric{
+		{MetricKey: samplers.MetricKey{Name: "api.requests", Type: "counter", JoinedTags: "host:a,service:api"}, Tags: []string{"host:a", "service:api"}},
+		{MetricKey: samplers.MetricKey{Name: "api.latency", Type: "histogram", JoinedTags: "service:api"}, Tags: []string{"service:api"}},
+		{MetricKey: samplers.MetricKey{Name: "db.queries", Type: "counter", JoinedTags: "host:a"}, Tags: []string{"host:a"}},
+	}
+	rolled := r.rollUpJSON(metrics)
+	require.Len(t, rolled, 4, "only selected metrics with a host tag should be rolled up")
+	assert.Equal(t, "api.requests", rolled[3].Name)
+	assert.Equal(t, []string{"service:api"}, rolled[3].Tags)
+	assert.Equal(t, "service:api", rolled[3].JoinedTags)
+	assert.Equal(t, []string{"host:a", "service:api"}, rolled[0].Tags, "the per-host metric should be left alone")
+
+	samples := r.report()
+	require.Len(t, samples, 1)
+	assert.Equal(t, float32(1), samples[0].Value)
+	assert.Equal(t, float32(0), r.report()[0].Value, "reporting should reset the count")
+}
+
+func TestHostRollupGRPC(t *testing.T) {
+	r := newHostRollup("instance", []string{"api."})
+	m := &metricpb.Metric{
+		Name:  "api.requests",
+		Type:  metricpb.Type_Counter,
+		Tags:  []string{"instance:i-1", "service:api"},
+		Value: &metricpb.Metric_Counter{Counter: &metricpb.CounterValue{Value: 2}},
+	}
+	rolled := r.rollUpGRPC(m)
+	require.NotNil(t, rolled)
+	assert.Equal(t, []string{"service:api"}, rolled.Tags)
+	assert.Equal(t, m.Value, rolled.Value)
+	assert.Equal(t, []string{"instance:i-1", "service:api"}, m.Tags, "the per-host metric should be left alone")
+
+	assert.Nil(t, r.rollUpGRPC(&metricpb.Metric{Name: "api.requests", Tags: []string{"host:a"}}),
+		"metrics without the rollup tag shouldn't be rolled up")
+	assert.Nil(t, r.rollUpGRPC(&metricpb.Metric{Name: "db.queries", Tags: []string{"instance:i-1"}}),
+		"metrics that aren't selected shouldn't be rolled up")
+}
@@ -0,0 +1,170 @@
+package veneur
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Cloud metadata services that can supply the instance's hostname.
+const (
+	hostnameCloudAWS   = "aws"
+	hostnameCloudAzure = "azure"
+	hostnameCloudGCP   = "gcp"
+)
+
+const hostnameMetadataTimeout = 2 * time.Second
+
+// hostnameResolver determines the hostname veneur reports when none
+// is configured. It tries, in order: an environment variable, a cloud
+// provider's metadata service and os.Hostname, using the first that
+// gives a non-empty answer.
+type hostnameResolver struct {
+	envVar      string
+	cloud       string
+	fqdn        bool
+	stripDomain bool
+
+	getenv     func(string) string
+	osHostname func() (string, error)
+	lookupFQDN func(string) (string, error)
+	httpClient *http.Client
+
+	awsURL   string
+	azureURL string
+	gcpURL   string
+}
+
+func newHostnameResolver(c *Config) *hostnameResolver {
+	return &hostnameResolver{
+		envVar:      c.HostnameEnvVar,
+		cloud:       c.HostnameCloudMetadata,
+		fqdn:        c.HostnameFqdn,
+		stripDomain: c.HostnameStripDomain,
+
+		getenv:     os.Getenv,
+		osHostname: os.Hostname,
+		lookupFQDN: lookupFQDN,
+		httpClient: &http.Client{Timeout: hostnameMetadataTimeout},
+
+		awsURL:   "http://169.254.169.254/latest",
+		azureURL: "http://169.254.169.254/metadata",
+		gcpURL:   "http://metadata.google.internal/computeMetadata/v1",
+	}
+}
+
+// resolve returns the hostname and the source it came from, or
+// empty strings if no source could supply one.
+func (r *hostnameResolver) resolve() (hostname string, source string) {
+	if r.envVar != "" {
+		if name := strings.TrimSpace(r.getenv(r.envVar)); name != "" {
+			return r.shorten(name), "env"
+		}
+	}
+	if r.cloud != "" {
+		name, err := r.fromCloud()
+		if err != nil {
+			log.WithError(err).WithField("provider", r.cloud).
+				Warn("Could not get the hostname from the cloud metadata service")
+		} else if name != "" {
+			return r.shorten(name), r.cloud
+		}
+	}
+	name, err := r.osHostname()
+	if err != nil || name == "" {
+		return "", ""
+	}
+	if r.fqdn {
+		fqdn, err := r.lookupFQDN(name)
+		if err != nil {
+			log.WithError(err).WithField("hostname", name).
+				Warn("Could not look up the fully-qualified hostname")
+		} else if fqdn != "" {
+			name = fqdn
+		}
+	}
+	return r.shorten(name), "os"
+}
+
+// shorten strips the domain off name if the resolver is configured to.
+func (r *hostnameResolver) shorten(name string) string {
+	if !r.stripDomain || net.ParseIP(name) != nil {
+		return name
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		return name[:i]
+	}
+	return name
+}
+
+func (r *hostnameResolver) fromCloud() (string, error) {
+	switch r.cloud {
+	case hostnameCloudAWS:
+		// IMDSv2 needs a session token; fall back to IMDSv1 if
+		// the instance doesn't hand one out.
+		header := http.Header{}
+		tokenReq, err := http.NewRequest(http.MethodPut, r.awsURL+"/api/token", nil)
+		if err != nil {
+			return "", err
+		}
+		tokenReq.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+		if token, err := r.fetch(tokenReq); err == nil {
+			header.Set("X-aws-ec2-metadata-token", token)
+		}
+		return r.get(r.awsURL+"/meta-data/local-hostname", header)
+	case hostnameCloudAzure:
+		return r.get(r.azureURL+"/instance/compute/name?api-version=2021-02-01&format=text",
+			http.Header{"Metadata": []string{"true"}})
+	case hostnameCloudGCP:
+		return r.get(r.gcpURL+"/instance/hostname",
+			http.Header{"Metadata-Flavor": []string{"Google"}})
+	default:
+		return "", fmt.Errorf("unknown hostname_cloud_metadata provider %q", r.cloud)
+	}
+}
+
+func (r *hostnameResolver) get(url string, header http.Header) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header = header
+	return r.fetch(req)
+}
+
+func (r *hostnameResolver) fetch(req *http.Request) (string, error) {
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %s", req.URL, resp.Status)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// lookupFQDN returns the canonical name of the host called name, like
+// `hostname -f` does.
+func lookupFQDN(name string) (string, error) {
+	addrs, err := net.LookupHost(name)
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		names, err := net.LookupAddr(addr)
+		if err != nil || len(names) == 0 {
+			continue
+		}
+		return strings.TrimSuffix(names[0], "."), nil
+	}
+	return "", fmt.Errorf("no reverse DNS names for %s", name)
+}
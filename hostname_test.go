@@ -0,0 +1,127 @@
+package veneur
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testHostnameResolver(env map[string]string) *hostnameResolver {
+	r := newHostnameResolver(&Config{})
+	r.getenv = func(k string) string { return env[k] }
+	r.osHostname = func() (string, error) { return "os-host", nil }
+	r.lookupFQDN = func(name string) (string, error) { return name + ".example.com", nil }
+	return r
+}
+
+func TestHostnameResolverOrder(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("gcp-host.c.project.internal\n"))
+	}))
+	defer srv.Close()
+
+	r := testHostnameResolver(map[string]string{"NODE_NAME": "env-host"})
+	r.gcpURL = srv.URL
+	name, source := r.resolve()
+	assert.Equal(t, "os-host", name)
+	assert.Equal(t, "os", source)
+
+	r.cloud = hostnameCloudGCP
+	name, source = r.resolve()
+	assert.Equal(t, "gcp-host.c.project.internal", name)
+	assert.Equal(t, "gcp", source)
+
+	r.envVar = "NODE_NAME"
+	name, source = r.resolve()
+	assert.Equal(t, "env-host", name)
+	assert.Equal(t, "env", source)
+
+	r.envVar = "UNSET"
+	r.stripDomain = true
+	name, _ = r.resolve()
+	assert.Equal(t, "gcp-host", name, "should strip the domain off the metadata service's hostname")
+}
+
+func TestHostnameResolverAWS(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/token":
+			w.Write([]byte("sekrit"))
+		case "/meta-data/local-hostname":
+			if r.Header.Get("X-aws-ec2-metadata-token") != "sekrit" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("ip-10-0-0-1.ec2.internal"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	r := testHostnameResolver(nil)
+	r.cloud = hostnameCloudAWS
+	r.awsURL = srv.URL
+	name, source := r.resolve()
+	assert.Equal(t, "ip-10-0-0-1.ec2.internal", name)
+	assert.Equal(t, "aws", source)
+}
+
+func TestHostnameResolverCloudFallback(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	r := testHostnameResolver(nil)
+	r.cloud = hostnameCloudAzure
+	r.azureURL = srv.URL
+	name, source := r.resolve()
+	assert.Equal(t, "os-host", name, "should fall back to os.Hostname when the metadata service fails")
+	assert.Equal(t, "os", source)
+
+	r.cloud = "digitalocean"
+	name, _ = r.resolve()
+	assert.Equal(t, "os-host", name, "should fall back to os.Hostname for unknown providers")
+}
+
+func TestHostnameResolverFQDN(t *testing.T) {
+	r := testHostnameResolver(nil)
+	r.fqdn = true
+	name, _ := r.resolve()
+	assert.Equal(t, "os-host.example.com", name)
+
+	r.stripDomain = true
+	name, _ = r.resolve()
+	assert.Equal(t, "os-host", name)
+
+	r.stripDomain = false
+	r.lookupFQDN = func(string) (string, error) { return "", errors.New("no DNS") }
+	name, _ = r.resolve()
+	assert.Equal(t, "os-host", name, "should keep the short name if the lookup fails")
+}
+
+func TestHostnameResolverStripDomainIP(t *testing.T) {
+	r := testHostnameResolver(map[string]string{"HOST_IP": "10.0.0.1"})
+	r.envVar = "HOST_IP"
+	r.stripDomain = true
+	name, _ := r.resolve()
+	assert.Equal(t, "10.0.0.1", name, "should leave IP addresses alone")
+}
+
+func TestHostnameConfigOverride(t *testing.T) {
+	c := Config{
+		Hostname:            "configured.example.com",
+		HostnameEnvVar:      "PATH",
+		HostnameStripDomain: true,
+	}
+	c.applyDefaults()
+	assert.Equal(t, "configured.example.com", c.Hostname)
+}
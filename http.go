@@ -38,7 +38,43 @@ func (s *Server) Handler() http.Handler {
 		w.Write([]byte("ok\n"))
 	})
 
+	mux.HandleFuncC(pat.Get("/autoscaling"), handleAutoscaling(s))
+
 	mux.Handle(pat.Post("/import"), handleImport(s))
+	mux.Handle(pat.Post("/ssf"), handleSSFImport(s))
+	if s.reporters != nil {
+		mux.HandleFuncC(pat.Get("/import/reporters"), handleImportReporters(s))
+	}
+
+	if s.metricSchemas != nil {
+		mux.HandleFuncC(pat.Get("/schema/violations"), handleSchemaViolations(s))
+	}
+
+	if s.metricBlocklist != nil {
+		mux.HandleFuncC(pat.Get("/blocklist"), handleBlocklist(s.metricBlocklist.blocklist))
+		mux.HandleFuncC(pat.Put("/blocklist"), handleBlocklistReplace(s.metricBlocklist.blocklist))
+	}
+	if s.spanBlocklist != nil {
+		mux.HandleFuncC(pat.Get("/blocklist/spans"), handleBlocklist(s.spanBlocklist.blocklist))
+		mux.HandleFuncC(pat.Put("/blocklist/spans"), handleBlocklistReplace(s.spanBlocklist.blocklist))
+	}
+
+	if s.serviceMap != nil {
+		mux.HandleFuncC(pat.Get("/service_map"), handleServiceMap(s))
+	}
+
+	if s.packetCaptureEnabled {
+		mux.HandleFuncC(pat.Get("/debug/packets"), handlePacketCaptures(s))
+		mux.HandleFuncC(pat.Post("/debug/packets/start"), handlePacketCaptureStart(s))
+		mux.HandleFuncC(pat.Post("/debug/packets/stop"), handlePacketCaptureStop(s))
+		mux.HandleFuncC(pat.Get("/debug/packets/download"), handlePacketCaptureDownload(s))
+	}
+
+	if s.sinkPauses != nil {
+		mux.HandleFuncC(pat.Get("/sinks"), handleSinkPauses(s))
+		mux.HandleFuncC(pat.Post("/sinks/pause"), handleSinkPause(s))
+		mux.HandleFuncC(pat.Post("/sinks/resume"), handleSinkResume(s))
+	}
 
 	mux.Handle(pat.Get("/debug/pprof/cmdline"), http.HandlerFunc(pprof.Cmdline))
 	mux.Handle(pat.Get("/debug/pprof/profile"), http.HandlerFunc(pprof.Profile))
@@ -55,6 +91,10 @@ func (s *Server) ImportMetrics(ctx context.Context, jsonMetrics []samplers.JSONM
 	span, _ := trace.StartSpanFromContext(ctx, "veneur.opentracing.import.import_metrics")
 	defer span.Finish()
 
+	if s.hostRollup != nil {
+		jsonMetrics = s.hostRollup.rollUpJSON(jsonMetrics)
+	}
+
 	// we have a slice of json metrics that we need to divide up across the workers
 	// we don't want to push one metric at a time (too much channel contention
 	// and goroutine switching) and we also don't want to allocate a temp
@@ -65,7 +105,7 @@ func (s *Server) ImportMetrics(ctx context.Context, jsonMetrics []samplers.JSONM
 	sortedIter := newJSONMetricsByWorker(jsonMetrics, len(s.Workers))
 	for sortedIter.Next() {
 		nextChunk, workerIndex := sortedIter.Chunk()
-		s.Workers[workerIndex].ImportChan <- nextChunk
+		s.Workers[workerIndex].IngestJSON(nextChunk)
 	}
 	metrics.ReportOne(s.TraceClient, ssf.Timing("import.response_duration_ns", time.Since(span.Start), time.Nanosecond, map[string]string{"part": "merge"}))
 }
@@ -11,12 +11,14 @@ import (
 	"net/http/httptrace"
 	"net/url"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stripe/veneur/ssf"
 	"github.com/stripe/veneur/trace"
+	"github.com/stripe/veneur/trace/metrics"
 )
 
 var tracer = trace.GlobalTracer
@@ -138,6 +140,121 @@ func (tripper *TraceRoundTripper) RoundTrip(req *http.Request) (*http.Response,
 	return tripper.inner.RoundTrip(req)
 }
 
+// WithHeader returns a client that sends requests like c does, with
+// the header set to value. Sinks use it for headers that PostHelper
+// doesn't set, like tenant IDs.
+func WithHeader(c *http.Client, key, value string) *http.Client {
+	withHeader := *c
+	withHeader.Transport = &headerRoundTripper{inner: c.Transport, key: key, value: value}
+	return &withHeader
+}
+
+type headerRoundTripper struct {
+	inner      http.RoundTripper
+	key, value string
+}
+
+func (rt *headerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// RoundTrippers mustn't modify the request they're given.
+	req2 := new(http.Request)
+	*req2 = *req
+	req2.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		req2.Header[k] = v
+	}
+	req2.Header.Set(rt.key, rt.value)
+	inner := rt.inner
+	if inner == nil {
+		inner = http.DefaultTransport
+	}
+	return inner.RoundTrip(req2)
+}
+
+// WithResponseMetrics returns a client that sends requests like c
+// does, and reports the status code of every response the sink gets,
+// along with the rate limit quota that the backend reports in the
+// response's headers, so that quota exhaustion shows up before
+// requests start failing with 429s.
+func WithResponseMetrics(c *http.Client, tc *trace.Client, sink string) *http.Client {
+	withMetrics := *c
+	withMetrics.Transport = &responseMetricsRoundTripper{inner: c.Transport, tc: tc, sink: sink}
+	return &withMetrics
+}
+
+type responseMetricsRoundTripper struct {
+	inner http.RoundTripper
+	tc    *trace.Client
+	sink  string
+}
+
+func (rt *responseMetricsRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	inner := rt.inner
+	if inner == nil {
+		inner = http.DefaultTransport
+	}
+	resp, err := inner.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	metrics.Report(rt.tc, ResponseSamples(resp, rt.sink))
+	return resp, nil
+}
+
+// quotaHeaders maps the headers that backends report their rate limit
+// quota in to the gauges they are reported as. Both the common
+// X-RateLimit-* headers and the IETF draft's RateLimit-* headers are
+// understood; -Reset is in seconds.
+var quotaHeaders = []struct {
+	header, gauge string
+}{
+	{"X-RateLimit-Remaining", "sink.ratelimit_remaining"},
+	{"RateLimit-Remaining", "sink.ratelimit_remaining"},
+	{"X-RateLimit-Limit", "sink.ratelimit_limit"},
+	{"RateLimit-Limit", "sink.ratelimit_limit"},
+	{"X-RateLimit-Reset", "sink.ratelimit_reset_seconds"},
+	{"RateLimit-Reset", "sink.ratelimit_reset_seconds"},
+	{"Retry-After", "sink.retry_after_seconds"},
+}
+
+// ResponseSamples returns the samples that describe a sink's response:
+// a count of its status code, and gauges of the rate limit quota in
+// its headers.
+func ResponseSamples(resp *http.Response, sink string) *ssf.Samples {
+	samples := &ssf.Samples{}
+	samples.Add(ssf.Count("sink.http_responses_total", 1, map[string]string{
+		"sink":         sink,
+		"status_code":  strconv.Itoa(resp.StatusCode),
+		"status_class": strconv.Itoa(resp.StatusCode/100) + "xx",
+	}))
+	seen := map[string]bool{}
+	for _, q := range quotaHeaders {
+		if seen[q.gauge] {
+			continue
+		}
+		value := resp.Header.Get(q.header)
+		if value == "" {
+			continue
+		}
+		// Some backends send a list of quotas, like "100, 100;w=60":
+		// the first is the one that applies.
+		if i := strings.IndexAny(value, ",;"); i >= 0 && q.header != "Retry-After" {
+			value = value[:i]
+		}
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			// Retry-After can also be an HTTP date:
+			date, dateErr := http.ParseTime(value)
+			if dateErr != nil {
+				continue
+			}
+			parsed = time.Until(date).Seconds()
+		}
+		seen[q.gauge] = true
+		samples.Add(ssf.Gauge(q.gauge, float32(parsed), map[string]string{"sink": sink}))
+	}
+	return samples
+}
+
 func mergeTags(tags map[string]string, k, v string) map[string]string {
 	ret := make(map[string]string, len(tags)+1)
 	for k, v := range tags {
@@ -147,6 +264,29 @@ func mergeTags(tags map[string]string, k, v string) map[string]string {
 	return ret
 }
 
+// StatusCause returns the failure cause (see ssf.Failure) for an
+// unsuccessful HTTP response status code.
+func StatusCause(code int) string {
+	switch code {
+	case http.StatusTooManyRequests:
+		return ssf.CauseRateLimited
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return ssf.CauseSinkTimeout
+	default:
+		return ssf.CauseRejected
+	}
+}
+
+// StatusError is returned by PostHelper when the endpoint responds
+// with a status other than 200, 202 or 204.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e StatusError) Error() string {
+	return strconv.Itoa(e.StatusCode)
+}
+
 // PostHelper is shared code for POSTing to an endpoint, that consumes JSON, is zlib-
 // compressed, that returns 202 on success, that has a small response
 // action as a string used for statsd metric names and log messages emitted from
@@ -164,6 +304,13 @@ func PostHelper(ctx context.Context, httpClient *http.Client, tc *trace.Client,
 	// attach this field to all the logs we generate
 	innerLogger := log.WithField("action", action)
 
+	// failures are attributed to the sink we're flushing to, if
+	// there is one:
+	component := action
+	if sink, ok := extraTags["sink"]; ok {
+		component = sink
+	}
+
 	marshalStart := time.Now()
 	var (
 		bodyBuffer bytes.Buffer
@@ -178,7 +325,8 @@ func PostHelper(ctx context.Context, httpClient *http.Client, tc *trace.Client,
 	}
 	if err := encoder.Encode(bodyObject); err != nil {
 		span.Error(err)
-		span.Add(ssf.Count(action+".error_total", 1, mergeTags(extraTags, "cause", "json")))
+		span.Add(ssf.Count(action+".error_total", 1, mergeTags(extraTags, "reason", "json"),
+			ssf.Failure(component, ssf.CauseEncodeError)))
 		innerLogger.WithError(err).Error("Could not render JSON")
 		return err
 	}
@@ -186,7 +334,8 @@ func PostHelper(ctx context.Context, httpClient *http.Client, tc *trace.Client,
 		// don't forget to flush leftover compressed bytes to the buffer
 		if err := compressor.Close(); err != nil {
 			span.Error(err)
-			span.Add(ssf.Count(action+".error_total", 1, mergeTags(extraTags, "cause", "compress")))
+			span.Add(ssf.Count(action+".error_total", 1, mergeTags(extraTags, "reason", "compress"),
+				ssf.Failure(component, ssf.CauseEncodeError)))
 			innerLogger.WithError(err).Error("Could not finalize compression")
 			return err
 		}
@@ -201,7 +350,8 @@ func PostHelper(ctx context.Context, httpClient *http.Client, tc *trace.Client,
 	req, err := http.NewRequest(method, endpoint, &bodyBuffer)
 	if err != nil {
 		span.Error(err)
-		span.Add(ssf.Count(action+".error_total", 1, mergeTags(extraTags, "cause", "construct")))
+		span.Add(ssf.Count(action+".error_total", 1, mergeTags(extraTags, "reason", "construct"),
+			ssf.Failure(component, ssf.CauseEncodeError)))
 		innerLogger.WithError(err).Error("Could not construct request")
 		return err
 	}
@@ -225,13 +375,18 @@ func PostHelper(ctx context.Context, httpClient *http.Client, tc *trace.Client,
 
 	resp, err := httpClient.Do(req)
 	if err != nil {
+		cause := ssf.CauseIOError
 		if urlErr, ok := err.(*url.Error); ok {
+			if urlErr.Timeout() {
+				cause = ssf.CauseSinkTimeout
+			}
 			// if the error has the url in it, then retrieve the inner error
 			// and ditch the url (which might contain secrets)
 			err = urlErr.Err
 		}
 		span.Error(err)
-		span.Add(ssf.Count(action+".error_total", 1, mergeTags(extraTags, "cause", "io")))
+		span.Add(ssf.Count(action+".error_total", 1, mergeTags(extraTags, "reason", "io"),
+			ssf.Failure(component, cause)))
 		// Log at Warn level instead of Error, because we don't want to create
 		// Sentry events for these (they're only important in large numbers, and
 		// we already have Datadog metrics for them)
@@ -248,7 +403,8 @@ func PostHelper(ctx context.Context, httpClient *http.Client, tc *trace.Client,
 		// this error is not fatal, since we only need the body for reporting
 		// purposes
 		span.Error(err)
-		span.Add(ssf.Count(action+".error_total", 1, mergeTags(extraTags, "cause", "readresponse")))
+		span.Add(ssf.Count(action+".error_total", 1, mergeTags(extraTags, "reason", "readresponse"),
+			ssf.Failure(component, ssf.CauseIOError)))
 		innerLogger.WithError(err).Error("Could not read response body")
 	}
 	resultLogger := innerLogger.WithFields(logrus.Fields{
@@ -260,10 +416,11 @@ func PostHelper(ctx context.Context, httpClient *http.Client, tc *trace.Client,
 		"response":         string(responseBody),
 	})
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
-		err := fmt.Errorf("%v", resp.StatusCode)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNoContent {
+		err := StatusError{StatusCode: resp.StatusCode}
 		span.Error(err)
-		span.Add(ssf.Count(action+".error_total", 1, mergeTags(extraTags, "cause", strconv.Itoa(resp.StatusCode))))
+		span.Add(ssf.Count(action+".error_total", 1, mergeTags(extraTags, "reason", strconv.Itoa(resp.StatusCode)),
+			ssf.Failure(component, StatusCause(resp.StatusCode))))
 		resultLogger.WithError(err).Warn("Could not POST")
 		return err
 	}
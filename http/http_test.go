@@ -0,0 +1,45 @@
+package http
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseSamples(t *testing.T) {
+	resp := &http.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Header: http.Header{
+			"X-Ratelimit-Remaining": {"0"},
+			"X-Ratelimit-Limit":     {"100"},
+			"Ratelimit-Limit":       {"50"},
+			"Ratelimit-Reset":       {"30, 60;w=60"},
+			"Retry-After":           {time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)},
+		},
+	}
+	samples := ResponseSamples(resp, "datadog")
+
+	values := map[string]float32{}
+	for _, s := range samples.Batch {
+		assert.Equal(t, "datadog", s.Tags["sink"])
+		values[s.Name] = s.Value
+		if s.Name == "sink.http_responses_total" {
+			assert.Equal(t, "429", s.Tags["status_code"])
+			assert.Equal(t, "4xx", s.Tags["status_class"])
+		}
+	}
+	assert.Equal(t, float32(1), values["sink.http_responses_total"])
+	assert.Equal(t, float32(0), values["sink.ratelimit_remaining"])
+	assert.Equal(t, float32(100), values["sink.ratelimit_limit"], "X-RateLimit-* should take precedence")
+	assert.Equal(t, float32(30), values["sink.ratelimit_reset_seconds"], "the first quota in a list should apply")
+	assert.InDelta(t, 60, values["sink.retry_after_seconds"], 2)
+}
+
+func TestResponseSamplesWithoutQuota(t *testing.T) {
+	samples := ResponseSamples(&http.Response{StatusCode: http.StatusAccepted, Header: http.Header{}}, "datadog")
+	if assert.Len(t, samples.Batch, 1) {
+		assert.Equal(t, "2xx", samples.Batch[0].Tags["status_class"])
+	}
+}
@@ -3,6 +3,7 @@ package veneur
 import (
 	"bytes"
 	"compress/gzip"
+	"compress/zlib"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -14,6 +15,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -256,6 +258,85 @@ func TestServerImportEmptyListError(t *testing.T) {
 	testServerImportHelper(t, data)
 }
 
+func TestServerImportLimits(t *testing.T) {
+	var metrics bytes.Buffer
+	err := json.NewEncoder(&metrics).Encode([]samplers.JSONMetric{
+		{MetricKey: samplers.MetricKey{Name: "a", Type: "counter"}, Value: []byte{1}},
+		{MetricKey: samplers.MetricKey{Name: "b", Type: "counter"}, Value: []byte{1}},
+		{MetricKey: samplers.MetricKey{Name: "c", Type: "counter"}, Value: []byte{1}},
+	})
+	assert.NoError(t, err)
+	body := metrics.String()
+	unterminated := strings.TrimSuffix(strings.TrimSpace(body), "]")
+
+	tests := []struct {
+		name   string
+		limits importLimits
+		body   io.Reader
+		code   int
+	}{
+		{"within limits", importLimits{maxBodyBytes: int64(len(body)), maxMetrics: 3}, strings.NewReader(body), http.StatusAccepted},
+		{"too many metrics", importLimits{maxMetrics: 2}, strings.NewReader(body), http.StatusRequestEntityTooLarge},
+		{"content length too large", importLimits{maxBodyBytes: 10}, strings.NewReader(body), http.StatusRequestEntityTooLarge},
+		// without a Content-Length, the body is cut off while it's read:
+		{"body too large", importLimits{maxBodyBytes: 10}, ioutil.NopCloser(strings.NewReader(body)), http.StatusRequestEntityTooLarge},
+		{"not an array", importLimits{}, strings.NewReader(`{"name": "a"}`), http.StatusBadRequest},
+		{"malformed element", importLimits{}, strings.NewReader(`[{"name": "a"}, garbage`), http.StatusBadRequest},
+		{"unterminated", importLimits{}, strings.NewReader(unterminated), http.StatusBadRequest},
+	}
+
+	config := localConfig()
+	s := setupVeneurServer(t, config, nil, nil, nil)
+	defer s.Shutdown()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s.importLimits = test.limits
+			r := httptest.NewRequest(http.MethodPost, "/import", test.body)
+			w := httptest.NewRecorder()
+			handleImport(s).ServeHTTP(w, r)
+			assert.Equal(t, test.code, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestServerImportDecompressedLimit(t *testing.T) {
+	var metrics bytes.Buffer
+	err := json.NewEncoder(&metrics).Encode([]samplers.JSONMetric{
+		{MetricKey: samplers.MetricKey{Name: "a", Type: "counter"}, Value: []byte{1}},
+		{MetricKey: samplers.MetricKey{Name: "b", Type: "counter"}, Value: []byte{1}},
+	})
+	assert.NoError(t, err)
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	_, err = zw.Write(metrics.Bytes())
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+
+	tests := []struct {
+		name  string
+		limit int64
+		code  int
+	}{
+		{"unlimited", 0, http.StatusAccepted},
+		{"within the limit", int64(metrics.Len()), http.StatusAccepted},
+		{"over the limit", 10, http.StatusRequestEntityTooLarge},
+	}
+
+	config := localConfig()
+	s := setupVeneurServer(t, config, nil, nil, nil)
+	defer s.Shutdown()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s.importLimits = importLimits{maxDecompressedBytes: test.limit}
+			r := httptest.NewRequest(http.MethodPost, "/import", bytes.NewReader(compressed.Bytes()))
+			r.Header.Set("Content-Encoding", "deflate")
+			w := httptest.NewRecorder()
+			handleImport(s).ServeHTTP(w, r)
+			assert.Equal(t, test.code, w.Code, w.Body.String())
+		})
+	}
+}
+
 func TestGeneralHealthCheck(t *testing.T) {
 	r := httptest.NewRequest(http.MethodGet, "/healthcheck", nil)
 
@@ -358,6 +439,31 @@ func TestVersion(t *testing.T) {
 	assert.Equal(t, string(bts), VERSION, "received invalid version")
 }
 
+func TestServerImportSSF(t *testing.T) {
+	config := localConfig()
+	s := setupVeneurServer(t, config, nil, nil, nil)
+	defer s.Shutdown()
+	handler := s.Handler()
+
+	tests := []struct {
+		contentType string
+		body        string
+		code        int
+	}{
+		{"application/json", `{"metrics": [{"metric": 0, "name": "foo.bar", "value": 1}]}`, http.StatusAccepted},
+		{"application/json", `{"metrics": "foo"}`, http.StatusBadRequest},
+		{"application/msgpack", "\x81\xa7service\xa3foo", http.StatusAccepted},
+		{"text/plain", "foo.bar:1|c", http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/ssf", strings.NewReader(tt.body))
+		r.Header.Set("Content-Type", tt.contentType)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		assert.Equal(t, tt.code, w.Code, "wrong response code for %s body %q", tt.contentType, tt.body)
+	}
+}
+
 func testServerImportHelper(t *testing.T, data interface{}) {
 	var b bytes.Buffer
 	err := json.NewEncoder(&b).Encode(data)
@@ -392,7 +498,7 @@ func BenchmarkNewSortableJSONMetrics(b *testing.B) {
 
 	w := httptest.NewRecorder()
 
-	_, jsonMetrics, err := unmarshalMetricsFromHTTP(context.Background(), trace.DefaultClient, w, r)
+	_, jsonMetrics, err := unmarshalMetricsFromHTTP(context.Background(), trace.DefaultClient, w, r, importLimits{})
 	assert.NoError(b, err)
 
 	b.ResetTimer()
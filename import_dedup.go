@@ -0,0 +1,45 @@
+package veneur
+
+import (
+	"net/url"
+	"time"
+)
+
+// importDeduper drops /import requests whose key (see importKey) it
+// has already seen within a short window, like those that a forwarding
+// veneur retries after its first attempt timed out, so that their
+// metrics aren't counted twice.
+type importDeduper struct {
+	window time.Duration
+	keys   dedupGenerations[string]
+}
+
+func newImportDeduper(window time.Duration) *importDeduper {
+	return &importDeduper{window: window}
+}
+
+// importKey identifies an /import request by the veneur that sent it,
+// the end of the interval its metrics are from, and its batch within
+// that interval. Unlike a hash of its body, the key doesn't match a
+// request of another interval that happens to hold the same metrics.
+// It returns "" if the request doesn't carry all three, like those of
+// veneurs that predate them.
+func importKey(query url.Values) string {
+	reporter := query.Get(importReporterParam)
+	intervalEnd := query.Get(importIntervalEndParam)
+	batch := query.Get(importBatchParam)
+	if reporter == "" || intervalEnd == "" || batch == "" {
+		return ""
+	}
+	return reporter + "\x00" + intervalEnd + "\x00" + batch
+}
+
+// duplicate returns whether a request with the same key was seen
+// within the window, and remembers the key if it wasn't. Requests
+// without a key are never duplicates.
+func (d *importDeduper) duplicate(key string, now time.Time) bool {
+	if key == "" {
+		return false
+	}
+	return d.keys.seen(key, now, d.window)
+}
@@ -0,0 +1,47 @@
+package veneur
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportDeduper(t *testing.T) {
+	d := newImportDeduper(10 * time.Second)
+	start := time.Now()
+
+	assert.False(t, d.duplicate("abc", start))
+	assert.True(t, d.duplicate("abc", start.Add(time.Second)))
+	assert.False(t, d.duplicate("def", start.Add(time.Second)))
+
+	// A hash is remembered for at least the window, even across a
+	// rotation:
+	assert.True(t, d.duplicate("abc", start.Add(15*time.Second)))
+	// and forgotten after two windows:
+	assert.False(t, d.duplicate("abc", start.Add(40*time.Second)))
+
+	// Requests without a key are never duplicates:
+	assert.False(t, d.duplicate("", start))
+	assert.False(t, d.duplicate("", start))
+}
+
+func TestImportKey(t *testing.T) {
+	query := url.Values{
+		importReporterParam:    {"local-1"},
+		importIntervalEndParam: {"1500000000000000000"},
+		importBatchParam:       {"0"},
+	}
+	key := importKey(query)
+	assert.NotEmpty(t, key)
+
+	query.Set(importBatchParam, "100")
+	assert.NotEqual(t, key, importKey(query), "batches of an interval should have different keys")
+	query.Set(importBatchParam, "0")
+	query.Set(importIntervalEndParam, "1500000010000000000")
+	assert.NotEqual(t, key, importKey(query), "intervals should have different keys")
+
+	query.Del(importBatchParam)
+	assert.Empty(t, importKey(query), "requests without a batch shouldn't have a key")
+}
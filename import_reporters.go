@@ -0,0 +1,258 @@
+package veneur
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/ssf"
+	"golang.org/x/net/context"
+)
+
+// importReporterParam is the query parameter that local veneurs forwarding
+// over HTTP identify themselves with.
+const importReporterParam = "reporter"
+
+// importIntervalEndParam is the query parameter that local veneurs
+// forwarding over HTTP send the end of the flush interval whose metrics
+// they forward with, in nanoseconds since the Unix epoch.
+const importIntervalEndParam = "interval_end"
+
+// importBatchParam is the query parameter that local veneurs forwarding
+// over HTTP number the requests of an interval with, when they split
+// its metrics across several requests.
+const importBatchParam = "batch"
+
+// worstReporters is how many of the reporters that lag or miss intervals
+// the most are reported in self-metrics every interval.
+const worstReporters = 10
+
+// reporterTracker records which local veneurs forwarded metrics to a
+// global veneur in each flush interval, so that the global veneur can
+// tell a drop in traffic apart from local veneurs failing to forward.
+// A local veneur is expected to report in every interval until it
+// hasn't for longer than expiry. It also records how late each local
+// veneur's metrics arrive after the end of the interval they're from,
+// and how many intervals in a row it missed, so that the overloaded
+// local veneurs that hold back the global aggregates can be found.
+type reporterTracker struct {
+	expiry time.Duration
+
+	mtx sync.Mutex
+	// current holds the reporters seen since the last report, with
+	// the longest lag of their metrics.
+	current map[string]*reporterArrival
+	// reporters holds the state of every expected reporter.
+	reporters map[string]*reporterState
+}
+
+// reporterArrival is how late a reporter's metrics arrived in an
+// interval.
+type reporterArrival struct {
+	lag time.Duration
+	// timed is whether the reporter sent the end of the interval its
+	// metrics are from, which older veneurs don't.
+	timed bool
+}
+
+type reporterState struct {
+	// lastSeen is the last report that the reporter was seen in.
+	lastSeen time.Time
+	// lag is the longest lag of the reporter's metrics in the last
+	// interval that it was seen in.
+	lag time.Duration
+	// missed counts the intervals in a row that the reporter didn't
+	// forward metrics in, and missedTotal all of them.
+	missed      int
+	missedTotal int
+}
+
+// reporterStatus is the state of a reporter, as served by the admin
+// API.
+type reporterStatus struct {
+	Reporter             string    `json:"reporter"`
+	LastSeen             time.Time `json:"last_seen"`
+	LagNanos             int64     `json:"lag_ns"`
+	MissedIntervals      int       `json:"missed_intervals"`
+	MissedIntervalsTotal int       `json:"missed_intervals_total"`
+}
+
+func newReporterTracker(expiry time.Duration) *reporterTracker {
+	return &reporterTracker{
+		expiry:    expiry,
+		current:   map[string]*reporterArrival{},
+		reporters: map[string]*reporterState{},
+	}
+}
+
+// seen records that the reporter forwarded metrics in this interval,
+// which arrived at the given time. intervalEnd is the end of the
+// interval that the metrics are from, or the zero time if the reporter
+// didn't send it.
+func (t *reporterTracker) seen(reporter string, intervalEnd, arrived time.Time) {
+	if reporter == "" {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	arrival, ok := t.current[reporter]
+	if !ok {
+		arrival = &reporterArrival{}
+		t.current[reporter] = arrival
+	}
+	if intervalEnd.IsZero() {
+		return
+	}
+	lag := arrived.Sub(intervalEnd)
+	if lag < 0 {
+		// the clocks of the two veneurs are skewed
+		lag = 0
+	}
+	if lag > arrival.lag {
+		arrival.lag = lag
+	}
+	arrival.timed = true
+}
+
+// report ends the interval, returning how many of the expected
+// reporters forwarded metrics in it, how late their metrics were, and
+// the reporters that lagged or missed intervals the most. It returns
+// nothing if no reporter is expected, as on veneurs that nothing
+// forwards to.
+func (t *reporterTracker) report(now time.Time) []*ssf.SSFSample {
+	t.mtx.Lock()
+	current := t.current
+	t.current = map[string]*reporterArrival{}
+	var samples []*ssf.SSFSample
+	for reporter, arrival := range current {
+		state, ok := t.reporters[reporter]
+		if !ok {
+			state = &reporterState{}
+			t.reporters[reporter] = state
+		}
+		state.lastSeen = now
+		state.missed = 0
+		state.lag = arrival.lag
+		if arrival.timed {
+			samples = append(samples, ssf.Timing("import.reporter_lag_ns", arrival.lag, time.Nanosecond, nil))
+		}
+	}
+	var missing []string
+	for reporter, state := range t.reporters {
+		if now.Sub(state.lastSeen) > t.expiry {
+			delete(t.reporters, reporter)
+			continue
+		}
+		if _, ok := current[reporter]; !ok {
+			state.missed++
+			state.missedTotal++
+			missing = append(missing, reporter)
+		}
+	}
+	expected := len(t.reporters)
+	worst := t.statuses(worstReporters)
+	t.mtx.Unlock()
+
+	if expected == 0 {
+		return nil
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		log.WithFields(logrus.Fields{
+			"missing":  missing,
+			"expected": expected,
+		}).Debug("Some local veneurs didn't forward metrics this interval")
+	}
+	samples = append(samples,
+		ssf.Gauge("import.reporters_expected", float32(expected), nil),
+		ssf.Gauge("import.reporters_total", float32(len(current)), nil),
+		ssf.Gauge("import.completeness_ratio", float32(len(current))/float32(expected), nil),
+		ssf.Count("import.reporter_missed_intervals_total", float32(len(missing)), nil),
+	)
+	for _, status := range worst {
+		if status.LagNanos == 0 && status.MissedIntervals == 0 {
+			// the rest of the reporters are all on time
+			break
+		}
+		tags := map[string]string{"reporter": status.Reporter}
+		samples = append(samples,
+			ssf.Gauge("import.worst_reporter_lag_ns", float32(status.LagNanos), tags),
+			ssf.Gauge("import.worst_reporter_missed_intervals", float32(status.MissedIntervals), tags),
+		)
+	}
+	return samples
+}
+
+// list returns the state of up to limit expected reporters, worst
+// first; a limit of 0 or less returns all of them.
+func (t *reporterTracker) list(limit int) []reporterStatus {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	return t.statuses(limit)
+}
+
+// statuses returns the state of up to limit reporters, ordered by the
+// intervals they missed in a row, then by their lag. t.mtx must be
+// held.
+func (t *reporterTracker) statuses(limit int) []reporterStatus {
+	statuses := make([]reporterStatus, 0, len(t.reporters))
+	for reporter, state := range t.reporters {
+		statuses = append(statuses, reporterStatus{
+			Reporter:             reporter,
+			LastSeen:             state.lastSeen,
+			LagNanos:             state.lag.Nanoseconds(),
+			MissedIntervals:      state.missed,
+			MissedIntervalsTotal: state.missedTotal,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		a, b := statuses[i], statuses[j]
+		if a.MissedIntervals != b.MissedIntervals {
+			return a.MissedIntervals > b.MissedIntervals
+		}
+		if a.LagNanos != b.LagNanos {
+			return a.LagNanos > b.LagNanos
+		}
+		return a.Reporter < b.Reporter
+	})
+	if limit > 0 && len(statuses) > limit {
+		statuses = statuses[:limit]
+	}
+	return statuses
+}
+
+// handleImportReporters serves the state of the veneurs that forward
+// to this one as JSON, worst first. The optional limit parameter caps
+// how many are served.
+func handleImportReporters(s *Server) func(context.Context, http.ResponseWriter, *http.Request) {
+	return func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		limit := 0
+		if param := r.URL.Query().Get("limit"); param != "" {
+			var err error
+			limit, err = strconv.Atoi(param)
+			if err != nil || limit < 0 {
+				http.Error(w, "limit must be a non-negative integer", http.StatusBadRequest)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s.reporters.list(limit)); err != nil {
+			log.WithError(err).Warn("Could not encode import reporters")
+		}
+	}
+}
+
+// intervalEndFromQuery parses the interval end that a local veneur
+// forwarding over HTTP sent, returning the zero time if it sent none.
+func intervalEndFromQuery(query url.Values) time.Time {
+	nanos, err := strconv.ParseInt(query.Get(importIntervalEndParam), 10, 64)
+	if err != nil || nanos <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
@@ -0,0 +1,140 @@
+package veneur
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/ssf"
+)
+
+func reporterGauges(samples []*ssf.SSFSample) map[string]float32 {
+	gauges := map[string]float32{}
+	for _, sample := range samples {
+		if sample.Metric != ssf.SSFSample_GAUGE || sample.Tags["reporter"] != "" {
+			continue
+		}
+		gauges[sample.Name] = sample.Value
+	}
+	return gauges
+}
+
+func TestReporterTracker(t *testing.T) {
+	tracker := newReporterTracker(time.Minute)
+	start := time.Now()
+	assert.Empty(t, tracker.report(start), "nothing should be reported before a reporter is seen")
+
+	for _, reporter := range []string{"a", "b", "c", "d", "a", ""} {
+		tracker.seen(reporter, time.Time{}, start)
+	}
+	gauges := reporterGauges(tracker.report(start))
+	assert.Equal(t, float32(4), gauges["import.reporters_expected"])
+	assert.Equal(t, float32(4), gauges["import.reporters_total"])
+	assert.Equal(t, float32(1), gauges["import.completeness_ratio"])
+
+	tracker.seen("a", time.Time{}, start)
+	tracker.seen("b", time.Time{}, start)
+	tracker.seen("c", time.Time{}, start)
+	gauges = reporterGauges(tracker.report(start.Add(10 * time.Second)))
+	assert.Equal(t, float32(4), gauges["import.reporters_expected"])
+	assert.Equal(t, float32(3), gauges["import.reporters_total"])
+	assert.Equal(t, float32(0.75), gauges["import.completeness_ratio"])
+
+	tracker.seen("a", time.Time{}, start)
+	gauges = reporterGauges(tracker.report(start.Add(65 * time.Second)))
+	assert.Equal(t, float32(3), gauges["import.reporters_expected"], "reporters that haven't reported for longer than the expiry shouldn't be expected")
+	assert.Equal(t, float32(1), gauges["import.reporters_total"])
+
+	samples := tracker.report(start.Add(10 * time.Minute))
+	require.Empty(t, samples, "all reporters should have expired")
+}
+
+func TestReporterTrackerLag(t *testing.T) {
+	tracker := newReporterTracker(time.Minute)
+	end := time.Now()
+	tracker.seen("on-time", end, end.Add(100*time.Millisecond))
+	tracker.seen("slow", end, end.Add(time.Second))
+	tracker.seen("slow", end, end.Add(3*time.Second))
+	tracker.seen("skewed", end, end.Add(-time.Second))
+	tracker.seen("old", time.Time{}, end)
+	samples := tracker.report(end.Add(10 * time.Second))
+
+	var lags []float32
+	worstLags := map[string]float32{}
+	for _, sample := range samples {
+		switch sample.Name {
+		case "import.reporter_lag_ns":
+			lags = append(lags, sample.Value)
+		case "import.worst_reporter_lag_ns":
+			worstLags[sample.Tags["reporter"]] = sample.Value
+		}
+	}
+	assert.ElementsMatch(t, []float32{float32(100 * time.Millisecond), float32(3 * time.Second), 0}, lags,
+		"reporters that send no interval end shouldn't have a lag")
+	assert.Equal(t, map[string]float32{
+		"slow":    float32(3 * time.Second),
+		"on-time": float32(100 * time.Millisecond),
+	}, worstLags, "reporters without lag shouldn't be among the worst")
+
+	// "slow" misses two intervals, and "on-time" one:
+	tracker.seen("old", time.Time{}, end)
+	tracker.seen("skewed", end, end)
+	tracker.report(end.Add(20 * time.Second))
+	tracker.seen("old", time.Time{}, end)
+	tracker.seen("skewed", end, end)
+	tracker.seen("on-time", end.Add(20*time.Second), end.Add(20*time.Second))
+	samples = tracker.report(end.Add(30 * time.Second))
+	missed := map[string]float32{}
+	for _, sample := range samples {
+		if sample.Name == "import.worst_reporter_missed_intervals" {
+			missed[sample.Tags["reporter"]] = sample.Value
+		}
+	}
+	assert.Equal(t, map[string]float32{"slow": 2}, missed, "reporters that caught up shouldn't be among the worst")
+
+	statuses := tracker.list(0)
+	require.Len(t, statuses, 4)
+	assert.Equal(t, "slow", statuses[0].Reporter, "the reporter that missed the most intervals in a row should be first")
+	assert.Equal(t, 2, statuses[0].MissedIntervals)
+	for _, status := range statuses[1:] {
+		assert.Equal(t, 0, status.MissedIntervals)
+		if status.Reporter == "on-time" {
+			assert.Equal(t, 1, status.MissedIntervalsTotal)
+		}
+	}
+	assert.Len(t, tracker.list(1), 1)
+}
+
+func TestImportReportersEndpoint(t *testing.T) {
+	s := setupVeneurServer(t, globalConfig(), nil, nil, nil)
+	defer s.Shutdown()
+	handler := s.Handler()
+
+	request := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	end := time.Now()
+	for i := 1; i <= 3; i++ {
+		s.reporters.seen(fmt.Sprintf("local-%d", i), end, end.Add(time.Duration(i)*time.Second))
+	}
+	s.reporters.report(end.Add(5 * time.Second))
+
+	w := request("/import/reporters?limit=2")
+	require.Equal(t, http.StatusOK, w.Code)
+	var statuses []reporterStatus
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&statuses))
+	require.Len(t, statuses, 2)
+	assert.Equal(t, "local-3", statuses[0].Reporter, "the reporter that lagged the most should be first")
+	assert.Equal(t, (3 * time.Second).Nanoseconds(), statuses[0].LagNanos)
+	assert.Equal(t, "local-2", statuses[1].Reporter)
+
+	assert.Equal(t, http.StatusBadRequest, request("/import/reporters?limit=few").Code)
+}
@@ -1,6 +1,11 @@
 package importsrv
 
-import "github.com/stripe/veneur/trace"
+import (
+	"time"
+
+	"github.com/stripe/veneur/samplers/metricpb"
+	"github.com/stripe/veneur/trace"
+)
 
 // WithTraceClient sets the trace client for the server.  Otherwise it uses
 // trace.DefaultClient.
@@ -9,3 +14,33 @@ func WithTraceClient(c *trace.Client) Option {
 		opts.traceClient = c
 	}
 }
+
+// WithMaxRecvMsgSize sets the largest message that the server receives,
+// which it advertises to its clients. Otherwise it uses
+// forwardrpc.DefaultMaxMsgSize.
+func WithMaxRecvMsgSize(n int) Option {
+	return func(opts *options) {
+		if n > 0 {
+			opts.maxRecvMsgSize = n
+		}
+	}
+}
+
+// WithExtraMetric calls f on every metric the server receives. If f
+// returns a metric, the server ingests it along with the one it
+// received.
+func WithExtraMetric(f func(*metricpb.Metric) *metricpb.Metric) Option {
+	return func(opts *options) {
+		opts.extraMetric = f
+	}
+}
+
+// WithReporterSeen calls f with the ID of the Veneur whose metrics the
+// server receives, for every request whose client sent one, along with
+// the end of the interval that the metrics are from (or the zero time
+// if the client didn't send it) and the time the request arrived.
+func WithReporterSeen(f func(reporter string, intervalEnd, arrived time.Time)) Option {
+	return func(opts *options) {
+		opts.reporterSeen = f
+	}
+}
@@ -8,12 +8,14 @@ package importsrv
 import (
 	"fmt"
 	"net"
+	"strconv"
 	"time"
 
 	"github.com/golang/protobuf/ptypes/empty"
 	"github.com/segmentio/fasthash/fnv1a"
 	"golang.org/x/net/context" // This can be replace with "context" after Go 1.8 support is dropped
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 
 	"github.com/stripe/veneur/forwardrpc"
 	"github.com/stripe/veneur/samplers/metricpb"
@@ -41,7 +43,10 @@ type Server struct {
 }
 
 type options struct {
-	traceClient *trace.Client
+	traceClient    *trace.Client
+	maxRecvMsgSize int
+	extraMetric    func(*metricpb.Metric) *metricpb.Metric
+	reporterSeen   func(string, time.Time, time.Time)
 }
 
 // Option is returned by functions that serve as options to New, like
@@ -52,9 +57,8 @@ type Option func(*options)
 // output to.
 func New(metricOuts []MetricIngester, opts ...Option) *Server {
 	res := &Server{
-		Server:     grpc.NewServer(),
 		metricOuts: metricOuts,
-		opts:       &options{},
+		opts:       &options{maxRecvMsgSize: forwardrpc.DefaultMaxMsgSize},
 	}
 
 	for _, opt := range opts {
@@ -65,6 +69,8 @@ func New(metricOuts []MetricIngester, opts ...Option) *Server {
 		res.opts.traceClient = trace.DefaultClient
 	}
 
+	res.Server = grpc.NewServer(grpc.MaxRecvMsgSize(res.opts.maxRecvMsgSize))
+
 	forwardrpc.RegisterForwardServer(res.Server, res)
 
 	return res
@@ -103,6 +109,17 @@ func (s *Server) SendMetrics(ctx context.Context, mlist *forwardrpc.MetricList)
 	span.SetTag("protocol", "grpc")
 	defer span.ClientFinish(s.opts.traceClient)
 
+	// Tell the client how large the messages it sends can be. This
+	// fails outside of a gRPC call, where there's no client to tell.
+	_ = grpc.SetHeader(ctx, metadata.Pairs(forwardrpc.MaxRecvMsgSizeHeader,
+		strconv.Itoa(s.opts.maxRecvMsgSize)))
+
+	if s.opts.reporterSeen != nil {
+		if reporter := forwardrpc.ReporterFromContext(ctx); reporter != "" {
+			s.opts.reporterSeen(reporter, forwardrpc.IntervalEndFromContext(ctx), time.Now())
+		}
+	}
+
 	dests := make([][]*metricpb.Metric, len(s.metricOuts))
 
 	// group metrics by their destination
@@ -110,6 +127,13 @@ func (s *Server) SendMetrics(ctx context.Context, mlist *forwardrpc.MetricList)
 	for _, m := range mlist.Metrics {
 		workerIdx := s.hashMetric(m) % uint32(len(dests))
 		dests[workerIdx] = append(dests[workerIdx], m)
+		if s.opts.extraMetric == nil {
+			continue
+		}
+		if extra := s.opts.extraMetric(m); extra != nil {
+			workerIdx := s.hashMetric(extra) % uint32(len(dests))
+			dests[workerIdx] = append(dests[workerIdx], extra)
+		}
 	}
 	span.Add(ssf.Timing(responseDurationMetric, time.Since(groupStart), time.Nanosecond, responseGroupTags))
 
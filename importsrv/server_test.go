@@ -4,14 +4,19 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"net"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stripe/veneur/forwardrpc"
 	"github.com/stripe/veneur/samplers/metricpb"
 	metrictest "github.com/stripe/veneur/samplers/metricpb/testutils"
 	"github.com/stripe/veneur/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
 type testMetricIngester struct {
@@ -69,6 +74,47 @@ func TestSendMetrics_Empty(t *testing.T) {
 		"any metrics")
 }
 
+func TestSendMetrics_ExtraMetric(t *testing.T) {
+	ingester := &testMetricIngester{}
+	extra := &metricpb.Metric{Name: "test.counter", Type: metricpb.Type_Counter}
+	s := New([]MetricIngester{ingester}, WithExtraMetric(func(m *metricpb.Metric) *metricpb.Metric {
+		if m.Name == "test.counter" {
+			return extra
+		}
+		return nil
+	}))
+
+	inputs := []*metricpb.Metric{
+		&metricpb.Metric{Name: "test.counter", Type: metricpb.Type_Counter, Tags: []string{"host:a"}},
+		&metricpb.Metric{Name: "test.gauge", Type: metricpb.Type_Gauge},
+	}
+	s.SendMetrics(context.Background(), &forwardrpc.MetricList{Metrics: inputs})
+
+	assert.ElementsMatch(t, []*metricpb.Metric{inputs[0], extra, inputs[1]}, ingester.metrics)
+}
+
+func TestSendMetrics_ReporterSeen(t *testing.T) {
+	var reporters []string
+	var intervalEnds []time.Time
+	s := New([]MetricIngester{&testMetricIngester{}}, WithReporterSeen(func(reporter string, intervalEnd, arrived time.Time) {
+		reporters = append(reporters, reporter)
+		intervalEnds = append(intervalEnds, intervalEnd)
+	}))
+
+	end := time.Unix(1500000000, 0)
+	ctx := metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(forwardrpc.ReporterHeader, "local-1",
+			forwardrpc.IntervalEndHeader, strconv.FormatInt(end.UnixNano(), 10)))
+	s.SendMetrics(ctx, &forwardrpc.MetricList{})
+	ctx = metadata.NewIncomingContext(context.Background(),
+		metadata.Pairs(forwardrpc.ReporterHeader, "local-2"))
+	s.SendMetrics(ctx, &forwardrpc.MetricList{})
+	s.SendMetrics(context.Background(), &forwardrpc.MetricList{})
+
+	assert.Equal(t, []string{"local-1", "local-2"}, reporters, "requests without an ID shouldn't be reported")
+	assert.Equal(t, []time.Time{end, {}}, intervalEnds)
+}
+
 func TestOptions_WithTraceClient(t *testing.T) {
 	c, err := trace.NewClient(trace.DefaultVeneurAddress)
 	if err != nil {
@@ -80,6 +126,33 @@ func TestOptions_WithTraceClient(t *testing.T) {
 		"set the trace client")
 }
 
+// Test that the server advertises the largest message it receives, and
+// that clients split their batches to fit it.
+func TestOptions_WithMaxRecvMsgSize(t *testing.T) {
+	expected := metrictest.RandomForwardMetrics(100)
+	limit := (&forwardrpc.MetricList{Metrics: expected}).Size() / 2
+
+	ingester := &testMetricIngester{}
+	s := New([]MetricIngester{ingester}, WithMaxRecvMsgSize(limit))
+	ln, err := net.Listen("tcp", "127.0.0.1:")
+	require.NoError(t, err)
+	go s.Server.Serve(ln)
+	defer s.Stop()
+
+	conn, err := grpc.Dial(ln.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	c := forwardrpc.NewClient(conn, forwardrpc.WithCompression(forwardrpc.CompressionZstd))
+
+	// The first batch is too large, and is split after the server
+	// rejects it.
+	_, err = c.Send(context.Background(), expected)
+	require.NoError(t, err)
+	assert.Equal(t, limit, c.MaxMsgSize(), "the client should have "+
+		"learned the server's limit")
+	assert.ElementsMatch(t, expected, ingester.metrics)
+}
+
 type noopChannelMetricIngester struct {
 	in   chan []*metricpb.Metric
 	quit chan struct{}
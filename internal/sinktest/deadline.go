@@ -0,0 +1,49 @@
+// Package sinktest holds helpers for testing sinks.
+package sinktest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Deadline is how long AssertFlushDeadline gives a flush, and
+// deadlineSlack how much longer the flush may take to give up.
+const (
+	Deadline      = 50 * time.Millisecond
+	deadlineSlack = 200 * time.Millisecond
+)
+
+// HangingServer returns a server that never answers requests, until
+// the returned function is called to stop it.
+func HangingServer() (*httptest.Server, func()) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	return srv, func() {
+		close(release)
+		srv.Close()
+	}
+}
+
+// AssertFlushDeadline calls flush with a context that expires after
+// Deadline, and asserts that flush gives up on a HangingServer as soon
+// as it expires.
+func AssertFlushDeadline(t *testing.T, flush func(ctx context.Context)) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), Deadline)
+	defer cancel()
+	start := time.Now()
+	flush(ctx)
+	took := time.Since(start)
+	assert.True(t, took >= Deadline, "flushing took %v, so it didn't wait for the server", took)
+	assert.True(t, took < Deadline+deadlineSlack, "flushing took %v, but should give up once the deadline passes", took)
+}
@@ -1,11 +1,17 @@
 package veneur
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -89,3 +95,233 @@ func (kd *KubernetesDiscoverer) GetDestinationsForService(serviceName string) ([
 	}
 	return ips, nil
 }
+
+const (
+	// kubernetesLeaderAnnotation is the annotation on the leader
+	// election ConfigMap that holds the current leader record.
+	kubernetesLeaderAnnotation = "veneur.stripe.com/leader"
+
+	kubernetesNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// kubernetesLeaderRecord is the lease that the leader holds in its
+// ConfigMap annotation.
+type kubernetesLeaderRecord struct {
+	HolderIdentity string        `json:"holderIdentity"`
+	LeaseDuration  time.Duration `json:"leaseDuration"`
+	AcquireTime    time.Time     `json:"acquireTime"`
+	RenewTime      time.Time     `json:"renewTime"`
+}
+
+// configMapClient is the subset of the Kubernetes ConfigMap API
+// that the KubernetesElector uses.
+type configMapClient interface {
+	Get(name string, options metav1.GetOptions) (*v1.ConfigMap, error)
+	Create(*v1.ConfigMap) (*v1.ConfigMap, error)
+	Update(*v1.ConfigMap) (*v1.ConfigMap, error)
+}
+
+// KubernetesElector is an Elector that holds a lease in an
+// annotation on a ConfigMap in the pod's namespace. Updates to the
+// ConfigMap are guarded by its resource version, so only one
+// instance can take over an expired lease.
+type KubernetesElector struct {
+	configMaps    configMapClient
+	name          string
+	identity      string
+	leaseDuration time.Duration
+
+	// The record we last saw and when we saw it. Lease expiry is
+	// judged by the local clock only, so clock skew between nodes
+	// can't cause two leaders.
+	observedRaw  string
+	observedTime time.Time
+
+	mtx    sync.Mutex
+	resign chan struct{}
+}
+
+// NewKubernetesElector creates an Elector that uses the ConfigMap
+// with the given name in the pod's own namespace for its lease.
+func NewKubernetesElector(name string, identity string, leaseDuration time.Duration) (*KubernetesElector, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	namespace := metav1.NamespaceDefault
+	if ns, err := ioutil.ReadFile(kubernetesNamespaceFile); err == nil {
+		namespace = strings.TrimSpace(string(ns))
+	}
+	return newKubernetesElector(clientset.CoreV1().ConfigMaps(namespace), name, identity, leaseDuration), nil
+}
+
+func newKubernetesElector(configMaps configMapClient, name string, identity string, leaseDuration time.Duration) *KubernetesElector {
+	return &KubernetesElector{
+		configMaps:    configMaps,
+		name:          name,
+		identity:      identity,
+		leaseDuration: leaseDuration,
+	}
+}
+
+// Campaign attempts to acquire the lease every third of the lease
+// duration until it succeeds.
+func (ke *KubernetesElector) Campaign(stop <-chan struct{}) (<-chan struct{}, error) {
+	ticker := time.NewTicker(ke.leaseDuration / 3)
+	defer ticker.Stop()
+	for {
+		ok, err := ke.tryAcquireOrRenew(time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			break
+		}
+		select {
+		case <-stop:
+			return nil, nil
+		case <-ticker.C:
+		}
+	}
+
+	lost := make(chan struct{})
+	resign := make(chan struct{})
+	ke.mtx.Lock()
+	ke.resign = resign
+	ke.mtx.Unlock()
+	go ke.renew(lost, resign)
+	return lost, nil
+}
+
+// renew keeps renewing the lease until resign is closed, and closes
+// lost if the lease could not be renewed in time.
+func (ke *KubernetesElector) renew(lost chan<- struct{}, resign <-chan struct{}) {
+	defer close(lost)
+	ticker := time.NewTicker(ke.leaseDuration / 3)
+	defer ticker.Stop()
+	lastRenewed := time.Now()
+	for {
+		select {
+		case <-resign:
+			return
+		case now := <-ticker.C:
+			ok, err := ke.tryAcquireOrRenew(now)
+			if ok {
+				lastRenewed = now
+				continue
+			}
+			if err == nil {
+				// Someone else holds the lease now.
+				return
+			}
+			log.WithError(err).WithField("configmap", ke.name).
+				Warn("Could not renew leader lease")
+			// Give up before the lease actually expires, so we
+			// never run duties alongside a new leader:
+			if now.Sub(lastRenewed) > ke.leaseDuration*2/3 {
+				return
+			}
+		}
+	}
+}
+
+// Resign stops renewing the lease and releases it, so another
+// instance can take over without waiting for it to expire.
+func (ke *KubernetesElector) Resign() error {
+	ke.mtx.Lock()
+	resign := ke.resign
+	ke.resign = nil
+	ke.mtx.Unlock()
+	if resign == nil {
+		return nil
+	}
+	close(resign)
+
+	cm, err := ke.configMaps.Get(ke.name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	var record kubernetesLeaderRecord
+	if err := json.Unmarshal([]byte(cm.Annotations[kubernetesLeaderAnnotation]), &record); err != nil {
+		return err
+	}
+	if record.HolderIdentity != ke.identity {
+		return nil
+	}
+	record.HolderIdentity = ""
+	return ke.updateRecord(cm, record)
+}
+
+// tryAcquireOrRenew takes over the lease if it is unheld or
+// expired, or renews it if we hold it already. It returns true if
+// this instance holds the lease afterwards.
+func (ke *KubernetesElector) tryAcquireOrRenew(now time.Time) (bool, error) {
+	record := kubernetesLeaderRecord{
+		HolderIdentity: ke.identity,
+		LeaseDuration:  ke.leaseDuration,
+		AcquireTime:    now,
+		RenewTime:      now,
+	}
+
+	cm, err := ke.configMaps.Get(ke.name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		raw, err := json.Marshal(record)
+		if err != nil {
+			return false, err
+		}
+		_, err = ke.configMaps.Create(&v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        ke.name,
+				Annotations: map[string]string{kubernetesLeaderAnnotation: string(raw)},
+			},
+		})
+		if errors.IsAlreadyExists(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var old kubernetesLeaderRecord
+	if raw, ok := cm.Annotations[kubernetesLeaderAnnotation]; ok {
+		if err := json.Unmarshal([]byte(raw), &old); err != nil {
+			return false, err
+		}
+		if raw != ke.observedRaw {
+			ke.observedRaw = raw
+			ke.observedTime = now
+		}
+	}
+	if old.HolderIdentity != "" && old.HolderIdentity != ke.identity &&
+		now.Before(ke.observedTime.Add(old.LeaseDuration)) {
+		return false, nil
+	}
+	if old.HolderIdentity == ke.identity {
+		record.AcquireTime = old.AcquireTime
+	}
+
+	err = ke.updateRecord(cm, record)
+	if errors.IsConflict(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (ke *KubernetesElector) updateRecord(cm *v1.ConfigMap, record kubernetesLeaderRecord) error {
+	raw, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if cm.Annotations == nil {
+		cm.Annotations = map[string]string{}
+	}
+	cm.Annotations[kubernetesLeaderAnnotation] = string(raw)
+	_, err = ke.configMaps.Update(cm)
+	return err
+}
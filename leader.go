@@ -0,0 +1,149 @@
+package veneur
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/stripe/veneur/ssf"
+)
+
+// An Elector determines which one of several veneur instances is
+// the leader: the one instance in a fleet that runs duties which
+// must happen only once per fleet. See consul.go and kubernetes.go
+// for implementations.
+type Elector interface {
+	// Campaign blocks until this instance is elected leader, or
+	// until stop is closed, in which case it returns a nil
+	// channel. The returned channel is closed when leadership is
+	// lost.
+	Campaign(stop <-chan struct{}) (<-chan struct{}, error)
+
+	// Resign gives up leadership, if this instance holds it.
+	Resign() error
+}
+
+// A LeaderDuty is run only on the elected leader, and is started
+// again on whichever instance takes over when leadership changes.
+// It must return once stop is closed.
+type LeaderDuty func(stop <-chan struct{})
+
+type namedLeaderDuty struct {
+	name string
+	run  LeaderDuty
+}
+
+// RegisterLeaderDuty adds a duty that runs while this server is the
+// leader of its fleet. Duties must be registered before the server
+// is started. If no leader election is configured, the server always
+// considers itself the leader.
+func (s *Server) RegisterLeaderDuty(name string, duty LeaderDuty) {
+	s.leaderMtx.Lock()
+	defer s.leaderMtx.Unlock()
+	s.leaderDuties = append(s.leaderDuties, namedLeaderDuty{name: name, run: duty})
+}
+
+// IsLeader returns true if this server currently holds leadership
+// of its fleet.
+func (s *Server) IsLeader() bool {
+	return atomic.LoadInt32(&s.isLeader) == 1
+}
+
+// lead runs all registered leader duties until lost is closed,
+// and waits for them to return.
+func (s *Server) lead(lost <-chan struct{}) {
+	s.leaderMtx.Lock()
+	duties := s.leaderDuties
+	s.leaderMtx.Unlock()
+
+	atomic.StoreInt32(&s.isLeader, 1)
+	defer atomic.StoreInt32(&s.isLeader, 0)
+
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for _, duty := range duties {
+		wg.Add(1)
+		go func(duty namedLeaderDuty) {
+			defer wg.Done()
+			defer func() {
+				ConsumePanic(s.Sentry, s.TraceClient, s.Hostname, recover())
+			}()
+			log.WithField("duty", duty.name).Debug("Starting leader duty")
+			duty.run(stop)
+		}(duty)
+	}
+	<-lost
+	close(stop)
+	wg.Wait()
+}
+
+// reportLeadership is the leader duty that reports the gauge
+// leader.leading once per interval while this server leads, so that
+// its sum over the fleet shows whether exactly one instance leads,
+// including across failovers.
+func (s *Server) reportLeadership(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.Statsd.Gauge("leader.leading", 1, nil, 1.0)
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runLeaderElection campaigns for leadership until the server shuts
+// down, running the leader duties whenever this server is elected.
+// Without an elector, the server leads unconditionally.
+func (s *Server) runLeaderElection() {
+	if s.elector == nil {
+		s.lead(s.shutdown)
+		return
+	}
+
+	for {
+		lost, err := s.elector.Campaign(s.shutdown)
+		if err != nil {
+			log.WithError(err).Warn("Could not campaign for leadership")
+			s.Statsd.Count("leader.campaign_errors_total", 1, failureTags("leader", ssf.CauseIOError), 1.0)
+			select {
+			case <-s.shutdown:
+				return
+			case <-time.After(s.leaderRetryInterval):
+				continue
+			}
+		}
+		if lost == nil {
+			// We were told to stop campaigning:
+			return
+		}
+
+		log.Info("Elected leader")
+		s.Statsd.Count("leader.elected_total", 1, nil, 1.0)
+
+		// Stop leading if either leadership is lost or the
+		// server shuts down:
+		done := make(chan struct{})
+		shuttingDown := false
+		go func() {
+			select {
+			case <-lost:
+			case <-s.shutdown:
+				shuttingDown = true
+			}
+			close(done)
+		}()
+		s.lead(done)
+
+		if shuttingDown {
+			if err := s.elector.Resign(); err != nil {
+				log.WithError(err).Warn("Could not resign leadership")
+			}
+			return
+		}
+		log.Warn("Lost leadership")
+		s.Statsd.Count("leader.lost_total", 1, nil, 1.0)
+	}
+}
@@ -0,0 +1,394 @@
+package veneur
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// testElector is an Elector that is elected whenever the test sends
+// a "lost" channel on elect.
+type testElector struct {
+	elect    chan chan struct{}
+	resigned chan struct{}
+}
+
+func (e *testElector) Campaign(stop <-chan struct{}) (<-chan struct{}, error) {
+	select {
+	case lost := <-e.elect:
+		return lost, nil
+	case <-stop:
+		return nil, nil
+	}
+}
+
+func (e *testElector) Resign() error {
+	close(e.resigned)
+	return nil
+}
+
+func TestLeaderDutiesFollowLeadership(t *testing.T) {
+	elector := &testElector{
+		elect:    make(chan chan struct{}),
+		resigned: make(chan struct{}),
+	}
+	s := &Server{
+		shutdown:            make(chan struct{}),
+		elector:             elector,
+		leaderRetryInterval: time.Millisecond,
+	}
+	started := make(chan struct{})
+	stopped := make(chan struct{})
+	s.RegisterLeaderDuty("test", func(stop <-chan struct{}) {
+		started <- struct{}{}
+		<-stop
+		stopped <- struct{}{}
+	})
+
+	done := make(chan struct{})
+	go func() {
+		s.runLeaderElection()
+		close(done)
+	}()
+	assert.False(t, s.IsLeader())
+
+	// Elected, then lose leadership:
+	lost := make(chan struct{})
+	elector.elect <- lost
+	<-started
+	assert.True(t, s.IsLeader())
+	close(lost)
+	<-stopped
+
+	// Elected again, then shut down:
+	elector.elect <- make(chan struct{})
+	<-started
+	close(s.shutdown)
+	<-stopped
+	<-elector.resigned
+	<-done
+	assert.False(t, s.IsLeader())
+}
+
+func TestLeaderWithoutElection(t *testing.T) {
+	s := &Server{shutdown: make(chan struct{})}
+	started := make(chan struct{})
+	s.RegisterLeaderDuty("test", func(stop <-chan struct{}) {
+		close(started)
+		<-stop
+	})
+
+	done := make(chan struct{})
+	go func() {
+		s.runLeaderElection()
+		close(done)
+	}()
+	<-started
+	assert.True(t, s.IsLeader())
+	close(s.shutdown)
+	<-done
+}
+
+func TestReportLeadership(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	stats, err := statsd.New(conn.LocalAddr().String())
+	require.NoError(t, err)
+	stats.Namespace = "veneur."
+
+	s := &Server{Statsd: stats, interval: time.Hour}
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.reportLeadership(stop)
+		close(done)
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "veneur.leader.leading:1.000000|g", string(buf[:n]))
+	close(stop)
+	<-done
+}
+
+// fakeConfigMaps is an in-memory configMapClient that enforces
+// optimistic concurrency on resource versions, like the API server.
+type fakeConfigMaps struct {
+	sync.Mutex
+	cms     map[string]v1.ConfigMap
+	version int
+}
+
+var configMapResource = schema.GroupResource{Resource: "configmaps"}
+
+func (f *fakeConfigMaps) Get(name string, options metav1.GetOptions) (*v1.ConfigMap, error) {
+	f.Lock()
+	defer f.Unlock()
+	cm, ok := f.cms[name]
+	if !ok {
+		return nil, errors.NewNotFound(configMapResource, name)
+	}
+	cm.Annotations = copyAnnotations(cm.Annotations)
+	return &cm, nil
+}
+
+func (f *fakeConfigMaps) Create(cm *v1.ConfigMap) (*v1.ConfigMap, error) {
+	f.Lock()
+	defer f.Unlock()
+	if _, ok := f.cms[cm.Name]; ok {
+		return nil, errors.NewAlreadyExists(configMapResource, cm.Name)
+	}
+	f.store(cm)
+	return cm, nil
+}
+
+func (f *fakeConfigMaps) Update(cm *v1.ConfigMap) (*v1.ConfigMap, error) {
+	f.Lock()
+	defer f.Unlock()
+	if f.cms[cm.Name].ResourceVersion != cm.ResourceVersion {
+		return nil, errors.NewConflict(configMapResource, cm.Name, nil)
+	}
+	f.store(cm)
+	return cm, nil
+}
+
+func (f *fakeConfigMaps) store(cm *v1.ConfigMap) {
+	f.version++
+	stored := *cm
+	stored.ResourceVersion = strconv.Itoa(f.version)
+	stored.Annotations = copyAnnotations(cm.Annotations)
+	f.cms[cm.Name] = stored
+}
+
+func copyAnnotations(in map[string]string) map[string]string {
+	out := map[string]string{}
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
+
+func TestKubernetesElectorLease(t *testing.T) {
+	cms := &fakeConfigMaps{cms: map[string]v1.ConfigMap{}}
+	a := newKubernetesElector(cms, "leader", "a", 10*time.Second)
+	b := newKubernetesElector(cms, "leader", "b", 10*time.Second)
+	now := time.Now()
+
+	ok, err := a.tryAcquireOrRenew(now)
+	require.NoError(t, err)
+	assert.True(t, ok, "a should acquire the unheld lease")
+
+	ok, err = b.tryAcquireOrRenew(now)
+	require.NoError(t, err)
+	assert.False(t, ok, "b must not take over a's lease")
+
+	ok, err = a.tryAcquireOrRenew(now.Add(5 * time.Second))
+	require.NoError(t, err)
+	assert.True(t, ok, "a should renew its own lease")
+
+	// b observed the renewal only now, so the lease is valid for
+	// another full lease duration from b's point of view:
+	ok, err = b.tryAcquireOrRenew(now.Add(14 * time.Second))
+	require.NoError(t, err)
+	assert.False(t, ok, "b must not take over a renewed lease")
+
+	ok, err = b.tryAcquireOrRenew(now.Add(25 * time.Second))
+	require.NoError(t, err)
+	assert.True(t, ok, "b should take over the expired lease")
+}
+
+func TestKubernetesElectorResign(t *testing.T) {
+	cms := &fakeConfigMaps{cms: map[string]v1.ConfigMap{}}
+	a := newKubernetesElector(cms, "leader", "a", time.Hour)
+	b := newKubernetesElector(cms, "leader", "b", time.Hour)
+
+	lost, err := a.Campaign(nil)
+	require.NoError(t, err)
+	require.NotNil(t, lost)
+
+	require.NoError(t, a.Resign())
+	<-lost
+
+	ok, err := b.tryAcquireOrRenew(time.Now())
+	require.NoError(t, err)
+	assert.True(t, ok, "b should acquire the lease right after a resigned")
+}
+
+// fakeConsulLocks serves the parts of Consul's session and KV APIs
+// that a Consul lock uses, for a single key.
+type fakeConsulLocks struct {
+	sync.Mutex
+	sessions map[string]bool
+	lastID   int
+	exists   bool
+	holder   string
+	index    uint64
+	changed  chan struct{}
+}
+
+func newFakeConsulLocks() *fakeConsulLocks {
+	return &fakeConsulLocks{
+		sessions: map[string]bool{},
+		index:    1,
+		changed:  make(chan struct{}),
+	}
+}
+
+// update must be called with the lock held.
+func (f *fakeConsulLocks) update() {
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// expire invalidates a session, like Consul does once its TTL passes
+// without a renewal, releasing the lock it holds.
+func (f *fakeConsulLocks) expire(session string) {
+	f.Lock()
+	defer f.Unlock()
+	f.destroy(session)
+}
+
+// destroy must be called with the lock held.
+func (f *fakeConsulLocks) destroy(session string) {
+	delete(f.sessions, session)
+	if f.holder == session {
+		f.holder = ""
+		f.update()
+	}
+}
+
+func (f *fakeConsulLocks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+	w.Header().Set("X-Consul-LastContact", "0")
+	w.Header().Set("X-Consul-KnownLeader", "true")
+	switch {
+	case r.URL.Path == "/v1/session/create":
+		f.lastID++
+		id := fmt.Sprintf("session-%d", f.lastID)
+		f.sessions[id] = true
+		json.NewEncoder(w).Encode(map[string]string{"ID": id})
+	case strings.HasPrefix(r.URL.Path, "/v1/session/renew/"):
+		id := strings.TrimPrefix(r.URL.Path, "/v1/session/renew/")
+		if !f.sessions[id] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode([]map[string]string{{"ID": id, "TTL": "10s"}})
+	case strings.HasPrefix(r.URL.Path, "/v1/session/destroy/"):
+		f.destroy(strings.TrimPrefix(r.URL.Path, "/v1/session/destroy/"))
+		fmt.Fprint(w, "true")
+	case strings.HasPrefix(r.URL.Path, "/v1/kv/") && r.Method == http.MethodGet:
+		// Block briefly if asked to wait for a change:
+		if r.URL.Query().Get("index") == strconv.FormatUint(f.index, 10) {
+			changed := f.changed
+			f.Unlock()
+			select {
+			case <-changed:
+			case <-time.After(100 * time.Millisecond):
+			}
+			f.Lock()
+		}
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+		if !f.exists {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode([]*api.KVPair{{
+			Key:     strings.TrimPrefix(r.URL.Path, "/v1/kv/"),
+			Flags:   api.LockFlagValue,
+			Session: f.holder,
+		}})
+	case strings.HasPrefix(r.URL.Path, "/v1/kv/"):
+		if session := r.URL.Query().Get("acquire"); session != "" {
+			if !f.sessions[session] || (f.holder != "" && f.holder != session) {
+				fmt.Fprint(w, "false")
+				return
+			}
+			f.exists = true
+			f.holder = session
+			f.update()
+			fmt.Fprint(w, "true")
+			return
+		}
+		if session := r.URL.Query().Get("release"); session != "" {
+			if f.holder != session {
+				fmt.Fprint(w, "false")
+				return
+			}
+			f.holder = ""
+			f.update()
+			fmt.Fprint(w, "true")
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeConsulLocks) currentHolder() string {
+	f.Lock()
+	defer f.Unlock()
+	return f.holder
+}
+
+func TestConsulElectorRegainsLeadership(t *testing.T) {
+	consul := newFakeConsulLocks()
+	srv := httptest.NewServer(consul)
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	config := api.DefaultConfig()
+	config.Address = u.Host
+	elector, err := NewConsulElector(config, "veneur-leader", "a", 10*time.Second)
+	require.NoError(t, err)
+
+	lost, err := elector.Campaign(nil)
+	require.NoError(t, err)
+	require.NotNil(t, lost)
+	first := consul.currentHolder()
+	require.NotEmpty(t, first)
+
+	// The session expires, e.g. because Consul couldn't be reached
+	// to renew it:
+	consul.expire(first)
+	select {
+	case <-lost:
+	case <-time.After(5 * time.Second):
+		t.Fatal("leadership wasn't lost when the session expired")
+	}
+
+	lost, err = elector.Campaign(nil)
+	require.NoError(t, err, "campaigning again after losing the session")
+	require.NotNil(t, lost)
+	second := consul.currentHolder()
+	assert.NotEmpty(t, second)
+	assert.NotEqual(t, first, second, "leading with a new session")
+
+	require.NoError(t, elector.Resign())
+	<-lost
+	assert.Empty(t, consul.currentHolder())
+}
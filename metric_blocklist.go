@@ -0,0 +1,96 @@
+package veneur
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync/atomic"
+)
+
+// metricBlocklistRule drops the metrics whose name matches Name and
+// that have all of Tags. Name and tags are shell-style glob patterns
+// (see path.Match); a tag pattern without a colon matches the tag's
+// key, whatever its value.
+type metricBlocklistRule struct {
+	Name string   `yaml:"name" json:"name"`
+	Tags []string `yaml:"tags" json:"tags,omitempty"`
+
+	// drops counts the metrics the rule dropped since the last
+	// report, updated atomically
+	drops int64
+}
+
+func (r *metricBlocklistRule) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("blocklist rule %v has no name pattern", r.Tags)
+	}
+	for _, pattern := range append([]string{r.Name}, r.Tags...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid blocklist pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+func (r *metricBlocklistRule) dropped() *int64 {
+	return &r.drops
+}
+
+// String returns the rule's name pattern.
+func (r *metricBlocklistRule) String() string {
+	return r.Name
+}
+
+// matches returns whether the rule drops a metric.
+func (r *metricBlocklistRule) matches(name string, tags []string) bool {
+	if ok, _ := path.Match(r.Name, name); !ok {
+		return false
+	}
+	for _, pattern := range r.Tags {
+		if !hasMatchingTag(pattern, tags) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasMatchingTag(pattern string, tags []string) bool {
+	byKey := !strings.Contains(pattern, ":")
+	for _, tag := range tags {
+		if byKey {
+			if i := strings.IndexByte(tag, ':'); i >= 0 {
+				tag = tag[:i]
+			}
+		}
+		if ok, _ := path.Match(pattern, tag); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// metricBlocklist drops the metrics that match any of its rules as
+// soon as they are parsed or imported, before they are aggregated.
+// It's meant as an emergency lever, for example when a bad deploy
+// starts emitting a metric with a tag of unbounded cardinality.
+type metricBlocklist struct {
+	*blocklist[*metricBlocklistRule]
+}
+
+// newMetricBlocklist returns a blocklist without rules that watches
+// file, if it's not empty.
+func newMetricBlocklist(file string) *metricBlocklist {
+	return &metricBlocklist{newBlocklist[*metricBlocklistRule]("metric", "blocklist.dropped_total", file)}
+}
+
+// blocks returns whether a metric should be dropped, and counts it
+// against the rule that drops it.
+func (b *metricBlocklist) blocks(name string, tags []string) bool {
+	for _, rule := range b.inEffect() {
+		if rule.matches(name, tags) {
+			atomic.AddInt64(&rule.drops, 1)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package veneur
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/samplers/metricpb"
+)
+
+const testBlocklist = `
+rules:
+  - name: "api.requests.*"
+    tags: ["user_id"]
+  - name: "debug.*"
+    tags: ["env:prod*"]
+  - name: "bad.metric"
+`
+
+func TestParseMetricBlocklist(t *testing.T) {
+	rules, err := parseBlocklist[*metricBlocklistRule]([]byte(testBlocklist))
+	require.NoError(t, err)
+	assert.Len(t, rules, 3)
+
+	_, err = parseBlocklist[*metricBlocklistRule]([]byte(`rules: [{tags: ["a"]}]`))
+	assert.Error(t, err, "rules should need a name")
+	_, err = parseBlocklist[*metricBlocklistRule]([]byte(`rules: [{name: "a.[b"}]`))
+	assert.Error(t, err, "patterns should be valid")
+	_, err = parseBlocklist[*metricBlocklistRule]([]byte(`rules: [{name: "a", tag: "b"}]`))
+	assert.Error(t, err, "unknown fields should be rejected")
+	_, err = parseBlocklist[*metricBlocklistRule]([]byte(`{"rules": [{"name": "a.*", "tags": ["b"]}]}`))
+	assert.NoError(t, err, "JSON should be accepted")
+}
+
+func TestMetricBlocklistBlocks(t *testing.T) {
+	rules, err := parseBlocklist[*metricBlocklistRule]([]byte(testBlocklist))
+	require.NoError(t, err)
+	b := newMetricBlocklist("")
+	assert.False(t, b.blocks("bad.metric", nil), "an empty blocklist shouldn't block anything")
+	b.replace(rules)
+
+	tests := []struct {
+		name    string
+		tags    []string
+		blocked bool
+	}{
+		{"api.requests.count", []string{"user_id:1234", "team:a"}, true},
+		{"api.requests.count", []string{"user_id"}, true},
+		{"api.requests.count", []string{"team:a"}, false},
+		{"api.latency", []string{"user_id:1234"}, false},
+		{"debug.queue", []string{"env:production"}, true},
+		{"debug.queue", []string{"env:staging"}, false},
+		{"bad.metric", nil, true},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.blocked, b.blocks(test.name, test.tags), "%s %v", test.name, test.tags)
+	}
+
+	samples := b.report()
+	require.Len(t, samples, 3)
+	assert.Equal(t, "blocklist.dropped_total", samples[0].Name)
+	assert.Equal(t, float32(2), samples[0].Value)
+	assert.Equal(t, "api.requests.*", samples[0].Tags["rule"])
+	assert.Empty(t, b.report(), "counts should be reset after reporting")
+}
+
+func TestMetricBlocklistReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "veneur-blocklist")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "blocklist.yaml")
+	require.NoError(t, ioutil.WriteFile(file, []byte(testBlocklist), 0644))
+
+	b := newMetricBlocklist(file)
+	_, err = b.reload()
+	require.NoError(t, err)
+	assert.True(t, b.blocks("bad.metric", nil))
+
+	// an invalid file keeps the previous rules:
+	require.NoError(t, ioutil.WriteFile(file, []byte("rules: [{name: \"[\"}]"), 0644))
+	require.NoError(t, os.Chtimes(file, time.Now(), time.Now().Add(time.Second)))
+	_, err = b.reload()
+	assert.Error(t, err)
+	assert.True(t, b.blocks("bad.metric", nil))
+
+	require.NoError(t, ioutil.WriteFile(file, []byte("rules: []"), 0644))
+	require.NoError(t, os.Chtimes(file, time.Now(), time.Now().Add(2*time.Second)))
+	_, err = b.reload()
+	require.NoError(t, err)
+	assert.False(t, b.blocks("bad.metric", nil))
+}
+
+func TestWorkerDropsBlockedMetrics(t *testing.T) {
+	rules, err := parseBlocklist[*metricBlocklistRule]([]byte(testBlocklist))
+	require.NoError(t, err)
+	b := newMetricBlocklist("")
+	b.replace(rules)
+
+	w := NewWorker(1, nil, logrus.New(), nil)
+	w.setMetricBlocklist(b)
+	w.IngestUDP(samplers.UDPMetric{MetricKey: samplers.MetricKey{Name: "bad.metric", Type: "counter"}})
+	w.IngestUDP(samplers.UDPMetric{MetricKey: samplers.MetricKey{Name: "good.metric", Type: "counter"}})
+	require.Len(t, w.PacketChan, 1)
+	assert.Equal(t, "good.metric", (<-w.PacketChan).Name)
+
+	w.ImportMetric(samplers.JSONMetric{
+		MetricKey: samplers.MetricKey{Name: "bad.metric", Type: "counter", JoinedTags: "a:b"},
+		Tags:      []string{"a:b"},
+	})
+	require.NoError(t, w.ImportMetricGRPC(&metricpb.Metric{
+		Name:  "bad.metric",
+		Type:  metricpb.Type_Counter,
+		Value: &metricpb.Metric_Counter{Counter: &metricpb.CounterValue{Value: 1}},
+	}))
+	assert.Empty(t, w.wm.globalCounters, "imported metrics should be dropped too")
+}
+
+func TestMetricBlocklistEndpoints(t *testing.T) {
+	config := localConfig()
+	config.MetricBlocklistEnabled = true
+	s := setupVeneurServer(t, config, nil, nil, nil)
+	defer s.Shutdown()
+	handler := s.Handler()
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodPut, "/blocklist", "rules: [{name: \"[\"}]").Code)
+	require.Equal(t, http.StatusOK, request(http.MethodPut, "/blocklist", testBlocklist).Code)
+	assert.True(t, s.Workers[0].blocklist.blocks("bad.metric", nil))
+
+	w := request(http.MethodGet, "/blocklist", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	var file blocklistFile[*metricBlocklistRule]
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&file))
+	require.Len(t, file.Rules, 3)
+	assert.Equal(t, "api.requests.*", file.Rules[0].Name)
+	assert.Equal(t, []string{"user_id"}, file.Rules[0].Tags)
+}
@@ -0,0 +1,226 @@
+package veneur
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stripe/veneur/plugins"
+	"github.com/stripe/veneur/samplers"
+)
+
+// compactionKey identifies the series that an InterMetric is flushed
+// to. Sinks can't tell apart metrics with equal keys.
+type compactionKey struct {
+	name     string
+	typ      samplers.MetricType
+	hostName string
+	tags     string
+	sinks    string
+}
+
+// compactInterMetrics merges the metrics that have the same name, type,
+// tags (regardless of their order, and after removing the tags whose
+// keys are in excludedTags) and destination sinks, so that sinks don't
+// receive conflicting points for the same series. Such duplicates come
+// from the same series being sampled by different workers, e.g. as a
+// local and as a global counter, or from tags that tell them apart being
+// excluded.
+//
+// Counters are summed, status checks keep the most severe status, and
+// gauges keep the largest value, or the smallest if they are the "min"
+// aggregate of a histogram. The merged metric has the highest priority
+// of its duplicates. compactInterMetrics returns the compacted metrics,
+// in the order they were first seen, and the number of metrics that
+// were merged into others.
+func compactInterMetrics(metrics []samplers.InterMetric, excludedTags map[string]struct{}) ([]samplers.InterMetric, int) {
+	indices := make(map[compactionKey]int, len(metrics))
+	compacted := metrics[:0]
+	merged := 0
+	for _, m := range metrics {
+		if len(excludedTags) > 0 {
+			m.Tags = excludeTags(m.Tags, excludedTags)
+		}
+		key := compactionKeyOf(m)
+		i, ok := indices[key]
+		if !ok {
+			indices[key] = len(compacted)
+			compacted = append(compacted, m)
+			continue
+		}
+		mergeInterMetric(&compacted[i], m)
+		merged++
+	}
+	return compacted, merged
+}
+
+func compactionKeyOf(m samplers.InterMetric) compactionKey {
+	key := compactionKey{name: m.Name, typ: m.Type, hostName: m.HostName}
+	if sort.StringsAreSorted(m.Tags) {
+		key.tags = strings.Join(m.Tags, ",")
+	} else {
+		tags := append([]string(nil), m.Tags...)
+		sort.Strings(tags)
+		key.tags = strings.Join(tags, ",")
+	}
+	// a nil route means every sink, which no route list can be confused
+	// with:
+	if m.Sinks == nil {
+		key.sinks = "*"
+	} else {
+		sinks := make([]string, 0, len(m.Sinks))
+		for sink := range m.Sinks {
+			sinks = append(sinks, sink)
+		}
+		sort.Strings(sinks)
+		key.sinks = strings.Join(sinks, ",")
+	}
+	return key
+}
+
+// excludeTags returns tags without the ones whose keys are excluded. It
+// doesn't modify tags, which can be shared with the samplers.
+func excludeTags(tags []string, excluded map[string]struct{}) []string {
+	var kept []string
+	for i, tag := range tags {
+		key := tag
+		if idx := strings.IndexByte(tag, ':'); idx >= 0 {
+			key = tag[:idx]
+		}
+		if _, ok := excluded[key]; !ok {
+			if kept != nil {
+				kept = append(kept, tag)
+			}
+			continue
+		}
+		if kept == nil {
+			kept = append(make([]string, 0, len(tags)-1), tags[:i]...)
+		}
+	}
+	if kept == nil {
+		return tags
+	}
+	return kept
+}
+
+// mergeInterMetric merges the value of other into the metric m.
+func mergeInterMetric(m *samplers.InterMetric, other samplers.InterMetric) {
+	switch m.Type {
+	case samplers.CounterMetric:
+		m.Value += other.Value
+	case samplers.StatusMetric:
+		if other.Value > m.Value {
+			m.Value = other.Value
+			m.Message = other.Message
+		}
+	case samplers.GaugeMetric:
+		if strings.HasSuffix(m.Name, ".min") {
+			if other.Value < m.Value {
+				m.Value = other.Value
+			}
+		} else if other.Value > m.Value {
+			m.Value = other.Value
+		}
+	}
+	if other.Timestamp > m.Timestamp {
+		m.Timestamp = other.Timestamp
+	}
+	if other.Priority.Outranks(m.Priority) {
+		m.Priority = other.Priority
+	}
+	if m.Unit == "" {
+		m.Unit = other.Unit
+	}
+	if m.Exemplar == nil {
+		m.Exemplar = other.Exemplar
+	}
+}
+
+// rollupPlugin is a plugin that downsamples the metrics flushed to
+// another plugin, for archival destinations that don't need every
+// flush: it compacts the metrics of all the flushes in each window of
+// resolution into one point per series, like compactInterMetrics does
+// for the metrics of one flush, and hands them to the wrapped plugin
+// once the first flush of the next window arrives.
+//
+// Unlike in a single flush, the points of a series in a window follow
+// each other in time, so gauges keep their last value, except for the
+// "min" and "max" aggregates of histograms. The rolled-up metrics are
+// timestamped with the start of their window. The metrics of the
+// current window are only held in memory, so they are lost if veneur
+// exits before the window ends.
+type rollupPlugin struct {
+	plugin     plugins.Plugin
+	resolution time.Duration
+
+	mtx     sync.Mutex
+	window  int64
+	indices map[compactionKey]int
+	pending []samplers.InterMetric
+}
+
+// newRollupPlugin returns a plugin that flushes metrics to p at
+// resolution.
+func newRollupPlugin(p plugins.Plugin, resolution time.Duration) *rollupPlugin {
+	return &rollupPlugin{plugin: p, resolution: resolution}
+}
+
+// Name returns the name of the wrapped plugin.
+func (r *rollupPlugin) Name() string {
+	return r.plugin.Name()
+}
+
+// Flush adds metrics to the current window, and flushes the previous
+// window's rolled-up metrics to the wrapped plugin if metrics are from
+// a later window.
+func (r *rollupPlugin) Flush(ctx context.Context, metrics []samplers.InterMetric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	resolution := int64(r.resolution / time.Second)
+	if resolution <= 0 {
+		return r.plugin.Flush(ctx, metrics)
+	}
+	window := metrics[0].Timestamp - metrics[0].Timestamp%resolution
+
+	r.mtx.Lock()
+	var done []samplers.InterMetric
+	if window != r.window && len(r.pending) > 0 {
+		done = r.pending
+		for i := range done {
+			done[i].Timestamp = r.window
+		}
+		r.pending = nil
+		r.indices = nil
+	}
+	r.window = window
+	if r.indices == nil {
+		r.indices = map[compactionKey]int{}
+	}
+	for _, m := range metrics {
+		key := compactionKeyOf(m)
+		i, ok := r.indices[key]
+		if !ok {
+			r.indices[key] = len(r.pending)
+			r.pending = append(r.pending, m)
+			continue
+		}
+		rollUpInterMetric(&r.pending[i], m)
+	}
+	r.mtx.Unlock()
+
+	if done == nil {
+		return nil
+	}
+	return r.plugin.Flush(ctx, done)
+}
+
+// rollUpInterMetric merges a later point of the same series into m.
+func rollUpInterMetric(m *samplers.InterMetric, later samplers.InterMetric) {
+	mergeInterMetric(m, later)
+	if m.Type == samplers.GaugeMetric && !strings.HasSuffix(m.Name, ".min") && !strings.HasSuffix(m.Name, ".max") {
+		m.Value = later.Value
+	}
+}
@@ -0,0 +1,108 @@
+package veneur
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+)
+
+func TestCompactInterMetrics(t *testing.T) {
+	metrics := []samplers.InterMetric{
+		{Name: "a.count", Type: samplers.CounterMetric, Value: 1, Tags: []string{"x:1", "y:2"}},
+		{Name: "a.max", Type: samplers.GaugeMetric, Value: 3, Tags: []string{"x:1"}},
+		{Name: "a.count", Type: samplers.CounterMetric, Value: 2, Tags: []string{"y:2", "x:1"}, Priority: samplers.PriorityHigh},
+		{Name: "a.min", Type: samplers.GaugeMetric, Value: 4, Tags: []string{"x:1"}},
+		{Name: "a.max", Type: samplers.GaugeMetric, Value: 5, Tags: []string{"x:1"}},
+		{Name: "a.min", Type: samplers.GaugeMetric, Value: 2, Tags: []string{"x:1"}},
+		{Name: "a.count", Type: samplers.CounterMetric, Value: 4, Tags: []string{"x:1", "y:2"}, Sinks: samplers.RouteInformation{"datadog": {}}},
+		{Name: "a.count", Type: samplers.GaugeMetric, Value: 8, Tags: []string{"x:1", "y:2"}},
+		{Name: "check", Type: samplers.StatusMetric, Value: 1, Message: "warning"},
+		{Name: "check", Type: samplers.StatusMetric, Value: 2, Message: "critical"},
+		{Name: "check", Type: samplers.StatusMetric, Value: 0, Message: "ok"},
+	}
+	compacted, merged := compactInterMetrics(metrics, nil)
+	assert.Equal(t, 5, merged)
+	require.Len(t, compacted, 6)
+
+	assert.Equal(t, "a.count", compacted[0].Name)
+	assert.Equal(t, float64(3), compacted[0].Value, "counters should be summed")
+	assert.Equal(t, samplers.PriorityHigh, compacted[0].Priority)
+	assert.Equal(t, float64(5), compacted[1].Value, "gauges should keep the largest value")
+	assert.Equal(t, float64(2), compacted[2].Value, "min gauges should keep the smallest value")
+	assert.Equal(t, float64(4), compacted[3].Value, "metrics routed to other sinks shouldn't be merged")
+	assert.Equal(t, float64(8), compacted[4].Value, "metrics of other types shouldn't be merged")
+	assert.Equal(t, float64(2), compacted[5].Value, "status checks should keep the most severe status")
+	assert.Equal(t, "critical", compacted[5].Message)
+}
+
+func TestCompactInterMetricsExcludedTags(t *testing.T) {
+	shared := []string{"host:a", "service:web"}
+	metrics := []samplers.InterMetric{
+		{Name: "requests", Type: samplers.CounterMetric, Value: 1, Tags: shared},
+		{Name: "requests", Type: samplers.CounterMetric, Value: 2, Tags: []string{"host:b", "service:web"}},
+		{Name: "requests", Type: samplers.CounterMetric, Value: 4, Tags: []string{"service:api"}},
+	}
+	compacted, merged := compactInterMetrics(metrics, map[string]struct{}{"host": {}})
+	assert.Equal(t, 1, merged)
+	require.Len(t, compacted, 2)
+	assert.Equal(t, []string{"service:web"}, compacted[0].Tags)
+	assert.Equal(t, float64(3), compacted[0].Value)
+	assert.Equal(t, []string{"service:api"}, compacted[1].Tags)
+	assert.Equal(t, []string{"host:a", "service:web"}, shared, "the original tags shouldn't be modified")
+}
+
+type recordingPlugin struct {
+	flushes [][]samplers.InterMetric
+}
+
+func (p *recordingPlugin) Flush(ctx context.Context, metrics []samplers.InterMetric) error {
+	p.flushes = append(p.flushes, metrics)
+	return nil
+}
+
+func (p *recordingPlugin) Name() string {
+	return "recording"
+}
+
+func rollupTestMetrics(ts int64, counter, gauge, max float64) []samplers.InterMetric {
+	return []samplers.InterMetric{
+		{Name: "a.counter", Timestamp: ts, Value: counter, Tags: []string{"b:c", "a:b"}, Type: samplers.CounterMetric},
+		{Name: "a.gauge", Timestamp: ts, Value: gauge, Type: samplers.GaugeMetric},
+		{Name: "a.timer.max", Timestamp: ts, Value: max, Type: samplers.GaugeMetric},
+	}
+}
+
+func TestRollupPlugin(t *testing.T) {
+	rec := &recordingPlugin{}
+	r := newRollupPlugin(rec, 5*time.Minute)
+	ctx := context.Background()
+	assert.Equal(t, "recording", r.Name())
+
+	const window = 1500000000 // a multiple of 300
+	require.NoError(t, r.Flush(ctx, rollupTestMetrics(window+10, 1, 5, 100)))
+	counters := rollupTestMetrics(window+20, 2, 3, 50)
+	counters[0].Tags = []string{"a:b", "b:c"}
+	require.NoError(t, r.Flush(ctx, counters))
+	require.NoError(t, r.Flush(ctx, rollupTestMetrics(window+290, 3, 4, 10)))
+	assert.Empty(t, rec.flushes, "nothing should be flushed before the window ends")
+
+	require.NoError(t, r.Flush(ctx, rollupTestMetrics(window+300, 10, 10, 10)))
+	require.Len(t, rec.flushes, 1)
+	rolled := rec.flushes[0]
+	require.Len(t, rolled, 3)
+	assert.Equal(t, float64(6), rolled[0].Value, "counters should be summed, regardless of tag order")
+	assert.Equal(t, float64(4), rolled[1].Value, "gauges should keep their last value")
+	assert.Equal(t, float64(100), rolled[2].Value, "max aggregates should keep the largest value")
+	for _, m := range rolled {
+		assert.Equal(t, int64(window), m.Timestamp)
+	}
+
+	require.NoError(t, r.Flush(ctx, rollupTestMetrics(window+900, 1, 1, 1)))
+	require.Len(t, rec.flushes, 2)
+	assert.Equal(t, float64(10), rec.flushes[1][0].Value)
+	assert.Equal(t, int64(window+300), rec.flushes[1][0].Timestamp)
+}
@@ -0,0 +1,300 @@
+package veneur
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/stripe/veneur/samplers"
+)
+
+// metricExpression computes a metric at flush time from arithmetic
+// over other metrics flushed in the same interval, like
+// "errors / requests".
+type metricExpression struct {
+	name string
+	root exprNode
+	// inputs are the names of the metrics that the expression uses.
+	inputs []string
+}
+
+// exprNode is a node of a parsed metric expression.
+type exprNode interface {
+	eval(values map[string]float64) float64
+}
+
+type exprNumber float64
+
+func (n exprNumber) eval(map[string]float64) float64 {
+	return float64(n)
+}
+
+type exprMetric string
+
+func (m exprMetric) eval(values map[string]float64) float64 {
+	return values[string(m)]
+}
+
+type exprNegation struct {
+	operand exprNode
+}
+
+func (n exprNegation) eval(values map[string]float64) float64 {
+	return -n.operand.eval(values)
+}
+
+type exprBinary struct {
+	op          byte
+	left, right exprNode
+}
+
+func (b exprBinary) eval(values map[string]float64) float64 {
+	l, r := b.left.eval(values), b.right.eval(values)
+	switch b.op {
+	case '+':
+		return l + r
+	case '-':
+		return l - r
+	case '*':
+		return l * r
+	default:
+		return l / r
+	}
+}
+
+// parseMetricExpression parses src, which is made of numbers, metric
+// names, parentheses and the operators +, -, * and /. Metric names
+// that aren't made of letters, digits, '_' and '.' can be written in
+// double quotes.
+func parseMetricExpression(name, src string) (*metricExpression, error) {
+	if name == "" {
+		return nil, fmt.Errorf("metric_expressions: an expression needs a name")
+	}
+	p := &exprParser{src: src, seen: map[string]bool{}}
+	root, err := p.parseSum()
+	if err == nil {
+		p.skipSpace()
+		if p.pos < len(p.src) {
+			err = p.errorf("unexpected %q", p.src[p.pos])
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("metric_expressions: %s: %v", name, err)
+	}
+	if len(p.inputs) == 0 {
+		return nil, fmt.Errorf("metric_expressions: %s: the expression uses no metrics", name)
+	}
+	return &metricExpression{name: name, root: root, inputs: p.inputs}, nil
+}
+
+type exprParser struct {
+	src    string
+	pos    int
+	inputs []string
+	seen   map[string]bool
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// peek returns the next non-space byte, or 0 at the end.
+func (p *exprParser) peek() byte {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *exprParser) parseSum() (exprNode, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos++
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = exprBinary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseProduct() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '*' || op == '/'; op = p.peek() {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = exprBinary{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.peek() == '-' {
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return exprNegation{operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	c := p.peek()
+	switch {
+	case c == 0:
+		return nil, p.errorf("unexpected end of expression")
+	case c == '(':
+		p.pos++
+		node, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, p.errorf("missing )")
+		}
+		p.pos++
+		return node, nil
+	case c == '"':
+		end := strings.IndexByte(p.src[p.pos+1:], '"')
+		if end < 0 {
+			return nil, p.errorf("unterminated metric name")
+		}
+		name := p.src[p.pos+1 : p.pos+1+end]
+		p.pos += end + 2
+		return p.metric(name), nil
+	case c == '.' || (c >= '0' && c <= '9'):
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] == '.' || (p.src[p.pos] >= '0' && p.src[p.pos] <= '9')) {
+			p.pos++
+		}
+		text := p.src[start:p.pos]
+		n, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			p.pos = start
+			return nil, p.errorf("invalid number %q", text)
+		}
+		return exprNumber(n), nil
+	case c == '_' || unicode.IsLetter(rune(c)):
+		start := p.pos
+		for p.pos < len(p.src) && isMetricNameByte(p.src[p.pos]) {
+			p.pos++
+		}
+		return p.metric(p.src[start:p.pos]), nil
+	default:
+		return nil, p.errorf("unexpected %q", c)
+	}
+}
+
+func isMetricNameByte(c byte) bool {
+	return c == '_' || c == '.' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func (p *exprParser) metric(name string) exprNode {
+	if !p.seen[name] {
+		p.seen[name] = true
+		p.inputs = append(p.inputs, name)
+	}
+	return exprMetric(name)
+}
+
+// exprSeries groups the values of the input metrics that share a
+// series: the same tags (regardless of order) and host.
+type exprSeries struct {
+	tags      []string
+	hostName  string
+	timestamp int64
+	values    map[string]float64
+}
+
+// computeMetricExpressions evaluates exprs over the counters and gauges
+// in metrics, once for every set of tags and host that all of an
+// expression's inputs were flushed with, and returns the results as
+// gauges. Results that aren't finite, e.g. because of a division by
+// zero, are left out.
+func computeMetricExpressions(exprs []*metricExpression, metrics []samplers.InterMetric) []samplers.InterMetric {
+	inputs := map[string]struct{}{}
+	for _, expr := range exprs {
+		for _, name := range expr.inputs {
+			inputs[name] = struct{}{}
+		}
+	}
+
+	series := map[string]*exprSeries{}
+	var order []string
+	for _, m := range metrics {
+		if _, ok := inputs[m.Name]; !ok {
+			continue
+		}
+		if m.Type != samplers.CounterMetric && m.Type != samplers.GaugeMetric {
+			continue
+		}
+		tags := m.Tags
+		if !sort.StringsAreSorted(tags) {
+			tags = append([]string(nil), tags...)
+			sort.Strings(tags)
+		}
+		key := m.HostName + "|" + strings.Join(tags, ",")
+		s, ok := series[key]
+		if !ok {
+			s = &exprSeries{tags: m.Tags, hostName: m.HostName, values: map[string]float64{}}
+			series[key] = s
+			order = append(order, key)
+		}
+		if m.Type == samplers.CounterMetric {
+			s.values[m.Name] += m.Value
+		} else {
+			s.values[m.Name] = m.Value
+		}
+		if m.Timestamp > s.timestamp {
+			s.timestamp = m.Timestamp
+		}
+	}
+
+	var results []samplers.InterMetric
+	for _, expr := range exprs {
+	Series:
+		for _, key := range order {
+			s := series[key]
+			for _, name := range expr.inputs {
+				if _, ok := s.values[name]; !ok {
+					continue Series
+				}
+			}
+			value := expr.root.eval(s.values)
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+			results = append(results, samplers.InterMetric{
+				Name:      expr.name,
+				Timestamp: s.timestamp,
+				Value:     value,
+				Tags:      s.tags,
+				Type:      samplers.GaugeMetric,
+				HostName:  s.hostName,
+			})
+		}
+	}
+	return results
+}
@@ -0,0 +1,69 @@
+package veneur
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+)
+
+func TestParseMetricExpression(t *testing.T) {
+	tests := []struct {
+		src    string
+		inputs []string
+		values map[string]float64
+		result float64
+	}{
+		{"a / b", []string{"a", "b"}, map[string]float64{"a": 1, "b": 4}, 0.25},
+		{"a.hits / (a.hits + a.misses)", []string{"a.hits", "a.misses"}, map[string]float64{"a.hits": 3, "a.misses": 1}, 0.75},
+		{"1 - x * 2 + 3", []string{"x"}, map[string]float64{"x": 2}, 0},
+		{"-x / -.5", []string{"x"}, map[string]float64{"x": 2}, 4},
+		{`"odd-name:metric" * 100`, []string{"odd-name:metric"}, map[string]float64{"odd-name:metric": 0.5}, 50},
+		{"x/y/z", []string{"x", "y", "z"}, map[string]float64{"x": 8, "y": 2, "z": 2}, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.src, func(t *testing.T) {
+			expr, err := parseMetricExpression("result", test.src)
+			require.NoError(t, err)
+			assert.Equal(t, test.inputs, expr.inputs)
+			assert.Equal(t, test.result, expr.root.eval(test.values))
+		})
+	}
+}
+
+func TestParseMetricExpressionErrors(t *testing.T) {
+	for _, src := range []string{"", "a /", "(a + b", "a b", "1 + 2", `"unterminated`, "a % b", "1.2.3 * a"} {
+		_, err := parseMetricExpression("result", src)
+		assert.Error(t, err, "%q should not parse", src)
+	}
+	_, err := parseMetricExpression("", "a / b")
+	assert.Error(t, err, "expressions need a name")
+}
+
+func TestComputeMetricExpressions(t *testing.T) {
+	rate, err := parseMetricExpression("error_rate", "errors / requests")
+	require.NoError(t, err)
+	metrics := []samplers.InterMetric{
+		{Name: "requests", Type: samplers.CounterMetric, Value: 10, Tags: []string{"service:a", "env:prod"}, Timestamp: 10},
+		{Name: "errors", Type: samplers.CounterMetric, Value: 1, Tags: []string{"env:prod", "service:a"}, Timestamp: 11},
+		{Name: "requests", Type: samplers.CounterMetric, Value: 4, Tags: []string{"service:b"}},
+		{Name: "errors", Type: samplers.CounterMetric, Value: 1, Tags: []string{"service:b"}},
+		{Name: "errors", Type: samplers.CounterMetric, Value: 1, Tags: []string{"service:b"}},
+		{Name: "requests", Type: samplers.CounterMetric, Value: 0, Tags: []string{"service:c"}},
+		{Name: "errors", Type: samplers.CounterMetric, Value: 0, Tags: []string{"service:c"}},
+		{Name: "errors", Type: samplers.CounterMetric, Value: 1, Tags: []string{"service:d"}},
+		{Name: "requests", Type: samplers.StatusMetric, Value: 1, Tags: []string{"service:e"}},
+		{Name: "errors", Type: samplers.CounterMetric, Value: 1, Tags: []string{"service:e"}},
+	}
+	computed := computeMetricExpressions([]*metricExpression{rate}, metrics)
+	require.Len(t, computed, 2, "series missing an input or with a division by zero shouldn't be computed")
+
+	assert.Equal(t, "error_rate", computed[0].Name)
+	assert.Equal(t, samplers.GaugeMetric, computed[0].Type)
+	assert.Equal(t, 0.1, computed[0].Value)
+	assert.Equal(t, []string{"service:a", "env:prod"}, computed[0].Tags)
+	assert.Equal(t, int64(11), computed[0].Timestamp)
+	assert.Equal(t, 0.5, computed[1].Value, "counters of the same series should be summed")
+	assert.Equal(t, []string{"service:b"}, computed[1].Tags)
+}
@@ -0,0 +1,163 @@
+package veneur
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/samplers/metricpb"
+	"github.com/stripe/veneur/ssf"
+)
+
+// metricMatch matches the metrics whose names start with a prefix and
+// that carry a tag, if either is set.
+type metricMatch struct {
+	namePrefix string
+	tag        string
+}
+
+func (r metricMatch) matches(name string, tags []string) bool {
+	if !strings.HasPrefix(name, r.namePrefix) {
+		return false
+	}
+	if r.tag == "" {
+		return true
+	}
+	for _, tag := range tags {
+		if tag == r.tag {
+			return true
+		}
+		// a rule's tag without a value matches any value:
+		if !strings.Contains(r.tag, ":") && strings.HasPrefix(tag, r.tag+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// priorityRule assigns a priority class to the metrics it matches.
+type priorityRule struct {
+	metricMatch
+	priority samplers.Priority
+}
+
+// metricPriorities assigns priority classes to metrics as workers
+// ingest them, and sheds metrics by priority when the workers can't
+// keep up.
+type metricPriorities struct {
+	rules []priorityRule
+	// sheddingThreshold is the fraction of a worker's queue that must
+	// be full for low-priority metrics to be shed. If it's 0, no
+	// metrics are shed.
+	sheddingThreshold float64
+
+	// shed counts the metrics shed since the last report, by priority:
+	shedLow    int64
+	shedNormal int64
+}
+
+func newMetricPriorities(sheddingThreshold float64) (*metricPriorities, error) {
+	if sheddingThreshold < 0 || sheddingThreshold > 1 {
+		return nil, fmt.Errorf("metric_priority_shed_threshold must be between 0 and 1, got %v", sheddingThreshold)
+	}
+	return &metricPriorities{sheddingThreshold: sheddingThreshold}, nil
+}
+
+// addRule adds a rule that assigns the named priority to matching
+// metrics. Rules are tried in the order they were added.
+func (p *metricPriorities) addRule(namePrefix, tag, priority string) error {
+	if namePrefix == "" && tag == "" {
+		return fmt.Errorf("metric_priorities: a rule needs a name_prefix or a tag")
+	}
+	prio, err := samplers.ParsePriority(priority)
+	if err != nil {
+		return fmt.Errorf("metric_priorities: %v", err)
+	}
+	p.rules = append(p.rules, priorityRule{
+		metricMatch: metricMatch{namePrefix: namePrefix, tag: tag},
+		priority:    prio,
+	})
+	return nil
+}
+
+// classify returns the priority of a metric: the priority it already
+// has, if that isn't normal, or else that of the first rule it
+// matches.
+func (p *metricPriorities) classify(name string, tags []string, priority samplers.Priority) samplers.Priority {
+	if priority != samplers.PriorityNormal {
+		return priority
+	}
+	for _, rule := range p.rules {
+		if rule.matches(name, tags) {
+			return rule.priority
+		}
+	}
+	return priority
+}
+
+// shed returns whether a metric of the given priority should be
+// dropped rather than queued to a worker, given how many metrics the
+// worker's queue holds. Low-priority metrics are shed once the queue
+// is past the threshold, normal ones when it is full, and high-priority
+// ones never: they wait for room in the queue.
+func (p *metricPriorities) shed(priority samplers.Priority, queued, capacity int) bool {
+	if p.sheddingThreshold == 0 {
+		return false
+	}
+	switch priority {
+	case samplers.PriorityLow:
+		if float64(queued) >= p.sheddingThreshold*float64(capacity) {
+			atomic.AddInt64(&p.shedLow, 1)
+			return true
+		}
+	case samplers.PriorityNormal:
+		if queued >= capacity {
+			atomic.AddInt64(&p.shedNormal, 1)
+			return true
+		}
+	}
+	return false
+}
+
+// report returns counters of the metrics shed since the last report,
+// for each priority that any were shed of.
+func (p *metricPriorities) report() []*ssf.SSFSample {
+	var samples []*ssf.SSFSample
+	for _, shed := range []struct {
+		count    *int64
+		priority samplers.Priority
+	}{{&p.shedLow, samplers.PriorityLow}, {&p.shedNormal, samplers.PriorityNormal}} {
+		if n := atomic.SwapInt64(shed.count, 0); n > 0 {
+			samples = append(samples, ssf.Count("worker.metrics_shed_total", float32(n),
+				map[string]string{"priority": shed.priority.String()}))
+		}
+	}
+	return samples
+}
+
+// sortInterMetricsByPriority orders metrics so that sinks, which
+// submit metrics in the order they're given, submit those of higher
+// priorities first.
+func sortInterMetricsByPriority(metrics []samplers.InterMetric) {
+	sort.SliceStable(metrics, func(i, j int) bool {
+		return metrics[i].Priority.Outranks(metrics[j].Priority)
+	})
+}
+
+// sortJSONMetricsByPriority orders metrics so that the batches forwarded
+// first hold those of higher priorities.
+func sortJSONMetricsByPriority(metrics []samplers.JSONMetric) {
+	sort.SliceStable(metrics, func(i, j int) bool {
+		return metrics[i].Priority.Outranks(metrics[j].Priority)
+	})
+}
+
+// sortForwardMetricsByPriority orders metrics so that the batches
+// forwarded first hold those of higher priorities.
+func sortForwardMetricsByPriority(metrics []*metricpb.Metric) {
+	sort.SliceStable(metrics, func(i, j int) bool {
+		return samplers.Priority(metrics[i].Priority).Outranks(samplers.Priority(metrics[j].Priority))
+	})
+}
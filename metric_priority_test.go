@@ -0,0 +1,165 @@
+package veneur
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/samplers/metricpb"
+)
+
+func testMetricPriorities(t *testing.T, threshold float64) *metricPriorities {
+	p, err := newMetricPriorities(threshold)
+	require.NoError(t, err)
+	require.NoError(t, p.addRule("debug.", "", "low"))
+	require.NoError(t, p.addRule("", "slo", "high"))
+	require.NoError(t, p.addRule("api.", "team:payments", "high"))
+	return p
+}
+
+func TestNewMetricPriorities(t *testing.T) {
+	_, err := newMetricPriorities(1.5)
+	assert.Error(t, err)
+	_, err = newMetricPriorities(-0.1)
+	assert.Error(t, err)
+
+	p, err := newMetricPriorities(0.5)
+	require.NoError(t, err)
+	assert.Error(t, p.addRule("", "", "low"), "a rule should need a prefix or a tag")
+	assert.Error(t, p.addRule("a.", "", "urgent"), "unknown priorities should be rejected")
+}
+
+func TestMetricPrioritiesClassify(t *testing.T) {
+	p := testMetricPriorities(t, 0)
+	tests := []struct {
+		name     string
+		tags     []string
+		priority samplers.Priority
+		expected samplers.Priority
+	}{
+		{"debug.queue_depth", nil, samplers.PriorityNormal, samplers.PriorityLow},
+		{"requests", []string{"slo"}, samplers.PriorityNormal, samplers.PriorityHigh},
+		{"requests", []string{"slo:checkout"}, samplers.PriorityNormal, samplers.PriorityHigh},
+		{"requests", []string{"slow:true"}, samplers.PriorityNormal, samplers.PriorityNormal},
+		{"api.requests", []string{"team:payments"}, samplers.PriorityNormal, samplers.PriorityHigh},
+		{"api.requests", []string{"team:search"}, samplers.PriorityNormal, samplers.PriorityNormal},
+		{"debug.queue_depth", []string{"slo"}, samplers.PriorityNormal, samplers.PriorityLow},
+		{"debug.queue_depth", nil, samplers.PriorityHigh, samplers.PriorityHigh},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, p.classify(test.name, test.tags, test.priority),
+			"%s %v (%s)", test.name, test.tags, test.priority)
+	}
+}
+
+func TestMetricPrioritiesShed(t *testing.T) {
+	p := testMetricPriorities(t, 0.5)
+	assert.False(t, p.shed(samplers.PriorityLow, 4, 10))
+	assert.True(t, p.shed(samplers.PriorityLow, 5, 10))
+	assert.False(t, p.shed(samplers.PriorityNormal, 9, 10))
+	assert.True(t, p.shed(samplers.PriorityNormal, 10, 10))
+	assert.False(t, p.shed(samplers.PriorityHigh, 10, 10))
+
+	samples := p.report()
+	require.Len(t, samples, 2)
+	assert.Equal(t, "worker.metrics_shed_total", samples[0].Name)
+	assert.Equal(t, float32(1), samples[0].Value)
+	assert.Equal(t, "low", samples[0].Tags["priority"])
+	assert.Equal(t, "normal", samples[1].Tags["priority"])
+	assert.Empty(t, p.report(), "counts should be reset after reporting")
+
+	p = testMetricPriorities(t, 0)
+	assert.False(t, p.shed(samplers.PriorityLow, 10, 10), "a threshold of 0 should disable shedding")
+}
+
+func TestWorkerShedsByPriority(t *testing.T) {
+	w := NewWorker(1, nil, logrus.New(), nil)
+	w.PacketChan = make(chan samplers.UDPMetric, 4)
+	w.setMetricPriorities(testMetricPriorities(t, 0.5))
+
+	for i := 0; i < 4; i++ {
+		w.IngestUDP(samplers.UDPMetric{MetricKey: samplers.MetricKey{Name: "debug.a", Type: "counter"}})
+		w.IngestUDP(samplers.UDPMetric{MetricKey: samplers.MetricKey{Name: "a", Type: "counter"}})
+	}
+	close(w.PacketChan)
+
+	var ingested []samplers.UDPMetric
+	for m := range w.PacketChan {
+		ingested = append(ingested, m)
+	}
+	require.Len(t, ingested, 4)
+	assert.Equal(t, samplers.PriorityLow, ingested[0].Priority)
+	assert.Equal(t, samplers.PriorityNormal, ingested[1].Priority)
+	for _, m := range ingested[2:] {
+		assert.Equal(t, "a", m.Name, "low-priority metrics should be shed past the threshold")
+	}
+}
+
+func TestWorkerPriorities(t *testing.T) {
+	w := NewWorker(1, nil, logrus.New(), nil)
+	w.setMetricPriorities(testMetricPriorities(t, 0))
+	w.ProcessMetric(&samplers.UDPMetric{
+		MetricKey:  samplers.MetricKey{Name: "a.b.c", Type: "counter"},
+		Value:      1.0,
+		SampleRate: 1.0,
+		Scope:      samplers.MixedScope,
+		Priority:   samplers.PriorityHigh,
+	})
+	wm := w.Flush()
+	require.Len(t, wm.counters, 1)
+	for _, c := range wm.counters {
+		assert.Equal(t, samplers.PriorityHigh, c.Priority)
+		metrics := c.Flush(10)
+		require.Len(t, metrics, 1)
+		assert.Equal(t, samplers.PriorityHigh, metrics[0].Priority)
+	}
+
+	h := samplers.NewHist("debug.latency", nil)
+	h.Sample(5, 1.0)
+	m, err := h.Metric()
+	require.NoError(t, err)
+	require.NoError(t, w.ImportMetricGRPC(m))
+
+	g := samplers.NewGauge("requests", []string{"slo:checkout"})
+	g.Sample(1, 1.0)
+	jm, err := g.Export()
+	require.NoError(t, err)
+	w.ImportMetric(jm)
+
+	wm = w.Flush()
+	require.Len(t, wm.histograms, 1)
+	for _, h := range wm.histograms {
+		assert.Equal(t, samplers.PriorityLow, h.Priority, "imported metrics should be classified by the rules")
+	}
+	require.Len(t, wm.globalGauges, 1)
+	for _, g := range wm.globalGauges {
+		assert.Equal(t, samplers.PriorityHigh, g.Priority, "imported metrics should be classified by the rules")
+	}
+}
+
+func TestSortMetricsByPriority(t *testing.T) {
+	metrics := []samplers.InterMetric{
+		{Name: "a", Priority: samplers.PriorityLow},
+		{Name: "b"},
+		{Name: "c", Priority: samplers.PriorityHigh},
+		{Name: "d"},
+	}
+	sortInterMetricsByPriority(metrics)
+	var names []string
+	for _, m := range metrics {
+		names = append(names, m.Name)
+	}
+	assert.Equal(t, []string{"c", "b", "d", "a"}, names)
+
+	forwarded := []*metricpb.Metric{
+		{Name: "a", Priority: int32(samplers.PriorityLow)},
+		{Name: "b", Priority: int32(samplers.PriorityHigh)},
+		{Name: "c"},
+	}
+	sortForwardMetricsByPriority(forwarded)
+	assert.Equal(t, "b", forwarded[0].Name)
+	assert.Equal(t, "c", forwarded[1].Name)
+	assert.Equal(t, "a", forwarded[2].Name)
+}
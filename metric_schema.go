@@ -0,0 +1,298 @@
+package veneur
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/ssf"
+	"golang.org/x/net/context"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// The ways in which a metric can violate its schema.
+const (
+	schemaViolationType = "type"
+	schemaViolationUnit = "unit"
+	schemaViolationTag  = "tag"
+)
+
+// maxTrackedSchemaViolations bounds the number of distinct
+// (metric, violation) pairs that are listed on /schema/violations.
+const maxTrackedSchemaViolations = 1000
+
+// metricSchema declares the type, unit and tag keys that a metric
+// is expected to have. Empty fields aren't enforced.
+type metricSchema struct {
+	Name string   `yaml:"name"`
+	Type string   `yaml:"type"`
+	Unit string   `yaml:"unit"`
+	Tags []string `yaml:"tags"`
+
+	tagKeys map[string]struct{}
+}
+
+// metricSchemaFile is the format of a metric schema registry source.
+type metricSchemaFile struct {
+	Metrics []metricSchema `yaml:"metrics"`
+}
+
+var schemaMetricTypes = map[string]struct{}{
+	counterTypeName:   {},
+	gaugeTypeName:     {},
+	histogramTypeName: {},
+	setTypeName:       {},
+	timerTypeName:     {},
+	statusTypeName:    {},
+}
+
+// parseMetricSchemas parses a metric schema registry, returning the
+// schemas by metric name.
+func parseMetricSchemas(bts []byte) (map[string]*metricSchema, error) {
+	var file metricSchemaFile
+	if err := yaml.UnmarshalStrict(bts, &file); err != nil {
+		return nil, err
+	}
+	schemas := make(map[string]*metricSchema, len(file.Metrics))
+	for i := range file.Metrics {
+		schema := &file.Metrics[i]
+		if schema.Name == "" {
+			return nil, fmt.Errorf("metric schema #%d has no name", i+1)
+		}
+		if _, ok := schemas[schema.Name]; ok {
+			return nil, fmt.Errorf("metric %q is declared more than once", schema.Name)
+		}
+		if _, ok := schemaMetricTypes[schema.Type]; schema.Type != "" && !ok {
+			return nil, fmt.Errorf("metric %q has unknown type %q", schema.Name, schema.Type)
+		}
+		if len(schema.Tags) > 0 {
+			schema.tagKeys = make(map[string]struct{}, len(schema.Tags))
+			for _, key := range schema.Tags {
+				schema.tagKeys[key] = struct{}{}
+			}
+		}
+		schemas[schema.Name] = schema
+	}
+	return schemas, nil
+}
+
+// schemaViolation records how often a metric violated one aspect of
+// its schema.
+type schemaViolation struct {
+	Metric    string    `json:"metric"`
+	Violation string    `json:"violation"`
+	Expected  string    `json:"expected"`
+	Got       string    `json:"got"`
+	Count     int64     `json:"count"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// schemaRegistry enforces the metric schemas read from a file or an
+// HTTP(S) URL on the metrics that workers ingest.
+type schemaRegistry struct {
+	source string
+	reject bool
+	client *http.Client
+
+	schemas atomic.Value // map[string]*metricSchema
+
+	mtx        sync.Mutex
+	violations map[string]*schemaViolation
+	// counts since the last report:
+	violated map[string]int64
+	rejected int64
+}
+
+func newSchemaRegistry(source, mode string, client *http.Client) (*schemaRegistry, error) {
+	r := &schemaRegistry{
+		source:     source,
+		client:     client,
+		violations: map[string]*schemaViolation{},
+		violated:   map[string]int64{},
+	}
+	switch mode {
+	case "warn":
+	case "reject":
+		r.reject = true
+	default:
+		return nil, fmt.Errorf("metric_schema_mode must be \"warn\" or \"reject\", got %q", mode)
+	}
+	r.schemas.Store(map[string]*metricSchema{})
+	return r, nil
+}
+
+// fetch reads the registry's source.
+func (r *schemaRegistry) fetch() ([]byte, error) {
+	if !strings.HasPrefix(r.source, "http://") && !strings.HasPrefix(r.source, "https://") {
+		return ioutil.ReadFile(r.source)
+	}
+	resp, err := r.client.Get(r.source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: unexpected status %s", r.source, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// reload reads and parses the registry's source, replacing the
+// schemas in effect. If that fails, the previous schemas stay in
+// effect and the returned error's cause (see ssf.Failure) says why.
+func (r *schemaRegistry) reload() (cause string, err error) {
+	bts, err := r.fetch()
+	if err != nil {
+		return ssf.CauseIOError, err
+	}
+	schemas, err := parseMetricSchemas(bts)
+	if err != nil {
+		return ssf.CauseParseError, err
+	}
+	r.schemas.Store(schemas)
+	return "", nil
+}
+
+// check checks a metric against its schema, if it has one, and
+// records any violations. It returns false if the metric should be
+// dropped.
+func (r *schemaRegistry) check(m *samplers.UDPMetric) bool {
+	schema, ok := r.schemas.Load().(map[string]*metricSchema)[m.Name]
+	if !ok {
+		return true
+	}
+	valid := true
+	if schema.Type != "" && m.Type != schema.Type {
+		r.violate(m.Name, schemaViolationType, schema.Type, m.Type)
+		valid = false
+	}
+	// only SSF samples carry a unit:
+	if schema.Unit != "" && m.Unit != "" && m.Unit != schema.Unit {
+		r.violate(m.Name, schemaViolationUnit, schema.Unit, m.Unit)
+		valid = false
+	}
+	if schema.tagKeys != nil {
+		for _, tag := range m.Tags {
+			key := tag
+			if i := strings.IndexByte(tag, ':'); i >= 0 {
+				key = tag[:i]
+			}
+			if strings.HasPrefix(key, "veneur") {
+				// magic tags are always allowed
+				continue
+			}
+			if _, ok := schema.tagKeys[key]; !ok {
+				r.violate(m.Name, schemaViolationTag, strings.Join(schema.Tags, ","), key)
+				valid = false
+				break
+			}
+		}
+	}
+	if valid || !r.reject {
+		return true
+	}
+	r.mtx.Lock()
+	r.rejected++
+	r.mtx.Unlock()
+	return false
+}
+
+func (r *schemaRegistry) violate(name, violation, expected, got string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.violated[violation]++
+
+	key := name + "|" + violation
+	v, ok := r.violations[key]
+	if !ok {
+		if len(r.violations) >= maxTrackedSchemaViolations {
+			return
+		}
+		log.WithFields(logrus.Fields{
+			"metric":    name,
+			"violation": violation,
+			"expected":  expected,
+			"got":       got,
+		}).Warn("Metric violates its schema")
+		v = &schemaViolation{Metric: name, Violation: violation, Expected: expected}
+		r.violations[key] = v
+	}
+	v.Got = got
+	v.Count++
+	v.LastSeen = time.Now()
+}
+
+// listViolations returns the recorded schema violations, ordered by
+// metric name.
+func (r *schemaRegistry) listViolations() []schemaViolation {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	violations := make([]schemaViolation, 0, len(r.violations))
+	for _, v := range r.violations {
+		violations = append(violations, *v)
+	}
+	sort.Slice(violations, func(i, j int) bool {
+		if violations[i].Metric != violations[j].Metric {
+			return violations[i].Metric < violations[j].Metric
+		}
+		return violations[i].Violation < violations[j].Violation
+	})
+	return violations
+}
+
+// report returns counters of the violations and rejected metrics
+// since the last report.
+func (r *schemaRegistry) report() []*ssf.SSFSample {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	var samples []*ssf.SSFSample
+	for violation, n := range r.violated {
+		samples = append(samples, ssf.Count("schema.violations_total", float32(n),
+			map[string]string{"violation": violation}))
+		delete(r.violated, violation)
+	}
+	if r.rejected > 0 {
+		samples = append(samples, ssf.Count("schema.rejected_total", float32(r.rejected), nil,
+			ssf.Failure("schema", ssf.CauseSchemaViolation)))
+		r.rejected = 0
+	}
+	return samples
+}
+
+// refreshMetricSchemas reloads the metric schema registry every
+// refresh interval until the server shuts down.
+func (s *Server) refreshMetricSchemas() {
+	ticker := time.NewTicker(s.metricSchemaRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.shutdown:
+			return
+		case <-ticker.C:
+			if cause, err := s.metricSchemas.reload(); err != nil {
+				log.WithError(err).WithField("source", s.metricSchemas.source).
+					Warn("Could not reload metric schemas, keeping the previous ones")
+				s.Statsd.Count("schema.reload_errors_total", 1, failureTags("schema", cause), 1.0)
+			}
+		}
+	}
+}
+
+// handleSchemaViolations serves the recorded schema violations as
+// JSON.
+func handleSchemaViolations(s *Server) func(context.Context, http.ResponseWriter, *http.Request) {
+	return func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s.metricSchemas.listViolations()); err != nil {
+			log.WithError(err).Warn("Could not encode schema violations")
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package veneur
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/ssf"
+)
+
+const testMetricSchemas = `
+metrics:
+  - name: api.request.duration
+    type: timer
+    unit: ms
+    tags: [service, endpoint]
+  - name: api.requests
+    type: counter
+`
+
+func TestParseMetricSchemas(t *testing.T) {
+	schemas, err := parseMetricSchemas([]byte(testMetricSchemas))
+	require.NoError(t, err)
+	require.Len(t, schemas, 2)
+	assert.Equal(t, "timer", schemas["api.request.duration"].Type)
+	assert.Len(t, schemas["api.request.duration"].tagKeys, 2)
+	assert.Nil(t, schemas["api.requests"].tagKeys, "tags should not be enforced if none are declared")
+
+	invalid := map[string]string{
+		"no name":      "metrics:\n  - type: counter\n",
+		"duplicate":    "metrics:\n  - name: a\n  - name: a\n",
+		"unknown type": "metrics:\n  - name: a\n    type: meter\n",
+		"unknown key":  "metrics:\n  - name: a\n    kind: counter\n",
+	}
+	for name, registry := range invalid {
+		_, err := parseMetricSchemas([]byte(registry))
+		assert.Error(t, err, name)
+	}
+}
+
+func testSchemaRegistry(t *testing.T, mode string) *schemaRegistry {
+	r, err := newSchemaRegistry("unused", mode, nil)
+	require.NoError(t, err)
+	schemas, err := parseMetricSchemas([]byte(testMetricSchemas))
+	require.NoError(t, err)
+	r.schemas.Store(schemas)
+	return r
+}
+
+func TestSchemaRegistryCheck(t *testing.T) {
+	metric := func(name, typ, unit string, tags ...string) *samplers.UDPMetric {
+		return &samplers.UDPMetric{
+			MetricKey: samplers.MetricKey{Name: name, Type: typ},
+			Unit:      unit,
+			Tags:      tags,
+		}
+	}
+	tests := []struct {
+		name      string
+		metric    *samplers.UDPMetric
+		violation string
+	}{
+		{"valid", metric("api.request.duration", "timer", "ms", "service:a", "endpoint:b"), ""},
+		{"undeclared", metric("other", "gauge", "s", "foo:bar"), ""},
+		{"no unit", metric("api.request.duration", "timer", ""), ""},
+		{"magic tags", metric("api.request.duration", "timer", "", "veneursinkonly:datadog"), ""},
+		{"any tags", metric("api.requests", "counter", "", "foo:bar"), ""},
+		{"type", metric("api.request.duration", "gauge", "ms"), schemaViolationType},
+		{"unit", metric("api.request.duration", "timer", "s"), schemaViolationUnit},
+		{"tag", metric("api.request.duration", "timer", "", "service:a", "user_id:5"), schemaViolationTag},
+	}
+	for _, elt := range tests {
+		test := elt
+		t.Run(test.name, func(t *testing.T) {
+			warn := testSchemaRegistry(t, "warn")
+			assert.True(t, warn.check(test.metric), "warn mode should never drop metrics")
+
+			reject := testSchemaRegistry(t, "reject")
+			assert.Equal(t, test.violation == "", reject.check(test.metric))
+
+			violations := reject.listViolations()
+			if test.violation == "" {
+				assert.Empty(t, violations)
+				return
+			}
+			if assert.Len(t, violations, 1) {
+				assert.Equal(t, test.violation, violations[0].Violation)
+				assert.Equal(t, int64(1), violations[0].Count)
+			}
+		})
+	}
+}
+
+func TestSchemaRegistryReport(t *testing.T) {
+	r := testSchemaRegistry(t, "reject")
+	r.check(&samplers.UDPMetric{MetricKey: samplers.MetricKey{Name: "api.requests", Type: "gauge"}})
+	r.check(&samplers.UDPMetric{MetricKey: samplers.MetricKey{Name: "api.requests", Type: "gauge"}})
+
+	samples := r.report()
+	require.Len(t, samples, 2)
+	assert.Equal(t, "schema.violations_total", samples[0].Name)
+	assert.Equal(t, float32(2), samples[0].Value)
+	assert.Equal(t, "type", samples[0].Tags["violation"])
+	assert.Equal(t, "schema.rejected_total", samples[1].Name)
+	assert.Equal(t, ssf.CauseSchemaViolation, samples[1].Tags["cause"])
+
+	assert.Empty(t, r.report(), "counts should be reset after reporting")
+	assert.Len(t, r.listViolations(), 1, "violations should still be listed")
+}
+
+func TestSchemaRegistrySources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "veneur-schema")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "schema.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(testMetricSchemas), 0644))
+
+	r, err := newSchemaRegistry(path, "warn", nil)
+	require.NoError(t, err)
+	_, err = r.reload()
+	require.NoError(t, err)
+	assert.Len(t, r.schemas.Load(), 2)
+
+	body := testMetricSchemas
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if body == "" {
+			http.Error(w, "gone", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	r, err = newSchemaRegistry(srv.URL, "warn", srv.Client())
+	require.NoError(t, err)
+	_, err = r.reload()
+	require.NoError(t, err)
+	assert.Len(t, r.schemas.Load(), 2)
+
+	body = "metrics: [{"
+	cause, err := r.reload()
+	assert.Error(t, err)
+	assert.Equal(t, ssf.CauseParseError, cause)
+	assert.Len(t, r.schemas.Load(), 2, "the previous schemas should stay in effect")
+
+	body = ""
+	cause, err = r.reload()
+	assert.Error(t, err)
+	assert.Equal(t, ssf.CauseIOError, cause)
+
+	_, err = newSchemaRegistry(srv.URL, "ignore", nil)
+	assert.Error(t, err, "unknown modes should be rejected")
+}
+
+func TestWorkerSchemaEnforcement(t *testing.T) {
+	w := NewWorker(1, nil, logrus.New(), nil)
+	w.setSchemaRegistry(testSchemaRegistry(t, "reject"))
+
+	w.ProcessMetric(&samplers.UDPMetric{
+		MetricKey: samplers.MetricKey{Name: "api.requests", Type: "gauge"},
+		Value:     1.0,
+		Scope:     samplers.MixedScope,
+	})
+	w.ProcessMetric(&samplers.UDPMetric{
+		MetricKey: samplers.MetricKey{Name: "api.requests", Type: "counter"},
+		Value:     1.0,
+		Scope:     samplers.MixedScope,
+	})
+
+	wm := w.Flush()
+	assert.Empty(t, wm.gauges, "the gauge violates its schema and should be dropped")
+	assert.Len(t, wm.counters, 1)
+}
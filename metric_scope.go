@@ -0,0 +1,100 @@
+package veneur
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/ssf"
+)
+
+// scopeNames are the names of the metric scopes in configuration and
+// in the tags of internal metrics.
+var scopeNames = map[samplers.MetricScope]string{
+	samplers.MixedScope: "mixed",
+	samplers.LocalOnly:  "local",
+	samplers.GlobalOnly: "global",
+}
+
+// scopeRule sets the scope of the metrics of a type (or of any type,
+// if it's empty) that it matches.
+type scopeRule struct {
+	metricMatch
+	metricType string
+	scope      samplers.MetricScope
+}
+
+// metricScopes overrides the scopes of metrics as workers ingest them,
+// so that e.g. a busy histogram can be kept host-local, instead of
+// being forwarded to the global veneurs, without changing its clients.
+type metricScopes struct {
+	rules []scopeRule
+
+	// overridden counts the metrics whose scopes were changed since
+	// the last report, by the scope they were given.
+	overridden [3]int64
+}
+
+// addRule adds a rule that gives matching metrics the named scope.
+// Rules are tried in the order they were added.
+func (s *metricScopes) addRule(namePrefix, tag, metricType, scope string) error {
+	if namePrefix == "" && tag == "" {
+		return fmt.Errorf("metric_scopes: a rule needs a name_prefix or a tag")
+	}
+	switch metricType {
+	case "", counterTypeName, gaugeTypeName, histogramTypeName, setTypeName, timerTypeName:
+	default:
+		return fmt.Errorf("metric_scopes: unknown metric type %q", metricType)
+	}
+	rule := scopeRule{
+		metricMatch: metricMatch{namePrefix: namePrefix, tag: tag},
+		metricType:  metricType,
+		scope:       -1,
+	}
+	for ms, name := range scopeNames {
+		if name == scope {
+			rule.scope = ms
+		}
+	}
+	if rule.scope < 0 {
+		return fmt.Errorf("metric_scopes: scope must be one of mixed, local or global, got %q", scope)
+	}
+	s.rules = append(s.rules, rule)
+	return nil
+}
+
+// override gives a metric the scope of the first rule it matches. The
+// rules take precedence over the veneurlocalonly and veneurglobalonly
+// tags that clients send.
+func (s *metricScopes) override(m *samplers.UDPMetric) {
+	if m.Type == statusTypeName {
+		// status checks are always flushed locally
+		return
+	}
+	for _, rule := range s.rules {
+		if rule.metricType != "" && rule.metricType != m.Type {
+			continue
+		}
+		if !rule.matches(m.Name, m.Tags) {
+			continue
+		}
+		if m.Scope != rule.scope {
+			m.Scope = rule.scope
+			atomic.AddInt64(&s.overridden[rule.scope], 1)
+		}
+		return
+	}
+}
+
+// report returns counters of the metrics whose scopes were changed
+// since the last report, for each scope that any were given.
+func (s *metricScopes) report() []*ssf.SSFSample {
+	var samples []*ssf.SSFSample
+	for scope, name := range scopeNames {
+		if n := atomic.SwapInt64(&s.overridden[scope], 0); n > 0 {
+			samples = append(samples, ssf.Count("worker.metric_scope_overrides_total", float32(n),
+				map[string]string{"scope": name}))
+		}
+	}
+	return samples
+}
@@ -0,0 +1,79 @@
+package veneur
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+)
+
+func testMetricScopes(t *testing.T) *metricScopes {
+	s := &metricScopes{}
+	require.NoError(t, s.addRule("api.latency", "", "histogram", "local"))
+	require.NoError(t, s.addRule("", "team:payments", "", "global"))
+	return s
+}
+
+func TestMetricScopesAddRule(t *testing.T) {
+	s := &metricScopes{}
+	assert.Error(t, s.addRule("", "", "", "local"), "a rule should need a prefix or a tag")
+	assert.Error(t, s.addRule("a.", "", "", "host"), "unknown scopes should be rejected")
+	assert.Error(t, s.addRule("a.", "", "summary", "local"), "unknown metric types should be rejected")
+	assert.NoError(t, s.addRule("a.", "", "", "mixed"))
+}
+
+func TestMetricScopesOverride(t *testing.T) {
+	s := testMetricScopes(t)
+	tests := []struct {
+		name       string
+		metricType string
+		tags       []string
+		scope      samplers.MetricScope
+		expected   samplers.MetricScope
+	}{
+		{"api.latency", "histogram", nil, samplers.MixedScope, samplers.LocalOnly},
+		{"api.latency", "histogram", nil, samplers.GlobalOnly, samplers.LocalOnly},
+		{"api.latency", "timer", nil, samplers.MixedScope, samplers.MixedScope},
+		{"api.requests", "counter", []string{"team:payments"}, samplers.MixedScope, samplers.GlobalOnly},
+		{"api.requests", "counter", []string{"team:search"}, samplers.MixedScope, samplers.MixedScope},
+		{"api.latency", "histogram", []string{"team:payments"}, samplers.MixedScope, samplers.LocalOnly},
+		{"api.up", "status", []string{"team:payments"}, samplers.MixedScope, samplers.MixedScope},
+	}
+	for _, test := range tests {
+		m := samplers.UDPMetric{
+			MetricKey: samplers.MetricKey{Name: test.name, Type: test.metricType},
+			Tags:      test.tags,
+			Scope:     test.scope,
+		}
+		s.override(&m)
+		assert.Equal(t, test.expected, m.Scope, "%s %s %v", test.metricType, test.name, test.tags)
+	}
+
+	samples := s.report()
+	counts := map[string]float32{}
+	for _, sample := range samples {
+		assert.Equal(t, "worker.metric_scope_overrides_total", sample.Name)
+		counts[sample.Tags["scope"]] = sample.Value
+	}
+	assert.Equal(t, map[string]float32{"local": 3, "global": 1}, counts)
+	assert.Empty(t, s.report(), "the counters should reset on every report")
+}
+
+func TestWorkerScopes(t *testing.T) {
+	w := NewWorker(1, nil, logrus.New(), nil)
+	w.PacketChan = make(chan samplers.UDPMetric, 1)
+	w.setMetricScopes(testMetricScopes(t))
+	w.IngestUDP(samplers.UDPMetric{
+		MetricKey:  samplers.MetricKey{Name: "api.latency", Type: "histogram"},
+		Value:      1.0,
+		SampleRate: 1.0,
+	})
+	m := <-w.PacketChan
+	w.ProcessMetric(&m)
+
+	wm := w.Flush()
+	assert.Empty(t, wm.histograms)
+	assert.Len(t, wm.localHistograms, 1, "the histogram should be kept host-local")
+}
@@ -8,6 +8,7 @@ import (
 	"sync"
 
 	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/xdp"
 	flock "github.com/theckman/go-flock"
 )
 
@@ -30,12 +31,14 @@ func StartStatsd(s *Server, a net.Addr, packetPool *sync.Pool) net.Addr {
 // the pool provided.
 type udpProcessor func(net.PacketConn, *sync.Pool)
 
-// startProcessingOnUDP starts network num_readers listeners on the
-// given address in one goroutine each, using the passed pool. When
-// the listener is established, it starts the udpProcessor with the
-// listener.
-func startProcessingOnUDP(s *Server, protocol string, addr *net.UDPAddr, pool *sync.Pool, proc udpProcessor) net.Addr {
-	reusePort := s.numReaders != 1
+// startProcessingOnUDP starts network num_readers listeners (per CPU
+// group) on the given address in one goroutine each, using the
+// passed pool. When the listener is established, it starts the
+// udpProcessor that procForGroup returns for the listener's CPU
+// group with the listener.
+func startProcessingOnUDP(s *Server, protocol string, addr *net.UDPAddr, pool *sync.Pool, procForGroup func(group int) udpProcessor) net.Addr {
+	numReaders := s.numReaders * s.cpuGroupCount()
+	reusePort := numReaders != 1
 	// If we're reusing the port, make sure we're listening on the
 	// exact same address always; this is mostly relevant for
 	// tests, where port is typically 0 and the initial ListenUDP
@@ -50,11 +53,12 @@ func startProcessingOnUDP(s *Server, protocol string, addr *net.UDPAddr, pool *s
 	}
 	addrChan := make(chan net.Addr, 1)
 	once := sync.Once{}
-	for i := 0; i < s.numReaders; i++ {
-		go func() {
+	for i := 0; i < numReaders; i++ {
+		go func(group int) {
 			defer func() {
 				ConsumePanic(s.Sentry, s.TraceClient, s.Hostname, recover())
 			}()
+			s.pinToCPUGroup(group)
 			// each goroutine gets its own socket
 			// if the sockets support SO_REUSEPORT, then this will cause the
 			// kernel to distribute datagrams across them, for better read
@@ -75,19 +79,82 @@ func startProcessingOnUDP(s *Server, protocol string, addr *net.UDPAddr, pool *s
 				log.WithFields(logrus.Fields{
 					"address":   sock.LocalAddr(),
 					"protocol":  protocol,
-					"listeners": s.numReaders,
+					"listeners": numReaders,
 				}).Info("Listening on UDP address")
 				close(addrChan)
 			})
+			if s.tuner != nil {
+				s.tuner.addSocket(sock)
+			}
 
-			proc(sock, pool)
-		}()
+			procForGroup(group)(sock, pool)
+		}(i % s.cpuGroupCount())
 	}
 	return <-addrChan
 }
 
 func startStatsdUDP(s *Server, addr *net.UDPAddr, packetPool *sync.Pool) net.Addr {
-	return startProcessingOnUDP(s, "statsd", addr, packetPool, s.ReadMetricSocket)
+	return startProcessingOnUDP(s, "statsd", addr, packetPool, func(group int) udpProcessor {
+		workers := s.groupWorkers(group)
+		return func(sock net.PacketConn, pool *sync.Pool) {
+			s.readMetricSocket(sock, pool, workers)
+		}
+	})
+}
+
+// startStatsdXDP starts receiving statsd datagrams addressed to the
+// ports of the given UDP addresses through AF_XDP sockets on the
+// configured network interface, with one goroutine per receive
+// queue. As this is a setup routine, if any error occurs, it panics.
+func startStatsdXDP(s *Server, addrs []net.Addr) {
+	var ports []int
+	for _, addr := range addrs {
+		if udpAddr, ok := addr.(*net.UDPAddr); ok {
+			ports = append(ports, udpAddr.Port)
+		}
+	}
+	if len(ports) == 0 {
+		log.Warn("AF_XDP ingestion is configured, but there are no UDP statsd listeners")
+		return
+	}
+
+	listener, err := xdp.Listen(s.xdpInterface, s.xdpQueues, ports)
+	if err != nil {
+		panic(fmt.Sprintf("couldn't listen for AF_XDP traffic on %s: %v", s.xdpInterface, err))
+	}
+	log.WithFields(logrus.Fields{
+		"interface": s.xdpInterface,
+		"queues":    s.xdpQueues,
+		"ports":     ports,
+	}).Info("Listening for statsd metrics via AF_XDP")
+
+	go func() {
+		<-s.shutdown
+		if err := listener.Close(); err != nil {
+			log.WithError(err).Warn("Ignoring error closing AF_XDP listener")
+		}
+	}()
+
+	for queue, sock := range listener.Sockets() {
+		go func(sock *xdp.Socket, group int) {
+			defer func() {
+				ConsumePanic(s.Sentry, s.TraceClient, s.Hostname, recover())
+			}()
+			s.pinToCPUGroup(group)
+			workers := s.groupWorkers(group)
+			for {
+				_, err := sock.Receive(func(datagram []byte) {
+					s.handleMetricDatagram(datagram, workers)
+				})
+				if err == xdp.ErrClosed {
+					return
+				}
+				if err != nil {
+					log.WithError(err).Error("Error reading from AF_XDP socket")
+				}
+			}
+		}(sock, queue%s.cpuGroupCount())
+	}
 }
 
 func startStatsdTCP(s *Server, addr *net.TCPAddr, packetPool *sync.Pool) net.Addr {
@@ -124,6 +191,9 @@ func startStatsdTCP(s *Server, addr *net.TCPAddr, packetPool *sync.Pool) net.Add
 		"address": addr, "mode": mode,
 	}).Info("Listening for statsd metrics on TCP socket")
 
+	// register the listener for packet capture before any
+	// connections come in:
+	s.packetCaptureFor(listener.Addr())
 	go func() {
 		defer func() {
 			ConsumePanic(s.Sentry, s.TraceClient, s.Hostname, recover())
@@ -152,7 +222,9 @@ func StartSSF(s *Server, a net.Addr, tracePool *sync.Pool) net.Addr {
 }
 
 func startSSFUDP(s *Server, addr *net.UDPAddr, tracePool *sync.Pool) net.Addr {
-	return startProcessingOnUDP(s, "ssf", addr, tracePool, s.ReadSSFPacketSocket)
+	return startProcessingOnUDP(s, "ssf", addr, tracePool, func(int) udpProcessor {
+		return s.ReadSSFPacketSocket
+	})
 }
 
 // startSSFUnix starts listening for connections that send framed SSF
@@ -181,6 +253,10 @@ func startSSFUnix(s *Server, addr *net.UnixAddr) (<-chan struct{}, net.Addr) {
 		panic(fmt.Sprintf("Couldn't listen on UNIX socket %v: %v", addr, err))
 	}
 
+	// register the listener for packet capture before any
+	// connections come in:
+	s.packetCaptureFor(listener.Addr())
+
 	// Make the socket connectable by everyone with access to the socket pathname:
 	err = os.Chmod(addr.String(), 0666)
 	if err != nil {
@@ -0,0 +1,290 @@
+package veneur
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+// capturedPacket is a copy of a raw packet (or SSF frame, or
+// line of statsd over TCP) received on a listener.
+type capturedPacket struct {
+	received time.Time
+	data     []byte
+}
+
+// packetCapture keeps a sample of the raw packets received on one
+// listener in a ring buffer, so that malformed traffic can be
+// inspected without running tcpdump on a production host. Captures
+// are started, stopped and downloaded at runtime through the HTTP API
+// (see handlePacketCaptureStart and friends).
+//
+// All methods are safe to call on a nil *packetCapture, which never
+// captures anything.
+type packetCapture struct {
+	listener string
+
+	// rate is the number of packets per second to capture, or 0
+	// if the capture is stopped. It is accessed atomically, so
+	// that listeners can cheaply skip stopped captures.
+	rate int64
+
+	mtx     sync.Mutex
+	second  int64
+	taken   int64
+	packets []capturedPacket
+	next    int
+}
+
+// enabled returns whether packets should currently be passed to
+// capture.
+func (pc *packetCapture) enabled() bool {
+	return pc != nil && atomic.LoadInt64(&pc.rate) > 0
+}
+
+// start discards any previously captured packets and starts
+// capturing up to rate packets per second into a ring buffer of the
+// given size.
+func (pc *packetCapture) start(rate, size int) {
+	pc.mtx.Lock()
+	defer pc.mtx.Unlock()
+	pc.packets = make([]capturedPacket, 0, size)
+	pc.next = 0
+	pc.taken = 0
+	atomic.StoreInt64(&pc.rate, int64(rate))
+}
+
+// stop stops capturing packets. The packets captured so far can
+// still be downloaded.
+func (pc *packetCapture) stop() {
+	atomic.StoreInt64(&pc.rate, 0)
+}
+
+// capture records a copy of packet, unless the capture is stopped or
+// has already captured its rate of packets in the current second.
+func (pc *packetCapture) capture(packet []byte) {
+	if !pc.enabled() {
+		return
+	}
+	now := time.Now()
+
+	pc.mtx.Lock()
+	defer pc.mtx.Unlock()
+	if sec := now.Unix(); sec != pc.second {
+		pc.second = sec
+		pc.taken = 0
+	}
+	if pc.taken >= atomic.LoadInt64(&pc.rate) || cap(pc.packets) == 0 {
+		return
+	}
+	pc.taken++
+
+	captured := capturedPacket{received: now, data: append([]byte(nil), packet...)}
+	if len(pc.packets) < cap(pc.packets) {
+		pc.packets = append(pc.packets, captured)
+		return
+	}
+	pc.packets[pc.next] = captured
+	pc.next = (pc.next + 1) % len(pc.packets)
+}
+
+// captured returns the packets in the ring buffer, oldest first.
+func (pc *packetCapture) captured() []capturedPacket {
+	pc.mtx.Lock()
+	defer pc.mtx.Unlock()
+	packets := make([]capturedPacket, 0, len(pc.packets))
+	packets = append(packets, pc.packets[pc.next:]...)
+	return append(packets, pc.packets[:pc.next]...)
+}
+
+// writeTo writes the captured packets to w, oldest first. Each packet
+// is preceded by a line holding the time it was received and its
+// length in bytes, and followed by a newline.
+func (pc *packetCapture) writeTo(w io.Writer) error {
+	for _, p := range pc.captured() {
+		_, err := fmt.Fprintf(w, "%s %d\n%s\n", p.received.Format(time.RFC3339Nano), len(p.data), p.data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listenerName returns the name under which the listener on addr
+// appears in the packet capture API, in veneur's address format,
+// e.g. "udp://127.0.0.1:8126".
+func listenerName(addr net.Addr) string {
+	return addr.Network() + "://" + addr.String()
+}
+
+// packetCaptureFor returns the packet capture for the listener on
+// addr, registering it if necessary. If packet capture is disabled,
+// it returns nil.
+func (s *Server) packetCaptureFor(addr net.Addr) *packetCapture {
+	if !s.packetCaptureEnabled || addr == nil {
+		return nil
+	}
+	name := listenerName(addr)
+
+	s.packetCapturesMtx.Lock()
+	defer s.packetCapturesMtx.Unlock()
+	if s.packetCaptures == nil {
+		s.packetCaptures = map[string]*packetCapture{}
+	}
+	pc, ok := s.packetCaptures[name]
+	if !ok {
+		pc = &packetCapture{listener: name}
+		s.packetCaptures[name] = pc
+	}
+	return pc
+}
+
+// lookupPacketCapture returns the packet capture of the listener
+// named in the request's "listener" parameter, or writes an error
+// response and returns nil if there is no such listener.
+func (s *Server) lookupPacketCapture(w http.ResponseWriter, r *http.Request) *packetCapture {
+	name := r.URL.Query().Get("listener")
+	s.packetCapturesMtx.Lock()
+	pc, ok := s.packetCaptures[name]
+	s.packetCapturesMtx.Unlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("no listener named %q", name), http.StatusNotFound)
+		return nil
+	}
+	return pc
+}
+
+// packetCaptureStatus is the JSON representation of a listener's
+// packet capture.
+type packetCaptureStatus struct {
+	Listener string `json:"listener"`
+	Rate     int64  `json:"rate"`
+	Size     int    `json:"size"`
+	Captured int    `json:"captured"`
+}
+
+// handlePacketCaptures lists the listeners that packets can be
+// captured from, and the state of their captures.
+func handlePacketCaptures(s *Server) func(context.Context, http.ResponseWriter, *http.Request) {
+	return func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		s.packetCapturesMtx.Lock()
+		statuses := make([]packetCaptureStatus, 0, len(s.packetCaptures))
+		for _, pc := range s.packetCaptures {
+			pc.mtx.Lock()
+			statuses = append(statuses, packetCaptureStatus{
+				Listener: pc.listener,
+				Rate:     atomic.LoadInt64(&pc.rate),
+				Size:     cap(pc.packets),
+				Captured: len(pc.packets),
+			})
+			pc.mtx.Unlock()
+		}
+		s.packetCapturesMtx.Unlock()
+		sort.Slice(statuses, func(i, j int) bool {
+			return statuses[i].Listener < statuses[j].Listener
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(statuses); err != nil {
+			log.WithError(err).Warn("Could not encode packet captures")
+		}
+	}
+}
+
+// handlePacketCaptureStart starts capturing "rate" packets per second
+// from a listener, into a ring buffer holding "size" packets (by
+// default, and at most, packet_capture_max_packets).
+func handlePacketCaptureStart(s *Server) func(context.Context, http.ResponseWriter, *http.Request) {
+	return func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		pc := s.lookupPacketCapture(w, r)
+		if pc == nil {
+			return
+		}
+		rate, err := strconv.Atoi(r.URL.Query().Get("rate"))
+		if err != nil || rate <= 0 {
+			http.Error(w, "rate must be a positive number of packets per second", http.StatusBadRequest)
+			return
+		}
+		size := s.packetCaptureMaxPackets
+		if param := r.URL.Query().Get("size"); param != "" {
+			size, err = strconv.Atoi(param)
+			if err != nil || size <= 0 {
+				http.Error(w, "size must be a positive number of packets", http.StatusBadRequest)
+				return
+			}
+			if size > s.packetCaptureMaxPackets {
+				size = s.packetCaptureMaxPackets
+			}
+		}
+
+		pc.start(rate, size)
+		log.WithFields(logrus.Fields{
+			"listener": pc.listener,
+			"rate":     rate,
+			"size":     size,
+		}).Info("Started packet capture")
+		w.Write([]byte("ok\n"))
+	}
+}
+
+// handlePacketCaptureStop stops capturing packets from a listener.
+func handlePacketCaptureStop(s *Server) func(context.Context, http.ResponseWriter, *http.Request) {
+	return func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		pc := s.lookupPacketCapture(w, r)
+		if pc == nil {
+			return
+		}
+		pc.stop()
+		log.WithField("listener", pc.listener).Info("Stopped packet capture")
+		w.Write([]byte("ok\n"))
+	}
+}
+
+// handlePacketCaptureDownload serves the packets captured from a
+// listener as a file.
+func handlePacketCaptureDownload(s *Server) func(context.Context, http.ResponseWriter, *http.Request) {
+	return func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		pc := s.lookupPacketCapture(w, r)
+		if pc == nil {
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", `attachment; filename="veneur-packets.log"`)
+		if err := pc.writeTo(w); err != nil {
+			log.WithError(err).Warn("Could not write captured packets")
+		}
+	}
+}
+
+// frameRecorder is an io.Reader that keeps a copy of everything read
+// through it while recording, so that the raw bytes of SSF frames
+// can be captured from stream connections.
+type frameRecorder struct {
+	r         io.Reader
+	recording bool
+	frame     []byte
+}
+
+// reset discards the recorded bytes and turns recording on or off.
+func (fr *frameRecorder) reset(recording bool) {
+	fr.recording = recording
+	fr.frame = fr.frame[:0]
+}
+
+func (fr *frameRecorder) Read(p []byte) (int, error) {
+	n, err := fr.r.Read(p)
+	if fr.recording {
+		fr.frame = append(fr.frame, p[:n]...)
+	}
+	return n, err
+}
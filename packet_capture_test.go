@@ -0,0 +1,133 @@
+package veneur
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/protocol"
+	"github.com/stripe/veneur/ssf"
+)
+
+func TestPacketCapture(t *testing.T) {
+	pc := &packetCapture{listener: "udp://127.0.0.1:8126"}
+	pc.capture([]byte("before.start:1|c"))
+	assert.Empty(t, pc.captured(), "a stopped capture shouldn't capture anything")
+
+	pc.start(3, 2)
+	for i := 0; i < 5; i++ {
+		pc.capture([]byte(fmt.Sprintf("a.b.c:%d|c", i)))
+	}
+	packets := pc.captured()
+	if assert.Len(t, packets, 2) {
+		// only the first 3 packets in this second are taken,
+		// and the first of those was overwritten:
+		assert.Equal(t, "a.b.c:1|c", string(packets[0].data))
+		assert.Equal(t, "a.b.c:2|c", string(packets[1].data))
+	}
+
+	pc.stop()
+	pc.second = 0 // pretend the second is over
+	pc.capture([]byte("after.stop:1|c"))
+	assert.Len(t, pc.captured(), 2, "stopping should keep the captured packets")
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, pc.writeTo(buf))
+	lines := strings.Split(buf.String(), "\n")
+	require.Len(t, lines, 5)
+	assert.True(t, strings.HasSuffix(lines[0], " 9"), "header %q should end in the length", lines[0])
+	assert.Equal(t, "a.b.c:1|c", lines[1])
+	assert.Equal(t, "a.b.c:2|c", lines[3])
+
+	var nilCapture *packetCapture
+	assert.False(t, nilCapture.enabled())
+	nilCapture.capture([]byte("nothing"))
+}
+
+func TestFrameRecorder(t *testing.T) {
+	span := &ssf.SSFSpan{Id: 1, TraceId: 2, StartTimestamp: 1, EndTimestamp: 2, Name: "frame"}
+	stream := &bytes.Buffer{}
+	_, err := protocol.WriteSSF(stream, span)
+	require.NoError(t, err)
+	frame := append([]byte(nil), stream.Bytes()...)
+	_, err = protocol.WriteSSF(stream, span)
+	require.NoError(t, err)
+
+	in := &frameRecorder{r: stream}
+	in.reset(true)
+	_, err = protocol.ReadSSF(in)
+	require.NoError(t, err)
+	assert.Equal(t, frame, in.frame, "the whole frame should be recorded")
+
+	in.reset(false)
+	_, err = protocol.ReadSSF(in)
+	require.NoError(t, err)
+	assert.Empty(t, in.frame)
+}
+
+func TestPacketCaptureEndpoints(t *testing.T) {
+	config := localConfig()
+	config.PacketCaptureEnabled = true
+	config.PacketCaptureMaxPackets = 10
+	s := setupVeneurServer(t, config, nil, nil, nil)
+	defer s.Shutdown()
+	handler := s.Handler()
+
+	request := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	w := request(http.MethodGet, "/debug/packets")
+	require.Equal(t, http.StatusOK, w.Code)
+	var statuses []packetCaptureStatus
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&statuses))
+	require.Len(t, statuses, 2, "there should be a statsd and an SSF listener")
+	listener := statuses[0].Listener
+	require.True(t, strings.HasPrefix(listener, "udp://"), "unexpected listener %q", listener)
+
+	assert.Equal(t, http.StatusNotFound, request(http.MethodPost, "/debug/packets/start?listener=udp://nowhere&rate=10").Code)
+	assert.Equal(t, http.StatusBadRequest, request(http.MethodPost, "/debug/packets/start?listener="+listener+"&rate=0").Code)
+	require.Equal(t, http.StatusOK, request(http.MethodPost, "/debug/packets/start?listener="+listener+"&rate=10&size=100").Code)
+
+	w = request(http.MethodGet, "/debug/packets")
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&statuses))
+	assert.Equal(t, packetCaptureStatus{Listener: listener, Rate: 10, Size: 10}, statuses[0],
+		"the size should be capped at packet_capture_max_packets")
+
+	conn, err := net.Dial("udp", strings.TrimPrefix(listener, "udp://"))
+	require.NoError(t, err)
+	defer conn.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.packetCaptureFor(conn.RemoteAddr()).captured()) == 0 {
+		require.True(t, time.Now().Before(deadline), "no packets were captured")
+		conn.Write([]byte("malformed|packet"))
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	require.Equal(t, http.StatusOK, request(http.MethodPost, "/debug/packets/stop?listener="+listener).Code)
+	w = request(http.MethodGet, "/debug/packets/download?listener="+listener)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "attachment")
+	assert.Contains(t, w.Body.String(), "\nmalformed|packet\n")
+}
+
+func TestPacketCaptureDisabled(t *testing.T) {
+	config := localConfig()
+	s := setupVeneurServer(t, config, nil, nil, nil)
+	defer s.Shutdown()
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/packets", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Nil(t, s.packetCaptureFor(&net.UDPAddr{}))
+}
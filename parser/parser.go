@@ -0,0 +1,262 @@
+// Package parser parses the DogStatsD and SSF formats that veneur
+// listens for, with the same code that veneur itself uses, so that
+// other programs that consume those formats parse them identically.
+//
+// The types in this package are part of veneur's stable API: fields
+// may be added to them, but existing ones won't change meaning or be
+// removed. The parsers only read their input; they never modify it or
+// hold on to it.
+package parser
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/stripe/veneur/protocol/dogstatsd"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/ssf"
+)
+
+// MetricType is the type of a metric.
+type MetricType string
+
+// The types of metrics.
+const (
+	Counter   MetricType = "counter"
+	Gauge     MetricType = "gauge"
+	Histogram MetricType = "histogram"
+	Timer     MetricType = "timer"
+	Set       MetricType = "set"
+	// Status metrics only come from SSF samples; their value is a
+	// ServiceCheckStatus.
+	Status MetricType = "status"
+)
+
+// Scope is where veneur aggregates a metric: on the local veneur that
+// received it, on the global veneur that it's forwarded to, or,
+// for histograms and timers, partly on each.
+type Scope int
+
+// The scopes of metrics. The zero value is the default scope of the
+// metric's type.
+const (
+	ScopeDefault Scope = iota
+	// ScopeLocal is for metrics with the veneurlocalonly tag.
+	ScopeLocal
+	// ScopeGlobal is for metrics with the veneurglobalonly tag.
+	ScopeGlobal
+)
+
+func scopeOf(scope samplers.MetricScope) Scope {
+	switch scope {
+	case samplers.LocalOnly:
+		return ScopeLocal
+	case samplers.GlobalOnly:
+		return ScopeGlobal
+	}
+	return ScopeDefault
+}
+
+// Metric is a metric sample.
+type Metric struct {
+	Name string
+	Type MetricType
+	// Value is the value of counters, gauges, histograms, timers and
+	// statuses.
+	Value float64
+	// Member is the value of sets, which are of strings.
+	Member string
+	// SampleRate is the rate the sample was taken at, in (0, 1].
+	SampleRate float32
+	// Tags are the metric's tags, sorted, in "key:value" or "key"
+	// form. The veneurlocalonly and veneurglobalonly tags are removed
+	// and set Scope instead.
+	Tags  []string
+	Scope Scope
+	// Unit is the unit that the metric was measured in, if known.
+	// Only metrics from SSF samples have units.
+	Unit string
+}
+
+func metricOf(m *samplers.UDPMetric) Metric {
+	metric := Metric{
+		Name:       m.Name,
+		Type:       MetricType(m.Type),
+		SampleRate: m.SampleRate,
+		Tags:       m.Tags,
+		Scope:      scopeOf(m.Scope),
+		Unit:       m.Unit,
+	}
+	switch v := m.Value.(type) {
+	case float64:
+		metric.Value = v
+	case string:
+		metric.Member = v
+	case ssf.SSFSample_Status:
+		metric.Value = float64(v)
+	}
+	return metric
+}
+
+// ParseMetric parses a DogStatsD metric, like "api.requests:1|c|#route:/".
+func ParseMetric(line []byte) (Metric, error) {
+	m, err := samplers.ParseMetric(line)
+	if err != nil {
+		return Metric{}, err
+	}
+	return metricOf(m), nil
+}
+
+// Event is a DogStatsD event.
+type Event struct {
+	Title string
+	Text  string
+	// Timestamp is when the event happened; it's the time it was
+	// parsed if the event doesn't say.
+	Timestamp      time.Time
+	Hostname       string
+	AggregationKey string
+	// Priority is "normal" or "low", or "" if the event doesn't say.
+	Priority   string
+	SourceType string
+	// AlertType is "error", "warning", "info" or "success", or "" if
+	// the event doesn't say.
+	AlertType string
+	// Tags maps the event's tag keys to their values, which are ""
+	// for tags without a value.
+	Tags map[string]string
+}
+
+// ParseEvent parses a DogStatsD event, like "_e{5,4}:title|text".
+func ParseEvent(line []byte) (Event, error) {
+	sample, err := samplers.ParseEvent(line)
+	if err != nil {
+		return Event{}, err
+	}
+	// the parser passes the event's fields on in special tags:
+	tags := make(map[string]string, len(sample.Tags))
+	for k, v := range sample.Tags {
+		tags[k] = v
+	}
+	field := func(key string) string {
+		v := tags[key]
+		delete(tags, key)
+		return v
+	}
+	delete(tags, dogstatsd.EventIdentifierKey)
+	return Event{
+		Title:          sample.Name,
+		Text:           sample.Message,
+		Timestamp:      time.Unix(sample.Timestamp, 0),
+		Hostname:       field(dogstatsd.EventHostnameTagKey),
+		AggregationKey: field(dogstatsd.EventAggregationKeyTagKey),
+		Priority:       field(dogstatsd.EventPriorityTagKey),
+		SourceType:     field(dogstatsd.EventSourceTypeTagKey),
+		AlertType:      field(dogstatsd.EventAlertTypeTagKey),
+		Tags:           tags,
+	}, nil
+}
+
+// ServiceCheckStatus is the status of a service check.
+type ServiceCheckStatus int
+
+// The statuses of service checks, with their DogStatsD values.
+const (
+	StatusOK       ServiceCheckStatus = 0
+	StatusWarning  ServiceCheckStatus = 1
+	StatusCritical ServiceCheckStatus = 2
+	StatusUnknown  ServiceCheckStatus = 3
+)
+
+func (s ServiceCheckStatus) String() string {
+	switch s {
+	case StatusOK:
+		return "ok"
+	case StatusWarning:
+		return "warning"
+	case StatusCritical:
+		return "critical"
+	}
+	return "unknown"
+}
+
+// ServiceCheck is a DogStatsD service check.
+type ServiceCheck struct {
+	Name   string
+	Status ServiceCheckStatus
+	// Timestamp is when the check ran; it's the time it was parsed
+	// if the check doesn't say.
+	Timestamp time.Time
+	Hostname  string
+	Message   string
+	// Tags are the check's tags, sorted, like a Metric's.
+	Tags  []string
+	Scope Scope
+}
+
+// ParseServiceCheck parses a DogStatsD service check, like
+// "_sc|db.up|0|m:ok".
+func ParseServiceCheck(line []byte) (ServiceCheck, error) {
+	m, err := samplers.ParseServiceCheck(line)
+	if err != nil {
+		return ServiceCheck{}, err
+	}
+	status := StatusUnknown
+	if s, ok := m.Value.(ssf.SSFSample_Status); ok {
+		status = ServiceCheckStatus(s)
+	}
+	return ServiceCheck{
+		Name:      m.Name,
+		Status:    status,
+		Timestamp: time.Unix(m.Timestamp, 0),
+		Hostname:  m.HostName,
+		Message:   m.Message,
+		Tags:      m.Tags,
+		Scope:     scopeOf(m.Scope),
+	}, nil
+}
+
+// Packet holds what a DogStatsD packet contained.
+type Packet struct {
+	Metrics       []Metric
+	Events        []Event
+	ServiceChecks []ServiceCheck
+}
+
+// ParseDogStatsD parses a DogStatsD packet of newline-separated
+// metrics, events and service checks, telling them apart the way
+// veneur does. Empty lines are skipped. Lines that fail to parse are
+// skipped too, and the error of the first one is returned along with
+// everything else the packet contained.
+func ParseDogStatsD(packet []byte) (Packet, error) {
+	var p Packet
+	var firstErr error
+	lines := samplers.NewSplitBytes(packet, '\n')
+	for lines.Next() {
+		line := lines.Chunk()
+		var err error
+		switch {
+		case len(line) == 0:
+			continue
+		case bytes.HasPrefix(line, []byte("_e{")):
+			var event Event
+			if event, err = ParseEvent(line); err == nil {
+				p.Events = append(p.Events, event)
+			}
+		case bytes.HasPrefix(line, []byte("_sc")):
+			var check ServiceCheck
+			if check, err = ParseServiceCheck(line); err == nil {
+				p.ServiceChecks = append(p.ServiceChecks, check)
+			}
+		default:
+			var metric Metric
+			if metric, err = ParseMetric(line); err == nil {
+				p.Metrics = append(p.Metrics, metric)
+			}
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return p, firstErr
+}
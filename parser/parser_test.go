@@ -0,0 +1,134 @@
+package parser_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/parser"
+	"github.com/stripe/veneur/protocol"
+	"github.com/stripe/veneur/ssf"
+)
+
+func TestParseMetric(t *testing.T) {
+	m, err := parser.ParseMetric([]byte("a.b.c:1.5|h|@0.5|#foo:bar,veneurglobalonly,baz"))
+	require.NoError(t, err)
+	assert.Equal(t, parser.Metric{
+		Name:       "a.b.c",
+		Type:       parser.Histogram,
+		Value:      1.5,
+		SampleRate: 0.5,
+		Tags:       []string{"baz", "foo:bar"},
+		Scope:      parser.ScopeGlobal,
+	}, m)
+
+	m, err = parser.ParseMetric([]byte("users:alice|s"))
+	require.NoError(t, err)
+	assert.Equal(t, parser.Set, m.Type)
+	assert.Equal(t, "alice", m.Member)
+
+	_, err = parser.ParseMetric([]byte("a.b.c:1|x"))
+	assert.Error(t, err)
+}
+
+func TestParseEvent(t *testing.T) {
+	e, err := parser.ParseEvent([]byte(`_e{5,10}:title|text\nmore|d:1136239445|h:example.com|k:deploys|p:low|s:chef|t:warning|#env:prod,canary`))
+	require.NoError(t, err)
+	assert.Equal(t, parser.Event{
+		Title:          "title",
+		Text:           "text\nmore",
+		Timestamp:      time.Unix(1136239445, 0),
+		Hostname:       "example.com",
+		AggregationKey: "deploys",
+		Priority:       "low",
+		SourceType:     "chef",
+		AlertType:      "warning",
+		Tags:           map[string]string{"env": "prod", "canary": ""},
+	}, e)
+
+	_, err = parser.ParseEvent([]byte("_e{5,4}:title|text|p:urgent"))
+	assert.Error(t, err)
+}
+
+func TestParseServiceCheck(t *testing.T) {
+	sc, err := parser.ParseServiceCheck([]byte(`_sc|db.up|2|d:1136239445|h:db-1|#role:primary|m:replica\nlag`))
+	require.NoError(t, err)
+	assert.Equal(t, parser.ServiceCheck{
+		Name:      "db.up",
+		Status:    parser.StatusCritical,
+		Timestamp: time.Unix(1136239445, 0),
+		Hostname:  "db-1",
+		Message:   "replica\nlag",
+		Tags:      []string{"role:primary"},
+	}, sc)
+	assert.Equal(t, "critical", sc.Status.String())
+
+	_, err = parser.ParseServiceCheck([]byte("_sc|db.up|4"))
+	assert.Error(t, err)
+}
+
+func TestParseDogStatsD(t *testing.T) {
+	packet := []byte("a:1|c\n\n_e{1,1}:t|x\nbroken\n_sc|b|0\nc:2|g\n")
+	p, err := parser.ParseDogStatsD(packet)
+	assert.Error(t, err, "the broken line should be reported")
+	require.Len(t, p.Metrics, 2)
+	assert.Equal(t, "a", p.Metrics[0].Name)
+	assert.Equal(t, "c", p.Metrics[1].Name)
+	require.Len(t, p.Events, 1)
+	assert.Equal(t, "t", p.Events[0].Title)
+	require.Len(t, p.ServiceChecks, 1)
+	assert.Equal(t, parser.StatusOK, p.ServiceChecks[0].Status)
+	assert.Equal(t, []byte("a:1|c\n\n_e{1,1}:t|x\nbroken\n_sc|b|0\nc:2|g\n"), packet, "the packet shouldn't be modified")
+}
+
+func TestReadSSF(t *testing.T) {
+	span := &ssf.SSFSpan{
+		Id:             1,
+		TraceId:        2,
+		Name:           "op",
+		Service:        "srv",
+		StartTimestamp: 1,
+		EndTimestamp:   2,
+		Metrics: []*ssf.SSFSample{
+			ssf.Count("requests", 3, map[string]string{"route": "/"}),
+			ssf.Gauge("", 1, nil),
+		},
+	}
+	buf := &bytes.Buffer{}
+	_, err := protocol.WriteSSF(buf, span)
+	require.NoError(t, err)
+
+	spans, err := parser.ReadSSF(buf)
+	require.NoError(t, err)
+	require.Len(t, spans, 1)
+	assert.Equal(t, "op", spans[0].Name)
+
+	metrics, err := parser.SpanMetrics(spans[0])
+	assert.Error(t, err, "the sample without a name should be reported")
+	assert.Equal(t, []parser.Metric{{
+		Name:       "requests",
+		Type:       parser.Counter,
+		Value:      3,
+		SampleRate: 1,
+		Tags:       []string{"route:/"},
+	}}, metrics)
+
+	_, err = parser.ReadSSF(buf)
+	assert.False(t, parser.IsFramingError(err), "the end of the stream isn't a framing error")
+}
+
+func ExampleParseDogStatsD() {
+	p, err := parser.ParseDogStatsD([]byte("api.requests:1|c|#route:/\napi.latency:12|ms"))
+	if err != nil {
+		panic(err)
+	}
+	for _, m := range p.Metrics {
+		fmt.Println(m.Name, m.Type, m.Value, m.Tags)
+	}
+	// Output:
+	// api.requests counter 1 [route:/]
+	// api.latency timer 12 []
+}
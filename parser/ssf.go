@@ -0,0 +1,51 @@
+package parser
+
+import (
+	"io"
+
+	"github.com/stripe/veneur/protocol"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/ssf"
+)
+
+// ParseSSF parses an SSF span encoded as protobuf, as veneur receives
+// them in UDP packets. Fields that older clients leave out are filled
+// in the way veneur does.
+func ParseSSF(packet []byte) (*ssf.SSFSpan, error) {
+	return protocol.ParseSSF(packet)
+}
+
+// ReadSSF reads the next framed span, or batch of spans, from a stream,
+// as veneur receives them on UNIX domain and TCP sockets. It returns
+// io.EOF once the stream ends between frames. If IsFramingError is true
+// of an error it returns, the stream is broken and can't be read any
+// further; other errors only affect the frame they were returned for.
+func ReadSSF(in io.Reader) ([]*ssf.SSFSpan, error) {
+	return protocol.ReadSSFBatch(in)
+}
+
+// IsFramingError returns whether an error returned by ReadSSF means
+// that the stream it was reading from is broken.
+func IsFramingError(err error) bool {
+	return protocol.IsFramingError(err)
+}
+
+// ParseSSFBody parses the body of a request to veneur's /ssf HTTP
+// endpoint, whose encoding the request's Content-Type gives: protobuf
+// (the default), JSON or MessagePack.
+func ParseSSFBody(contentType string, body []byte) ([]*ssf.SSFSpan, error) {
+	return protocol.ParseSSFBody(contentType, body)
+}
+
+// SpanMetrics returns the metrics that an SSF span carries in its
+// samples. Samples that aren't valid metrics are skipped, and an error
+// that says how many there were is returned along with the valid
+// metrics.
+func SpanMetrics(span *ssf.SSFSpan) ([]Metric, error) {
+	converted, err := samplers.ConvertMetrics(span)
+	metrics := make([]Metric, 0, len(converted))
+	for i := range converted {
+		metrics = append(metrics, metricOf(&converted[i]))
+	}
+	return metrics, err
+}
@@ -549,6 +549,58 @@ func TestGlobalOnlyEscape(t *testing.T) {
 	assert.Contains(t, m.Tags, "tag2:quacks", "tag2 should be preserved in the list of tags after removing magic tags")
 }
 
+func TestPreAggregated(t *testing.T) {
+	m, err := samplers.ParseMetric([]byte("a.b.c:40|c|@0.1|#veneurpreaggregated,veneurglobalonly,tag2:quacks"))
+	assert.NoError(t, err, "should have no error parsing")
+	assert.Equal(t, float32(1), m.SampleRate, "the sample rate of a pre-aggregated metric should be ignored")
+	assert.Equal(t, samplers.GlobalOnly, m.Scope, "should have kept the other magic tags")
+	assert.Equal(t, []string{"tag2:quacks"}, m.Tags, "veneurpreaggregated should not actually be a tag")
+
+	plain, err := samplers.ParseMetric([]byte("a.b.c:40|c|#veneurglobalonly,tag2:quacks"))
+	assert.NoError(t, err, "should have no error parsing")
+	assert.Equal(t, plain.MetricKey, m.MetricKey, "pre-aggregated metrics should aggregate with the others")
+	assert.Equal(t, plain.Digest, m.Digest)
+
+	c := samplers.NewCounter(m.Name, m.Tags)
+	c.Sample(m.Value.(float64), m.SampleRate)
+	c.Sample(2, 1)
+	assert.Equal(t, float64(42), c.Flush(10 * time.Second)[0].Value, "pre-aggregated counts should be summed")
+
+	s, err := samplers.ParseMetricSSF(&ssf.SSFSample{
+		Metric:     ssf.SSFSample_COUNTER,
+		Name:       "a.b.c",
+		Value:      40,
+		SampleRate: 0.1,
+		Tags:       map[string]string{"veneurpreaggregated": ""},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, float32(1), s.SampleRate)
+	assert.Empty(t, s.Tags)
+}
+
+func TestPreAggregatedTagConfigured(t *testing.T) {
+	p := samplers.Parser{PreAggregatedTag: "summed"}
+	m, err := p.ParseMetric([]byte("a.b.c:40|c|@0.1|#summed,veneurpreaggregated"))
+	assert.NoError(t, err, "should have no error parsing")
+	assert.Equal(t, float32(1), m.SampleRate, "the configured tag should mark the metric as pre-aggregated")
+	assert.Equal(t, []string{"veneurpreaggregated"}, m.Tags, "the default tag should be an ordinary tag")
+
+	m, err = samplers.Parser{}.ParseMetric([]byte("a.b.c:40|c|@0.1|#veneurpreaggregated"))
+	assert.NoError(t, err, "should have no error parsing")
+	assert.Equal(t, float32(0.1), m.SampleRate, "no tag should mark metrics as pre-aggregated")
+
+	s, err := p.ParseMetricSSF(&ssf.SSFSample{
+		Metric:     ssf.SSFSample_COUNTER,
+		Name:       "a.b.c",
+		Value:      40,
+		SampleRate: 0.1,
+		Tags:       map[string]string{"summed": ""},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, float32(1), s.SampleRate)
+	assert.Empty(t, s.Tags)
+}
+
 func TestEvents(t *testing.T) {
 	evt, err := samplers.ParseEvent([]byte("_e{3,3}:foo|bar|k:foos|s:test|t:success|p:low|#foo:bar,baz:qux|d:1136239445|h:example.com"))
 	assert.NoError(t, err, "should have parsed correctly")
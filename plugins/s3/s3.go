@@ -45,7 +45,7 @@ func (p *S3Plugin) Flush(ctx context.Context, metrics []samplers.InterMetric) er
 		return err
 	}
 
-	err = p.S3Post(p.Hostname, csv, tsvGzFt)
+	err = p.S3PostWithContext(ctx, p.Hostname, csv, tsvGzFt)
 	if err != nil {
 		p.Logger.WithFields(logrus.Fields{
 			logrus.ErrorKey: err,
@@ -77,6 +77,12 @@ var S3Bucket string
 var S3ClientUninitializedError = errors.New("s3 client has not been initialized")
 
 func (p *S3Plugin) S3Post(hostname string, data io.ReadSeeker, ft filetype) error {
+	return p.S3PostWithContext(context.Background(), hostname, data, ft)
+}
+
+// S3PostWithContext is like S3Post, but gives up on the upload once ctx
+// is done.
+func (p *S3Plugin) S3PostWithContext(ctx context.Context, hostname string, data io.ReadSeeker, ft filetype) error {
 	if p.Svc == nil {
 		return S3ClientUninitializedError
 	}
@@ -86,7 +92,7 @@ func (p *S3Plugin) S3Post(hostname string, data io.ReadSeeker, ft filetype) erro
 		Body:   data,
 	}
 
-	_, err := p.Svc.PutObject(params)
+	_, err := p.Svc.PutObjectWithContext(ctx, params)
 	return err
 }
 
@@ -12,9 +12,10 @@ import (
 // occurs, ResolveAddr returns the respective error.
 //
 // Valid address examples are:
-//   udp6://127.0.0.1:8000
-//   unix:///tmp/foo.sock
-//   tcp://127.0.0.1:9002
+//
+//	udp6://127.0.0.1:8000
+//	unix:///tmp/foo.sock
+//	tcp://127.0.0.1:9002
 func ResolveAddr(str string) (net.Addr, error) {
 	u, err := url.Parse(str)
 	if err != nil {
@@ -0,0 +1,177 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+
+	"github.com/stripe/veneur/ssf"
+	"github.com/vmihailenco/msgpack"
+	"github.com/vmihailenco/msgpack/codes"
+)
+
+// The content types of the SSF encodings that ParseSSFBody accepts.
+const (
+	ContentTypeProtobuf = "application/x-protobuf"
+	ContentTypeJSON     = "application/json"
+	ContentTypeMsgpack  = "application/msgpack"
+)
+
+// UnsupportedContentType is returned by ParseSSFBody for content types
+// that SSF can't be decoded from.
+type UnsupportedContentType struct {
+	ContentType string
+}
+
+func (e *UnsupportedContentType) Error() string {
+	return fmt.Sprintf("can't decode SSF from content type %q", e.ContentType)
+}
+
+// ParseSSFBody decodes the SSF spans in an HTTP request body, encoded
+// according to its content type:
+//
+// A protobuf body (application/x-protobuf, application/octet-stream,
+// or no content type) holds a single span, encoded like an SSF packet.
+//
+// A JSON (application/json) or MessagePack (application/msgpack or
+// application/x-msgpack) body holds either a single span or an array
+// of spans. Spans are maps keyed by the snake_case names of the SSF
+// fields (e.g. "trace_id", "start_timestamp"), with enum fields such as
+// a sample's "metric" given as numbers. Spans that only hold samples,
+// with no IDs, are fine.
+//
+// The spans are normalized like ParseSSF does.
+func ParseSSFBody(contentType string, body []byte) ([]*ssf.SSFSpan, error) {
+	mediaType := ""
+	if contentType != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return nil, &UnsupportedContentType{contentType}
+		}
+	}
+
+	var spans []*ssf.SSFSpan
+	switch mediaType {
+	case "", ContentTypeProtobuf, "application/octet-stream":
+		span, err := ParseSSF(body)
+		if err != nil {
+			return nil, err
+		}
+		return []*ssf.SSFSpan{span}, nil
+	case ContentTypeJSON:
+		trimmed := bytes.TrimLeft(body, " \t\r\n")
+		if len(trimmed) > 0 && trimmed[0] == '[' {
+			if err := json.Unmarshal(body, &spans); err != nil {
+				return nil, err
+			}
+		} else {
+			span := &ssf.SSFSpan{}
+			if err := json.Unmarshal(body, span); err != nil {
+				return nil, err
+			}
+			spans = []*ssf.SSFSpan{span}
+		}
+	case ContentTypeMsgpack, "application/x-msgpack":
+		dec := msgpack.NewDecoder(bytes.NewReader(body))
+		code, err := dec.PeekCode()
+		if err != nil {
+			return nil, err
+		}
+		var decoded []*msgpackSpan
+		if codes.IsFixedArray(code) || code == codes.Array16 || code == codes.Array32 {
+			if err := dec.Decode(&decoded); err != nil {
+				return nil, err
+			}
+		} else {
+			span := &msgpackSpan{}
+			if err := dec.Decode(span); err != nil {
+				return nil, err
+			}
+			decoded = []*msgpackSpan{span}
+		}
+		spans = make([]*ssf.SSFSpan, len(decoded))
+		for i, span := range decoded {
+			if span != nil {
+				spans[i] = span.toSSF()
+			}
+		}
+	default:
+		return nil, &UnsupportedContentType{contentType}
+	}
+
+	for i, span := range spans {
+		if span == nil {
+			return nil, fmt.Errorf("span %d is null", i)
+		}
+		normalizeSpan(span)
+	}
+	return spans, nil
+}
+
+// msgpackSpan is an SSF span as decoded from MessagePack. MessagePack
+// encoders in most languages encode all floating-point numbers as
+// doubles, which can't be decoded into the float32 fields of an
+// ssf.SSFSample directly.
+type msgpackSpan struct {
+	Version        int32             `msgpack:"version"`
+	TraceId        int64             `msgpack:"trace_id"`
+	Id             int64             `msgpack:"id"`
+	ParentId       int64             `msgpack:"parent_id"`
+	StartTimestamp int64             `msgpack:"start_timestamp"`
+	EndTimestamp   int64             `msgpack:"end_timestamp"`
+	Error          bool              `msgpack:"error"`
+	Service        string            `msgpack:"service"`
+	Metrics        []*msgpackSample  `msgpack:"metrics"`
+	Tags           map[string]string `msgpack:"tags"`
+	Indicator      bool              `msgpack:"indicator"`
+	Name           string            `msgpack:"name"`
+}
+
+type msgpackSample struct {
+	Metric     int32             `msgpack:"metric"`
+	Name       string            `msgpack:"name"`
+	Value      float64           `msgpack:"value"`
+	Timestamp  int64             `msgpack:"timestamp"`
+	Message    string            `msgpack:"message"`
+	Status     int32             `msgpack:"status"`
+	SampleRate float64           `msgpack:"sample_rate"`
+	Tags       map[string]string `msgpack:"tags"`
+	Unit       string            `msgpack:"unit"`
+	Priority   int32             `msgpack:"priority"`
+}
+
+func (s *msgpackSpan) toSSF() *ssf.SSFSpan {
+	span := &ssf.SSFSpan{
+		Version:        s.Version,
+		TraceId:        s.TraceId,
+		Id:             s.Id,
+		ParentId:       s.ParentId,
+		StartTimestamp: s.StartTimestamp,
+		EndTimestamp:   s.EndTimestamp,
+		Error:          s.Error,
+		Service:        s.Service,
+		Tags:           s.Tags,
+		Indicator:      s.Indicator,
+		Name:           s.Name,
+	}
+	for _, sample := range s.Metrics {
+		if sample == nil {
+			continue
+		}
+		span.Metrics = append(span.Metrics, &ssf.SSFSample{
+			Metric:     ssf.SSFSample_Metric(sample.Metric),
+			Name:       sample.Name,
+			Value:      float32(sample.Value),
+			Timestamp:  sample.Timestamp,
+			Message:    sample.Message,
+			Status:     ssf.SSFSample_Status(sample.Status),
+			SampleRate: float32(sample.SampleRate),
+			Tags:       sample.Tags,
+			Unit:       sample.Unit,
+			Priority:   ssf.SSFSample_Priority(sample.Priority),
+		})
+	}
+	return span
+}
@@ -0,0 +1,94 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/ssf"
+	"github.com/vmihailenco/msgpack"
+)
+
+func TestParseSSFBody(t *testing.T) {
+	expected := &ssf.SSFSpan{
+		TraceId:        1,
+		Id:             2,
+		StartTimestamp: 9000,
+		EndTimestamp:   9001,
+		Service:        "test-srv",
+		Name:           "op",
+		Tags:           map[string]string{},
+		Metrics: []*ssf.SSFSample{{
+			Metric:     ssf.SSFSample_GAUGE,
+			Name:       "foo.bar",
+			Value:      1.5,
+			SampleRate: 1,
+			Tags:       map[string]string{"baz": "gorch"},
+		}},
+	}
+	span := map[string]interface{}{
+		"trace_id":        1,
+		"id":              2,
+		"start_timestamp": 9000,
+		"end_timestamp":   9001,
+		"service":         "test-srv",
+		// the name is normalized out of the tags:
+		"tags": map[string]string{"name": "op"},
+		"metrics": []interface{}{map[string]interface{}{
+			"metric": 1,
+			"name":   "foo.bar",
+			"value":  1.5,
+			"tags":   map[string]string{"baz": "gorch"},
+		}},
+	}
+
+	protobuf, err := proto.Marshal(expected)
+	require.NoError(t, err)
+	msgpackSpan, err := msgpack.Marshal(span)
+	require.NoError(t, err)
+	msgpackSpans, err := msgpack.Marshal([]interface{}{span, span})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+		spans       int
+	}{
+		{"protobuf", "application/x-protobuf", protobuf, 1},
+		{"no content type", "", protobuf, 1},
+		{"json", "application/json", []byte(`{"trace_id": 1, "id": 2, "start_timestamp": 9000,
+			"end_timestamp": 9001, "service": "test-srv", "tags": {"name": "op"},
+			"metrics": [{"metric": 1, "name": "foo.bar", "value": 1.5, "tags": {"baz": "gorch"}}]}`), 1},
+		{"json array", "application/json; charset=utf-8", []byte(` [{"trace_id": 1, "id": 2, "start_timestamp": 9000,
+			"end_timestamp": 9001, "service": "test-srv", "name": "op",
+			"metrics": [{"metric": 1, "name": "foo.bar", "value": 1.5, "tags": {"baz": "gorch"}}]}]`), 1},
+		{"msgpack", "application/msgpack", msgpackSpan, 1},
+		{"msgpack array", "application/x-msgpack", msgpackSpans, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spans, err := ParseSSFBody(tt.contentType, tt.body)
+			require.NoError(t, err)
+			require.Len(t, spans, tt.spans)
+			for _, span := range spans {
+				assert.Equal(t, expected, span)
+			}
+		})
+	}
+}
+
+func TestParseSSFBodyErrors(t *testing.T) {
+	_, err := ParseSSFBody("text/plain", []byte("foo"))
+	assert.IsType(t, &UnsupportedContentType{}, err)
+
+	_, err = ParseSSFBody("application/json", []byte(`{"id": "foo"}`))
+	assert.Error(t, err)
+
+	_, err = ParseSSFBody("application/json", []byte(`[null]`))
+	assert.Error(t, err)
+
+	_, err = ParseSSFBody("application/msgpack", []byte{})
+	assert.Error(t, err)
+}
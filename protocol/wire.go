@@ -2,20 +2,23 @@
 // wire protocol to read and write framed SSF samples on a streaming
 // network link or other non-seekable medium.
 //
-// SSF Wire Protocol
+// # SSF Wire Protocol
 //
 // SSF uses protobufs internally, which aren't encapsulated or framed
 // in any way that would allow them to be read on a streaming network
 // connection. To counteract that, the SSF Wire Protocol frames SSF
 // messages in the following way:
 //
-//   [ 8 bits - version and type of message]
-//   [32 bits - length of framed message in octets]
-//   [<length> - SSF message]
+//	[ 8 bits - version and type of message]
+//	[32 bits - length of framed message in octets]
+//	[<length> - SSF message]
 //
-// The version and type of message can currently only be set to the
-// value 0, which means that what follows is a protobuf-encoded
-// ssf.SSFSpan.
+// The version and type of message can be set to the value 0, which
+// means that what follows is a protobuf-encoded ssf.SSFSpan, or to the
+// value 1, which means that what follows is a batch of spans: a
+// snappy-compressed sequence of version 0 frames. Batches are only
+// read by ReadSSFBatch, and can't be longer than MaxSSFPacketLength
+// either compressed or decompressed.
 //
 // The length of the framed message is a number of octets (8-bit
 // bytes) in network byte order (big-endian), specifying the number of
@@ -29,6 +32,7 @@
 package protocol
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"sync"
@@ -36,6 +40,7 @@ import (
 	"encoding/binary"
 
 	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
 	"github.com/stripe/veneur/ssf"
 )
 
@@ -48,23 +53,24 @@ const MaxSSFPacketLength uint32 = 16 * 1024 * 1024
 // length.
 const SSFFrameLength uint32 = 1 + 4
 
-// The only version we support right now: A frame with a length
-// followed by an ssf.SSFSpan.
+// A frame with a length followed by an ssf.SSFSpan.
 const version0 uint8 = 0
 
+// A frame with a length followed by a snappy-compressed sequence of
+// version0 frames.
+const version1 uint8 = 1
+
 func readFrame(in io.Reader, length int) ([]byte, error) {
 	bts := make([]byte, length)
 	read := 0
-	for {
+	for read < length {
 		n, err := in.Read(bts[read:])
 		if err != nil {
 			return []byte{}, err
 		}
 		read += n
-		if read == length {
-			return bts, nil
-		}
 	}
+	return bts, nil
 }
 
 // InvalidTrace is an error type indicating that an SSF span was
@@ -107,30 +113,91 @@ func ValidateTrace(span *ssf.SSFSpan) error {
 // at the start of a message (e.g. if a connection was closed after
 // the last message).
 func ReadSSF(in io.Reader) (*ssf.SSFSpan, error) {
+	_, bts, err := readFramed(in, version0)
+	if err != nil {
+		return nil, err
+	}
+	return ParseSSF(bts)
+}
+
+// ReadSSFBatch reads a framed SSF span or batch of spans from a stream
+// and returns the parsed SSFSpan structures. Its errors are the same as
+// ReadSSF's; a batch that can't be decompressed or parsed isn't a
+// framing error.
+func ReadSSFBatch(in io.Reader) ([]*ssf.SSFSpan, error) {
+	version, bts, err := readFramed(in, version0, version1)
+	if err != nil {
+		return nil, err
+	}
+	if version == version1 {
+		return ParseSSFBatch(bts)
+	}
+	span, err := ParseSSF(bts)
+	if err != nil {
+		return nil, err
+	}
+	return []*ssf.SSFSpan{span}, nil
+}
+
+// readFramed reads a frame of one of the given versions from a stream,
+// and returns its version and contents.
+func readFramed(in io.Reader, versions ...uint8) (uint8, []byte, error) {
 	var version uint8
 	var length uint32
 	if err := binary.Read(in, binary.BigEndian, &version); err != nil {
 		if err == io.EOF {
 			// EOF/hang-ups at the start of a new message
 			// are fine, pass them through as-is.
-			return nil, err
+			return 0, nil, err
 		}
-		return nil, &errFramingIO{err}
+		return 0, nil, &errFramingIO{err}
+	}
+	supported := false
+	for _, v := range versions {
+		supported = supported || v == version
 	}
-	if version != version0 {
-		return nil, &errFrameVersion{version}
+	if !supported {
+		return 0, nil, &errFrameVersion{version}
 	}
 	if err := binary.Read(in, binary.BigEndian, &length); err != nil {
-		return nil, &errFramingIO{err}
+		return 0, nil, &errFramingIO{err}
 	}
 	if length > MaxSSFPacketLength {
-		return nil, &errFrameLength{length}
+		return 0, nil, &errFrameLength{length}
 	}
 	bts, err := readFrame(in, int(length))
 	if err != nil {
-		return nil, &errFramingIO{err}
+		return 0, nil, &errFramingIO{err}
 	}
-	return ParseSSF(bts)
+	return version, bts, nil
+}
+
+// ParseSSFBatch takes in the contents of a batch frame and returns the
+// normalized SSFSpans in it.
+func ParseSSFBatch(batch []byte) ([]*ssf.SSFSpan, error) {
+	length, err := snappy.DecodedLen(batch)
+	if err != nil {
+		return nil, fmt.Errorf("SSF batch could not be decompressed: %v", err)
+	}
+	if uint32(length) > MaxSSFPacketLength {
+		return nil, fmt.Errorf("SSF batch decompresses to %d bytes, more than the maximum of %d", length, MaxSSFPacketLength)
+	}
+	frames, err := snappy.Decode(nil, batch)
+	if err != nil {
+		return nil, fmt.Errorf("SSF batch could not be decompressed: %v", err)
+	}
+	var spans []*ssf.SSFSpan
+	in := bytes.NewReader(frames)
+	for in.Len() > 0 {
+		span, err := ReadSSF(in)
+		if err != nil {
+			// The batch frame was read whole, so the stream
+			// it came on is still fine.
+			return nil, fmt.Errorf("invalid span in SSF batch: %v", err)
+		}
+		spans = append(spans, span)
+	}
+	return spans, nil
 }
 
 // ParseSSF takes in a byte slice and returns: a normalized SSFSpan
@@ -148,8 +215,13 @@ func ParseSSF(packet []byte) (*ssf.SSFSpan, error) {
 	if err != nil {
 		return nil, err
 	}
+	normalizeSpan(span)
+	return span, nil
+}
 
-	// Normalize the span:
+// normalizeSpan fills in the fields of a parsed span that older
+// clients leave out.
+func normalizeSpan(span *ssf.SSFSpan) {
 	if span.Tags == nil {
 		span.Tags = map[string]string{}
 	}
@@ -170,7 +242,6 @@ func ParseSSF(packet []byte) (*ssf.SSFSpan, error) {
 			sample.SampleRate = 1
 		}
 	}
-	return span, nil
 }
 
 var pbufPool = sync.Pool{
@@ -199,13 +270,37 @@ func WriteSSF(out io.Writer, ssf *ssf.SSFSpan) (int, error) {
 		pbufPool.Put(pbuf)
 	}()
 
-	if err = binary.Write(out, binary.BigEndian, version0); err != nil {
+	return writeFrame(out, version0, pbuf.Bytes())
+}
+
+// WriteSSFBatch writes SSF spans as a v1 frame, compressed together
+// into one batch, onto a stream and returns the number of bytes
+// written, as well as an error. Only readers that use ReadSSFBatch can
+// read batches.
+//
+// If the error matches IsFramingError, the stream must be considered
+// poisoned and should not be re-used.
+func WriteSSFBatch(out io.Writer, spans []*ssf.SSFSpan) (int, error) {
+	frames := &bytes.Buffer{}
+	for _, span := range spans {
+		if _, err := WriteSSF(frames, span); err != nil {
+			return 0, err
+		}
+	}
+	if frames.Len() > int(MaxSSFPacketLength) {
+		return 0, fmt.Errorf("SSF batch of %d bytes is longer than the maximum of %d", frames.Len(), MaxSSFPacketLength)
+	}
+	return writeFrame(out, version1, snappy.Encode(nil, frames.Bytes()))
+}
+
+func writeFrame(out io.Writer, version uint8, bts []byte) (int, error) {
+	if err := binary.Write(out, binary.BigEndian, version); err != nil {
 		return 0, &errFramingIO{err}
 	}
-	if err = binary.Write(out, binary.BigEndian, uint32(len(pbuf.Bytes()))); err != nil {
+	if err := binary.Write(out, binary.BigEndian, uint32(len(bts))); err != nil {
 		return 0, &errFramingIO{err}
 	}
-	n, err := out.Write(pbuf.Bytes())
+	n, err := out.Write(bts)
 	if err != nil {
 		return n, &errFramingIO{err}
 	}
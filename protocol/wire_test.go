@@ -168,3 +168,54 @@ func BenchmarkParseSSF(b *testing.B) {
 		}
 	}
 }
+
+func TestReadSSFBatch(t *testing.T) {
+	spans := make([]*ssf.SSFSpan, 3)
+	for i := range spans {
+		spans[i] = &ssf.SSFSpan{
+			Version:        1,
+			TraceId:        1,
+			Id:             int64(i + 2),
+			StartTimestamp: 9000,
+			EndTimestamp:   9001,
+			Tags:           map[string]string{},
+		}
+	}
+	buf := bytes.NewBuffer([]byte{})
+	_, err := WriteSSFBatch(buf, spans)
+	require.NoError(t, err)
+	_, err = WriteSSF(buf, spans[0])
+	require.NoError(t, err)
+
+	read, err := ReadSSFBatch(buf)
+	require.NoError(t, err)
+	assert.Equal(t, spans, read)
+	read, err = ReadSSFBatch(buf)
+	require.NoError(t, err, "plain frames should be read too")
+	assert.Equal(t, spans[:1], read)
+	_, err = ReadSSFBatch(buf)
+	assert.Equal(t, io.EOF, err)
+
+	// Readers that don't know about batches should refuse them:
+	_, err = WriteSSFBatch(buf, spans)
+	require.NoError(t, err)
+	_, err = ReadSSF(buf)
+	assert.True(t, IsFramingError(err))
+}
+
+func TestReadSSFBatchBad(t *testing.T) {
+	span := &ssf.SSFSpan{Version: 1, TraceId: 1, Id: 2, StartTimestamp: 9000, EndTimestamp: 9001}
+	buf := bytes.NewBuffer([]byte{})
+	// a batch that isn't compressed:
+	buf.Write([]byte{0x01, 0x00, 0x00, 0x00, 0x04, 0xde, 0xad, 0xbe, 0xef})
+	_, err := WriteSSF(buf, span)
+	require.NoError(t, err)
+
+	_, err = ReadSSFBatch(buf)
+	if assert.Error(t, err) {
+		assert.False(t, IsFramingError(err), "a bad batch shouldn't poison the stream")
+	}
+	read, err := ReadSSFBatch(buf)
+	require.NoError(t, err)
+	assert.Len(t, read, 1)
+}
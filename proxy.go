@@ -23,9 +23,11 @@ import (
 	"github.com/hashicorp/consul/api"
 	"github.com/pkg/profile"
 	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/forwardrpc"
 	vhttp "github.com/stripe/veneur/http"
 	"github.com/stripe/veneur/proxysrv"
 	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/samplers/metricpb"
 	"github.com/stripe/veneur/ssf"
 	"github.com/stripe/veneur/trace"
 	"github.com/stripe/veneur/trace/metrics"
@@ -72,6 +74,10 @@ type Proxy struct {
 	// HTTP
 	// An atomic boolean for whether or not the HTTP server is listening
 	numListeningHTTP *int32
+
+	// degraded, if set, aggregates the metrics that can't be
+	// forwarded while every global destination is unreachable.
+	degraded *degradedAggregator
 }
 
 func NewProxyFromConfig(logger *logrus.Logger, conf ProxyConfig) (p Proxy, err error) {
@@ -236,6 +242,7 @@ func NewProxyFromConfig(logger *logrus.Logger, conf ProxyConfig) (p Proxy, err e
 			trace.Capacity(uint(conf.TracingClientCapacity)),
 			trace.FlushInterval(traceFlushInterval),
 			trace.ReportStatistics(stats, traceMetricsInterval, []string{format}),
+			trace.MaxPacketSize(uint(conf.SsfDestinationMaxPacketSize)),
 		)
 		if err != nil {
 			logger.WithField("ssf_destination_address", conf.SsfDestinationAddress).
@@ -244,13 +251,45 @@ func NewProxyFromConfig(logger *logrus.Logger, conf ProxyConfig) (p Proxy, err e
 		}
 	}
 
+	if conf.DegradedModeEnabled {
+		interval := 10 * time.Second
+		if conf.DegradedModeInterval != "" {
+			interval, err = time.ParseDuration(conf.DegradedModeInterval)
+			if err != nil {
+				logger.WithError(err).Error("Error parsing degraded mode interval")
+				return
+			}
+		}
+		percentiles := conf.DegradedModePercentiles
+		if len(percentiles) == 0 {
+			percentiles = []float64{0.5, 0.75, 0.99}
+		}
+		aggregates := conf.DegradedModeAggregates
+		if len(aggregates) == 0 {
+			aggregates = []string{"min", "max", "count"}
+		}
+		p.degraded = newDegradedAggregator(interval, percentiles, aggregates, p.TraceClient)
+	}
+
 	if conf.GrpcAddress != "" {
+		if err := forwardrpc.ValidCompression(conf.ForwardGrpcCompression); err != nil {
+			logger.WithError(err).Fatal("Invalid forward_grpc_compression")
+		}
 		p.grpcListenAddress = conf.GrpcAddress
-		p.grpcServer, err = proxysrv.New(p.ForwardGRPCDestinations,
+		opts := []proxysrv.Option{
 			proxysrv.WithForwardTimeout(p.ForwardTimeout),
 			proxysrv.WithLog(logrus.NewEntry(log)),
 			proxysrv.WithTraceClient(p.TraceClient),
-		)
+			proxysrv.WithMaxRecvMsgSize(conf.GrpcMaxRecvMsgSize),
+			proxysrv.WithForwardClientOptions(
+				forwardrpc.WithCompression(conf.ForwardGrpcCompression),
+				forwardrpc.WithMaxSendMsgSize(conf.ForwardGrpcMaxSendMsgSize),
+			),
+		}
+		if p.degraded != nil {
+			opts = append(opts, proxysrv.WithForwarded(p.forwardedGRPC))
+		}
+		p.grpcServer, err = proxysrv.New(p.ForwardGRPCDestinations, opts...)
 		if err != nil {
 			logger.WithError(err).Fatal("Failed to initialize the gRPC server")
 		}
@@ -346,6 +385,25 @@ func (p *Proxy) Start() {
 		}()
 	}
 
+	if p.degraded != nil {
+		go func() {
+			defer func() {
+				ConsumePanic(p.Sentry, p.TraceClient, p.Hostname, recover())
+			}()
+			ticker := time.NewTicker(p.degraded.interval)
+			for {
+				select {
+				case <-p.shutdown:
+					ticker.Stop()
+					p.degraded.flush()
+					return
+				case <-ticker.C:
+					p.degraded.flush()
+				}
+			}
+		}()
+	}
+
 	go func() {
 		hostname, _ := os.Hostname()
 		defer func() {
@@ -501,7 +559,8 @@ func (p *Proxy) RefreshDestinations(serviceName string, ring *consistent.Consist
 			"errorType":       reflect.TypeOf(err),
 			"numDestinations": len(destinations),
 		}).Error("Discoverer found zero destinations and/or returned an error. Destinations may be stale!")
-		samples.Add(ssf.Count("discoverer.errors", 1, srvTags))
+		samples.Add(ssf.Count("discoverer.errors", 1, srvTags,
+			ssf.Failure("discoverer", ssf.CauseIOError)))
 		// Return since we got no hosts. We don't want to zero out the list. This
 		// should result in us leaving the "last good" values in the ring.
 		return
@@ -576,8 +635,10 @@ func (p *Proxy) ProxyTraces(ctx context.Context, traces []DatadogTraceSpan) {
 }
 
 // ProxyMetrics takes a slice of JSONMetrics and breaks them up into
-// multiple HTTP requests by MetricKey using the hash ring.
-func (p *Proxy) ProxyMetrics(ctx context.Context, jsonMetrics []samplers.JSONMetric, origin string) {
+// multiple HTTP requests by MetricKey using the hash ring. The requests
+// pass on the ID of the reporter that sent the metrics, the end of the
+// interval they're from and their batch within it, if it sent them.
+func (p *Proxy) ProxyMetrics(ctx context.Context, jsonMetrics []samplers.JSONMetric, origin, reporter, intervalEnd, batch string) {
 	span, _ := trace.StartSpanFromContext(ctx, "veneur.opentracing.proxy.proxy_metrics")
 	defer span.ClientFinish(p.TraceClient)
 
@@ -608,8 +669,8 @@ func (p *Proxy) ProxyMetrics(ctx context.Context, jsonMetrics []samplers.JSONMet
 	wg := sync.WaitGroup{}
 	wg.Add(len(jsonMetricsByDestination)) // Make our waitgroup the size of our destinations
 
-	for dest, batch := range jsonMetricsByDestination {
-		go p.doPost(ctx, &wg, dest, batch)
+	for dest, destMetrics := range jsonMetricsByDestination {
+		go p.doPost(ctx, &wg, dest, destMetrics, reporter, intervalEnd, batch)
 	}
 	wg.Wait() // Wait for all the above goroutines to complete
 	log.WithField("count", metricCount).Debug("Completed forward")
@@ -620,7 +681,7 @@ func (p *Proxy) ProxyMetrics(ctx context.Context, jsonMetrics []samplers.JSONMet
 	)...)
 }
 
-func (p *Proxy) doPost(ctx context.Context, wg *sync.WaitGroup, destination string, batch []samplers.JSONMetric) {
+func (p *Proxy) doPost(ctx context.Context, wg *sync.WaitGroup, destination string, batch []samplers.JSONMetric, reporter, intervalEnd, batchID string) {
 	defer wg.Done()
 
 	samples := &ssf.Samples{}
@@ -630,6 +691,7 @@ func (p *Proxy) doPost(ctx context.Context, wg *sync.WaitGroup, destination stri
 	if batchSize < 1 {
 		return
 	}
+	ringDestination := destination
 
 	// Make sure the destination always has a valid 'http' prefix.
 	if !strings.HasPrefix(destination, "http") {
@@ -638,11 +700,30 @@ func (p *Proxy) doPost(ctx context.Context, wg *sync.WaitGroup, destination stri
 	}
 
 	endpoint := fmt.Sprintf("%s/import", destination)
+	// pass on the ID of the veneur that sent the metrics, the end of
+	// the interval they're from, and their batch within it
+	query := url.Values{}
+	if reporter != "" {
+		query.Set(importReporterParam, reporter)
+	}
+	if intervalEnd != "" {
+		query.Set(importIntervalEndParam, intervalEnd)
+	}
+	if batchID != "" {
+		query.Set(importBatchParam, batchID)
+	}
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
 	err := vhttp.PostHelper(ctx, p.HTTPClient, p.TraceClient, http.MethodPost, endpoint, batch, "forward", true, nil, log)
+	if p.degraded != nil && p.degraded.forwarded(ringDestination, p.ForwardDestinations.Members(), err, time.Now()) {
+		p.degraded.importJSON(batch)
+	}
 	if err == nil {
 		log.WithField("metrics", batchSize).Debug("Completed forward to Veneur")
 	} else {
-		samples.Add(ssf.Count("forward.error_total", 1, map[string]string{"cause": "post"}))
+		samples.Add(ssf.Count("forward.error_total", 1, map[string]string{"reason": "post"},
+			ssf.Failure("proxy", ssf.CauseIOError)))
 		log.WithError(err).WithFields(logrus.Fields{
 			"endpoint":  endpoint,
 			"batchSize": batchSize,
@@ -653,6 +734,14 @@ func (p *Proxy) doPost(ctx context.Context, wg *sync.WaitGroup, destination stri
 	)...)
 }
 
+// forwardedGRPC aggregates a batch that couldn't be forwarded over
+// gRPC, if every gRPC destination is unreachable.
+func (p *Proxy) forwardedGRPC(dest string, batch []*metricpb.Metric, err error) {
+	if p.degraded.forwarded(dest, p.ForwardGRPCDestinations.Members(), err, time.Now()) {
+		p.degraded.importGRPC(batch)
+	}
+}
+
 func (p *Proxy) ReportRuntimeMetrics() {
 	mem := &runtime.MemStats{}
 	runtime.ReadMemStats(mem)
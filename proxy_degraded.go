@@ -0,0 +1,220 @@
+package veneur
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/samplers/metricpb"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// degradedTag marks the metrics that veneur-proxy aggregated itself
+// while none of the global veneurs were reachable.
+const degradedTag = "veneur_degraded"
+
+// degradedReportBatch is how many aggregated metrics are sent to the
+// SSF destination in each span, to keep the spans within the size of
+// a UDP packet.
+const degradedReportBatch = 100
+
+// degradedIdleExpiry is how long a destination that no batch was
+// forwarded to still counts as reachable. The ring may not route any
+// metrics to a member for a while, and without an expiry, an idle
+// member would keep the proxy from ever aggregating.
+const degradedIdleExpiry = time.Minute
+
+// degradedAggregator aggregates the metrics that veneur-proxy couldn't
+// forward while every global veneur is unreachable, so that an outage
+// of the global tier degrades the mixed-scope metrics (their
+// percentiles only cover what each proxy saw) instead of losing them.
+// A destination counts as unreachable if the last batch forwarded to
+// it failed, or if no batch was forwarded to it within
+// degradedIdleExpiry; once every destination is, the batches that
+// fail are aggregated, until a batch is forwarded again.
+type degradedAggregator struct {
+	worker      *Worker
+	interval    time.Duration
+	percentiles []float64
+	aggregates  samplers.HistogramAggregates
+	traceClient *trace.Client
+
+	mtx  sync.Mutex
+	down map[string]bool
+	// lastForwarded is when a batch was last forwarded to each
+	// destination; destinations that no batch was forwarded to yet
+	// count from when the aggregator started.
+	lastForwarded map[string]time.Time
+	started       time.Time
+	degraded      bool
+	// aggregated counts the metrics aggregated since the last flush.
+	aggregated int
+}
+
+func newDegradedAggregator(interval time.Duration, percentiles []float64, aggregates []string, cl *trace.Client) *degradedAggregator {
+	d := &degradedAggregator{
+		worker:        NewWorker(0, cl, log, nil),
+		interval:      interval,
+		percentiles:   percentiles,
+		traceClient:   cl,
+		down:          map[string]bool{},
+		lastForwarded: map[string]time.Time{},
+		started:       time.Now(),
+	}
+	for _, agg := range aggregates {
+		d.aggregates.Value += samplers.AggregatesLookup[agg]
+	}
+	d.aggregates.Count = len(aggregates)
+	return d
+}
+
+// forwarded records the outcome of forwarding a batch to one of
+// destinations at now, and returns whether the batch has to be
+// aggregated here, because every destination is unreachable.
+func (d *degradedAggregator) forwarded(dest string, destinations []string, err error, now time.Time) bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if err == nil {
+		delete(d.down, dest)
+		d.lastForwarded[dest] = now
+		if d.degraded {
+			d.degraded = false
+			log.WithField("destination", dest).Info("A global destination is reachable again, forwarding metrics")
+		}
+		return false
+	}
+	d.down[dest] = true
+	d.lastForwarded[dest] = now
+	for _, other := range destinations {
+		if d.reachable(other, now) {
+			return false
+		}
+	}
+	// forget the destinations that left the ring:
+	if len(d.lastForwarded) > len(destinations) {
+		members := make(map[string]bool, len(destinations))
+		for _, member := range destinations {
+			members[member] = true
+		}
+		for other := range d.lastForwarded {
+			if !members[other] {
+				delete(d.down, other)
+				delete(d.lastForwarded, other)
+			}
+		}
+	}
+	if !d.degraded {
+		d.degraded = true
+		log.WithField("destinations", len(destinations)).
+			Warn("All global destinations are unreachable, aggregating metrics locally")
+	}
+	return true
+}
+
+// reachable returns whether the last batch forwarded to dest
+// succeeded, within degradedIdleExpiry of now. It must be called with
+// the lock held.
+func (d *degradedAggregator) reachable(dest string, now time.Time) bool {
+	if d.down[dest] {
+		return false
+	}
+	last, ok := d.lastForwarded[dest]
+	if !ok {
+		last = d.started
+	}
+	return now.Sub(last) < degradedIdleExpiry
+}
+
+// importJSON aggregates a batch that couldn't be forwarded to /import.
+func (d *degradedAggregator) importJSON(batch []samplers.JSONMetric) {
+	for _, m := range batch {
+		d.worker.ImportMetric(m)
+	}
+	d.mtx.Lock()
+	d.aggregated += len(batch)
+	d.mtx.Unlock()
+}
+
+// importGRPC aggregates a batch that couldn't be forwarded over gRPC.
+func (d *degradedAggregator) importGRPC(batch []*metricpb.Metric) {
+	imported := 0
+	for _, m := range batch {
+		if err := d.worker.ImportMetricGRPC(m); err == nil {
+			imported++
+		}
+	}
+	d.mtx.Lock()
+	d.aggregated += imported
+	d.mtx.Unlock()
+}
+
+// flush computes the aggregated metrics the way a global veneur would,
+// and sends them to the SSF destination, tagged with degradedTag and as
+// local-only, so that the veneur that receives them doesn't forward
+// them again.
+func (d *degradedAggregator) flush() []samplers.InterMetric {
+	d.mtx.Lock()
+	degraded, aggregated := d.degraded, d.aggregated
+	d.aggregated = 0
+	d.mtx.Unlock()
+
+	wm := d.worker.Flush()
+	var final []samplers.InterMetric
+	for _, c := range wm.globalCounters {
+		final = append(final, c.Flush(d.interval)...)
+	}
+	for _, g := range wm.globalGauges {
+		final = append(final, g.Flush()...)
+	}
+	for _, s := range wm.sets {
+		final = append(final, s.Flush()...)
+	}
+	for _, hs := range []map[samplers.MetricKey]*samplers.Histo{wm.histograms, wm.timers, wm.globalHistograms, wm.globalTimers} {
+		for _, h := range hs {
+			final = append(final, h.Flush(d.interval, d.percentiles, d.aggregates, true)...)
+		}
+	}
+
+	degradedGauge := float32(0)
+	if degraded {
+		degradedGauge = 1
+	}
+	metrics.ReportBatch(d.traceClient, []*ssf.SSFSample{
+		ssf.Gauge("proxy.degraded", degradedGauge, nil),
+		ssf.Count("proxy.degraded_metrics_total", float32(aggregated), nil),
+	})
+	if len(final) == 0 {
+		return nil
+	}
+
+	samples := make([]*ssf.SSFSample, 0, len(final))
+	for _, m := range final {
+		tags := samplers.ParseTagSliceToMap(m.Tags)
+		tags[degradedTag] = "true"
+		tags["veneurlocalonly"] = ""
+		switch m.Type {
+		case samplers.CounterMetric:
+			samples = append(samples, ssf.Count(m.Name, float32(m.Value), tags))
+		default:
+			samples = append(samples, ssf.Gauge(m.Name, float32(m.Value), tags))
+		}
+	}
+	for len(samples) > 0 {
+		n := degradedReportBatch
+		if n > len(samples) {
+			n = len(samples)
+		}
+		if err := metrics.ReportBatch(d.traceClient, samples[:n]); err != nil {
+			log.WithError(err).WithFields(logrus.Fields{
+				"metrics": len(samples),
+			}).Error("Failed to report the metrics aggregated while degraded")
+			break
+		}
+		samples = samples[n:]
+	}
+	log.WithField("metrics", len(final)).Info("Flushed the metrics aggregated while degraded")
+	return final
+}
@@ -0,0 +1,103 @@
+package veneur
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
+)
+
+func TestDegradedAggregatorForwarded(t *testing.T) {
+	d := newDegradedAggregator(10*time.Second, nil, nil, trace.DefaultClient)
+	dests := []string{"a", "b"}
+	failed := assert.AnError
+	now := time.Now()
+
+	assert.False(t, d.forwarded("a", dests, failed, now), "b is still reachable")
+	assert.True(t, d.forwarded("b", dests, failed, now), "every destination is unreachable")
+	assert.True(t, d.forwarded("a", dests, failed, now))
+	assert.False(t, d.forwarded("b", dests, nil, now), "b is reachable again")
+	assert.False(t, d.forwarded("a", dests, failed, now))
+
+	assert.True(t, d.forwarded("a", []string{"a"}, failed, now), "a single destination")
+}
+
+func TestDegradedAggregatorIdleDestination(t *testing.T) {
+	d := newDegradedAggregator(10*time.Second, nil, nil, trace.DefaultClient)
+	dests := []string{"a", "b"}
+	failed := assert.AnError
+	start := time.Now()
+
+	assert.False(t, d.forwarded("a", dests, failed, start.Add(time.Second)),
+		"b didn't get a batch yet, but only just started")
+	assert.True(t, d.forwarded("a", dests, failed, start.Add(degradedIdleExpiry+time.Second)),
+		"b didn't get a batch for too long")
+	assert.False(t, d.forwarded("b", dests, nil, start.Add(degradedIdleExpiry+2*time.Second)))
+	assert.False(t, d.forwarded("a", dests, failed, start.Add(degradedIdleExpiry+3*time.Second)),
+		"b got a batch recently")
+	assert.True(t, d.forwarded("a", dests, failed, start.Add(2*degradedIdleExpiry+3*time.Second)),
+		"b is idle again")
+
+	assert.True(t, d.forwarded("a", []string{"a"}, failed, start.Add(2*degradedIdleExpiry+3*time.Second)))
+	assert.NotContains(t, d.lastForwarded, "b", "b left the ring")
+}
+
+func TestDegradedAggregatorFlush(t *testing.T) {
+	spans := make(chan *ssf.SSFSpan, 10)
+	cl, err := trace.NewChannelClient(spans)
+	require.NoError(t, err)
+	defer cl.Close()
+	d := newDegradedAggregator(10*time.Second, []float64{0.5}, []string{"max"}, cl)
+
+	var batch []samplers.JSONMetric
+	for _, host := range []string{"a", "b"} {
+		h := samplers.NewHist("api.latency", []string{"route:/"})
+		for i := 0; i < 100; i++ {
+			h.Sample(float64(i), 1)
+		}
+		jh, err := h.Export()
+		require.NoError(t, err)
+		batch = append(batch, jh)
+
+		c := samplers.Counter{Name: "api.requests", Tags: []string{"host:" + host}}
+		c.Sample(5, 1)
+		jc, err := c.Export()
+		require.NoError(t, err)
+		batch = append(batch, jc)
+	}
+	require.True(t, d.forwarded("a", []string{"a"}, assert.AnError, time.Now()))
+	d.importJSON(batch)
+
+	final := d.flush()
+	byName := map[string]samplers.InterMetric{}
+	for _, m := range final {
+		byName[m.Name+"|"+m.Tags[0]] = m
+	}
+	assert.Len(t, final, 4)
+	assert.Equal(t, 99.0, byName["api.latency.max|route:/"].Value,
+		"the histograms should be merged")
+	assert.InDelta(t, 49.5, byName["api.latency.50percentile|route:/"].Value, 1)
+	assert.Equal(t, 5.0, byName["api.requests|host:a"].Value)
+
+	var samples []*ssf.SSFSample
+	for len(spans) > 0 {
+		samples = append(samples, (<-spans).Metrics...)
+	}
+	reported := map[string]*ssf.SSFSample{}
+	for _, s := range samples {
+		reported[s.Name] = s
+	}
+	require.Contains(t, reported, "proxy.degraded")
+	assert.Equal(t, float32(1), reported["proxy.degraded"].Value)
+	assert.Equal(t, float32(4), reported["proxy.degraded_metrics_total"].Value)
+	require.Contains(t, reported, "api.latency.max")
+	assert.Equal(t, map[string]string{"route": "/", degradedTag: "true", "veneurlocalonly": ""},
+		reported["api.latency.max"].Tags)
+	assert.Equal(t, ssf.SSFSample_COUNTER, reported["api.requests"].Metric)
+
+	assert.Empty(t, d.flush(), "a flush should reset the aggregated metrics")
+}
@@ -222,7 +222,7 @@ func TestTimeout(t *testing.T) {
 	// timeout:
 	ch := make(chan struct{})
 	go func() {
-		server.ProxyMetrics(context.Background(), metrics, "foo.com")
+		server.ProxyMetrics(context.Background(), metrics, "foo.com", "", "", "")
 		close(ch)
 	}()
 	select {
@@ -4,6 +4,8 @@ import (
 	"sync"
 
 	"google.golang.org/grpc"
+
+	"github.com/stripe/veneur/forwardrpc"
 )
 
 // clientConnMap is just a thread-safe wrapper around a map of strings to
@@ -12,13 +14,20 @@ import (
 type clientConnMap struct {
 	sync.RWMutex
 	conns   map[string]*grpc.ClientConn
+	clients map[string]*forwardrpc.Client
 	options []grpc.DialOption
+	// clientOptions are used to create a forwardrpc.Client for each
+	// connection, which keeps track of the message size its
+	// destination accepts.
+	clientOptions []forwardrpc.ClientOption
 }
 
-func newClientConnMap(opts ...grpc.DialOption) *clientConnMap {
+func newClientConnMap(clientOpts []forwardrpc.ClientOption, opts ...grpc.DialOption) *clientConnMap {
 	return &clientConnMap{
-		conns:   make(map[string]*grpc.ClientConn),
-		options: opts,
+		conns:         make(map[string]*grpc.ClientConn),
+		clients:       make(map[string]*forwardrpc.Client),
+		options:       opts,
+		clientOptions: clientOpts,
 	}
 }
 
@@ -32,6 +41,16 @@ func (m *clientConnMap) Get(dest string) (conn *grpc.ClientConn, ok bool) {
 	return
 }
 
+// GetClient returns a client of the Forward service for the input
+// destination.  The ok value indicates if the key was found in the map.
+func (m *clientConnMap) GetClient(dest string) (client *forwardrpc.Client, ok bool) {
+	m.RLock()
+	client, ok = m.clients[dest]
+	m.RUnlock()
+
+	return
+}
+
 // Add the destination to the map, and open a new connection to it. If the
 // destination already exists, this is a no-op.
 func (m *clientConnMap) Add(dest string) error {
@@ -46,6 +65,7 @@ func (m *clientConnMap) Add(dest string) error {
 	_, ok := m.conns[dest]
 	if !ok && err == nil {
 		m.conns[dest] = conn
+		m.clients[dest] = forwardrpc.NewClient(conn, m.clientOptions...)
 	}
 	m.Unlock()
 
@@ -65,6 +85,7 @@ func (m *clientConnMap) Delete(dest string) {
 		_ = conn.Close()
 	}
 	delete(m.conns, dest)
+	delete(m.clients, dest)
 
 	m.Unlock()
 }
@@ -91,5 +112,6 @@ func (m *clientConnMap) Clear() {
 	}
 
 	m.conns = make(map[string]*grpc.ClientConn)
+	m.clients = make(map[string]*forwardrpc.Client)
 	m.Unlock()
 }
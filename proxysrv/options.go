@@ -4,6 +4,8 @@ import (
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/forwardrpc"
+	"github.com/stripe/veneur/samplers/metricpb"
 	"github.com/stripe/veneur/trace"
 )
 
@@ -36,3 +38,31 @@ func WithTraceClient(c *trace.Client) Option {
 		opts.traceClient = c
 	}
 }
+
+// WithMaxRecvMsgSize sets the largest message that the server receives,
+// which it advertises to its clients. Otherwise it uses
+// forwardrpc.DefaultMaxMsgSize.
+func WithMaxRecvMsgSize(n int) Option {
+	return func(opts *options) {
+		if n > 0 {
+			opts.maxRecvMsgSize = n
+		}
+	}
+}
+
+// WithForwardClientOptions sets the options of the clients that forward
+// metrics to the downstream Veneurs, like their compression.
+func WithForwardClientOptions(clientOpts ...forwardrpc.ClientOption) Option {
+	return func(opts *options) {
+		opts.clientOptions = clientOpts
+	}
+}
+
+// WithForwarded sets a function that is called with the outcome of
+// every batch of metrics forwarded to a downstream Veneur, once it's
+// done. err is nil if the batch was forwarded.
+func WithForwarded(f func(dest string, batch []*metricpb.Metric, err error)) Option {
+	return func(opts *options) {
+		opts.forwarded = f
+	}
+}
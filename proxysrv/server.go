@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -19,6 +20,9 @@ import (
 	"github.com/sirupsen/logrus"
 	"golang.org/x/net/context" // This can be replace with "context" after Go 1.8 support is dropped
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"stathat.com/c/consistent"
 
 	"github.com/stripe/veneur/forwardrpc"
@@ -60,18 +64,20 @@ type options struct {
 	forwardTimeout time.Duration
 	traceClient    *trace.Client
 	statsInterval  time.Duration
+	maxRecvMsgSize int
+	clientOptions  []forwardrpc.ClientOption
+	forwarded      func(dest string, batch []*metricpb.Metric, err error)
 }
 
 // New creates a new Server with the provided destinations. The server returned
 // is unstarted.
 func New(destinations *consistent.Consistent, opts ...Option) (*Server, error) {
 	res := &Server{
-		Server: grpc.NewServer(),
 		opts: &options{
 			forwardTimeout: defaultForwardTimeout,
 			statsInterval:  defaultReportStatsInterval,
+			maxRecvMsgSize: forwardrpc.DefaultMaxMsgSize,
 		},
-		conns:               newClientConnMap(grpc.WithInsecure()),
 		activeProxyHandlers: new(int64),
 	}
 
@@ -79,6 +85,9 @@ func New(destinations *consistent.Consistent, opts ...Option) (*Server, error) {
 		opt(res.opts)
 	}
 
+	res.Server = grpc.NewServer(grpc.MaxRecvMsgSize(res.opts.maxRecvMsgSize))
+	res.conns = newClientConnMap(res.opts.clientOptions, grpc.WithInsecure())
+
 	if res.opts.log == nil {
 		log := logrus.New()
 		log.Out = ioutil.Discard
@@ -177,10 +186,25 @@ func (s *Server) SetDestinations(dests *consistent.Consistent) error {
 // SendMetrics spawns a new goroutine that forwards metrics to the destinations
 // and exist immediately.
 func (s *Server) SendMetrics(ctx context.Context, mlist *forwardrpc.MetricList) (*empty.Empty, error) {
+	// Tell the client how large the messages it sends can be. This
+	// fails outside of a gRPC call, where there's no client to tell.
+	_ = grpc.SetHeader(ctx, metadata.Pairs(forwardrpc.MaxRecvMsgSizeHeader,
+		strconv.Itoa(s.opts.maxRecvMsgSize)))
+
+	// Pass on the ID of the Veneur that sent the metrics, and the end of
+	// the interval they're from.
+	fwdCtx := context.Background()
+	if reporter := forwardrpc.ReporterFromContext(ctx); reporter != "" {
+		fwdCtx = forwardrpc.ContextWithReporter(fwdCtx, reporter)
+	}
+	if end := forwardrpc.IntervalEndFromContext(ctx); !end.IsZero() {
+		fwdCtx = forwardrpc.ContextWithIntervalEnd(fwdCtx, end)
+	}
+
 	go func() {
 		// Track the number of active goroutines in a counter
 		atomic.AddInt64(s.activeProxyHandlers, 1)
-		_ = s.sendMetrics(context.Background(), mlist)
+		_ = s.sendMetrics(fwdCtx, mlist)
 		atomic.AddInt64(s.activeProxyHandlers, -1)
 	}()
 	return &empty.Empty{}, nil
@@ -223,7 +247,11 @@ func (s *Server) sendMetrics(ctx context.Context, mlist *forwardrpc.MetricList)
 	for dest, batch := range dests {
 		go func(dest string, batch []*metricpb.Metric) {
 			defer wg.Done()
-			if err := s.forward(ctx, dest, batch); err != nil {
+			err := s.forward(ctx, dest, batch)
+			if s.opts.forwarded != nil {
+				s.opts.forwarded(dest, batch, err)
+			}
+			if err != nil {
 				msg := fmt.Sprintf("failed to forward to the host '%s'", dest)
 				errCh <- forwardError{err: err, cause: "forward", msg: msg,
 					numMetrics: len(batch)}
@@ -283,13 +311,12 @@ func (s *Server) destForMetric(m *metricpb.Metric) (string, error) {
 // forward sends a set of metrics to the destination address, and returns
 // an error if necessary.
 func (s *Server) forward(ctx context.Context, dest string, ms []*metricpb.Metric) (err error) {
-	conn, ok := s.conns.Get(dest)
+	c, ok := s.conns.GetClient(dest)
 	if !ok {
 		return fmt.Errorf("no connection was found for the host '%s'", dest)
 	}
 
-	c := forwardrpc.NewForwardClient(conn)
-	_, err = c.SendMetrics(ctx, &forwardrpc.MetricList{Metrics: ms})
+	_, err = c.Send(ctx, ms)
 	if err != nil {
 		return fmt.Errorf("failed to send %d metrics over gRPC: %v",
 			len(ms), err)
@@ -337,15 +364,25 @@ func (e forwardError) Error() string {
 // reportMetrics adds various metrics to an input span.
 func (e forwardError) reportMetrics(span *trace.Span) {
 	tags := map[string]string{
-		"cause":    e.cause,
+		"reason":   e.cause,
 		"protocol": "grpc",
 	}
+	failure := ssf.Failure("proxy", e.failureCause())
 	span.Add(
-		ssf.Count("proxy.proxied_metrics_failed", float32(e.numMetrics), tags),
-		ssf.Count("proxy.forward_errors", 1, tags),
+		ssf.Count("proxy.proxied_metrics_failed", float32(e.numMetrics), tags, failure),
+		ssf.Count("proxy.forward_errors", 1, tags, failure),
 	)
 }
 
+// failureCause returns the failure cause (see ssf.Failure) that the
+// error's metrics are tagged with.
+func (e forwardError) failureCause() string {
+	if status.Code(e.err) == codes.DeadlineExceeded {
+		return ssf.CauseSinkTimeout
+	}
+	return ssf.CauseIOError
+}
+
 // forwardErrors wraps a slice of errors and implements the "error" type.
 type forwardErrors []forwardError
 
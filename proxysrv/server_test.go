@@ -82,6 +82,37 @@ func TestUnreachableDestinations(t *testing.T) {
 		"of the destinations are unreachable")
 }
 
+func TestForwarded(t *testing.T) {
+	dests := createTestForwardServers(t, 1, func([]*metricpb.Metric) {})
+	defer stopTestForwardServers(dests)
+
+	ring := consistent.New()
+	ring.Add(dests[0].Addr().String())
+	ring.Add("not-a-real-host:9001")
+
+	var mtx sync.Mutex
+	forwarded := map[string]int{}
+	failed := map[string]int{}
+	server := newServer(t, ring, WithForwarded(func(dest string, batch []*metricpb.Metric, err error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if err != nil {
+			failed[dest] += len(batch)
+		} else {
+			forwarded[dest] += len(batch)
+		}
+	}))
+	server.sendMetrics(context.Background(),
+		&forwardrpc.MetricList{metrictest.RandomForwardMetrics(100)})
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	assert.Equal(t, 100, forwarded[dests[0].Addr().String()]+failed["not-a-real-host:9001"],
+		"every batch's outcome should be reported")
+	assert.Empty(t, failed[dests[0].Addr().String()])
+	assert.Empty(t, forwarded["not-a-real-host:9001"])
+}
+
 func TestTimeout(t *testing.T) {
 	dests := createTestForwardServers(t, 3, nil)
 	defer stopTestForwardServers(dests)
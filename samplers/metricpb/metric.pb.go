@@ -2,17 +2,20 @@
 // source: samplers/metricpb/metric.proto
 
 /*
-	Package metricpb is a generated protocol buffer package.
+Package metricpb is a generated protocol buffer package.
 
-	It is generated from these files:
-		samplers/metricpb/metric.proto
+It is generated from these files:
 
-	It has these top-level messages:
-		Metric
-		CounterValue
-		GaugeValue
-		HistogramValue
-		SetValue
+	samplers/metricpb/metric.proto
+
+It has these top-level messages:
+
+	Metric
+	CounterValue
+	GaugeValue
+	HistogramValue
+	SetValue
+	Exemplar
 */
 package metricpb
 
@@ -106,6 +109,11 @@ type Metric struct {
 	//	*Metric_Set
 	Value isMetric_Value `protobuf_oneof:"value"`
 	Scope Scope          `protobuf:"varint,9,opt,name=scope,proto3,enum=metricpb.Scope" json:"scope,omitempty"`
+	// unit is the unit the metric's values were measured in, if known.
+	Unit string `protobuf:"bytes,10,opt,name=unit,proto3" json:"unit,omitempty"`
+	// priority is the metric's priority class: 0 for normal, 1 for
+	// low and 2 for high, like the priority of an SSF sample.
+	Priority int32 `protobuf:"varint,11,opt,name=priority,proto3" json:"priority,omitempty"`
 }
 
 func (m *Metric) Reset()                    { *m = Metric{} }
@@ -200,6 +208,20 @@ func (m *Metric) GetScope() Scope {
 	return Scope_Mixed
 }
 
+func (m *Metric) GetUnit() string {
+	if m != nil {
+		return m.Unit
+	}
+	return ""
+}
+
+func (m *Metric) GetPriority() int32 {
+	if m != nil {
+		return m.Priority
+	}
+	return 0
+}
+
 // XXX_OneofFuncs is for the internal use of the proto package.
 func (*Metric) XXX_OneofFuncs() (func(msg proto.Message, b *proto.Buffer) error, func(msg proto.Message, tag, wire int, b *proto.Buffer) (bool, error), func(msg proto.Message) (n int), []interface{}) {
 	return _Metric_OneofMarshaler, _Metric_OneofUnmarshaler, _Metric_OneofSizer, []interface{}{
@@ -350,6 +372,9 @@ func (m *GaugeValue) GetValue() float64 {
 // to include the other values such as the sum, average, etc.
 type HistogramValue struct {
 	TDigest *tdigest.MergingDigestData `protobuf:"bytes,1,opt,name=t_digest,json=tDigest" json:"t_digest,omitempty"`
+	// exemplar is the trace span with the largest value that was
+	// sampled into the histogram, if any.
+	Exemplar *Exemplar `protobuf:"bytes,2,opt,name=exemplar" json:"exemplar,omitempty"`
 }
 
 func (m *HistogramValue) Reset()                    { *m = HistogramValue{} }
@@ -364,6 +389,13 @@ func (m *HistogramValue) GetTDigest() *tdigest.MergingDigestData {
 	return nil
 }
 
+func (m *HistogramValue) GetExemplar() *Exemplar {
+	if m != nil {
+		return m.Exemplar
+	}
+	return nil
+}
+
 // SetValue contains a binary-encoded HyperLogLog
 type SetValue struct {
 	HyperLogLog []byte `protobuf:"bytes,1,opt,name=hyper_log_log,json=hyperLogLog,proto3" json:"hyper_log_log,omitempty"`
@@ -381,12 +413,47 @@ func (m *SetValue) GetHyperLogLog() []byte {
 	return nil
 }
 
+// Exemplar identifies a trace span whose value was sampled into a
+// histogram or timer.
+type Exemplar struct {
+	TraceId int64   `protobuf:"varint,1,opt,name=trace_id,json=traceId,proto3" json:"trace_id,omitempty"`
+	SpanId  int64   `protobuf:"varint,2,opt,name=span_id,json=spanId,proto3" json:"span_id,omitempty"`
+	Value   float64 `protobuf:"fixed64,3,opt,name=value,proto3" json:"value,omitempty"`
+}
+
+func (m *Exemplar) Reset()                    { *m = Exemplar{} }
+func (m *Exemplar) String() string            { return proto.CompactTextString(m) }
+func (*Exemplar) ProtoMessage()               {}
+func (*Exemplar) Descriptor() ([]byte, []int) { return fileDescriptorMetric, []int{5} }
+
+func (m *Exemplar) GetTraceId() int64 {
+	if m != nil {
+		return m.TraceId
+	}
+	return 0
+}
+
+func (m *Exemplar) GetSpanId() int64 {
+	if m != nil {
+		return m.SpanId
+	}
+	return 0
+}
+
+func (m *Exemplar) GetValue() float64 {
+	if m != nil {
+		return m.Value
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*Metric)(nil), "metricpb.Metric")
 	proto.RegisterType((*CounterValue)(nil), "metricpb.CounterValue")
 	proto.RegisterType((*GaugeValue)(nil), "metricpb.GaugeValue")
 	proto.RegisterType((*HistogramValue)(nil), "metricpb.HistogramValue")
 	proto.RegisterType((*SetValue)(nil), "metricpb.SetValue")
+	proto.RegisterType((*Exemplar)(nil), "metricpb.Exemplar")
 	proto.RegisterEnum("metricpb.Scope", Scope_name, Scope_value)
 	proto.RegisterEnum("metricpb.Type", Type_name, Type_value)
 }
@@ -443,6 +510,17 @@ func (m *Metric) MarshalTo(dAtA []byte) (int, error) {
 		i++
 		i = encodeVarintMetric(dAtA, i, uint64(m.Scope))
 	}
+	if len(m.Unit) > 0 {
+		dAtA[i] = 0x52
+		i++
+		i = encodeVarintMetric(dAtA, i, uint64(len(m.Unit)))
+		i += copy(dAtA[i:], m.Unit)
+	}
+	if m.Priority != 0 {
+		dAtA[i] = 0x58
+		i++
+		i = encodeVarintMetric(dAtA, i, uint64(m.Priority))
+	}
 	return i, nil
 }
 
@@ -574,6 +652,16 @@ func (m *HistogramValue) MarshalTo(dAtA []byte) (int, error) {
 		}
 		i += n6
 	}
+	if m.Exemplar != nil {
+		dAtA[i] = 0x12
+		i++
+		i = encodeVarintMetric(dAtA, i, uint64(m.Exemplar.Size()))
+		n7, err := m.Exemplar.MarshalTo(dAtA[i:])
+		if err != nil {
+			return 0, err
+		}
+		i += n7
+	}
 	return i, nil
 }
 
@@ -601,6 +689,40 @@ func (m *SetValue) MarshalTo(dAtA []byte) (int, error) {
 	return i, nil
 }
 
+func (m *Exemplar) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Exemplar) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.TraceId != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintMetric(dAtA, i, uint64(m.TraceId))
+	}
+	if m.SpanId != 0 {
+		dAtA[i] = 0x10
+		i++
+		i = encodeVarintMetric(dAtA, i, uint64(m.SpanId))
+	}
+	if m.Value != 0 {
+		dAtA[i] = 0x19
+		i++
+		encoding_binary.LittleEndian.PutUint64(dAtA[i:], uint64(math.Float64bits(float64(m.Value))))
+		i += 8
+	}
+	return i, nil
+}
+
 func encodeVarintMetric(dAtA []byte, offset int, v uint64) int {
 	for v >= 1<<7 {
 		dAtA[offset] = uint8(v&0x7f | 0x80)
@@ -632,6 +754,13 @@ func (m *Metric) Size() (n int) {
 	if m.Scope != 0 {
 		n += 1 + sovMetric(uint64(m.Scope))
 	}
+	l = len(m.Unit)
+	if l > 0 {
+		n += 1 + l + sovMetric(uint64(l))
+	}
+	if m.Priority != 0 {
+		n += 1 + sovMetric(uint64(m.Priority))
+	}
 	return n
 }
 
@@ -696,6 +825,10 @@ func (m *HistogramValue) Size() (n int) {
 		l = m.TDigest.Size()
 		n += 1 + l + sovMetric(uint64(l))
 	}
+	if m.Exemplar != nil {
+		l = m.Exemplar.Size()
+		n += 1 + l + sovMetric(uint64(l))
+	}
 	return n
 }
 
@@ -709,6 +842,21 @@ func (m *SetValue) Size() (n int) {
 	return n
 }
 
+func (m *Exemplar) Size() (n int) {
+	var l int
+	_ = l
+	if m.TraceId != 0 {
+		n += 1 + sovMetric(uint64(m.TraceId))
+	}
+	if m.SpanId != 0 {
+		n += 1 + sovMetric(uint64(m.SpanId))
+	}
+	if m.Value != 0 {
+		n += 9
+	}
+	return n
+}
+
 func sovMetric(x uint64) (n int) {
 	for {
 		n++
@@ -975,6 +1123,54 @@ func (m *Metric) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
+		case 10:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Unit", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMetric
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= (uint64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthMetric
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Unit = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 11:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Priority", wireType)
+			}
+			m.Priority = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMetric
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Priority |= (int32(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipMetric(dAtA[iNdEx:])
@@ -1188,6 +1384,39 @@ func (m *HistogramValue) Unmarshal(dAtA []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Exemplar", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMetric
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthMetric
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Exemplar == nil {
+				m.Exemplar = &Exemplar{}
+			}
+			if err := m.Exemplar.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipMetric(dAtA[iNdEx:])
@@ -1290,6 +1519,105 @@ func (m *SetValue) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
+func (m *Exemplar) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowMetric
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: Exemplar: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: Exemplar: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TraceId", wireType)
+			}
+			m.TraceId = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMetric
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.TraceId |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SpanId", wireType)
+			}
+			m.SpanId = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMetric
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.SpanId |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 1 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Value", wireType)
+			}
+			var v uint64
+			if (iNdEx + 8) > l {
+				return io.ErrUnexpectedEOF
+			}
+			v = uint64(encoding_binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+			iNdEx += 8
+			m.Value = float64(math.Float64frombits(v))
+		default:
+			iNdEx = preIndex
+			skippy, err := skipMetric(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthMetric
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
 func skipMetric(dAtA []byte) (n int, err error) {
 	l := len(dAtA)
 	iNdEx := 0
@@ -1398,33 +1726,38 @@ var (
 func init() { proto.RegisterFile("samplers/metricpb/metric.proto", fileDescriptorMetric) }
 
 var fileDescriptorMetric = []byte{
-	// 438 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x54, 0x92, 0xc1, 0x6a, 0xdb, 0x40,
-	0x10, 0x86, 0xbd, 0x96, 0x65, 0xd9, 0xe3, 0xc4, 0x15, 0x43, 0x5a, 0x96, 0x1c, 0x84, 0x11, 0x6d,
-	0x71, 0x43, 0x51, 0xc0, 0xa5, 0xd0, 0x6b, 0xd3, 0x80, 0x73, 0xb0, 0x2f, 0x4a, 0xe8, 0x35, 0xac,
-	0x9d, 0x61, 0x23, 0x90, 0xbc, 0x42, 0x5a, 0x97, 0xfa, 0x2d, 0xfa, 0x58, 0x39, 0xf6, 0x11, 0x8a,
-	0xfb, 0x22, 0x65, 0x57, 0xda, 0x2a, 0x39, 0x08, 0xcd, 0xfe, 0xff, 0xf7, 0x4b, 0xfc, 0x1a, 0x41,
-	0x54, 0x8b, 0xa2, 0xcc, 0xa9, 0xaa, 0x2f, 0x0b, 0xd2, 0x55, 0xb6, 0x2d, 0x37, 0xed, 0x90, 0x94,
-	0x95, 0xd2, 0x0a, 0x47, 0x4e, 0x3e, 0x7f, 0xad, 0x1f, 0x32, 0x49, 0xb5, 0xbe, 0x6c, 0xef, 0x0d,
-	0x10, 0x3f, 0xf5, 0x61, 0xb8, 0xb6, 0x0c, 0x22, 0x0c, 0x76, 0xa2, 0x20, 0xce, 0x66, 0x6c, 0x3e,
-	0x4e, 0xed, 0x6c, 0x34, 0x2d, 0x64, 0xcd, 0xfb, 0x33, 0xcf, 0x68, 0x66, 0xc6, 0x18, 0x06, 0xfa,
-	0x50, 0x12, 0xf7, 0x66, 0x6c, 0x3e, 0x5d, 0x4c, 0x13, 0xf7, 0x8a, 0xe4, 0xee, 0x50, 0x52, 0x6a,
-	0x3d, 0x5c, 0x40, 0xb0, 0x55, 0xfb, 0x9d, 0xa6, 0x8a, 0xfb, 0x33, 0x36, 0x9f, 0x2c, 0xde, 0x74,
-	0xd8, 0xb7, 0xc6, 0xf8, 0x2e, 0xf2, 0x3d, 0xdd, 0xf4, 0x52, 0x07, 0xe2, 0x47, 0xf0, 0xa5, 0xd8,
-	0x4b, 0xe2, 0x43, 0x9b, 0x38, 0xeb, 0x12, 0x4b, 0x23, 0x3b, 0xbe, 0x81, 0xf0, 0x0b, 0x8c, 0x1f,
-	0xb3, 0x5a, 0x2b, 0x59, 0x89, 0x82, 0x07, 0x36, 0xc1, 0xbb, 0xc4, 0x8d, 0xb3, 0x5c, 0xaa, 0x83,
-	0xf1, 0x3d, 0x78, 0x35, 0x69, 0x3e, 0xb2, 0x19, 0xec, 0x32, 0xb7, 0xa4, 0x1d, 0x6d, 0x00, 0x7c,
-	0x07, 0x7e, 0xbd, 0x55, 0x25, 0xf1, 0xb1, 0x2d, 0xfa, 0xea, 0x19, 0x69, 0xe4, 0xb4, 0x71, 0xaf,
-	0x02, 0xf0, 0x7f, 0x98, 0x58, 0xfc, 0x16, 0x4e, 0x9e, 0x57, 0xc3, 0xb3, 0xd6, 0xb0, 0x1f, 0xd4,
-	0x4b, 0x5b, 0x2a, 0x06, 0xe8, 0xea, 0xbc, 0x64, 0x98, 0x63, 0x96, 0x30, 0x7d, 0x59, 0x00, 0x3f,
-	0xc3, 0x48, 0xdf, 0x37, 0x8b, 0xb3, 0xe8, 0x64, 0x71, 0x9e, 0xb8, 0x45, 0xae, 0xa9, 0x92, 0xd9,
-	0x4e, 0x5e, 0xdb, 0xd3, 0xb5, 0xd0, 0x22, 0x0d, 0x74, 0x73, 0x88, 0x13, 0x18, 0xb9, 0x56, 0x18,
-	0xc3, 0xe9, 0xe3, 0xa1, 0xa4, 0xea, 0x3e, 0x57, 0xd2, 0x5c, 0xf6, 0x39, 0x27, 0xe9, 0xc4, 0x8a,
-	0x2b, 0x25, 0x57, 0x4a, 0x5e, 0x7c, 0x00, 0xdf, 0x76, 0xc3, 0x31, 0xf8, 0xeb, 0xec, 0x27, 0x3d,
-	0x84, 0x3d, 0x33, 0xae, 0xd4, 0x56, 0xe4, 0x21, 0x43, 0x80, 0xe1, 0x32, 0x57, 0x1b, 0x91, 0x87,
-	0xfd, 0x8b, 0xaf, 0x30, 0x30, 0xfb, 0xc6, 0x09, 0x04, 0x6d, 0xeb, 0x86, 0xb5, 0xe5, 0x42, 0x86,
-	0xa7, 0x30, 0xfe, 0xdf, 0x21, 0xec, 0x63, 0x00, 0xde, 0x2d, 0xe9, 0xd0, 0x33, 0xc8, 0x5d, 0x56,
-	0x50, 0x15, 0x0e, 0xae, 0xc2, 0xa7, 0x63, 0xc4, 0x7e, 0x1f, 0x23, 0xf6, 0xe7, 0x18, 0xb1, 0x5f,
-	0x7f, 0xa3, 0xde, 0x66, 0x68, 0x7f, 0xca, 0x4f, 0xff, 0x02, 0x00, 0x00, 0xff, 0xff, 0x3d, 0x41,
-	0xaf, 0x18, 0xd7, 0x02, 0x00, 0x00,
+	// 522 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x5c, 0x93, 0xc1, 0x6e, 0xd3, 0x40,
+	0x10, 0x86, 0x63, 0x3b, 0x8e, 0x9d, 0x49, 0x1b, 0xac, 0x51, 0x81, 0x25, 0x87, 0x28, 0xb2, 0x00,
+	0x85, 0x0a, 0xb9, 0x52, 0x10, 0x12, 0x57, 0x4a, 0x51, 0x5b, 0x29, 0xb9, 0xb8, 0x11, 0xd7, 0x68,
+	0x93, 0xac, 0x5c, 0x4b, 0x76, 0xd6, 0x5a, 0x6f, 0xa0, 0x79, 0x0b, 0x1e, 0x8b, 0x23, 0x8f, 0x80,
+	0xc2, 0x4b, 0x70, 0x44, 0xbb, 0xf6, 0xc6, 0x69, 0x0f, 0x51, 0x66, 0xe6, 0xff, 0x7e, 0xaf, 0xe6,
+	0x5f, 0x1b, 0x86, 0x25, 0xcd, 0x8b, 0x8c, 0x89, 0xf2, 0x22, 0x67, 0x52, 0xa4, 0xab, 0x62, 0x59,
+	0x17, 0x51, 0x21, 0xb8, 0xe4, 0xe8, 0x9b, 0xf1, 0xe0, 0xb9, 0x5c, 0xa7, 0x09, 0x2b, 0xe5, 0x45,
+	0xfd, 0x5f, 0x01, 0xe1, 0x3f, 0x1b, 0x3a, 0x33, 0xcd, 0x20, 0x42, 0x7b, 0x43, 0x73, 0x46, 0xac,
+	0x91, 0x35, 0xee, 0xc6, 0xba, 0x56, 0x33, 0x49, 0x93, 0x92, 0xd8, 0x23, 0x47, 0xcd, 0x54, 0x8d,
+	0x21, 0xb4, 0xe5, 0xae, 0x60, 0xc4, 0x19, 0x59, 0xe3, 0xfe, 0xa4, 0x1f, 0x99, 0x23, 0xa2, 0xf9,
+	0xae, 0x60, 0xb1, 0xd6, 0x70, 0x02, 0xde, 0x8a, 0x6f, 0x37, 0x92, 0x09, 0xe2, 0x8e, 0xac, 0x71,
+	0x6f, 0xf2, 0xa2, 0xc1, 0xbe, 0x54, 0xc2, 0x37, 0x9a, 0x6d, 0xd9, 0x4d, 0x2b, 0x36, 0x20, 0xbe,
+	0x07, 0x37, 0xa1, 0xdb, 0x84, 0x91, 0x8e, 0x76, 0x9c, 0x35, 0x8e, 0x6b, 0x35, 0x36, 0x7c, 0x05,
+	0xe1, 0x27, 0xe8, 0xde, 0xa7, 0xa5, 0xe4, 0x89, 0xa0, 0x39, 0xf1, 0xb4, 0x83, 0x34, 0x8e, 0x1b,
+	0x23, 0x19, 0x57, 0x03, 0xe3, 0x5b, 0x70, 0x4a, 0x26, 0x89, 0xaf, 0x3d, 0xd8, 0x78, 0xee, 0x98,
+	0x34, 0xb4, 0x02, 0xf0, 0x0d, 0xb8, 0xe5, 0x8a, 0x17, 0x8c, 0x74, 0xf5, 0xa2, 0xcf, 0x8e, 0x48,
+	0x35, 0x8e, 0x2b, 0x55, 0x45, 0xb4, 0xdd, 0xa4, 0x92, 0x40, 0x15, 0x9b, 0xaa, 0x71, 0x00, 0x7e,
+	0x21, 0x52, 0x2e, 0x52, 0xb9, 0x23, 0xbd, 0x91, 0x35, 0x76, 0xe3, 0x43, 0x7f, 0xe9, 0x81, 0xfb,
+	0x5d, 0x1d, 0x13, 0xbe, 0x86, 0x93, 0xe3, 0x28, 0xf0, 0xac, 0x16, 0xf4, 0x05, 0x38, 0x71, 0x4d,
+	0x85, 0x00, 0xcd, 0xfa, 0x8f, 0x19, 0xcb, 0x30, 0x3f, 0xa0, 0xff, 0x78, 0x61, 0xfc, 0x08, 0xbe,
+	0x5c, 0x54, 0x17, 0xad, 0xd1, 0xde, 0x64, 0x10, 0x99, 0x8b, 0x9f, 0x31, 0x91, 0xa4, 0x9b, 0xe4,
+	0x4a, 0x77, 0x57, 0x54, 0xd2, 0xd8, 0x93, 0x55, 0x83, 0x11, 0xf8, 0xec, 0x81, 0xe5, 0x45, 0x46,
+	0x05, 0xb1, 0x9f, 0xe6, 0xf3, 0xb5, 0x56, 0xe2, 0x03, 0x13, 0x46, 0xe0, 0x9b, 0xd4, 0x30, 0x84,
+	0xd3, 0xfb, 0x5d, 0xc1, 0xc4, 0x22, 0xe3, 0x89, 0xfa, 0xe9, 0x73, 0x4f, 0xe2, 0x9e, 0x1e, 0x4e,
+	0x79, 0x32, 0xe5, 0x49, 0x38, 0x07, 0xdf, 0x3c, 0x05, 0x5f, 0x81, 0x2f, 0x05, 0x5d, 0xb1, 0x45,
+	0xba, 0xae, 0x37, 0xf6, 0x74, 0x7f, 0xbb, 0xc6, 0x97, 0xe0, 0x95, 0x05, 0xdd, 0x28, 0xc5, 0xd6,
+	0x4a, 0x47, 0xb5, 0xb7, 0xeb, 0x66, 0x7d, 0xe7, 0x68, 0xfd, 0xf3, 0x77, 0xe0, 0xea, 0x1b, 0xc1,
+	0x2e, 0xb8, 0xb3, 0xf4, 0x81, 0xad, 0x83, 0x96, 0x2a, 0xa7, 0x7c, 0x45, 0xb3, 0xc0, 0x42, 0x80,
+	0xce, 0x75, 0xc6, 0x97, 0x34, 0x0b, 0xec, 0xf3, 0xcf, 0xd0, 0x56, 0x6f, 0x29, 0xf6, 0xc0, 0xab,
+	0xb3, 0xaf, 0x58, 0x1d, 0x71, 0x60, 0xe1, 0x29, 0x74, 0x0f, 0x49, 0x06, 0x36, 0x7a, 0xe0, 0xdc,
+	0x31, 0x19, 0x38, 0x0a, 0x99, 0xa7, 0x39, 0x13, 0x41, 0xfb, 0x32, 0xf8, 0xb5, 0x1f, 0x5a, 0xbf,
+	0xf7, 0x43, 0xeb, 0xcf, 0x7e, 0x68, 0xfd, 0xfc, 0x3b, 0x6c, 0x2d, 0x3b, 0xfa, 0x53, 0xfa, 0xf0,
+	0x7f, 0x00, 0x7d, 0x31, 0xc8, 0x1d, 0x8d, 0x03, 0x00, 0x00,
 }
@@ -30,6 +30,15 @@ type UDPMetric struct {
 	Timestamp  int64
 	Message    string
 	HostName   string
+	// Exemplar, if non-nil, is the trace span that a histogram or
+	// timer sample was measured from.
+	Exemplar *Exemplar
+	// Unit is the unit the metric was measured in, if known. Only
+	// metrics from SSF samples carry a unit.
+	Unit string
+	// Priority is the metric's priority class, from its SSF sample
+	// or from the priority rules that veneur is configured with.
+	Priority Priority
 }
 
 // MetricScope describes where the metric will be emitted.
@@ -68,6 +77,73 @@ const (
 	GlobalOnly
 )
 
+// DefaultPreAggregatedTag is the magic tag of metrics that their
+// client already aggregated over the interval, unless a Parser says
+// otherwise.
+const DefaultPreAggregatedTag = "veneurpreaggregated"
+
+// Parser parses metrics from DogStatsD packets and SSF samples.
+type Parser struct {
+	// PreAggregatedTag is the magic tag of metrics that their client
+	// already aggregated over the interval, like a counter that it
+	// summed itself. Their values are taken as they are: a sample
+	// rate doesn't scale them. The tag is removed from the metric's
+	// tags. If it's empty, no tag marks metrics as pre-aggregated.
+	PreAggregatedTag string
+}
+
+// defaultParser is the Parser of the package-level functions.
+var defaultParser = Parser{PreAggregatedTag: DefaultPreAggregatedTag}
+
+// Priority is the priority class of a metric. When veneur is
+// overloaded, it sheds low-priority metrics first, and when it
+// flushes, it submits high-priority metrics first. Its values match
+// those of ssf.SSFSample_Priority and of metricpb.Metric's priority.
+type Priority int
+
+const (
+	PriorityNormal Priority = iota
+	PriorityLow
+	PriorityHigh
+)
+
+var priorityNames = map[Priority]string{
+	PriorityNormal: "normal",
+	PriorityLow:    "low",
+	PriorityHigh:   "high",
+}
+
+// the order in which priorities are flushed, from last to first:
+var priorityRanks = map[Priority]int{
+	PriorityLow:    0,
+	PriorityNormal: 1,
+	PriorityHigh:   2,
+}
+
+// ParsePriority returns the priority with the given name: "low",
+// "normal" or "high".
+func ParsePriority(name string) (Priority, error) {
+	for p, pname := range priorityNames {
+		if pname == name {
+			return p, nil
+		}
+	}
+	return PriorityNormal, fmt.Errorf("unknown priority %q, must be one of low, normal or high", name)
+}
+
+func (p Priority) String() string {
+	if name, ok := priorityNames[p]; ok {
+		return name
+	}
+	return "normal"
+}
+
+// Outranks returns whether metrics of priority p are flushed before,
+// and shed after, metrics of priority other.
+func (p Priority) Outranks(other Priority) bool {
+	return priorityRanks[p] > priorityRanks[other]
+}
+
 // MetricKey is a struct used to key the metrics into the worker's map. All fields must be comparable types.
 type MetricKey struct {
 	Name       string `json:"name"`
@@ -100,12 +176,18 @@ func (m MetricKey) String() string {
 // collects them into the error type InvalidMetrics and returns this
 // error alongside any valid metrics that could be parsed.
 func ConvertMetrics(m *ssf.SSFSpan) ([]UDPMetric, error) {
+	return defaultParser.ConvertMetrics(m)
+}
+
+// ConvertMetrics is like the package-level ConvertMetrics, with the
+// parser's magic tags.
+func (p Parser) ConvertMetrics(m *ssf.SSFSpan) ([]UDPMetric, error) {
 	samples := m.Metrics
 	metrics := make([]UDPMetric, 0, len(samples)+1)
 	invalid := []*ssf.SSFSample{}
 
 	for _, metricPacket := range samples {
-		metric, err := ParseMetricSSF(metricPacket)
+		metric, err := p.ParseMetricSSF(metricPacket)
 		if err != nil || !ValidMetric(metric) {
 			invalid = append(invalid, metricPacket)
 			continue
@@ -148,6 +230,32 @@ func ConvertIndicatorMetrics(span *ssf.SSFSpan, timerName string) (metrics []UDP
 	return metrics, nil
 }
 
+// ConvertSpanDurationMetrics takes a trace span and returns a timer
+// metric for its duration, tagged with the span's service, operation
+// name and whether it was an error. The span's trace and span IDs are
+// attached to the timer sample as its exemplar.
+func ConvertSpanDurationMetrics(span *ssf.SSFSpan, timerName string) ([]UDPMetric, error) {
+	duration := time.Duration(span.EndTimestamp - span.StartTimestamp)
+	tags := map[string]string{
+		"service":   span.Service,
+		"operation": span.Name,
+		"error":     strconv.FormatBool(span.Error),
+	}
+	ssfTimer := ssf.Timing(timerName, duration, time.Nanosecond, tags)
+	ssfTimer.Name = timerName // Ensure the name is free from any name prefixes, like "veneur."
+
+	timer, err := ParseMetricSSF(ssfTimer)
+	if err != nil {
+		return nil, err
+	}
+	timer.Exemplar = &Exemplar{
+		TraceID: span.TraceId,
+		SpanID:  span.Id,
+		Value:   timer.Value.(float64),
+	}
+	return []UDPMetric{timer}, nil
+}
+
 // ConvertSpanUniquenessMetrics takes a trace span and computes
 // uniqueness metrics about it, returning UDPMetrics sampled at
 // rate. Currently, the only metric returned is a Set counting the
@@ -205,6 +313,12 @@ func (err *invalidMetrics) Samples() []*ssf.SSFSample {
 
 // ParseMetricSSF converts an incoming SSF packet to a Metric.
 func ParseMetricSSF(metric *ssf.SSFSample) (UDPMetric, error) {
+	return defaultParser.ParseMetricSSF(metric)
+}
+
+// ParseMetricSSF is like the package-level ParseMetricSSF, with the
+// parser's magic tags.
+func (p Parser) ParseMetricSSF(metric *ssf.SSFSample) (UDPMetric, error) {
 	ret := UDPMetric{
 		SampleRate: 1.0,
 	}
@@ -235,6 +349,8 @@ func ParseMetricSSF(metric *ssf.SSFSample) (UDPMetric, error) {
 		ret.Value = float64(metric.Value)
 	}
 	ret.SampleRate = metric.SampleRate
+	ret.Unit = metric.Unit
+	ret.Priority = Priority(metric.Priority)
 	tempTags := make([]string, 0, len(metric.Tags))
 	for key, value := range metric.Tags {
 		if key == "veneurlocalonly" {
@@ -245,6 +361,10 @@ func ParseMetricSSF(metric *ssf.SSFSample) (UDPMetric, error) {
 			ret.Scope = GlobalOnly
 			continue
 		}
+		if p.PreAggregatedTag != "" && key == p.PreAggregatedTag {
+			ret.SampleRate = 1
+			continue
+		}
 		tempTags = append(tempTags, key+":"+value)
 	}
 	sort.Strings(tempTags)
@@ -258,6 +378,12 @@ func ParseMetricSSF(metric *ssf.SSFSample) (UDPMetric, error) {
 // ParseMetric converts the incoming packet from Datadog DogStatsD
 // Datagram format in to a Metric. http://docs.datadoghq.com/guides/dogstatsd/#datagram-format
 func ParseMetric(packet []byte) (*UDPMetric, error) {
+	return defaultParser.ParseMetric(packet)
+}
+
+// ParseMetric is like the package-level ParseMetric, with the parser's
+// magic tags.
+func (p Parser) ParseMetric(packet []byte) (*UDPMetric, error) {
 	ret := &UDPMetric{
 		SampleRate: 1.0,
 	}
@@ -320,6 +446,7 @@ func ParseMetric(packet []byte) (*UDPMetric, error) {
 
 	// each of these sections can only appear once in the packet
 	foundSampleRate := false
+	preAggregated := false
 	for pipeSplitter.Next() {
 		if len(pipeSplitter.Chunk()) == 0 {
 			// avoid panicking on malformed packets that have too many pipes
@@ -353,19 +480,29 @@ func ParseMetric(packet []byte) (*UDPMetric, error) {
 			// see worker.go line 273
 			tags := strings.Split(string(pipeSplitter.Chunk()[1:]), ",")
 			sort.Strings(tags)
-			for i, tag := range tags {
+			scoped := false
+			for i := 0; i < len(tags); i++ {
+				tag := tags[i]
 				// we use this tag as an escape hatch for metrics that always
 				// want to be host-local
-				if strings.HasPrefix(tag, "veneurlocalonly") {
+				if !scoped && strings.HasPrefix(tag, "veneurlocalonly") {
 					// delete the tag from the list
 					tags = append(tags[:i], tags[i+1:]...)
+					i--
 					ret.Scope = LocalOnly
-					break
-				} else if strings.HasPrefix(tag, "veneurglobalonly") {
+					scoped = true
+				} else if !scoped && strings.HasPrefix(tag, "veneurglobalonly") {
 					// delete the tag from the list
 					tags = append(tags[:i], tags[i+1:]...)
+					i--
 					ret.Scope = GlobalOnly
-					break
+					scoped = true
+				} else if p.PreAggregatedTag != "" && tag == p.PreAggregatedTag {
+					// the client already aggregated the value over
+					// the interval, it isn't a sample:
+					tags = append(tags[:i], tags[i+1:]...)
+					i--
+					preAggregated = true
 				}
 			}
 			ret.Tags = tags
@@ -379,6 +516,11 @@ func ParseMetric(packet []byte) (*UDPMetric, error) {
 		}
 	}
 
+	if preAggregated {
+		// the value is all there is for the interval; scaling it
+		// by the sample rate would count it more than once:
+		ret.SampleRate = 1
+	}
 	ret.Digest = h
 
 	return ret, nil
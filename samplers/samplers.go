@@ -24,6 +24,9 @@ const (
 	GaugeMetric
 	// StatusMetric is a status (synonymous with a service check)
 	StatusMetric
+	// DistributionMetric is a distribution of values, whose
+	// percentiles a sink computes server-side
+	DistributionMetric
 )
 
 // RouteInformation is a key-only map indicating sink names that are
@@ -58,6 +61,32 @@ type InterMetric struct {
 	// should be inserted into. If nil, that means the metric is
 	// meant to go to every sink.
 	Sinks RouteInformation
+
+	// Unit is the unit that the metric's value is measured in, if
+	// known, for sinks that can record it.
+	Unit string `json:",omitempty"`
+
+	// Priority is the metric's priority class. Metrics of a higher
+	// priority are flushed to sinks first.
+	Priority Priority `json:",omitempty"`
+
+	// Exemplar, if non-nil, is a trace span that was measured
+	// in the metric's value, for sinks that can link metrics to
+	// traces.
+	Exemplar *Exemplar `json:",omitempty"`
+
+	// Values are the values of a distribution metric, and Weights
+	// the number of samples of each of them.
+	Values  []float64 `json:",omitempty"`
+	Weights []float64 `json:",omitempty"`
+}
+
+// Exemplar identifies a trace span whose duration (or other value)
+// was sampled into a histogram or timer.
+type Exemplar struct {
+	TraceID int64
+	SpanID  int64
+	Value   float64
 }
 
 type Aggregate int
@@ -105,6 +134,13 @@ type JSONMetric struct {
 	// the Value is an internal representation of the metric's contents, eg a
 	// gob-encoded histogram or hyperloglog.
 	Value []byte `json:"value"`
+	// Unit is the unit the metric's values were measured in, if known.
+	Unit string `json:"unit,omitempty"`
+	// Priority is the metric's priority class.
+	Priority Priority `json:"priority,omitempty"`
+	// Exemplar is the exemplar of a histogram or timer, if it has
+	// one.
+	Exemplar *Exemplar `json:"exemplar,omitempty"`
 }
 
 const sinkPrefix string = "veneursinkonly:"
@@ -128,9 +164,20 @@ func routeInfo(tags []string) RouteInformation {
 
 // Counter is an accumulator
 type Counter struct {
-	Name  string
-	Tags  []string
-	value int64
+	Name     string
+	Tags     []string
+	Unit     string
+	Priority Priority
+	value    int64
+
+	// Summaries makes Flush report, alongside the counter's value,
+	// how many samples were added to it and by how much their
+	// sample rates scaled them up. Only samples that were added
+	// with Sample are accounted for.
+	Summaries bool
+	samples   int64
+	rawSum    int64
+	scaledSum int64
 }
 
 // GetName returns the name of the counter.
@@ -140,21 +187,67 @@ func (c *Counter) GetName() string {
 
 // Sample adds a sample to the counter.
 func (c *Counter) Sample(sample float64, sampleRate float32) {
-	c.value += int64(sample) * int64(1/sampleRate)
+	scaled := int64(sample) * int64(1/sampleRate)
+	c.value += scaled
+
+	c.samples++
+	c.rawSum += int64(sample)
+	c.scaledSum += scaled
 }
 
 // Flush generates an InterMetric from the current state of this Counter.
 func (c *Counter) Flush(interval time.Duration) []InterMetric {
 	tags := make([]string, len(c.Tags))
 	copy(tags, c.Tags)
-	return []InterMetric{{
+	metrics := []InterMetric{{
 		Name:      c.Name,
 		Timestamp: time.Now().Unix(),
 		Value:     float64(c.value),
 		Tags:      tags,
 		Type:      CounterMetric,
 		Sinks:     routeInfo(tags),
+		Priority:  c.Priority,
+		Unit:      c.Unit,
+	}}
+	if c.Summaries && c.samples > 0 {
+		metrics = append(metrics, c.summaries()...)
+	}
+	return metrics
+}
+
+// summaries returns the number of samples added to the counter and,
+// if their values didn't sum to zero, the average factor by which
+// their sample rates scaled them up. A client that sends the wrong
+// sample rate shows up as a correction that doesn't match the rate
+// it is supposed to sample at.
+func (c *Counter) summaries() []InterMetric {
+	now := time.Now().Unix()
+	sinks := routeInfo(c.Tags)
+	tags := make([]string, len(c.Tags))
+	copy(tags, c.Tags)
+	metrics := []InterMetric{{
+		Name:      fmt.Sprintf("%s.samples", c.Name),
+		Timestamp: now,
+		Value:     float64(c.samples),
+		Tags:      tags,
+		Type:      CounterMetric,
+		Sinks:     sinks,
+		Priority:  c.Priority,
 	}}
+	if c.rawSum != 0 {
+		tags := make([]string, len(c.Tags))
+		copy(tags, c.Tags)
+		metrics = append(metrics, InterMetric{
+			Name:      fmt.Sprintf("%s.sample_rate_correction", c.Name),
+			Timestamp: now,
+			Value:     float64(c.scaledSum) / float64(c.rawSum),
+			Tags:      tags,
+			Type:      GaugeMetric,
+			Sinks:     sinks,
+			Priority:  c.Priority,
+		})
+	}
+	return metrics
 }
 
 // Export converts a Counter into a JSONMetric which reports the rate.
@@ -172,8 +265,10 @@ func (c *Counter) Export() (JSONMetric, error) {
 			Type:       "counter",
 			JoinedTags: strings.Join(c.Tags, ","),
 		},
-		Tags:  c.Tags,
-		Value: buf.Bytes(),
+		Tags:     c.Tags,
+		Value:    buf.Bytes(),
+		Priority: c.Priority,
+		Unit:     c.Unit,
 	}, nil
 }
 
@@ -197,10 +292,12 @@ func (c *Counter) Combine(other []byte) error {
 // a Counter for forwarding.
 func (c *Counter) Metric() (*metricpb.Metric, error) {
 	return &metricpb.Metric{
-		Name:  c.Name,
-		Tags:  c.Tags,
-		Type:  metricpb.Type_Counter,
-		Value: &metricpb.Metric_Counter{&metricpb.CounterValue{Value: c.value}},
+		Name:     c.Name,
+		Tags:     c.Tags,
+		Type:     metricpb.Type_Counter,
+		Priority: int32(c.Priority),
+		Value:    &metricpb.Metric_Counter{&metricpb.CounterValue{Value: c.value}},
+		Unit:     c.Unit,
 	}, nil
 }
 
@@ -209,6 +306,29 @@ func (c *Counter) Merge(v *metricpb.CounterValue) {
 	c.value += v.Value
 }
 
+// CounterSummary is the state behind a counter's summaries: the
+// number of samples added to it, and the sums of their values before
+// and after scaling them by their sample rates.
+type CounterSummary struct {
+	Samples   int64
+	RawSum    int64
+	ScaledSum int64
+}
+
+// Summary returns the state behind the counter's summaries, which
+// Export doesn't include.
+func (c *Counter) Summary() CounterSummary {
+	return CounterSummary{Samples: c.samples, RawSum: c.rawSum, ScaledSum: c.scaledSum}
+}
+
+// AddSummary adds the summary state of another counter with the same
+// name and tags to this one.
+func (c *Counter) AddSummary(s CounterSummary) {
+	c.samples += s.Samples
+	c.rawSum += s.RawSum
+	c.scaledSum += s.ScaledSum
+}
+
 // NewCounter generates and returns a new Counter.
 func NewCounter(Name string, Tags []string) *Counter {
 	return &Counter{Name: Name, Tags: Tags}
@@ -216,9 +336,11 @@ func NewCounter(Name string, Tags []string) *Counter {
 
 // Gauge retains whatever the last value was.
 type Gauge struct {
-	Name  string
-	Tags  []string
-	value float64
+	Name     string
+	Tags     []string
+	Unit     string
+	Priority Priority
+	value    float64
 }
 
 // Sample takes on whatever value is passed in as a sample.
@@ -237,6 +359,8 @@ func (g *Gauge) Flush() []InterMetric {
 		Tags:      tags,
 		Type:      GaugeMetric,
 		Sinks:     routeInfo(tags),
+		Priority:  g.Priority,
+		Unit:      g.Unit,
 	}}
 
 }
@@ -256,8 +380,10 @@ func (g *Gauge) Export() (JSONMetric, error) {
 			Type:       "gauge",
 			JoinedTags: strings.Join(g.Tags, ","),
 		},
-		Tags:  g.Tags,
-		Value: buf.Bytes(),
+		Tags:     g.Tags,
+		Value:    buf.Bytes(),
+		Priority: g.Priority,
+		Unit:     g.Unit,
 	}, nil
 }
 
@@ -286,10 +412,12 @@ func (g *Gauge) GetName() string {
 // a Gauge for forwarding.
 func (g *Gauge) Metric() (*metricpb.Metric, error) {
 	return &metricpb.Metric{
-		Name:  g.Name,
-		Tags:  g.Tags,
-		Type:  metricpb.Type_Gauge,
-		Value: &metricpb.Metric_Gauge{&metricpb.GaugeValue{Value: g.value}},
+		Name:     g.Name,
+		Tags:     g.Tags,
+		Type:     metricpb.Type_Gauge,
+		Priority: int32(g.Priority),
+		Value:    &metricpb.Metric_Gauge{&metricpb.GaugeValue{Value: g.value}},
+		Unit:     g.Unit,
 	}, nil
 }
 
@@ -365,9 +493,10 @@ func NewStatusCheck(Name string, Tags []string) *StatusCheck {
 
 // Set is a list of unique values seen.
 type Set struct {
-	Name string
-	Tags []string
-	Hll  *hyperloglog.Sketch
+	Name     string
+	Tags     []string
+	Priority Priority
+	Hll      *hyperloglog.Sketch
 }
 
 // Sample checks if the supplied value has is already in the filter. If not, it increments
@@ -399,6 +528,7 @@ func (s *Set) Flush() []InterMetric {
 		Tags:      tags,
 		Type:      GaugeMetric,
 		Sinks:     routeInfo(tags),
+		Priority:  s.Priority,
 	}}
 }
 
@@ -414,8 +544,9 @@ func (s *Set) Export() (JSONMetric, error) {
 			Type:       "set",
 			JoinedTags: strings.Join(s.Tags, ","),
 		},
-		Tags:  s.Tags,
-		Value: val,
+		Tags:     s.Tags,
+		Value:    val,
+		Priority: s.Priority,
 	}, nil
 }
 
@@ -449,10 +580,11 @@ func (s *Set) Metric() (*metricpb.Metric, error) {
 	}
 
 	return &metricpb.Metric{
-		Name:  s.Name,
-		Tags:  s.Tags,
-		Type:  metricpb.Type_Set,
-		Value: &metricpb.Metric_Set{&metricpb.SetValue{HyperLogLog: encoded}},
+		Name:     s.Name,
+		Tags:     s.Tags,
+		Type:     metricpb.Type_Set,
+		Priority: int32(s.Priority),
+		Value:    &metricpb.Metric_Set{&metricpb.SetValue{HyperLogLog: encoded}},
 	}, nil
 }
 
@@ -465,9 +597,11 @@ func (s *Set) Merge(v *metricpb.SetValue) error {
 // Histo is a collection of values that generates max, min, count, and
 // percentiles over time.
 type Histo struct {
-	Name  string
-	Tags  []string
-	Value *tdigest.MergingDigest
+	Name     string
+	Tags     []string
+	Unit     string
+	Priority Priority
+	Value    *tdigest.MergingDigest
 	// these values are computed from only the samples that came through this
 	// veneur instance, ignoring any histograms merged from elsewhere
 	// we separate them because they're easy to aggregate on the backend without
@@ -478,6 +612,17 @@ type Histo struct {
 	LocalMax           float64
 	LocalSum           float64
 	LocalReciprocalSum float64
+
+	// WeightedMerge makes Combine and Merge merge other t-digests'
+	// weighted centroids in one pass, rather than re-adding them as
+	// samples. MergeError is the largest error estimated for such a
+	// merge since the histogram was created.
+	WeightedMerge bool
+	MergeError    float64
+
+	// Exemplar is the locally sampled exemplar with the largest
+	// value, if any samples came with one.
+	Exemplar *Exemplar
 }
 
 // Sample adds the supplied value to the histogram.
@@ -493,13 +638,32 @@ func (h *Histo) Sample(sample float64, sampleRate float32) {
 	h.LocalReciprocalSum += (1 / sample) * weight
 }
 
+// DefaultHistogramCompression is the compression of the t-digests
+// in histograms and timers, unless configured otherwise. We're going
+// to allocate a lot of these, so we don't want them to be huge.
+const DefaultHistogramCompression = 100
+
+// SampleExemplar records the exemplar of a sample, if it has the
+// largest value of the histogram's exemplars so far.
+func (h *Histo) SampleExemplar(e Exemplar) {
+	if h.Exemplar == nil || e.Value > h.Exemplar.Value {
+		h.Exemplar = &e
+	}
+}
+
 // NewHist generates a new Histo and returns it.
 func NewHist(Name string, Tags []string) *Histo {
+	return NewHistWithCompression(Name, Tags, DefaultHistogramCompression)
+}
+
+// NewHistWithCompression generates a new Histo whose t-digest has the
+// given compression. Higher compressions are more accurate and use
+// more memory; see the README for their error bounds.
+func NewHistWithCompression(Name string, Tags []string, compression float64) *Histo {
 	return &Histo{
-		Name: Name,
-		Tags: Tags,
-		// we're going to allocate a lot of these, so we don't want them to be huge
-		Value:    tdigest.NewMerging(100, false),
+		Name:     Name,
+		Tags:     Tags,
+		Value:    tdigest.NewMerging(compression, false),
 		LocalMin: math.Inf(+1),
 		LocalMax: math.Inf(-1),
 		LocalSum: 0,
@@ -544,6 +708,9 @@ func (h *Histo) Flush(interval time.Duration, percentiles []float64, aggregates
 			Tags:      tags,
 			Type:      GaugeMetric,
 			Sinks:     sinks,
+			Priority:  h.Priority,
+			Unit:      h.Unit,
+			Exemplar:  h.Exemplar,
 		})
 	}
 	if (aggregates.Value&AggregateMin) == AggregateMin && (!math.IsInf(h.LocalMin, 0) || global) {
@@ -560,6 +727,8 @@ func (h *Histo) Flush(interval time.Duration, percentiles []float64, aggregates
 			Tags:      tags,
 			Type:      GaugeMetric,
 			Sinks:     sinks,
+			Priority:  h.Priority,
+			Unit:      h.Unit,
 		})
 	}
 
@@ -577,6 +746,8 @@ func (h *Histo) Flush(interval time.Duration, percentiles []float64, aggregates
 			Tags:      tags,
 			Type:      GaugeMetric,
 			Sinks:     sinks,
+			Priority:  h.Priority,
+			Unit:      h.Unit,
 		})
 	}
 
@@ -596,6 +767,8 @@ func (h *Histo) Flush(interval time.Duration, percentiles []float64, aggregates
 			Tags:      tags,
 			Type:      GaugeMetric,
 			Sinks:     sinks,
+			Priority:  h.Priority,
+			Unit:      h.Unit,
 		})
 	}
 
@@ -616,6 +789,7 @@ func (h *Histo) Flush(interval time.Duration, percentiles []float64, aggregates
 			Tags:      tags,
 			Type:      CounterMetric,
 			Sinks:     sinks,
+			Priority:  h.Priority,
 		})
 	}
 
@@ -631,6 +805,8 @@ func (h *Histo) Flush(interval time.Duration, percentiles []float64, aggregates
 				Tags:      tags,
 				Type:      GaugeMetric,
 				Sinks:     sinks,
+				Priority:  h.Priority,
+				Unit:      h.Unit,
 			},
 		)
 	}
@@ -651,12 +827,27 @@ func (h *Histo) Flush(interval time.Duration, percentiles []float64, aggregates
 			Tags:      tags,
 			Type:      GaugeMetric,
 			Sinks:     sinks,
+			Priority:  h.Priority,
+			Unit:      h.Unit,
 		})
 	}
 
-	for _, p := range percentiles {
+	// The exemplar, the slowest span, is also attached to the
+	// highest percentile, since global veneurs emit percentiles
+	// rather than the maximum of mixed-scope histograms:
+	highest := -1
+	for i, p := range percentiles {
+		if highest < 0 || p > percentiles[highest] {
+			highest = i
+		}
+	}
+	for i, p := range percentiles {
 		tags := make([]string, len(h.Tags))
 		copy(tags, h.Tags)
+		var exemplar *Exemplar
+		if i == highest {
+			exemplar = h.Exemplar
+		}
 		metrics = append(
 			metrics,
 			// TODO Fix to allow for p999, etc
@@ -667,6 +858,9 @@ func (h *Histo) Flush(interval time.Duration, percentiles []float64, aggregates
 				Tags:      tags,
 				Type:      GaugeMetric,
 				Sinks:     sinks,
+				Priority:  h.Priority,
+				Unit:      h.Unit,
+				Exemplar:  exemplar,
 			},
 		)
 	}
@@ -674,6 +868,38 @@ func (h *Histo) Flush(interval time.Duration, percentiles []float64, aggregates
 	return metrics
 }
 
+// FlushDistribution returns the histogram as a distribution metric,
+// for sinks that compute percentiles server-side, or nothing if it's
+// empty. The distribution is approximated from the digest: its values
+// are the means of the centroids, and its weights the number of
+// samples of each, so it holds every sample of the histogram in as
+// many values as the digest has centroids.
+func (h *Histo) FlushDistribution() []InterMetric {
+	if h.Value.Count() == 0 {
+		return nil
+	}
+	centroids := h.Value.Data().MainCentroids
+	values := make([]float64, len(centroids))
+	weights := make([]float64, len(centroids))
+	for i, c := range centroids {
+		values[i] = c.Mean
+		weights[i] = c.Weight
+	}
+	tags := make([]string, len(h.Tags))
+	copy(tags, h.Tags)
+	return []InterMetric{{
+		Name:      h.Name,
+		Timestamp: time.Now().Unix(),
+		Tags:      tags,
+		Type:      DistributionMetric,
+		Sinks:     routeInfo(h.Tags),
+		Priority:  h.Priority,
+		Unit:      h.Unit,
+		Values:    values,
+		Weights:   weights,
+	}}
+}
+
 // Export converts a Histogram into a JSONMetric
 func (h *Histo) Export() (JSONMetric, error) {
 	val, err := h.Value.GobEncode()
@@ -686,22 +912,34 @@ func (h *Histo) Export() (JSONMetric, error) {
 			Type:       "histogram",
 			JoinedTags: strings.Join(h.Tags, ","),
 		},
-		Tags:  h.Tags,
-		Value: val,
+		Tags:     h.Tags,
+		Value:    val,
+		Priority: h.Priority,
+		Unit:     h.Unit,
+		Exemplar: h.Exemplar,
 	}, nil
 }
 
 // Combine merges the values of a histogram with another histogram
 // (marshalled as a byte slice)
 func (h *Histo) Combine(other []byte) error {
-	otherHistogram := tdigest.NewMerging(100, false)
+	otherHistogram := tdigest.NewMerging(DefaultHistogramCompression, false)
 	if err := otherHistogram.GobDecode(other); err != nil {
 		return err
 	}
-	h.Value.Merge(otherHistogram)
+	h.mergeDigest(otherHistogram)
 	return nil
 }
 
+// mergeDigest merges another t-digest into the histogram's.
+func (h *Histo) mergeDigest(other *tdigest.MergingDigest) {
+	if !h.WeightedMerge {
+		h.Value.Merge(other)
+		return
+	}
+	h.MergeError = math.Max(h.MergeError, h.Value.MergeWeighted(other))
+}
+
 // GetName returns the name of the Histo.
 func (h *Histo) GetName() string {
 	return h.Name
@@ -711,20 +949,35 @@ func (h *Histo) GetName() string {
 // at the time this function was called.  This should be used to export
 // a Histo for forwarding.
 func (h *Histo) Metric() (*metricpb.Metric, error) {
+	var exemplar *metricpb.Exemplar
+	if h.Exemplar != nil {
+		exemplar = &metricpb.Exemplar{
+			TraceId: h.Exemplar.TraceID,
+			SpanId:  h.Exemplar.SpanID,
+			Value:   h.Exemplar.Value,
+		}
+	}
 	return &metricpb.Metric{
-		Name: h.Name,
-		Tags: h.Tags,
-		Type: metricpb.Type_Histogram,
+		Name:     h.Name,
+		Tags:     h.Tags,
+		Type:     metricpb.Type_Histogram,
+		Priority: int32(h.Priority),
 		Value: &metricpb.Metric_Histogram{&metricpb.HistogramValue{
-			TDigest: h.Value.Data(),
+			TDigest:  h.Value.Data(),
+			Exemplar: exemplar,
 		}},
+		Unit: h.Unit,
 	}, nil
 }
 
 // Merge merges the t-digests of the two histograms and mutates the state
-// of this one.
+// of this one. The other histogram's exemplar replaces this one's if its
+// value is larger.
 func (h *Histo) Merge(v *metricpb.HistogramValue) {
 	if v.TDigest != nil {
-		h.Value.Merge(tdigest.NewMergingFromData(v.TDigest))
+		h.mergeDigest(tdigest.NewMergingFromData(v.TDigest))
+	}
+	if e := v.Exemplar; e != nil {
+		h.SampleExemplar(Exemplar{TraceID: e.TraceId, SpanID: e.SpanId, Value: e.Value})
 	}
 }
@@ -10,6 +10,8 @@ import (
 	"github.com/stripe/veneur/tdigest"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers/metricpb"
 	"github.com/stripe/veneur/ssf"
 )
 
@@ -84,6 +86,36 @@ func TestCounterSampleRate(t *testing.T) {
 	assert.Equal(t, float64(10), metrics[0].Value, "Metric value")
 }
 
+func TestCounterSummaries(t *testing.T) {
+	c := NewCounter("a.b.c", []string{"a:b"})
+	c.Sample(5, 0.5)
+	assert.Len(t, c.Flush(10*time.Second), 1, "summaries should be off by default")
+
+	c = NewCounter("a.b.c", []string{"a:b"})
+	c.Summaries = true
+	c.Sample(5, 0.5)
+	c.Sample(1, 0.1)
+	c.Sample(4, 1.0)
+	assert.NoError(t, c.Combine([]byte{1, 0, 0, 0, 0, 0, 0, 0}), "merged values shouldn't count as samples")
+
+	metrics := c.Flush(10 * time.Second)
+	if assert.Len(t, metrics, 3) {
+		assert.Equal(t, float64(25), metrics[0].Value)
+		assert.Equal(t, "a.b.c.samples", metrics[1].Name)
+		assert.Equal(t, CounterMetric, metrics[1].Type)
+		assert.Equal(t, float64(3), metrics[1].Value)
+		assert.Equal(t, "a.b.c.sample_rate_correction", metrics[2].Name)
+		assert.Equal(t, GaugeMetric, metrics[2].Type)
+		assert.Equal(t, float64(24)/10, metrics[2].Value)
+		assert.Equal(t, []string{"a:b"}, metrics[2].Tags)
+	}
+
+	imported := NewCounter("a.b.c", nil)
+	imported.Summaries = true
+	assert.NoError(t, imported.Combine([]byte{1, 0, 0, 0, 0, 0, 0, 0}))
+	assert.Len(t, imported.Flush(10*time.Second), 1, "counters without samples have no summaries")
+}
+
 func TestCounterMerge(t *testing.T) {
 	c := NewCounter("a.b.c", []string{"tag:val"})
 
@@ -308,6 +340,37 @@ func TestLocalHistoFlushedBehavior(t *testing.T) {
 	assert.Equal(t, float64(1), m[0].Value, "histogram returned global value for mixed scope flush.")
 }
 
+func TestHistoFlushDistribution(t *testing.T) {
+	h := NewHist("a.b.c", []string{"a:b", "veneursinkonly:datadog"})
+	assert.Empty(t, h.FlushDistribution(), "empty histograms shouldn't be flushed")
+
+	h.Sample(5, 1.0)
+	h.Sample(10, 0.5)
+	h.Sample(15, 1.0)
+
+	metrics := h.FlushDistribution()
+	require.Len(t, metrics, 1)
+	assert.Equal(t, "a.b.c", metrics[0].Name)
+	assert.Equal(t, DistributionMetric, metrics[0].Type)
+	assert.Equal(t, []string{"a:b", "veneursinkonly:datadog"}, metrics[0].Tags)
+	assert.True(t, metrics[0].Sinks.RouteTo("datadog"))
+	assert.False(t, metrics[0].Sinks.RouteTo("signalfx"))
+	assert.Equal(t, []float64{5, 10, 15}, metrics[0].Values)
+	assert.Equal(t, []float64{1, 2, 1}, metrics[0].Weights,
+		"each value should be weighted by its sample rate")
+
+	for i := 0; i < 100000; i++ {
+		h.Sample(float64(i), 1.0)
+	}
+	metrics = h.FlushDistribution()
+	total := 0.0
+	for _, w := range metrics[0].Weights {
+		total += w
+	}
+	assert.Equal(t, float64(100004), total, "the distribution should hold every sample")
+	assert.True(t, len(metrics[0].Values) < 10000, "the distribution should hold centroids, not samples")
+}
+
 func TestHisto(t *testing.T) {
 	h := NewHist("a.b.c", []string{"a:b"})
 
@@ -580,7 +643,8 @@ func TestParseMetricSSF(t *testing.T) {
 			"wilde":            "true",
 			"veneurglobalonly": "true",
 		},
-		Unit: "frobs per second",
+		Unit:     "frobs per second",
+		Priority: ssf.SSFSample_HIGH,
 	}
 
 	expected := UDPMetric{
@@ -597,7 +661,8 @@ func TestParseMetricSSF(t *testing.T) {
 			"wilde:true",
 			"yeats:false",
 		},
-		Scope: 2,
+		Scope:    2,
+		Priority: PriorityHigh,
 	}
 
 	udpMetric, err := ParseMetricSSF(&sample)
@@ -610,6 +675,22 @@ func TestParseMetricSSF(t *testing.T) {
 	assert.Equal(t, udpMetric.JoinedTags, expected.JoinedTags)
 	assert.Equal(t, udpMetric.Tags, expected.Tags)
 	assert.Equal(t, udpMetric.Scope, expected.Scope)
+	assert.Equal(t, udpMetric.Priority, expected.Priority)
+}
+
+func TestParsePriority(t *testing.T) {
+	for _, p := range []Priority{PriorityLow, PriorityNormal, PriorityHigh} {
+		parsed, err := ParsePriority(p.String())
+		assert.NoError(t, err)
+		assert.Equal(t, p, parsed)
+	}
+	_, err := ParsePriority("urgent")
+	assert.Error(t, err)
+
+	assert.True(t, PriorityHigh.Outranks(PriorityNormal))
+	assert.True(t, PriorityNormal.Outranks(PriorityLow))
+	assert.False(t, PriorityLow.Outranks(PriorityNormal))
+	assert.False(t, PriorityNormal.Outranks(PriorityNormal))
 }
 
 func BenchmarkParseMetricSSF(b *testing.B) {
@@ -643,3 +724,130 @@ func BenchmarkParseMetricSSF(b *testing.B) {
 		ParseMetricSSF(samples[i%LEN])
 	}
 }
+
+func TestHistoWeightedMerge(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	h := NewHist("a.b.c", []string{"a:b"})
+	for i := 0; i < 1000; i++ {
+		h.Sample(r.NormFloat64(), 1.0)
+	}
+	h.Sample(50, 1.0)
+	jm, err := h.Export()
+	assert.NoError(t, err)
+	m, err := h.Metric()
+	assert.NoError(t, err)
+
+	h2 := NewHist("a.b.c", []string{"a:b"})
+	h2.WeightedMerge = true
+	assert.NoError(t, h2.Combine(jm.Value))
+	h2.Merge(m.GetHistogram())
+	assert.Equal(t, 2002.0, h2.Value.Count())
+	assert.Equal(t, 50.0, h2.Value.Max(), "the exact maximum should survive merging")
+	assert.InEpsilon(t, h.Value.Quantile(0.9), h2.Value.Quantile(0.9), 0.02, "90th percentiles did not match after merging")
+	assert.True(t, h2.MergeError > 0 && h2.MergeError < 0.01, "merge error was %v", h2.MergeError)
+
+	// Without weighted merging, no error is estimated:
+	h3 := NewHist("a.b.c", []string{"a:b"})
+	assert.NoError(t, h3.Combine(jm.Value))
+	assert.Equal(t, 0.0, h3.MergeError)
+}
+
+func TestHistoExemplar(t *testing.T) {
+	h := NewHist("a.b.c", []string{"a:b"})
+	h.Sample(5, 1.0)
+	h.SampleExemplar(Exemplar{TraceID: 1, SpanID: 2, Value: 5})
+	h.Sample(20, 1.0)
+	h.SampleExemplar(Exemplar{TraceID: 3, SpanID: 4, Value: 20})
+	h.Sample(10, 1.0)
+	h.SampleExemplar(Exemplar{TraceID: 5, SpanID: 6, Value: 10})
+
+	aggregates := HistogramAggregates{Value: AggregateMax | AggregateMin, Count: 2}
+	for _, m := range h.Flush(10*time.Second, []float64{0.99, 0.5}, aggregates, false) {
+		if m.Name == "a.b.c.max" || m.Name == "a.b.c.99percentile" {
+			assert.Equal(t, &Exemplar{TraceID: 3, SpanID: 4, Value: 20}, m.Exemplar, "%s should refer to the slowest exemplar", m.Name)
+		} else {
+			assert.Nil(t, m.Exemplar, "%s should not have an exemplar", m.Name)
+		}
+	}
+}
+
+func TestHistoExemplarForwarding(t *testing.T) {
+	local := NewHist("a.b.c", []string{"a:b"})
+	local.Sample(20, 1.0)
+	local.SampleExemplar(Exemplar{TraceID: 3, SpanID: 4, Value: 20})
+	slower := NewHist("a.b.c", []string{"a:b"})
+	slower.Sample(30, 1.0)
+	slower.SampleExemplar(Exemplar{TraceID: 5, SpanID: 6, Value: 30})
+
+	// Over HTTP:
+	global := NewHist("a.b.c", []string{"a:b"})
+	jm, err := local.Export()
+	require.NoError(t, err)
+	require.NoError(t, global.Combine(jm.Value))
+	assert.Equal(t, &Exemplar{TraceID: 3, SpanID: 4, Value: 20}, jm.Exemplar)
+
+	// Over gRPC, where the slower exemplar wins:
+	pbm, err := slower.Metric()
+	require.NoError(t, err)
+	global.Merge(pbm.GetHistogram())
+	assert.Equal(t, &Exemplar{TraceID: 5, SpanID: 6, Value: 30}, global.Exemplar)
+	pbm, err = local.Metric()
+	require.NoError(t, err)
+	global.Merge(pbm.GetHistogram())
+	assert.Equal(t, &Exemplar{TraceID: 5, SpanID: 6, Value: 30}, global.Exemplar)
+}
+
+func TestUnitPropagation(t *testing.T) {
+	udp, err := ParseMetricSSF(ssf.Timing("a.b.c", 5*time.Millisecond, time.Millisecond, nil))
+	require.NoError(t, err)
+	assert.Equal(t, "ms", udp.Unit)
+
+	h := NewHist("a.b.c", []string{"a:b"})
+	h.Unit = udp.Unit
+	h.Sample(5, 1.0)
+	aggregates := HistogramAggregates{Value: AggregateMax | AggregateAverage | AggregateCount, Count: 3}
+	for _, m := range h.Flush(10*time.Second, []float64{0.5}, aggregates, false) {
+		if m.Name == "a.b.c.count" {
+			assert.Empty(t, m.Unit, "the count of samples has no unit")
+		} else {
+			assert.Equal(t, "ms", m.Unit, "%s should have the histogram's unit", m.Name)
+		}
+	}
+
+	// units survive forwarding:
+	pb, err := h.Metric()
+	require.NoError(t, err)
+	bts, err := pb.Marshal()
+	require.NoError(t, err)
+	forwarded := &metricpb.Metric{}
+	require.NoError(t, forwarded.Unmarshal(bts))
+	assert.Equal(t, "ms", forwarded.Unit)
+
+	c := NewCounter("a.b.c", nil)
+	c.Unit = "B"
+	jm, err := c.Export()
+	require.NoError(t, err)
+	assert.Equal(t, "B", jm.Unit)
+	assert.Equal(t, "B", c.Flush(10 * time.Second)[0].Unit)
+}
+
+func TestConvertSpanDurationMetrics(t *testing.T) {
+	span := &ssf.SSFSpan{
+		Id:             2,
+		TraceId:        1,
+		Service:        "svc",
+		Name:           "op",
+		Error:          true,
+		StartTimestamp: time.Unix(1, 0).UnixNano(),
+		EndTimestamp:   time.Unix(3, 0).UnixNano(),
+	}
+	metrics, err := ConvertSpanDurationMetrics(span, "span.duration_ns")
+	assert.NoError(t, err)
+	if assert.Len(t, metrics, 1) {
+		m := metrics[0]
+		assert.Equal(t, "span.duration_ns", m.Name)
+		assert.Equal(t, float64(2*time.Second), m.Value)
+		assert.Equal(t, []string{"error:true", "operation:op", "service:svc"}, m.Tags)
+		assert.Equal(t, &Exemplar{TraceID: 1, SpanID: 2, Value: float64(2 * time.Second)}, m.Exemplar)
+	}
+}
@@ -6,10 +6,10 @@ import "bytes"
 // delimiter byte. It does not perform any allocations, and does not modify the
 // buffer it is given. It is not safe for use by concurrent goroutines.
 //
-//     sb := NewSplitBytes(buf, '\n')
-//     for sb.Next() {
-//         fmt.Printf("%q\n", sb.Chunk())
-//     }
+//	sb := NewSplitBytes(buf, '\n')
+//	for sb.Next() {
+//	    fmt.Printf("%q\n", sb.Chunk())
+//	}
 //
 // The sequence of chunks returned by SplitBytes is equivalent to calling
 // bytes.Split, except without allocating an intermediate slice.
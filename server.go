@@ -12,6 +12,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"os"
 	"reflect"
 	"runtime"
 	"strings"
@@ -27,6 +28,8 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/getsentry/raven-go"
+	"github.com/hashicorp/consul/api"
+	"github.com/segmentio/fasthash/fnv1a"
 	"github.com/sirupsen/logrus"
 	"github.com/zenazn/goji/bind"
 	"github.com/zenazn/goji/graceful"
@@ -34,6 +37,7 @@ import (
 
 	"github.com/pkg/profile"
 
+	"github.com/stripe/veneur/forwardrpc"
 	vhttp "github.com/stripe/veneur/http"
 	"github.com/stripe/veneur/importsrv"
 	"github.com/stripe/veneur/plugins"
@@ -47,9 +51,13 @@ import (
 	"github.com/stripe/veneur/sinks/falconer"
 	"github.com/stripe/veneur/sinks/kafka"
 	"github.com/stripe/veneur/sinks/lightstep"
+	"github.com/stripe/veneur/sinks/loki"
+	"github.com/stripe/veneur/sinks/prometheus"
+	"github.com/stripe/veneur/sinks/retry"
 	"github.com/stripe/veneur/sinks/signalfx"
 	"github.com/stripe/veneur/sinks/splunk"
 	"github.com/stripe/veneur/sinks/ssfmetrics"
+	"github.com/stripe/veneur/sinks/tempo"
 	"github.com/stripe/veneur/ssf"
 	"github.com/stripe/veneur/trace"
 	"github.com/stripe/veneur/trace/metrics"
@@ -81,6 +89,8 @@ type Server struct {
 	SpanChan             chan *ssf.SSFSpan
 	SpanWorker           *SpanWorker
 	SpanWorkerGoroutines int
+	SpanSinkQueueSize    int
+	SpanSinkQueueWorkers int
 
 	Statsd *statsd.Client
 	Sentry *raven.Client
@@ -97,16 +107,22 @@ type Server struct {
 	ForwardAddr    string
 	forwardUseGRPC bool
 
-	StatsdListenAddrs []net.Addr
-	SSFListenAddrs    []net.Addr
-	RcvbufBytes       int
+	StatsdListenAddrs   []net.Addr
+	SSFListenAddrs      []net.Addr
+	CombinedListenAddrs []net.Addr
+	RcvbufBytes         int
 
 	interval            time.Duration
 	synchronizeInterval bool
+	flushJitter         time.Duration
 	numReaders          int
 	metricMaxLength     int
 	traceMaxLengthBytes int
 
+	// metricMaxPerDatagram is how many metrics a statsd datagram
+	// may hold, or 0 if there is no limit.
+	metricMaxPerDatagram int
+
 	tlsConfig      *tls.Config
 	tcpReadTimeout time.Duration
 
@@ -125,6 +141,10 @@ type Server struct {
 	spanSinks   []sinks.SpanSink
 	metricSinks []sinks.MetricSink
 
+	// sinkMigration, if non-nil, compares the series that two
+	// metric sinks flush.
+	sinkMigration *sinkMigration
+
 	TraceClient *trace.Client
 
 	ssfInternalMetrics sync.Map
@@ -134,7 +154,141 @@ type Server struct {
 	grpcServer        *importsrv.Server
 
 	// gRPC forward clients
-	grpcForwardConn *grpc.ClientConn
+	grpcForwardConn    *grpc.ClientConn
+	grpcForwardClient  *forwardrpc.Client
+	grpcForwardOptions []forwardrpc.ClientOption
+
+	// sampler state snapshots
+	snapshotPath     string
+	snapshotInterval time.Duration
+	snapshotMtx      sync.Mutex
+
+	// CPUs that each group of readers and workers is pinned to
+	cpuGroups [][]int
+
+	// AF_XDP statsd ingestion
+	xdpInterface string
+	xdpQueues    int
+
+	// leader election for fleet-wide singleton duties
+	elector             Elector
+	leaderRetryInterval time.Duration
+	leaderDuties        []namedLeaderDuty
+	leaderMtx           sync.Mutex
+	isLeader            int32 // An atomic boolean for whether this server leads its fleet
+
+	// autoscaling signals
+	autoscalingCapacity float64
+	autoscalingMtx      sync.Mutex
+	ingestRate          float64
+	lastTally           time.Time
+	lastFlushDuration   time.Duration
+
+	// whether imported t-digests are merged with their weights, and
+	// their merge errors reported
+	weightedDigestMerging bool
+
+	// whether the workers' accounting of their samples is checked
+	// on every flush
+	accountingCheck bool
+
+	// adaptive runtime tuning
+	tuner            *tuner
+	receivedBytes    int64 // Bytes read from UDP sockets, updated atomically
+	forwardBatchSize int64 // Metrics per forwarding request, updated atomically
+
+	// metric schema enforcement
+	metricSchemas               *schemaRegistry
+	metricSchemaRefreshInterval time.Duration
+
+	// metric priority classes
+	metricPriorities *metricPriorities
+
+	// metric scope overrides
+	metricScopes *metricScopes
+
+	// runtime metric blocklist
+	metricBlocklist                *metricBlocklist
+	metricBlocklistRefreshInterval time.Duration
+
+	// flush-time merging of duplicate series, and the tag keys to
+	// exclude from every metric before merging
+	compactDuplicateMetrics bool
+	compactionExcludedTags  map[string]struct{}
+
+	// metrics computed at flush time from other metrics
+	metricExpressions []*metricExpression
+
+	// canonical ordering and formatting of flushed metrics
+	deterministicOutput bool
+
+	// datadogDistributions flushes histograms and timers as
+	// distributions, which the Datadog sink sends in place of their
+	// percentiles.
+	datadogDistributions bool
+
+	// service-level aggregates of imported per-host metrics
+	hostRollup *hostRollup
+
+	counterRates *counterRates
+
+	// forwardReporter identifies this veneur to the one it forwards to.
+	forwardReporter string
+	// reporters tracks the veneurs that forward to this one.
+	reporters *reporterTracker
+
+	// raw traffic mirroring, per listener
+	trafficMirrors []*trafficMirror
+	// passthrough of selected raw statsd metrics
+	statsdRepeater *statsdRepeater
+	// parses metrics with the configured magic tags
+	parser samplers.Parser
+
+	// drops spans that clients submitted more than once
+	spanDeduper *spanDeduper
+	// drops /import requests that forwarding veneurs retried
+	importDeduper *importDeduper
+	// bounds the /import requests that are decoded
+	importLimits importLimits
+
+	// counts and optionally drops data with skewed timestamps
+	timestampSkew *timestampSkew
+	spanLimits    *spanLimits
+	// derives a dependency graph of services from spans
+	serviceMap *serviceMap
+
+	// the order of the shutdown phases, and the timeouts of each
+	// phase and sink
+	shutdownOrder    []string
+	shutdownTimeouts map[string]time.Duration
+
+	// retries the requests of the sinks that have a retry policy,
+	// keyed by sink name
+	sinkRetriers map[string]*retry.Retrier
+
+	// injects canary metrics and spans, and measures their delivery
+	canary *canary
+
+	// injects failures into sinks, forwarding and listeners, if chaos
+	// mode is enabled
+	chaos *chaos
+
+	// measures the local clock's skew against an NTP server
+	clockCheck         *clockCheck
+	clockCheckInterval time.Duration
+
+	// runtime span blocklist
+	spanBlocklist                *spanBlocklist
+	spanBlocklistRefreshInterval time.Duration
+
+	// runtime packet capture, by listener name
+	packetCaptureEnabled    bool
+	packetCaptureMaxPackets int
+	packetCaptures          map[string]*packetCapture
+	packetCapturesMtx       sync.Mutex
+
+	// sinks paused at runtime, if pausing sinks is enabled
+	sinkPauses *sinkPauses
 }
 
 // ssfServiceSpanMetrics refer to the span metrics that will
@@ -142,7 +296,9 @@ type Server struct {
 // It is expected the values on the struct will only be handled
 // using atomic operations
 type ssfServiceSpanMetrics struct {
-	ssfSpansReceivedTotal int64
+	ssfSpansReceivedTotal  int64
+	ssfSpansDuplicateTotal int64
+	ssfSpansTruncated      [spanFields]int64
 }
 
 // SetLogger sets the default logger in veneur to the passed value.
@@ -162,6 +318,7 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 	mappedTags := samplers.ParseTagSliceToMap(ret.Tags)
 
 	ret.synchronizeInterval = conf.SynchronizeWithInterval
+	ret.parser = samplers.Parser{PreAggregatedTag: conf.PreAggregatedTag}
 
 	ret.TagsAsMap = mappedTags
 	ret.HistogramPercentiles = conf.Percentiles
@@ -196,6 +353,118 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 	ret.Statsd = stats
 
 	ret.SpanChan = make(chan *ssf.SSFSpan, conf.SpanChannelCapacity)
+	if conf.SsfDedupWindow != "" {
+		window, err := time.ParseDuration(conf.SsfDedupWindow)
+		if err != nil {
+			return ret, err
+		}
+		if window > 0 {
+			ret.spanDeduper = newSpanDeduper(window)
+		}
+	}
+	if conf.ImportDedupWindow != "" {
+		window, err := time.ParseDuration(conf.ImportDedupWindow)
+		if err != nil {
+			return ret, err
+		}
+		if window > 0 {
+			ret.importDeduper = newImportDeduper(window)
+		}
+	}
+	ret.importLimits = importLimits{
+		maxBodyBytes:         int64(conf.ImportMaxBodyBytes),
+		maxDecompressedBytes: int64(conf.ImportMaxDecompressedBytes),
+		maxMetrics:           conf.ImportMaxMetrics,
+	}
+	if conf.TimestampMaxAge != "" || conf.TimestampMaxFuture != "" {
+		var maxAge, maxFuture time.Duration
+		if conf.TimestampMaxAge != "" {
+			if maxAge, err = time.ParseDuration(conf.TimestampMaxAge); err != nil {
+				return ret, err
+			}
+		}
+		if conf.TimestampMaxFuture != "" {
+			if maxFuture, err = time.ParseDuration(conf.TimestampMaxFuture); err != nil {
+				return ret, err
+			}
+		}
+		ret.timestampSkew = newTimestampSkew(maxAge, maxFuture, conf.TimestampRejectSkewed)
+	}
+	if conf.SpanMaxTags > 0 || conf.SpanMaxTagValueLength > 0 || conf.SpanMaxNameLength > 0 {
+		ret.spanLimits = newSpanLimits(conf.SpanMaxTags, conf.SpanMaxTagValueLength, conf.SpanMaxNameLength)
+	}
+	if conf.ServiceMapEnabled {
+		ret.serviceMap = newServiceMap()
+	}
+	ret.shutdownOrder, err = parseShutdownOrder(conf.ShutdownOrder)
+	if err != nil {
+		return ret, err
+	}
+	ret.shutdownTimeouts, err = parseShutdownTimeouts(conf.ShutdownTimeouts)
+	if err != nil {
+		return ret, err
+	}
+	if conf.ClockCheckNtpServer != "" {
+		ret.clockCheck = newClockCheck(conf.ClockCheckNtpServer, conf.ClockSkewCompensation)
+		ret.clockCheckInterval, err = time.ParseDuration(conf.ClockCheckInterval)
+		if err != nil {
+			return ret, err
+		}
+	}
+	if conf.CanaryInterval != "" {
+		interval, err := time.ParseDuration(conf.CanaryInterval)
+		if err != nil {
+			return ret, err
+		}
+		if interval > 0 {
+			ret.canary = newCanary(ret.Hostname, interval, ret.interval, conf.CanaryForward)
+		}
+	}
+	if conf.ChaosEnabled {
+		chaosConf := chaosConfig{
+			sinks:            conf.ChaosSinks,
+			sinkErrorRate:    conf.ChaosSinkErrorRate,
+			sinkTimeoutRate:  conf.ChaosSinkTimeoutRate,
+			sinkDelayRate:    conf.ChaosSinkDelayRate,
+			forwardErrorRate: conf.ChaosForwardErrorRate,
+			packetDropRate:   conf.ChaosPacketDropRate,
+		}
+		if conf.ChaosSinkDelay != "" {
+			chaosConf.sinkDelay, err = time.ParseDuration(conf.ChaosSinkDelay)
+			if err != nil {
+				return ret, err
+			}
+		}
+		ret.chaos, err = newChaos(chaosConf)
+		if err != nil {
+			return ret, err
+		}
+		log.WithFields(logrus.Fields{
+			"sinks":            conf.ChaosSinks,
+			"sinkErrorRate":    conf.ChaosSinkErrorRate,
+			"sinkTimeoutRate":  conf.ChaosSinkTimeoutRate,
+			"sinkDelayRate":    conf.ChaosSinkDelayRate,
+			"sinkDelay":        chaosConf.sinkDelay,
+			"forwardErrorRate": conf.ChaosForwardErrorRate,
+			"packetDropRate":   conf.ChaosPacketDropRate,
+		}).Warn("Chaos mode is enabled, injecting failures")
+		if unsupported := ret.chaos.unsupportedSinks(); len(unsupported) > 0 {
+			log.WithField("sinks", unsupported).
+				Warn("Chaos mode can only inject faults into the Datadog, SignalFx, Prometheus, Loki and Tempo sinks, ignoring the others")
+		}
+	}
+	if conf.SpanBlocklistEnabled {
+		ret.spanBlocklist = newSpanBlocklist(conf.SpanBlocklistFile)
+		if conf.SpanBlocklistFile != "" {
+			if _, err := ret.spanBlocklist.reload(); err != nil {
+				return ret, fmt.Errorf("could not load span blocklist from %s: %v", conf.SpanBlocklistFile, err)
+			}
+			ret.spanBlocklistRefreshInterval, err = time.ParseDuration(conf.SpanBlocklistRefreshInterval)
+			if err != nil {
+				return ret, err
+			}
+		}
+	}
 	ret.TraceClient, err = trace.NewChannelClient(ret.SpanChan,
 		trace.ReportStatistics(stats, 1*time.Second, []string{"ssf_format:internal"}),
 	)
@@ -260,21 +529,137 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 	if conf.NumWorkers > 1 {
 		numWorkers = conf.NumWorkers
 	}
-	logger.WithField("number", numWorkers).Info("Preparing workers")
+	for _, list := range conf.CPUAffinityGroups {
+		cpus, err := parseCPUList(list)
+		if err != nil {
+			return ret, err
+		}
+		ret.cpuGroups = append(ret.cpuGroups, cpus)
+	}
+	if len(ret.cpuGroups) > 0 {
+		logger.WithField("groups", conf.CPUAffinityGroups).
+			Info("Pinning workers and readers to CPU groups")
+	}
+	logger.WithField("number", numWorkers*ret.cpuGroupCount()).Info("Preparing workers")
 	// Allocate the slice, we'll fill it with workers later.
-	ret.Workers = make([]*Worker, numWorkers)
+	ret.Workers = make([]*Worker, numWorkers*ret.cpuGroupCount())
 	ret.numReaders = conf.NumReaders
 
+	if conf.MetricSchemaSource != "" {
+		ret.metricSchemas, err = newSchemaRegistry(conf.MetricSchemaSource, conf.MetricSchemaMode, ret.HTTPClient)
+		if err != nil {
+			return ret, err
+		}
+		if _, err := ret.metricSchemas.reload(); err != nil {
+			return ret, fmt.Errorf("could not load metric schemas from %s: %v", conf.MetricSchemaSource, err)
+		}
+		ret.metricSchemaRefreshInterval, err = time.ParseDuration(conf.MetricSchemaRefreshInterval)
+		if err != nil {
+			return ret, err
+		}
+	}
+
+	if len(conf.MetricPriorities) > 0 || conf.MetricPriorityShedThreshold > 0 {
+		ret.metricPriorities, err = newMetricPriorities(conf.MetricPriorityShedThreshold)
+		if err != nil {
+			return ret, err
+		}
+		for _, rule := range conf.MetricPriorities {
+			if err := ret.metricPriorities.addRule(rule.NamePrefix, rule.Tag, rule.Priority); err != nil {
+				return ret, err
+			}
+		}
+	}
+
+	if len(conf.MetricScopes) > 0 {
+		ret.metricScopes = &metricScopes{}
+		for _, rule := range conf.MetricScopes {
+			if err := ret.metricScopes.addRule(rule.NamePrefix, rule.Tag, rule.Type, rule.Scope); err != nil {
+				return ret, err
+			}
+		}
+	}
+
+	if conf.MetricBlocklistEnabled {
+		ret.metricBlocklist = newMetricBlocklist(conf.MetricBlocklistFile)
+		if conf.MetricBlocklistFile != "" {
+			if _, err := ret.metricBlocklist.reload(); err != nil {
+				return ret, fmt.Errorf("could not load metric blocklist from %s: %v", conf.MetricBlocklistFile, err)
+			}
+			ret.metricBlocklistRefreshInterval, err = time.ParseDuration(conf.MetricBlocklistRefreshInterval)
+			if err != nil {
+				return ret, err
+			}
+		}
+	}
+
+	exprNames := map[string]bool{}
+	for _, e := range conf.MetricExpressions {
+		expr, err := parseMetricExpression(e.Name, e.Expression)
+		if err != nil {
+			return ret, err
+		}
+		if exprNames[expr.name] {
+			return ret, fmt.Errorf("metric_expressions: %s is defined more than once", expr.name)
+		}
+		exprNames[expr.name] = true
+		ret.metricExpressions = append(ret.metricExpressions, expr)
+	}
+
+	if len(conf.HostRollupMetricPrefixes) > 0 {
+		ret.hostRollup = newHostRollup(conf.HostRollupTag, conf.HostRollupMetricPrefixes)
+	}
+	if len(conf.CounterRateMetricPrefixes) > 0 {
+		ret.counterRates = newCounterRates(conf.CounterRateMetricPrefixes)
+	}
+
+	ret.forwardReporter = conf.ForwardReporterID
+	if ret.forwardReporter == "" {
+		ret.forwardReporter = ret.Hostname
+	}
+	reporterExpiry := 10 * ret.interval
+	if conf.ImportReporterExpiry != "" {
+		reporterExpiry, err = time.ParseDuration(conf.ImportReporterExpiry)
+		if err != nil {
+			return ret, err
+		}
+	}
+	ret.reporters = newReporterTracker(reporterExpiry)
+
+	if conf.FlushJitter < 0 || conf.FlushJitter >= 1 {
+		return ret, fmt.Errorf("flush_jitter must be at least 0 and less than 1, got %v", conf.FlushJitter)
+	}
+	ret.flushJitter = calculateFlushJitter(ret.interval, conf.FlushJitter, ret.forwardReporter)
+
 	// Use the pre-allocated Workers slice to know how many to start.
 	for i := range ret.Workers {
 		ret.Workers[i] = NewWorker(i+1, ret.TraceClient, log, ret.Statsd)
+		ret.Workers[i].setHistogramCompression(conf.HistogramCompression)
+		ret.Workers[i].setWeightedDigestMerging(conf.WeightedDigestMerging)
+		ret.Workers[i].setCounterSummaries(conf.CounterSampleSummaries)
+		if ret.metricSchemas != nil {
+			ret.Workers[i].setSchemaRegistry(ret.metricSchemas)
+		}
+		if ret.metricBlocklist != nil {
+			ret.Workers[i].setMetricBlocklist(ret.metricBlocklist)
+		}
+		if ret.metricPriorities != nil {
+			ret.Workers[i].setMetricPriorities(ret.metricPriorities)
+		}
+		if ret.metricScopes != nil {
+			ret.Workers[i].setMetricScopes(ret.metricScopes)
+		}
+		if conf.AccountingCheckEnabled {
+			ret.Workers[i].setAccounting()
+		}
 		// do not close over loop index
-		go func(w *Worker) {
+		go func(w *Worker, group int) {
 			defer func() {
 				ConsumePanic(ret.Sentry, ret.TraceClient, ret.Hostname, recover())
 			}()
+			ret.pinToCPUGroup(group)
 			w.Work()
-		}(ret.Workers[i])
+		}(ret.Workers[i], i/numWorkers)
 	}
 
 	ret.EventWorker = NewEventWorker(ret.TraceClient, ret.Statsd)
@@ -285,7 +670,15 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 	for i, w := range ret.Workers {
 		processors[i] = w
 	}
-	metricSink, err := ssfmetrics.NewMetricExtractionSink(processors, conf.IndicatorSpanTimerName, ret.TraceClient, log)
+	apdexThresholds := make([]ssfmetrics.ApdexThreshold, len(conf.ApdexThresholds))
+	for i, t := range conf.ApdexThresholds {
+		threshold, err := time.ParseDuration(t.Threshold)
+		if err != nil {
+			return ret, fmt.Errorf("apdex_thresholds: %v", err)
+		}
+		apdexThresholds[i] = ssfmetrics.ApdexThreshold{Service: t.Service, Operation: t.Operation, Threshold: threshold}
+	}
+	metricSink, err := ssfmetrics.NewMetricExtractionSink(processors, ret.parser, conf.IndicatorSpanTimerName, conf.SpanDurationTimerName, conf.SpanDurationServices, conf.ApdexMetricName, apdexThresholds, ret.TraceClient, log)
 	if err != nil {
 		return ret, err
 	}
@@ -305,8 +698,101 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 		}
 		ret.SSFListenAddrs = append(ret.SSFListenAddrs, addr)
 	}
+	for _, addrStr := range conf.CombinedListenAddresses {
+		addr, err := protocol.ResolveAddr(addrStr)
+		if err != nil {
+			return ret, err
+		}
+		if addr.Network() != "udp" && addr.Network() != "unixgram" {
+			return ret, fmt.Errorf("combined_listen_addresses: can't listen on %q, only udp:// and unixgram:// addresses are supported", addrStr)
+		}
+		ret.CombinedListenAddrs = append(ret.CombinedListenAddrs, addr)
+	}
+	ret.trafficMirrors, err = newTrafficMirrors(conf.TrafficMirrors,
+		append(append(append([]string{}, conf.StatsdListenAddresses...), conf.SsfListenAddresses...), conf.CombinedListenAddresses...))
+	if err != nil {
+		return ret, err
+	}
+	if conf.StatsdRepeaterAddress != "" {
+		ret.statsdRepeater, err = newStatsdRepeater(conf.StatsdRepeaterAddress, conf.StatsdRepeaterMetrics, conf.StatsdRepeaterMaxDatagramSize)
+		if err != nil {
+			return ret, err
+		}
+	}
+
+	if conf.SamplerSnapshotPath != "" {
+		ret.snapshotPath = conf.SamplerSnapshotPath
+		ret.snapshotInterval, err = time.ParseDuration(conf.SamplerSnapshotInterval)
+		if err != nil {
+			return ret, err
+		}
+		if ret.snapshotInterval <= 0 {
+			return ret, fmt.Errorf("sampler_snapshot_interval must be positive, got %v", ret.snapshotInterval)
+		}
+	}
+
+	if conf.LeaderElectionBackend != "" {
+		leaseDuration, err := time.ParseDuration(conf.LeaderElectionLeaseDuration)
+		if err != nil {
+			return ret, err
+		}
+		identity := conf.Hostname
+		if identity == "" {
+			identity, _ = os.Hostname()
+		}
+		switch conf.LeaderElectionBackend {
+		case "consul":
+			ret.elector, err = NewConsulElector(api.DefaultConfig(), conf.LeaderElectionKey, identity, leaseDuration)
+		case "kubernetes":
+			ret.elector, err = NewKubernetesElector(conf.LeaderElectionKey, identity, leaseDuration)
+		default:
+			err = fmt.Errorf("unknown leader_election_backend %q", conf.LeaderElectionBackend)
+		}
+		if err != nil {
+			return ret, err
+		}
+		ret.leaderRetryInterval = leaseDuration
+		ret.RegisterLeaderDuty("report_leadership", ret.reportLeadership)
+		log.WithFields(logrus.Fields{
+			"backend":  conf.LeaderElectionBackend,
+			"key":      conf.LeaderElectionKey,
+			"identity": identity,
+		}).Info("Using leader election for fleet-wide duties")
+	}
+
+	ret.autoscalingCapacity = float64(conf.AutoscalingCapacityPerSecond)
+
+	ret.weightedDigestMerging = conf.WeightedDigestMerging
+	ret.accountingCheck = conf.AccountingCheckEnabled
+
+	ret.deterministicOutput = conf.DeterministicOutput
+
+	ret.compactDuplicateMetrics = conf.CompactDuplicateMetrics
+	if ret.compactDuplicateMetrics {
+		// only the rules that don't name a sink apply to every sink:
+		ret.compactionExcludedTags = map[string]struct{}{}
+		for _, rule := range conf.TagsExclude {
+			if !strings.Contains(rule, "|") {
+				ret.compactionExcludedTags[rule] = struct{}{}
+			}
+		}
+	}
+
+	ret.packetCaptureEnabled = conf.PacketCaptureEnabled
+	ret.packetCaptureMaxPackets = conf.PacketCaptureMaxPackets
+
+	if conf.TuningEnabled {
+		ret.tuner = newTuner(conf)
+	}
+
+	ret.xdpInterface = conf.StatsdXdpInterface
+	ret.xdpQueues = conf.StatsdXdpQueues
+	if ret.xdpQueues < 1 {
+		ret.xdpQueues = 1
+	}
 
 	ret.metricMaxLength = conf.MetricMaxLength
+	ret.metricMaxPerDatagram = conf.MetricMaxPerDatagram
 	ret.traceMaxLengthBytes = conf.TraceMaxLengthBytes
 	ret.RcvbufBytes = conf.ReadBufferSizeBytes
 	ret.HTTPAddr = conf.HTTPAddress
@@ -349,34 +835,133 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 		}
 	}
 
+	ret.sinkRetriers = map[string]*retry.Retrier{}
+	for sink, policyConf := range conf.SinkRetryPolicies {
+		policy, err := retry.ParsePolicy(policyConf)
+		if err != nil {
+			return ret, fmt.Errorf("invalid retry policy for sink %s: %v", sink, err)
+		}
+		ret.sinkRetriers[sink] = retry.New(sink, policy, ret.TraceClient, log)
+	}
+
 	if conf.SignalfxAPIKey != "" {
-		tracedHTTP := *ret.HTTPClient
+		tracedHTTP := *ret.sinkHTTPClient("signalfx")
 		tracedHTTP.Transport = vhttp.NewTraceRoundTripper(tracedHTTP.Transport, ret.TraceClient, "signalfx")
 
 		fallback := signalfx.NewClient(conf.SignalfxEndpointBase, conf.SignalfxAPIKey, &tracedHTTP)
+		if conf.SignalfxAPIKeySecondary != "" {
+			fallback = signalfx.NewFailoverClient(conf.SignalfxEndpointBase, conf.SignalfxAPIKey, conf.SignalfxAPIKeySecondary, &tracedHTTP)
+		}
 		byTagClients := map[string]signalfx.DPClient{}
+		byTagAPIKeys := map[string]string{}
 		for _, perTag := range conf.SignalfxPerTagAPIKeys {
 			byTagClients[perTag.Name] = signalfx.NewClient(conf.SignalfxEndpointBase, perTag.APIKey, &tracedHTTP)
+			byTagAPIKeys[perTag.Name] = perTag.APIKey
 		}
 		sfxSink, err := signalfx.NewSignalFxSink(conf.SignalfxHostnameTag, conf.Hostname, ret.TagsAsMap, log, fallback, conf.SignalfxVaryKeyBy, byTagClients, conf.SignalfxMetricNamePrefixDrops, conf.SignalfxMetricTagPrefixDrops, metricSink)
 		if err != nil {
 			return ret, err
 		}
+		sfxSink.SetUnitDimension(conf.SignalfxUnitDimension)
+		dimensionRules := make([]signalfx.DimensionRule, 0, len(conf.SignalfxDimensionRules))
+		for _, rule := range conf.SignalfxDimensionRules {
+			dimensionRules = append(dimensionRules, signalfx.DimensionRule{
+				Dimension:                rule.Dimension,
+				ValuePrefix:              rule.ValuePrefix,
+				Properties:               rule.Properties,
+				PropertiesFromDimensions: rule.PropertiesFromDimensions,
+				Tags:                     rule.Tags,
+			})
+		}
+		err = sfxSink.SetDimensionRules(conf.SignalfxAPIEndpoint, conf.SignalfxAPIKey, byTagAPIKeys, &tracedHTTP, dimensionRules)
+		if err != nil {
+			return ret, err
+		}
 		ret.metricSinks = append(ret.metricSinks, sfxSink)
 	}
+	if conf.DatadogSite != "" {
+		siteHostname, err := datadog.SiteAPIHostname(conf.DatadogSite)
+		if err != nil {
+			return ret, err
+		}
+		if conf.DatadogAPIHostname == "" {
+			conf.DatadogAPIHostname = siteHostname
+		} else if err := datadog.CheckSite(conf.DatadogAPIHostname, conf.DatadogSite); err != nil {
+			return ret, fmt.Errorf("invalid datadog_api_hostname: %v", err)
+		}
+	}
 	if conf.DatadogAPIKey != "" && conf.DatadogAPIHostname != "" {
 		ddSink, err := datadog.NewDatadogMetricSink(
 			ret.interval.Seconds(), conf.DatadogFlushMaxPerBody, conf.Hostname, ret.Tags,
-			conf.DatadogAPIHostname, conf.DatadogAPIKey, ret.HTTPClient, log,
+			conf.DatadogAPIHostname, conf.DatadogAPIKey, ret.sinkHTTPClient("datadog"), log,
 		)
 		if err != nil {
 			return ret, err
 		}
+		ddSink.ApplicationKey = conf.DatadogApplicationKey
+		ddSink.SecondaryAPIKey = conf.DatadogAPIKeySecondary
+		ddSink.ValidateAPIKey = conf.DatadogSite != ""
+		ddSink.Distributions = conf.DatadogDistributions
+		ret.datadogDistributions = conf.DatadogDistributions
 		ret.metricSinks = append(ret.metricSinks, ddSink)
 	}
+	if conf.PrometheusRemoteWriteAddress != "" {
+		promSink, err := prometheus.NewRemoteWriteSink(
+			conf.PrometheusRemoteWriteAddress, conf.PrometheusRemoteWriteTenant,
+			conf.PrometheusRemoteWriteTenantTag, conf.PrometheusRemoteWriteHostnameLabel,
+			conf.Hostname, conf.PrometheusRemoteWriteBatchSize, ret.sinkHTTPClient("prometheus"), log,
+		)
+		if err != nil {
+			return ret, err
+		}
+		ret.metricSinks = append(ret.metricSinks, promSink)
+	}
+	if conf.LokiAddress != "" {
+		lokiSink, err := loki.NewLokiEventSink(
+			conf.LokiAddress, conf.LokiTenant, conf.LokiLabelTags,
+			conf.LokiBatchSize, ret.sinkHTTPClient("loki"), log,
+		)
+		if err != nil {
+			return ret, err
+		}
+		ret.metricSinks = append(ret.metricSinks, lokiSink)
+	}
+	if conf.SplunkHecMetricsEnabled {
+		splunkAddresses := splunkHecAddresses(conf)
+		if len(splunkAddresses) == 0 || (conf.SplunkHecToken == "" && conf.SplunkHecTokenFile == "") {
+			return ret, fmt.Errorf("splunk_hec_metrics_enabled needs splunk_hec_address (or splunk_hec_addresses) and splunk_hec_token (or splunk_hec_token_file) to be set")
+		}
+		var sendTimeout, tokenRefresh time.Duration
+		if conf.SplunkHecSendTimeout != "" {
+			sendTimeout, err = time.ParseDuration(conf.SplunkHecSendTimeout)
+			if err != nil {
+				return ret, err
+			}
+		}
+		if conf.SplunkHecTokenFile != "" {
+			tokenRefresh, err = time.ParseDuration(conf.SplunkHecTokenFileRefreshInterval)
+			if err != nil {
+				return ret, err
+			}
+		}
+		tlsConfig, err := splunk.NewTLSConfig(conf.SplunkHecTLSAuthorityCertificate, conf.SplunkHecTLSCertificate, conf.SplunkHecTLSKey, conf.SplunkHecTLSMinVersion)
+		if err != nil {
+			return ret, err
+		}
+		splunkSink, err := splunk.NewSplunkMetricSink(
+			splunkAddresses, conf.SplunkHecToken, conf.SplunkHecTokenSecondary,
+			conf.SplunkHecTokenFile, tokenRefresh, conf.Hostname,
+			conf.SplunkHecTLSValidateHostname, sendTimeout, tlsConfig, conf.SplunkHecGzip,
+			conf.SplunkHecMetricsIndex, conf.SplunkHecMetricsBatchSize, conf.SplunkHecMaxBatchBytes, ret.sinkRetriers["splunk"], log,
+		)
+		if err != nil {
+			return ret, err
+		}
+		ret.metricSinks = append(ret.metricSinks, splunkSink)
+	}
 
 	// Configure tracing sinks
-	if len(conf.SsfListenAddresses) > 0 {
+	if len(conf.SsfListenAddresses) > 0 || len(conf.CombinedListenAddresses) > 0 {
 
 		trace.Enable()
 
@@ -384,7 +969,7 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 		if conf.DatadogAPIKey != "" && conf.DatadogTraceAPIAddress != "" {
 			ddSink, err := datadog.NewDatadogSpanSink(
 				conf.DatadogTraceAPIAddress, conf.DatadogSpanBufferSize,
-				ret.HTTPClient, log,
+				ret.sinkHTTPClient("datadog"), log,
 			)
 			if err != nil {
 				return ret, err
@@ -411,12 +996,14 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 			logger.Info("Configured Lightstep trace sink")
 		}
 
-		if (conf.SplunkHecToken != "" && conf.SplunkHecAddress == "") ||
-			(conf.SplunkHecToken == "" && conf.SplunkHecAddress != "") {
-			return ret, fmt.Errorf("both splunk_hec_address and splunk_hec_token need to be set!")
+		hasSplunkToken := conf.SplunkHecToken != "" || conf.SplunkHecTokenFile != ""
+		splunkAddresses := splunkHecAddresses(conf)
+		if (hasSplunkToken && len(splunkAddresses) == 0) ||
+			(!hasSplunkToken && len(splunkAddresses) != 0) {
+			return ret, fmt.Errorf("both splunk_hec_address (or splunk_hec_addresses) and splunk_hec_token (or splunk_hec_token_file) need to be set!")
 		}
-		if conf.SplunkHecToken != "" && conf.SplunkHecAddress != "" {
-			var sendTimeout, ingestTimeout, connLifetime, connJitter time.Duration
+		if hasSplunkToken && len(splunkAddresses) != 0 {
+			var sendTimeout, ingestTimeout, connLifetime, connJitter, batchAge, ackTimeout, tokenRefresh, keepalive time.Duration
 			if conf.SplunkHecSendTimeout != "" {
 				sendTimeout, err = time.ParseDuration(conf.SplunkHecSendTimeout)
 				if err != nil {
@@ -441,8 +1028,89 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 					return ret, err
 				}
 			}
+			if conf.SplunkHecMaxBatchAge != "" {
+				batchAge, err = time.ParseDuration(conf.SplunkHecMaxBatchAge)
+				if err != nil {
+					return ret, err
+				}
+			}
+			if conf.SplunkHecAckTimeout != "" {
+				ackTimeout, err = time.ParseDuration(conf.SplunkHecAckTimeout)
+				if err != nil {
+					return ret, err
+				}
+			}
+			if conf.SplunkHecTokenFile != "" {
+				tokenRefresh, err = time.ParseDuration(conf.SplunkHecTokenFileRefreshInterval)
+				if err != nil {
+					return ret, err
+				}
+			}
+			if conf.SplunkHecKeepaliveInterval != "" {
+				keepalive, err = time.ParseDuration(conf.SplunkHecKeepaliveInterval)
+				if err != nil {
+					return ret, err
+				}
+			}
+			var scaleInterval time.Duration
+			if conf.SplunkHecSubmissionWorkersScaleInterval != "" {
+				scaleInterval, err = time.ParseDuration(conf.SplunkHecSubmissionWorkersScaleInterval)
+				if err != nil {
+					return ret, err
+				}
+			}
 
-			sss, err := splunk.NewSplunkSpanSink(conf.SplunkHecAddress, conf.SplunkHecToken, conf.Hostname, conf.SplunkHecTLSValidateHostname, log, ingestTimeout, sendTimeout, conf.SplunkHecBatchSize, conf.SplunkHecSubmissionWorkers, conf.SplunkSpanSampleRate, connLifetime, connJitter)
+			tlsConfig, err := splunk.NewTLSConfig(conf.SplunkHecTLSAuthorityCertificate, conf.SplunkHecTLSCertificate, conf.SplunkHecTLSKey, conf.SplunkHecTLSMinVersion)
+			if err != nil {
+				return ret, err
+			}
+			sampleRateOverrides := make([]splunk.SampleRateOverride, len(conf.SplunkSpanSampleRateOverrides))
+			for i, o := range conf.SplunkSpanSampleRateOverrides {
+				sampleRateOverrides[i] = splunk.SampleRateOverride{Service: o.Service, Name: o.Name, SampleRate: o.SampleRate}
+			}
+
+			sss, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+				Servers:              splunkAddresses,
+				Token:                conf.SplunkHecToken,
+				Hostname:             conf.Hostname,
+				ValidateServerName:   conf.SplunkHecTLSValidateHostname,
+				Log:                  log,
+				IngestTimeout:        ingestTimeout,
+				SendTimeout:          sendTimeout,
+				BatchSize:            conf.SplunkHecBatchSize,
+				Workers:              conf.SplunkHecSubmissionWorkers,
+				SpanSampleRate:       conf.SplunkSpanSampleRate,
+				MaxConnLifetime:      connLifetime,
+				ConnLifetimeJitter:   connJitter,
+				MaxBatchAge:          batchAge,
+				HealthCheck:          conf.SplunkHecHealthCheck,
+				SecondaryToken:       conf.SplunkHecTokenSecondary,
+				AckTimeout:           ackTimeout,
+				Gzip:                 conf.SplunkHecGzip,
+				Retrier:              ret.sinkRetriers["splunk"],
+				RetryBufferBytes:     conf.SplunkHecRetryBufferBytes,
+				IndexTag:             conf.SplunkHecIndexTag,
+				Indexes:              conf.SplunkHecIndexes,
+				AlwaysKeep:           conf.SplunkSpanSampleAlwaysKeep,
+				TokenFile:            conf.SplunkHecTokenFile,
+				TokenRefreshInterval: tokenRefresh,
+				TLSConfig:            tlsConfig,
+				TagAllowlist:         conf.SplunkSpanTagAllowlist,
+				TagDenylist:          conf.SplunkSpanTagDenylist,
+				MaxBatchBytes:        conf.SplunkHecMaxBatchBytes,
+				RawEndpoint:          conf.SplunkHecRaw,
+				RawSourceType:        conf.SplunkHecRawSourcetype,
+				SourceTemplate:       conf.SplunkSpanSourceTemplate,
+				SourceTypeTemplate:   conf.SplunkSpanSourcetypeTemplate,
+				SpillDir:             conf.SplunkHecSpillDir,
+				SpillMaxBytes:        conf.SplunkHecSpillMaxBytes,
+				KeepaliveInterval:    keepalive,
+				SampleDecisionTag:    conf.SplunkSpanSampleDecisionTag,
+				HealthCheckWarnOnly:  conf.SplunkHecHealthCheckWarnOnly,
+				SampleRateOverrides:  sampleRateOverrides,
+				MaxWorkers:           conf.SplunkHecMaxSubmissionWorkers,
+				WorkerScaleInterval:  scaleInterval,
+			})
 			if err != nil {
 				return ret, err
 			}
@@ -451,7 +1119,11 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 		}
 
 		if conf.FalconerAddress != "" {
-			falsink, err := falconer.NewSpanSink(context.Background(), conf.FalconerAddress, log, grpc.WithInsecure())
+			opts := []grpc.DialOption{grpc.WithInsecure()}
+			if retrier, ok := ret.sinkRetriers["falconer"]; ok {
+				opts = append(opts, grpc.WithUnaryInterceptor(retrier.UnaryClientInterceptor()))
+			}
+			falsink, err := falconer.NewSpanSink(context.Background(), conf.FalconerAddress, log, opts...)
 			if err != nil {
 				return ret, err
 			}
@@ -460,11 +1132,48 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 			logger.Info("Configured Falconer trace sink")
 		}
 
+		if conf.LokiAddress != "" {
+			lokiSink, err := loki.NewLokiSpanSink(
+				conf.LokiAddress, conf.LokiTenant, conf.LokiLabelTags,
+				conf.LokiBatchSize, conf.LokiSpanBufferSize, ret.sinkHTTPClient("loki"), log,
+			)
+			if err != nil {
+				return ret, err
+			}
+
+			ret.spanSinks = append(ret.spanSinks, lokiSink)
+			logger.Info("Configured Loki trace sink")
+		}
+
+		if conf.TempoAddress != "" {
+			serviceTenants := map[string]string{}
+			for _, perService := range conf.TempoPerServiceTenants {
+				serviceTenants[perService.Service] = perService.Tenant
+			}
+			tempoSink, err := tempo.NewTempoSpanSink(
+				conf.TempoAddress, conf.TempoTenant, serviceTenants,
+				conf.TempoSpanBufferSize, ret.sinkHTTPClient("tempo"), log,
+			)
+			if err != nil {
+				return ret, err
+			}
+
+			ret.spanSinks = append(ret.spanSinks, tempoSink)
+			logger.Info("Configured Tempo trace sink")
+		}
+
 		// Set up as many span workers as we need:
 		ret.SpanWorkerGoroutines = 1
 		if conf.NumSpanWorkers > 0 {
 			ret.SpanWorkerGoroutines = conf.NumSpanWorkers
 		}
+
+		// and as many goroutines per span sink queue:
+		ret.SpanSinkQueueSize = conf.SpanSinkQueueSize
+		ret.SpanSinkQueueWorkers = 1
+		if conf.SpanSinkQueueWorkers > 0 {
+			ret.SpanSinkQueueWorkers = conf.SpanSinkQueueWorkers
+		}
 	}
 
 	if conf.KafkaBroker != "" {
@@ -474,7 +1183,7 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 				conf.KafkaMetricTopic, conf.KafkaMetricRequireAcks,
 				conf.KafkaPartitioner, conf.KafkaRetryMax,
 				conf.KafkaMetricBufferBytes, conf.KafkaMetricBufferMessages,
-				conf.KafkaMetricBufferFrequency,
+				conf.KafkaMetricBufferFrequency, conf.KafkaIdempotent,
 			)
 			if err != nil {
 				return ret, err
@@ -493,6 +1202,7 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 				conf.KafkaSpanBufferBytes, conf.KafkaSpanBufferMesages,
 				conf.KafkaSpanBufferFrequency, conf.KafkaSpanSerializationFormat,
 				conf.KafkaSpanSampleTag, conf.KafkaSpanSampleRatePercent,
+				conf.KafkaIdempotent,
 			)
 			if err != nil {
 				return ret, err
@@ -518,6 +1228,27 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 	// After all sinks are initialized, set the list of tags to exclude
 	setSinkExcludedTags(conf.TagsExclude, ret.metricSinks)
 
+	if conf.SinkMigrationFrom != "" || conf.SinkMigrationTo != "" {
+		ret.sinkMigration, err = newSinkMigration(conf.SinkMigrationFrom, conf.SinkMigrationTo, conf.SinkMigrationTolerance, ret.metricSinks)
+		if err != nil {
+			return ret, err
+		}
+	}
+
+	if conf.SinkPauseEnabled {
+		var names []string
+		for _, sink := range ret.metricSinks {
+			names = append(names, sink.Name())
+		}
+		for _, sink := range ret.spanSinks {
+			names = append(names, sink.Name())
+		}
+		ret.sinkPauses, err = newSinkPauses(conf.SinkPausePolicy, conf.SinkPauseBufferSize, names)
+		if err != nil {
+			return ret, err
+		}
+	}
+
 	var svc s3iface.S3API
 	awsID := conf.AwsAccessKeyID
 	awsSecret := conf.AwsSecretAccessKey
@@ -534,12 +1265,19 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 			} else {
 				logger.Info("Successfully created AWS session")
 				svc = s3.New(sess)
-				plugin := &s3p.S3Plugin{
+				var plugin plugins.Plugin = &s3p.S3Plugin{
 					Logger:   log,
 					Svc:      svc,
 					S3Bucket: conf.AwsS3Bucket,
 					Hostname: ret.Hostname,
 				}
+				if conf.AwsS3RollupInterval != "" {
+					resolution, err := time.ParseDuration(conf.AwsS3RollupInterval)
+					if err != nil {
+						return ret, err
+					}
+					plugin = newRollupPlugin(plugin, resolution)
+				}
 				ret.registerPlugin(plugin)
 			}
 		} else {
@@ -556,10 +1294,17 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 	}
 
 	if conf.FlushFile != "" {
-		localFilePlugin := &localfilep.Plugin{
+		var localFilePlugin plugins.Plugin = &localfilep.Plugin{
 			FilePath: conf.FlushFile,
 			Logger:   log,
 		}
+		if conf.FlushFileRollupInterval != "" {
+			resolution, err := time.ParseDuration(conf.FlushFileRollupInterval)
+			if err != nil {
+				return ret, err
+			}
+			localFilePlugin = newRollupPlugin(localFilePlugin, resolution)
+		}
 		ret.registerPlugin(localFilePlugin)
 		logger.Info(fmt.Sprintf("Local file logging to %s", conf.FlushFile))
 	}
@@ -571,12 +1316,25 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 	conf.SentryDsn = REDACTED
 	conf.TLSKey = REDACTED
 	conf.DatadogAPIKey = REDACTED
+	conf.DatadogAPIKeySecondary = REDACTED
+	conf.DatadogApplicationKey = REDACTED
 	conf.SignalfxAPIKey = REDACTED
+	conf.SignalfxAPIKeySecondary = REDACTED
+	conf.SplunkHecTokenSecondary = REDACTED
+	conf.SplunkHecTLSKey = REDACTED
 	conf.LightstepAccessToken = REDACTED
 	conf.AwsAccessKeyID = REDACTED
 	conf.AwsSecretAccessKey = REDACTED
 
 	ret.forwardUseGRPC = conf.ForwardUseGrpc
+	if err := forwardrpc.ValidCompression(conf.ForwardGrpcCompression); err != nil {
+		return ret, err
+	}
+	ret.grpcForwardOptions = []forwardrpc.ClientOption{
+		forwardrpc.WithCompression(conf.ForwardGrpcCompression),
+		forwardrpc.WithMaxSendMsgSize(conf.ForwardGrpcMaxSendMsgSize),
+		forwardrpc.WithReporter(ret.forwardReporter),
+	}
 
 	// Setup the grpc server if it was configured
 	ret.grpcListenAddress = conf.GrpcAddress
@@ -587,8 +1345,15 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 			ingesters[i] = worker
 		}
 
-		ret.grpcServer = importsrv.New(ingesters,
-			importsrv.WithTraceClient(ret.TraceClient))
+		opts := []importsrv.Option{
+			importsrv.WithTraceClient(ret.TraceClient),
+			importsrv.WithMaxRecvMsgSize(conf.GrpcMaxRecvMsgSize),
+			importsrv.WithReporterSeen(ret.reporters.seen),
+		}
+		if ret.hostRollup != nil {
+			opts = append(opts, importsrv.WithExtraMetric(ret.hostRollup.rollUpGRPC))
+		}
+		ret.grpcServer = importsrv.New(ingesters, opts...)
 	}
 
 	logger.WithField("config", conf).Debug("Initialized server")
@@ -596,6 +1361,17 @@ func NewFromConfig(logger *logrus.Logger, conf Config) (*Server, error) {
 	return ret, err
 }
 
+// sinkHTTPClient returns the HTTP client for the named sink, which
+// reports the sink's responses, and retries its requests if the sink
+// has a retry policy.
+func (s *Server) sinkHTTPClient(sink string) *http.Client {
+	client := vhttp.WithResponseMetrics(s.chaos.client(s.HTTPClient, sink), s.TraceClient, sink)
+	if retrier, ok := s.sinkRetriers[sink]; ok {
+		return retrier.Client(client)
+	}
+	return client
+}
+
 // Start spins up the Server to do actual work, firing off goroutines for
 // various workers and utilities.
 func (s *Server) Start() {
@@ -605,6 +1381,10 @@ func (s *Server) Start() {
 
 	// Use the pre-allocated Workers slice to know how many to start.
 	s.SpanWorker = NewSpanWorker(s.spanSinks, s.TraceClient, s.Statsd, s.SpanChan, s.TagsAsMap)
+	if s.sinkPauses != nil {
+		s.SpanWorker.setSinkPauses(s.sinkPauses)
+	}
+	s.SpanWorker.canary = s.canary
 
 	go func() {
 		log.Info("Starting Event worker")
@@ -614,6 +1394,47 @@ func (s *Server) Start() {
 		s.EventWorker.Work()
 	}()
 
+	for _, tm := range s.trafficMirrors {
+		log.WithFields(logrus.Fields{
+			"listener":    tm.listener,
+			"destination": listenerName(tm.destination),
+		}).Info("Mirroring listener traffic")
+		go func(tm *trafficMirror) {
+			defer func() {
+				ConsumePanic(s.Sentry, s.TraceClient, s.Hostname, recover())
+			}()
+			tm.run(s.shutdown)
+		}(tm)
+	}
+
+	if s.statsdRepeater != nil {
+		log.WithFields(s.statsdRepeater.logFields()).Info("Repeating raw statsd metrics")
+		go func() {
+			defer func() {
+				ConsumePanic(s.Sentry, s.TraceClient, s.Hostname, recover())
+			}()
+			s.statsdRepeater.run(s.shutdown)
+		}()
+	}
+
+	if s.SpanSinkQueueSize > 0 {
+		s.SpanWorker.setSinkQueues(s.SpanSinkQueueSize)
+		log.WithFields(logrus.Fields{
+			"size":    s.SpanSinkQueueSize,
+			"workers": s.SpanSinkQueueWorkers,
+		}).Info("Starting span sink queues")
+		for i := range s.spanSinks {
+			for j := 0; j < s.SpanSinkQueueWorkers; j++ {
+				go func(i int) {
+					defer func() {
+						ConsumePanic(s.Sentry, s.TraceClient, s.Hostname, recover())
+					}()
+					s.SpanWorker.drainSinkQueue(i)
+				}(i)
+			}
+		}
+	}
+
 	log.WithField("n", s.SpanWorkerGoroutines).Info("Starting span workers")
 	for i := 0; i < s.SpanWorkerGoroutines; i++ {
 		go func() {
@@ -632,8 +1453,10 @@ func (s *Server) Start() {
 	}
 
 	tracePool := &sync.Pool{
+		// Like statsdPool's, these are +1 to detect spans that are
+		// too long.
 		New: func() interface{} {
-			return make([]byte, s.traceMaxLengthBytes)
+			return make([]byte, s.traceMaxLengthBytes+1)
 		},
 	}
 
@@ -651,12 +1474,82 @@ func (s *Server) Start() {
 		}
 	}
 
+	// Restore any sampler state left behind by a previous
+	// instance before we start accepting new data:
+	if s.snapshotPath != "" {
+		if err := s.restoreSnapshot(); err != nil {
+			log.WithError(err).WithField("path", s.snapshotPath).
+				Warn("Could not restore sampler snapshot")
+		}
+		go func() {
+			defer func() {
+				ConsumePanic(s.Sentry, s.TraceClient, s.Hostname, recover())
+			}()
+			s.snapshotPeriodically()
+		}()
+	}
+
+	go func() {
+		defer func() {
+			ConsumePanic(s.Sentry, s.TraceClient, s.Hostname, recover())
+		}()
+		s.runLeaderElection()
+	}()
+
+	if s.metricBlocklist != nil && s.metricBlocklist.file != "" {
+		go func() {
+			defer func() {
+				ConsumePanic(s.Sentry, s.TraceClient, s.Hostname, recover())
+			}()
+			watchBlocklist(s, s.metricBlocklist.blocklist, s.metricBlocklistRefreshInterval)
+		}()
+	}
+
+	if s.clockCheck != nil {
+		go func() {
+			defer func() {
+				ConsumePanic(s.Sentry, s.TraceClient, s.Hostname, recover())
+			}()
+			s.watchClock(s.clockCheckInterval)
+		}()
+	}
+
+	if s.canary != nil {
+		go func() {
+			defer func() {
+				ConsumePanic(s.Sentry, s.TraceClient, s.Hostname, recover())
+			}()
+			s.runCanary()
+		}()
+	}
+
+	if s.spanBlocklist != nil && s.spanBlocklist.file != "" {
+		go func() {
+			defer func() {
+				ConsumePanic(s.Sentry, s.TraceClient, s.Hostname, recover())
+			}()
+			watchBlocklist(s, s.spanBlocklist.blocklist, s.spanBlocklistRefreshInterval)
+		}()
+	}
+
+	if s.metricSchemas != nil && s.metricSchemaRefreshInterval > 0 {
+		go func() {
+			defer func() {
+				ConsumePanic(s.Sentry, s.TraceClient, s.Hostname, recover())
+			}()
+			s.refreshMetricSchemas()
+		}()
+	}
+
 	// Read Metrics Forever!
 	concreteAddrs := make([]net.Addr, 0, len(s.StatsdListenAddrs))
 	for _, addr := range s.StatsdListenAddrs {
 		concreteAddrs = append(concreteAddrs, StartStatsd(s, addr, statsdPool))
 	}
 	s.StatsdListenAddrs = concreteAddrs
+	if s.xdpInterface != "" {
+		startStatsdXDP(s, concreteAddrs)
+	}
 
 	// Read Traces Forever!
 	if len(s.SSFListenAddrs) > 0 {
@@ -669,15 +1562,38 @@ func (s *Server) Start() {
 		logrus.Info("Tracing sockets are not configured - not reading trace socket")
 	}
 
+	// Read both, from the same sockets, Forever!
+	if len(s.CombinedListenAddrs) > 0 {
+		combinedPool := &sync.Pool{
+			New: func() interface{} {
+				size := s.metricMaxLength + 1
+				if s.traceMaxLengthBytes+1 > size {
+					size = s.traceMaxLengthBytes + 1
+				}
+				return make([]byte, size)
+			},
+		}
+		concreteAddrs := make([]net.Addr, 0, len(s.CombinedListenAddrs))
+		for _, addr := range s.CombinedListenAddrs {
+			concreteAddrs = append(concreteAddrs, StartCombined(s, addr, combinedPool))
+		}
+		s.CombinedListenAddrs = concreteAddrs
+	}
+
 	// Initialize a gRPC connection for forwarding
 	if s.forwardUseGRPC {
+		opts := []grpc.DialOption{grpc.WithInsecure()}
+		if s.chaos != nil {
+			opts = append(opts, grpc.WithUnaryInterceptor(s.chaos.unaryClientInterceptor()))
+		}
 		var err error
-		s.grpcForwardConn, err = grpc.Dial(s.ForwardAddr, grpc.WithInsecure())
+		s.grpcForwardConn, err = grpc.Dial(s.ForwardAddr, opts...)
 		if err != nil {
 			log.WithError(err).WithFields(logrus.Fields{
 				"forwardAddr": s.ForwardAddr,
 			}).Fatal("Failed to initialize a gRPC connection for forwarding")
 		}
+		s.grpcForwardClient = forwardrpc.NewClient(s.grpcForwardConn, s.grpcForwardOptions...)
 	}
 
 	// Flush every Interval forever!
@@ -691,6 +1607,11 @@ func (s *Server) Start() {
 			// convenience of bucketing.
 			<-time.After(CalculateTickDelay(s.interval, time.Now()))
 		}
+		if s.flushJitter > 0 {
+			// Offset our flushes from those of other instances, so they
+			// don't all forward and flush to sinks at the same moment.
+			<-time.After(s.flushJitter)
+		}
 
 		// We aligned the ticker to our interval above. It's worth noting that just
 		// because we aligned once we're not guaranteed to be perfect on each
@@ -714,6 +1635,12 @@ func (s *Server) Start() {
 // HandleMetricPacket processes each packet that is sent to the server, and sends to an
 // appropriate worker (EventWorker or Worker).
 func (s *Server) HandleMetricPacket(packet []byte) error {
+	return s.handleMetricPacket(packet, s.Workers)
+}
+
+// handleMetricPacket processes a packet like HandleMetricPacket,
+// handing metrics to one of the given workers.
+func (s *Server) handleMetricPacket(packet []byte, workers []*Worker) error {
 	// This is a very performance-sensitive function
 	// and packets may be dropped if it gets slowed down.
 	// Keep that in mind when modifying!
@@ -733,9 +1660,13 @@ func (s *Server) HandleMetricPacket(packet []byte) error {
 				logrus.ErrorKey: err,
 				"packet":        string(packet),
 			}).Warn("Could not parse packet")
-			samples.Add(ssf.Count("packet.error_total", 1, map[string]string{"packet_type": "event", "reason": "parse"}))
+			samples.Add(ssf.Count("packet.error_total", 1, map[string]string{"packet_type": "event", "reason": "parse"},
+				ssf.Failure("statsd", ssf.CauseParseError)))
 			return err
 		}
+		if s.timestampSkew != nil && s.timestampSkew.rejectsUnix(skewKindEvent, event.Timestamp, time.Now()) {
+			return nil
+		}
 		s.EventWorker.sampleChan <- *event
 	} else if bytes.HasPrefix(packet, []byte{'_', 's', 'c'}) {
 		svcheck, err := samplers.ParseServiceCheck(packet)
@@ -744,21 +1675,29 @@ func (s *Server) HandleMetricPacket(packet []byte) error {
 				logrus.ErrorKey: err,
 				"packet":        string(packet),
 			}).Warn("Could not parse packet")
-			samples.Add(ssf.Count("packet.error_total", 1, map[string]string{"packet_type": "service_check", "reason": "parse"}))
+			samples.Add(ssf.Count("packet.error_total", 1, map[string]string{"packet_type": "service_check", "reason": "parse"},
+				ssf.Failure("statsd", ssf.CauseParseError)))
 			return err
 		}
-		s.Workers[svcheck.Digest%uint32(len(s.Workers))].PacketChan <- *svcheck
+		if s.timestampSkew != nil && s.timestampSkew.rejectsUnix(skewKindServiceCheck, svcheck.Timestamp, time.Now()) {
+			return nil
+		}
+		workers[svcheck.Digest%uint32(len(workers))].IngestUDP(*svcheck)
 	} else {
-		metric, err := samplers.ParseMetric(packet)
+		metric, err := s.parser.ParseMetric(packet)
 		if err != nil {
 			log.WithFields(logrus.Fields{
 				logrus.ErrorKey: err,
 				"packet":        string(packet),
 			}).Warn("Could not parse packet")
-			samples.Add(ssf.Count("packet.error_total", 1, map[string]string{"packet_type": "metric", "reason": "parse"}))
+			samples.Add(ssf.Count("packet.error_total", 1, map[string]string{"packet_type": "metric", "reason": "parse"},
+				ssf.Failure("statsd", ssf.CauseParseError)))
 			return err
 		}
-		s.Workers[metric.Digest%uint32(len(s.Workers))].PacketChan <- *metric
+		if s.statsdRepeater.repeats(metric.Name) {
+			s.statsdRepeater.repeat(packet)
+		}
+		workers[metric.Digest%uint32(len(workers))].IngestUDP(*metric)
 	}
 	return nil
 }
@@ -771,7 +1710,7 @@ func (s *Server) HandleTracePacket(packet []byte) {
 
 	// Unlike metrics, protobuf shouldn't have an issue with 0-length packets
 	if len(packet) == 0 {
-		s.Statsd.Count("ssf.error_total", 1, []string{"ssf_format:packet", "packet_type:unknown", "reason:zerolength"}, 1.0)
+		s.Statsd.Count("ssf.error_total", 1, failureTags("ssf", ssf.CauseParseError, "ssf_format:packet", "packet_type:unknown", "reason:zerolength"), 1.0)
 		log.Warn("received zero-length trace packet")
 		return
 	}
@@ -781,16 +1720,24 @@ func (s *Server) HandleTracePacket(packet []byte) {
 	span, err := protocol.ParseSSF(packet)
 	if err != nil {
 		reason := "reason:" + err.Error()
-		s.Statsd.Count("ssf.error_total", 1, []string{"ssf_format:packet", "packet_type:ssf_metric", reason}, 1.0)
+		s.Statsd.Count("ssf.error_total", 1, failureTags("ssf", ssf.CauseParseError, "ssf_format:packet", "packet_type:ssf_metric", reason), 1.0)
 		log.WithError(err).Warn("ParseSSF")
 		return
 	}
+	s.handleTraceSpan(span)
+}
+
+// handleTraceSpan handles a span parsed from an SSF packet.
+func (s *Server) handleTraceSpan(span *ssf.SSFSpan) {
+	if s.chaos.dropPacket() {
+		return
+	}
 	// we want to keep track of this, because it's a client problem, but still
 	// handle the span normally
 	if span.Id == 0 {
 		reason := "reason:" + "empty_id"
-		s.Statsd.Count("ssf.error_total", 1, []string{"ssf_format:packet", "packet_type:ssf_metric", reason}, 1.0)
-		log.WithError(err).Warn("ParseSSF")
+		s.Statsd.Count("ssf.error_total", 1, failureTags("ssf", ssf.CauseParseError, "ssf_format:packet", "packet_type:ssf_metric", reason), 1.0)
+		log.Warn("ParseSSF")
 	}
 
 	s.handleSSF(span, "packet")
@@ -834,11 +1781,74 @@ func (s *Server) handleSSF(span *ssf.SSFSpan, ssfFormat string) {
 
 	atomic.AddInt64(&metricsStruct.ssfSpansReceivedTotal, 1)
 
+	if s.spanBlocklist != nil && s.spanBlocklist.blocks(span) {
+		return
+	}
+	if s.timestampSkew != nil && s.timestampSkew.rejectsSpan(span, time.Now()) {
+		return
+	}
+	if s.spanDeduper != nil && s.spanDeduper.duplicate(span, time.Now()) {
+		atomic.AddInt64(&metricsStruct.ssfSpansDuplicateTotal, 1)
+		return
+	}
+	if s.spanLimits != nil {
+		for field, truncated := range s.spanLimits.truncate(span) {
+			if truncated {
+				atomic.AddInt64(&metricsStruct.ssfSpansTruncated[field], 1)
+			}
+		}
+	}
+	if s.serviceMap != nil {
+		s.serviceMap.record(span)
+	}
+	for _, event := range splitEvents(span) {
+		s.EventWorker.sampleChan <- *event
+	}
 	s.SpanChan <- span
 }
 
+// splitEvents removes the samples that are events or service checks,
+// tagged with ssf.EventTag, from the span's metrics, and returns them.
+func splitEvents(span *ssf.SSFSpan) []*ssf.SSFSample {
+	var events []*ssf.SSFSample
+	metrics := span.Metrics[:0]
+	for _, sample := range span.Metrics {
+		if _, ok := sample.Tags[ssf.EventTag]; ok {
+			events = append(events, sample)
+			continue
+		}
+		metrics = append(metrics, sample)
+	}
+	span.Metrics = metrics
+	return events
+}
+
+// splunkHecAddresses returns the URLs of the Splunk HECs that the
+// Splunk sinks submit to.
+func splunkHecAddresses(conf Config) []string {
+	addresses := conf.SplunkHecAddresses
+	if conf.SplunkHecAddress != "" {
+		addresses = append([]string{conf.SplunkHecAddress}, addresses...)
+	}
+	return addresses
+}
+
+// failureTags returns DogStatsD tags for a failure counter, like the
+// ssf.Failure sample option does for SSF samples.
+func failureTags(component, cause string, tags ...string) []string {
+	return append([]string{"component:" + component, "cause:" + cause}, tags...)
+}
+
 // ReadMetricSocket listens for available packets to handle.
 func (s *Server) ReadMetricSocket(serverConn net.PacketConn, packetPool *sync.Pool) {
+	s.readMetricSocket(serverConn, packetPool, s.Workers)
+}
+
+// readMetricSocket listens for packets like ReadMetricSocket, and
+// hands the metrics in them to the given workers.
+func (s *Server) readMetricSocket(serverConn net.PacketConn, packetPool *sync.Pool, workers []*Worker) {
+	capture := s.packetCaptureFor(serverConn.LocalAddr())
+	mirror := s.trafficMirrorFor(serverConn.LocalAddr())
 	for {
 		buf := packetPool.Get().([]byte)
 		n, _, err := serverConn.ReadFrom(buf)
@@ -846,20 +1856,10 @@ func (s *Server) ReadMetricSocket(serverConn net.PacketConn, packetPool *sync.Po
 			log.WithError(err).Error("Error reading from UDP metrics socket")
 			continue
 		}
-		if n > s.metricMaxLength {
-			metrics.ReportOne(s.TraceClient, ssf.Count("packet.error_total", 1, map[string]string{"packet_type": "unknown", "reason": "toolong"}))
-			continue
-		}
-
-		// statsd allows multiple packets to be joined by newlines and sent as
-		// one larger packet
-		// note that spurious newlines are not allowed in this format, it has
-		// to be exactly one newline between each packet, with no leading or
-		// trailing newlines
-		splitPacket := samplers.NewSplitBytes(buf[:n], '\n')
-		for splitPacket.Next() {
-			s.HandleMetricPacket(splitPacket.Chunk())
-		}
+		atomic.AddInt64(&s.receivedBytes, int64(n))
+		capture.capture(buf[:n])
+		mirror.mirror(buf[:n])
+		s.handleMetricDatagram(buf[:n], workers)
 
 		// the Metric struct created by HandleMetricPacket has no byte slices in it,
 		// only strings
@@ -869,6 +1869,36 @@ func (s *Server) ReadMetricSocket(serverConn net.PacketConn, packetPool *sync.Po
 	}
 }
 
+// handleMetricDatagram processes all the packets contained in one
+// datagram received from a statsd client.
+func (s *Server) handleMetricDatagram(datagram []byte, workers []*Worker) {
+	if s.chaos.dropPacket() {
+		return
+	}
+	if len(datagram) > s.metricMaxLength {
+		metrics.ReportOne(s.TraceClient, ssf.Count("packet.error_total", 1, map[string]string{"packet_type": "unknown", "reason": "toolong"},
+			ssf.Failure("statsd", ssf.CauseParseError)))
+		return
+	}
+
+	// statsd allows multiple packets to be joined by newlines and sent as
+	// one larger packet
+	// note that spurious newlines are not allowed in this format, it has
+	// to be exactly one newline between each packet, with no leading or
+	// trailing newlines
+	splitPacket := samplers.NewSplitBytes(datagram, '\n')
+	for packets := 0; splitPacket.Next(); packets++ {
+		if s.metricMaxPerDatagram > 0 && packets >= s.metricMaxPerDatagram {
+			// the rest of the datagram is dropped, and counted
+			// apart from datagrams that were dropped whole:
+			metrics.ReportOne(s.TraceClient, ssf.Count("packet.error_total", 1, map[string]string{"packet_type": "unknown", "reason": "truncated"},
+				ssf.Failure("statsd", ssf.CauseParseError)))
+			return
+		}
+		s.handleMetricPacket(splitPacket.Chunk(), workers)
+	}
+}
+
 // ReadSSFPacketSocket reads SSF packets off a packet connection.
 func (s *Server) ReadSSFPacketSocket(serverConn net.PacketConn, packetPool *sync.Pool) {
 	// TODO This is duplicated from ReadMetricSocket and feels like it could be it's
@@ -880,6 +1910,8 @@ func (s *Server) ReadSSFPacketSocket(serverConn net.PacketConn, packetPool *sync
 	}
 	packetPool.Put(p)
 
+	capture := s.packetCaptureFor(serverConn.LocalAddr())
+	mirror := s.trafficMirrorFor(serverConn.LocalAddr())
 	for {
 		buf := packetPool.Get().([]byte)
 		n, _, err := serverConn.ReadFrom(buf)
@@ -897,14 +1929,28 @@ func (s *Server) ReadSSFPacketSocket(serverConn net.PacketConn, packetPool *sync
 			}
 		}
 
-		s.HandleTracePacket(buf[:n])
+		atomic.AddInt64(&s.receivedBytes, int64(n))
+		capture.capture(buf[:n])
+		mirror.mirror(buf[:n])
+		if n > s.traceMaxLengthBytes {
+			s.reportTooLongSpan()
+		} else {
+			s.HandleTracePacket(buf[:n])
+		}
 		packetPool.Put(buf)
 	}
 }
 
+// reportTooLongSpan counts an SSF datagram that was dropped because
+// it's longer than trace_max_length_bytes, and so may have been
+// truncated on the way in.
+func (s *Server) reportTooLongSpan() {
+	s.Statsd.Count("ssf.error_total", 1, failureTags("ssf", ssf.CauseParseError, "ssf_format:packet", "packet_type:unknown", "reason:toolong"), 1.0)
+}
+
 // ReadSSFStreamSocket reads a streaming connection in framed wire format
-// off a streaming socket. See package
-// github.com/stripe/veneur/protocol for details.
+// off a streaming socket, including compressed batches of spans. See
+// package github.com/stripe/veneur/protocol for details.
 func (s *Server) ReadSSFStreamSocket(serverConn net.Conn) {
 	defer func() {
 		serverConn.Close()
@@ -915,8 +1961,16 @@ func (s *Server) ReadSSFStreamSocket(serverConn net.Conn) {
 	tags := make([]string, 1, 3)
 	tags[0] = "ssf_format:framed"
 
+	capture := s.packetCaptureFor(serverConn.LocalAddr())
+	mirror := s.trafficMirrorFor(serverConn.LocalAddr())
+	in := &frameRecorder{r: serverConn}
 	for {
-		msg, err := protocol.ReadSSF(serverConn)
+		in.reset(capture.enabled() || mirror != nil)
+		msgs, err := protocol.ReadSSFBatch(in)
+		if len(in.frame) > 0 {
+			capture.capture(in.frame)
+			mirror.mirror(in.frame)
+		}
 		if err != nil {
 			if err == io.EOF {
 				// Client hangup, close this
@@ -928,7 +1982,7 @@ func (s *Server) ReadSSFStreamSocket(serverConn net.Conn) {
 					WithField("remote", serverConn.RemoteAddr()).
 					Info("Frame error reading from SSF connection. Closing.")
 				tags = append(tags, []string{"packet_type:unknown", "reason:framing"}...)
-				s.Statsd.Incr("ssf.error_total", tags, 1.0)
+				s.Statsd.Incr("ssf.error_total", failureTags("ssf", ssf.CauseParseError, tags...), 1.0)
 				return
 			}
 			// Non-frame errors means we can continue reading:
@@ -936,15 +1990,19 @@ func (s *Server) ReadSSFStreamSocket(serverConn net.Conn) {
 				WithField("remote", serverConn.RemoteAddr()).
 				Error("Error processing an SSF frame")
 			tags = append(tags, []string{"packet_type:unknown", "reason:processing"}...)
-			s.Statsd.Incr("ssf.error_total", tags, 1.0)
+			s.Statsd.Incr("ssf.error_total", failureTags("ssf", ssf.CauseParseError, tags...), 1.0)
 			tags = tags[:1]
 			continue
 		}
-		s.handleSSF(msg, "framed")
+		for _, msg := range msgs {
+			s.handleSSF(msg, "framed")
+		}
 	}
 }
 
-func (s *Server) handleTCPGoroutine(conn net.Conn) {
+// handleTCPGoroutine reads statsd lines from a TCP connection,
+// passing each of them to capture.
+func (s *Server) handleTCPGoroutine(conn net.Conn, capture *packetCapture) {
 	defer func() {
 		ConsumePanic(s.Sentry, s.TraceClient, s.Hostname, recover())
 	}()
@@ -976,7 +2034,8 @@ func (s *Server) handleTCPGoroutine(conn net.Conn) {
 		if err != nil {
 			// usually io.EOF or "read: connection reset by peer"; not really errors
 			// it can also be caused by certificate authentication problems
-			metrics.ReportOne(s.TraceClient, ssf.Count("tcp.tls_handshake_failures", 1, nil))
+			metrics.ReportOne(s.TraceClient, ssf.Count("tcp.tls_handshake_failures", 1, nil,
+				ssf.Failure("statsd", ssf.CauseIOError)))
 			log.WithFields(logrus.Fields{
 				logrus.ErrorKey: err,
 				"peer":          conn.RemoteAddr(),
@@ -1008,6 +2067,7 @@ func (s *Server) handleTCPGoroutine(conn net.Conn) {
 		return buf.Scan()
 	}
 	for scanWithDeadline() {
+		capture.capture(buf.Bytes())
 		// treat each line as a separate packet
 		err := s.HandleMetricPacket(buf.Bytes())
 		if err != nil {
@@ -1029,6 +2089,7 @@ func (s *Server) handleTCPGoroutine(conn net.Conn) {
 
 // ReadTCPSocket listens on Server.TCPAddr for new connections, starting a goroutine for each.
 func (s *Server) ReadTCPSocket(listener net.Listener) {
+	capture := s.packetCaptureFor(listener.Addr())
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
@@ -1042,7 +2103,7 @@ func (s *Server) ReadTCPSocket(listener net.Listener) {
 			}
 		}
 
-		go s.handleTCPGoroutine(conn)
+		go s.handleTCPGoroutine(conn, capture)
 	}
 }
 
@@ -1164,11 +2225,14 @@ func (s *Server) gRPCStop() {
 // Shutdown signals the server to shut down after closing all
 // current connections.
 func (s *Server) Shutdown() {
-	// TODO(aditya) shut down workers and socket readers
 	log.Info("Shutting down server gracefully")
-	close(s.shutdown)
-	graceful.Shutdown()
-	s.gRPCStop()
+	order := s.shutdownOrder
+	if order == nil {
+		order = defaultShutdownOrder
+	}
+	for _, phase := range order {
+		s.runShutdownPhase(phase)
+	}
 
 	// Close the gRPC connection for forwarding
 	if s.grpcForwardConn != nil {
@@ -1211,6 +2275,16 @@ func CalculateTickDelay(interval time.Duration, t time.Time) time.Duration {
 	return t.Truncate(interval).Add(interval).Sub(t)
 }
 
+// calculateFlushJitter returns how long after each interval an instance
+// identified by id should flush: a duration between 0 and fraction of
+// interval, that's the same every time for the same id.
+func calculateFlushJitter(interval time.Duration, fraction float64, id string) time.Duration {
+	if fraction <= 0 {
+		return 0
+	}
+	return time.Duration(float64(interval) * fraction * float64(fnv1a.HashString32(id)) / (1 << 32))
+}
+
 // Set the list of tags to exclude on each sink
 func setSinkExcludedTags(excludeRules []string, metricSinks []sinks.MetricSink) {
 	type excludableSink interface {
@@ -763,6 +763,43 @@ func TestIgnoreLongUDPMetrics(t *testing.T) {
 	assert.Equal(t, int64(0), f.server.Workers[0].processed, "worker did not process a metric")
 }
 
+func TestTruncateUDPDatagrams(t *testing.T) {
+	config := localConfig()
+	config.NumWorkers = 1
+	config.MetricMaxPerDatagram = 2
+	config.Interval = "60s"
+	config.StatsdListenAddresses = []string{"udp://127.0.0.1:0"}
+	f := newFixture(t, config, nil, nil)
+	defer f.Close()
+
+	conn := connectToAddress(t, "udp", f.server.StatsdListenAddrs[0].String(), 20*time.Millisecond)
+	defer conn.Close()
+
+	// only the first two metrics fit in the limit we set above:
+	conn.Write([]byte("foo.bar:1|c\nfoo.baz:1|c\nfoo.quux:1|c"))
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, int64(2), f.server.Workers[0].processed, "worker processed the metrics within the limit")
+}
+
+func TestIgnoreLongSSFDatagrams(t *testing.T) {
+	config := localConfig()
+	config.TraceMaxLengthBytes = 16
+	s := setupVeneurServer(t, config, nil, nil, nil)
+	defer s.Shutdown()
+
+	span := &ssf.SSFSpan{
+		Id: 1, TraceId: 1, StartTimestamp: 1, EndTimestamp: 5,
+		Service: "a-long-service-name", Name: "a-long-span-name",
+	}
+	packet, err := proto.Marshal(span)
+	require.NoError(t, err)
+	require.True(t, len(packet) > config.TraceMaxLengthBytes)
+
+	s.SpanChan = make(chan *ssf.SSFSpan, 1)
+	s.handleCombinedDatagram(packet, s.Workers)
+	assert.Len(t, s.SpanChan, 0, "the span is too long and shouldn't be handled")
+}
+
 // TestTCPMetrics checks that a server can accept metrics over a TCP socket.
 func TestTCPMetrics(t *testing.T) {
 	pems, err := readTestKeysCerts()
@@ -899,7 +936,7 @@ func TestHandleTCPGoroutineTimeout(t *testing.T) {
 
 	// handleTCPGoroutine should not block forever: it will time outTest
 	log.Printf("handling goroutine")
-	s.handleTCPGoroutine(conn)
+	s.handleTCPGoroutine(conn, nil)
 	<-acceptorDone
 
 	// we should have received one metric
@@ -930,6 +967,21 @@ func TestCalculateTickerDelay(t *testing.T) {
 	assert.Equal(t, 3.629, delay.Seconds(), "Delay is incorrect")
 }
 
+func TestCalculateFlushJitter(t *testing.T) {
+	interval := 10 * time.Second
+	assert.Equal(t, time.Duration(0), calculateFlushJitter(interval, 0, "host-1"))
+
+	jitter := calculateFlushJitter(interval, 0.2, "host-1")
+	assert.Equal(t, jitter, calculateFlushJitter(interval, 0.2, "host-1"), "the jitter should be the same for the same instance")
+	spread := map[time.Duration]bool{}
+	for i := 0; i < 100; i++ {
+		jitter := calculateFlushJitter(interval, 0.2, fmt.Sprintf("host-%d", i))
+		assert.True(t, jitter >= 0 && jitter < 2*time.Second, "jitter %v is out of bounds", jitter)
+		spread[jitter] = true
+	}
+	assert.True(t, len(spread) > 90, "instances should flush at different times")
+}
+
 // BenchmarkSendSSFUNIX sends b.N metrics to veneur and waits until
 // all of them have been read (not processed).
 func BenchmarkSendSSFUNIX(b *testing.B) {
@@ -1399,3 +1451,14 @@ func BenchmarkHandleSSF(b *testing.B) {
 		f.server.handleSSF(spans[i%LEN], "packet")
 	}
 }
+
+func TestSplitEvents(t *testing.T) {
+	event := &ssf.SSFSample{Name: "deploy", Tags: map[string]string{ssf.EventTag: ""}}
+	check := ssf.Status("api.up", ssf.SSFSample_OK, map[string]string{ssf.EventTag: ""})
+	status := ssf.Status("db.up", ssf.SSFSample_OK, nil)
+	counter := ssf.Count("requests", 1, nil)
+	span := &ssf.SSFSpan{Metrics: []*ssf.SSFSample{counter, event, status, check}}
+
+	assert.Equal(t, []*ssf.SSFSample{event, check}, splitEvents(span))
+	assert.Equal(t, []*ssf.SSFSample{counter, status}, span.Metrics, "only the events and service checks should be split off")
+}
@@ -0,0 +1,187 @@
+package veneur
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/stripe/veneur/ssf"
+	"golang.org/x/net/context"
+)
+
+// serviceMapMaxSpans bounds the spans that a service map remembers in
+// a flush interval. Spans beyond it don't make edges.
+const serviceMapMaxSpans = 1 << 20
+
+// serviceEdge is a caller→callee edge of the service map: the requests
+// from spans of the caller service to spans of the callee service in a
+// flush interval, and how many of them failed.
+type serviceEdge struct {
+	Caller    string  `json:"caller"`
+	Callee    string  `json:"callee"`
+	Requests  int64   `json:"requests"`
+	Errors    int64   `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+}
+
+type serviceEdgeKey struct {
+	caller, callee string
+}
+
+// serviceMapChild is a span whose parent hasn't been seen yet.
+type serviceMapChild struct {
+	parent  spanKey
+	service string
+	error   bool
+	// carried is whether the span was carried over from the previous
+	// interval already.
+	carried bool
+}
+
+// serviceMap derives a dependency graph of services from the parent
+// and child spans that veneur receives: a child span of another
+// service than its parent's is a request from the parent's service to
+// the child's. Children usually finish, and are sent, before their
+// parents, so a child whose parent hasn't been seen by the end of an
+// interval is carried over to the next one, where it counts towards
+// the next interval's edges; it's dropped if its parent doesn't show
+// up then either. Parents are remembered for one more interval, for
+// the children that arrive late.
+type serviceMap struct {
+	mtx sync.Mutex
+	// services holds the service of the spans seen in the interval,
+	// and previous those of the previous interval.
+	services map[spanKey]string
+	previous map[spanKey]string
+	children []serviceMapChild
+	// overflowed counts the spans that didn't fit in the interval.
+	overflowed int64
+
+	// last holds the edges of the last interval, for the HTTP
+	// endpoint.
+	last []serviceEdge
+}
+
+func newServiceMap() *serviceMap {
+	return &serviceMap{
+		services: map[spanKey]string{},
+		previous: map[spanKey]string{},
+	}
+}
+
+// record remembers a span for the edges of the interval.
+func (m *serviceMap) record(span *ssf.SSFSpan) {
+	if span.Id == 0 || span.TraceId == 0 || span.Service == "" {
+		return
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if len(m.services)+len(m.children) >= serviceMapMaxSpans {
+		m.overflowed++
+		return
+	}
+	m.services[spanKey{traceID: span.TraceId, id: span.Id}] = span.Service
+	if span.ParentId > 0 && span.ParentId != span.Id {
+		m.children = append(m.children, serviceMapChild{
+			parent:  spanKey{traceID: span.TraceId, id: span.ParentId},
+			service: span.Service,
+			error:   span.Error,
+		})
+	}
+}
+
+// flush ends the interval, and returns its edges, ordered by caller
+// and callee.
+func (m *serviceMap) flush() []serviceEdge {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	edges := map[serviceEdgeKey]*serviceEdge{}
+	var pending []serviceMapChild
+	for _, child := range m.children {
+		caller, ok := m.services[child.parent]
+		if !ok {
+			caller, ok = m.previous[child.parent]
+		}
+		if !ok {
+			if !child.carried {
+				child.carried = true
+				pending = append(pending, child)
+			}
+			continue
+		}
+		if caller == child.service {
+			continue
+		}
+		key := serviceEdgeKey{caller: caller, callee: child.service}
+		edge, ok := edges[key]
+		if !ok {
+			edge = &serviceEdge{Caller: caller, Callee: child.service}
+			edges[key] = edge
+		}
+		edge.Requests++
+		if child.error {
+			edge.Errors++
+		}
+	}
+
+	m.previous = m.services
+	m.services = map[spanKey]string{}
+	m.children = pending
+
+	list := make([]serviceEdge, 0, len(edges))
+	for _, edge := range edges {
+		edge.ErrorRate = float64(edge.Errors) / float64(edge.Requests)
+		list = append(list, *edge)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Caller != list[j].Caller {
+			return list[i].Caller < list[j].Caller
+		}
+		return list[i].Callee < list[j].Callee
+	})
+	m.last = list
+	return list
+}
+
+// report ends the interval, and returns the requests, errors and error
+// rate of each of its edges, tagged by caller and callee.
+func (m *serviceMap) report() []*ssf.SSFSample {
+	edges := m.flush()
+	m.mtx.Lock()
+	overflowed := m.overflowed
+	m.overflowed = 0
+	m.mtx.Unlock()
+
+	samples := make([]*ssf.SSFSample, 0, 3*len(edges)+1)
+	for _, edge := range edges {
+		tags := map[string]string{"caller": edge.Caller, "callee": edge.Callee}
+		samples = append(samples,
+			ssf.Count("service_map.requests_total", float32(edge.Requests), tags),
+			ssf.Count("service_map.errors_total", float32(edge.Errors), tags),
+			ssf.Gauge("service_map.error_rate", float32(edge.ErrorRate), tags))
+	}
+	return append(samples, ssf.Count("service_map.spans_overflowed_total", float32(overflowed), nil))
+}
+
+// edges returns the edges of the last interval.
+func (m *serviceMap) edges() []serviceEdge {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.last
+}
+
+// handleServiceMap serves the edges of the service map's last interval
+// as JSON.
+func handleServiceMap(s *Server) func(context.Context, http.ResponseWriter, *http.Request) {
+	return func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		edges := s.serviceMap.edges()
+		if edges == nil {
+			edges = []serviceEdge{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(edges); err != nil {
+			log.WithError(err).Warn("Could not encode the service map")
+		}
+	}
+}
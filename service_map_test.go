@@ -0,0 +1,97 @@
+package veneur
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/ssf"
+)
+
+func mapSpan(traceID, id, parentID int64, service string, failed bool) *ssf.SSFSpan {
+	return &ssf.SSFSpan{TraceId: traceID, Id: id, ParentId: parentID, Service: service, Error: failed}
+}
+
+func TestServiceMapEdges(t *testing.T) {
+	m := newServiceMap()
+	// children arrive before their parents:
+	m.record(mapSpan(1, 2, 1, "api", false))
+	m.record(mapSpan(1, 3, 2, "db", false))
+	m.record(mapSpan(1, 4, 2, "db", true))
+	m.record(mapSpan(1, 5, 2, "api", false))
+	m.record(mapSpan(1, 1, 0, "frontend", false))
+	m.record(mapSpan(6, 7, 6, "api", true))
+	m.record(mapSpan(6, 6, 0, "frontend", false))
+
+	assert.Equal(t, []serviceEdge{
+		{Caller: "api", Callee: "db", Requests: 2, Errors: 1, ErrorRate: 0.5},
+		{Caller: "frontend", Callee: "api", Requests: 2, Errors: 1, ErrorRate: 0.5},
+	}, m.flush(), "calls within a service shouldn't be edges")
+	assert.Empty(t, m.flush())
+}
+
+func TestServiceMapLateSpans(t *testing.T) {
+	m := newServiceMap()
+	m.record(mapSpan(1, 2, 1, "api", false))
+	m.record(mapSpan(1, 10, 9, "api", false))
+	assert.Empty(t, m.flush())
+
+	// the parent arrives in the next interval:
+	m.record(mapSpan(1, 1, 0, "frontend", false))
+	assert.Equal(t, []serviceEdge{
+		{Caller: "frontend", Callee: "api", Requests: 1, ErrorRate: 0},
+	}, m.flush())
+
+	// a child whose parent arrived in the previous interval:
+	m.record(mapSpan(1, 3, 1, "search", false))
+	assert.Equal(t, []serviceEdge{
+		{Caller: "frontend", Callee: "search", Requests: 1, ErrorRate: 0},
+	}, m.flush())
+
+	m.record(mapSpan(1, 9, 8, "cache", false))
+	assert.Empty(t, m.flush())
+	assert.Len(t, m.children, 1)
+	assert.Empty(t, m.flush())
+	assert.Empty(t, m.children, "children whose parent never arrived should be dropped")
+}
+
+func TestServiceMapReport(t *testing.T) {
+	m := newServiceMap()
+	m.record(mapSpan(1, 2, 1, "api", true))
+	m.record(mapSpan(1, 1, 0, "frontend", false))
+
+	samples := map[string]*ssf.SSFSample{}
+	for _, s := range m.report() {
+		samples[s.Name] = s
+	}
+	require.Contains(t, samples, "service_map.requests_total")
+	assert.Equal(t, float32(1), samples["service_map.requests_total"].Value)
+	assert.Equal(t, map[string]string{"caller": "frontend", "callee": "api"}, samples["service_map.requests_total"].Tags)
+	assert.Equal(t, float32(1), samples["service_map.errors_total"].Value)
+	assert.Equal(t, float32(1), samples["service_map.error_rate"].Value)
+	assert.Equal(t, float32(0), samples["service_map.spans_overflowed_total"].Value)
+}
+
+func TestServiceMapEndpoint(t *testing.T) {
+	config := localConfig()
+	config.ServiceMapEnabled = true
+	s := setupVeneurServer(t, config, nil, nil, nil)
+	defer s.Shutdown()
+
+	s.serviceMap.record(mapSpan(1, 2, 1, "api", false))
+	s.serviceMap.record(mapSpan(1, 1, 0, "frontend", false))
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/service_map", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, "[]", w.Body.String(), "only finished intervals should be served")
+
+	s.serviceMap.flush()
+	w = httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/service_map", nil))
+	var edges []serviceEdge
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&edges))
+	assert.Equal(t, []serviceEdge{{Caller: "frontend", Callee: "api", Requests: 1}}, edges)
+}
@@ -0,0 +1,208 @@
+package veneur
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/sinks"
+	"github.com/stripe/veneur/ssf"
+	"github.com/zenazn/goji/graceful"
+)
+
+// The phases of a shutdown, which run in the configured order.
+const (
+	// shutdownListeners stops accepting data: it stops the socket
+	// readers and the HTTP and gRPC servers.
+	shutdownListeners = "listeners"
+	// shutdownWorkers writes the sampler snapshot, and waits for
+	// the span workers to hand the spans they received to the span
+	// sinks.
+	shutdownWorkers = "workers"
+	// shutdownSinks stops the sinks that implement sinks.Stopper,
+	// each with its own timeout.
+	shutdownSinks = "sinks"
+)
+
+var defaultShutdownOrder = []string{shutdownListeners, shutdownWorkers, shutdownSinks}
+
+var defaultShutdownTimeouts = map[string]time.Duration{
+	shutdownListeners: 10 * time.Second,
+	shutdownWorkers:   5 * time.Second,
+	shutdownSinks:     10 * time.Second,
+}
+
+// shutdownDrainInterval is how often the workers phase checks whether
+// the span workers are done.
+const shutdownDrainInterval = 10 * time.Millisecond
+
+// parseShutdownOrder validates the order of the shutdown phases, which
+// must name each phase exactly once, and defaults it. The sinks can't
+// be stopped before the listeners, which keep handing them data.
+func parseShutdownOrder(order []string) ([]string, error) {
+	if len(order) == 0 {
+		return defaultShutdownOrder, nil
+	}
+	seen := map[string]bool{}
+	for _, phase := range order {
+		if _, ok := defaultShutdownTimeouts[phase]; !ok {
+			return nil, fmt.Errorf("unknown shutdown phase %q, must be one of %v", phase, defaultShutdownOrder)
+		}
+		if seen[phase] {
+			return nil, fmt.Errorf("shutdown phase %q is listed twice", phase)
+		}
+		seen[phase] = true
+	}
+	if len(seen) != len(defaultShutdownOrder) {
+		return nil, fmt.Errorf("shutdown_order %v must list each of %v", order, defaultShutdownOrder)
+	}
+	for _, phase := range order {
+		if phase == shutdownListeners {
+			break
+		}
+		if phase == shutdownSinks {
+			return nil, fmt.Errorf("shutdown_order %v must stop the %s before the %s", order, shutdownListeners, shutdownSinks)
+		}
+	}
+	return order, nil
+}
+
+// parseShutdownTimeouts parses the timeouts of the shutdown phases and
+// of individual sinks, keyed by phase or sink name. The phases that
+// aren't configured get their default timeout.
+func parseShutdownTimeouts(conf map[string]string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration, len(defaultShutdownTimeouts)+len(conf))
+	for phase, timeout := range defaultShutdownTimeouts {
+		timeouts[phase] = timeout
+	}
+	for name, value := range conf {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid shutdown timeout for %s: %v", name, err)
+		}
+		timeouts[name] = timeout
+	}
+	return timeouts, nil
+}
+
+// shutdownTimeout returns the timeout of a sink, or of its phase if it
+// has none of its own.
+func (s *Server) shutdownTimeout(phase, sink string) time.Duration {
+	if timeout, ok := s.shutdownTimeouts[sink]; ok && sink != "" {
+		return timeout
+	}
+	if timeout, ok := s.shutdownTimeouts[phase]; ok {
+		return timeout
+	}
+	return defaultShutdownTimeouts[phase]
+}
+
+// runShutdownPhase runs the given phase of a shutdown.
+func (s *Server) runShutdownPhase(phase string) {
+	switch phase {
+	case shutdownListeners:
+		s.stopComponent(phase, "", s.stopListeners)
+	case shutdownWorkers:
+		s.stopComponent(phase, "", s.stopWorkers)
+	case shutdownSinks:
+		s.stopSinks()
+	}
+}
+
+// stopComponent stops a component, or a single sink if sink isn't
+// empty, giving up on it once its timeout passes. A component that
+// doesn't stop in time keeps stopping in the background, but the
+// shutdown moves on without it.
+func (s *Server) stopComponent(phase, sink string, stop func(ctx context.Context)) {
+	timeout := s.shutdownTimeout(phase, sink)
+	tags := []string{"phase:" + phase}
+	fields := logrus.Fields{"phase": phase, "timeout": timeout}
+	if sink != "" {
+		tags = append(tags, "sink:"+sink)
+		fields["sink"] = sink
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		stop(ctx)
+	}()
+	select {
+	case <-done:
+		log.WithFields(fields).WithField("duration", time.Since(start)).Info("Stopped component")
+	case <-ctx.Done():
+		log.WithFields(fields).Warn("Component didn't stop within its shutdown timeout, moving on")
+		s.Statsd.Count("shutdown.timeouts_total", 1, failureTags("shutdown", ssf.CauseSinkTimeout, tags...), 1.0)
+	}
+	s.Statsd.Timing("shutdown.duration_ns", time.Since(start), tags, 1.0)
+}
+
+// stopListeners stops accepting data.
+func (s *Server) stopListeners(ctx context.Context) {
+	close(s.shutdown)
+	graceful.Shutdown()
+	s.gRPCStop()
+}
+
+// stopWorkers writes the sampler snapshot, and waits for the span
+// workers to hand the spans they received to the span sinks.
+func (s *Server) stopWorkers(ctx context.Context) {
+	if s.snapshotPath != "" {
+		if err := s.writeSnapshot(); err != nil {
+			log.WithError(err).WithField("path", s.snapshotPath).
+				Warn("Could not write final sampler snapshot")
+		}
+	}
+	if s.SpanWorker == nil {
+		return
+	}
+	ticker := time.NewTicker(shutdownDrainInterval)
+	defer ticker.Stop()
+	for s.SpanWorker.pending() > 0 {
+		select {
+		case <-ctx.Done():
+			log.WithField("spans", s.SpanWorker.pending()).
+				Warn("Span workers didn't hand all spans to the sinks")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// stopSinks stops every sink that implements sinks.Stopper
+// concurrently, each within its own timeout.
+func (s *Server) stopSinks() {
+	var stoppers []sinks.Stopper
+	var names []string
+	for _, sink := range s.metricSinks {
+		if stopper, ok := sink.(sinks.Stopper); ok {
+			stoppers = append(stoppers, stopper)
+			names = append(names, sink.Name())
+		}
+	}
+	for _, sink := range s.spanSinks {
+		if stopper, ok := sink.(sinks.Stopper); ok {
+			stoppers = append(stoppers, stopper)
+			names = append(names, sink.Name())
+		}
+	}
+
+	wg := sync.WaitGroup{}
+	for i, stopper := range stoppers {
+		wg.Add(1)
+		go func(stopper sinks.Stopper, name string) {
+			defer wg.Done()
+			s.stopComponent(shutdownSinks, name, func(ctx context.Context) {
+				if err := stopper.Stop(ctx); err != nil {
+					log.WithError(err).WithField("sink", name).Warn("Error stopping sink")
+				}
+			})
+		}(stopper, names[i])
+	}
+	wg.Wait()
+}
@@ -0,0 +1,97 @@
+package veneur
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
+)
+
+// stoppingSink is a metric sink that records when it's stopped, and
+// takes as long to stop as it's told to.
+type stoppingSink struct {
+	name    string
+	delay   time.Duration
+	stopped chan struct{}
+}
+
+func (s *stoppingSink) Name() string                                       { return s.name }
+func (s *stoppingSink) Start(*trace.Client) error                          { return nil }
+func (s *stoppingSink) FlushOtherSamples(context.Context, []ssf.SSFSample) {}
+func (s *stoppingSink) Flush(context.Context, []samplers.InterMetric) error {
+	return nil
+}
+
+func (s *stoppingSink) Stop(ctx context.Context) error {
+	select {
+	case <-time.After(s.delay):
+		close(s.stopped)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestParseShutdownOrder(t *testing.T) {
+	order, err := parseShutdownOrder(nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"listeners", "workers", "sinks"}, order)
+
+	order, err = parseShutdownOrder([]string{"workers", "listeners", "sinks"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"workers", "listeners", "sinks"}, order)
+
+	_, err = parseShutdownOrder([]string{"listeners", "sinks"})
+	assert.Error(t, err, "every phase should be listed")
+	_, err = parseShutdownOrder([]string{"listeners", "listeners", "sinks"})
+	assert.Error(t, err, "phases shouldn't be listed twice")
+	_, err = parseShutdownOrder([]string{"listeners", "workers", "sinks", "plugins"})
+	assert.Error(t, err, "phases should be known")
+	_, err = parseShutdownOrder([]string{"sinks", "listeners", "workers"})
+	assert.Error(t, err, "sinks shouldn't be stopped before listeners")
+	_, err = parseShutdownOrder([]string{"workers", "sinks", "listeners"})
+	assert.Error(t, err, "sinks shouldn't be stopped before listeners")
+}
+
+func TestParseShutdownTimeouts(t *testing.T) {
+	timeouts, err := parseShutdownTimeouts(map[string]string{"sinks": "30s", "kafka": "1m"})
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Second, timeouts["listeners"])
+	assert.Equal(t, 30*time.Second, timeouts["sinks"])
+	assert.Equal(t, time.Minute, timeouts["kafka"])
+
+	_, err = parseShutdownTimeouts(map[string]string{"sinks": "soon"})
+	assert.Error(t, err)
+}
+
+func TestShutdownStopsSinks(t *testing.T) {
+	config := localConfig()
+	config.ShutdownTimeouts = map[string]string{
+		"sinks": "1s",
+		"slow":  "10ms",
+	}
+	fast := &stoppingSink{name: "fast", stopped: make(chan struct{})}
+	slow := &stoppingSink{name: "slow", delay: time.Minute, stopped: make(chan struct{})}
+	s := setupVeneurServer(t, config, nil, fast, nil)
+	s.metricSinks = append(s.metricSinks, slow)
+
+	start := time.Now()
+	s.Shutdown()
+	assert.True(t, time.Since(start) < time.Second, "shutdown shouldn't wait for the slow sink")
+
+	select {
+	case <-fast.stopped:
+	default:
+		t.Error("the fast sink wasn't stopped")
+	}
+	select {
+	case <-slow.stopped:
+		t.Error("the slow sink shouldn't have finished stopping")
+	default:
+	}
+}
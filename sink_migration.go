@@ -0,0 +1,198 @@
+package veneur
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/sinks"
+	"github.com/stripe/veneur/ssf"
+)
+
+// maxLoggedSeriesDiffs is the number of example series that are
+// logged for each kind of difference between two sinks.
+const maxLoggedSeriesDiffs = 10
+
+// sinkMigration compares the series that two metric sinks flush every
+// interval, to verify that a new sink's pipeline agrees with an old
+// one's before cutting over from one to the other. Veneur already
+// writes every metric to each of its sinks, but sinks can route,
+// filter and re-tag metrics differently, and their requests can fail,
+// so the sinks record the series they actually send.
+type sinkMigration struct {
+	from      sinks.SeriesRecorder
+	to        sinks.SeriesRecorder
+	fromLog   seriesLog
+	toLog     seriesLog
+	tolerance float64
+}
+
+// newSinkMigration returns a migration between the metric sinks named
+// from and to, and makes them record the series they send.
+func newSinkMigration(from, to string, tolerance float64, metricSinks []sinks.MetricSink) (*sinkMigration, error) {
+	if from == to {
+		return nil, fmt.Errorf("can't migrate from sink %q to itself", from)
+	}
+	var fromSink, toSink sinks.MetricSink
+	for _, sink := range metricSinks {
+		switch sink.Name() {
+		case from:
+			fromSink = sink
+		case to:
+			toSink = sink
+		}
+	}
+	if fromSink == nil {
+		return nil, fmt.Errorf("sink_migration_from: no metric sink named %q is configured", from)
+	}
+	if toSink == nil {
+		return nil, fmt.Errorf("sink_migration_to: no metric sink named %q is configured", to)
+	}
+
+	m := &sinkMigration{tolerance: tolerance}
+	var ok bool
+	if m.from, ok = fromSink.(sinks.SeriesRecorder); !ok {
+		return nil, fmt.Errorf("sink_migration_from: metric sink %q can't record the series it sends", from)
+	}
+	if m.to, ok = toSink.(sinks.SeriesRecorder); !ok {
+		return nil, fmt.Errorf("sink_migration_to: metric sink %q can't record the series it sends", to)
+	}
+	m.from.RecordSeries(m.fromLog.record)
+	m.to.RecordSeries(m.toLog.record)
+	return m, nil
+}
+
+// seriesLog gathers the series that a sink sent in one interval.
+type seriesLog struct {
+	mtx    sync.Mutex
+	series map[string]float64
+	failed int
+}
+
+// record adds the series of one of the sink's requests to the log, or
+// counts them as failed if the request failed.
+func (l *seriesLog) record(series []sinks.FlushedSeries, err error) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	if err != nil {
+		l.failed += len(series)
+		return
+	}
+	if l.series == nil {
+		l.series = map[string]float64{}
+	}
+	for _, s := range series {
+		if s.Counter {
+			// counters that the sink can't tell apart add up
+			l.series[s.Key] += s.Value
+		} else {
+			l.series[s.Key] = s.Value
+		}
+	}
+}
+
+// take returns the series that were sent, and the number of series
+// that failed to be sent, since the last call, and empties the log.
+func (l *seriesLog) take() (map[string]float64, int) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	series, failed := l.series, l.failed
+	l.series = nil
+	l.failed = 0
+	return series, failed
+}
+
+// seriesDiff is the difference between the series that two sinks
+// flushed in one interval.
+type seriesDiff struct {
+	fromSeries int
+	toSeries   int
+	// series that the sinks failed to send:
+	fromFailed int
+	toFailed   int
+	// series that only one of the sinks flushed:
+	missingFrom []string
+	missingTo   []string
+	// series whose values differ by more than the tolerance:
+	mismatched []string
+}
+
+// compare compares the series that the sinks sent since the last
+// comparison. It must be called once the sinks' flushes have returned.
+func (m *sinkMigration) compare() seriesDiff {
+	from, fromFailed := m.fromLog.take()
+	to, toFailed := m.toLog.take()
+	diff := seriesDiff{
+		fromSeries: len(from),
+		toSeries:   len(to),
+		fromFailed: fromFailed,
+		toFailed:   toFailed,
+	}
+	for key, fromValue := range from {
+		toValue, ok := to[key]
+		if !ok {
+			diff.missingTo = append(diff.missingTo, key)
+			continue
+		}
+		if !withinTolerance(fromValue, toValue, m.tolerance) {
+			diff.mismatched = append(diff.mismatched, key)
+		}
+	}
+	for key := range to {
+		if _, ok := from[key]; !ok {
+			diff.missingFrom = append(diff.missingFrom, key)
+		}
+	}
+	sort.Strings(diff.missingFrom)
+	sort.Strings(diff.missingTo)
+	sort.Strings(diff.mismatched)
+	return diff
+}
+
+// withinTolerance returns whether a and b differ by at most tolerance,
+// relative to the larger of the two.
+func withinTolerance(a, b, tolerance float64) bool {
+	if a == b {
+		return true
+	}
+	return math.Abs(a-b) <= tolerance*math.Max(math.Abs(a), math.Abs(b))
+}
+
+// report logs the differences between the sinks and returns metrics
+// counting them.
+func (m *sinkMigration) report(diff seriesDiff) []*ssf.SSFSample {
+	from, to := m.from.Name(), m.to.Name()
+	if len(diff.missingFrom)+len(diff.missingTo)+len(diff.mismatched)+diff.fromFailed+diff.toFailed > 0 {
+		log.WithFields(logrus.Fields{
+			"from":             from,
+			"to":               to,
+			"missing_from":     seriesExamples(diff.missingFrom),
+			"missing_to":       seriesExamples(diff.missingTo),
+			"mismatched":       seriesExamples(diff.mismatched),
+			"missing_from_num": len(diff.missingFrom),
+			"missing_to_num":   len(diff.missingTo),
+			"mismatched_num":   len(diff.mismatched),
+			"failed_from_num":  diff.fromFailed,
+			"failed_to_num":    diff.toFailed,
+		}).Warn("Sinks being migrated between flushed different series")
+	}
+	return []*ssf.SSFSample{
+		ssf.Gauge("sink_migration.series", float32(diff.fromSeries), map[string]string{"sink": from}),
+		ssf.Gauge("sink_migration.series", float32(diff.toSeries), map[string]string{"sink": to}),
+		ssf.Count("sink_migration.missing_series_total", float32(len(diff.missingFrom)), map[string]string{"sink": from}),
+		ssf.Count("sink_migration.missing_series_total", float32(len(diff.missingTo)), map[string]string{"sink": to}),
+		ssf.Count("sink_migration.value_mismatches_total", float32(len(diff.mismatched)), nil),
+		ssf.Count("sink_migration.failed_series_total", float32(diff.fromFailed), map[string]string{"sink": from}),
+		ssf.Count("sink_migration.failed_series_total", float32(diff.toFailed), map[string]string{"sink": to}),
+	}
+}
+
+// seriesExamples returns at most maxLoggedSeriesDiffs of the given series.
+func seriesExamples(series []string) []string {
+	if len(series) > maxLoggedSeriesDiffs {
+		return series[:maxLoggedSeriesDiffs]
+	}
+	return series
+}
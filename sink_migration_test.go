@@ -0,0 +1,136 @@
+package veneur
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks"
+	"github.com/stripe/veneur/sinks/blackhole"
+)
+
+// recordingSink is a metric sink that records the series it flushes,
+// in one request per flush. If filter is set, it drops the metrics
+// whose names start with "drop.", and drops the tag "env" from the
+// others. If err is set, its requests fail with it.
+type recordingSink struct {
+	sinks.MetricSink
+	name   string
+	filter bool
+	err    error
+	record func([]sinks.FlushedSeries, error)
+}
+
+func (rs *recordingSink) Name() string {
+	return rs.name
+}
+
+func (rs *recordingSink) RecordSeries(record func([]sinks.FlushedSeries, error)) {
+	rs.record = record
+}
+
+func (rs *recordingSink) Flush(ctx context.Context, metrics []samplers.InterMetric) error {
+	series := []sinks.FlushedSeries{}
+	for _, m := range metrics {
+		if !sinks.IsAcceptableMetric(m, rs) {
+			continue
+		}
+		tags := m.Tags
+		if rs.filter {
+			if strings.HasPrefix(m.Name, "drop.") {
+				continue
+			}
+			tags = []string{}
+			for _, tag := range m.Tags {
+				if !strings.HasPrefix(tag, "env:") {
+					tags = append(tags, tag)
+				}
+			}
+		}
+		series = append(series, sinks.FlushedSeries{
+			Key:     sinks.SeriesKey(m.Name, tags),
+			Value:   m.Value,
+			Counter: m.Type == samplers.CounterMetric,
+		})
+	}
+	rs.record(series, rs.err)
+	return nil
+}
+
+func TestNewSinkMigration(t *testing.T) {
+	bh, err := blackhole.NewBlackholeMetricSink()
+	require.NoError(t, err)
+	from := &recordingSink{MetricSink: bh, name: "from"}
+	to := &recordingSink{MetricSink: bh, name: "to"}
+	metricSinks := []sinks.MetricSink{bh, from, to}
+
+	m, err := newSinkMigration("from", "to", 0, metricSinks)
+	require.NoError(t, err)
+	assert.Equal(t, "from", m.from.Name())
+	assert.Equal(t, "to", m.to.Name())
+	assert.NotNil(t, from.record, "the sinks should record their series")
+	assert.NotNil(t, to.record, "the sinks should record their series")
+
+	_, err = newSinkMigration("from", "from", 0, metricSinks)
+	assert.Error(t, err, "a sink can't be migrated to itself")
+	_, err = newSinkMigration("datadog", "to", 0, metricSinks)
+	assert.Error(t, err, "the sinks must be configured")
+	_, err = newSinkMigration("blackhole", "to", 0, metricSinks)
+	assert.Error(t, err, "the sinks must record their series")
+}
+
+func TestSinkMigrationCompare(t *testing.T) {
+	bh, err := blackhole.NewBlackholeMetricSink()
+	require.NoError(t, err)
+	from := &recordingSink{MetricSink: bh, name: "plain"}
+	to := &recordingSink{MetricSink: bh, name: "filtering", filter: true}
+	m, err := newSinkMigration("plain", "filtering", 0.1, []sinks.MetricSink{from, to})
+	require.NoError(t, err)
+
+	metrics := []samplers.InterMetric{
+		{Name: "a.b.c", Tags: []string{"foo:bar"}, Value: 1, Type: samplers.GaugeMetric},
+		{Name: "drop.me", Value: 1, Type: samplers.GaugeMetric},
+		// the filtering sink can't tell these apart:
+		{Name: "counter", Tags: []string{"env:dev"}, Value: 10, Type: samplers.CounterMetric},
+		{Name: "counter", Value: 1, Type: samplers.CounterMetric},
+		// and only sends one of these:
+		{Name: "gauge", Tags: []string{"env:dev"}, Value: 10, Type: samplers.GaugeMetric},
+		{Name: "gauge", Value: 10.5, Type: samplers.GaugeMetric},
+		{Name: "sinkonly", Value: 1, Type: samplers.GaugeMetric,
+			Sinks: samplers.RouteInformation{"filtering": struct{}{}}},
+	}
+
+	require.NoError(t, from.Flush(context.Background(), metrics))
+	require.NoError(t, to.Flush(context.Background(), metrics))
+	diff := m.compare()
+	assert.Equal(t, 6, diff.fromSeries)
+	assert.Equal(t, 4, diff.toSeries)
+	assert.Equal(t, []string{"sinkonly|"}, diff.missingFrom)
+	assert.Equal(t, []string{"counter|env:dev", "drop.me|", "gauge|env:dev"}, diff.missingTo)
+	assert.Equal(t, []string{"counter|"}, diff.mismatched,
+		"the summed counter should be off, the gauges within the tolerance")
+
+	diff = m.compare()
+	assert.Equal(t, 0, diff.fromSeries+diff.toSeries, "each comparison should start over")
+
+	to.err = errors.New("request failed")
+	require.NoError(t, from.Flush(context.Background(), metrics))
+	require.NoError(t, to.Flush(context.Background(), metrics))
+	diff = m.compare()
+	assert.Equal(t, 0, diff.toSeries)
+	assert.Equal(t, 6, diff.toFailed, "every series in a failed request should count as failed")
+	assert.Len(t, diff.missingTo, 6, "a failed request should miss its series")
+
+	samples := m.report(diff)
+	require.Len(t, samples, 7)
+	assert.Equal(t, "sink_migration.missing_series_total", samples[3].Name)
+	assert.Equal(t, "filtering", samples[3].Tags["sink"])
+	assert.Equal(t, float32(6), samples[3].Value)
+	assert.Equal(t, "sink_migration.failed_series_total", samples[6].Name)
+	assert.Equal(t, "filtering", samples[6].Tags["sink"])
+	assert.Equal(t, float32(6), samples[6].Value)
+}
@@ -0,0 +1,284 @@
+package veneur
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/ssf"
+	"golang.org/x/net/context"
+)
+
+const (
+	// sinkPauseDrop drops what a paused sink would have been sent.
+	sinkPauseDrop = "drop"
+	// sinkPauseBuffer keeps the metrics and spans that a paused sink
+	// would have been sent, up to a limit, and sends them once the
+	// sink is resumed.
+	sinkPauseBuffer = "buffer"
+)
+
+// sinkPauses holds the pause state of every sink, by name, so that
+// sinks can be paused and resumed through the HTTP API without
+// restarting veneur, for example while a vendor's API is having an
+// incident. Pausing a name pauses both the metric and the span sink
+// with that name.
+type sinkPauses struct {
+	sinks map[string]*sinkPause
+}
+
+// sinkPause is the pause state of one sink. Its methods are safe to
+// call on a nil *sinkPause, which is never paused.
+type sinkPause struct {
+	name   string
+	buffer bool
+	// bufferSize is the largest number of metrics, and of spans,
+	// kept while paused.
+	bufferSize int
+
+	// paused is 1 while the sink is paused, accessed atomically
+	paused int32
+	// dropped counts the metrics, spans and other samples dropped
+	// since the last report, updated atomically
+	dropped int64
+
+	mtx           sync.Mutex
+	since         time.Time
+	buffered      []samplers.InterMetric
+	bufferedSpans []*ssf.SSFSpan
+}
+
+// newSinkPauses returns the pause state of the named sinks, which are
+// paused according to policy: either "drop" or "buffer", in which case
+// up to bufferSize metrics and bufferSize spans are kept for each paused
+// sink.
+func newSinkPauses(policy string, bufferSize int, names []string) (*sinkPauses, error) {
+	if policy == "" {
+		policy = sinkPauseDrop
+	}
+	if policy != sinkPauseDrop && policy != sinkPauseBuffer {
+		return nil, fmt.Errorf("sink_pause_policy: unknown policy %q, must be %q or %q", policy, sinkPauseDrop, sinkPauseBuffer)
+	}
+	p := &sinkPauses{sinks: map[string]*sinkPause{}}
+	for _, name := range names {
+		p.sinks[name] = &sinkPause{
+			name:       name,
+			buffer:     policy == sinkPauseBuffer,
+			bufferSize: bufferSize,
+		}
+	}
+	return p, nil
+}
+
+// lookup returns the pause state of the sink with the given name, or
+// nil if there is none.
+func (p *sinkPauses) lookup(name string) *sinkPause {
+	if p == nil {
+		return nil
+	}
+	return p.sinks[name]
+}
+
+// isPaused returns whether the sink is paused.
+func (sp *sinkPause) isPaused() bool {
+	return sp != nil && atomic.LoadInt32(&sp.paused) == 1
+}
+
+// holdSpan buffers or drops a span instead of having the sink ingest
+// it, if the sink is paused, and returns whether it did. Paused span
+// sinks don't ingest spans at all, so that they can't flush them from
+// buffers of their own, e.g. in the background.
+func (sp *sinkPause) holdSpan(span *ssf.SSFSpan) bool {
+	if !sp.isPaused() {
+		return false
+	}
+	sp.mtx.Lock()
+	defer sp.mtx.Unlock()
+	if atomic.LoadInt32(&sp.paused) == 0 {
+		// resumed in the meantime
+		return false
+	}
+	if sp.buffer && len(sp.bufferedSpans) < sp.bufferSize {
+		sp.bufferedSpans = append(sp.bufferedSpans, span)
+	} else {
+		sp.drop(1)
+	}
+	return true
+}
+
+// spansToIngest returns the spans that were buffered while the sink
+// was paused, once it's resumed, and empties the buffer.
+func (sp *sinkPause) spansToIngest() []*ssf.SSFSpan {
+	if sp == nil || sp.isPaused() {
+		return nil
+	}
+	sp.mtx.Lock()
+	defer sp.mtx.Unlock()
+	spans := sp.bufferedSpans
+	sp.bufferedSpans = nil
+	return spans
+}
+
+// drop counts n things that the sink wasn't sent while paused.
+func (sp *sinkPause) drop(n int) {
+	atomic.AddInt64(&sp.dropped, int64(n))
+}
+
+// pause pauses the sink. It returns false if the sink was already
+// paused.
+func (sp *sinkPause) pause() bool {
+	sp.mtx.Lock()
+	defer sp.mtx.Unlock()
+	if !atomic.CompareAndSwapInt32(&sp.paused, 0, 1) {
+		return false
+	}
+	sp.since = time.Now()
+	return true
+}
+
+// resume resumes the sink. It returns false if the sink wasn't paused.
+func (sp *sinkPause) resume() bool {
+	sp.mtx.Lock()
+	defer sp.mtx.Unlock()
+	if !atomic.CompareAndSwapInt32(&sp.paused, 1, 0) {
+		return false
+	}
+	sp.since = time.Time{}
+	return true
+}
+
+// metricsToFlush returns the metrics to flush to the sink, and whether
+// to flush it at all. While the sink is paused, the metrics are
+// buffered or dropped; once it's resumed, the buffered metrics are
+// flushed with the next interval's.
+func (sp *sinkPause) metricsToFlush(metrics []samplers.InterMetric) ([]samplers.InterMetric, bool) {
+	if sp == nil {
+		return metrics, true
+	}
+	sp.mtx.Lock()
+	defer sp.mtx.Unlock()
+	if atomic.LoadInt32(&sp.paused) == 1 {
+		if !sp.buffer {
+			sp.drop(len(metrics))
+			return nil, false
+		}
+		keep := sp.bufferSize - len(sp.buffered)
+		if keep < 0 {
+			keep = 0
+		}
+		if keep > len(metrics) {
+			keep = len(metrics)
+		}
+		sp.buffered = append(sp.buffered, metrics[:keep]...)
+		sp.drop(len(metrics) - keep)
+		return nil, false
+	}
+	if len(sp.buffered) == 0 {
+		return metrics, true
+	}
+	flushed := append(sp.buffered, metrics...)
+	sp.buffered = nil
+	return flushed, true
+}
+
+// report returns which sinks are paused, and counters of what paused
+// sinks dropped since the last report.
+func (p *sinkPauses) report() []*ssf.SSFSample {
+	var samples []*ssf.SSFSample
+	for name, sp := range p.sinks {
+		tags := map[string]string{"sink": name}
+		if sp.isPaused() {
+			samples = append(samples, ssf.Gauge("sink.paused", 1, tags))
+		}
+		if n := atomic.SwapInt64(&sp.dropped, 0); n > 0 {
+			samples = append(samples, ssf.Count("sink.paused_dropped_total", float32(n), tags))
+		}
+	}
+	return samples
+}
+
+// sinkPauseStatus is the JSON representation of a sink's pause state.
+type sinkPauseStatus struct {
+	Sink          string     `json:"sink"`
+	Paused        bool       `json:"paused"`
+	Since         *time.Time `json:"since,omitempty"`
+	Buffered      int        `json:"buffered"`
+	BufferedSpans int        `json:"buffered_spans"`
+}
+
+func (s *Server) lookupSinkPause(w http.ResponseWriter, r *http.Request) *sinkPause {
+	name := r.URL.Query().Get("sink")
+	sp := s.sinkPauses.lookup(name)
+	if sp == nil {
+		http.Error(w, fmt.Sprintf("no sink named %q", name), http.StatusNotFound)
+		return nil
+	}
+	return sp
+}
+
+// handleSinkPauses lists the sinks and whether they are paused.
+func handleSinkPauses(s *Server) func(context.Context, http.ResponseWriter, *http.Request) {
+	return func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		statuses := make([]sinkPauseStatus, 0, len(s.sinkPauses.sinks))
+		for name, sp := range s.sinkPauses.sinks {
+			sp.mtx.Lock()
+			status := sinkPauseStatus{
+				Sink:          name,
+				Paused:        sp.isPaused(),
+				Buffered:      len(sp.buffered),
+				BufferedSpans: len(sp.bufferedSpans),
+			}
+			if !sp.since.IsZero() {
+				since := sp.since
+				status.Since = &since
+			}
+			sp.mtx.Unlock()
+			statuses = append(statuses, status)
+		}
+		sort.Slice(statuses, func(i, j int) bool {
+			return statuses[i].Sink < statuses[j].Sink
+		})
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(statuses); err != nil {
+			log.WithError(err).Warn("Could not encode sink pauses")
+		}
+	}
+}
+
+// handleSinkPause pauses a sink.
+func handleSinkPause(s *Server) func(context.Context, http.ResponseWriter, *http.Request) {
+	return func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		sp := s.lookupSinkPause(w, r)
+		if sp == nil {
+			return
+		}
+		if sp.pause() {
+			log.WithFields(logrus.Fields{
+				"sink":   sp.name,
+				"buffer": sp.buffer,
+			}).Warn("Paused sink")
+		}
+		w.Write([]byte("ok\n"))
+	}
+}
+
+// handleSinkResume resumes a paused sink.
+func handleSinkResume(s *Server) func(context.Context, http.ResponseWriter, *http.Request) {
+	return func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		sp := s.lookupSinkPause(w, r)
+		if sp == nil {
+			return
+		}
+		if sp.resume() {
+			log.WithField("sink", sp.name).Info("Resumed sink")
+		}
+		w.Write([]byte("ok\n"))
+	}
+}
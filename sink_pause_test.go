@@ -0,0 +1,161 @@
+package veneur
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks"
+	"github.com/stripe/veneur/ssf"
+)
+
+func TestNewSinkPauses(t *testing.T) {
+	_, err := newSinkPauses("queue", 10, []string{"datadog"})
+	assert.Error(t, err)
+
+	p, err := newSinkPauses("", 10, []string{"datadog"})
+	require.NoError(t, err)
+	assert.False(t, p.lookup("datadog").buffer, "the default policy should be to drop")
+	assert.Nil(t, p.lookup("signalfx"))
+
+	var nilPauses *sinkPauses
+	assert.Nil(t, nilPauses.lookup("datadog"))
+	var nilPause *sinkPause
+	assert.False(t, nilPause.isPaused())
+	assert.False(t, nilPause.holdSpan(&ssf.SSFSpan{}))
+	assert.Empty(t, nilPause.spansToIngest())
+}
+
+func TestSinkPauseDrop(t *testing.T) {
+	p, err := newSinkPauses(sinkPauseDrop, 10, []string{"datadog"})
+	require.NoError(t, err)
+	sp := p.lookup("datadog")
+	metrics := []samplers.InterMetric{{Name: "a"}, {Name: "b"}}
+
+	assert.True(t, sp.pause())
+	assert.False(t, sp.pause(), "pausing twice should do nothing")
+	assert.True(t, sp.holdSpan(&ssf.SSFSpan{}))
+	flushed, flush := sp.metricsToFlush(metrics)
+	assert.False(t, flush)
+	assert.Empty(t, flushed)
+
+	samples := p.report()
+	require.Len(t, samples, 2)
+	assert.Equal(t, "sink.paused", samples[0].Name)
+	assert.Equal(t, "sink.paused_dropped_total", samples[1].Name)
+	assert.Equal(t, float32(3), samples[1].Value)
+
+	assert.True(t, sp.resume())
+	assert.False(t, sp.resume(), "resuming twice should do nothing")
+	assert.False(t, sp.holdSpan(&ssf.SSFSpan{}))
+	assert.Empty(t, sp.spansToIngest(), "dropped spans shouldn't be ingested")
+	flushed, flush = sp.metricsToFlush(metrics)
+	assert.True(t, flush)
+	assert.Equal(t, metrics, flushed)
+	assert.Empty(t, p.report())
+}
+
+func TestSinkPauseBuffer(t *testing.T) {
+	p, err := newSinkPauses(sinkPauseBuffer, 3, []string{"datadog"})
+	require.NoError(t, err)
+	sp := p.lookup("datadog")
+
+	sp.pause()
+	for i := int64(1); i <= 4; i++ {
+		assert.True(t, sp.holdSpan(&ssf.SSFSpan{Id: i}), "paused span sinks shouldn't ingest spans")
+	}
+	assert.Empty(t, sp.spansToIngest(), "spans should stay buffered while paused")
+	_, flush := sp.metricsToFlush([]samplers.InterMetric{{Name: "a"}, {Name: "b"}})
+	assert.False(t, flush)
+	_, flush = sp.metricsToFlush([]samplers.InterMetric{{Name: "c"}, {Name: "d"}})
+	assert.False(t, flush)
+
+	sp.resume()
+	flushed, flush := sp.metricsToFlush([]samplers.InterMetric{{Name: "e"}})
+	assert.True(t, flush)
+	var names []string
+	for _, m := range flushed {
+		names = append(names, m.Name)
+	}
+	assert.Equal(t, []string{"a", "b", "c", "e"}, names, "the buffer should hold at most 3 metrics")
+
+	flushed, _ = sp.metricsToFlush([]samplers.InterMetric{{Name: "f"}})
+	assert.Len(t, flushed, 1, "the buffer should be emptied once flushed")
+
+	spans := sp.spansToIngest()
+	require.Len(t, spans, 3, "the buffer should hold at most 3 spans")
+	assert.Equal(t, int64(1), spans[0].Id)
+	assert.Empty(t, sp.spansToIngest(), "the buffer should be emptied once ingested")
+	assert.False(t, sp.holdSpan(&ssf.SSFSpan{}))
+}
+
+func TestSpanWorkerPausedSink(t *testing.T) {
+	fake := &fakeSpanSink{wg: &sync.WaitGroup{}}
+	sw := NewSpanWorker([]sinks.SpanSink{fake}, nil, nil, nil, nil)
+	p, err := newSinkPauses(sinkPauseBuffer, 10, []string{"fake"})
+	require.NoError(t, err)
+	sw.setSinkPauses(p)
+
+	p.lookup("fake").pause()
+	sw.ingest(0, &ssf.SSFSpan{Id: 1})
+	sw.ingest(0, &ssf.SSFSpan{Id: 2})
+	sw.Flush(context.Background())
+	assert.Empty(t, fake.spans, "a paused sink shouldn't ingest spans")
+
+	p.lookup("fake").resume()
+	fake.wg.Add(2)
+	sw.Flush(context.Background())
+	require.Len(t, fake.spans, 2, "the buffered spans should be ingested once the sink is resumed")
+	assert.Equal(t, int64(1), fake.spans[0].Id)
+}
+
+func TestSinkPauseEndpoints(t *testing.T) {
+	config := localConfig()
+	config.SinkPauseEnabled = true
+	s := setupVeneurServer(t, config, nil, nil, nil)
+	defer s.Shutdown()
+	handler := s.Handler()
+
+	request := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	w := request(http.MethodGet, "/sinks")
+	require.Equal(t, http.StatusOK, w.Code)
+	var statuses []sinkPauseStatus
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&statuses))
+	require.NotEmpty(t, statuses)
+	name := statuses[0].Sink
+	assert.False(t, statuses[0].Paused)
+
+	assert.Equal(t, http.StatusNotFound, request(http.MethodPost, "/sinks/pause?sink=nowhere").Code)
+	require.Equal(t, http.StatusOK, request(http.MethodPost, "/sinks/pause?sink="+name).Code)
+	assert.True(t, s.sinkPauses.lookup(name).isPaused())
+
+	w = request(http.MethodGet, "/sinks")
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&statuses))
+	assert.True(t, statuses[0].Paused)
+	assert.NotNil(t, statuses[0].Since)
+
+	require.Equal(t, http.StatusOK, request(http.MethodPost, "/sinks/resume?sink="+name).Code)
+	assert.False(t, s.sinkPauses.lookup(name).isPaused())
+}
+
+func TestSinkPauseDisabled(t *testing.T) {
+	config := localConfig()
+	s := setupVeneurServer(t, config, nil, nil, nil)
+	defer s.Shutdown()
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/sinks/pause?sink=datadog", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Nil(t, s.sinkPauses)
+}
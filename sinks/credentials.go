@@ -0,0 +1,122 @@
+package sinks
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/stripe/veneur/ssf"
+)
+
+// MetricKeyCredentialFailoverTotal is emitted as a counter by sinks
+// with Credentials, tagged with `sink:sink.Name()`, every time they
+// switch from one of their keys to the other.
+const MetricKeyCredentialFailoverTotal = "sink.credential_failover_total"
+
+// MetricKeySecondaryCredentialActive is emitted as a gauge by sinks
+// with Credentials, tagged with `sink:sink.Name()`: 1 while they
+// authenticate with their secondary key, 0 while they use the primary.
+const MetricKeySecondaryCredentialActive = "sink.secondary_credential_active"
+
+// Credentials holds the API key or token that a sink authenticates
+// with, along with an optional secondary key that the sink fails over
+// to when its backend rejects the key in use. This smooths over key
+// rotation with backends that take a while to activate new keys: the
+// new key is configured as the secondary, and the sink starts using
+// it once the old one is revoked.
+type Credentials struct {
+	keys      atomic.Value // [2]string
+	active    int32
+	failovers int64
+}
+
+// NewCredentials returns Credentials that start out using primary. If
+// secondary is empty, they never fail over.
+func NewCredentials(primary, secondary string) *Credentials {
+	c := &Credentials{}
+	c.keys.Store([2]string{primary, secondary})
+	return c
+}
+
+// Current returns the key to authenticate with.
+func (c *Credentials) Current() string {
+	return c.keys.Load().([2]string)[atomic.LoadInt32(&c.active)]
+}
+
+// Replace replaces the keys, for example with newly rotated ones, and
+// starts using primary. It returns false, and keeps using the key in
+// use, if the keys didn't change.
+func (c *Credentials) Replace(primary, secondary string) bool {
+	keys := [2]string{primary, secondary}
+	if c.keys.Load().([2]string) == keys {
+		return false
+	}
+	c.keys.Store(keys)
+	atomic.StoreInt32(&c.active, 0)
+	return true
+}
+
+// Rejected records that the backend refused key. If key is the one in
+// use and there is another to fail over to, the Credentials switch to
+// it and Rejected returns true. Rejections of a key that has already
+// been switched away from are ignored, so that requests in flight
+// during a switch don't switch back.
+func (c *Credentials) Rejected(key string) bool {
+	keys := c.keys.Load().([2]string)
+	if keys[1] == "" {
+		return false
+	}
+	active := atomic.LoadInt32(&c.active)
+	if keys[active] != key {
+		return false
+	}
+	if !atomic.CompareAndSwapInt32(&c.active, active, 1-active) {
+		return false
+	}
+	atomic.AddInt64(&c.failovers, 1)
+	return true
+}
+
+// Report returns the failover metrics of the sink named sink since
+// the last call to Report. It returns nothing if there is no secondary
+// key.
+func (c *Credentials) Report(sink string) []*ssf.SSFSample {
+	if c.keys.Load().([2]string)[1] == "" {
+		return nil
+	}
+	tags := map[string]string{"sink": sink}
+	return []*ssf.SSFSample{
+		ssf.Count(MetricKeyCredentialFailoverTotal, float32(atomic.SwapInt64(&c.failovers, 0)), tags),
+		ssf.Gauge(MetricKeySecondaryCredentialActive, float32(atomic.LoadInt32(&c.active)), tags),
+	}
+}
+
+// ReadCredentialsFile reads a file that holds a sink's key on its
+// first line, and optionally a secondary key on its second line, so
+// that keys can be rotated by rewriting the file.
+func ReadCredentialsFile(path string) (primary, secondary string, err error) {
+	bts, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	lines := strings.Split(strings.TrimSpace(string(bts)), "\n")
+	if len(lines) > 2 {
+		return "", "", fmt.Errorf("%s holds %d lines, not a key and an optional secondary key", path, len(lines))
+	}
+	primary = strings.TrimSpace(lines[0])
+	if primary == "" {
+		return "", "", fmt.Errorf("%s holds no key", path)
+	}
+	if len(lines) == 2 {
+		secondary = strings.TrimSpace(lines[1])
+	}
+	return primary, secondary, nil
+}
+
+// IsAuthFailure returns true if an HTTP response status code means
+// that the backend rejected a sink's credentials.
+func IsAuthFailure(status int) bool {
+	return status == http.StatusUnauthorized || status == http.StatusForbidden
+}
@@ -0,0 +1,78 @@
+package sinks
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCredentialsFailover(t *testing.T) {
+	c := NewCredentials("old", "new")
+	assert.Equal(t, "old", c.Current())
+
+	assert.True(t, c.Rejected("old"))
+	assert.Equal(t, "new", c.Current())
+	assert.False(t, c.Rejected("old"), "a rejection of the key already switched away from shouldn't switch back")
+	assert.Equal(t, "new", c.Current())
+
+	samples := c.Report("test")
+	if assert.Len(t, samples, 2) {
+		assert.Equal(t, MetricKeyCredentialFailoverTotal, samples[0].Name)
+		assert.Equal(t, float32(1), samples[0].Value)
+		assert.Equal(t, MetricKeySecondaryCredentialActive, samples[1].Name)
+		assert.Equal(t, float32(1), samples[1].Value)
+		assert.Equal(t, "test", samples[1].Tags["sink"])
+	}
+
+	assert.True(t, c.Rejected("new"))
+	assert.Equal(t, "old", c.Current())
+	samples = c.Report("test")
+	assert.Equal(t, float32(1), samples[0].Value)
+	assert.Equal(t, float32(0), samples[1].Value)
+}
+
+func TestCredentialsNoSecondary(t *testing.T) {
+	c := NewCredentials("only", "")
+	assert.False(t, c.Rejected("only"))
+	assert.Equal(t, "only", c.Current())
+	assert.Empty(t, c.Report("test"))
+}
+
+func TestCredentialsReplace(t *testing.T) {
+	c := NewCredentials("old", "new")
+	assert.True(t, c.Rejected("old"))
+	assert.False(t, c.Replace("old", "new"), "unchanged keys shouldn't switch back")
+	assert.Equal(t, "new", c.Current())
+
+	assert.True(t, c.Replace("new", "newer"))
+	assert.Equal(t, "new", c.Current())
+	assert.True(t, c.Rejected("new"))
+	assert.Equal(t, "newer", c.Current())
+}
+
+func TestReadCredentialsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "veneur-credentials")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "token")
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("primary\n"), 0600))
+	primary, secondary, err := ReadCredentialsFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "primary", primary)
+	assert.Equal(t, "", secondary)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("primary\nsecondary\n"), 0600))
+	primary, secondary, err = ReadCredentialsFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "primary", primary)
+	assert.Equal(t, "secondary", secondary)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("\n"), 0600))
+	_, _, err = ReadCredentialsFile(path)
+	assert.Error(t, err)
+}
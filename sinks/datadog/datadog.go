@@ -4,9 +4,13 @@ import (
 	"container/ring"
 	"context"
 	"fmt"
+	"math"
 	"net/http"
+	"net/url"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -41,6 +45,81 @@ type DatadogMetricSink struct {
 	interval        float64
 	traceClient     *trace.Client
 	log             *logrus.Logger
+
+	// ApplicationKey, if set, lets the sink set the units of the
+	// metrics it flushes through Datadog's metric metadata API.
+	ApplicationKey string
+	metadataMtx    sync.Mutex
+	unitsSent      map[string]string
+	// unitsSending is 1 while units are being sent in the
+	// background.
+	unitsSending int32
+
+	// SecondaryAPIKey, if set, is used in place of APIKey once
+	// Datadog rejects APIKey, and vice versa.
+	SecondaryAPIKey string
+	keysOnce        sync.Once
+	keys            *sinks.Credentials
+
+	// ValidateAPIKey makes Start fail if Datadog rejects the API key
+	// (and the secondary one) at DDHostname with a 403.
+	ValidateAPIKey bool
+
+	// Distributions makes the sink send the distributions that
+	// FlushWithDistributions gets through Datadog's distribution
+	// API, in place of the percentiles of the histograms and timers
+	// that they were flushed from.
+	Distributions bool
+
+	// recordSeries, if set, is called with the series of every
+	// request that the sink sends.
+	recordSeries func([]sinks.FlushedSeries, error)
+}
+
+// validateTimeout bounds the request that validates the API key.
+const validateTimeout = 10 * time.Second
+
+// distributionsPerBody limits the number of distributions that are
+// sent in one request, and distributionValuesPerBody the number of
+// values that they hold in total. maxDistributionValues limits the
+// values of each distribution, so that the size of a flush depends on
+// the number of series and not on the number of samples.
+const (
+	distributionsPerBody      = 100
+	distributionValuesPerBody = 50000
+	maxDistributionValues     = 10000
+)
+
+var _ sinks.DistributionSink = &DatadogMetricSink{}
+
+// maxMetadataPerFlush limits the number of metric metadata updates
+// that are sent in one flush. Any more are sent in later flushes.
+const maxMetadataPerFlush = 100
+
+// ddUnits maps the unit symbols that SSF clients use (see
+// ssf.TimeUnit) to Datadog's unit names. Units that aren't listed
+// here are passed on unchanged, so clients can use Datadog's names
+// directly.
+var ddUnits = map[string]string{
+	"ns":  "nanosecond",
+	"µs":  "microsecond",
+	"us":  "microsecond",
+	"ms":  "millisecond",
+	"s":   "second",
+	"min": "minute",
+	"h":   "hour",
+	"B":   "byte",
+	"KiB": "kibibyte",
+	"MiB": "mebibyte",
+	"GiB": "gibibyte",
+	"%":   "percent",
+}
+
+// DDMetricMetadata is the part of Datadog's metric metadata that the
+// sink updates.
+type DDMetricMetadata struct {
+	Unit    string `json:"unit"`
+	PerUnit string `json:"per_unit,omitempty"`
 }
 
 // DDEvent represents the structure of datadog's undocumented /intake endpoint
@@ -68,6 +147,18 @@ type DDMetric struct {
 	Interval   int32         `json:"interval,omitempty"`
 }
 
+// DDDistribution is a data structure that represents the JSON that
+// Datadog wants when posting distributions to the API. Its point is
+// a timestamp and the list of values.
+type DDDistribution struct {
+	Name       string            `json:"metric"`
+	Points     [1][2]interface{} `json:"points"`
+	Tags       []string          `json:"tags,omitempty"`
+	MetricType string            `json:"type"`
+	Hostname   string            `json:"host,omitempty"`
+	DeviceName string            `json:"device_name,omitempty"`
+}
+
 // DDServiceCheck is a representation of the service check.
 type DDServiceCheck struct {
 	Name      string   `json:"check"`
@@ -100,21 +191,44 @@ func (dd *DatadogMetricSink) Name() string {
 // Start sets the sink up.
 func (dd *DatadogMetricSink) Start(cl *trace.Client) error {
 	dd.traceClient = cl
+	if dd.ValidateAPIKey {
+		ctx, cancel := context.WithTimeout(context.Background(), validateTimeout)
+		defer cancel()
+		return dd.validateAPIKey(ctx)
+	}
 	return nil
 }
 
 // Flush sends metrics to Datadog
 func (dd *DatadogMetricSink) Flush(ctx context.Context, interMetrics []samplers.InterMetric) error {
+	return dd.FlushWithDistributions(ctx, interMetrics, nil)
+}
+
+// FlushWithDistributions sends metrics to Datadog and, with
+// Distributions, the distributions through Datadog's distribution API
+// in place of the percentiles of the histograms and timers that they
+// were flushed from.
+func (dd *DatadogMetricSink) FlushWithDistributions(ctx context.Context, interMetrics, distributionMetrics []samplers.InterMetric) error {
 	span, _ := trace.StartSpanFromContext(ctx, "")
 	defer span.ClientFinish(dd.traceClient)
 
+	var distributions []DDDistribution
+	var droppedSamples int
+	if dd.Distributions && len(distributionMetrics) > 0 {
+		interMetrics, distributions, droppedSamples = dd.finalizeDistributions(interMetrics, distributionMetrics)
+	}
 	ddmetrics, checks := dd.finalizeMetrics(interMetrics)
 
 	if len(checks) != 0 {
 		// this endpoint is not documented to take an array... but it does
 		// another curious constraint of this endpoint is that it does not
 		// support "Content-Encoding: deflate"
-		err := vhttp.PostHelper(context.TODO(), dd.HTTPClient, dd.traceClient, http.MethodPost, fmt.Sprintf("%s/api/v1/check_run?api_key=%s", dd.DDHostname, dd.APIKey), checks, "flush_checks", false, map[string]string{"sink": "datadog"}, dd.log)
+		err := dd.post(span.Attach(ctx), http.MethodPost, func(apiKey string) string {
+			return fmt.Sprintf("%s/api/v1/check_run?api_key=%s", dd.DDHostname, apiKey)
+		}, checks, "flush_checks", false)
+		if dd.recordSeries != nil {
+			dd.recordSeries(checkSeries(checks), err)
+		}
 		if err == nil {
 			dd.log.WithField("checks", len(checks)).Info("Completed flushing service checks to Datadog")
 		} else {
@@ -142,95 +256,190 @@ func (dd *DatadogMetricSink) Flush(ctx context.Context, interMetrics []samplers.
 		wg.Add(1)
 		go dd.flushPart(span.Attach(ctx), chunk, &wg)
 	}
+	for _, chunk := range distributionBodies(distributions) {
+		wg.Add(1)
+		go dd.flushDistributions(span.Attach(ctx), chunk, &wg)
+	}
 	wg.Wait()
 	tags := map[string]string{"sink": dd.Name()}
 	span.Add(
 		ssf.Timing(sinks.MetricKeyMetricFlushDuration, time.Since(flushStart), time.Nanosecond, tags),
-		ssf.Count(sinks.MetricKeyTotalMetricsFlushed, float32(len(ddmetrics)), tags),
+		ssf.Count(sinks.MetricKeyTotalMetricsFlushed, float32(len(ddmetrics)+len(distributions)), tags),
+		ssf.Count("datadog.distribution_samples_dropped_total", float32(droppedSamples), tags),
 	)
+	span.Add(dd.apiKeys().Report(dd.Name())...)
 	dd.log.WithField("metrics", len(ddmetrics)).Info("Completed flush to Datadog")
+
+	if dd.ApplicationKey != "" {
+		dd.flushUnits(interMetrics)
+	}
 	return nil
 }
 
+// unitUpdate is the metadata that sets the unit of a metric.
+type unitUpdate struct {
+	name, unit string
+	metadata   DDMetricMetadata
+}
+
+// flushUnits sets the units of the flushed metrics whose units Datadog
+// hasn't been told about yet, in the background, so that the metadata
+// API doesn't hold up the flush. While the updates of an earlier flush
+// are still being sent, it leaves them to a later flush.
+func (dd *DatadogMetricSink) flushUnits(metrics []samplers.InterMetric) {
+	if !atomic.CompareAndSwapInt32(&dd.unitsSending, 0, 1) {
+		return
+	}
+	updates := dd.unitUpdates(metrics)
+	if len(updates) == 0 {
+		atomic.StoreInt32(&dd.unitsSending, 0)
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&dd.unitsSending, 0)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(dd.interval*float64(time.Second)))
+		defer cancel()
+		dd.sendUnits(ctx, updates)
+	}()
+}
+
+// unitUpdates returns the updates for up to maxMetadataPerFlush of the
+// metrics whose units haven't been sent yet.
+func (dd *DatadogMetricSink) unitUpdates(metrics []samplers.InterMetric) []unitUpdate {
+	dd.metadataMtx.Lock()
+	defer dd.metadataMtx.Unlock()
+
+	var updates []unitUpdate
+	seen := map[string]bool{}
+	for _, m := range metrics {
+		if len(updates) >= maxMetadataPerFlush {
+			break
+		}
+		if m.Unit == "" || seen[m.Name] || dd.unitsSent[m.Name] == m.Unit || !sinks.IsAcceptableMetric(m, dd) {
+			continue
+		}
+		metadata := DDMetricMetadata{Unit: m.Unit}
+		if unit, ok := ddUnits[m.Unit]; ok {
+			metadata.Unit = unit
+		}
+		switch m.Type {
+		case samplers.CounterMetric:
+			// counters are sent as rates
+			metadata.PerUnit = "second"
+		case samplers.GaugeMetric:
+		default:
+			continue
+		}
+		seen[m.Name] = true
+		updates = append(updates, unitUpdate{name: m.Name, unit: m.Unit, metadata: metadata})
+	}
+	return updates
+}
+
+// sendUnits sends unit updates to the metadata API one by one.
+func (dd *DatadogMetricSink) sendUnits(ctx context.Context, updates []unitUpdate) {
+	for _, u := range updates {
+		if ctx.Err() != nil {
+			return
+		}
+		err := dd.post(ctx, http.MethodPut, func(apiKey string) string {
+			return fmt.Sprintf("%s/api/v1/metrics/%s?api_key=%s&application_key=%s", dd.DDHostname, url.PathEscape(u.name), apiKey, dd.ApplicationKey)
+		}, u.metadata, "flush_metadata", false)
+		if err != nil {
+			// try again next flush
+			dd.log.WithError(err).WithField("metric", u.name).Warn("Could not set the unit of a metric in Datadog")
+			continue
+		}
+		dd.metadataMtx.Lock()
+		if dd.unitsSent == nil {
+			dd.unitsSent = map[string]string{}
+		}
+		dd.unitsSent[u.name] = u.unit
+		dd.metadataMtx.Unlock()
+	}
+}
+
+// eventTagKeys are the tags that carry the fields of a Datadog event
+// that don't fit into an SSF sample.
+type eventTagKeys struct {
+	identifier, aggregation, priority, source, alertType, hostname string
+}
+
+var (
+	// dogstatsdEventTags are the tags that the DogStatsD parser
+	// encodes events with.
+	dogstatsdEventTags = eventTagKeys{
+		identifier:  dogstatsd.EventIdentifierKey,
+		aggregation: dogstatsd.EventAggregationKeyTagKey,
+		priority:    dogstatsd.EventPriorityTagKey,
+		source:      dogstatsd.EventSourceTypeTagKey,
+		alertType:   dogstatsd.EventAlertTypeTagKey,
+		hostname:    dogstatsd.EventHostnameTagKey,
+	}
+	// ssfEventTags are the tags that SSF-native events set the fields
+	// with, named like the fields.
+	ssfEventTags = eventTagKeys{
+		identifier:  ssf.EventTag,
+		aggregation: "aggregation_key",
+		priority:    "priority",
+		source:      "source_type_name",
+		alertType:   "alert_type",
+	}
+)
+
 // FlushOtherSamples serializes Events or Service Checks directly to datadog.
-// May make 2 external calls to the datadog client.
+// May make 3 external calls to the datadog client.
 func (dd *DatadogMetricSink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {
 
 	events := []DDEvent{}
+	checks := []DDServiceCheck{}
 
 	span, _ := trace.StartSpanFromContext(ctx, "")
 	defer span.ClientFinish(dd.traceClient)
 
-	// fill in the default hostname for packets that didn't set it
 	for _, sample := range samples {
-
 		if _, ok := sample.Tags[dogstatsd.EventIdentifierKey]; ok {
 			// This is an event!
-			ret := DDEvent{
-				Title:     sample.Name,
-				Text:      sample.Message,
-				Timestamp: sample.Timestamp,
-				Priority:  "normal",
-				AlertType: "info",
-			}
-
-			// Defensively copy the tags that came in
-			tags := map[string]string{}
-			for k, v := range sample.Tags {
-				tags[k] = v
-			}
-			// Remove the tag that flagged this as an event
-			delete(tags, dogstatsd.EventIdentifierKey)
-
-			// The parser uses special tags to encode the fields for us from DogStatsD
-			// that don't fit into a normal SSF Sample. We'll hunt for each one and
-			// delete the tag if we find it.
-			if v, ok := tags[dogstatsd.EventAggregationKeyTagKey]; ok {
-				ret.Aggregation = v
-				delete(tags, dogstatsd.EventAggregationKeyTagKey)
-			}
-			if v, ok := tags[dogstatsd.EventPriorityTagKey]; ok {
-				ret.Priority = v
-				delete(tags, dogstatsd.EventPriorityTagKey)
-			}
-			if v, ok := tags[dogstatsd.EventSourceTypeTagKey]; ok {
-				ret.Source = v
-				delete(tags, dogstatsd.EventSourceTypeTagKey)
-			}
-			if v, ok := tags[dogstatsd.EventAlertTypeTagKey]; ok {
-				ret.AlertType = v
-				delete(tags, dogstatsd.EventAlertTypeTagKey)
-			}
-			if v, ok := tags[dogstatsd.EventHostnameTagKey]; ok {
-				ret.Hostname = v
-				delete(tags, dogstatsd.EventHostnameTagKey)
+			events = append(events, dd.event(sample, dogstatsdEventTags))
+		} else if _, ok := sample.Tags[ssf.EventTag]; ok {
+			// This is an SSF-native event or service check!
+			if sample.Metric == ssf.SSFSample_STATUS {
+				checks = append(checks, dd.serviceCheck(sample))
 			} else {
-				// Default hostname since there isn't one
-				ret.Hostname = dd.hostname
+				// SSF timestamps are in nanoseconds:
+				sample.Timestamp /= int64(time.Second)
+				events = append(events, dd.event(sample, ssfEventTags))
 			}
-			// Do our last bit of tag housekeeping
-			finalTags := []string{}
-			for k, v := range tags {
-				finalTags = append(finalTags, fmt.Sprintf("%s:%s", k, v))
-			}
-
-			ret.Tags = append(finalTags, dd.tags...)
-			events = append(events, ret)
 		} else {
 			dd.log.Warn("Received an SSF Sample that wasn't an event or service check, ack!")
 		}
 	}
 
+	if len(checks) != 0 {
+		err := dd.post(span.Attach(ctx), http.MethodPost, func(apiKey string) string {
+			return fmt.Sprintf("%s/api/v1/check_run?api_key=%s", dd.DDHostname, apiKey)
+		}, checks, "flush_checks", false)
+		if err == nil {
+			dd.log.WithField("checks", len(checks)).Info("Completed flushing service checks to Datadog")
+		} else {
+			dd.log.WithFields(logrus.Fields{
+				"checks":        len(checks),
+				logrus.ErrorKey: err}).Warn("Error flushing checks to Datadog")
+		}
+	}
+
 	if len(events) != 0 {
 		// this endpoint is not documented at all, its existence is only known from
 		// the official dd-agent
 		// we don't actually pass all the body keys that dd-agent passes here... but
 		// it still works
-		err := vhttp.PostHelper(context.Background(), dd.HTTPClient, dd.traceClient, http.MethodPost, fmt.Sprintf("%s/intake?api_key=%s", dd.DDHostname, dd.APIKey), map[string]map[string][]DDEvent{
+		err := dd.post(span.Attach(ctx), http.MethodPost, func(apiKey string) string {
+			return fmt.Sprintf("%s/intake?api_key=%s", dd.DDHostname, apiKey)
+		}, map[string]map[string][]DDEvent{
 			"events": {
 				"api": events,
 			},
-		}, "flush_events", true, map[string]string{"sink": "datadog"}, dd.log)
+		}, "flush_events", true)
 
 		if err == nil {
 			dd.log.WithField("events", len(events)).Info("Completed flushing events to Datadog")
@@ -242,36 +451,125 @@ func (dd *DatadogMetricSink) FlushOtherSamples(ctx context.Context, samples []ss
 	}
 }
 
+// event converts an event sample, whose fields are encoded in the
+// given tags, into a Datadog event.
+func (dd *DatadogMetricSink) event(sample ssf.SSFSample, keys eventTagKeys) DDEvent {
+	ret := DDEvent{
+		Title:     sample.Name,
+		Text:      sample.Message,
+		Timestamp: sample.Timestamp,
+		Priority:  "normal",
+		AlertType: "info",
+	}
+
+	// Defensively copy the tags that came in
+	tags := map[string]string{}
+	for k, v := range sample.Tags {
+		tags[k] = v
+	}
+	// Remove the tag that flagged this as an event
+	delete(tags, keys.identifier)
+
+	// The producers use special tags to encode the fields for us
+	// that don't fit into a normal SSF Sample. We'll hunt for each
+	// one and delete the tag if we find it.
+	if v, ok := tags[keys.aggregation]; ok {
+		ret.Aggregation = v
+		delete(tags, keys.aggregation)
+	}
+	if v, ok := tags[keys.priority]; ok {
+		ret.Priority = v
+		delete(tags, keys.priority)
+	}
+	if v, ok := tags[keys.source]; ok {
+		ret.Source = v
+		delete(tags, keys.source)
+	}
+	if v, ok := tags[keys.alertType]; ok {
+		ret.AlertType = v
+		delete(tags, keys.alertType)
+	}
+	if v, ok := tags[keys.hostname]; ok && keys.hostname != "" {
+		ret.Hostname = v
+		delete(tags, keys.hostname)
+	} else {
+		// Default hostname since there isn't one
+		ret.Hostname = dd.hostname
+	}
+	ret.Tags = dd.sampleTags(tags)
+	return ret
+}
+
+// serviceCheck converts an SSF-native service check sample into a
+// Datadog service check.
+func (dd *DatadogMetricSink) serviceCheck(sample ssf.SSFSample) DDServiceCheck {
+	tags := make(map[string]string, len(sample.Tags))
+	for k, v := range sample.Tags {
+		if k != ssf.EventTag {
+			tags[k] = v
+		}
+	}
+	return DDServiceCheck{
+		Name:     sample.Name,
+		Status:   int(sample.Status),
+		Hostname: dd.hostname,
+		Message:  sample.Message,
+		Tags:     dd.sampleTags(tags),
+		// SSF timestamps are in nanoseconds:
+		Timestamp: sample.Timestamp / int64(time.Second),
+	}
+}
+
+// sampleTags returns the tags of an event or service check, followed
+// by the sink's tags.
+func (dd *DatadogMetricSink) sampleTags(tags map[string]string) []string {
+	// Do our last bit of tag housekeeping
+	finalTags := []string{}
+	for k, v := range tags {
+		finalTags = append(finalTags, fmt.Sprintf("%s:%s", k, v))
+	}
+	// tags is a map, so sort them to send the same event the same way
+	// every time.
+	sort.Strings(finalTags)
+	return append(finalTags, dd.tags...)
+}
+
+// finalizeMetrics converts the flushed metrics into Datadog's metrics
+// and service checks, on every core.
 func (dd *DatadogMetricSink) finalizeMetrics(metrics []samplers.InterMetric) ([]DDMetric, []DDServiceCheck) {
+	shards := sinks.EncodeShards(metrics, func(shard []samplers.InterMetric) finalizedShard {
+		var f finalizedShard
+		f.metrics, f.checks = dd.finalizeShard(shard)
+		return f
+	})
 	ddMetrics := make([]DDMetric, 0, len(metrics))
 	checks := []DDServiceCheck{}
+	for _, f := range shards {
+		ddMetrics = append(ddMetrics, f.metrics...)
+		checks = append(checks, f.checks...)
+	}
+	return ddMetrics, checks
+}
+
+// finalizedShard is what finalizeShard converted a shard of the
+// flushed metrics into.
+type finalizedShard struct {
+	metrics []DDMetric
+	checks  []DDServiceCheck
+}
+
+// finalizeShard converts a shard of the flushed metrics into Datadog's
+// metrics and service checks. It's called concurrently for every
+// shard.
+func (dd *DatadogMetricSink) finalizeShard(metrics []samplers.InterMetric) ([]DDMetric, []DDServiceCheck) {
+	ddMetrics := make([]DDMetric, 0, len(metrics))
+	var checks []DDServiceCheck
 
 	for _, m := range metrics {
 		if !sinks.IsAcceptableMetric(m, dd) {
 			continue
 		}
-		// Defensively copy tags since we're gonna mutate it
-		tags := make([]string, len(dd.tags))
-		copy(tags, dd.tags)
-		var hostname, devicename string
-		// Let's look for "magic tags" that override metric fields host and device.
-		for _, tag := range m.Tags {
-			// This overrides hostname
-			if strings.HasPrefix(tag, "host:") {
-				// Override the hostname with the tag, trimming off the prefix.
-				hostname = tag[5:]
-			} else if strings.HasPrefix(tag, "device:") {
-				// Same as above, but device this time
-				devicename = tag[7:]
-			} else {
-				// Add it, no reason to exclude it.
-				tags = append(tags, tag)
-			}
-		}
-		if hostname == "" {
-			// No magic tag, set the hostname
-			hostname = dd.hostname
-		}
+		tags, hostname, devicename := dd.metricTags(m)
 
 		if m.Type == samplers.StatusMetric {
 			// This is a service check!
@@ -321,11 +619,212 @@ func (dd *DatadogMetricSink) finalizeMetrics(metrics []samplers.InterMetric) ([]
 	return ddMetrics, checks
 }
 
+// finalizeDistributions returns the points of the distributions, the
+// number of samples that were dropped to fit them into
+// maxDistributionValues values each, and the metrics without the
+// percentiles of the histograms and timers that they replace: the
+// gauges named after a distribution with a ".<n>percentile" suffix, and
+// with the same tags.
+func (dd *DatadogMetricSink) finalizeDistributions(metrics, distributions []samplers.InterMetric) ([]samplers.InterMetric, []DDDistribution, int) {
+	var points []DDDistribution
+	dropped := 0
+	replaced := map[string]bool{}
+	for _, m := range distributions {
+		if !sinks.IsAcceptableMetric(m, dd) {
+			continue
+		}
+		tags, hostname, devicename := dd.metricTags(m)
+		values, d := distributionValues(m.Values, m.Weights, maxDistributionValues)
+		dropped += d
+		if len(values) > 0 {
+			points = append(points, DDDistribution{
+				Name:       m.Name,
+				Points:     [1][2]interface{}{{m.Timestamp, values}},
+				Tags:       tags,
+				MetricType: "distribution",
+				Hostname:   hostname,
+				DeviceName: devicename,
+			})
+		}
+		replaced[m.Name+"|"+strings.Join(m.Tags, ",")] = true
+	}
+	if len(points) == 0 {
+		return metrics, nil, dropped
+	}
+
+	kept := make([]samplers.InterMetric, 0, len(metrics))
+	for _, m := range metrics {
+		if m.Type == samplers.GaugeMetric && strings.HasSuffix(m.Name, "percentile") {
+			if i := strings.LastIndex(m.Name, "."); i > 0 && replaced[m.Name[:i]+"|"+strings.Join(m.Tags, ",")] {
+				continue
+			}
+		}
+		kept = append(kept, m)
+	}
+	return kept, points, dropped
+}
+
+// distributionValues expands weighted values into at most maxValues
+// values, since Datadog's distribution API takes every sample, without
+// weights, and returns them with the number of samples that didn't fit.
+// If the weights add up to more than maxValues, they are all scaled
+// down by the same factor, so that every value keeps its share of the
+// distribution. The weights are rounded by their running total, so that
+// light values add up instead of each rounding to nothing.
+func distributionValues(values, weights []float64, maxValues int) ([]float64, int) {
+	total := 0.0
+	for _, w := range weights {
+		total += w
+	}
+	scale := 1.0
+	if total > float64(maxValues) {
+		scale = float64(maxValues) / total
+	}
+
+	var expanded []float64
+	running := 0.0
+	for i, v := range values {
+		running += weights[i] * scale
+		for len(expanded) < int(math.Round(running)) {
+			expanded = append(expanded, v)
+		}
+	}
+	return expanded, int(math.Round(total)) - len(expanded)
+}
+
+// distributionBodies splits distribution points into the bodies of
+// requests, each of at most distributionsPerBody points and
+// distributionValuesPerBody values.
+func distributionBodies(distributions []DDDistribution) [][]DDDistribution {
+	var bodies [][]DDDistribution
+	start, values := 0, 0
+	for i, d := range distributions {
+		n := len(d.Points[0][1].([]float64))
+		if i > start && (i-start == distributionsPerBody || values+n > distributionValuesPerBody) {
+			bodies = append(bodies, distributions[start:i])
+			start, values = i, 0
+		}
+		values += n
+	}
+	if start < len(distributions) {
+		bodies = append(bodies, distributions[start:])
+	}
+	return bodies
+}
+
+// metricTags returns the tags that a metric is sent with, and the
+// hostname and device name that its magic tags (or the sink's
+// hostname) set.
+func (dd *DatadogMetricSink) metricTags(m samplers.InterMetric) (tags []string, hostname, devicename string) {
+	// Defensively copy tags since we're gonna mutate it
+	tags = make([]string, len(dd.tags))
+	copy(tags, dd.tags)
+	// Let's look for "magic tags" that override metric fields host and device.
+	for _, tag := range m.Tags {
+		// This overrides hostname
+		if strings.HasPrefix(tag, "host:") {
+			// Override the hostname with the tag, trimming off the prefix.
+			hostname = tag[5:]
+		} else if strings.HasPrefix(tag, "device:") {
+			// Same as above, but device this time
+			devicename = tag[7:]
+		} else {
+			// Add it, no reason to exclude it.
+			tags = append(tags, tag)
+		}
+	}
+	if hostname == "" {
+		// No magic tag, set the hostname
+		hostname = dd.hostname
+	}
+	return tags, hostname, devicename
+}
+
+// RecordSeries makes the sink report the series and service checks
+// that it sends.
+func (dd *DatadogMetricSink) RecordSeries(record func([]sinks.FlushedSeries, error)) {
+	dd.recordSeries = record
+}
+
+// flushedSeries returns the series that metrics are sent to Datadog
+// as, identified by their name, tags, host and device.
+func (dd *DatadogMetricSink) flushedSeries(metrics []DDMetric) []sinks.FlushedSeries {
+	series := make([]sinks.FlushedSeries, 0, len(metrics))
+	for _, m := range metrics {
+		tags := append(append([]string{}, m.Tags...), "host:"+m.Hostname)
+		if m.DeviceName != "" {
+			tags = append(tags, "device:"+m.DeviceName)
+		}
+		value := m.Value[0][1]
+		counter := m.MetricType == "rate"
+		if counter {
+			// counters are compared as counts, not rates:
+			value *= dd.interval
+		}
+		series = append(series, sinks.FlushedSeries{
+			Key:     sinks.SeriesKey(m.Name, tags),
+			Value:   value,
+			Counter: counter,
+		})
+	}
+	return series
+}
+
+// checkSeries returns the series that service checks are sent to
+// Datadog as.
+func checkSeries(checks []DDServiceCheck) []sinks.FlushedSeries {
+	series := make([]sinks.FlushedSeries, 0, len(checks))
+	for _, c := range checks {
+		tags := append(append([]string{}, c.Tags...), "host:"+c.Hostname)
+		series = append(series, sinks.FlushedSeries{
+			Key:   sinks.SeriesKey(c.Name, tags),
+			Value: float64(c.Status),
+		})
+	}
+	return series
+}
+
 func (dd *DatadogMetricSink) flushPart(ctx context.Context, metricSlice []DDMetric, wg *sync.WaitGroup) {
 	defer wg.Done()
-	vhttp.PostHelper(ctx, dd.HTTPClient, dd.traceClient, http.MethodPost, fmt.Sprintf("%s/api/v1/series?api_key=%s", dd.DDHostname, dd.APIKey), map[string][]DDMetric{
+	err := dd.post(ctx, http.MethodPost, func(apiKey string) string {
+		return fmt.Sprintf("%s/api/v1/series?api_key=%s", dd.DDHostname, apiKey)
+	}, map[string][]DDMetric{
 		"series": metricSlice,
-	}, "flush", true, map[string]string{"sink": "datadog"}, dd.log)
+	}, "flush", true)
+	if dd.recordSeries != nil {
+		dd.recordSeries(dd.flushedSeries(metricSlice), err)
+	}
+}
+
+func (dd *DatadogMetricSink) flushDistributions(ctx context.Context, distributions []DDDistribution, wg *sync.WaitGroup) {
+	defer wg.Done()
+	dd.post(ctx, http.MethodPost, func(apiKey string) string {
+		return fmt.Sprintf("%s/api/v1/distribution_points?api_key=%s", dd.DDHostname, apiKey)
+	}, map[string][]DDDistribution{
+		"series": distributions,
+	}, "flush_distributions", true)
+}
+
+// apiKeys returns the sink's API keys.
+func (dd *DatadogMetricSink) apiKeys() *sinks.Credentials {
+	dd.keysOnce.Do(func() {
+		dd.keys = sinks.NewCredentials(dd.APIKey, dd.SecondaryAPIKey)
+	})
+	return dd.keys
+}
+
+// post sends body to the Datadog API endpoint that endpoint returns
+// for the API key in use, and switches to the other API key if
+// Datadog rejects it.
+func (dd *DatadogMetricSink) post(ctx context.Context, method string, endpoint func(apiKey string) string, body interface{}, action string, compress bool) error {
+	apiKey := dd.apiKeys().Current()
+	err := vhttp.PostHelper(ctx, dd.HTTPClient, dd.traceClient, method, endpoint(apiKey), body, action, compress, map[string]string{"sink": "datadog"}, dd.log)
+	if statusErr, ok := err.(vhttp.StatusError); ok && sinks.IsAuthFailure(statusErr.StatusCode) {
+		if dd.apiKeys().Rejected(apiKey) {
+			dd.log.WithField("status", statusErr.StatusCode).Warn("Datadog rejected the API key, switching to the other one")
+		}
+	}
+	return err
 }
 
 // DatadogTraceSpan represents a trace span as JSON for the
@@ -399,7 +898,7 @@ func (dd *DatadogSpanSink) Ingest(span *ssf.SSFSpan) error {
 // Flush signals the sink to send it's spans to their destination. For this
 // sync it means we'll be making an HTTP request to send them along. We assume
 // it's beneficial to performance to defer these until the normal 10s flush.
-func (dd *DatadogSpanSink) Flush() {
+func (dd *DatadogSpanSink) Flush(ctx context.Context) {
 	samples := &ssf.Samples{}
 	defer metrics.Report(dd.traceClient, samples)
 	dd.mutex.Lock()
@@ -430,7 +929,8 @@ func (dd *DatadogSpanSink) Flush() {
 				tags["type"] = "tooLate"
 			}
 			if timeErr != "" {
-				samples.Add(ssf.Count("worker.trace.sink.timestamp_error", 1, tags))
+				samples.Add(ssf.Count("worker.trace.sink.timestamp_error", 1, tags,
+					ssf.Failure(dd.Name(), ssf.CauseParseError)))
 			}
 
 			ssfSpans = append(ssfSpans, ssfSpan)
@@ -446,6 +946,7 @@ func (dd *DatadogSpanSink) Flush() {
 	serviceCount := make(map[string]int64)
 	// Datadog wants the spans for each trace in an array, so make a map.
 	traceMap := map[int64][]*DatadogTraceSpan{}
+	var traceIDs []int64
 	// Convert the SSFSpans into Datadog Spans
 	for _, span := range ssfSpans {
 		// -1 is a canonical way of passing in invalid info in Go
@@ -496,15 +997,15 @@ func (dd *DatadogSpanSink) Flush() {
 		serviceCount[span.Service]++
 		if _, ok := traceMap[span.TraceId]; !ok {
 			traceMap[span.TraceId] = []*DatadogTraceSpan{}
+			traceIDs = append(traceIDs, span.TraceId)
 		}
 		traceMap[span.TraceId] = append(traceMap[span.TraceId], ddspan)
 	}
 	// Smush the spans into a two-dimensional array now that they are grouped by trace id.
-	finalTraces := make([][]*DatadogTraceSpan, len(traceMap))
-	idx := 0
-	for _, val := range traceMap {
-		finalTraces[idx] = val
-		idx++
+	// Traces keep the order their first spans were flushed in.
+	finalTraces := make([][]*DatadogTraceSpan, len(traceIDs))
+	for idx, traceID := range traceIDs {
+		finalTraces[idx] = traceMap[traceID]
 	}
 
 	if len(finalTraces) != 0 {
@@ -512,7 +1013,7 @@ func (dd *DatadogSpanSink) Flush() {
 		// another curious constraint of this endpoint is that it does not
 		// support "Content-Encoding: deflate"
 
-		err := vhttp.PostHelper(context.TODO(), dd.HTTPClient, dd.traceClient, http.MethodPut, fmt.Sprintf("%s/v0.3/traces", dd.traceAddress), finalTraces, "flush_traces", false, map[string]string{"sink": "datadog"}, dd.log)
+		err := vhttp.PostHelper(ctx, dd.HTTPClient, dd.traceClient, http.MethodPut, fmt.Sprintf("%s/v0.3/traces", dd.traceAddress), finalTraces, "flush_traces", false, map[string]string{"sink": "datadog"}, dd.log)
 		if err == nil {
 			dd.log.WithField("traces", len(finalTraces)).Info("Completed flushing traces to Datadog")
 		} else {
@@ -4,18 +4,23 @@ import (
 	"compress/zlib"
 	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/internal/sinktest"
 	"github.com/stripe/veneur/protocol/dogstatsd"
 	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks"
 	"github.com/stripe/veneur/ssf"
 )
 
@@ -115,6 +120,34 @@ func TestDeviceMagicTag(t *testing.T) {
 	assert.Contains(t, ddMetrics[0].Tags, "x:e", "Last tag is still around")
 }
 
+func TestFinalizeMetricsInShards(t *testing.T) {
+	ddSink := DatadogMetricSink{hostname: "globalstats"}
+
+	var metrics []samplers.InterMetric
+	for i := 0; i < 10000; i++ {
+		metrics = append(metrics, samplers.InterMetric{
+			Name: fmt.Sprintf("a.b.c%d", i),
+			Type: samplers.GaugeMetric,
+		})
+		if i%1000 == 0 {
+			metrics = append(metrics, samplers.InterMetric{
+				Name: fmt.Sprintf("check%d", i),
+				Type: samplers.StatusMetric,
+			})
+		}
+	}
+
+	ddMetrics, serviceChecks := ddSink.finalizeMetrics(metrics)
+	require.Len(t, ddMetrics, 10000)
+	for i, m := range ddMetrics {
+		assert.Equal(t, fmt.Sprintf("a.b.c%d", i), m.Name, "the metrics should stay in order")
+	}
+	require.Len(t, serviceChecks, 10)
+	for i, c := range serviceChecks {
+		assert.Equal(t, fmt.Sprintf("check%d", i*1000), c.Name, "the service checks should stay in order")
+	}
+}
+
 func TestNewDatadogSpanSinkConfig(t *testing.T) {
 	// test the variables that have been renamed
 	ddSink, err := NewDatadogSpanSink("http://example.com", 100, &http.Client{}, logrus.New())
@@ -187,10 +220,60 @@ func TestDatadogFlushSpans(t *testing.T) {
 	err = ddSink.Ingest(testSpan)
 	assert.NoError(t, err)
 
-	ddSink.Flush()
+	ddSink.Flush(context.Background())
 	assert.Equal(t, true, transport.GotCalled, "Did not call spans endpoint")
 }
 
+func TestDatadogFlushDeadline(t *testing.T) {
+	srv, stop := sinktest.HangingServer()
+	defer stop()
+
+	ddSink, err := NewDatadogMetricSink(10, 2500, "example.com", nil, srv.URL, "secret", &http.Client{}, logrus.New())
+	require.NoError(t, err)
+	ddSink.ApplicationKey = "secret"
+
+	sinktest.AssertFlushDeadline(t, func(ctx context.Context) {
+		ddSink.Flush(ctx, []samplers.InterMetric{{
+			Name:      "a.b.c",
+			Timestamp: time.Now().Unix(),
+			Value:     1,
+			Type:      samplers.GaugeMetric,
+			Unit:      "second",
+		}, {
+			Name:      "a.b.status",
+			Timestamp: time.Now().Unix(),
+			Value:     1,
+			Type:      samplers.StatusMetric,
+		}})
+		ddSink.FlushOtherSamples(ctx, []ssf.SSFSample{{
+			Name:      "an event",
+			Message:   "something happened",
+			Timestamp: time.Now().Unix(),
+			Tags:      map[string]string{dogstatsd.EventIdentifierKey: ""},
+		}})
+	})
+}
+
+func TestDatadogFlushSpansDeadline(t *testing.T) {
+	srv, stop := sinktest.HangingServer()
+	defer stop()
+
+	ddSink, err := NewDatadogSpanSink(srv.URL, 100, &http.Client{}, logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, ddSink.Ingest(&ssf.SSFSpan{
+		TraceId:        1,
+		Id:             2,
+		StartTimestamp: time.Now().UnixNano(),
+		EndTimestamp:   time.Now().Add(time.Second).UnixNano(),
+		Service:        "farts-srv",
+		Name:           "farting farty farts",
+	}))
+
+	sinktest.AssertFlushDeadline(t, func(ctx context.Context) {
+		ddSink.Flush(ctx)
+	})
+}
+
 type result struct {
 	received  bool
 	contained bool
@@ -371,6 +454,66 @@ func TestDatadogFlushOtherMetricsForServiceChecks(t *testing.T) {
 	assert.Equal(t, false, transport.GotCalled, "Was not supposed to log a service check in the FlushOtherSamples")
 }
 
+func TestDatadogFlushSSFEvents(t *testing.T) {
+	transport := &DatadogRoundTripper{Endpoint: "/intake", Contains: ""}
+	ddSink, err := NewDatadogMetricSink(10, 2500, "example.com", []string{"gloobles:toots"}, "http://example.com", "secret", &http.Client{Transport: transport}, logrus.New())
+	assert.NoError(t, err)
+
+	ddSink.FlushOtherSamples(context.TODO(), []ssf.SSFSample{{
+		Name:      "deploy",
+		Message:   "deployed the api",
+		Timestamp: 1136239445 * int64(time.Second),
+		Tags: map[string]string{
+			ssf.EventTag:      "",
+			"alert_type":      "success",
+			"aggregation_key": "deploys",
+			"foo":             "bar",
+		},
+	}})
+
+	require.True(t, transport.GotCalled, "Did not call endpoint")
+	ddEvents := DDEventRequest{}
+	require.NoError(t, json.Unmarshal([]byte(transport.Contents), &ddEvents))
+	require.Len(t, ddEvents.Events.Api, 1)
+	assert.Equal(t, DDEvent{
+		Title:       "deploy",
+		Text:        "deployed the api",
+		Timestamp:   1136239445,
+		Hostname:    "example.com",
+		Aggregation: "deploys",
+		Priority:    "normal",
+		AlertType:   "success",
+		Tags:        []string{"foo:bar", "gloobles:toots"},
+	}, ddEvents.Events.Api[0])
+}
+
+func TestDatadogFlushSSFServiceChecks(t *testing.T) {
+	transport := &DatadogRoundTripper{Endpoint: "/api/v1/check_run", Contains: ""}
+	ddSink, err := NewDatadogMetricSink(10, 2500, "example.com", []string{"gloobles:toots"}, "http://example.com", "secret", &http.Client{Transport: transport}, logrus.New())
+	assert.NoError(t, err)
+
+	ddSink.FlushOtherSamples(context.TODO(), []ssf.SSFSample{{
+		Metric:    ssf.SSFSample_STATUS,
+		Name:      "api.up",
+		Message:   "the api is slow",
+		Status:    ssf.SSFSample_WARNING,
+		Timestamp: 1136239445 * int64(time.Second),
+		Tags:      map[string]string{ssf.EventTag: "", "foo": "bar"},
+	}})
+
+	require.True(t, transport.GotCalled, "Did not call endpoint")
+	var checks []DDServiceCheck
+	require.NoError(t, json.Unmarshal([]byte(transport.Contents), &checks))
+	assert.Equal(t, []DDServiceCheck{{
+		Name:      "api.up",
+		Status:    int(ssf.SSFSample_WARNING),
+		Hostname:  "example.com",
+		Timestamp: 1136239445,
+		Tags:      []string{"foo:bar", "gloobles:toots"},
+		Message:   "the api is slow",
+	}}, checks)
+}
+
 func TestDatadogFlushServiceCheck(t *testing.T) {
 	transport := &DatadogRoundTripper{Endpoint: "/api/v1/check_run", Contains: ""}
 	ddSink, err := NewDatadogMetricSink(10, 2500, "example.com", []string{"gloobles:toots"}, "http://example.com", "secret", &http.Client{Transport: transport}, logrus.New())
@@ -417,3 +560,314 @@ func TestDatadogFlushServiceCheck(t *testing.T) {
 	assert.Subset(t, ddFixtureCheck.Tags, ddChecks[0].Tags, "Check posted to DD does not have matching tags")
 
 }
+
+func TestDatadogFlushDistributions(t *testing.T) {
+	transport := &DatadogRoundTripper{Endpoint: "/api/v1/distribution_points"}
+	ddSink, err := NewDatadogMetricSink(10, 2500, "example.com", []string{"gloobles:toots"}, "http://example.com", "secret", &http.Client{Transport: transport}, logrus.New())
+	require.NoError(t, err)
+	distributions := []samplers.InterMetric{
+		{Name: "a.b.c", Type: samplers.DistributionMetric, Timestamp: 1136239445, Tags: []string{"foo:bar"}, Values: []float64{1, 2}, Weights: []float64{1, 2}},
+	}
+	metrics := []samplers.InterMetric{
+		{Name: "a.b.c.50percentile", Type: samplers.GaugeMetric, Tags: []string{"foo:bar"}, Value: 2},
+		{Name: "a.b.c.50percentile", Type: samplers.GaugeMetric, Tags: []string{"foo:baz"}, Value: 2},
+		{Name: "a.b.c.max", Type: samplers.GaugeMetric, Tags: []string{"foo:bar"}, Value: 2},
+	}
+
+	require.NoError(t, ddSink.FlushWithDistributions(context.TODO(), metrics, distributions))
+	assert.False(t, transport.GotCalled, "distributions shouldn't be sent unless enabled")
+
+	ddSink.Distributions = true
+	require.NoError(t, ddSink.FlushWithDistributions(context.TODO(), metrics, distributions))
+	require.True(t, transport.GotCalled)
+	var body struct {
+		Series []struct {
+			Metric string
+			Points [][2]json.RawMessage
+			Tags   []string
+			Type   string
+			Host   string
+		}
+	}
+	require.NoError(t, json.Unmarshal([]byte(transport.Contents), &body))
+	require.Len(t, body.Series, 1)
+	assert.Equal(t, "a.b.c", body.Series[0].Metric)
+	assert.Equal(t, "distribution", body.Series[0].Type)
+	assert.Equal(t, "example.com", body.Series[0].Host)
+	assert.Equal(t, []string{"gloobles:toots", "foo:bar"}, body.Series[0].Tags)
+	assert.JSONEq(t, "1136239445", string(body.Series[0].Points[0][0]))
+	assert.JSONEq(t, "[1, 2, 2]", string(body.Series[0].Points[0][1]), "each value should be repeated by its weight")
+
+	kept, _, _ := ddSink.finalizeDistributions(metrics, distributions)
+	assert.Equal(t, []samplers.InterMetric{metrics[1], metrics[2]}, kept,
+		"only the percentiles of the distribution's histogram should be replaced")
+}
+
+func TestDatadogDistributionValues(t *testing.T) {
+	values, dropped := distributionValues([]float64{1, 2, 3}, []float64{1, 3, 1}, 5)
+	assert.Equal(t, []float64{1, 2, 2, 2, 3}, values, "every sample should be sent")
+	assert.Equal(t, 0, dropped)
+
+	values, dropped = distributionValues([]float64{1, 2, 3}, []float64{0.4, 0.4, 1}, 5)
+	assert.Equal(t, []float64{2, 3}, values, "light values should add up")
+	assert.Equal(t, 0, dropped)
+
+	values, dropped = distributionValues([]float64{1, 2, 3}, []float64{2000, 6000, 2000}, 5)
+	assert.Equal(t, []float64{1, 2, 2, 2, 3}, values,
+		"values should be downsampled to maxValues, keeping their shares")
+	assert.Equal(t, 9995, dropped)
+
+	values, dropped = distributionValues([]float64{1}, []float64{1e7}, maxDistributionValues)
+	assert.Len(t, values, maxDistributionValues, "a hot distribution should be capped")
+	assert.Equal(t, 1e7-maxDistributionValues, float64(dropped))
+
+	var points []DDDistribution
+	for i := 0; i < distributionValuesPerBody/maxDistributionValues+1; i++ {
+		points = append(points, DDDistribution{Points: [1][2]interface{}{{0, values}}})
+	}
+	bodies := distributionBodies(points)
+	require.Len(t, bodies, 2, "no body should hold more than distributionValuesPerBody values")
+	assert.Len(t, bodies[1], 1)
+
+	points = make([]DDDistribution, distributionsPerBody+1)
+	for i := range points {
+		points[i] = DDDistribution{Points: [1][2]interface{}{{0, []float64{1}}}}
+	}
+	assert.Len(t, distributionBodies(points), 2, "no body should hold more than distributionsPerBody points")
+}
+
+type metadataRoundTripper struct {
+	mtx      sync.Mutex
+	metadata map[string]DDMetricMetadata
+}
+
+func (rt *metadataRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	if req.Method == http.MethodPut && strings.HasPrefix(req.URL.Path, "/api/v1/metrics/") {
+		var metadata DDMetricMetadata
+		if err := json.NewDecoder(req.Body).Decode(&metadata); err != nil {
+			return nil, err
+		}
+		rt.mtx.Lock()
+		rt.metadata[strings.TrimPrefix(req.URL.Path, "/api/v1/metrics/")] = metadata
+		rt.mtx.Unlock()
+	}
+	rec.WriteHeader(http.StatusAccepted)
+	return rec.Result(), nil
+}
+
+func TestDatadogFlushUnits(t *testing.T) {
+	transport := &metadataRoundTripper{metadata: map[string]DDMetricMetadata{}}
+	ddSink, err := NewDatadogMetricSink(10, 2500, "example.com", nil, "http://example.com", "secret", &http.Client{Transport: transport}, logrus.New())
+	require.NoError(t, err)
+	metrics := []samplers.InterMetric{
+		{Name: "request.duration.max", Type: samplers.GaugeMetric, Unit: "ms"},
+		{Name: "bytes.sent", Type: samplers.CounterMetric, Unit: "B"},
+		{Name: "widgets", Type: samplers.GaugeMetric, Unit: "widget"},
+		{Name: "no.unit", Type: samplers.GaugeMetric},
+	}
+
+	require.NoError(t, ddSink.Flush(context.TODO(), metrics))
+	assert.Empty(t, transport.metadata, "units shouldn't be set without an application key")
+
+	ddSink.ApplicationKey = "appkey"
+	require.NoError(t, ddSink.Flush(context.TODO(), metrics))
+	waitForUnits(ddSink)
+	assert.Equal(t, map[string]DDMetricMetadata{
+		"request.duration.max": {Unit: "millisecond"},
+		"bytes.sent":           {Unit: "byte", PerUnit: "second"},
+		"widgets":              {Unit: "widget"},
+	}, transport.metadata)
+
+	transport.metadata = map[string]DDMetricMetadata{}
+	require.NoError(t, ddSink.Flush(context.TODO(), metrics))
+	waitForUnits(ddSink)
+	assert.Empty(t, transport.metadata, "units should only be set once")
+}
+
+// waitForUnits waits until the sink sent the units of its last flush.
+func waitForUnits(dd *DatadogMetricSink) {
+	for atomic.LoadInt32(&dd.unitsSending) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// blockingMetadataRoundTripper holds metadata requests until release
+// is closed.
+type blockingMetadataRoundTripper struct {
+	release chan struct{}
+}
+
+func (rt *blockingMetadataRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	if req.Method == http.MethodPut {
+		<-rt.release
+	}
+	rec.WriteHeader(http.StatusAccepted)
+	return rec.Result(), nil
+}
+
+func TestDatadogFlushUnitsDoesNotBlock(t *testing.T) {
+	transport := &blockingMetadataRoundTripper{release: make(chan struct{})}
+	ddSink, err := NewDatadogMetricSink(10, 2500, "example.com", nil, "http://example.com", "secret", &http.Client{Transport: transport}, logrus.New())
+	require.NoError(t, err)
+	ddSink.ApplicationKey = "appkey"
+
+	done := make(chan error)
+	go func() {
+		done <- ddSink.Flush(context.TODO(), []samplers.InterMetric{
+			{Name: "request.duration.max", Type: samplers.GaugeMetric, Unit: "ms"},
+		})
+	}()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the flush waited for the metadata API")
+	}
+	close(transport.release)
+	waitForUnits(ddSink)
+}
+
+func TestDatadogRecordSeries(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/check_run" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	ddSink, err := NewDatadogMetricSink(10, 2500, "somehostname", []string{"a:b"}, srv.URL, "apikey", &http.Client{}, logrus.New())
+	require.NoError(t, err)
+	var mtx sync.Mutex
+	sent := map[string]float64{}
+	failed := map[string]float64{}
+	ddSink.RecordSeries(func(series []sinks.FlushedSeries, err error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		for _, s := range series {
+			if err != nil {
+				failed[s.Key] = s.Value
+			} else {
+				sent[s.Key] = s.Value
+			}
+		}
+	})
+
+	err = ddSink.Flush(context.Background(), []samplers.InterMetric{{
+		Name:  "foo.bar",
+		Value: 10,
+		Tags:  []string{"x:e", "device:sda"},
+		Type:  samplers.CounterMetric,
+	}, {
+		Name:  "foo.baz",
+		Type:  samplers.GaugeMetric,
+		Sinks: samplers.RouteInformation{"signalfx": struct{}{}},
+	}, {
+		Name:  "foo.check",
+		Value: 2,
+		Type:  samplers.StatusMetric,
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]float64{"foo.bar|a:b,device:sda,host:somehostname,x:e": 10}, sent,
+		"counters should be recorded as counts, not rates, and metrics routed elsewhere not at all")
+	assert.Equal(t, map[string]float64{"foo.check|a:b,host:somehostname": 2}, failed,
+		"the series of failed requests should be recorded as failed")
+}
+
+func TestDatadogAPIKeyFailover(t *testing.T) {
+	var mtx sync.Mutex
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Query().Get("api_key")
+		mtx.Lock()
+		keys = append(keys, key)
+		mtx.Unlock()
+		if key != "new" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	ddSink, err := NewDatadogMetricSink(10, 2500, "example.com", nil, srv.URL, "old", &http.Client{}, logrus.New())
+	require.NoError(t, err)
+	ddSink.SecondaryAPIKey = "new"
+
+	metrics := []samplers.InterMetric{{
+		Name:      "a.b.c",
+		Timestamp: time.Now().Unix(),
+		Value:     1,
+		Type:      samplers.GaugeMetric,
+	}}
+	require.NoError(t, ddSink.Flush(context.Background(), metrics))
+	require.NoError(t, ddSink.Flush(context.Background(), metrics))
+	assert.Equal(t, []string{"old", "new"}, keys)
+}
+
+func TestDatadogSite(t *testing.T) {
+	hostname, err := SiteAPIHostname("datadoghq.eu")
+	require.NoError(t, err)
+	assert.Equal(t, "https://api.datadoghq.eu", hostname)
+	_, err = SiteAPIHostname("datadoghq.example")
+	assert.Error(t, err)
+
+	assert.NoError(t, CheckSite("https://app.datadoghq.com", "datadoghq.com"))
+	assert.NoError(t, CheckSite("https://api.ddog-gov.com", "ddog-gov.com"))
+	assert.Error(t, CheckSite("https://app.datadoghq.com", "datadoghq.eu"))
+}
+
+func TestDatadogValidateAPIKey(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/validate", r.URL.Path)
+		if r.Header.Get("DD-API-KEY") != "good" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["Forbidden"]}`))
+			return
+		}
+		w.Write([]byte(`{"valid":true}`))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		primary, secondary string
+		valid              bool
+	}{
+		{"good", "", true},
+		{"bad", "good", true},
+		{"bad", "", false},
+		{"bad", "worse", false},
+	}
+	for _, test := range tests {
+		ddSink, err := NewDatadogMetricSink(10, 2500, "example.com", nil, srv.URL, test.primary, &http.Client{}, logrus.New())
+		require.NoError(t, err)
+		ddSink.SecondaryAPIKey = test.secondary
+		ddSink.ValidateAPIKey = true
+		err = ddSink.Start(nil)
+		if test.valid {
+			assert.NoError(t, err, "keys %q, %q", test.primary, test.secondary)
+		} else {
+			assert.Error(t, err, "keys %q, %q", test.primary, test.secondary)
+		}
+	}
+}
+
+func TestDatadogValidateAPIKeyUnavailable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	for _, hostname := range []string{srv.URL, down.URL} {
+		ddSink, err := NewDatadogMetricSink(10, 2500, "example.com", nil, hostname, "key", &http.Client{}, logrus.New())
+		require.NoError(t, err)
+		ddSink.ValidateAPIKey = true
+		assert.NoError(t, ddSink.Start(nil),
+			"the sink should start unless Datadog rejects the key with a 403")
+	}
+}
@@ -0,0 +1,90 @@
+package datadog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/stripe/veneur/sinks"
+)
+
+// sites are the Datadog sites (regions) that the sink can send data
+// to, by the name that Datadog's documentation gives them.
+var sites = map[string]bool{
+	"datadoghq.com":     true,
+	"us3.datadoghq.com": true,
+	"us5.datadoghq.com": true,
+	"ap1.datadoghq.com": true,
+	"datadoghq.eu":      true,
+	"ddog-gov.com":      true,
+}
+
+// SiteAPIHostname returns the base URL of the API of a Datadog site,
+// like "datadoghq.eu", to which the sink posts metrics, service checks
+// and events.
+func SiteAPIHostname(site string) (string, error) {
+	if !sites[site] {
+		return "", fmt.Errorf("unknown Datadog site %q", site)
+	}
+	return "https://api." + site, nil
+}
+
+// CheckSite returns an error unless hostname, a base URL like
+// datadog_api_hostname, belongs to a Datadog site. It catches
+// configurations that send data to one site with the API key of
+// another.
+func CheckSite(hostname, site string) error {
+	u, err := url.Parse(hostname)
+	if err != nil {
+		return err
+	}
+	host := u.Hostname()
+	if host != site && !strings.HasSuffix(host, "."+site) {
+		return fmt.Errorf("%q is not on the Datadog site %q", hostname, site)
+	}
+	return nil
+}
+
+// validateAPIKey asks Datadog whether the sink's API key is valid,
+// and returns an error if Datadog explicitly rejects it with a 403. If
+// there is a secondary API key, Datadog has to reject both for
+// validation to fail. Any other failure, like an unreachable API or a
+// 5xx status, is only logged, so that an outage at Datadog doesn't
+// keep veneur from starting.
+func (dd *DatadogMetricSink) validateAPIKey(ctx context.Context) error {
+	var status int
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		apiKey := dd.apiKeys().Current()
+		status, err = dd.validate(ctx, apiKey)
+		if err == nil || !sinks.IsAuthFailure(status) || !dd.apiKeys().Rejected(apiKey) {
+			break
+		}
+	}
+	if err != nil && status != http.StatusForbidden {
+		dd.log.WithError(err).Warn("Could not validate the Datadog API key, starting anyway")
+		return nil
+	}
+	return err
+}
+
+// validate returns the HTTP status of Datadog's API key validation
+// endpoint for apiKey, and an error if it rejected the key.
+func (dd *DatadogMetricSink) validate(ctx context.Context, apiKey string) (int, error) {
+	req, err := http.NewRequest(http.MethodGet, dd.DDHostname+"/api/v1/validate", nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("DD-API-KEY", apiKey)
+	resp, err := dd.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("datadog rejected the API key at %s: HTTP status %d", dd.DDHostname, resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
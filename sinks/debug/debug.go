@@ -116,6 +116,6 @@ func (b *debugSpanSink) Ingest(span *ssf.SSFSpan) error {
 	return nil
 }
 
-func (b *debugSpanSink) Flush() {
+func (b *debugSpanSink) Flush(context.Context) {
 	return
 }
@@ -1,3 +1,4 @@
+//go:build !go1.9
 // +build !go1.9
 
 package grpsink
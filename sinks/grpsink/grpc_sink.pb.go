@@ -2,13 +2,15 @@
 // source: sinks/grpsink/grpc_sink.proto
 
 /*
-	Package grpsink is a generated protocol buffer package.
+Package grpsink is a generated protocol buffer package.
 
-	It is generated from these files:
-		sinks/grpsink/grpc_sink.proto
+It is generated from these files:
 
-	It has these top-level messages:
-		Empty
+	sinks/grpsink/grpc_sink.proto
+
+It has these top-level messages:
+
+	Empty
 */
 package grpsink
 
@@ -143,7 +143,7 @@ func (gs *GRPCSpanSink) Ingest(ssfSpan *ssf.SSFSpan) error {
 //
 // No data is sent to the target sink by this call, as this sink dispatches all
 // spans directly via gRPC during Ingest().
-func (gs *GRPCSpanSink) Flush() {
+func (gs *GRPCSpanSink) Flush(context.Context) {
 	samples := &ssf.Samples{}
 	samples.Add(
 		ssf.Count(
@@ -154,6 +154,7 @@ func (gs *GRPCSpanSink) Flush() {
 			sinks.MetricKeyTotalSpansDropped,
 			float32(atomic.SwapUint32(&gs.dropCount, 0)),
 			map[string]string{"sink": gs.Name()},
+			ssf.Failure(gs.Name(), ssf.CauseIOError),
 		),
 	)
 
@@ -2,6 +2,8 @@ package kafka
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,6 +12,7 @@ import (
 	"math"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -32,8 +35,20 @@ const IngestTimeout = 5 * time.Second
 
 var IngestTimeoutError = errors.New("Timed out writing to Kafka producer")
 
+// The headers that idempotent sinks add to their messages. Together,
+// the producer ID and sequence number identify a message uniquely, so
+// consumers can drop the duplicates that producer retries cause. The
+// flush number groups the metrics that were flushed together.
+const (
+	ProducerIDHeader = "veneur-producer-id"
+	SequenceHeader   = "veneur-sequence"
+	FlushHeader      = "veneur-flush"
+)
+
 var _ sinks.MetricSink = &KafkaMetricSink{}
 var _ sinks.SpanSink = &KafkaSpanSink{}
+var _ sinks.Stopper = &KafkaMetricSink{}
+var _ sinks.Stopper = &KafkaSpanSink{}
 
 type KafkaMetricSink struct {
 	logger      *logrus.Entry
@@ -44,6 +59,8 @@ type KafkaMetricSink struct {
 	brokers     string
 	config      *sarama.Config
 	traceClient *trace.Client
+	idempotence *idempotence
+	gate        producerGate
 }
 
 type KafkaSpanSink struct {
@@ -57,10 +74,45 @@ type KafkaSpanSink struct {
 	config          *sarama.Config
 	spansFlushed    int64
 	traceClient     *trace.Client
+	idempotence     *idempotence
+	gate            producerGate
+}
+
+// idempotence numbers the messages of a producer, since the vendored
+// Kafka client can't make the broker deduplicate them.
+type idempotence struct {
+	producerID []byte
+	sequence   int64
+	flush      int64
+}
+
+func newIdempotence() (*idempotence, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return &idempotence{producerID: []byte(hex.EncodeToString(id))}, nil
+}
+
+// headers returns the headers of the producer's next message, with a
+// flush header if flush is positive.
+func (i *idempotence) headers(flush int64) []sarama.RecordHeader {
+	headers := []sarama.RecordHeader{
+		{Key: []byte(ProducerIDHeader), Value: i.producerID},
+		{Key: []byte(SequenceHeader), Value: []byte(strconv.FormatInt(atomic.AddInt64(&i.sequence, 1), 10))},
+	}
+	if flush > 0 {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(FlushHeader), Value: []byte(strconv.FormatInt(flush, 10))})
+	}
+	return headers
 }
 
 // NewKafkaMetricSink creates a new Kafka Plugin.
-func NewKafkaMetricSink(logger *logrus.Logger, cl *trace.Client, brokers string, checkTopic string, eventTopic string, metricTopic string, ackRequirement string, partitioner string, retries int, bufferBytes int, bufferMessages int, bufferDuration string) (*KafkaMetricSink, error) {
+//
+// If idempotent is set, the sink numbers its messages with headers that
+// let consumers drop duplicates, and configures the producer so that
+// retries don't reorder them. This needs Kafka 0.11 or later.
+func NewKafkaMetricSink(logger *logrus.Logger, cl *trace.Client, brokers string, checkTopic string, eventTopic string, metricTopic string, ackRequirement string, partitioner string, retries int, bufferBytes int, bufferMessages int, bufferDuration string, idempotent bool) (*KafkaMetricSink, error) {
 	if logger == nil {
 		logger = &logrus.Logger{Out: ioutil.Discard}
 	}
@@ -80,7 +132,15 @@ func NewKafkaMetricSink(logger *logrus.Logger, cl *trace.Client, brokers string,
 		}
 	}
 
-	config, _ := newProducerConfig(ll, ackRequirement, partitioner, retries, bufferBytes, bufferMessages, finalBufferDuration)
+	config, _ := newProducerConfig(ll, ackRequirement, partitioner, retries, bufferBytes, bufferMessages, finalBufferDuration, idempotent)
+
+	var idem *idempotence
+	if idempotent {
+		var err error
+		if idem, err = newIdempotence(); err != nil {
+			return nil, err
+		}
+	}
 
 	ll.WithFields(logrus.Fields{
 		"brokers":         brokers,
@@ -93,6 +153,7 @@ func NewKafkaMetricSink(logger *logrus.Logger, cl *trace.Client, brokers string,
 		"buffer_bytes":    bufferBytes,
 		"buffer_messages": bufferMessages,
 		"buffer_duration": bufferDuration,
+		"idempotent":      idempotent,
 	}).Info("Created Kafka metric sink")
 
 	return &KafkaMetricSink{
@@ -103,10 +164,11 @@ func NewKafkaMetricSink(logger *logrus.Logger, cl *trace.Client, brokers string,
 		brokers:     brokers,
 		config:      config,
 		traceClient: cl,
+		idempotence: idem,
 	}, nil
 }
 
-func newProducerConfig(logger *logrus.Entry, ackRequirement string, partitioner string, retries int, bufferBytes int, bufferMessages int, bufferFrequency time.Duration) (*sarama.Config, error) {
+func newProducerConfig(logger *logrus.Entry, ackRequirement string, partitioner string, retries int, bufferBytes int, bufferMessages int, bufferFrequency time.Duration, idempotent bool) (*sarama.Config, error) {
 
 	config := sarama.NewConfig()
 	// TODO Stringer?
@@ -142,6 +204,19 @@ func newProducerConfig(logger *logrus.Entry, ackRequirement string, partitioner
 
 	config.Producer.Retry.Max = retries
 
+	if idempotent {
+		// Message headers need Kafka 0.11.
+		config.Version = sarama.V0_11_0_0
+		// Retrying messages that weren't acknowledged by all
+		// replicas is what makes duplicates; with more than one
+		// request in flight, retries would also reorder them.
+		if config.Producer.RequiredAcks != sarama.WaitForAll {
+			logger.WithField("ack_requirement", ackRequirement).Warn("Idempotent Kafka sinks require acks from all replicas, ignoring ack requirement")
+			config.Producer.RequiredAcks = sarama.WaitForAll
+		}
+		config.Net.MaxOpenRequests = 1
+	}
+
 	// If either of these is set to true, you must
 	// read from the corresponding channels in a separate
 	// goroutine. Otherwise, the entire sink will back up.
@@ -170,6 +245,54 @@ func newConfiguredProducer(logger *logrus.Entry, brokerString string, config *sa
 	return producer, nil
 }
 
+// errProducerStopped is returned when a sink is asked to send messages
+// once it's stopped.
+var errProducerStopped = errors.New("the Kafka producer is stopped")
+
+// producerGate keeps a sink from sending messages to its producer once
+// the producer is closed, which would panic.
+type producerGate struct {
+	mtx     sync.RWMutex
+	stopped bool
+}
+
+// enter returns whether messages can be sent to the producer. If they
+// can, the producer isn't closed until leave is called.
+func (g *producerGate) enter() bool {
+	g.mtx.RLock()
+	if g.stopped {
+		g.mtx.RUnlock()
+		return false
+	}
+	return true
+}
+
+func (g *producerGate) leave() {
+	g.mtx.RUnlock()
+}
+
+// close waits until no messages are being sent to a producer, and
+// closes it, which sends the messages it still buffers first, or gives
+// up once the context is done.
+func (g *producerGate) close(ctx context.Context, producer sarama.AsyncProducer) error {
+	if producer == nil {
+		return nil
+	}
+	done := make(chan error, 1)
+	go func() {
+		g.mtx.Lock()
+		g.stopped = true
+		g.mtx.Unlock()
+		done <- producer.Close()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Name returns the name of this sink.
 func (k *KafkaMetricSink) Name() string {
 	return "kafka"
@@ -185,6 +308,12 @@ func (k *KafkaMetricSink) Start(cl *trace.Client) error {
 	return nil
 }
 
+// Stop waits for Flush to finish sending messages, sends the messages
+// that the producer still buffers, and closes it.
+func (k *KafkaMetricSink) Stop(ctx context.Context) error {
+	return k.gate.close(ctx, k.producer)
+}
+
 // Flush sends a slice of metrics to Kafka
 func (k *KafkaMetricSink) Flush(ctx context.Context, interMetrics []samplers.InterMetric) error {
 	samples := &ssf.Samples{}
@@ -194,7 +323,15 @@ func (k *KafkaMetricSink) Flush(ctx context.Context, interMetrics []samplers.Int
 		k.logger.Info("Nothing to flush, skipping.")
 		return nil
 	}
+	if !k.gate.enter() {
+		return errProducerStopped
+	}
+	defer k.gate.leave()
 
+	var flush int64
+	if k.idempotence != nil {
+		flush = atomic.AddInt64(&k.idempotence.flush, 1)
+	}
 	successes := int64(0)
 	for _, metric := range interMetrics {
 		if !sinks.IsAcceptableMetric(metric, k) {
@@ -205,14 +342,19 @@ func (k *KafkaMetricSink) Flush(ctx context.Context, interMetrics []samplers.Int
 		j, err := json.Marshal(metric)
 		if err != nil {
 			k.logger.Error("Error marshalling metric: ", metric.Name)
-			samples.Add(ssf.Count("kafka.marshal.error_total", 1, nil))
+			samples.Add(ssf.Count("kafka.marshal.error_total", 1, nil,
+				ssf.Failure(k.Name(), ssf.CauseEncodeError)))
 			return err
 		}
 
-		k.producer.Input() <- &sarama.ProducerMessage{
+		message := &sarama.ProducerMessage{
 			Topic: k.metricTopic,
 			Value: sarama.StringEncoder(j),
 		}
+		if k.idempotence != nil {
+			message.Headers = k.idempotence.headers(flush)
+		}
+		k.producer.Input() <- message
 		successes++
 	}
 	samples.Add(ssf.Count(sinks.MetricKeyTotalMetricsFlushed, float32(successes), map[string]string{"sink": k.Name()}))
@@ -225,7 +367,8 @@ func (k *KafkaMetricSink) FlushOtherSamples(ctx context.Context, samples []ssf.S
 }
 
 // NewKafkaSpanSink creates a new Kafka Plugin.
-func NewKafkaSpanSink(logger *logrus.Logger, cl *trace.Client, brokers string, topic string, partitioner string, ackRequirement string, retries int, bufferBytes int, bufferMessages int, bufferDuration string, serializationFormat string, sampleTag string, sampleRatePercentage int) (*KafkaSpanSink, error) {
+// See NewKafkaMetricSink for idempotent.
+func NewKafkaSpanSink(logger *logrus.Logger, cl *trace.Client, brokers string, topic string, partitioner string, ackRequirement string, retries int, bufferBytes int, bufferMessages int, bufferDuration string, serializationFormat string, sampleTag string, sampleRatePercentage int, idempotent bool) (*KafkaSpanSink, error) {
 	if logger == nil {
 		logger = &logrus.Logger{Out: ioutil.Discard}
 	}
@@ -261,7 +404,15 @@ func NewKafkaSpanSink(logger *logrus.Logger, cl *trace.Client, brokers string, t
 		}
 	}
 
-	config, _ := newProducerConfig(ll, ackRequirement, partitioner, retries, bufferBytes, bufferMessages, finalBufferDuration)
+	config, _ := newProducerConfig(ll, ackRequirement, partitioner, retries, bufferBytes, bufferMessages, finalBufferDuration, idempotent)
+
+	var idem *idempotence
+	if idempotent {
+		var err error
+		if idem, err = newIdempotence(); err != nil {
+			return nil, err
+		}
+	}
 
 	ll.WithFields(logrus.Fields{
 		"brokers":         brokers,
@@ -272,6 +423,7 @@ func NewKafkaSpanSink(logger *logrus.Logger, cl *trace.Client, brokers string, t
 		"buffer_bytes":    bufferBytes,
 		"buffer_messages": bufferMessages,
 		"buffer_duration": bufferDuration,
+		"idempotent":      idempotent,
 	}).Info("Started Kafka span sink")
 
 	return &KafkaSpanSink{
@@ -282,6 +434,7 @@ func NewKafkaSpanSink(logger *logrus.Logger, cl *trace.Client, brokers string, t
 		serializer:      serializer,
 		sampleTag:       sampleTag,
 		sampleThreshold: sampleThreshold,
+		idempotence:     idem,
 	}, nil
 }
 
@@ -353,7 +506,8 @@ func (k *KafkaSpanSink) Ingest(span *ssf.SSFSpan) error {
 		j, err := json.Marshal(span)
 		if err != nil {
 			k.logger.Error("Error marshalling span")
-			samples.Add(ssf.Count("kafka.span_marshal_error_total", 1, nil))
+			samples.Add(ssf.Count("kafka.span_marshal_error_total", 1, nil,
+				ssf.Failure(k.Name(), ssf.CauseEncodeError)))
 			return err
 		}
 		enc = sarama.StringEncoder(j)
@@ -361,7 +515,8 @@ func (k *KafkaSpanSink) Ingest(span *ssf.SSFSpan) error {
 		p, err := proto.Marshal(span)
 		if err != nil {
 			k.logger.Error("Error marshalling span")
-			samples.Add(ssf.Count("kafka.span_marshal_error_total", 1, nil))
+			samples.Add(ssf.Count("kafka.span_marshal_error_total", 1, nil,
+				ssf.Failure(k.Name(), ssf.CauseEncodeError)))
 			return err
 		}
 		enc = sarama.ByteEncoder(p)
@@ -373,7 +528,14 @@ func (k *KafkaSpanSink) Ingest(span *ssf.SSFSpan) error {
 		Topic: k.topic,
 		Value: enc,
 	}
+	if k.idempotence != nil {
+		message.Headers = k.idempotence.headers(0)
+	}
 
+	if !k.gate.enter() {
+		return errProducerStopped
+	}
+	defer k.gate.leave()
 	select {
 	case k.producer.Input() <- message:
 		atomic.AddInt64(&k.spansFlushed, 1)
@@ -385,9 +547,15 @@ func (k *KafkaSpanSink) Ingest(span *ssf.SSFSpan) error {
 
 // Flush emits metrics, since the spans have already been ingested and are
 // sending async.
-func (k *KafkaSpanSink) Flush() {
+func (k *KafkaSpanSink) Flush(context.Context) {
 	// TODO We have no stuff in here for detecting failed writes from the async
 	// producer. We should add that.
 	metrics.ReportOne(k.traceClient, ssf.Count(sinks.MetricKeyTotalSpansFlushed, float32(atomic.LoadInt64(&k.spansFlushed)), map[string]string{"sink": k.Name()}))
 	atomic.SwapInt64(&k.spansFlushed, 0)
 }
+
+// Stop waits for Ingest to finish sending spans, sends the spans that
+// the producer still buffers, and closes it.
+func (k *KafkaSpanSink) Stop(ctx context.Context) error {
+	return k.gate.close(ctx, k.producer)
+}
@@ -3,6 +3,7 @@ package kafka
 import (
 	"context"
 	"math"
+	"strconv"
 	"testing"
 	"time"
 
@@ -28,7 +29,7 @@ func TestMetricFlush(t *testing.T) {
 	// https://github.com/stripe/veneur/issues/277
 	logger := logrus.StandardLogger()
 
-	sink, err := NewKafkaMetricSink(logger, nil, "testing", "testCheckTopic", "testEventTopic", "testMetricTopic", "all", "hash", 0, 0, 0, "")
+	sink, err := NewKafkaMetricSink(logger, nil, "testing", "testCheckTopic", "testEventTopic", "testMetricTopic", "all", "hash", 0, 0, 0, "", false)
 	assert.NoError(t, err)
 	sink.Start(trace.DefaultClient)
 
@@ -53,6 +54,29 @@ func TestMetricFlush(t *testing.T) {
 	assert.Contains(t, string(contents), metric.Name)
 }
 
+func TestMetricStop(t *testing.T) {
+	config := sarama.NewConfig()
+	producerMock := mocks.NewAsyncProducer(t, config)
+	producerMock.ExpectInputAndSucceed()
+
+	sink, err := NewKafkaMetricSink(logrus.StandardLogger(), nil, "testing", "testCheckTopic", "testEventTopic", "testMetricTopic", "all", "hash", 0, 0, 0, "", false)
+	assert.NoError(t, err)
+	sink.producer = producerMock
+	metric := samplers.InterMetric{
+		Name:      "a.b.c",
+		Timestamp: 1476119058,
+		Value:     float64(100),
+		Type:      samplers.GaugeMetric,
+	}
+	assert.NoError(t, sink.Flush(context.Background(), []samplers.InterMetric{metric}))
+
+	// Stop closes the producer, which fails the test if it still
+	// expects messages:
+	assert.NoError(t, sink.Stop(context.Background()))
+	assert.Equal(t, errProducerStopped, sink.Flush(context.Background(), []samplers.InterMetric{metric}),
+		"a stopped sink shouldn't send to its closed producer")
+}
+
 func TestMetricFlushRouting(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -122,7 +146,7 @@ func TestMetricFlushRouting(t *testing.T) {
 			// https://github.com/stripe/veneur/issues/277
 			logger := logrus.StandardLogger()
 
-			sink, err := NewKafkaMetricSink(logger, nil, "testing", "testCheckTopic", "testEventTopic", "testMetricTopic", "all", "hash", 0, 0, 0, "")
+			sink, err := NewKafkaMetricSink(logger, nil, "testing", "testCheckTopic", "testEventTopic", "testMetricTopic", "all", "hash", 0, 0, 0, "", false)
 			assert.NoError(t, err)
 			sink.Start(trace.DefaultClient)
 
@@ -150,7 +174,7 @@ func TestMetricFlushRouting(t *testing.T) {
 func TestMetricConstructor(t *testing.T) {
 	logger := logrus.StandardLogger()
 
-	sink, err := NewKafkaMetricSink(logger, nil, "testing", "veneur_checks", "veneur_events", "veneur_metrics", "all", "hash", 1, 2, 3, "10s")
+	sink, err := NewKafkaMetricSink(logger, nil, "testing", "veneur_checks", "veneur_events", "veneur_metrics", "all", "hash", 1, 2, 3, "10s", false)
 	assert.NoError(t, err)
 
 	assert.Equal(t, "kafka", sink.Name())
@@ -170,11 +194,11 @@ func TestMetricInstantiateError(t *testing.T) {
 	logger := logrus.StandardLogger()
 
 	// Busted duration
-	_, err1 := NewKafkaMetricSink(logger, nil, "testing", "veneur_checks", "veneur_events", "veneur_metrics", "all", "hash", 1, 2, 3, "farts")
+	_, err1 := NewKafkaMetricSink(logger, nil, "testing", "veneur_checks", "veneur_events", "veneur_metrics", "all", "hash", 1, 2, 3, "farts", false)
 	assert.Error(t, err1)
 
 	// No topics
-	_, err := NewKafkaMetricSink(logger, nil, "testing", "", "", "", "all", "hash", 1, 2, 3, "10s")
+	_, err := NewKafkaMetricSink(logger, nil, "testing", "", "", "", "all", "hash", 1, 2, 3, "10s", false)
 	assert.Error(t, err)
 }
 
@@ -182,43 +206,43 @@ func TestSpanInstantiateError(t *testing.T) {
 	logger := logrus.StandardLogger()
 
 	// Busted duration
-	_, err := NewKafkaSpanSink(logger, nil, "testing", "veneur_spans", "hash", "all", 1, 2, 3, "farts", "", "", 100)
+	_, err := NewKafkaSpanSink(logger, nil, "testing", "veneur_spans", "hash", "all", 1, 2, 3, "farts", "", "", 100, false)
 	assert.Error(t, err)
 
 	// Missing topic
-	_, err2 := NewKafkaSpanSink(logger, nil, "testing", "", "hash", "all", 1, 2, 3, "farts", "", "", 100)
+	_, err2 := NewKafkaSpanSink(logger, nil, "testing", "", "hash", "all", 1, 2, 3, "farts", "", "", 100, false)
 	assert.Error(t, err2)
 
 	// Missing brokers
-	_, err3 := NewKafkaSpanSink(logger, nil, "", "farts", "hash", "all", 1, 2, 3, "farts", "", "", 100)
+	_, err3 := NewKafkaSpanSink(logger, nil, "", "farts", "hash", "all", 1, 2, 3, "farts", "", "", 100, false)
 	assert.Error(t, err3)
 
 	// Sampling rate set <= 0%
-	_, err4 := NewKafkaSpanSink(logger, nil, "testing", "veneur_spans", "hash", "all", 1, 2, 3, "10s", "", "", 0)
+	_, err4 := NewKafkaSpanSink(logger, nil, "testing", "veneur_spans", "hash", "all", 1, 2, 3, "10s", "", "", 0, false)
 	assert.Error(t, err4)
 
 	// Sampling rate set > 100%
-	_, err5 := NewKafkaSpanSink(logger, nil, "testing", "veneur_spans", "hash", "all", 1, 2, 3, "10s", "", "", 101)
+	_, err5 := NewKafkaSpanSink(logger, nil, "testing", "veneur_spans", "hash", "all", 1, 2, 3, "10s", "", "", 101, false)
 	assert.Error(t, err5)
 }
 
 func TestSpanConstructorAck(t *testing.T) {
 	logger := logrus.StandardLogger()
 
-	sink1, _ := NewKafkaSpanSink(logger, nil, "testing", "veneur_spans", "hash", "none", 1, 2, 3, "10s", "", "", 100)
+	sink1, _ := NewKafkaSpanSink(logger, nil, "testing", "veneur_spans", "hash", "none", 1, 2, 3, "10s", "", "", 100, false)
 	assert.Equal(t, sarama.NoResponse, sink1.config.Producer.RequiredAcks, "ack did not set correctly")
 
-	sink2, _ := NewKafkaSpanSink(logger, nil, "testing", "veneur_spans", "hash", "local", 1, 2, 3, "10s", "", "", 100)
+	sink2, _ := NewKafkaSpanSink(logger, nil, "testing", "veneur_spans", "hash", "local", 1, 2, 3, "10s", "", "", 100, false)
 	assert.Equal(t, sarama.WaitForLocal, sink2.config.Producer.RequiredAcks, "ack did not set correctly")
 
-	sink3, _ := NewKafkaSpanSink(logger, nil, "testing", "veneur_spans", "random", "farts", 1, 2, 3, "10s", "", "", 100)
+	sink3, _ := NewKafkaSpanSink(logger, nil, "testing", "veneur_spans", "random", "farts", 1, 2, 3, "10s", "", "", 100, false)
 	assert.Equal(t, sarama.WaitForAll, sink3.config.Producer.RequiredAcks, "ack did not default correctly")
 }
 
 func TestSpanConstructor(t *testing.T) {
 	logger := logrus.StandardLogger()
 
-	sink, err := NewKafkaSpanSink(logger, nil, "testing", "veneur_spans", "hash", "all", 1, 2, 3, "10s", "", "foo", 100)
+	sink, err := NewKafkaSpanSink(logger, nil, "testing", "veneur_spans", "hash", "all", 1, 2, 3, "10s", "", "foo", 100, false)
 	assert.NoError(t, err)
 	assert.Equal(t, "kafka", sink.Name())
 
@@ -248,7 +272,7 @@ func TestSpanTraceIdSampling(t *testing.T) {
 	logger := logrus.StandardLogger()
 	logger.SetLevel(logrus.DebugLevel)
 
-	sink, err := NewKafkaSpanSink(logger, nil, "testing", "testSpanTopic", "hash", "all", 0, 0, 0, "", "json", "", 50)
+	sink, err := NewKafkaSpanSink(logger, nil, "testing", "testSpanTopic", "hash", "all", 0, 0, 0, "", "json", "", 50, false)
 	assert.NoError(t, err)
 
 	sink.producer = producerMock
@@ -308,7 +332,7 @@ func TestSpanTagSampling(t *testing.T) {
 	logger := logrus.StandardLogger()
 	logger.SetLevel(logrus.DebugLevel)
 
-	sink, err := NewKafkaSpanSink(logger, nil, "testing", "testSpanTopic", "hash", "all", 0, 0, 0, "", "json", "baz", 50)
+	sink, err := NewKafkaSpanSink(logger, nil, "testing", "testSpanTopic", "hash", "all", 0, 0, 0, "", "json", "baz", 50, false)
 	assert.NoError(t, err)
 
 	sink.producer = producerMock
@@ -393,7 +417,7 @@ func TestSpanTagSampling(t *testing.T) {
 func TestBadDuration(t *testing.T) {
 	logger := logrus.StandardLogger()
 
-	_, err := NewKafkaSpanSink(logger, nil, "testing", "", "hash", "all", 0, 0, 0, "pthbbbbbt", "", "", 100)
+	_, err := NewKafkaSpanSink(logger, nil, "testing", "", "hash", "all", 0, 0, 0, "pthbbbbbt", "", "", 100, false)
 	assert.Error(t, err)
 }
 
@@ -409,7 +433,7 @@ func TestSpanFlushJson(t *testing.T) {
 	// https://github.com/stripe/veneur/issues/277
 	logger := logrus.StandardLogger()
 
-	sink, err := NewKafkaSpanSink(logger, nil, "testing", "testSpanTopic", "hash", "all", 0, 0, 0, "", "json", "", 100)
+	sink, err := NewKafkaSpanSink(logger, nil, "testing", "testSpanTopic", "hash", "all", 0, 0, 0, "", "json", "", 100, false)
 	assert.NoError(t, err)
 
 	sink.producer = producerMock
@@ -452,7 +476,7 @@ func TestSpanFlushProtobuf(t *testing.T) {
 	// https://github.com/stripe/veneur/issues/277
 	logger := logrus.StandardLogger()
 
-	sink, err := NewKafkaSpanSink(logger, nil, "testing", "testSpanTopic", "hash", "all", 0, 0, 0, "", "protobuf", "", 100)
+	sink, err := NewKafkaSpanSink(logger, nil, "testing", "testSpanTopic", "hash", "all", 0, 0, 0, "", "protobuf", "", 100, false)
 	assert.NoError(t, err)
 
 	sink.producer = producerMock
@@ -487,3 +511,63 @@ func TestSpanFlushProtobuf(t *testing.T) {
 
 	assert.Equal(t, testSpan.Service, span.Service)
 }
+
+func messageHeaders(msg *sarama.ProducerMessage) map[string]string {
+	headers := map[string]string{}
+	for _, h := range msg.Headers {
+		headers[string(h.Key)] = string(h.Value)
+	}
+	return headers
+}
+
+func TestMetricFlushIdempotent(t *testing.T) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	producerMock := mocks.NewAsyncProducer(t, config)
+	for i := 0; i < 3; i++ {
+		producerMock.ExpectInputAndSucceed()
+	}
+	logger := logrus.StandardLogger()
+
+	sink, err := NewKafkaMetricSink(logger, nil, "testing", "", "", "testMetricTopic", "local", "hash", 0, 0, 0, "", true)
+	assert.NoError(t, err)
+	assert.Equal(t, sarama.WaitForAll, sink.config.Producer.RequiredAcks, "idempotent sinks should wait for all replicas")
+	assert.Equal(t, 1, sink.config.Net.MaxOpenRequests)
+	assert.True(t, sink.config.Version.IsAtLeast(sarama.V0_11_0_0), "headers need Kafka 0.11")
+	sink.producer = producerMock
+
+	metric := samplers.InterMetric{Name: "a.b.c", Timestamp: 1476119058, Value: 100, Type: samplers.GaugeMetric}
+	assert.NoError(t, sink.Flush(context.Background(), []samplers.InterMetric{metric, metric}))
+	assert.NoError(t, sink.Flush(context.Background(), []samplers.InterMetric{metric}))
+
+	var headers []map[string]string
+	for i := 0; i < 3; i++ {
+		headers = append(headers, messageHeaders(<-producerMock.Successes()))
+	}
+	assert.NotEmpty(t, headers[0][ProducerIDHeader])
+	for i, h := range headers {
+		assert.Equal(t, headers[0][ProducerIDHeader], h[ProducerIDHeader])
+		assert.Equal(t, strconv.Itoa(i+1), h[SequenceHeader])
+	}
+	assert.Equal(t, "1", headers[0][FlushHeader])
+	assert.Equal(t, "1", headers[1][FlushHeader])
+	assert.Equal(t, "2", headers[2][FlushHeader])
+}
+
+func TestSpanIngestIdempotent(t *testing.T) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	producerMock := mocks.NewAsyncProducer(t, config)
+	producerMock.ExpectInputAndSucceed()
+	logger := logrus.StandardLogger()
+
+	sink, err := NewKafkaSpanSink(logger, nil, "testing", "testSpanTopic", "hash", "all", 0, 0, 0, "", "protobuf", "", 100, true)
+	assert.NoError(t, err)
+	sink.producer = producerMock
+
+	assert.NoError(t, sink.Ingest(&ssf.SSFSpan{TraceId: 1, Id: 2, StartTimestamp: 1, EndTimestamp: 2}))
+	headers := messageHeaders(<-producerMock.Successes())
+	assert.NotEmpty(t, headers[ProducerIDHeader])
+	assert.Equal(t, "1", headers[SequenceHeader])
+	assert.NotContains(t, headers, FlushHeader, "spans aren't flushed together")
+}
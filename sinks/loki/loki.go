@@ -0,0 +1,404 @@
+package loki
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	vhttp "github.com/stripe/veneur/http"
+	"github.com/stripe/veneur/protocol"
+	"github.com/stripe/veneur/protocol/dogstatsd"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// TenantHeader is the header that Loki reads the tenant of a request
+// from, when it runs with multi-tenancy enabled.
+const TenantHeader = "X-Scope-OrgID"
+
+// SourceLabel is the label that tells the lines of spans and events
+// apart. Every stream has it, since Loki needs at least one label.
+const SourceLabel = "source"
+
+// pushPath is the path of Loki's push API.
+const pushPath = "/loki/api/v1/push"
+
+// lokiBatchSize is the default maximum number of lines per push.
+const lokiBatchSize = 1000
+
+// lokiSpanBufferSize is the default maximum number of spans that the
+// span sink holds between flushes.
+const lokiSpanBufferSize = 1 << 14
+
+var _ sinks.SpanSink = &LokiSpanSink{}
+var _ sinks.MetricSink = &LokiEventSink{}
+
+// entry is a line to push, with the labels of its stream.
+type entry struct {
+	labels    map[string]string
+	timestamp int64
+	line      string
+}
+
+// pusher pushes lines to Loki in batches.
+type pusher struct {
+	httpClient  *http.Client
+	address     string
+	batchSize   int
+	labelTags   map[string]string
+	traceClient *trace.Client
+	log         *logrus.Logger
+}
+
+func newPusher(address string, tenant string, labelTags []string, batchSize int, httpClient *http.Client, log *logrus.Logger) (*pusher, error) {
+	if address == "" {
+		return nil, fmt.Errorf("Loki sink needs an address")
+	}
+	if batchSize <= 0 {
+		batchSize = lokiBatchSize
+	}
+	if tenant != "" {
+		httpClient = vhttp.WithHeader(httpClient, TenantHeader, tenant)
+	}
+	labels := make(map[string]string, len(labelTags))
+	for _, tag := range labelTags {
+		label := labelName(tag)
+		if label == SourceLabel {
+			return nil, fmt.Errorf("Loki label tag %q is reserved", tag)
+		}
+		labels[tag] = label
+	}
+	return &pusher{
+		httpClient: httpClient,
+		address:    strings.TrimRight(address, "/"),
+		batchSize:  batchSize,
+		labelTags:  labels,
+		log:        log,
+	}, nil
+}
+
+// labelName returns tag with the characters that Loki doesn't allow in
+// label names replaced by underscores.
+func labelName(tag string) string {
+	name := []byte(tag)
+	for i, c := range name {
+		valid := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9')
+		if !valid {
+			name[i] = '_'
+		}
+	}
+	return string(name)
+}
+
+// split divides tags into the labels of a line's stream, which only
+// come from the configured label tags, and the fields of the line.
+func (p *pusher) split(source string, tags map[string]string) (labels map[string]string, fields map[string]string) {
+	labels = map[string]string{SourceLabel: source}
+	fields = map[string]string{}
+	for k, v := range tags {
+		if label, ok := p.labelTags[k]; ok && v != "" {
+			labels[label] = v
+			continue
+		}
+		fields[k] = v
+	}
+	return labels, fields
+}
+
+// push sends entries to Loki, in as many requests as it takes to keep
+// each of them within the batch size. It returns the number of entries
+// that were pushed.
+func (p *pusher) push(ctx context.Context, entries []entry, component string) int {
+	pushed := 0
+	for start := 0; start < len(entries); start += p.batchSize {
+		end := start + p.batchSize
+		if end > len(entries) {
+			end = len(entries)
+		}
+		err := vhttp.PostHelper(ctx, p.httpClient, p.traceClient, http.MethodPost, p.address+pushPath, pushRequest(entries[start:end]), "flush_lines", false, map[string]string{"sink": component}, p.log)
+		if err != nil {
+			p.log.WithFields(logrus.Fields{
+				"lines":         end - start,
+				logrus.ErrorKey: err}).Warn("Error pushing lines to Loki")
+			continue
+		}
+		pushed += end - start
+	}
+	return pushed
+}
+
+type lokiPushRequest struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	// Values are pairs of a timestamp in Unix nanoseconds and a line.
+	Values [][2]string `json:"values"`
+}
+
+// streamKey identifies the stream with the given labels.
+func streamKey(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+strconv.Quote(v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// pushRequest groups entries into streams by their labels, with the
+// lines of each stream in order.
+func pushRequest(entries []entry) *lokiPushRequest {
+	sorted := make([]entry, len(entries))
+	copy(sorted, entries)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].timestamp < sorted[j].timestamp
+	})
+
+	streams := map[string]*lokiStream{}
+	var keys []string
+	for _, e := range sorted {
+		key := streamKey(e.labels)
+		stream, ok := streams[key]
+		if !ok {
+			stream = &lokiStream{Stream: e.labels}
+			streams[key] = stream
+			keys = append(keys, key)
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(e.timestamp, 10), e.line})
+	}
+	req := &lokiPushRequest{}
+	for _, key := range keys {
+		req.Streams = append(req.Streams, *streams[key])
+	}
+	return req
+}
+
+// LokiSpanSink pushes a line for every span it ingests to Loki's push
+// API on every flush.
+type LokiSpanSink struct {
+	pusher     *pusher
+	bufferSize int
+
+	mutex   sync.Mutex
+	spans   []*ssf.SSFSpan
+	dropped int64
+}
+
+// NewLokiSpanSink creates a sink that pushes the lines of spans to the
+// Loki instance at address. The values of the tags in labelTags become
+// the labels of the lines' streams; all other tags are fields of the
+// lines. If tenant is set, it's sent in the X-Scope-OrgID header.
+func NewLokiSpanSink(address string, tenant string, labelTags []string, batchSize int, bufferSize int, httpClient *http.Client, log *logrus.Logger) (*LokiSpanSink, error) {
+	p, err := newPusher(address, tenant, labelTags, batchSize, httpClient, log)
+	if err != nil {
+		return nil, err
+	}
+	if bufferSize <= 0 {
+		bufferSize = lokiSpanBufferSize
+	}
+	return &LokiSpanSink{pusher: p, bufferSize: bufferSize}, nil
+}
+
+// Name returns the name of this sink.
+func (l *LokiSpanSink) Name() string {
+	return "loki"
+}
+
+// Start performs final adjustments on the sink.
+func (l *LokiSpanSink) Start(cl *trace.Client) error {
+	l.pusher.traceClient = cl
+	return nil
+}
+
+// Ingest buffers the span until the next flush. Spans that arrive
+// while the buffer is full are dropped.
+func (l *LokiSpanSink) Ingest(span *ssf.SSFSpan) error {
+	if err := protocol.ValidateTrace(span); err != nil {
+		return err
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if len(l.spans) >= l.bufferSize {
+		l.dropped++
+		return nil
+	}
+	l.spans = append(l.spans, span)
+	return nil
+}
+
+// Flush pushes the lines of the buffered spans to Loki.
+func (l *LokiSpanSink) Flush(ctx context.Context) {
+	samples := &ssf.Samples{}
+	defer metrics.Report(l.pusher.traceClient, samples)
+
+	l.mutex.Lock()
+	spans := l.spans
+	dropped := l.dropped
+	l.spans = nil
+	l.dropped = 0
+	l.mutex.Unlock()
+
+	if dropped > 0 {
+		samples.Add(ssf.Count(sinks.MetricKeyTotalSpansDropped, float32(dropped), map[string]string{"sink": l.Name()},
+			ssf.Failure(l.Name(), ssf.CauseQueueFull)))
+	}
+	if len(spans) == 0 {
+		return
+	}
+
+	flushStart := time.Now()
+	entries := make([]entry, 0, len(spans))
+	for _, span := range spans {
+		e, err := l.spanEntry(span)
+		if err != nil {
+			samples.Add(ssf.Count(sinks.MetricKeyTotalSpansSkipped, 1, map[string]string{"sink": l.Name()},
+				ssf.Failure(l.Name(), ssf.CauseEncodeError)))
+			continue
+		}
+		entries = append(entries, e)
+	}
+	pushed := l.pusher.push(ctx, entries, l.Name())
+	samples.Add(ssf.Count(sinks.MetricKeyTotalSpansFlushed, float32(pushed), map[string]string{"sink": l.Name()}))
+	samples.Add(ssf.Timing(sinks.MetricKeySpanFlushDuration, time.Since(flushStart), time.Nanosecond, map[string]string{"sink": l.Name()}))
+}
+
+// spanLine is the line that a span is pushed as.
+type spanLine struct {
+	Name       string            `json:"name"`
+	Service    string            `json:"service,omitempty"`
+	TraceID    int64             `json:"trace_id"`
+	SpanID     int64             `json:"span_id"`
+	ParentID   int64             `json:"parent_id,omitempty"`
+	DurationNs int64             `json:"duration_ns"`
+	Error      bool              `json:"error,omitempty"`
+	Indicator  bool              `json:"indicator,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+}
+
+// spanEntry converts a span into a line at the span's end. The span's
+// service can be a label like its tags, under the tag name "service".
+func (l *LokiSpanSink) spanEntry(span *ssf.SSFSpan) (entry, error) {
+	tags := make(map[string]string, len(span.Tags)+1)
+	for k, v := range span.Tags {
+		tags[k] = v
+	}
+	if span.Service != "" {
+		tags["service"] = span.Service
+	}
+	labels, fields := l.pusher.split("span", tags)
+	delete(fields, "service")
+
+	parentID := span.ParentId
+	if parentID < 0 {
+		parentID = 0
+	}
+	line, err := json.Marshal(spanLine{
+		Name:       span.Name,
+		Service:    span.Service,
+		TraceID:    span.TraceId,
+		SpanID:     span.Id,
+		ParentID:   parentID,
+		DurationNs: span.EndTimestamp - span.StartTimestamp,
+		Error:      span.Error,
+		Indicator:  span.Indicator,
+		Tags:       fields,
+	})
+	if err != nil {
+		return entry{}, err
+	}
+	return entry{labels: labels, timestamp: span.EndTimestamp, line: string(line)}, nil
+}
+
+// LokiEventSink pushes the events that Veneur receives, like DogStatsD
+// events, to Loki's push API. It ignores metrics.
+type LokiEventSink struct {
+	pusher *pusher
+}
+
+// NewLokiEventSink creates a sink that pushes events to the Loki
+// instance at address. Its arguments are the same as NewLokiSpanSink's.
+func NewLokiEventSink(address string, tenant string, labelTags []string, batchSize int, httpClient *http.Client, log *logrus.Logger) (*LokiEventSink, error) {
+	p, err := newPusher(address, tenant, labelTags, batchSize, httpClient, log)
+	if err != nil {
+		return nil, err
+	}
+	return &LokiEventSink{pusher: p}, nil
+}
+
+// Name returns the name of this sink.
+func (l *LokiEventSink) Name() string {
+	return "loki"
+}
+
+// Start performs final adjustments on the sink.
+func (l *LokiEventSink) Start(cl *trace.Client) error {
+	l.pusher.traceClient = cl
+	return nil
+}
+
+// Flush does nothing: the sink only handles events.
+func (l *LokiEventSink) Flush(ctx context.Context, interMetrics []samplers.InterMetric) error {
+	return nil
+}
+
+// eventLine is the line that an event is pushed as.
+type eventLine struct {
+	Title string            `json:"title"`
+	Text  string            `json:"text,omitempty"`
+	Tags  map[string]string `json:"tags,omitempty"`
+}
+
+// FlushOtherSamples pushes a line for every event among the samples.
+// All other samples are ignored.
+func (l *LokiEventSink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {
+	span, _ := trace.StartSpanFromContext(ctx, "")
+	defer span.ClientFinish(l.pusher.traceClient)
+
+	var entries []entry
+	for _, sample := range samples {
+		if _, ok := sample.Tags[dogstatsd.EventIdentifierKey]; !ok {
+			continue
+		}
+		tags := make(map[string]string, len(sample.Tags))
+		for k, v := range sample.Tags {
+			if k != dogstatsd.EventIdentifierKey {
+				tags[k] = v
+			}
+		}
+		labels, fields := l.pusher.split("event", tags)
+		line, err := json.Marshal(eventLine{Title: sample.Name, Text: sample.Message, Tags: fields})
+		if err != nil {
+			span.Add(ssf.Count(sinks.EventReportedCount, 1, map[string]string{"sink": l.Name(), "results": "failure"},
+				ssf.Failure(l.Name(), ssf.CauseEncodeError)))
+			continue
+		}
+		entries = append(entries, entry{
+			labels:    labels,
+			timestamp: time.Unix(sample.Timestamp, 0).UnixNano(),
+			line:      string(line),
+		})
+	}
+	if len(entries) == 0 {
+		return
+	}
+	pushed := l.pusher.push(span.Attach(ctx), entries, l.Name())
+	if pushed > 0 {
+		span.Add(ssf.Count(sinks.EventReportedCount, float32(pushed), map[string]string{"sink": l.Name(), "results": "success"}))
+	}
+	if failed := len(entries) - pushed; failed > 0 {
+		span.Add(ssf.Count(sinks.EventReportedCount, float32(failed), map[string]string{"sink": l.Name(), "results": "failure"},
+			ssf.Failure(l.Name(), ssf.CauseIOError)))
+	}
+}
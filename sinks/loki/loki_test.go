@@ -0,0 +1,176 @@
+package loki
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/internal/sinktest"
+	"github.com/stripe/veneur/protocol/dogstatsd"
+	"github.com/stripe/veneur/ssf"
+)
+
+type pushed struct {
+	tenant string
+	req    lokiPushRequest
+}
+
+func testServer(t *testing.T) (*httptest.Server, chan pushed) {
+	pushes := make(chan pushed, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, pushPath, r.URL.Path)
+		var req lokiPushRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		pushes <- pushed{tenant: r.Header.Get(TenantHeader), req: req}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	return srv, pushes
+}
+
+func testSpan(id int64, service, env string) *ssf.SSFSpan {
+	start := time.Now()
+	return &ssf.SSFSpan{
+		Id:             id,
+		TraceId:        1,
+		ParentId:       -1,
+		StartTimestamp: start.UnixNano(),
+		EndTimestamp:   start.Add(time.Duration(id) * time.Second).UnixNano(),
+		Name:           "test.span",
+		Service:        service,
+		Tags:           map[string]string{"env": env, "request_id": "abc"},
+	}
+}
+
+func TestLokiSpanFlush(t *testing.T) {
+	srv, pushes := testServer(t)
+	defer srv.Close()
+
+	sink, err := NewLokiSpanSink(srv.URL, "team-a", []string{"service", "env"}, 2, 0, &http.Client{}, logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+
+	require.NoError(t, sink.Ingest(testSpan(3, "search", "prod")))
+	require.NoError(t, sink.Ingest(testSpan(1, "search", "prod")))
+	require.NoError(t, sink.Ingest(testSpan(2, "billing", "dev")))
+	sink.Flush(context.Background())
+	close(pushes)
+
+	var streams []lokiStream
+	for p := range pushes {
+		assert.Equal(t, "team-a", p.tenant)
+		streams = append(streams, p.req.Streams...)
+	}
+	// A batch size of 2 splits the spans into two pushes, of one
+	// stream each:
+	require.Len(t, streams, 2)
+	lines := map[string][]string{}
+	for _, s := range streams {
+		assert.Equal(t, "span", s.Stream[SourceLabel])
+		assert.Len(t, s.Stream, 3)
+		key := s.Stream["service"] + "/" + s.Stream["env"]
+		for _, v := range s.Values {
+			lines[key] = append(lines[key], v[1])
+		}
+	}
+	require.Len(t, lines["search/prod"], 2)
+	require.Len(t, lines["billing/dev"], 1)
+
+	var line spanLine
+	require.NoError(t, json.Unmarshal([]byte(lines["billing/dev"][0]), &line))
+	assert.Equal(t, "test.span", line.Name)
+	assert.Equal(t, int64(2), line.SpanID)
+	assert.Equal(t, int64(0), line.ParentID)
+	assert.Equal(t, (2 * time.Second).Nanoseconds(), line.DurationNs)
+	assert.Equal(t, map[string]string{"request_id": "abc"}, line.Tags)
+}
+
+func TestLokiSpanBufferFull(t *testing.T) {
+	sink, err := NewLokiSpanSink("http://localhost", "", nil, 0, 2, &http.Client{}, logrus.New())
+	require.NoError(t, err)
+	for i := int64(1); i <= 3; i++ {
+		require.NoError(t, sink.Ingest(testSpan(i, "search", "prod")))
+	}
+	assert.Len(t, sink.spans, 2)
+	assert.Equal(t, int64(1), sink.dropped)
+}
+
+func TestLokiEvents(t *testing.T) {
+	srv, pushes := testServer(t)
+	defer srv.Close()
+
+	sink, err := NewLokiEventSink(srv.URL, "", []string{"env"}, 0, &http.Client{}, logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+
+	sink.FlushOtherSamples(context.Background(), []ssf.SSFSample{
+		{
+			Name:      "deploy",
+			Message:   "deployed search",
+			Timestamp: 1000,
+			Tags: map[string]string{
+				dogstatsd.EventIdentifierKey: "",
+				"env":                        "prod",
+				"version":                    "42",
+			},
+		},
+		{
+			Name: "not.an.event",
+			Tags: map[string]string{"env": "prod"},
+		},
+	})
+	close(pushes)
+
+	p := <-pushes
+	assert.Empty(t, p.tenant)
+	require.Len(t, p.req.Streams, 1)
+	stream := p.req.Streams[0]
+	assert.Equal(t, map[string]string{SourceLabel: "event", "env": "prod"}, stream.Stream)
+	require.Len(t, stream.Values, 1)
+	assert.Equal(t, "1000000000000", stream.Values[0][0])
+
+	var line eventLine
+	require.NoError(t, json.Unmarshal([]byte(stream.Values[0][1]), &line))
+	assert.Equal(t, eventLine{Title: "deploy", Text: "deployed search", Tags: map[string]string{"version": "42"}}, line)
+
+	_, ok := <-pushes
+	assert.False(t, ok)
+}
+
+func TestLokiFlushDeadline(t *testing.T) {
+	srv, stop := sinktest.HangingServer()
+	defer stop()
+
+	spanSink, err := NewLokiSpanSink(srv.URL, "", nil, 0, 0, &http.Client{}, logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, spanSink.Start(nil))
+	require.NoError(t, spanSink.Ingest(testSpan(1, "search", "prod")))
+	eventSink, err := NewLokiEventSink(srv.URL, "", nil, 0, &http.Client{}, logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, eventSink.Start(nil))
+
+	sinktest.AssertFlushDeadline(t, func(ctx context.Context) {
+		spanSink.Flush(ctx)
+	})
+	sinktest.AssertFlushDeadline(t, func(ctx context.Context) {
+		eventSink.FlushOtherSamples(ctx, []ssf.SSFSample{{
+			Name:      "deploy",
+			Timestamp: 1000,
+			Tags:      map[string]string{dogstatsd.EventIdentifierKey: ""},
+		}})
+	})
+}
+
+func TestLokiLabelNames(t *testing.T) {
+	assert.Equal(t, "host_name", labelName("host.name"))
+	assert.Equal(t, "_xyz", labelName("1xyz"))
+	assert.Equal(t, "env2", labelName("env2"))
+
+	_, err := NewLokiEventSink("http://localhost", "", []string{"source"}, 0, &http.Client{}, logrus.New())
+	assert.Error(t, err)
+}
@@ -0,0 +1,327 @@
+package prometheus
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/sirupsen/logrus"
+	vhttp "github.com/stripe/veneur/http"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks"
+	"github.com/stripe/veneur/spanconv"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// TenantHeader is the header that Cortex and Mimir read the tenant of
+// a request from.
+const TenantHeader = "X-Scope-OrgID"
+
+// remoteWriteBatchSize is the default maximum number of series per
+// write request.
+const remoteWriteBatchSize = 1000
+
+var _ sinks.MetricSink = &RemoteWriteSink{}
+
+// RemoteWriteSink sends metrics to an endpoint that implements
+// Prometheus' remote write protocol, like Prometheus itself, Cortex or
+// Mimir. Each metric is sent as a sample of the series with its name
+// and tags, at the time it was flushed. Metrics with an exemplar carry
+// it as an exemplar of their sample, labeled with its trace_id and
+// span_id.
+//
+// Metrics with the tenant tag are sent to the tenant named by its value
+// in the X-Scope-OrgID header, in write requests of their own, so that
+// one Veneur can feed a multi-tenant Cortex or Mimir.
+type RemoteWriteSink struct {
+	HTTPClient    *http.Client
+	address       string
+	defaultTenant string
+	tenantTag     string
+	hostnameLabel string
+	hostname      string
+	batchSize     int
+	traceClient   *trace.Client
+	log           *logrus.Logger
+}
+
+// NewRemoteWriteSink creates a sink that sends metrics to the remote
+// write endpoint at address. If tenantTag is set, metrics with that tag
+// are sent to the tenant named by its value; all others are sent to
+// defaultTenant, or without a tenant if it's empty.
+func NewRemoteWriteSink(address string, defaultTenant string, tenantTag string, hostnameLabel string, hostname string, batchSize int, httpClient *http.Client, log *logrus.Logger) (*RemoteWriteSink, error) {
+	if address == "" {
+		return nil, fmt.Errorf("Prometheus remote write sink needs an address")
+	}
+	if batchSize <= 0 {
+		batchSize = remoteWriteBatchSize
+	}
+	return &RemoteWriteSink{
+		HTTPClient:    httpClient,
+		address:       address,
+		defaultTenant: defaultTenant,
+		tenantTag:     tenantTag,
+		hostnameLabel: labelName(hostnameLabel),
+		hostname:      hostname,
+		batchSize:     batchSize,
+		log:           log,
+	}, nil
+}
+
+// Name returns the name of this sink.
+func (p *RemoteWriteSink) Name() string {
+	return "prometheus"
+}
+
+// Start performs final adjustments on the sink.
+func (p *RemoteWriteSink) Start(cl *trace.Client) error {
+	p.traceClient = cl
+	return nil
+}
+
+// Flush sends metrics to the remote write endpoint, in one or more
+// write requests per tenant.
+func (p *RemoteWriteSink) Flush(ctx context.Context, interMetrics []samplers.InterMetric) error {
+	samples := &ssf.Samples{}
+	defer metrics.Report(p.traceClient, samples)
+
+	flushStart := time.Now()
+	byTenant := map[string][]timeSeries{}
+	for _, metric := range interMetrics {
+		if !sinks.IsAcceptableMetric(metric, p) {
+			continue
+		}
+		// Prometheus has no type of series for service checks.
+		if metric.Type == samplers.StatusMetric {
+			continue
+		}
+		tenant, series := p.convert(metric)
+		byTenant[tenant] = append(byTenant[tenant], series)
+	}
+
+	tenants := make([]string, 0, len(byTenant))
+	for tenant := range byTenant {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+
+	var firstErr error
+	for _, tenant := range tenants {
+		series := byTenant[tenant]
+		flushed := 0
+		for start := 0; start < len(series); start += p.batchSize {
+			end := start + p.batchSize
+			if end > len(series) {
+				end = len(series)
+			}
+			if err := p.write(ctx, tenant, series[start:end]); err != nil {
+				samples.Add(ssf.Count("flush.error_total", 1, map[string]string{"sink": p.Name()},
+					ssf.Failure(p.Name(), errorCause(err))))
+				p.log.WithFields(logrus.Fields{
+					"series":        end - start,
+					"tenant":        tenant,
+					logrus.ErrorKey: err}).Warn("Error writing metrics to Prometheus remote write endpoint")
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			flushed += end - start
+		}
+		samples.Add(ssf.Count(sinks.MetricKeyTotalMetricsFlushed, float32(flushed), map[string]string{"sink": p.Name(), "tenant": tenant}))
+	}
+	samples.Add(ssf.Timing(sinks.MetricKeyMetricFlushDuration, time.Since(flushStart), time.Nanosecond, map[string]string{"sink": p.Name()}))
+	return firstErr
+}
+
+// FlushOtherSamples does nothing: Prometheus has no events.
+func (p *RemoteWriteSink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {
+}
+
+func errorCause(err error) string {
+	if statusErr, ok := err.(vhttp.StatusError); ok {
+		return vhttp.StatusCause(statusErr.StatusCode)
+	}
+	return ssf.CauseIOError
+}
+
+// convert returns the tenant of a metric, and the series that it's
+// sent as.
+func (p *RemoteWriteSink) convert(metric samplers.InterMetric) (string, timeSeries) {
+	tenant := p.defaultTenant
+	labels := map[string]string{"__name__": metricName(metric.Name)}
+	for _, tag := range metric.Tags {
+		kv := strings.SplitN(tag, ":", 2)
+		value := "true"
+		if len(kv) == 2 {
+			value = kv[1]
+		}
+		if p.tenantTag != "" && kv[0] == p.tenantTag && value != "" {
+			tenant = value
+		}
+		if value != "" {
+			labels[labelName(kv[0])] = value
+		}
+	}
+	hostname := metric.HostName
+	if hostname == "" {
+		hostname = p.hostname
+	}
+	if _, ok := labels[p.hostnameLabel]; !ok && p.hostnameLabel != "" && hostname != "" {
+		labels[p.hostnameLabel] = hostname
+	}
+
+	series := timeSeries{
+		value:     metric.Value,
+		timestamp: metric.Timestamp * 1000,
+		exemplar:  metric.Exemplar,
+	}
+	for name, value := range labels {
+		series.labels = append(series.labels, label{name, value})
+	}
+	// Remote write endpoints expect labels sorted by name.
+	sort.Slice(series.labels, func(i, j int) bool {
+		return series.labels[i].name < series.labels[j].name
+	})
+	return tenant, series
+}
+
+// labelName returns name with the characters that Prometheus doesn't
+// allow in label names replaced by underscores.
+func labelName(name string) string {
+	return sanitize(name, false)
+}
+
+// metricName returns name with the characters that Prometheus doesn't
+// allow in metric names, like dots, replaced by underscores.
+func metricName(name string) string {
+	return sanitize(name, true)
+}
+
+func sanitize(name string, allowColons bool) string {
+	b := []byte(name)
+	for i, c := range b {
+		valid := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+			(i > 0 && c >= '0' && c <= '9') || (allowColons && c == ':')
+		if !valid {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// write sends series to the remote write endpoint in one request.
+func (p *RemoteWriteSink) write(ctx context.Context, tenant string, series []timeSeries) error {
+	body := snappy.Encode(nil, encodeWriteRequest(series))
+	req, err := http.NewRequest(http.MethodPost, p.address, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if tenant != "" {
+		req.Header.Set(TenantHeader, tenant)
+	}
+
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// Drain the body so that the connection can be reused.
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return vhttp.StatusError{StatusCode: resp.StatusCode}
+	}
+	return nil
+}
+
+type label struct {
+	name, value string
+}
+
+type timeSeries struct {
+	labels []label
+	value  float64
+	// timestamp is in Unix milliseconds.
+	timestamp int64
+	exemplar  *samplers.Exemplar
+}
+
+// encodeWriteRequest encodes series as a prometheus.WriteRequest
+// protobuf message:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries {
+//	  repeated Label labels = 1;
+//	  repeated Sample samples = 2;
+//	  repeated Exemplar exemplars = 3;
+//	}
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+//	message Exemplar {
+//	  repeated Label labels = 1;
+//	  double value = 2;
+//	  int64 timestamp = 3;
+//	}
+func encodeWriteRequest(series []timeSeries) []byte {
+	req := proto.NewBuffer(nil)
+	for _, s := range series {
+		ts := proto.NewBuffer(nil)
+		encodeLabels(ts, s.labels)
+		sample := proto.NewBuffer(nil)
+		sample.EncodeVarint(1<<3 | proto.WireFixed64)
+		sample.EncodeFixed64(math.Float64bits(s.value))
+		sample.EncodeVarint(2<<3 | proto.WireVarint)
+		sample.EncodeVarint(uint64(s.timestamp))
+		encodeBytes(ts, 2, sample.Bytes())
+		if s.exemplar != nil {
+			ex := proto.NewBuffer(nil)
+			encodeLabels(ex, []label{
+				{"span_id", spanconv.FormatID(s.exemplar.SpanID)},
+				{"trace_id", spanconv.FormatTraceID(s.exemplar.TraceID)},
+			})
+			ex.EncodeVarint(2<<3 | proto.WireFixed64)
+			ex.EncodeFixed64(math.Float64bits(s.exemplar.Value))
+			ex.EncodeVarint(3<<3 | proto.WireVarint)
+			ex.EncodeVarint(uint64(s.timestamp))
+			encodeBytes(ts, 3, ex.Bytes())
+		}
+		encodeBytes(req, 1, ts.Bytes())
+	}
+	return req.Bytes()
+}
+
+// encodeLabels encodes labels as the repeated Label field 1 of the
+// message in b.
+func encodeLabels(b *proto.Buffer, labels []label) {
+	for _, l := range labels {
+		lb := proto.NewBuffer(nil)
+		encodeString(lb, 1, l.name)
+		encodeString(lb, 2, l.value)
+		encodeBytes(b, 1, lb.Bytes())
+	}
+}
+
+func encodeString(b *proto.Buffer, field uint64, s string) {
+	b.EncodeVarint(field<<3 | proto.WireBytes)
+	b.EncodeStringBytes(s)
+}
+
+func encodeBytes(b *proto.Buffer, field uint64, bts []byte) {
+	b.EncodeVarint(field<<3 | proto.WireBytes)
+	b.EncodeRawBytes(bts)
+}
@@ -0,0 +1,216 @@
+package prometheus
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/internal/sinktest"
+	"github.com/stripe/veneur/samplers"
+)
+
+// The remote write messages, for decoding what the sink sends.
+
+type writeRequest struct {
+	Timeseries []*testSeries `protobuf:"bytes,1,rep,name=timeseries"`
+}
+
+func (m *writeRequest) Reset()         { *m = writeRequest{} }
+func (m *writeRequest) String() string { return proto.CompactTextString(m) }
+func (*writeRequest) ProtoMessage()    {}
+
+type testSeries struct {
+	Labels    []*testLabel    `protobuf:"bytes,1,rep,name=labels"`
+	Samples   []*testSample   `protobuf:"bytes,2,rep,name=samples"`
+	Exemplars []*testExemplar `protobuf:"bytes,3,rep,name=exemplars"`
+}
+
+func (m *testSeries) Reset()         { *m = testSeries{} }
+func (m *testSeries) String() string { return proto.CompactTextString(m) }
+func (*testSeries) ProtoMessage()    {}
+
+type testLabel struct {
+	Name  string `protobuf:"bytes,1,opt,name=name"`
+	Value string `protobuf:"bytes,2,opt,name=value"`
+}
+
+func (m *testLabel) Reset()         { *m = testLabel{} }
+func (m *testLabel) String() string { return proto.CompactTextString(m) }
+func (*testLabel) ProtoMessage()    {}
+
+type testSample struct {
+	Value     float64 `protobuf:"fixed64,1,opt,name=value"`
+	Timestamp int64   `protobuf:"varint,2,opt,name=timestamp"`
+}
+
+func (m *testSample) Reset()         { *m = testSample{} }
+func (m *testSample) String() string { return proto.CompactTextString(m) }
+func (*testSample) ProtoMessage()    {}
+
+type testExemplar struct {
+	Labels    []*testLabel `protobuf:"bytes,1,rep,name=labels"`
+	Value     float64      `protobuf:"fixed64,2,opt,name=value"`
+	Timestamp int64        `protobuf:"varint,3,opt,name=timestamp"`
+}
+
+func (m *testExemplar) Reset()         { *m = testExemplar{} }
+func (m *testExemplar) String() string { return proto.CompactTextString(m) }
+func (*testExemplar) ProtoMessage()    {}
+
+func labels(s *testSeries) map[string]string {
+	return labelMap(s.Labels)
+}
+
+func labelMap(ls []*testLabel) map[string]string {
+	ret := map[string]string{}
+	for _, l := range ls {
+		ret[l.Name] = l.Value
+	}
+	return ret
+}
+
+func TestRemoteWriteTenants(t *testing.T) {
+	received := map[string][]*writeRequest{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "snappy", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		compressed, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		body, err := snappy.Decode(nil, compressed)
+		assert.NoError(t, err)
+		req := &writeRequest{}
+		assert.NoError(t, proto.Unmarshal(body, req))
+		tenant := r.Header.Get(TenantHeader)
+		received[tenant] = append(received[tenant], req)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	sink, err := NewRemoteWriteSink(srv.URL, "shared", "team", "host", "localhost", 1, &http.Client{}, logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+
+	err = sink.Flush(context.Background(), []samplers.InterMetric{
+		{Name: "api.requests", Timestamp: 100, Value: 3, Tags: []string{"team:search", "env:prod"}, Type: samplers.CounterMetric},
+		{Name: "api.latency", Timestamp: 100, Value: 1.5, Tags: []string{"team:search", "canary"}, Type: samplers.GaugeMetric},
+		{Name: "queue.depth", Timestamp: 100, Value: 7, Tags: []string{"env:prod"}, HostName: "worker-1", Type: samplers.GaugeMetric},
+		{Name: "service.ok", Timestamp: 100, Value: 0, Type: samplers.StatusMetric},
+	})
+	require.NoError(t, err)
+
+	require.Len(t, received, 2)
+	// With a batch size of 1, every series has a request of its own:
+	require.Len(t, received["search"], 2)
+	require.Len(t, received["shared"], 1)
+
+	series := received["shared"][0].Timeseries
+	require.Len(t, series, 1)
+	assert.Equal(t, map[string]string{
+		"__name__": "queue_depth",
+		"env":      "prod",
+		"host":     "worker-1",
+	}, labels(series[0]))
+	require.Len(t, series[0].Samples, 1)
+	assert.Equal(t, 7.0, series[0].Samples[0].Value)
+	assert.Equal(t, int64(100000), series[0].Samples[0].Timestamp)
+
+	var names []string
+	for _, req := range received["search"] {
+		require.Len(t, req.Timeseries, 1)
+		l := labels(req.Timeseries[0])
+		assert.Equal(t, "search", l["team"])
+		assert.Equal(t, "localhost", l["host"])
+		names = append(names, l["__name__"])
+		if l["__name__"] == "api_latency" {
+			assert.Equal(t, "true", l["canary"])
+		}
+	}
+	assert.ElementsMatch(t, []string{"api_requests", "api_latency"}, names)
+}
+
+func TestRemoteWriteError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	sink, err := NewRemoteWriteSink(srv.URL, "", "", "", "", 0, &http.Client{}, logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+
+	err = sink.Flush(context.Background(), []samplers.InterMetric{
+		{Name: "api.requests", Timestamp: 100, Value: 3, Type: samplers.CounterMetric},
+	})
+	assert.Error(t, err)
+}
+
+func TestRemoteWriteFlushDeadline(t *testing.T) {
+	srv, stop := sinktest.HangingServer()
+	defer stop()
+
+	sink, err := NewRemoteWriteSink(srv.URL, "", "", "", "", 0, &http.Client{}, logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+
+	sinktest.AssertFlushDeadline(t, func(ctx context.Context) {
+		err := sink.Flush(ctx, []samplers.InterMetric{
+			{Name: "api.requests", Timestamp: 100, Value: 3, Type: samplers.CounterMetric},
+		})
+		assert.Error(t, err)
+	})
+}
+
+func TestRemoteWriteExemplars(t *testing.T) {
+	var received *writeRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compressed, err := ioutil.ReadAll(r.Body)
+		assert.NoError(t, err)
+		body, err := snappy.Decode(nil, compressed)
+		assert.NoError(t, err)
+		received = &writeRequest{}
+		assert.NoError(t, proto.Unmarshal(body, received))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	sink, err := NewRemoteWriteSink(srv.URL, "", "", "", "", 0, &http.Client{}, logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+
+	err = sink.Flush(context.Background(), []samplers.InterMetric{
+		{Name: "span.duration.max", Timestamp: 100, Value: 2e9, Type: samplers.GaugeMetric,
+			Exemplar: &samplers.Exemplar{TraceID: 1, SpanID: 255, Value: 2e9}},
+		{Name: "span.duration.min", Timestamp: 100, Value: 1e9, Type: samplers.GaugeMetric},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, received)
+	require.Len(t, received.Timeseries, 2)
+
+	for _, series := range received.Timeseries {
+		if labels(series)["__name__"] == "span_duration_min" {
+			assert.Empty(t, series.Exemplars)
+			continue
+		}
+		require.Len(t, series.Exemplars, 1)
+		ex := series.Exemplars[0]
+		assert.Equal(t, map[string]string{
+			"trace_id": "00000000000000000000000000000001",
+			"span_id":  "00000000000000ff",
+		}, labelMap(ex.Labels))
+		assert.Equal(t, 2e9, ex.Value)
+		assert.Equal(t, int64(100000), ex.Timestamp)
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	assert.Equal(t, "a_b:c", metricName("a.b:c"))
+	assert.Equal(t, "a_b_c", labelName("a.b:c"))
+	assert.Equal(t, "_xx", labelName("1xx"))
+}
@@ -0,0 +1,345 @@
+// Package retry retries the requests that sinks send to their
+// backends, with exponential backoff and within a time budget, and
+// stops sending requests to a backend for a while once it keeps
+// failing, like a circuit breaker does. Each sink gets its own Policy.
+//
+// HTTP sinks get retries by sending their requests through the client
+// that Client returns, and gRPC sinks by dialing with
+// UnaryClientInterceptor, so the sinks themselves don't need to know
+// about retries.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
+	"github.com/stripe/veneur/trace/metrics"
+	"google.golang.org/grpc/status"
+)
+
+// ErrCircuitOpen is returned instead of sending a request while the
+// backend's circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open, not sending the request")
+
+// The defaults of the fields of a PolicyConfig that aren't set.
+const (
+	DefaultMaxAttempts            = 3
+	DefaultInitialBackoff         = 100 * time.Millisecond
+	DefaultMaxBackoff             = 5 * time.Second
+	DefaultCircuitBreakerCooldown = 30 * time.Second
+)
+
+// DefaultRetryableStatuses are the HTTP status codes that are retried
+// by default: timeouts, rate limits and server errors.
+var DefaultRetryableStatuses = []string{"408", "429", "5xx"}
+
+// DefaultRetryableCodes are the gRPC status codes that are retried by
+// default.
+var DefaultRetryableCodes = []string{"Unavailable", "ResourceExhausted", "Aborted"}
+
+// PolicyConfig is the configuration of a sink's Policy, as it appears
+// in veneur's config file.
+type PolicyConfig struct {
+	MaxAttempts            int      `yaml:"max_attempts"`
+	InitialBackoff         string   `yaml:"initial_backoff"`
+	MaxBackoff             string   `yaml:"max_backoff"`
+	Budget                 string   `yaml:"budget"`
+	RetryableStatuses      []string `yaml:"retryable_statuses"`
+	RetryableCodes         []string `yaml:"retryable_codes"`
+	CircuitBreakerFailures int      `yaml:"circuit_breaker_failures"`
+	CircuitBreakerCooldown string   `yaml:"circuit_breaker_cooldown"`
+	ContentHash            bool     `yaml:"content_hash"`
+}
+
+// Policy says how requests to a backend are retried.
+type Policy struct {
+	// MaxAttempts is how many times a request is sent at most,
+	// including the first time.
+	MaxAttempts int
+	// InitialBackoff is about how long to wait before the first
+	// retry. Each retry waits twice as long as the previous one, up
+	// to MaxBackoff, with jitter.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Budget, if not 0, bounds the time spent on a request across
+	// all attempts and backoffs. Requests are also bounded by their
+	// context's deadline.
+	Budget time.Duration
+
+	// RetryableStatuses are the HTTP status codes that are retried,
+	// either exact ("503") or a whole class ("5xx").
+	RetryableStatuses []string
+	// RetryableCodes are the names of the gRPC status codes that are
+	// retried, like "Unavailable".
+	RetryableCodes []string
+
+	// CircuitBreakerFailures, if not 0, is how many requests in a
+	// row must fail after all their attempts for the circuit
+	// breaker to open. While it's open, requests fail with
+	// ErrCircuitOpen without being sent, until CircuitBreakerCooldown
+	// has passed.
+	CircuitBreakerFailures int
+	CircuitBreakerCooldown time.Duration
+
+	// ContentHash makes HTTP requests carry the hash of their body in
+	// the ContentHashHeader, on every attempt, so that backends can
+	// tell a retried request from a new one.
+	ContentHash bool
+}
+
+// ParsePolicy validates a policy's configuration, and fills in the
+// defaults of the fields that aren't set.
+func ParsePolicy(conf PolicyConfig) (Policy, error) {
+	p := Policy{
+		MaxAttempts:            conf.MaxAttempts,
+		InitialBackoff:         DefaultInitialBackoff,
+		MaxBackoff:             DefaultMaxBackoff,
+		RetryableStatuses:      conf.RetryableStatuses,
+		RetryableCodes:         conf.RetryableCodes,
+		CircuitBreakerFailures: conf.CircuitBreakerFailures,
+		CircuitBreakerCooldown: DefaultCircuitBreakerCooldown,
+		ContentHash:            conf.ContentHash,
+	}
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = DefaultMaxAttempts
+	}
+	if p.MaxAttempts < 0 {
+		return p, fmt.Errorf("max_attempts must be positive, not %d", p.MaxAttempts)
+	}
+	if p.RetryableStatuses == nil {
+		p.RetryableStatuses = DefaultRetryableStatuses
+	}
+	if p.RetryableCodes == nil {
+		p.RetryableCodes = DefaultRetryableCodes
+	}
+	for _, s := range p.RetryableStatuses {
+		if !validStatusPattern(s) {
+			return p, fmt.Errorf("invalid retryable status %q, must be like 503 or 5xx", s)
+		}
+	}
+	durations := []struct {
+		value string
+		dest  *time.Duration
+		name  string
+	}{
+		{conf.InitialBackoff, &p.InitialBackoff, "initial_backoff"},
+		{conf.MaxBackoff, &p.MaxBackoff, "max_backoff"},
+		{conf.Budget, &p.Budget, "budget"},
+		{conf.CircuitBreakerCooldown, &p.CircuitBreakerCooldown, "circuit_breaker_cooldown"},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		var err error
+		if *d.dest, err = time.ParseDuration(d.value); err != nil {
+			return p, fmt.Errorf("invalid %s: %v", d.name, err)
+		}
+	}
+	return p, nil
+}
+
+func validStatusPattern(s string) bool {
+	if len(s) != 3 {
+		return false
+	}
+	if strings.HasSuffix(s, "xx") {
+		return s[0] >= '1' && s[0] <= '5'
+	}
+	code, err := strconv.Atoi(s)
+	return err == nil && code >= 100 && code < 600
+}
+
+// retryableStatus returns whether the policy retries an HTTP status
+// code.
+func (p Policy) retryableStatus(code int) bool {
+	exact := strconv.Itoa(code)
+	for _, s := range p.RetryableStatuses {
+		if s == exact || (strings.HasSuffix(s, "xx") && s[0] == exact[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// permanentError is an error that mustn't be retried.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Permanent marks an error that Retrier.Do must return without
+// retrying, like a request that the backend rejected as invalid.
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// statusError is the error of an HTTP request whose response has a
+// retryable status.
+type statusError struct {
+	code int
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("retryable HTTP status %d", e.code)
+}
+
+// Retrier sends requests to a single sink's backend according to its
+// Policy. It's safe for concurrent use.
+type Retrier struct {
+	sink   string
+	policy Policy
+	tc     *trace.Client
+	log    *logrus.Logger
+	now    func() time.Time
+
+	mtx sync.Mutex
+	// failures counts the requests in a row that failed after all
+	// their attempts
+	failures  int
+	openUntil time.Time
+}
+
+// New returns a Retrier for the named sink, which reports its retries
+// and circuit breaker state to the trace client.
+func New(sink string, policy Policy, tc *trace.Client, log *logrus.Logger) *Retrier {
+	return &Retrier{sink: sink, policy: policy, tc: tc, log: log, now: time.Now}
+}
+
+// Do calls attempt until it succeeds, until it returns an error that
+// isn't retryable, or until the policy's attempts or budget or the
+// context run out, and returns its last error. Errors returned from
+// attempt are retryable unless they were made with Permanent, or are
+// gRPC status errors whose code the policy doesn't retry.
+func (r *Retrier) Do(ctx context.Context, attempt func(context.Context) error) error {
+	return r.do(ctx, r.policy.MaxAttempts, attempt)
+}
+
+// do is Do with a different number of attempts than the policy's.
+func (r *Retrier) do(ctx context.Context, attempts int, attempt func(context.Context) error) error {
+	if !r.allow() {
+		metrics.ReportOne(r.tc, ssf.Count("sink.circuit_breaker_rejected_total", 1,
+			map[string]string{"sink": r.sink}, ssf.Failure(r.sink, ssf.CauseIOError)))
+		return ErrCircuitOpen
+	}
+
+	start := r.now()
+	backoff := r.policy.InitialBackoff
+	var err error
+	for n := 1; ; n++ {
+		err = attempt(ctx)
+		if err == nil || n >= attempts || !r.retryable(ctx, err) {
+			break
+		}
+		wait := jitter(backoff)
+		if r.policy.Budget > 0 && r.now().Sub(start)+wait > r.policy.Budget {
+			break
+		}
+		if !sleep(ctx, wait) {
+			break
+		}
+		metrics.ReportOne(r.tc, ssf.Count("sink.retries_total", 1, map[string]string{"sink": r.sink}))
+		backoff *= 2
+		if backoff > r.policy.MaxBackoff {
+			backoff = r.policy.MaxBackoff
+		}
+	}
+
+	// Only failures that could be the backend's fault count towards
+	// opening the circuit breaker:
+	r.record(err == nil || !r.retryable(context.Background(), err))
+	if p, ok := err.(permanentError); ok {
+		return p.err
+	}
+	return err
+}
+
+// retryable returns whether an attempt that failed with err should be
+// retried.
+func (r *Retrier) retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	switch err.(type) {
+	case permanentError:
+		return false
+	case statusError:
+		return true
+	}
+	if s, ok := status.FromError(err); ok {
+		code := s.Code().String()
+		for _, c := range r.policy.RetryableCodes {
+			if c == code {
+				return true
+			}
+		}
+		return false
+	}
+	// Errors from the network are worth retrying:
+	return true
+}
+
+// allow returns whether a request may be sent, which is whenever the
+// circuit breaker isn't open.
+func (r *Retrier) allow() bool {
+	if r.policy.CircuitBreakerFailures <= 0 {
+		return true
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return !r.now().Before(r.openUntil)
+}
+
+// record records whether a request succeeded for the circuit breaker.
+// Once the breaker's cooldown passes, requests are sent again, and the
+// first one that fails opens it again right away.
+func (r *Retrier) record(succeeded bool) {
+	if r.policy.CircuitBreakerFailures <= 0 {
+		return
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if succeeded {
+		r.failures = 0
+		return
+	}
+	r.failures++
+	if r.failures >= r.policy.CircuitBreakerFailures {
+		r.openUntil = r.now().Add(r.policy.CircuitBreakerCooldown)
+		metrics.ReportOne(r.tc, ssf.Count("sink.circuit_breaker_opened_total", 1, map[string]string{"sink": r.sink}))
+	}
+}
+
+// jitter returns a random duration between half of d and d, so that
+// sinks that fail at the same time don't retry at the same time.
+func jitter(d time.Duration) time.Duration {
+	if d <= 1 {
+		return d
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(d-half)))
+}
+
+// sleep waits for d, and returns false if the context is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,240 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func testPolicy() Policy {
+	p, _ := ParsePolicy(PolicyConfig{InitialBackoff: "1ms", MaxBackoff: "2ms"})
+	return p
+}
+
+func TestParsePolicy(t *testing.T) {
+	p, err := ParsePolicy(PolicyConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultMaxAttempts, p.MaxAttempts)
+	assert.Equal(t, DefaultInitialBackoff, p.InitialBackoff)
+	assert.Equal(t, time.Duration(0), p.Budget)
+	assert.True(t, p.retryableStatus(503))
+	assert.True(t, p.retryableStatus(429))
+	assert.False(t, p.retryableStatus(400))
+
+	p, err = ParsePolicy(PolicyConfig{MaxAttempts: 5, Budget: "10s", RetryableStatuses: []string{"503"}})
+	require.NoError(t, err)
+	assert.Equal(t, 5, p.MaxAttempts)
+	assert.Equal(t, 10*time.Second, p.Budget)
+	assert.True(t, p.retryableStatus(503))
+	assert.False(t, p.retryableStatus(500))
+
+	_, err = ParsePolicy(PolicyConfig{RetryableStatuses: []string{"5x"}})
+	assert.Error(t, err)
+	_, err = ParsePolicy(PolicyConfig{Budget: "soon"})
+	assert.Error(t, err)
+	_, err = ParsePolicy(PolicyConfig{MaxAttempts: -1})
+	assert.Error(t, err)
+}
+
+func TestDoRetries(t *testing.T) {
+	r := New("test", testPolicy(), nil, logrus.New())
+	attempts := 0
+	err := r.Do(context.Background(), func(context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+
+	attempts = 0
+	err = r.Do(context.Background(), func(context.Context) error {
+		attempts++
+		return errors.New("connection refused")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, DefaultMaxAttempts, attempts, "should give up after max_attempts")
+}
+
+func TestDoPermanent(t *testing.T) {
+	r := New("test", testPolicy(), nil, logrus.New())
+	attempts := 0
+	rejected := errors.New("bad request")
+	err := r.Do(context.Background(), func(context.Context) error {
+		attempts++
+		return Permanent(rejected)
+	})
+	assert.Equal(t, rejected, err, "permanent errors should be unwrapped")
+	assert.Equal(t, 1, attempts)
+}
+
+func TestDoGRPCCodes(t *testing.T) {
+	r := New("test", testPolicy(), nil, logrus.New())
+	for code, retried := range map[codes.Code]bool{
+		codes.Unavailable:     true,
+		codes.InvalidArgument: false,
+	} {
+		attempts := 0
+		r.Do(context.Background(), func(context.Context) error {
+			attempts++
+			return status.Error(code, "nope")
+		})
+		if retried {
+			assert.Equal(t, DefaultMaxAttempts, attempts, "%v should be retried", code)
+		} else {
+			assert.Equal(t, 1, attempts, "%v shouldn't be retried", code)
+		}
+	}
+}
+
+func TestDoBudget(t *testing.T) {
+	p := testPolicy()
+	p.MaxAttempts = 100
+	p.InitialBackoff = 20 * time.Millisecond
+	p.MaxBackoff = 20 * time.Millisecond
+	p.Budget = 50 * time.Millisecond
+	r := New("test", p, nil, logrus.New())
+
+	attempts := 0
+	r.Do(context.Background(), func(context.Context) error {
+		attempts++
+		return errors.New("timeout")
+	})
+	assert.True(t, attempts > 1 && attempts < 5, "the budget should allow only a few attempts, not %d", attempts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	attempts = 0
+	r.Do(ctx, func(context.Context) error {
+		attempts++
+		return errors.New("timeout")
+	})
+	assert.Equal(t, 1, attempts, "a done context shouldn't be retried")
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	p := testPolicy()
+	p.MaxAttempts = 1
+	p.CircuitBreakerFailures = 2
+	p.CircuitBreakerCooldown = time.Minute
+	r := New("test", p, nil, logrus.New())
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	fail := func(context.Context) error { return errors.New("connection refused") }
+	reject := func(context.Context) error { return Permanent(errors.New("bad request")) }
+	succeed := func(context.Context) error { return nil }
+
+	assert.Error(t, r.Do(context.Background(), fail))
+	assert.Error(t, r.Do(context.Background(), reject), "rejections shouldn't count as failures")
+	assert.NoError(t, r.Do(context.Background(), succeed))
+	assert.Error(t, r.Do(context.Background(), fail))
+	assert.Error(t, r.Do(context.Background(), fail))
+	assert.Equal(t, ErrCircuitOpen, r.Do(context.Background(), succeed))
+
+	now = now.Add(time.Minute)
+	assert.NoError(t, r.Do(context.Background(), succeed), "the breaker should close after the cooldown")
+	assert.NoError(t, r.Do(context.Background(), succeed))
+}
+
+func TestClient(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	client := New("test", testPolicy(), nil, logrus.New()).Client(srv.Client())
+	req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("payload"))
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, []string{"payload", "payload", "payload"}, bodies, "the body should be sent again")
+}
+
+func TestClientReturnsLastResponse(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadGateway)
+		io.WriteString(w, "upstream down")
+	}))
+	defer srv.Close()
+
+	client := New("test", testPolicy(), nil, logrus.New()).Client(srv.Client())
+	resp, err := client.Post(srv.URL, "text/plain", strings.NewReader("payload"))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, "upstream down", string(body))
+	assert.Equal(t, DefaultMaxAttempts, requests)
+}
+
+func TestClientStreamedBody(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	client := New("test", testPolicy(), nil, logrus.New()).Client(srv.Client())
+	body, w := io.Pipe()
+	go func() {
+		io.WriteString(w, "payload")
+		w.Close()
+	}()
+	resp, err := client.Post(srv.URL, "text/plain", body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 1, requests, "a streamed body can't be sent again")
+}
+
+func TestClientContentHash(t *testing.T) {
+	var hashes []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hashes = append(hashes, r.Header.Get(ContentHashHeader))
+		if len(hashes) < 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	p := testPolicy()
+	p.ContentHash = true
+	client := New("test", p, nil, logrus.New()).Client(srv.Client())
+	req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader("payload"))
+	require.NoError(t, err)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	hash := ContentHash([]byte("payload"))
+	assert.Equal(t, []string{hash, hash}, hashes, "every attempt should carry the same hash")
+	assert.Empty(t, req.Header.Get(ContentHashHeader), "the original request shouldn't be modified")
+}
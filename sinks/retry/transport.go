@@ -0,0 +1,150 @@
+package retry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+)
+
+// ContentHashHeader carries the hash of a request's body, when the
+// sink's policy has ContentHash set. It's the same on every attempt of
+// a request, so backends, including veneur's own /import endpoint, can
+// drop requests they already received.
+const ContentHashHeader = "X-Veneur-Content-Hash"
+
+// ContentHash returns the hash of a request body, as it appears in the
+// ContentHashHeader: the hex-encoded SHA-256 of the body.
+func ContentHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Client returns a client that sends requests like c does, retrying
+// them according to the Retrier's policy.
+//
+// Only requests whose body can be sent again are retried: those
+// without a body, and those made with http.NewRequest from a
+// bytes.Buffer, bytes.Reader or strings.Reader, or with GetBody set
+// otherwise. Other requests, like those that stream their body, are
+// sent once, but still count towards the circuit breaker. When a
+// request runs out of attempts, the client returns the last response,
+// so the sink handles it as it would without retries.
+func (r *Retrier) Client(c *http.Client) *http.Client {
+	withRetries := *c
+	withRetries.Transport = &roundTripper{inner: c.Transport, retrier: r}
+	return &withRetries
+}
+
+type roundTripper struct {
+	inner   http.RoundTripper
+	retrier *Retrier
+}
+
+func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	inner := rt.inner
+	if inner == nil {
+		inner = http.DefaultTransport
+	}
+	attempts := rt.retrier.policy.MaxAttempts
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		// the body can't be sent again
+		attempts = 1
+	}
+
+	var hash string
+	if rt.retrier.policy.ContentHash && req.GetBody != nil {
+		hash = rt.hash(req)
+	}
+
+	var resp *http.Response
+	n := 0
+	err := rt.retrier.do(req.Context(), attempts, func(ctx context.Context) error {
+		if resp != nil {
+			discard(resp)
+			resp = nil
+		}
+		n++
+		try := req
+		if n > 1 || hash != "" {
+			// RoundTrippers mustn't modify the request they're
+			// given.
+			try = new(http.Request)
+			*try = *req
+		}
+		if n > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return Permanent(err)
+			}
+			try.Body = body
+		}
+		if hash != "" {
+			try.Header = make(http.Header, len(req.Header)+1)
+			for k, v := range req.Header {
+				try.Header[k] = v
+			}
+			try.Header.Set(ContentHashHeader, hash)
+			rt.retrier.log.WithFields(logrus.Fields{
+				"sink":         rt.retrier.sink,
+				"content_hash": hash,
+				"attempt":      n,
+				"host":         req.URL.Host,
+				"path":         req.URL.Path,
+			}).Debug("Sending request")
+		}
+
+		var err error
+		resp, err = inner.RoundTrip(try)
+		if err != nil {
+			resp = nil
+			return err
+		}
+		if rt.retrier.policy.retryableStatus(resp.StatusCode) {
+			return statusError{resp.StatusCode}
+		}
+		return nil
+	})
+	if resp != nil {
+		return resp, nil
+	}
+	return nil, err
+}
+
+// hash returns the ContentHash of a request's body, or "" if the body
+// can't be read.
+func (rt *roundTripper) hash(req *http.Request) string {
+	body, err := req.GetBody()
+	if err != nil {
+		return ""
+	}
+	defer body.Close()
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return ""
+	}
+	return ContentHash(b)
+}
+
+// discard reads the rest of a response's body and closes it, so that
+// its connection can be reused.
+func discard(resp *http.Response) {
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// UnaryClientInterceptor returns an interceptor that retries unary
+// gRPC calls according to the Retrier's policy. Sinks dial with it
+// using grpc.WithUnaryInterceptor.
+func (r *Retrier) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return r.Do(ctx, func(ctx context.Context) error {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		})
+	}
+}
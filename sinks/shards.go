@@ -0,0 +1,45 @@
+package sinks
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/stripe/veneur/samplers"
+)
+
+// minShardSize is the fewest metrics that EncodeShards converts in a
+// goroutine of its own; smaller flushes aren't worth the goroutines.
+const minShardSize = 1000
+
+// EncodeShards converts the metrics that a sink flushes into its
+// backend's format on every core: it splits them into contiguous
+// shards, one per core, converts each shard with encode in a goroutine
+// of its own, and returns the shards' results in the order of the
+// metrics, for the sink to concatenate. encode must be safe to call
+// concurrently.
+func EncodeShards[T any](metrics []samplers.InterMetric, encode func([]samplers.InterMetric) T) []T {
+	shards := runtime.GOMAXPROCS(0)
+	if max := (len(metrics) + minShardSize - 1) / minShardSize; shards > max {
+		shards = max
+	}
+	if shards <= 1 {
+		return []T{encode(metrics)}
+	}
+
+	size := (len(metrics) + shards - 1) / shards
+	results := make([]T, (len(metrics)+size-1)/size)
+	wg := sync.WaitGroup{}
+	for i := range results {
+		shard := metrics[i*size:]
+		if len(shard) > size {
+			shard = shard[:size]
+		}
+		wg.Add(1)
+		go func(i int, shard []samplers.InterMetric) {
+			defer wg.Done()
+			results[i] = encode(shard)
+		}(i, shard)
+	}
+	wg.Wait()
+	return results
+}
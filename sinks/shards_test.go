@@ -0,0 +1,34 @@
+package sinks
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+)
+
+func TestEncodeShards(t *testing.T) {
+	names := func(metrics []samplers.InterMetric) []string {
+		var names []string
+		for _, m := range metrics {
+			names = append(names, m.Name)
+		}
+		return names
+	}
+
+	assert.Equal(t, [][]string{nil}, EncodeShards(nil, names))
+
+	metrics := make([]samplers.InterMetric, 10*minShardSize+1)
+	for i := range metrics {
+		metrics[i].Name = fmt.Sprintf("m%d", i)
+	}
+	shards := EncodeShards(metrics, names)
+	require.NotEmpty(t, shards)
+	var encoded []string
+	for _, shard := range shards {
+		encoded = append(encoded, shard...)
+	}
+	assert.Equal(t, names(metrics), encoded, "the shards should be returned in the order of the metrics")
+}
@@ -0,0 +1,403 @@
+package signalfx
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/signalfx/golib/sfxclient"
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/ssf"
+)
+
+// DefaultAPIEndpoint is the SignalFx API that dimension properties and
+// tags are sent to, unless configured otherwise. Unlike datapoints and
+// events, they aren't sent to the ingest endpoint.
+const DefaultAPIEndpoint = "https://api.signalfx.com"
+
+// DimensionRule sets properties and tags on the values of the
+// dimension Dimension that the sink sends metrics with, and that start
+// with ValuePrefix (an empty one matches every value): the Properties
+// as they are, and the values of the PropertiesFromDimensions that the
+// same metrics carry, as properties named like those dimensions.
+type DimensionRule struct {
+	Dimension                string
+	ValuePrefix              string
+	Properties               map[string]string
+	PropertiesFromDimensions []string
+	Tags                     []string
+}
+
+// dimension identifies a dimension value in the organization that the
+// API key for the value org of the sink's signalfx_vary_key_by tag
+// belongs to.
+type dimension struct {
+	key, value string
+	org        string
+}
+
+// dimensionMetadata is the JSON that SignalFx's dimension API takes to
+// update the properties and tags of a dimension.
+type dimensionMetadata struct {
+	CustomProperties map[string]string `json:"customProperties"`
+	Tags             []string          `json:"tags,omitempty"`
+}
+
+// dimensionUpdate is the body of an update to a dimension.
+type dimensionUpdate struct {
+	dimension dimension
+	body      string
+}
+
+const (
+	// dimensionUpdateWorkers is the number of updates that are sent
+	// at once.
+	dimensionUpdateWorkers = 4
+	// dimensionUpdateQueueSize bounds the updates that wait for a
+	// worker. Updates that don't fit are dropped, and retried on the
+	// next flush that their dimension is seen in.
+	dimensionUpdateQueueSize = 10000
+	// dimensionUpdateTimeout bounds each update.
+	dimensionUpdateTimeout = 10 * time.Second
+	// dimensionSentSize bounds the dimensions whose last update is
+	// remembered, and dimensionSentTTL how long it is remembered
+	// for, after which the dimension is updated again.
+	dimensionSentSize = 100000
+	dimensionSentTTL  = time.Hour
+)
+
+// dimensionUpdater collects the properties and tags that the rules
+// give the dimensions of the metrics flushed in an interval, and has a
+// pool of workers send those that changed since they were last sent to
+// SignalFx's dimension API, in the background.
+type dimensionUpdater struct {
+	endpoint string
+	apiKey   string
+	// apiKeys are the API keys for the values of the sink's
+	// signalfx_vary_key_by tag, like the sink's per-tag clients.
+	apiKeys map[string]string
+	client  *http.Client
+	rules   []DimensionRule
+	log     *logrus.Logger
+
+	queue  chan dimensionUpdate
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mtx     sync.Mutex
+	pending map[dimension]*dimensionMetadata
+	// queued is the body of each update waiting for or being sent
+	// by a worker.
+	queued map[dimension]string
+	// sent is the body last sent successfully for each dimension.
+	sent *sentCache
+
+	updated int64
+	failed  int64
+	dropped int64
+}
+
+func newDimensionUpdater(endpoint, apiKey string, apiKeys map[string]string, client *http.Client, rules []DimensionRule, log *logrus.Logger) (*dimensionUpdater, error) {
+	for _, rule := range rules {
+		if rule.Dimension == "" {
+			return nil, fmt.Errorf("signalfx dimension rule for %q has no dimension", rule.ValuePrefix)
+		}
+		if len(rule.Properties) == 0 && len(rule.PropertiesFromDimensions) == 0 && len(rule.Tags) == 0 {
+			return nil, fmt.Errorf("signalfx dimension rule for dimension %q sets no properties or tags", rule.Dimension)
+		}
+	}
+	if endpoint == "" {
+		endpoint = DefaultAPIEndpoint
+	}
+	u := &dimensionUpdater{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		apiKey:   apiKey,
+		apiKeys:  apiKeys,
+		client:   client,
+		rules:    rules,
+		log:      log,
+		queue:    make(chan dimensionUpdate, dimensionUpdateQueueSize),
+		pending:  map[dimension]*dimensionMetadata{},
+		queued:   map[dimension]string{},
+		sent:     newSentCache(dimensionSentSize, dimensionSentTTL),
+	}
+	u.ctx, u.cancel = context.WithCancel(context.Background())
+	for i := 0; i < dimensionUpdateWorkers; i++ {
+		u.wg.Add(1)
+		go u.work()
+	}
+	return u, nil
+}
+
+// observe records the properties and tags that the rules give the
+// dimensions of a metric, sent with the API key for org.
+func (u *dimensionUpdater) observe(dims map[string]string, org string) {
+	if _, ok := u.apiKeys[org]; !ok {
+		// sent with the default API key, like any other value's
+		org = ""
+	}
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	for _, rule := range u.rules {
+		value, ok := dims[rule.Dimension]
+		if !ok || value == "" || !strings.HasPrefix(value, rule.ValuePrefix) {
+			continue
+		}
+		d := dimension{key: rule.Dimension, value: value, org: org}
+		md, ok := u.pending[d]
+		if !ok {
+			md = &dimensionMetadata{CustomProperties: map[string]string{}}
+			u.pending[d] = md
+		}
+		for k, v := range rule.Properties {
+			md.CustomProperties[k] = v
+		}
+		for _, name := range rule.PropertiesFromDimensions {
+			if v := dims[name]; v != "" {
+				md.CustomProperties[name] = v
+			}
+		}
+		for _, tag := range rule.Tags {
+			if !containsString(md.Tags, tag) {
+				md.Tags = append(md.Tags, tag)
+			}
+		}
+	}
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// flush queues updates of the properties and tags of the dimensions
+// seen since the last flush, unless they were already sent as they are,
+// or are already queued. Dimensions that couldn't be updated, or didn't
+// fit in the queue, are retried on the next flush they're seen in.
+func (u *dimensionUpdater) flush(now time.Time) {
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	pending := u.pending
+	u.pending = map[dimension]*dimensionMetadata{}
+
+	for d, md := range pending {
+		sort.Strings(md.Tags)
+		bts, err := json.Marshal(md)
+		if err != nil {
+			u.log.WithError(err).WithField("dimension", d.key).Warn("Could not encode SignalFx dimension properties")
+			continue
+		}
+		body := string(bts)
+		if sent, ok := u.sent.get(d, now); ok && sent == body {
+			continue
+		}
+		if u.queued[d] == body {
+			continue
+		}
+		select {
+		case u.queue <- dimensionUpdate{dimension: d, body: body}:
+			u.queued[d] = body
+		default:
+			u.dropped++
+		}
+	}
+}
+
+// work sends queued updates until the updater is stopped.
+func (u *dimensionUpdater) work() {
+	defer u.wg.Done()
+	for {
+		select {
+		case <-u.ctx.Done():
+			return
+		case upd := <-u.queue:
+			u.send(upd)
+		}
+	}
+}
+
+// send sends an update, and remembers it if it succeeded.
+func (u *dimensionUpdater) send(upd dimensionUpdate) {
+	ctx, cancel := context.WithTimeout(u.ctx, dimensionUpdateTimeout)
+	defer cancel()
+	err := u.update(ctx, upd.dimension, []byte(upd.body))
+
+	u.mtx.Lock()
+	defer u.mtx.Unlock()
+	if u.queued[upd.dimension] == upd.body {
+		delete(u.queued, upd.dimension)
+	}
+	if err != nil {
+		u.log.WithError(err).WithFields(logrus.Fields{
+			"dimension": upd.dimension.key,
+			"value":     upd.dimension.value,
+		}).Warn("Could not update SignalFx dimension properties")
+		u.failed++
+		return
+	}
+	u.sent.put(upd.dimension, upd.body, time.Now())
+	u.updated++
+}
+
+// update sets the properties and tags of a dimension value in
+// SignalFx. PATCH adds them to the ones the dimension already has,
+// rather than replacing those, so that properties set by others stay.
+func (u *dimensionUpdater) update(ctx context.Context, d dimension, body []byte) error {
+	endpoint := fmt.Sprintf("%s/v2/dimension/%s/%s", u.endpoint, url.PathEscape(d.key), url.PathEscape(d.value))
+	req, err := http.NewRequest(http.MethodPatch, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(sfxclient.TokenHeaderName, u.apiKeyFor(d.org))
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("signalfx dimension API responded with HTTP status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// apiKeyFor returns the API key for a value of the sink's
+// signalfx_vary_key_by tag, or the default one.
+func (u *dimensionUpdater) apiKeyFor(org string) string {
+	if key, ok := u.apiKeys[org]; ok {
+		return key
+	}
+	return u.apiKey
+}
+
+// stop stops the workers, abandoning the updates that they're sending
+// and that are queued, and waits for them until ctx is done.
+func (u *dimensionUpdater) stop(ctx context.Context) error {
+	u.cancel()
+	done := make(chan struct{})
+	go func() {
+		u.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sentCache remembers the body last sent for up to size dimensions,
+// evicting the least recently seen ones, and for up to ttl, so that
+// dimensions whose properties don't change are still updated every
+// ttl, in case they were changed by others.
+type sentCache struct {
+	size    int
+	ttl     time.Duration
+	entries map[dimension]*list.Element
+	// order holds the *sentEntry of every dimension, the most
+	// recently seen first.
+	order *list.List
+}
+
+type sentEntry struct {
+	dimension dimension
+	body      string
+	sent      time.Time
+}
+
+func newSentCache(size int, ttl time.Duration) *sentCache {
+	return &sentCache{
+		size:    size,
+		ttl:     ttl,
+		entries: map[dimension]*list.Element{},
+		order:   list.New(),
+	}
+}
+
+// get returns the body last sent for a dimension, unless it was sent
+// ttl or longer ago.
+func (c *sentCache) get(d dimension, now time.Time) (string, bool) {
+	el, ok := c.entries[d]
+	if !ok {
+		return "", false
+	}
+	entry := el.Value.(*sentEntry)
+	if now.Sub(entry.sent) >= c.ttl {
+		c.order.Remove(el)
+		delete(c.entries, d)
+		return "", false
+	}
+	c.order.MoveToFront(el)
+	return entry.body, true
+}
+
+// put remembers the body sent for a dimension, and forgets the least
+// recently seen dimension if there are more than size of them.
+func (c *sentCache) put(d dimension, body string, now time.Time) {
+	if el, ok := c.entries[d]; ok {
+		el.Value = &sentEntry{dimension: d, body: body, sent: now}
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[d] = c.order.PushFront(&sentEntry{dimension: d, body: body, sent: now})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*sentEntry).dimension)
+	}
+}
+
+// report returns the number of dimensions whose properties were
+// updated, that couldn't be, and whose updates didn't fit in the
+// queue, since the last report.
+func (u *dimensionUpdater) report(name string) []*ssf.SSFSample {
+	u.mtx.Lock()
+	updated, failed, dropped := u.updated, u.failed, u.dropped
+	u.updated, u.failed, u.dropped = 0, 0, 0
+	u.mtx.Unlock()
+	return []*ssf.SSFSample{
+		ssf.Count("signalfx.dimension_updates_total", float32(updated), map[string]string{"sink": name}),
+		ssf.Count("signalfx.dimension_update_errors_total", float32(failed), map[string]string{"sink": name},
+			ssf.Failure(name, ssf.CauseIOError)),
+		ssf.Count("signalfx.dimension_updates_dropped_total", float32(dropped), map[string]string{"sink": name},
+			ssf.Failure(name, ssf.CauseQueueFull)),
+	}
+}
+
+// SetDimensionRules makes the sink update the properties and tags of
+// the dimensions of the metrics it flushes, as the rules say, in
+// SignalFx's dimension API at endpoint (DefaultAPIEndpoint, if it's
+// empty). The dimensions of metrics whose signalfx_vary_key_by tag has
+// a value in perTagAPIKeys are updated with that API key, and the
+// others with apiKey. Updates are sent in the background, once per
+// dimension value, and again whenever they change or an hour passes.
+func (sfx *SignalFxSink) SetDimensionRules(endpoint, apiKey string, perTagAPIKeys map[string]string, client *http.Client, rules []DimensionRule) error {
+	if sfx.dimensionUpdater != nil {
+		sfx.dimensionUpdater.cancel()
+	}
+	if len(rules) == 0 {
+		sfx.dimensionUpdater = nil
+		return nil
+	}
+	u, err := newDimensionUpdater(endpoint, apiKey, perTagAPIKeys, client, rules, sfx.log)
+	if err != nil {
+		return err
+	}
+	sfx.dimensionUpdater = u
+	return nil
+}
@@ -30,40 +30,70 @@ type collection struct {
 	sink        *SignalFxSink
 	points      []*datapoint.Datapoint
 	pointsByKey map[string][]*datapoint.Datapoint
+	// the series of the points, if the sink records them:
+	series      []sinks.FlushedSeries
+	seriesByKey map[string][]sinks.FlushedSeries
 }
 
-func (c *collection) addPoint(key string, point *datapoint.Datapoint) {
+func (c *collection) addPoint(key string, point *datapoint.Datapoint, series sinks.FlushedSeries) {
+	record := c.sink.recordSeries != nil
 	if c.sink.clientsByTagValue != nil {
 		if _, ok := c.sink.clientsByTagValue[key]; ok {
 			c.pointsByKey[key] = append(c.pointsByKey[key], point)
+			if record {
+				c.seriesByKey[key] = append(c.seriesByKey[key], series)
+			}
 			return
 		}
 	}
 	c.points = append(c.points, point)
+	if record {
+		c.series = append(c.series, series)
+	}
+}
+
+// merge adds the points of another collection after the collection's.
+func (c *collection) merge(other *collection) {
+	c.points = append(c.points, other.points...)
+	c.series = append(c.series, other.series...)
+	for key, points := range other.pointsByKey {
+		c.pointsByKey[key] = append(c.pointsByKey[key], points...)
+	}
+	for key, series := range other.seriesByKey {
+		c.seriesByKey[key] = append(c.seriesByKey[key], series...)
+	}
 }
 
 func (c *collection) submit(ctx context.Context, cl *trace.Client) error {
 	wg := &sync.WaitGroup{}
 	errorCh := make(chan error, len(c.pointsByKey)+1)
 
-	submitOne := func(client dpsink.Sink, points []*datapoint.Datapoint) {
+	submitOne := func(client dpsink.Sink, points []*datapoint.Datapoint, series []sinks.FlushedSeries) {
 		span, childCtx := trace.StartSpanFromContext(ctx, "")
 		span.SetTag("datapoint_count", len(points))
 		defer span.ClientFinish(cl)
 		defer wg.Done()
 		err := client.AddDatapoints(childCtx, points)
+		if c.sink.recordSeries != nil {
+			c.sink.recordSeries(series, err)
+		}
 		if err != nil {
 			span.Error(err)
-			span.Add(ssf.Count("flush.error_total", 1, map[string]string{"cause": "io", "sink": "signalfx"}))
+			cause := ssf.CauseIOError
+			if childCtx.Err() == context.DeadlineExceeded {
+				cause = ssf.CauseSinkTimeout
+			}
+			span.Add(ssf.Count("flush.error_total", 1, map[string]string{"reason": "io", "sink": "signalfx"},
+				ssf.Failure("signalfx", cause)))
 			errorCh <- err
 		}
 	}
 
 	wg.Add(1)
-	go submitOne(c.sink.defaultClient, c.points)
+	go submitOne(c.sink.defaultClient, c.points, c.series)
 	for key, points := range c.pointsByKey {
 		wg.Add(1)
-		go submitOne(c.sink.client(key), points)
+		go submitOne(c.sink.client(key), points, c.seriesByKey[key])
 	}
 	wg.Wait()
 	close(errorCh)
@@ -89,11 +119,19 @@ type SignalFxSink struct {
 	log                   *logrus.Logger
 	traceClient           *trace.Client
 	excludedTags          map[string]struct{}
+	unitDimension         string
 	metricNamePrefixDrops []string
 	metricTagPrefixDrops  []string
 	derivedMetrics        samplers.DerivedMetricsProcessor
+	dimensionUpdater      *dimensionUpdater
+
+	// recordSeries, if set, is called with the series of every
+	// request that the sink sends.
+	recordSeries func([]sinks.FlushedSeries, error)
 }
 
+var _ sinks.Stopper = &SignalFxSink{}
+
 // A DPClient is a client that can be used to submit signalfx data
 // points to an upstream consumer. It wraps the dpsink.Sink interface.
 type DPClient dpsink.Sink
@@ -109,6 +147,42 @@ func NewClient(endpoint, apiKey string, client *http.Client) DPClient {
 	return httpSink
 }
 
+// NewFailoverClient constructs a signalfx HTTP client for the given
+// endpoint that authenticates with apiKey until SignalFx rejects it,
+// then with secondaryAPIKey until SignalFx rejects that, and so on.
+func NewFailoverClient(endpoint, apiKey, secondaryAPIKey string, client *http.Client) DPClient {
+	return &failoverClient{
+		clients: map[string]DPClient{
+			apiKey:          NewClient(endpoint, apiKey, client),
+			secondaryAPIKey: NewClient(endpoint, secondaryAPIKey, client),
+		},
+		keys: sinks.NewCredentials(apiKey, secondaryAPIKey),
+	}
+}
+
+type failoverClient struct {
+	clients map[string]DPClient
+	keys    *sinks.Credentials
+}
+
+func (fc *failoverClient) AddDatapoints(ctx context.Context, points []*datapoint.Datapoint) error {
+	key := fc.keys.Current()
+	return fc.checkAuth(key, fc.clients[key].AddDatapoints(ctx, points))
+}
+
+func (fc *failoverClient) AddEvents(ctx context.Context, events []*event.Event) error {
+	key := fc.keys.Current()
+	return fc.checkAuth(key, fc.clients[key].AddEvents(ctx, events))
+}
+
+// checkAuth switches API keys if err means that SignalFx rejected key.
+func (fc *failoverClient) checkAuth(key string, err error) error {
+	if apiErr, ok := err.(sfxclient.SFXAPIError); ok && sinks.IsAuthFailure(apiErr.StatusCode) {
+		fc.keys.Rejected(key)
+	}
+	return err
+}
+
 // NewSignalFxSink creates a new SignalFx sink for metrics.
 func NewSignalFxSink(hostnameTag string, hostname string, commonDimensions map[string]string, log *logrus.Logger, client DPClient, varyBy string, perTagClients map[string]DPClient, metricNamePrefixDrops []string, metricTagPrefixDrops []string, derivedMetrics samplers.DerivedMetricsProcessor) (*SignalFxSink, error) {
 	return &SignalFxSink{
@@ -136,6 +210,14 @@ func (sfx *SignalFxSink) Start(traceClient *trace.Client) error {
 	return nil
 }
 
+// Stop stops sending dimension updates.
+func (sfx *SignalFxSink) Stop(ctx context.Context) error {
+	if sfx.dimensionUpdater == nil {
+		return nil
+	}
+	return sfx.dimensionUpdater.stop(ctx)
+}
+
 // client returns a client that can be used to submit to vary-by tag's
 // value. If no client is specified for that tag value, the default
 // client is returned.
@@ -152,6 +234,7 @@ func (sfx *SignalFxSink) newPointCollection() *collection {
 		sink:        sfx,
 		points:      []*datapoint.Datapoint{},
 		pointsByKey: map[string][]*datapoint.Datapoint{},
+		seriesByKey: map[string][]sinks.FlushedSeries{},
 	}
 }
 
@@ -164,86 +247,151 @@ func (sfx *SignalFxSink) Flush(ctx context.Context, interMetrics []samplers.Inte
 	coll := sfx.newPointCollection()
 	numPoints := 0
 	countSkipped := 0
-	countStatusMetrics := 0
+	for _, shard := range sinks.EncodeShards(interMetrics, sfx.collectShard) {
+		coll.merge(shard.coll)
+		numPoints += shard.points
+		countSkipped += shard.skipped
+	}
+	tags := map[string]string{"sink": "signalfx"}
+	span.Add(ssf.Count(sinks.MetricKeyTotalMetricsSkipped, float32(countSkipped), tags))
+	err := coll.submit(subCtx, sfx.traceClient)
+	if err != nil {
+		span.Error(err)
+	}
+	span.Add(ssf.Timing(sinks.MetricKeyMetricFlushDuration, time.Since(flushStart), time.Nanosecond, tags))
+	span.Add(ssf.Count(sinks.MetricKeyTotalMetricsFlushed, float32(numPoints), tags))
+	if fc, ok := sfx.defaultClient.(*failoverClient); ok {
+		span.Add(fc.keys.Report(sfx.Name())...)
+	}
+	sfx.log.WithField("metrics", len(interMetrics)).Info("Completed flush to SignalFx")
 
-METRICLOOP: // Convenience label so that inner nested loops and `continue` easily
-	for _, metric := range interMetrics {
-		if !sinks.IsAcceptableMetric(metric, sfx) {
-			countSkipped++
-			continue
-		}
-		if len(sfx.metricNamePrefixDrops) > 0 {
-			for _, pre := range sfx.metricNamePrefixDrops {
-				if strings.HasPrefix(metric.Name, pre) {
-					countSkipped++
-					continue METRICLOOP
-				}
-			}
-		}
-		if len(sfx.metricTagPrefixDrops) > 0 {
-			for _, dropTag := range sfx.metricTagPrefixDrops {
-				for _, tag := range metric.Tags {
-					if strings.HasPrefix(tag, dropTag) {
-						countSkipped++
-						continue METRICLOOP
-					}
-				}
-			}
-		}
-		dims := map[string]string{}
-		// Set the hostname as a tag, since SFx doesn't have a first-class hostname field
-		dims[sfx.hostnameTag] = sfx.hostname
-		for _, tag := range metric.Tags {
-			kv := strings.SplitN(tag, ":", 2)
-			key := kv[0]
+	if sfx.dimensionUpdater != nil {
+		sfx.dimensionUpdater.flush(time.Now())
+		span.Add(sfx.dimensionUpdater.report(sfx.Name())...)
+	}
 
-			if len(kv) == 1 {
-				dims[key] = ""
-			} else {
-				dims[key] = kv[1]
-			}
-		}
-		// Copy common dimensions
-		for k, v := range sfx.commonDimensions {
-			dims[k] = v
-		}
-		metricKey := ""
-		if sfx.varyBy != "" {
-			if val, ok := dims[sfx.varyBy]; ok {
-				metricKey = val
-			}
-		}
+	return err
+}
+
+// collectedShard is what collectShard converted a shard of the flushed
+// metrics into.
+type collectedShard struct {
+	coll    *collection
+	points  int
+	skipped int
+}
 
-		for k := range sfx.excludedTags {
-			delete(dims, k)
+// collectShard converts a shard of the flushed metrics into points.
+// It's called concurrently for every shard.
+func (sfx *SignalFxSink) collectShard(metrics []samplers.InterMetric) collectedShard {
+	shard := collectedShard{coll: sfx.newPointCollection()}
+	for _, metric := range metrics {
+		dims, metricKey, ok := sfx.dimensions(metric)
+		if !ok {
+			shard.skipped++
+			continue
+		}
+		if sfx.dimensionUpdater != nil {
+			sfx.dimensionUpdater.observe(dims, metricKey)
 		}
-		delete(dims, "veneursinkonly")
 
 		var point *datapoint.Datapoint
+		value := metric.Value
 		switch metric.Type {
 		case samplers.GaugeMetric:
 			point = sfxclient.GaugeF(metric.Name, dims, metric.Value)
 		case samplers.CounterMetric:
 			// TODO I am not certain if this should be a Counter or a Cumulative
 			point = sfxclient.Counter(metric.Name, dims, int64(metric.Value))
+			value = float64(int64(metric.Value))
 		case samplers.StatusMetric:
-			countStatusMetrics++
 			point = sfxclient.GaugeF(metric.Name, dims, metric.Value)
 		}
-		coll.addPoint(metricKey, point)
-		numPoints++
+		var series sinks.FlushedSeries
+		if sfx.recordSeries != nil {
+			series = sinks.FlushedSeries{
+				Key:     seriesKey(metric.Name, dims),
+				Value:   value,
+				Counter: metric.Type == samplers.CounterMetric,
+			}
+		}
+		shard.coll.addPoint(metricKey, point, series)
+		shard.points++
 	}
-	tags := map[string]string{"sink": "signalfx"}
-	span.Add(ssf.Count(sinks.MetricKeyTotalMetricsSkipped, float32(countSkipped), tags))
-	err := coll.submit(subCtx, sfx.traceClient)
-	if err != nil {
-		span.Error(err)
+	return shard
+}
+
+// dimensions returns the dimensions that a metric is sent with, and
+// the key of the client to send it with, or ok=false if the sink
+// drops the metric.
+func (sfx *SignalFxSink) dimensions(metric samplers.InterMetric) (dims map[string]string, metricKey string, ok bool) {
+	if !sinks.IsAcceptableMetric(metric, sfx) {
+		return nil, "", false
+	}
+	for _, pre := range sfx.metricNamePrefixDrops {
+		if strings.HasPrefix(metric.Name, pre) {
+			return nil, "", false
+		}
+	}
+	for _, dropTag := range sfx.metricTagPrefixDrops {
+		for _, tag := range metric.Tags {
+			if strings.HasPrefix(tag, dropTag) {
+				return nil, "", false
+			}
+		}
+	}
+	dims = map[string]string{}
+	// Set the hostname as a tag, since SFx doesn't have a first-class hostname field
+	dims[sfx.hostnameTag] = sfx.hostname
+	for _, tag := range metric.Tags {
+		kv := strings.SplitN(tag, ":", 2)
+		key := kv[0]
+
+		if len(kv) == 1 {
+			dims[key] = ""
+		} else {
+			dims[key] = kv[1]
+		}
+	}
+	// Copy common dimensions
+	for k, v := range sfx.commonDimensions {
+		dims[k] = v
+	}
+	if sfx.unitDimension != "" && metric.Unit != "" {
+		if _, ok := dims[sfx.unitDimension]; !ok {
+			dims[sfx.unitDimension] = metric.Unit
+		}
+	}
+	if sfx.varyBy != "" {
+		if val, ok := dims[sfx.varyBy]; ok {
+			metricKey = val
+		}
 	}
-	span.Add(ssf.Timing(sinks.MetricKeyMetricFlushDuration, time.Since(flushStart), time.Nanosecond, tags))
-	span.Add(ssf.Count(sinks.MetricKeyTotalMetricsFlushed, float32(numPoints), tags))
-	sfx.log.WithField("metrics", len(interMetrics)).Info("Completed flush to SignalFx")
 
-	return err
+	for k := range sfx.excludedTags {
+		delete(dims, k)
+	}
+	delete(dims, "veneursinkonly")
+	return dims, metricKey, true
+}
+
+// RecordSeries makes the sink report the series that it sends.
+func (sfx *SignalFxSink) RecordSeries(record func([]sinks.FlushedSeries, error)) {
+	sfx.recordSeries = record
+}
+
+// seriesKey returns the key of the series that a metric is sent to
+// SignalFx as, identified by its name and dimensions.
+func seriesKey(name string, dims map[string]string) string {
+	tags := make([]string, 0, len(dims))
+	for k, v := range dims {
+		if v == "" {
+			tags = append(tags, k)
+		} else {
+			tags = append(tags, k+":"+v)
+		}
+	}
+	return sinks.SeriesKey(name, tags)
 }
 
 var successSpanTags = map[string]string{"sink": "signalfx", "results": "success"}
@@ -256,21 +404,28 @@ func (sfx *SignalFxSink) FlushOtherSamples(ctx context.Context, samples []ssf.SS
 	defer span.ClientFinish(sfx.traceClient)
 	var countFailed = 0
 	var countSuccess = 0
-	for _, sample := range samples {
-		if _, ok := sample.Tags[dogstatsd.EventIdentifierKey]; ok {
-			err := sfx.reportEvent(ctx, &sample)
-			if err != nil {
-				countFailed++
-			} else {
-				countSuccess++
-			}
+	eventsByKey := map[string][]*event.Event{}
+	for i := range samples {
+		if _, ok := samples[i].Tags[dogstatsd.EventIdentifierKey]; ok {
+			ev, key := sfx.event(&samples[i])
+			eventsByKey[key] = append(eventsByKey[key], ev)
+		}
+	}
+	for key, events := range eventsByKey {
+		err := sfx.client(key).AddEvents(ctx, events)
+		if err != nil {
+			sfx.log.WithError(err).WithField("events", len(events)).Warn("Could not submit events to SignalFx")
+			countFailed += len(events)
+		} else {
+			countSuccess += len(events)
 		}
 	}
 	if countSuccess > 0 {
 		span.Add(ssf.Count(sinks.EventReportedCount, float32(countSuccess), successSpanTags))
 	}
 	if countFailed > 0 {
-		span.Add(ssf.Count(sinks.EventReportedCount, float32(countFailed), failureSpanTags))
+		span.Add(ssf.Count(sinks.EventReportedCount, float32(countFailed), failureSpanTags,
+			ssf.Failure(sfx.Name(), ssf.CauseIOError)))
 	}
 }
 
@@ -285,6 +440,13 @@ func (sfx *SignalFxSink) SetExcludedTags(excludes []string) {
 	sfx.excludedTags = tagsSet
 }
 
+// SetUnitDimension makes the sink add the unit of each metric, if
+// known, as a dimension with the given name. SignalFx datapoints have
+// no field for units.
+func (sfx *SignalFxSink) SetUnitDimension(name string) {
+	sfx.unitDimension = name
+}
+
 type ddSampleKind int
 
 const (
@@ -293,7 +455,20 @@ const (
 	ddSampleServiceCheck
 )
 
-func (sfx *SignalFxSink) reportEvent(ctx context.Context, sample *ssf.SSFSample) error {
+// eventProperties maps the tags that carry the fields of DogStatsD
+// events to the names of the SignalFx event properties they are sent
+// as. They describe the event, rather than what it happened to, so
+// they aren't dimensions.
+var eventProperties = map[string]string{
+	dogstatsd.EventAggregationKeyTagKey: "aggregation_key",
+	dogstatsd.EventAlertTypeTagKey:      "alert_type",
+	dogstatsd.EventPriorityTagKey:       "priority",
+	dogstatsd.EventSourceTypeTagKey:     "source_type",
+}
+
+// event converts an event sample to a SignalFx custom event, and
+// returns it with the key of the client to send it with.
+func (sfx *SignalFxSink) event(sample *ssf.SSFSample) (*event.Event, string) {
 	// Copy common dimensions in
 	dims := map[string]string{}
 	for k, v := range sfx.commonDimensions {
@@ -302,13 +477,27 @@ func (sfx *SignalFxSink) reportEvent(ctx context.Context, sample *ssf.SSFSample)
 	// And hostname
 	dims[sfx.hostnameTag] = sfx.hostname
 
+	properties := map[string]interface{}{}
 	for k, v := range sample.Tags {
-		if k == dogstatsd.EventIdentifierKey {
+		switch k {
+		case dogstatsd.EventIdentifierKey:
 			// Don't copy this tag
 			continue
+		case dogstatsd.EventHostnameTagKey:
+			// The event happened to another host:
+			dims[sfx.hostnameTag] = v
+			continue
+		}
+		if property, ok := eventProperties[k]; ok {
+			properties[property] = v
+			continue
 		}
 		dims[k] = v
 	}
+	var key string
+	if sfx.varyBy != "" {
+		key = dims[sfx.varyBy]
+	}
 
 	for k := range sfx.excludedTags {
 		delete(dims, k)
@@ -328,18 +517,13 @@ func (sfx *SignalFxSink) reportEvent(ctx context.Context, sample *ssf.SSFSample)
 	message = strings.Replace(message, "\n %%%", "", 1)
 	// Sometimes there are leading and trailing spaces
 	message = strings.TrimSpace(message)
+	properties["description"] = message
 
-	ev := event.Event{
+	return &event.Event{
 		EventType:  name,
 		Category:   event.USERDEFINED,
 		Dimensions: dims,
 		Timestamp:  time.Unix(sample.Timestamp, 0),
-		Properties: map[string]interface{}{
-			"description": message,
-		},
-	}
-
-	// TODO: Split events out the same way as points
-	// report evt
-	return sfx.defaultClient.AddEvents(ctx, []*event.Event{&ev})
+		Properties: properties,
+	}, key
 }
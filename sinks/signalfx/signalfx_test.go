@@ -2,9 +2,13 @@ package signalfx
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
+	"net/http/httptest"
 	"sort"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -13,8 +17,11 @@ import (
 	"github.com/signalfx/golib/sfxclient"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/internal/sinktest"
 	"github.com/stripe/veneur/protocol/dogstatsd"
 	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks"
 	"github.com/stripe/veneur/ssf"
 )
 
@@ -80,6 +87,89 @@ func TestNewSignalFxSink(t *testing.T) {
 	assert.Equal(t, map[string]string{"yay": "pie"}, sink.commonDimensions)
 }
 
+func TestSignalFxUnitDimension(t *testing.T) {
+	fakeSink := NewFakeSink()
+	derived := newDerivedProcessor()
+	sink, err := NewSignalFxSink("host", "glooblestoots", map[string]string{"yay": "pie"}, logrus.New(), fakeSink, "", nil, nil, nil, derived)
+	assert.NoError(t, err)
+
+	interMetrics := []samplers.InterMetric{
+		{Name: "a.b.c.max", Value: 100, Type: samplers.GaugeMetric, Unit: "ms"},
+		{Name: "a.b.c.count", Value: 10, Type: samplers.CounterMetric},
+		{Name: "already.tagged", Value: 1, Type: samplers.GaugeMetric, Unit: "ms", Tags: []string{"unit:s"}},
+	}
+	sink.Flush(context.TODO(), interMetrics)
+	for _, pt := range fakeSink.points {
+		if pt.Metric != "already.tagged" {
+			_, ok := pt.Dimensions["unit"]
+			assert.False(t, ok, "%s shouldn't have a unit dimension by default", pt.Metric)
+		}
+	}
+
+	fakeSink.points = nil
+	sink.SetUnitDimension("unit")
+	sink.Flush(context.TODO(), interMetrics)
+	units := map[string]string{}
+	for _, pt := range fakeSink.points {
+		units[pt.Metric] = pt.Dimensions["unit"]
+	}
+	assert.Equal(t, map[string]string{
+		"a.b.c.max":      "ms",
+		"a.b.c.count":    "",
+		"already.tagged": "s",
+	}, units)
+}
+
+// failingSink is a DPClient whose requests fail.
+type failingSink struct {
+	FakeSink
+}
+
+func (fs *failingSink) AddDatapoints(ctx context.Context, points []*datapoint.Datapoint) error {
+	return errors.New("request failed")
+}
+
+func TestSignalFxRecordSeries(t *testing.T) {
+	derived := newDerivedProcessor()
+	sink, err := NewSignalFxSink("host", "glooblestoots", map[string]string{"yay": "pie"}, logrus.New(), NewFakeSink(), "vary_by", map[string]DPClient{"broken": &failingSink{}}, []string{"drop."}, nil, derived)
+	assert.NoError(t, err)
+	sink.SetExcludedTags([]string{"secret"})
+	var mtx sync.Mutex
+	sent := map[string]float64{}
+	failed := map[string]float64{}
+	sink.RecordSeries(func(series []sinks.FlushedSeries, err error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		for _, s := range series {
+			if err != nil {
+				failed[s.Key] = s.Value
+			} else {
+				sent[s.Key] = s.Value
+			}
+		}
+	})
+
+	err = sink.Flush(context.Background(), []samplers.InterMetric{{
+		Name:  "a.b.c",
+		Value: 10.5,
+		Tags:  []string{"foo:bar", "secret:123", "novalue"},
+		Type:  samplers.CounterMetric,
+	}, {
+		Name: "drop.me",
+		Type: samplers.GaugeMetric,
+	}, {
+		Name:  "d.e.f",
+		Value: 1,
+		Tags:  []string{"vary_by:broken"},
+		Type:  samplers.GaugeMetric,
+	}})
+	assert.Error(t, err)
+	assert.Equal(t, map[string]float64{"a.b.c|foo:bar,host:glooblestoots,novalue,yay:pie": 10}, sent,
+		"counters should be recorded as the integers they're sent as")
+	assert.Equal(t, map[string]float64{"d.e.f|host:glooblestoots,vary_by:broken,yay:pie": 1}, failed,
+		"the series of failed requests should be recorded as failed")
+}
+
 func TestSignalFxFlushRouting(t *testing.T) {
 	fakeSink := NewFakeSink()
 	derived := newDerivedProcessor()
@@ -478,3 +568,232 @@ func TestSignalFxFlushMultiKey(t *testing.T) {
 	}
 	assert.Empty(t, derived.samples, "Gauges should not generated derived metrics")
 }
+
+func TestSignalFxFlushDeadline(t *testing.T) {
+	srv, stop := sinktest.HangingServer()
+	defer stop()
+
+	client := NewClient(srv.URL, "secret", &http.Client{})
+	derived := newDerivedProcessor()
+	sink, err := NewSignalFxSink("host", "glooblestoots", map[string]string{"yay": "pie"}, logrus.New(), client, "", nil, nil, nil, derived)
+	require.NoError(t, err)
+
+	sinktest.AssertFlushDeadline(t, func(ctx context.Context) {
+		err := sink.Flush(ctx, []samplers.InterMetric{{
+			Name:      "a.b.c",
+			Timestamp: time.Now().Unix(),
+			Value:     1,
+			Type:      samplers.GaugeMetric,
+		}})
+		assert.Error(t, err)
+	})
+}
+
+func TestSignalFxAPIKeyFailover(t *testing.T) {
+	var tokens []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get(sfxclient.TokenHeaderName)
+		tokens = append(tokens, token)
+		if token != "new" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`"OK"`))
+	}))
+	defer srv.Close()
+
+	client := NewFailoverClient(srv.URL, "old", "new", &http.Client{})
+	derived := newDerivedProcessor()
+	sink, err := NewSignalFxSink("host", "glooblestoots", map[string]string{"yay": "pie"}, logrus.New(), client, "", nil, nil, nil, derived)
+	require.NoError(t, err)
+
+	metrics := []samplers.InterMetric{{
+		Name:      "a.b.c",
+		Timestamp: time.Now().Unix(),
+		Value:     1,
+		Type:      samplers.GaugeMetric,
+	}}
+	assert.Error(t, sink.Flush(context.Background(), metrics))
+	assert.NoError(t, sink.Flush(context.Background(), metrics))
+	assert.Equal(t, []string{"old", "new"}, tokens)
+}
+
+func TestSignalFxEventFlushMultiKey(t *testing.T) {
+	fallback := NewFakeSink()
+	specialized := NewFakeSink()
+	sink, err := NewSignalFxSink("host", "glooblestoots", nil, logrus.New(), fallback, "test_by", map[string]DPClient{"available": specialized}, nil, nil, newDerivedProcessor())
+	require.NoError(t, err)
+
+	deploy := ssf.SSFSample{
+		Name:      "deploy",
+		Message:   "Deployed payments 1234abc",
+		Timestamp: time.Now().Unix(),
+		Tags: map[string]string{
+			dogstatsd.EventIdentifierKey:        "",
+			dogstatsd.EventHostnameTagKey:       "deploy-host",
+			dogstatsd.EventSourceTypeTagKey:     "jenkins",
+			dogstatsd.EventAggregationKeyTagKey: "payments",
+			"service":                           "payments",
+			"test_by":                           "available",
+		},
+	}
+	other := ssf.SSFSample{
+		Name:      "config change",
+		Timestamp: time.Now().Unix(),
+		Tags:      map[string]string{dogstatsd.EventIdentifierKey: ""},
+	}
+	sink.FlushOtherSamples(context.Background(), []ssf.SSFSample{deploy, other})
+
+	require.Len(t, specialized.events, 1)
+	ev := specialized.events[0]
+	assert.Equal(t, "deploy", ev.EventType)
+	assert.Equal(t, map[string]string{
+		"host":    "deploy-host",
+		"service": "payments",
+		"test_by": "available",
+	}, ev.Dimensions)
+	assert.Equal(t, map[string]interface{}{
+		"description":     "Deployed payments 1234abc",
+		"source_type":     "jenkins",
+		"aggregation_key": "payments",
+	}, ev.Properties)
+
+	require.Len(t, fallback.events, 1)
+	assert.Equal(t, "config change", fallback.events[0].EventType)
+}
+
+// dimensionUpdateRecorder records the dimension updates that a test
+// server gets.
+type dimensionUpdateRecorder struct {
+	mtx     sync.Mutex
+	updates []dimensionUpdateRecord
+}
+
+type dimensionUpdateRecord struct {
+	path, token string
+	metadata    dimensionMetadata
+}
+
+func (rec *dimensionUpdateRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u := dimensionUpdateRecord{path: r.URL.EscapedPath(), token: r.Header.Get(sfxclient.TokenHeaderName)}
+	if r.Method != http.MethodPatch || json.NewDecoder(r.Body).Decode(&u.metadata) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rec.mtx.Lock()
+	rec.updates = append(rec.updates, u)
+	rec.mtx.Unlock()
+}
+
+// wait returns the updates once there are n of them, sorted by path
+// and token, and forgets them.
+func (rec *dimensionUpdateRecorder) wait(t *testing.T, n int) []dimensionUpdateRecord {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		rec.mtx.Lock()
+		if len(rec.updates) >= n || time.Now().After(deadline) {
+			updates := rec.updates
+			rec.updates = nil
+			rec.mtx.Unlock()
+			require.Len(t, updates, n)
+			sort.Slice(updates, func(i, j int) bool {
+				if updates[i].path != updates[j].path {
+					return updates[i].path < updates[j].path
+				}
+				return updates[i].token < updates[j].token
+			})
+			return updates
+		}
+		rec.mtx.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSignalFxDimensionRules(t *testing.T) {
+	rec := &dimensionUpdateRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	sink, err := NewSignalFxSink("host", "glooblestoots", nil, logrus.New(), NewFakeSink(), "", nil, nil, nil, newDerivedProcessor())
+	require.NoError(t, err)
+	require.NoError(t, sink.SetDimensionRules(srv.URL, "secret", nil, &http.Client{}, []DimensionRule{
+		{Dimension: "host", PropertiesFromDimensions: []string{"az"}},
+		{Dimension: "service", ValuePrefix: "payments-", Properties: map[string]string{"team": "payments"}, Tags: []string{"pci"}},
+	}))
+	defer sink.Stop(context.Background())
+
+	metrics := []samplers.InterMetric{
+		{Name: "a.b.c", Value: 1, Type: samplers.GaugeMetric, Tags: []string{"az:us-west-2a", "service:payments-api"}},
+		{Name: "a.b.c", Value: 1, Type: samplers.GaugeMetric, Tags: []string{"service:search"}},
+	}
+	require.NoError(t, sink.Flush(context.Background(), metrics))
+	assert.Equal(t, []dimensionUpdateRecord{
+		{
+			path:     "/v2/dimension/host/glooblestoots",
+			token:    "secret",
+			metadata: dimensionMetadata{CustomProperties: map[string]string{"az": "us-west-2a"}},
+		},
+		{
+			path:     "/v2/dimension/service/payments-api",
+			token:    "secret",
+			metadata: dimensionMetadata{CustomProperties: map[string]string{"team": "payments"}, Tags: []string{"pci"}},
+		},
+	}, rec.wait(t, 2))
+
+	// unchanged dimensions are never queued, so once the changed one
+	// is sent, nothing else is:
+	require.NoError(t, sink.Flush(context.Background(), metrics))
+	metrics[0].Tags[0] = "az:us-west-2b"
+	require.NoError(t, sink.Flush(context.Background(), metrics))
+	updates := rec.wait(t, 1)
+	assert.Equal(t, map[string]string{"az": "us-west-2b"}, updates[0].metadata.CustomProperties,
+		"only the changed dimension should be updated")
+	time.Sleep(50 * time.Millisecond)
+	rec.wait(t, 0)
+
+	assert.Error(t, sink.SetDimensionRules("", "secret", nil, &http.Client{}, []DimensionRule{{Dimension: "host"}}),
+		"rules that set nothing should be rejected")
+}
+
+func TestSignalFxDimensionRulesPerTagAPIKeys(t *testing.T) {
+	rec := &dimensionUpdateRecorder{}
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+
+	sink, err := NewSignalFxSink("host", "glooblestoots", nil, logrus.New(), NewFakeSink(), "org", map[string]DPClient{"acme": NewFakeSink()}, nil, nil, newDerivedProcessor())
+	require.NoError(t, err)
+	require.NoError(t, sink.SetDimensionRules(srv.URL, "secret", map[string]string{"acme": "acmesecret"}, &http.Client{}, []DimensionRule{
+		{Dimension: "service", Properties: map[string]string{"managed": "true"}},
+	}))
+	defer sink.Stop(context.Background())
+
+	require.NoError(t, sink.Flush(context.Background(), []samplers.InterMetric{
+		{Name: "a.b.c", Value: 1, Type: samplers.GaugeMetric, Tags: []string{"org:acme", "service:api"}},
+		{Name: "a.b.c", Value: 1, Type: samplers.GaugeMetric, Tags: []string{"service:api"}},
+		{Name: "a.b.c", Value: 1, Type: samplers.GaugeMetric, Tags: []string{"org:other", "service:api"}},
+	}))
+	updates := rec.wait(t, 2)
+	assert.Equal(t, "acmesecret", updates[0].token, "the dimension should be updated in the organization of the metric's API key")
+	assert.Equal(t, "secret", updates[1].token)
+}
+
+func TestDimensionSentCache(t *testing.T) {
+	now := time.Now()
+	c := newSentCache(2, time.Hour)
+	a, b, d := dimension{key: "host", value: "a"}, dimension{key: "host", value: "b"}, dimension{key: "host", value: "d"}
+	c.put(a, "1", now)
+	c.put(b, "2", now)
+	_, ok := c.get(a, now)
+	require.True(t, ok)
+
+	c.put(d, "3", now)
+	_, ok = c.get(b, now)
+	assert.False(t, ok, "the least recently seen dimension should be forgotten")
+	body, ok := c.get(a, now)
+	assert.True(t, ok)
+	assert.Equal(t, "1", body)
+
+	_, ok = c.get(d, now.Add(time.Hour))
+	assert.False(t, ok, "dimensions should be forgotten once the TTL passes")
+	assert.Len(t, c.entries, 1)
+}
@@ -2,6 +2,8 @@ package sinks
 
 import (
 	"context"
+	"sort"
+	"strings"
 
 	"github.com/stripe/veneur/samplers"
 	"github.com/stripe/veneur/ssf"
@@ -40,7 +42,9 @@ type MetricSink interface {
 	// backend wants. Note that the sink must **not** mutate the
 	// incoming metrics as they are shared with other sinks. Sinks
 	// must also check each metric with IsAcceptableMetric to
-	// verify they are eligible to consume the metric.
+	// verify they are eligible to consume the metric. The
+	// context's deadline is that of the flush: requests to the
+	// backend must be canceled once it passes.
 	Flush(context.Context, []samplers.InterMetric) error
 	// Handle non-metric, non-span samples.
 	FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample)
@@ -55,6 +59,63 @@ func IsAcceptableMetric(metric samplers.InterMetric, sink MetricSink) bool {
 	return metric.Sinks.RouteTo(sink.Name())
 }
 
+// Stopper is implemented by the sinks that hold data or resources
+// that they must release when veneur shuts down, like the messages
+// that a producer buffers before sending them.
+type Stopper interface {
+	// Stop sends whatever the sink still buffers and releases its
+	// resources. It's invoked once when the server shuts down, and
+	// must give up once the context is done.
+	Stop(ctx context.Context) error
+}
+
+// DistributionSink is a MetricSink that can also send histograms and
+// timers as distributions, whose percentiles its backend computes.
+// The distributions aren't among the metrics that every sink gets.
+type DistributionSink interface {
+	MetricSink
+	// FlushWithDistributions is like Flush, with the distributions
+	// that the flushed histograms and timers were also flushed as.
+	// The sink must not mutate them either.
+	FlushWithDistributions(ctx context.Context, metrics, distributions []samplers.InterMetric) error
+}
+
+// FlushedSeries is a series that a sink sent to its backend.
+type FlushedSeries struct {
+	// Key identifies the series, as formed by SeriesKey.
+	Key string
+	// Value is the metric's value before any conversion for the
+	// sink's backend (e.g. of counters to rates), so that the
+	// values of different sinks are comparable.
+	Value float64
+	// Counter is set for counters, whose values add up when a sink
+	// sends several of them as the same series.
+	Counter bool
+}
+
+// SeriesRecorder is a MetricSink that can report the series it sends
+// to its backend, after applying its own filters and tag handling, and
+// whether its backend accepted them, so that the output of two sinks
+// can be compared while migrating from one to the other.
+type SeriesRecorder interface {
+	MetricSink
+	// RecordSeries makes the sink call record, for every request it
+	// sends while flushing, with the series the request holds and
+	// the error the request failed with, if any. record may be
+	// called concurrently. It's invoked once, before the sink is
+	// started.
+	RecordSeries(record func(series []FlushedSeries, err error))
+}
+
+// SeriesKey returns a key identifying the series with the given name
+// and tags, regardless of the order of the tags.
+func SeriesKey(name string, tags []string) string {
+	sorted := make([]string, len(tags))
+	copy(sorted, tags)
+	sort.Strings(sorted)
+	return name + "|" + strings.Join(sorted, ",")
+}
+
 // MetricKeySpanFlushDuration should be emitted as a timer by a SpanSink
 // if possible. Tagged with `sink:sink.Name()`. The `Flush` function is a great
 // place to do this. If your sync does async sends, this might not be necessary.
@@ -97,5 +158,7 @@ type SpanSink interface {
 
 	// Invoked at the same interval as metric flushes, this can be used as a
 	// signal for the sink to write out if it was buffering or something.
-	Flush()
+	// The context's deadline is that of the flush: sinks that send spans
+	// from Flush must give up on them once it passes.
+	Flush(context.Context)
 }
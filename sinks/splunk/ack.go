@@ -0,0 +1,205 @@
+package splunk
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// ackPollsPerTimeout is how many times per ack timeout the sink asks
+// the HEC which of its batches were indexed.
+const ackPollsPerTimeout = 4
+
+// ackMaxResubmissions is how many times a batch that the HEC never
+// acknowledges is submitted again before it's dropped.
+const ackMaxResubmissions = 3
+
+// pendingBatch is a submitted batch of events that the HEC hasn't
+// acknowledged yet.
+type pendingBatch struct {
+	body          []byte
+	sent          time.Time
+	resubmissions int
+}
+
+// ackKey identifies a submitted batch. Each HEC endpoint hands out
+// its own ackIds, so they're only unique per endpoint.
+type ackKey struct {
+	endpoint *hecEndpoint
+	id       int64
+}
+
+// hecAcks tracks the batches that were submitted with HEC indexer
+// acknowledgement, by their endpoint and ackId, until the HEC confirms
+// that they were indexed.
+type hecAcks struct {
+	timeout time.Duration
+
+	mtx         sync.Mutex
+	pending     map[ackKey]*pendingBatch
+	acked       int
+	resubmitted int
+	dropped     int
+}
+
+func newHecAcks(timeout time.Duration) *hecAcks {
+	return &hecAcks{timeout: timeout, pending: map[ackKey]*pendingBatch{}}
+}
+
+// add starts tracking a submitted batch.
+func (a *hecAcks) add(key ackKey, batch *pendingBatch) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	a.pending[key] = batch
+}
+
+// ids returns the ackIds of the batches that weren't acknowledged yet,
+// by the endpoint they were submitted to.
+func (a *hecAcks) ids() map[*hecEndpoint][]int64 {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	ids := map[*hecEndpoint][]int64{}
+	for key := range a.pending {
+		ids[key.endpoint] = append(ids[key.endpoint], key.id)
+	}
+	return ids
+}
+
+// ack stops tracking the batches that an endpoint acknowledged.
+func (a *hecAcks) ack(ep *hecEndpoint, acked []int64) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	for _, id := range acked {
+		key := ackKey{endpoint: ep, id: id}
+		if _, ok := a.pending[key]; ok {
+			delete(a.pending, key)
+			a.acked++
+		}
+	}
+}
+
+// expired returns the batches that weren't acknowledged within the
+// timeout.
+func (a *hecAcks) expired(now time.Time) map[ackKey]*pendingBatch {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	expired := map[ackKey]*pendingBatch{}
+	for key, batch := range a.pending {
+		if now.Sub(batch.sent) >= a.timeout {
+			expired[key] = batch
+		}
+	}
+	return expired
+}
+
+// replace replaces an expired batch's endpoint and ackId with the ones
+// it was submitted again with.
+func (a *hecAcks) replace(old, key ackKey, batch *pendingBatch) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	delete(a.pending, old)
+	a.pending[key] = batch
+	a.resubmitted++
+}
+
+// postpone waits for another timeout before an expired batch that
+// couldn't be submitted again is tried again. That counts as one of its
+// resubmissions.
+func (a *hecAcks) postpone(batch *pendingBatch, now time.Time) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	batch.sent = now
+	batch.resubmissions++
+}
+
+// drop stops tracking a batch that was never acknowledged.
+func (a *hecAcks) drop(key ackKey) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	delete(a.pending, key)
+	a.dropped++
+}
+
+// report returns the counts of batches that were acknowledged,
+// submitted again and dropped since the last report, and the number of
+// batches waiting for acknowledgement.
+func (a *hecAcks) report(sink string) []*ssf.SSFSample {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	samples := []*ssf.SSFSample{
+		ssf.Count("splunk.hec_ack_acknowledged_total", float32(a.acked), nil),
+		ssf.Count("splunk.hec_ack_resubmitted_total", float32(a.resubmitted), nil),
+		ssf.Count("splunk.hec_ack_dropped_total", float32(a.dropped), nil,
+			ssf.Failure(sink, ssf.CauseSinkTimeout)),
+		ssf.Gauge("splunk.hec_ack_pending", float32(len(a.pending)), nil),
+	}
+	a.acked, a.resubmitted, a.dropped = 0, 0, 0
+	return samples
+}
+
+// pollAcks periodically asks the HEC which batches were indexed, until
+// stop is closed.
+func (sss *splunkSpanSink) pollAcks(stop <-chan struct{}) {
+	ticker := time.NewTicker(sss.acks.timeout / ackPollsPerTimeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			sss.checkAcks()
+		}
+	}
+}
+
+// checkAcks asks each HEC endpoint which of the batches submitted to
+// it were indexed, and submits the batches that weren't acknowledged
+// within the timeout again, to the next endpoint in the rotation.
+func (sss *splunkSpanSink) checkAcks() {
+	ids := sss.acks.ids()
+	if len(ids) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sss.acks.timeout)
+	defer cancel()
+	for ep, epIDs := range ids {
+		acked, err := sss.hec.queryAcks(ctx, sss.httpClient, ep, epIDs)
+		if err != nil {
+			sss.log.WithError(err).WithField("endpoint", ep.url.Host).
+				Warn("Could not check Splunk HEC acknowledgements")
+			metrics.ReportOne(sss.traceClient, ssf.Count("splunk.hec_ack_errors_total", 1, nil,
+				ssf.Failure(sss.Name(), ssf.CauseIOError)))
+			continue
+		}
+		sss.acks.ack(ep, acked)
+	}
+
+	for key, batch := range sss.acks.expired(time.Now()) {
+		if batch.resubmissions >= ackMaxResubmissions {
+			sss.log.WithFields(logrus.Fields{
+				"ack_id":        key.id,
+				"endpoint":      key.endpoint.url.Host,
+				"resubmissions": batch.resubmissions,
+			}).Warn("Splunk HEC never acknowledged a batch, dropping it")
+			sss.acks.drop(key)
+			continue
+		}
+		ep := sss.hec.endpoints.pick(time.Now())
+		newID, err := sss.hec.submit(ctx, sss.httpClient, ep, batch.body)
+		if err != nil {
+			sss.log.WithError(err).WithField("ack_id", key.id).
+				Warn("Could not submit unacknowledged batch to Splunk HEC again")
+			sss.acks.postpone(batch, time.Now())
+			continue
+		}
+		sss.acks.replace(key, ackKey{endpoint: ep, id: newID}, &pendingBatch{
+			body:          batch.body,
+			sent:          time.Now(),
+			resubmissions: batch.resubmissions + 1,
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package splunk
+
+import (
+	"sync"
+	"time"
+
+	"github.com/stripe/veneur/ssf"
+)
+
+const (
+	// defaultWorkerScaleInterval is how often the ingest buffer is
+	// checked, unless configured otherwise.
+	defaultWorkerScaleInterval = time.Second
+	// scaleUpChecks is the number of checks in a row that have to
+	// find the ingest buffer at least three quarters full before
+	// the sink starts another submission worker.
+	scaleUpChecks = 3
+	// scaleDownChecks is the number of checks in a row that have to
+	// find the ingest buffer empty before the sink stops a
+	// submission worker.
+	scaleDownChecks = 10
+)
+
+// workerScaler decides how many submission workers the sink runs,
+// between a minimum and a maximum, from how full the ingest buffer is:
+// a buffer that stays mostly full means the workers can't keep up, and
+// one that stays empty means they idle.
+type workerScaler struct {
+	min, max int
+	interval time.Duration
+
+	mtx sync.Mutex
+	// full and empty count the checks in a row that found the buffer
+	// mostly full or empty.
+	full, empty int
+	scaledUp    int64
+	scaledDown  int64
+}
+
+// check records how many events wait in the ingest buffer of capacity
+// capacity, and returns how many workers to start (1) or stop (-1),
+// given that the sink runs workers of them.
+func (s *workerScaler) check(waiting, capacity, workers int) int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	switch {
+	case waiting*4 >= capacity*3:
+		s.full++
+		s.empty = 0
+	case waiting == 0:
+		s.empty++
+		s.full = 0
+	default:
+		s.full = 0
+		s.empty = 0
+	}
+	if s.full >= scaleUpChecks && workers < s.max {
+		s.full = 0
+		s.scaledUp++
+		return 1
+	}
+	if s.empty >= scaleDownChecks && workers > s.min {
+		s.empty = 0
+		s.scaledDown++
+		return -1
+	}
+	return 0
+}
+
+// report returns the number of workers that the sink runs, and the
+// number of times it started or stopped one since the last report.
+func (s *workerScaler) report(name string, workers int) []*ssf.SSFSample {
+	s.mtx.Lock()
+	up, down := s.scaledUp, s.scaledDown
+	s.scaledUp, s.scaledDown = 0, 0
+	s.mtx.Unlock()
+	return []*ssf.SSFSample{
+		ssf.Gauge("splunk.hec_submission_workers", float32(workers), map[string]string{"sink": name}),
+		ssf.Count("splunk.hec_submission_workers_scaled_total", float32(up), map[string]string{"sink": name, "direction": "up"}),
+		ssf.Count("splunk.hec_submission_workers_scaled_total", float32(down), map[string]string{"sink": name, "direction": "down"}),
+	}
+}
+
+// autoscale checks the ingest buffer every interval, and starts or
+// stops submission workers as the scaler decides, until stop is
+// closed.
+func (sss *splunkSpanSink) autoscale(stop <-chan struct{}) {
+	ticker := time.NewTicker(sss.scaler.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		sss.workersMtx.Lock()
+		select {
+		case <-stop:
+			// Stop already stopped the workers:
+			sss.workersMtx.Unlock()
+			return
+		default:
+		}
+		workers := len(sss.sync)
+		switch sss.scaler.check(len(sss.ingest), cap(sss.ingest), workers) {
+		case 1:
+			ch := make(chan struct{})
+			retire := make(chan struct{})
+			go sss.submitter(sss.workerClients[workers], ch, retire, &sync.Once{}, make(chan struct{}))
+			sss.sync = append(sss.sync, ch)
+			sss.retire = append(sss.retire, retire)
+			sss.log.WithField("workers", workers+1).Debug("Started a Splunk HEC submission worker")
+		case -1:
+			// only the workers that the scaler started are
+			// stopped, the last one first:
+			close(sss.retire[len(sss.retire)-1])
+			sss.retire = sss.retire[:len(sss.retire)-1]
+			sss.sync = sss.sync[:workers-1]
+			sss.log.WithField("workers", workers-1).Debug("Stopped a Splunk HEC submission worker")
+		}
+		sss.workersMtx.Unlock()
+	}
+}
+
+// Workers returns the number of submission workers that the sink runs.
+func (sss *splunkSpanSink) Workers() int {
+	sss.workersMtx.Lock()
+	defer sss.workersMtx.Unlock()
+	return len(sss.sync)
+}
@@ -0,0 +1,35 @@
+package splunk
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerScalerCheck(t *testing.T) {
+	s := &workerScaler{min: 1, max: 2}
+	assert.Equal(t, 0, s.check(8, 10, 1))
+	assert.Equal(t, 0, s.check(8, 10, 1))
+	assert.Equal(t, 0, s.check(5, 10, 1), "a buffer that drains should reset the count")
+	assert.Equal(t, 0, s.check(10, 10, 1))
+	assert.Equal(t, 0, s.check(10, 10, 1))
+	assert.Equal(t, 1, s.check(10, 10, 1), "a buffer that stays full should start a worker")
+	for i := 0; i < scaleUpChecks; i++ {
+		assert.Equal(t, 0, s.check(10, 10, 2), "there shouldn't be more than the maximum of workers")
+	}
+
+	for i := 1; i < scaleDownChecks; i++ {
+		assert.Equal(t, 0, s.check(0, 10, 2))
+	}
+	assert.Equal(t, -1, s.check(0, 10, 2), "a buffer that stays empty should stop a worker")
+	for i := 0; i < scaleDownChecks; i++ {
+		assert.Equal(t, 0, s.check(0, 10, 1), "there shouldn't be fewer than the minimum of workers")
+	}
+
+	samples := s.report("splunk", 1)
+	assert.Equal(t, float32(1), samples[0].Value)
+	assert.Equal(t, float32(1), samples[1].Value)
+	assert.Equal(t, "up", samples[1].Tags["direction"])
+	assert.Equal(t, float32(1), samples[2].Value)
+	assert.Equal(t, float32(0), s.report("splunk", 1)[1].Value, "the counters should reset on every report")
+}
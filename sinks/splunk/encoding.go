@@ -0,0 +1,259 @@
+package splunk
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"unicode/utf8"
+)
+
+// maxCachedStrings bounds the number of encoded strings that an
+// eventEncoder keeps around. Once it's exceeded, the cache starts over.
+const maxCachedStrings = 4096
+
+// eventEncoder encodes HEC events as JSON without reflection. Its
+// output is the same as encoding/json's. The fields that hardly vary
+// from one span to the next, like the host, sourcetype, service and
+// span name, are encoded once and cached.
+//
+// An eventEncoder isn't safe for concurrent use; each submitter has
+// its own.
+type eventEncoder struct {
+	cache map[string][]byte
+	buf   []byte
+	// raw makes encode leave out the HEC event envelope, for the
+	// raw endpoint: only the event itself is encoded.
+	raw bool
+}
+
+func newEventEncoder() *eventEncoder {
+	return &eventEncoder{cache: map[string][]byte{}}
+}
+
+// encode returns the JSON encoding of an event followed by a newline,
+// like json.Encoder writes it. The returned slice is only valid until
+// the next call.
+func (enc *eventEncoder) encode(e *Event) ([]byte, error) {
+	var buf []byte
+	var err error
+	if enc.raw {
+		buf, err = enc.appendData(enc.buf[:0], e.Event)
+	} else {
+		buf, err = enc.appendEvent(enc.buf[:0], e)
+	}
+	if err != nil {
+		return nil, err
+	}
+	buf = append(buf, '\n')
+	enc.buf = buf
+	return buf, nil
+}
+
+// MarshalJSON encodes the event without reflection, for spans.
+func (e *Event) MarshalJSON() ([]byte, error) {
+	return (&eventEncoder{}).appendEvent(nil, e)
+}
+
+func (enc *eventEncoder) appendEvent(buf []byte, e *Event) ([]byte, error) {
+	buf = append(buf, '{')
+	if e.Host != nil {
+		buf = append(buf, `"host":`...)
+		buf = enc.appendCachedString(buf, *e.Host)
+		buf = append(buf, ',')
+	}
+	if e.Index != nil {
+		buf = append(buf, `"index":`...)
+		buf = enc.appendCachedString(buf, *e.Index)
+		buf = append(buf, ',')
+	}
+	if e.Source != nil {
+		buf = append(buf, `"source":`...)
+		buf = enc.appendCachedString(buf, *e.Source)
+		buf = append(buf, ',')
+	}
+	if e.SourceType != nil {
+		buf = append(buf, `"sourcetype":`...)
+		buf = enc.appendCachedString(buf, *e.SourceType)
+		buf = append(buf, ',')
+	}
+	if e.Time != nil {
+		buf = append(buf, `"time":`...)
+		buf = appendString(buf, *e.Time)
+		buf = append(buf, ',')
+	}
+	buf = append(buf, `"event":`...)
+	buf, err := enc.appendData(buf, e.Event)
+	if err != nil {
+		return nil, err
+	}
+	if len(e.Fields) > 0 {
+		bts, err := json.Marshal(e.Fields)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, `,"fields":`...)
+		buf = append(buf, bts...)
+	}
+	return append(buf, '}'), nil
+}
+
+// appendData encodes the data of an event.
+func (enc *eventEncoder) appendData(buf []byte, data interface{}) ([]byte, error) {
+	switch ev := data.(type) {
+	case SerializedSSF:
+		return enc.appendSSF(buf, &ev), nil
+	case *SerializedSSF:
+		return enc.appendSSF(buf, ev), nil
+	default:
+		// Events that aren't spans are rare enough to go through
+		// encoding/json:
+		bts, err := json.Marshal(ev)
+		if err != nil {
+			return nil, err
+		}
+		return append(buf, bts...), nil
+	}
+}
+
+// appendSSF encodes a span in the order of SerializedSSF's fields.
+func (enc *eventEncoder) appendSSF(buf []byte, s *SerializedSSF) []byte {
+	buf = append(buf, `{"trace_id":`...)
+	buf = appendString(buf, s.TraceId)
+	buf = append(buf, `,"id":`...)
+	buf = appendString(buf, s.Id)
+	buf = append(buf, `,"parent_id":`...)
+	buf = appendString(buf, s.ParentId)
+	buf = append(buf, `,"start_timestamp":`...)
+	buf = appendFloat(buf, s.StartTimestamp)
+	buf = append(buf, `,"end_timestamp":`...)
+	buf = appendFloat(buf, s.EndTimestamp)
+	buf = append(buf, `,"duration_ns":`...)
+	buf = strconv.AppendInt(buf, s.Duration, 10)
+	buf = append(buf, `,"error":`...)
+	buf = strconv.AppendBool(buf, s.Error)
+	buf = append(buf, `,"service":`...)
+	buf = enc.appendCachedString(buf, s.Service)
+	buf = append(buf, `,"tags":`...)
+	if s.Tags == nil {
+		buf = append(buf, "null"...)
+	} else {
+		keys := make([]string, 0, len(s.Tags))
+		for k := range s.Tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf = append(buf, '{')
+		for i, k := range keys {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			buf = enc.appendCachedString(buf, k)
+			buf = append(buf, ':')
+			buf = appendString(buf, s.Tags[k])
+		}
+		buf = append(buf, '}')
+	}
+	buf = append(buf, `,"indicator":`...)
+	buf = strconv.AppendBool(buf, s.Indicator)
+	buf = append(buf, `,"name":`...)
+	buf = enc.appendCachedString(buf, s.Name)
+	return append(buf, '}')
+}
+
+// appendCachedString appends the encoding of a string that is likely
+// to repeat, encoding it only the first time.
+func (enc *eventEncoder) appendCachedString(buf []byte, s string) []byte {
+	if enc.cache == nil {
+		return appendString(buf, s)
+	}
+	encoded, ok := enc.cache[s]
+	if !ok {
+		if len(enc.cache) >= maxCachedStrings {
+			enc.cache = map[string][]byte{}
+		}
+		encoded = appendString(nil, s)
+		enc.cache[s] = encoded
+	}
+	return append(buf, encoded...)
+}
+
+const hex = "0123456789abcdef"
+
+// appendString appends a JSON string, escaped like encoding/json does
+// by default (including HTML characters).
+func appendString(buf []byte, s string) []byte {
+	buf = append(buf, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if b >= 0x20 && b != '"' && b != '\\' && b != '<' && b != '>' && b != '&' {
+				i++
+				continue
+			}
+			buf = append(buf, s[start:i]...)
+			switch b {
+			case '"', '\\':
+				buf = append(buf, '\\', b)
+			case '\n':
+				buf = append(buf, '\\', 'n')
+			case '\r':
+				buf = append(buf, '\\', 'r')
+			case '\t':
+				buf = append(buf, '\\', 't')
+			case '\b':
+				buf = append(buf, '\\', 'b')
+			case '\f':
+				buf = append(buf, '\\', 'f')
+			default:
+				buf = append(buf, '\\', 'u', '0', '0', hex[b>>4], hex[b&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, "\ufffd"...)
+			i += size
+			start = i
+			continue
+		}
+		// U+2028 and U+2029 are valid JSON, but not valid
+		// JavaScript:
+		if r == '\u2028' || r == '\u2029' {
+			buf = append(buf, s[start:i]...)
+			buf = append(buf, '\\', 'u', '2', '0', '2', hex[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	buf = append(buf, s[start:]...)
+	return append(buf, '"')
+}
+
+// appendFloat appends a float64 the way encoding/json formats it.
+func appendFloat(buf []byte, f float64) []byte {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		// encoding/json refuses these; timestamps never are.
+		return append(buf, '0')
+	}
+	abs := math.Abs(f)
+	format := byte('f')
+	if abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	buf = strconv.AppendFloat(buf, f, format, -1, 64)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		n := len(buf)
+		if n >= 4 && buf[n-4] == 'e' && buf[n-3] == '-' && buf[n-2] == '0' {
+			buf[n-2] = buf[n-1]
+			buf = buf[:n-1]
+		}
+	}
+	return buf
+}
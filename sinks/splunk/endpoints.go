@@ -0,0 +1,149 @@
+package splunk
+
+import (
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/stripe/veneur/ssf"
+)
+
+// endpointEjectionThreshold is how many submissions to an endpoint
+// have to fail in a row before it's ejected from the rotation.
+const endpointEjectionThreshold = 5
+
+// endpointEjectionPeriod is how long an ejected endpoint stays out of
+// the rotation, unless a health check finds it healthy earlier.
+const endpointEjectionPeriod = 30 * time.Second
+
+// hecEndpoint is one of the HEC URLs that the sink submits to.
+type hecEndpoint struct {
+	url *url.URL
+
+	// these fields are protected by the hecEndpoints' mutex:
+
+	// failures counts the submissions that failed in a row.
+	failures     int
+	ejectedUntil time.Time
+	// ejections counts the ejections since the last report.
+	ejections int
+}
+
+// resolve returns the URL of an endpoint's path, on the given channel.
+func (ep *hecEndpoint) resolve(path *url.URL, channel string) string {
+	endpoint := ep.url.ResolveReference(path)
+	q := endpoint.Query()
+	q.Add("channel", channel)
+	endpoint.RawQuery = q.Encode()
+	return endpoint.String()
+}
+
+// hecEndpoints spreads the submissions across HEC endpoints
+// round-robin, like the collectors in front of an indexer cluster.
+// Endpoints that keep failing, or that fail a health check, are
+// ejected from the rotation for a while. If every endpoint is
+// ejected, they all stay in the rotation, since there's nowhere
+// better to send events.
+type hecEndpoints struct {
+	mtx       sync.Mutex
+	endpoints []*hecEndpoint
+	next      int
+}
+
+func newHecEndpoints(servers []string) (*hecEndpoints, error) {
+	if len(servers) == 0 {
+		return nil, errors.New("no splunk HEC address given")
+	}
+	e := &hecEndpoints{}
+	for _, server := range servers {
+		u, err := url.Parse(server)
+		if err != nil {
+			return nil, err
+		}
+		e.endpoints = append(e.endpoints, &hecEndpoint{url: u})
+	}
+	return e, nil
+}
+
+// all returns every endpoint, whether it's ejected or not.
+func (e *hecEndpoints) all() []*hecEndpoint {
+	return e.endpoints
+}
+
+// pick returns the next endpoint in the rotation.
+func (e *hecEndpoints) pick(now time.Time) *hecEndpoint {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	for range e.endpoints {
+		ep := e.endpoints[e.next]
+		e.next = (e.next + 1) % len(e.endpoints)
+		if !now.Before(ep.ejectedUntil) {
+			return ep
+		}
+	}
+	ep := e.endpoints[e.next]
+	e.next = (e.next + 1) % len(e.endpoints)
+	return ep
+}
+
+// record tracks the outcome of a submission to an endpoint: it failed
+// if the request failed, or if the HEC responded with a status that
+// means it can't take events right now. It returns whether the
+// endpoint was ejected.
+func (e *hecEndpoints) record(ep *hecEndpoint, status int, err error, now time.Time) bool {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	if err == nil && !retryable(status) {
+		ep.failures = 0
+		return false
+	}
+	ep.failures++
+	if ep.failures < endpointEjectionThreshold || now.Before(ep.ejectedUntil) {
+		return false
+	}
+	e.eject(ep, now)
+	return true
+}
+
+// setHealthy ejects an endpoint that failed a health check, and puts
+// an ejected endpoint that passed one back into the rotation.
+func (e *hecEndpoints) setHealthy(ep *hecEndpoint, healthy bool, now time.Time) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	switch {
+	case healthy:
+		ep.failures = 0
+		ep.ejectedUntil = time.Time{}
+	case !now.Before(ep.ejectedUntil):
+		e.eject(ep, now)
+	}
+}
+
+func (e *hecEndpoints) eject(ep *hecEndpoint, now time.Time) {
+	ep.failures = 0
+	ep.ejectedUntil = now.Add(endpointEjectionPeriod)
+	ep.ejections++
+}
+
+// report returns the number of ejections of each endpoint since the
+// last report, and the number of endpoints in the rotation.
+func (e *hecEndpoints) report(sink string, now time.Time) []*ssf.SSFSample {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	var samples []*ssf.SSFSample
+	available := 0
+	for _, ep := range e.endpoints {
+		if !now.Before(ep.ejectedUntil) {
+			available++
+		}
+		if ep.ejections > 0 {
+			samples = append(samples, ssf.Count("splunk.hec_endpoint_ejections_total", float32(ep.ejections),
+				map[string]string{"endpoint": ep.url.Host},
+				ssf.Failure(sink, ssf.CauseIOError)))
+			ep.ejections = 0
+		}
+	}
+	samples = append(samples, ssf.Gauge("splunk.hec_endpoints_available", float32(available), nil))
+	return samples
+}
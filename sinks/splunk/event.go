@@ -16,6 +16,9 @@ type Event struct {
 	SourceType *string     `json:"sourcetype,omitempty"`
 	Time       *string     `json:"time,omitempty"`
 	Event      interface{} `json:"event"`
+	// Fields holds the name, value and dimensions of a metric, whose
+	// Event is "metric".
+	Fields map[string]interface{} `json:"fields,omitempty"`
 }
 
 func NewEvent(data interface{}) *Event {
@@ -0,0 +1,254 @@
+package splunk
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	vhttp "github.com/stripe/veneur/http"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks"
+	"github.com/stripe/veneur/sinks/retry"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// metricBatchSize is the default maximum number of metrics per HEC
+// request.
+const metricBatchSize = 1000
+
+var _ sinks.MetricSink = &SplunkMetricSink{}
+var _ sinks.Stopper = &SplunkMetricSink{}
+
+// SplunkMetricSink sends metrics to the HEC in Splunk's metric event
+// format, as a metric_name, a _value and a dimension for each of the
+// metric's tags. The index that they're stored in has to be a metrics
+// index.
+type SplunkMetricSink struct {
+	hec        *hecClient
+	httpClient *http.Client
+	hostname   string
+	index      string
+	batchSize  int
+	// maxBatchBytes, if positive, bounds the size of the encoded
+	// events in a request.
+	maxBatchBytes int
+
+	// tokenFile, if set, is re-read every tokenRefreshInterval, so
+	// that the tokens can be rotated without restarting.
+	tokenFile            string
+	tokenRefreshInterval time.Duration
+	// stop is closed when the sink stops, which ends the watch of
+	// the token file.
+	stop chan struct{}
+
+	traceClient *trace.Client
+	log         *logrus.Logger
+}
+
+// NewSplunkMetricSink creates a sink that sends metrics to the HEC
+// servers, round-robin, in batches of up to batchSize metrics. The
+// servers and the token, secondaryToken, tokenFile,
+// tokenRefreshInterval, validateServerName, sendTimeout, tlsConfig
+// and gzipPayloads arguments work like the fields of SpanSinkOptions. If
+// index is set, metrics are stored in it instead of the token's
+// default index. If retrier is set, requests that the HEC rejects with
+// a 429 or 5xx status are retried according to its policy. If
+// maxBatchBytes is positive, requests are also kept within that many
+// bytes of events (before compression); metrics whose event alone is
+// larger are dropped.
+func NewSplunkMetricSink(servers []string, token string, secondaryToken string, tokenFile string, tokenRefreshInterval time.Duration, localHostname string, validateServerName string, sendTimeout time.Duration, tlsConfig *tls.Config, gzipPayloads bool, index string, batchSize int, maxBatchBytes int, retrier *retry.Retrier, log *logrus.Logger) (*SplunkMetricSink, error) {
+	if tokenFile != "" {
+		var err error
+		token, secondaryToken, err = sinks.ReadCredentialsFile(tokenFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	client, err := newHecClient(servers, token, secondaryToken, gzipPayloads)
+	if err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 {
+		batchSize = metricBatchSize
+	}
+	httpClient := newHTTPClient(1, validateServerName, sendTimeout, tlsConfig)
+	if retrier != nil {
+		httpClient = retrier.Client(httpClient)
+	}
+	return &SplunkMetricSink{
+		hec:                  client,
+		httpClient:           httpClient,
+		hostname:             localHostname,
+		index:                index,
+		batchSize:            batchSize,
+		maxBatchBytes:        maxBatchBytes,
+		tokenFile:            tokenFile,
+		tokenRefreshInterval: tokenRefreshInterval,
+		stop:                 make(chan struct{}),
+		log:                  log,
+	}, nil
+}
+
+// Name returns the name of this sink.
+func (*SplunkMetricSink) Name() string {
+	return "splunk"
+}
+
+// Start sets up the sink, and starts watching the token file, if it
+// has one.
+func (s *SplunkMetricSink) Start(cl *trace.Client) error {
+	s.traceClient = cl
+	if s.tokenFile != "" && s.tokenRefreshInterval > 0 {
+		go s.hec.watchTokenFile(s.tokenFile, s.tokenRefreshInterval, s.stop, s.log, cl)
+	}
+	return nil
+}
+
+// Stop stops watching the token file. Metrics are only sent while
+// flushing, so there is nothing left to send.
+func (s *SplunkMetricSink) Stop(ctx context.Context) error {
+	close(s.stop)
+	return nil
+}
+
+// Flush sends metrics to the HEC, in one or more requests.
+func (s *SplunkMetricSink) Flush(ctx context.Context, interMetrics []samplers.InterMetric) error {
+	samples := &ssf.Samples{}
+	defer metrics.Report(s.traceClient, samples)
+	flushStart := time.Now()
+
+	enc := newEventEncoder()
+	var firstErr error
+	var batch bytes.Buffer
+	batched, flushed, oversized := 0, 0, 0
+	submit := func() {
+		if batched == 0 {
+			return
+		}
+		if err := s.submit(ctx, batch.Bytes()); err != nil {
+			samples.Add(ssf.Count("flush.error_total", 1, map[string]string{"sink": s.Name()},
+				ssf.Failure(s.Name(), errorCause(err))))
+			s.log.WithFields(logrus.Fields{
+				"metrics":       batched,
+				logrus.ErrorKey: err}).Warn("Error submitting metrics to Splunk HEC")
+			if firstErr == nil {
+				firstErr = err
+			}
+		} else {
+			flushed += batched
+		}
+		batch.Reset()
+		batched = 0
+	}
+	for _, metric := range interMetrics {
+		if !sinks.IsAcceptableMetric(metric, s) {
+			continue
+		}
+		// Splunk's metrics have no notion of service checks.
+		if metric.Type == samplers.StatusMetric {
+			continue
+		}
+		encoded, err := enc.encode(s.event(metric))
+		if err != nil {
+			s.log.WithError(err).WithField("metric", metric.Name).
+				Warn("Could not json-encode HEC event")
+			continue
+		}
+		if s.maxBatchBytes > 0 {
+			if len(encoded) > s.maxBatchBytes {
+				oversized++
+				continue
+			}
+			if batch.Len()+len(encoded) > s.maxBatchBytes {
+				submit()
+			}
+		}
+		batch.Write(encoded)
+		batched++
+		if batched >= s.batchSize {
+			submit()
+		}
+	}
+	submit()
+	if oversized > 0 {
+		samples.Add(ssf.Count("splunk.hec_oversized_metrics_dropped_total", float32(oversized), nil,
+			ssf.Failure(s.Name(), ssf.CauseRejected)))
+		s.log.WithField("metrics", oversized).
+			Warn("Dropped metrics that are larger than the maximum HEC batch")
+	}
+	samples.Add(s.hec.tokens.Report(s.Name())...)
+	samples.Add(s.hec.endpoints.report(s.Name(), time.Now())...)
+	samples.Add(ssf.Count(sinks.MetricKeyTotalMetricsFlushed, float32(flushed), map[string]string{"sink": s.Name()}))
+	samples.Add(ssf.Timing(sinks.MetricKeyMetricFlushDuration, time.Since(flushStart), time.Nanosecond, map[string]string{"sink": s.Name()}))
+	return firstErr
+}
+
+// FlushOtherSamples does nothing: events are only sent as spans.
+func (s *SplunkMetricSink) FlushOtherSamples(ctx context.Context, samples []ssf.SSFSample) {
+}
+
+func errorCause(err error) string {
+	if statusErr, ok := err.(vhttp.StatusError); ok {
+		return vhttp.StatusCause(statusErr.StatusCode)
+	}
+	return ssf.CauseIOError
+}
+
+// event returns the HEC metric event of a metric.
+func (s *SplunkMetricSink) event(metric samplers.InterMetric) *Event {
+	fields := make(map[string]interface{}, len(metric.Tags)+2)
+	for _, tag := range metric.Tags {
+		kv := strings.SplitN(tag, ":", 2)
+		value := "true"
+		if len(kv) == 2 {
+			value = kv[1]
+		}
+		fields[kv[0]] = value
+	}
+	// the name and value win over tags of the same name:
+	fields["metric_name"] = metric.Name
+	fields["_value"] = metric.Value
+
+	event := &Event{Event: "metric", Fields: fields}
+	event.SetTime(time.Unix(metric.Timestamp, 0))
+	hostname := metric.HostName
+	if hostname == "" {
+		hostname = s.hostname
+	}
+	if hostname != "" {
+		event.SetHost(hostname)
+	}
+	if s.index != "" {
+		event.SetIndex(s.index)
+	}
+	return event
+}
+
+// submit sends a batch of encoded metric events to the next endpoint
+// in the rotation; post tracks the outcome towards its ejection.
+func (s *SplunkMetricSink) submit(ctx context.Context, batch []byte) error {
+	ep := s.hec.endpoints.pick(time.Now())
+	status, parsed, err := s.hec.post(ctx, s.httpClient, ep, batch)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		if sinks.IsAuthFailure(status) && s.hec.tokens.Rejected(s.hec.tokens.Current()) {
+			s.log.WithField("http_status_code", status).
+				Warn("Splunk HEC rejected the token, switching to the other one")
+		}
+		s.log.WithFields(logrus.Fields{
+			"http_status_code":  status,
+			"hec_status_code":   parsed.Code,
+			"hec_response_text": parsed.Text,
+		}).Debug("Error response from Splunk HEC")
+		return vhttp.StatusError{StatusCode: status}
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package splunk_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/internal/sinktest"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/sinks/splunk"
+)
+
+// metricEvent is a HEC metric event, as the sink sends it.
+type metricEvent struct {
+	Host   string                 `json:"host"`
+	Index  string                 `json:"index"`
+	Time   string                 `json:"time"`
+	Event  string                 `json:"event"`
+	Fields map[string]interface{} `json:"fields"`
+}
+
+func TestMetricSinkFlush(t *testing.T) {
+	var mtx sync.Mutex
+	var batches [][]metricEvent
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/services/collector", r.URL.Path)
+		assert.Equal(t, "Splunk good", r.Header.Get("Authorization"))
+		var batch []metricEvent
+		dec := json.NewDecoder(r.Body)
+		for dec.More() {
+			var event metricEvent
+			require.NoError(t, dec.Decode(&event))
+			batch = append(batch, event)
+		}
+		mtx.Lock()
+		batches = append(batches, batch)
+		mtx.Unlock()
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer ts.Close()
+
+	sink, err := splunk.NewSplunkMetricSink([]string{ts.URL}, "good", "", "", 0, "test-host", "", 0, nil, false,
+		"metrics", 2, 0, nil, logrus.StandardLogger())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+
+	err = sink.Flush(context.Background(), []samplers.InterMetric{
+		{
+			Name:      "a.b.c",
+			Timestamp: 1476119058,
+			Value:     100,
+			Tags:      []string{"foo:bar", "baz", "metric_name:nope"},
+			Type:      samplers.CounterMetric,
+		},
+		{
+			Name:      "d.e",
+			Timestamp: 1476119058,
+			Value:     0.5,
+			Type:      samplers.GaugeMetric,
+			HostName:  "other-host",
+		},
+		{
+			Name:      "service.check",
+			Timestamp: 1476119058,
+			Type:      samplers.StatusMetric,
+		},
+		{
+			Name:      "f",
+			Timestamp: 1476119059,
+			Value:     3,
+			Type:      samplers.GaugeMetric,
+		},
+	})
+	require.NoError(t, err)
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	require.Len(t, batches, 2, "metrics should be sent in batches of 2, without the service check")
+	require.Len(t, batches[0], 2)
+	require.Len(t, batches[1], 1)
+
+	first := batches[0][0]
+	assert.Equal(t, "metric", first.Event)
+	assert.Equal(t, "test-host", first.Host)
+	assert.Equal(t, "metrics", first.Index)
+	assert.Equal(t, "1476119058.000", first.Time)
+	assert.Equal(t, map[string]interface{}{
+		"metric_name": "a.b.c",
+		"_value":      float64(100),
+		"foo":         "bar",
+		"baz":         "true",
+	}, first.Fields)
+	assert.Equal(t, "other-host", batches[0][1].Host)
+	assert.Equal(t, "f", batches[1][0].Fields["metric_name"])
+}
+
+func TestMetricSinkRejected(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"text":"Incorrect index","code":7}`))
+	}))
+	defer ts.Close()
+
+	sink, err := splunk.NewSplunkMetricSink([]string{ts.URL}, "good", "", "", 0, "test-host", "", 0, nil, false,
+		"events", 0, 0, nil, logrus.StandardLogger())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+
+	err = sink.Flush(context.Background(), []samplers.InterMetric{
+		{Name: "a.b.c", Timestamp: time.Now().Unix(), Value: 1, Type: samplers.GaugeMetric},
+	})
+	assert.Error(t, err)
+}
+
+func TestMetricSinkFlushDeadline(t *testing.T) {
+	ts, stop := sinktest.HangingServer()
+	defer stop()
+
+	sink, err := splunk.NewSplunkMetricSink([]string{ts.URL}, "good", "", "", 0, "test-host", "", 0, nil, false,
+		"events", 0, 0, nil, logrus.StandardLogger())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+
+	sinktest.AssertFlushDeadline(t, func(ctx context.Context) {
+		err := sink.Flush(ctx, []samplers.InterMetric{
+			{Name: "a.b.c", Timestamp: time.Now().Unix(), Value: 1, Type: samplers.GaugeMetric},
+		})
+		assert.Error(t, err)
+	})
+}
+
+func TestMetricSinkMaxBatchBytes(t *testing.T) {
+	const maxBatchBytes = 400
+	var mtx sync.Mutex
+	var sizes []int
+	events := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		mtx.Lock()
+		sizes = append(sizes, len(body))
+		events += bytes.Count(body, []byte("\n"))
+		mtx.Unlock()
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer ts.Close()
+
+	sink, err := splunk.NewSplunkMetricSink([]string{ts.URL}, "good", "", "", 0, "test-host", "", 0, nil, false,
+		"metrics", 100, maxBatchBytes, nil, logrus.StandardLogger())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+
+	var metrics []samplers.InterMetric
+	for i := 0; i < 6; i++ {
+		metrics = append(metrics, samplers.InterMetric{
+			Name:      fmt.Sprintf("a.b.c%d", i),
+			Timestamp: 1476119058,
+			Value:     float64(i),
+			Tags:      []string{"foo:bar"},
+			Type:      samplers.GaugeMetric,
+		})
+	}
+	metrics[3].Tags = []string{"huge:" + strings.Repeat("x", maxBatchBytes)}
+	require.NoError(t, sink.Flush(context.Background(), metrics))
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	assert.Equal(t, 5, events, "the oversized metric should be dropped")
+	assert.True(t, len(sizes) > 1, "the metrics should be split across requests")
+	for _, size := range sizes {
+		assert.True(t, size <= maxBatchBytes, "submitted %d bytes", size)
+	}
+}
+
+func TestMetricSinkEjectsFailingEndpoint(t *testing.T) {
+	var mtx sync.Mutex
+	failed, accepted := 0, 0
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		failed++
+		mtx.Unlock()
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"text":"Server is busy","code":9}`))
+	}))
+	defer failing.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		accepted++
+		mtx.Unlock()
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer healthy.Close()
+
+	sink, err := splunk.NewSplunkMetricSink([]string{failing.URL, healthy.URL}, "good", "", "", 0, "test-host", "", 0, nil, false,
+		"metrics", 1, 0, nil, logrus.StandardLogger())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+	defer sink.Stop(context.Background())
+
+	var metrics []samplers.InterMetric
+	for i := 0; i < 20; i++ {
+		metrics = append(metrics, samplers.InterMetric{
+			Name:      fmt.Sprintf("a.b.c%d", i),
+			Timestamp: 1476119058,
+			Value:     float64(i),
+			Type:      samplers.GaugeMetric,
+		})
+	}
+	assert.Error(t, sink.Flush(context.Background(), metrics))
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	assert.Equal(t, 5, failed, "the failing endpoint should be ejected after 5 failures in a row")
+	assert.Equal(t, 15, accepted)
+}
@@ -1,23 +1,37 @@
 package splunk
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/satori/go.uuid"
+	"github.com/stripe/veneur/sinks"
 )
 
 type hecClient struct {
-	token     string
-	serverURL *url.URL
+	tokens    *sinks.Credentials
+	endpoints *hecEndpoints
 	idGen     uuid.UUID
+	// gzip compresses the batches that are submitted.
+	gzip bool
+	// raw, if set, makes the batches go to the raw endpoint with
+	// these query parameters, rather than to the event endpoint.
+	raw url.Values
 }
 
-func newHecClient(serverURL string, token string) (*hecClient, error) {
-	u, err := url.Parse(serverURL)
+func newHecClient(servers []string, token string, secondaryToken string, compress bool) (*hecClient, error) {
+	endpoints, err := newHecEndpoints(servers)
 	if err != nil {
 		return nil, err
 	}
@@ -25,13 +39,19 @@ func newHecClient(serverURL string, token string) (*hecClient, error) {
 	if err != nil {
 		return nil, err
 	}
-	cl := hecClient{token: token, serverURL: u, idGen: id}
+	cl := hecClient{tokens: sinks.NewCredentials(token, secondaryToken), endpoints: endpoints, idGen: id, gzip: compress}
 	return &cl, nil
 }
 
 const rawEndpointStr = "services/collector"
+const rawDataEndpointStr = "services/collector/raw"
+const healthEndpointStr = "services/collector/health"
+const ackEndpointStr = "services/collector/ack"
 
 var rawEndpoint *url.URL
+var rawDataEndpoint *url.URL
+var healthEndpoint *url.URL
+var ackEndpoint *url.URL
 
 func init() {
 	var err error
@@ -39,48 +59,258 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
+	rawDataEndpoint, err = url.Parse(rawDataEndpointStr)
+	if err != nil {
+		panic(err)
+	}
+	healthEndpoint, err = url.Parse(healthEndpointStr)
+	if err != nil {
+		panic(err)
+	}
+	ackEndpoint, err = url.Parse(ackEndpointStr)
+	if err != nil {
+		panic(err)
+	}
 }
 
-// newRequest creates a new streaming HEC raw request and returns the
-// writer to it. The request is submitted when the writer is closed.
+// HEC status codes of responses: hecCodeSuccess for requests whose
+// events were accepted, and hecCodeNoData for requests that hold no
+// events.
+const (
+	hecCodeSuccess = 0
+	hecCodeNoData  = 5
+)
+
+// newRequest creates a new streaming HEC raw request to the next
+// endpoint in the rotation and returns the writer to it. The request
+// is submitted when the writer is closed.
 func (c *hecClient) newRequest() (*hecRequest, error) {
-	req := &hecRequest{url: c.url(c.idGen.String()), authHeader: c.authHeader()}
+	token := c.tokens.Current()
+	ep := c.endpoints.pick(time.Now())
+	req := &hecRequest{url: c.submissionURL(ep), endpoint: ep, token: token, authHeader: authHeader(token)}
 	req.r, req.w = io.Pipe()
+	if c.gzip {
+		req.gz = gzip.NewWriter(req.w)
+	}
 	return req, nil
 }
 
+// submissionURL returns the URL on an endpoint that batches are
+// submitted to.
+func (c *hecClient) submissionURL(ep *hecEndpoint) string {
+	if c.raw == nil {
+		return ep.resolve(rawEndpoint, c.idGen.String())
+	}
+	return ep.resolve(rawDataEndpoint, c.idGen.String()) + "&" + c.raw.Encode()
+}
+
 type hecRequest struct {
 	r          io.ReadCloser
 	w          io.WriteCloser
+	gz         *gzip.Writer
 	url        string
+	endpoint   *hecEndpoint
+	token      string
 	authHeader string
 }
 
-func (r *hecRequest) Start(ctx context.Context) (*http.Request, *json.Encoder, error) {
+func (r *hecRequest) Start(ctx context.Context) (*http.Request, io.Writer, error) {
 	req, err := http.NewRequest("POST", r.url, r.r)
 	if err != nil {
 		return nil, nil, err
 	}
 	req.Header.Add("Authorization", r.authHeader)
+	if r.gz != nil {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
 	req = req.WithContext(ctx)
 
-	return req, json.NewEncoder(r.w), nil
+	return req, r.writer(), nil
+}
+
+// writer returns the writer that the request's events are encoded
+// into, which compresses them if the request is gzipped.
+func (r *hecRequest) writer() io.Writer {
+	if r.gz != nil {
+		return r.gz
+	}
+	return r.w
 }
 
 func (r *hecRequest) Close() error {
+	if r.gz != nil {
+		if err := r.gz.Close(); err != nil {
+			r.w.Close()
+			return err
+		}
+	}
 	return r.w.Close()
 }
 
-func (c *hecClient) url(channel string) string {
-	endpoint := c.serverURL.ResolveReference(rawEndpoint)
-	q := endpoint.Query()
-	q.Add("channel", channel)
-	endpoint.RawQuery = q.Encode()
-	return endpoint.String()
+func authHeader(token string) string {
+	return "Splunk " + token
+}
+
+// checkHealth returns an error if the health endpoint of a HEC
+// endpoint reports that it can't accept events.
+func (c *hecClient) checkHealth(ctx context.Context, client *http.Client, ep *hecEndpoint) error {
+	req, err := http.NewRequest("GET", ep.url.ResolveReference(healthEndpoint).String(), nil)
+	if err != nil {
+		return err
+	}
+	status, parsed, err := c.do(ctx, client, req, c.tokens.Current())
+	if err != nil {
+		return fmt.Errorf("could not reach splunk HEC at %s: %v", ep.url.Host, err)
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("splunk HEC is unhealthy: HTTP status %d, HEC code %d: %s", status, parsed.Code, parsed.Text)
+	}
+	return nil
+}
+
+// validateToken submits a request with no events, which the HEC
+// accepts or rejects based on the token alone, and returns an error if
+// the token was rejected. If there is a secondary token, the HEC has
+// to reject both for validation to fail.
+func (c *hecClient) validateToken(ctx context.Context, client *http.Client) error {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		token := c.tokens.Current()
+		var status int
+		status, err = c.validate(ctx, client, token)
+		if err == nil || !sinks.IsAuthFailure(status) || !c.tokens.Rejected(token) {
+			break
+		}
+	}
+	return err
 }
 
-func (c *hecClient) authHeader() string {
-	return "Splunk " + c.token
+// validate returns the HTTP status of a request with no events,
+// authenticated with token, and an error if the HEC rejected it.
+func (c *hecClient) validate(ctx context.Context, client *http.Client, token string) (int, error) {
+	ep := c.endpoints.pick(time.Now())
+	req, err := http.NewRequest("POST", c.submissionURL(ep), strings.NewReader(""))
+	if err != nil {
+		return 0, err
+	}
+	status, parsed, err := c.do(ctx, client, req, token)
+	if err != nil {
+		return 0, err
+	}
+	if status == http.StatusOK || (status == http.StatusBadRequest && parsed.Code == hecCodeNoData) {
+		return status, nil
+	}
+	return status, fmt.Errorf("splunk HEC rejected the token: HTTP status %d, HEC code %d: %s", status, parsed.Code, parsed.Text)
+}
+
+// do authenticates a request with token and submits it, and returns
+// its HTTP status and parsed HEC response, if it has one.
+func (c *hecClient) do(ctx context.Context, client *http.Client, req *http.Request, token string) (int, Response, error) {
+	var parsed Response
+	req.Header.Add("Authorization", authHeader(token))
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, parsed, err
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	// not every response has a HEC status in its body:
+	_ = json.NewDecoder(resp.Body).Decode(&parsed)
+	return resp.StatusCode, parsed, nil
+}
+
+// post submits a batch of encoded events to an endpoint, and returns
+// the HTTP status and parsed HEC response. The outcome counts towards
+// the endpoint's ejection.
+func (c *hecClient) post(ctx context.Context, client *http.Client, ep *hecEndpoint, batch []byte) (int, Response, error) {
+	body := batch
+	if c.gzip {
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		if _, err := gz.Write(batch); err != nil {
+			return 0, Response{}, err
+		}
+		if err := gz.Close(); err != nil {
+			return 0, Response{}, err
+		}
+		body = compressed.Bytes()
+	}
+	req, err := http.NewRequest("POST", c.submissionURL(ep), bytes.NewReader(body))
+	if err != nil {
+		return 0, Response{}, err
+	}
+	if c.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	status, parsed, err := c.do(ctx, client, req, c.tokens.Current())
+	c.endpoints.record(ep, status, err, time.Now())
+	return status, parsed, err
+}
+
+// submit submits a batch of encoded events to an endpoint, and returns
+// the ID that the HEC acknowledges it with once it's indexed.
+func (c *hecClient) submit(ctx context.Context, client *http.Client, ep *hecEndpoint, batch []byte) (int64, error) {
+	status, parsed, err := c.post(ctx, client, ep, batch)
+	if err != nil {
+		return 0, err
+	}
+	if status != http.StatusOK {
+		return 0, fmt.Errorf("splunk HEC rejected the batch: HTTP status %d, HEC code %d: %s", status, parsed.Code, parsed.Text)
+	}
+	if parsed.AckID == nil {
+		return 0, errors.New("splunk HEC didn't return an ackId, is indexer acknowledgement enabled for the token?")
+	}
+	return *parsed.AckID, nil
+}
+
+// ackRequest is the body of a request to the HEC's ack endpoint.
+type ackRequest struct {
+	Acks []int64 `json:"acks"`
+}
+
+// ackResponse is the HEC's response to an ackRequest. Its keys are
+// the requested ackIds, as strings.
+type ackResponse struct {
+	Acks map[string]bool `json:"acks"`
+}
+
+// queryAcks asks a HEC endpoint which of the batches submitted to it
+// with the given ackIds were indexed, and returns the ackIds of those
+// that were.
+func (c *hecClient) queryAcks(ctx context.Context, client *http.Client, ep *hecEndpoint, ids []int64) ([]int64, error) {
+	body, err := json.Marshal(ackRequest{Acks: ids})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", ep.resolve(ackEndpoint, c.idGen.String()), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", authHeader(c.tokens.Current()))
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("splunk HEC ack request failed: HTTP status %d", resp.StatusCode)
+	}
+	var parsed ackResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, err
+	}
+	var acked []int64
+	for _, id := range ids {
+		if parsed.Acks[strconv.FormatInt(id, 10)] {
+			acked = append(acked, id)
+		}
+	}
+	return acked, nil
 }
 
 // Response represents the JSON-parseable response from a splunk HEC
@@ -89,4 +319,7 @@ type Response struct {
 	Text               string `json:"text,omitempty"`
 	Code               int    `json:"code"`
 	InvalidEventNumber *int   `json:"invalid-event-number,omitempty"`
+	// AckID identifies a submitted batch when indexer
+	// acknowledgement is enabled.
+	AckID *int64 `json:"ackId,omitempty"`
 }
@@ -0,0 +1,139 @@
+package splunk
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/stripe/veneur/sinks/retry"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// retryable returns whether a batch that the HEC responded to with
+// status should be submitted again: the HEC is out of capacity, or
+// rate limits the token.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// retryQueue holds the batches that wait to be submitted again, up to
+// a maximum number of bytes.
+type retryQueue struct {
+	maxBytes int
+
+	mtx     sync.Mutex
+	batches [][]byte
+	bytes   int
+	// ready has a value whenever batches were added since the
+	// queue was last emptied.
+	ready chan struct{}
+
+	dropped int
+}
+
+func newRetryQueue(maxBytes int) *retryQueue {
+	return &retryQueue{maxBytes: maxBytes, ready: make(chan struct{}, 1)}
+}
+
+// push adds a batch to the queue, or drops it if the queue is full.
+func (q *retryQueue) push(batch []byte) bool {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if q.bytes+len(batch) > q.maxBytes {
+		q.dropped++
+		return false
+	}
+	q.batches = append(q.batches, batch)
+	q.bytes += len(batch)
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// pop removes the oldest batch from the queue, and returns nil if the
+// queue is empty.
+func (q *retryQueue) pop() []byte {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if len(q.batches) == 0 {
+		return nil
+	}
+	batch := q.batches[0]
+	q.batches[0] = nil
+	q.batches = q.batches[1:]
+	q.bytes -= len(batch)
+	return batch
+}
+
+// report returns the number of batches dropped because the queue was
+// full since the last report, and the size of the queue.
+func (q *retryQueue) report(sink string) []*ssf.SSFSample {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	samples := []*ssf.SSFSample{
+		ssf.Count("splunk.hec_retry_dropped_total", float32(q.dropped), nil,
+			ssf.Failure(sink, ssf.CauseQueueFull)),
+		ssf.Gauge("splunk.hec_retry_queue_bytes", float32(q.bytes), nil),
+	}
+	q.dropped = 0
+	return samples
+}
+
+// retryBatches submits the batches in the retry queue again, according
+// to the sink's retry policy, until stop is closed.
+func (sss *splunkSpanSink) retryBatches(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-sss.retries.ready:
+		}
+		for batch := sss.retries.pop(); batch != nil; batch = sss.retries.pop() {
+			sss.resubmit(batch)
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// resubmit submits a batch again until the HEC accepts it, or the
+// retry policy gives up. Each attempt goes to the next endpoint in the
+// rotation.
+func (sss *splunkSpanSink) resubmit(batch []byte) {
+	var parsed Response
+	var ep *hecEndpoint
+	err := sss.retrier.Do(context.Background(), func(ctx context.Context) error {
+		ep = sss.hec.endpoints.pick(time.Now())
+		status, resp, err := sss.hec.post(ctx, sss.httpClient, ep, batch)
+		if err != nil {
+			return err
+		}
+		parsed = resp
+		if status == http.StatusOK {
+			return nil
+		}
+		err = fmt.Errorf("HTTP status %d, HEC code %d: %s", status, resp.Code, resp.Text)
+		if retryable(status) {
+			return err
+		}
+		return retry.Permanent(err)
+	})
+	if err != nil {
+		sss.log.WithError(err).Warn("Giving up on resubmitting a batch to Splunk HEC")
+		metrics.ReportOne(sss.traceClient, ssf.Count("splunk.hec_retries_exhausted_total", 1, nil,
+			ssf.Failure(sss.Name(), ssf.CauseIOError)))
+		return
+	}
+	metrics.ReportOne(sss.traceClient, ssf.Count("splunk.hec_retries_succeeded_total", 1, nil))
+	if sss.acks != nil && parsed.AckID != nil {
+		sss.acks.add(ackKey{endpoint: ep, id: *parsed.AckID}, &pendingBatch{body: batch, sent: time.Now()})
+	}
+}
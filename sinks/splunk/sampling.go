@@ -0,0 +1,116 @@
+package splunk
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/stripe/veneur/ssf"
+)
+
+// defaultAlwaysKeep are the classes of spans that are submitted
+// regardless of the sample rate, unless configured otherwise.
+var defaultAlwaysKeep = []string{"indicator", "error"}
+
+// SampleRateOverride is the sample rate of the spans of a service, or
+// of spans with a name, in place of the sink's span sample rate: 1 in
+// SampleRate of them are submitted, so a SampleRate of 1 submits all
+// of them. An empty or "*" Service or Name matches every service or
+// name, but an override must set at least one of them.
+type SampleRateOverride struct {
+	Service    string
+	Name       string
+	SampleRate int
+}
+
+func checkSampleRateOverrides(overrides []SampleRateOverride) error {
+	for _, o := range overrides {
+		if (o.Service == "" || o.Service == "*") && (o.Name == "" || o.Name == "*") {
+			return fmt.Errorf("span sample rate override with sample rate %d matches every span; set a service or name", o.SampleRate)
+		}
+		if o.SampleRate < 1 {
+			return fmt.Errorf("span sample rate override for service %q and name %q must be at least 1, got %d", o.Service, o.Name, o.SampleRate)
+		}
+	}
+	return nil
+}
+
+// keepRule matches a class of spans that are submitted regardless of
+// the sample rate.
+type keepRule func(span *ssf.SSFSpan) bool
+
+// parseAlwaysKeep parses the classes of spans that are always kept:
+// "indicator" and "error" spans, spans with a tag ("tag:name"), and
+// spans with a tag set to a value ("tag:name=value").
+func parseAlwaysKeep(classes []string) ([]keepRule, error) {
+	rules := make([]keepRule, 0, len(classes))
+	for _, class := range classes {
+		switch {
+		case class == "indicator":
+			rules = append(rules, func(span *ssf.SSFSpan) bool { return span.Indicator })
+		case class == "error":
+			rules = append(rules, func(span *ssf.SSFSpan) bool { return span.Error })
+		case strings.HasPrefix(class, "tag:") && len(class) > len("tag:"):
+			kv := strings.SplitN(class[len("tag:"):], "=", 2)
+			name := kv[0]
+			if len(kv) == 1 {
+				rules = append(rules, func(span *ssf.SSFSpan) bool {
+					_, ok := span.Tags[name]
+					return ok
+				})
+				continue
+			}
+			value := kv[1]
+			rules = append(rules, func(span *ssf.SSFSpan) bool {
+				v, ok := span.Tags[name]
+				return ok && v == value
+			})
+		default:
+			return nil, fmt.Errorf("unknown class of spans to always keep: %q", class)
+		}
+	}
+	return rules, nil
+}
+
+// alwaysKeep returns whether a span is submitted regardless of the
+// sample rate.
+func (sss *splunkSpanSink) alwaysKeep(span *ssf.SSFSpan) bool {
+	for _, rule := range sss.keepRules {
+		if rule(span) {
+			return true
+		}
+	}
+	return false
+}
+
+// sampleRate returns the sample rate of a span: that of the first
+// override that matches its service and name, or spanSampleRate.
+func (sss *splunkSpanSink) sampleRate(span *ssf.SSFSpan) int64 {
+	for _, o := range sss.sampleRateOverrides {
+		if o.Service != "" && o.Service != "*" && o.Service != span.Service {
+			continue
+		}
+		if o.Name != "" && o.Name != "*" && o.Name != span.Name {
+			continue
+		}
+		return int64(o.SampleRate)
+	}
+	return sss.spanSampleRate
+}
+
+// sampled returns whether a span is chosen for submission. If the span
+// carries an upstream sampling decision in the decision tag, it's
+// followed. Otherwise, 1 in the span's sample rate (see sampleRate)
+// spans are chosen by their trace ID; spans that have the trace ID 0
+// or are in a class that is always kept (by default, indicator and
+// error spans) are always chosen, regardless of the sample rate.
+func (sss *splunkSpanSink) sampled(span *ssf.SSFSpan) bool {
+	if sss.decisionTag != "" {
+		if value, ok := span.Tags[sss.decisionTag]; ok {
+			if keep, err := strconv.ParseBool(value); err == nil {
+				return keep
+			}
+		}
+	}
+	return span.TraceId%sss.sampleRate(span) == 0 || sss.alwaysKeep(span)
+}
@@ -0,0 +1,325 @@
+package splunk
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/sinks"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// spillSuffix ends the name of every spilled batch's file.
+const spillSuffix = ".batch"
+
+// spillDrainInterval is how often the sink tries to submit the
+// spilled batches again while no submission succeeds.
+const spillDrainInterval = 10 * time.Second
+
+// spillSubmitTimeout bounds the submission of a spilled batch.
+const spillSubmitTimeout = 30 * time.Second
+
+// errSpillFull is returned when a batch doesn't fit on disk anymore.
+var errSpillFull = errors.New("the splunk HEC spill directory is full")
+
+// spillQueue keeps the batches that couldn't be submitted to the HEC
+// on disk, one file per batch, up to a maximum number of bytes, until
+// they can be submitted again. The files are named after a sequence
+// number, so they're drained oldest first, also after a restart.
+type spillQueue struct {
+	dir           string
+	maxBytes      int
+	batchSize     int
+	maxBatchBytes int
+
+	mtx   sync.Mutex
+	files []spillFile
+	bytes int
+	seq   uint64
+
+	// pending gathers the events that couldn't be ingested in
+	// time, until there's a batch's worth of them to spill.
+	events        *eventEncoder
+	pending       []byte
+	pendingEvents int
+
+	// ready has a value whenever the HEC accepted a batch, or
+	// batches were left over from the last run.
+	ready chan struct{}
+
+	spilled int
+	drained int
+	dropped int
+}
+
+type spillFile struct {
+	name string
+	size int
+}
+
+// newSpillQueue creates dir if it doesn't exist, and picks up the
+// batches that were spilled to it before.
+func newSpillQueue(dir string, maxBytes, batchSize, maxBatchBytes int, raw bool) (*spillQueue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	q := &spillQueue{
+		dir:           dir,
+		maxBytes:      maxBytes,
+		batchSize:     batchSize,
+		maxBatchBytes: maxBatchBytes,
+		events:        newEventEncoder(),
+		ready:         make(chan struct{}, 1),
+	}
+	q.events.raw = raw
+
+	// ReadDir sorts by name, which is the order the batches were
+	// spilled in:
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasSuffix(name, spillSuffix) || !info.Mode().IsRegular() {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spillSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		q.files = append(q.files, spillFile{name: name, size: int(info.Size())})
+		q.bytes += int(info.Size())
+		if seq >= q.seq {
+			q.seq = seq + 1
+		}
+	}
+	if len(q.files) > 0 {
+		q.wake()
+	}
+	return q, nil
+}
+
+// push writes a batch to disk, or drops it if the queue is full.
+func (q *spillQueue) push(batch []byte) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	return q.write(batch)
+}
+
+// write writes a batch to a file of its own. It must be called with
+// the lock held.
+func (q *spillQueue) write(batch []byte) error {
+	if len(batch) == 0 {
+		return nil
+	}
+	if q.bytes+len(batch) > q.maxBytes {
+		q.dropped++
+		return errSpillFull
+	}
+	name := fmt.Sprintf("%020d%s", q.seq, spillSuffix)
+	q.seq++
+	// write to a temporary file first, so that a crash never
+	// leaves a partial batch behind:
+	path := filepath.Join(q.dir, name)
+	if err := ioutil.WriteFile(path+".tmp", batch, 0644); err != nil {
+		os.Remove(path + ".tmp")
+		q.dropped++
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		os.Remove(path + ".tmp")
+		q.dropped++
+		return err
+	}
+	q.files = append(q.files, spillFile{name: name, size: len(batch)})
+	q.bytes += len(batch)
+	q.spilled++
+	return nil
+}
+
+// add encodes an event into the pending batch, and spills that batch
+// once it holds batchSize events, or the event would take it over
+// maxBatchBytes.
+func (q *spillQueue) add(ev *Event) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	encoded, err := q.events.encode(ev)
+	if err != nil {
+		return err
+	}
+	if q.maxBatchBytes > 0 && len(q.pending)+len(encoded) > q.maxBatchBytes {
+		if err := q.writePending(); err != nil {
+			return err
+		}
+	}
+	q.pending = append(q.pending, encoded...)
+	q.pendingEvents++
+	if q.pendingEvents >= q.batchSize {
+		return q.writePending()
+	}
+	return nil
+}
+
+// flush spills the pending batch, however many events it holds.
+func (q *spillQueue) flush() error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	return q.writePending()
+}
+
+// writePending spills the pending batch and starts a new one. It must
+// be called with the lock held.
+func (q *spillQueue) writePending() error {
+	batch := q.pending
+	q.pending = q.pending[:0]
+	q.pendingEvents = 0
+	return q.write(batch)
+}
+
+// oldest reads the oldest batch on disk, and returns "" as its name if
+// there is none.
+func (q *spillQueue) oldest() (string, []byte, error) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if len(q.files) == 0 {
+		return "", nil, nil
+	}
+	name := q.files[0].name
+	batch, err := ioutil.ReadFile(filepath.Join(q.dir, name))
+	return name, batch, err
+}
+
+// remove deletes a batch from disk, once it's drained or can't be
+// submitted at all; only drained batches are counted.
+func (q *spillQueue) remove(name string, drained bool) error {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	for i, f := range q.files {
+		if f.name == name {
+			q.files = append(q.files[:i], q.files[i+1:]...)
+			q.bytes -= f.size
+			break
+		}
+	}
+	if drained {
+		q.drained++
+	}
+	return os.Remove(filepath.Join(q.dir, name))
+}
+
+// wake makes the sink drain the queue right away.
+func (q *spillQueue) wake() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// report returns the number of batches spilled, drained and dropped
+// since the last report, and the size of the queue.
+func (q *spillQueue) report(sink string) []*ssf.SSFSample {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	samples := []*ssf.SSFSample{
+		ssf.Count("splunk.hec_spilled_batches_total", float32(q.spilled), nil),
+		ssf.Count("splunk.hec_spill_drained_batches_total", float32(q.drained), nil),
+		ssf.Count("splunk.hec_spill_dropped_batches_total", float32(q.dropped), nil,
+			ssf.Failure(sink, ssf.CauseQueueFull)),
+		ssf.Gauge("splunk.hec_spill_bytes", float32(q.bytes), nil),
+	}
+	q.spilled = 0
+	q.drained = 0
+	q.dropped = 0
+	return samples
+}
+
+// spillEvent spills an event that couldn't be ingested in time.
+func (sss *splunkSpanSink) spillEvent(ev *Event) {
+	if err := sss.spill.add(ev); err != nil && err != errSpillFull {
+		sss.log.WithError(err).Warn("Could not spill a span to disk")
+	}
+}
+
+// spillBatch spills a batch that couldn't be submitted.
+func (sss *splunkSpanSink) spillBatch(batch []byte) {
+	if err := sss.spill.push(batch); err != nil && err != errSpillFull {
+		sss.log.WithError(err).Warn("Could not spill a Splunk HEC batch to disk")
+	}
+}
+
+// drainSpill submits the spilled batches, oldest first, whenever the
+// HEC accepts a batch and every spillDrainInterval, until stop is
+// closed.
+func (sss *splunkSpanSink) drainSpill(stop <-chan struct{}) {
+	ticker := time.NewTicker(spillDrainInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-sss.spill.ready:
+		case <-ticker.C:
+		}
+		for sss.drainOne() {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// drainOne submits the oldest spilled batch, and returns whether the
+// next one should be submitted right away. Batches stay on disk while
+// the HEC is unreachable, out of capacity or rejects the token.
+func (sss *splunkSpanSink) drainOne() bool {
+	name, batch, err := sss.spill.oldest()
+	if name == "" {
+		return false
+	}
+	if err != nil {
+		sss.log.WithError(err).WithField("file", name).
+			Warn("Could not read a spilled Splunk HEC batch, dropping it")
+		sss.spill.remove(name, false)
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), spillSubmitTimeout)
+	defer cancel()
+	ep := sss.hec.endpoints.pick(time.Now())
+	status, parsed, err := sss.hec.post(ctx, sss.httpClient, ep, batch)
+	if err != nil || retryable(status) || sinks.IsAuthFailure(status) {
+		return false
+	}
+	if status != http.StatusOK {
+		sss.log.WithFields(logrus.Fields{
+			"http_status_code":  status,
+			"hec_status_code":   parsed.Code,
+			"hec_response_text": parsed.Text,
+		}).Warn("Splunk HEC rejected a spilled batch, dropping it")
+		metrics.ReportOne(sss.traceClient, ssf.Count("splunk.hec_spill_rejected_batches_total", 1, nil,
+			ssf.Failure(sss.Name(), ssf.CauseRejected)))
+		sss.spill.remove(name, false)
+		return true
+	}
+	if err := sss.spill.remove(name, true); err != nil {
+		sss.log.WithError(err).WithField("file", name).
+			Warn("Could not remove a drained Splunk HEC batch")
+	}
+	if sss.acks != nil && parsed.AckID != nil {
+		sss.acks.add(ackKey{endpoint: ep, id: *parsed.AckID}, &pendingBatch{body: batch, sent: time.Now()})
+	}
+	return true
+}
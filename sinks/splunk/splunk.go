@@ -1,12 +1,15 @@
 package splunk
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"io"
 	"io/ioutil"
 	"math"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -20,8 +23,10 @@ import (
 	mrand "math/rand"
 
 	"github.com/sirupsen/logrus"
+	vhttp "github.com/stripe/veneur/http"
 	"github.com/stripe/veneur/protocol"
 	"github.com/stripe/veneur/sinks"
+	"github.com/stripe/veneur/sinks/retry"
 	"github.com/stripe/veneur/ssf"
 	"github.com/stripe/veneur/trace"
 	"github.com/stripe/veneur/trace/metrics"
@@ -40,11 +45,19 @@ type TestableSplunkSpanSink interface {
 	// their current request and start a new one. It returns when
 	// the last worker's submission is done.
 	Sync()
+
+	// Workers returns the number of submission workers that the
+	// sink runs.
+	Workers() int
 }
 
 type splunkSpanSink struct {
-	hec           *hecClient
-	httpClient    *http.Client
+	hec        *hecClient
+	httpClient *http.Client
+	// workerClients are the clients that each submission worker
+	// submits its batches with, so that every worker keeps its own
+	// connections to the HEC.
+	workerClients []*http.Client
 	hostname      string
 	sendTimeout   time.Duration
 	ingestTimeout time.Duration
@@ -56,22 +69,96 @@ type splunkSpanSink struct {
 	ingestedSpans        uint32
 	droppedSpans         uint32
 
+	// maxBatchBytes, if positive, bounds the size of the encoded
+	// events in a batch, below the HEC's maximum request size.
+	maxBatchBytes int
+	// oversizedSpans counts the spans dropped because their event
+	// alone is larger than maxBatchBytes.
+	oversizedSpans uint32
+
 	ingest chan *Event
 
 	traceClient *trace.Client
 	log         *logrus.Logger
 
 	spanSampleRate int64
-	skippedSpans   uint32
+	// sampleRateOverrides replace spanSampleRate for the spans they
+	// match.
+	sampleRateOverrides []SampleRateOverride
+	skippedSpans        uint32
+	// keepRules match the spans that are submitted regardless of
+	// spanSampleRate.
+	keepRules []keepRule
+	// decisionTag, if set, is the tag that carries an upstream
+	// sampling decision, which overrides spanSampleRate and
+	// keepRules.
+	decisionTag string
+
+	// tags, if set, strips tags from the submitted spans.
+	tags         *tagFilter
+	strippedTags uint32
 
 	maxConnLifetime    time.Duration
 	connLifetimeJitter time.Duration
+	maxBatchAge        time.Duration
 	rand               *mrand.Rand
 
-	// these fields are for testing only:
+	// healthCheck makes the sink check the HEC's health and its
+	// token on Start, and report the HEC's health on every flush.
+	healthCheck bool
+	// healthCheckWarnOnly makes Start log a warning when the checks
+	// fail, instead of failing.
+	healthCheckWarnOnly bool
+
+	// indexes maps the values of the span's service (or of its
+	// indexTag tag, if set) to the index that its event is stored
+	// in. Other spans go to the token's default index.
+	indexTag string
+	indexes  map[string]string
+
+	// source and sourceType, if set, render the source and sourcetype
+	// of each span's event. The sourcetype is the span's service
+	// otherwise, and the source is left to the HEC.
+	source     *fieldTemplate
+	sourceType *fieldTemplate
+
+	// tokenFile, if set, is re-read every tokenRefreshInterval, so
+	// that the tokens can be rotated without restarting.
+	tokenFile            string
+	tokenRefreshInterval time.Duration
+
+	// acks tracks the submitted batches until the HEC acknowledges
+	// them, if indexer acknowledgement is enabled.
+	acks *hecAcks
+
+	// retrier and retries, if set, submit the batches that the HEC
+	// rejected with a retryable status again.
+	retrier *retry.Retrier
+	retries *retryQueue
+
+	// spill, if set, keeps the batches that couldn't be submitted,
+	// and the spans that couldn't be ingested in time, on disk
+	// until the HEC accepts batches again.
+	spill *spillQueue
+
+	// stop stops the goroutines that track acknowledgements, retry
+	// batches and drain the spilled ones.
+	stop chan struct{}
 
-	// sync holds one channel per submission worker.
-	sync []chan struct{}
+	// scaler, if set, starts and stops submission workers as the
+	// ingest buffer fills up and drains.
+	scaler *workerScaler
+
+	// sync holds one channel per submission worker; closing it
+	// stops the worker. retire holds one channel per worker that the
+	// scaler started, in the same order; closing it stops the worker
+	// once it has submitted its batch. workersMtx guards both while
+	// the scaler runs.
+	sync       []chan struct{}
+	retire     []chan struct{}
+	workersMtx sync.Mutex
+
+	// these fields are for testing only:
 
 	// synced is marked Done by each submission worker, when the
 	// submission has happened.
@@ -81,38 +168,215 @@ type splunkSpanSink struct {
 var _ sinks.SpanSink = &splunkSpanSink{}
 var _ TestableSplunkSpanSink = &splunkSpanSink{}
 
-// NewSplunkSpanSink constructs a new splunk span sink from the server
-// name and token provided, using the local hostname configured for
-// veneur. An optional argument, validateServerName is used (if
-// non-empty) to instruct go to validate a different hostname than the
-// one on the server URL.
-// The spanSampleRate is an integer. For any given trace ID, the probability
-// that all spans in the trace will be chosen for the sample is 1/spanSampleRate.
-// Sampling is performed on the trace ID, so either all spans within a given trace
-// will be chosen, or none will.
-func NewSplunkSpanSink(server string, token string, localHostname string, validateServerName string, log *logrus.Logger, ingestTimeout time.Duration, sendTimeout time.Duration, batchSize int, workers int, spanSampleRate int, maxConnLifetime time.Duration, connLifetimeJitter time.Duration) (sinks.SpanSink, error) {
-	if spanSampleRate < 1 {
-		spanSampleRate = 1
-	}
-
-	client, err := newHecClient(server, token)
+// SpanSinkOptions configures a Splunk span sink. Only Servers, Token
+// (or TokenFile), Hostname and Log are required.
+type SpanSinkOptions struct {
+	// Servers are the HEC URLs. Batches are spread across them
+	// round-robin, and a server that fails several submissions in a
+	// row, or a health check, is ejected from the rotation for a
+	// while.
+	Servers []string
+	// Token authenticates the submissions. If SecondaryToken is set,
+	// the sink switches to it when the HEC rejects Token, and back
+	// again if the HEC rejects SecondaryToken.
+	Token          string
+	SecondaryToken string
+	// If TokenFile is set, the tokens are read from it instead (see
+	// sinks.ReadCredentialsFile), and it's read again every
+	// TokenRefreshInterval, so that they can be rotated.
+	TokenFile            string
+	TokenRefreshInterval time.Duration
+	// Hostname is the local hostname configured for veneur.
+	Hostname string
+	Log      *logrus.Logger
+
+	// ValidateServerName, if set, is the hostname that the HEC's
+	// certificate is validated against instead of the one in its URL.
+	ValidateServerName string
+	// TLSConfig, if set (see NewTLSConfig), is used to connect to the
+	// HEC, for example to present a client certificate.
+	TLSConfig *tls.Config
+
+	// IngestTimeout bounds how long Ingest waits for a submission
+	// worker, and SendTimeout how long a submission waits for the
+	// HEC's response.
+	IngestTimeout time.Duration
+	SendTimeout   time.Duration
+	// BatchSize is the number of spans in a batch. If MaxBatchBytes
+	// is positive, a batch is submitted early when its next event
+	// would take it over MaxBatchBytes (before compression), and that
+	// event starts the next batch; events larger than MaxBatchBytes
+	// by themselves are dropped, since the HEC would reject them
+	// anyway. If MaxBatchAge is positive, a batch is submitted once
+	// its first span is that old, even if it holds fewer spans.
+	BatchSize     int
+	MaxBatchBytes int
+	MaxBatchAge   time.Duration
+	// Workers is the number of submission workers. If MaxWorkers is
+	// larger, spans wait for the workers in a buffer of BatchSize
+	// events, which is checked every WorkerScaleInterval (a second,
+	// if it isn't positive): the sink starts another worker, up to
+	// MaxWorkers, when the buffer stays at least three quarters
+	// full, and stops one, down to Workers, when it stays empty.
+	Workers             int
+	MaxWorkers          int
+	WorkerScaleInterval time.Duration
+	// MaxConnLifetime, if positive, bounds how long a worker streams
+	// a batch to the HEC in one request, plus a random part of
+	// ConnLifetimeJitter. Each worker connects with a client of its
+	// own, which prefers HTTP/2 and, if KeepaliveInterval is
+	// positive, pings idle HTTP/2 connections that often, so that
+	// workers don't wait on each other's connections.
+	MaxConnLifetime    time.Duration
+	ConnLifetimeJitter time.Duration
+	KeepaliveInterval  time.Duration
+
+	// For any given trace ID, the probability that all spans in the
+	// trace are chosen for the sample is 1/SpanSampleRate. Spans in
+	// the classes listed in AlwaysKeep ("indicator", "error",
+	// "tag:name" or "tag:name=value") are chosen regardless; if
+	// AlwaysKeep is nil, indicator and error spans are. Spans that
+	// match one of SampleRateOverrides by their service and name are
+	// sampled at the first matching override's rate instead. Since
+	// all of them are sampled by trace ID, a trace that is kept at a
+	// rate of 100 is also kept by the spans sampled at rates that
+	// divide it, like 10 or 1.
+	SpanSampleRate      int
+	AlwaysKeep          []string
+	SampleRateOverrides []SampleRateOverride
+	// If SampleDecisionTag is set, spans whose SampleDecisionTag tag
+	// is a boolean ("true", "false", "1", "0"...) are kept or dropped
+	// as it says instead, so that the sink keeps the same traces as
+	// the rest of the pipeline.
+	SampleDecisionTag string
+
+	// If HealthCheck is set, Start fails unless the HEC is healthy
+	// and accepts the token, and the HEC's health is reported on
+	// every flush. If HealthCheckWarnOnly is also set, Start logs a
+	// warning instead of failing.
+	HealthCheck         bool
+	HealthCheckWarnOnly bool
+	// If AckTimeout is positive, the sink uses HEC indexer
+	// acknowledgement, which must be enabled for the token: it
+	// submits the batches that the HEC didn't acknowledge within
+	// AckTimeout again.
+	AckTimeout time.Duration
+	// If Gzip is set, batches are submitted gzip-compressed.
+	Gzip bool
+	// If Retrier is set, batches that the HEC rejects with a 429 or
+	// 5xx status are submitted again according to its policy,
+	// keeping up to RetryBufferBytes of them in memory.
+	Retrier          *retry.Retrier
+	RetryBufferBytes int
+	// If SpillDir is set, batches that can't be submitted because
+	// the HEC is unreachable or out of capacity (and that don't fit
+	// into the retry buffer), and spans that can't be ingested within
+	// IngestTimeout, are written to files in SpillDir, up to
+	// SpillMaxBytes of them, and submitted again, oldest first, once
+	// the HEC accepts batches again. Batches left in SpillDir by a
+	// previous run are submitted too.
+	SpillDir      string
+	SpillMaxBytes int
+
+	// Spans whose service, or whose IndexTag tag if IndexTag is set,
+	// is a key of Indexes are stored in the index it maps to.
+	IndexTag string
+	Indexes  map[string]string
+	// If TagAllowlist is set, only the span tags it names are
+	// submitted, and the tags that TagDenylist names never are; a
+	// name ending in "*" stands for every tag with that prefix. Tags
+	// are stripped after the sampling and index routing, which still
+	// see all of them.
+	TagAllowlist []string
+	TagDenylist  []string
+	// If RawEndpoint is set, batches are submitted to the HEC's raw
+	// endpoint instead of its event endpoint, for HECs that have the
+	// latter disabled: each span is one JSON object on a line of its
+	// own, without the event envelope, and the events of a batch
+	// share the local hostname and the sourcetype RawSourceType (or
+	// the token's default sourcetype, if it's empty). Spans can't be
+	// routed to indexes on the raw endpoint.
+	RawEndpoint   bool
+	RawSourceType string
+	// If SourceTemplate or SourceTypeTemplate are set, the source and
+	// sourcetype of each span's event are rendered from them,
+	// replacing {service}, {name} and {tag:name} with the span's
+	// service, name and the value of its tag; the sourcetype is the
+	// span's service otherwise.
+	SourceTemplate     string
+	SourceTypeTemplate string
+}
+
+// NewSplunkSpanSink constructs a new splunk span sink.
+func NewSplunkSpanSink(opts SpanSinkOptions) (sinks.SpanSink, error) {
+	if opts.SpanSampleRate < 1 {
+		opts.SpanSampleRate = 1
+	}
+	if err := checkSampleRateOverrides(opts.SampleRateOverrides); err != nil {
+		return nil, err
+	}
+	if opts.AlwaysKeep == nil {
+		opts.AlwaysKeep = defaultAlwaysKeep
+	}
+	keepRules, err := parseAlwaysKeep(opts.AlwaysKeep)
+	if err != nil {
+		return nil, err
+	}
+	source, err := parseFieldTemplate(opts.SourceTemplate)
+	if err != nil {
+		return nil, err
+	}
+	sourceType, err := parseFieldTemplate(opts.SourceTypeTemplate)
 	if err != nil {
 		return nil, err
 	}
 
-	trnsp := &http.Transport{}
-	httpC := &http.Client{Transport: trnsp}
+	if opts.TokenFile != "" {
+		opts.Token, opts.SecondaryToken, err = sinks.ReadCredentialsFile(opts.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	// keep an idle connection in reserve for every worker:
-	trnsp.MaxIdleConnsPerHost = workers
+	client, err := newHecClient(opts.Servers, opts.Token, opts.SecondaryToken, opts.Gzip)
+	if err != nil {
+		return nil, err
+	}
+	if opts.RawEndpoint {
+		if len(opts.Indexes) > 0 {
+			return nil, errors.New("spans can't be routed to splunk indexes on the HEC's raw endpoint")
+		}
+		client.raw = url.Values{"host": {opts.Hostname}}
+		if opts.RawSourceType != "" {
+			client.raw.Set("sourcetype", opts.RawSourceType)
+		}
+	}
 
-	if validateServerName != "" {
-		tlsCfg := &tls.Config{}
-		tlsCfg.ServerName = validateServerName
-		trnsp.TLSClientConfig = tlsCfg
+	// the acknowledgement, retry and health check requests share a
+	// client, with an idle connection to every server in reserve for
+	// every worker:
+	if opts.Workers < 1 {
+		opts.Workers = 1
 	}
-	if sendTimeout > 0 {
-		trnsp.ResponseHeaderTimeout = sendTimeout
+	ingest := make(chan *Event)
+	var scaler *workerScaler
+	if opts.MaxWorkers > opts.Workers {
+		if opts.WorkerScaleInterval <= 0 {
+			opts.WorkerScaleInterval = defaultWorkerScaleInterval
+		}
+		scaler = &workerScaler{min: opts.Workers, max: opts.MaxWorkers, interval: opts.WorkerScaleInterval}
+		buffer := opts.BatchSize
+		if buffer < 1 {
+			buffer = 1
+		}
+		ingest = make(chan *Event, buffer)
+	} else {
+		opts.MaxWorkers = opts.Workers
+	}
+	httpC := newHTTPClient(opts.MaxWorkers, opts.ValidateServerName, opts.SendTimeout, opts.TLSConfig)
+	workerClients := make([]*http.Client, opts.MaxWorkers)
+	for i := range workerClients {
+		workerClients[i] = newWorkerHTTPClient(opts.ValidateServerName, opts.SendTimeout, opts.TLSConfig, opts.KeepaliveInterval)
 	}
 
 	seed, err := rand.Int(rand.Reader, big.NewInt(math.MaxInt64))
@@ -120,30 +384,140 @@ func NewSplunkSpanSink(server string, token string, localHostname string, valida
 		return nil, err
 	}
 
+	var acks *hecAcks
+	if opts.AckTimeout > 0 {
+		acks = newHecAcks(opts.AckTimeout)
+	}
+	var retries *retryQueue
+	if opts.Retrier != nil {
+		retries = newRetryQueue(opts.RetryBufferBytes)
+	}
+	var spill *spillQueue
+	if opts.SpillDir != "" {
+		spill, err = newSpillQueue(opts.SpillDir, opts.SpillMaxBytes, opts.BatchSize, opts.MaxBatchBytes, opts.RawEndpoint)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &splunkSpanSink{
-		hec:                client,
-		httpClient:         httpC,
-		ingest:             make(chan *Event),
-		hostname:           localHostname,
-		log:                log,
-		sendTimeout:        sendTimeout,
-		ingestTimeout:      ingestTimeout,
-		batchSize:          batchSize,
-		spanSampleRate:     int64(spanSampleRate),
-		rand:               mrand.New(mrand.NewSource(seed.Int64())),
-		maxConnLifetime:    maxConnLifetime,
-		connLifetimeJitter: connLifetimeJitter,
+		hec:                  client,
+		httpClient:           httpC,
+		workerClients:        workerClients,
+		ingest:               ingest,
+		hostname:             opts.Hostname,
+		log:                  opts.Log,
+		sendTimeout:          opts.SendTimeout,
+		ingestTimeout:        opts.IngestTimeout,
+		workers:              opts.Workers,
+		scaler:               scaler,
+		batchSize:            opts.BatchSize,
+		maxBatchBytes:        opts.MaxBatchBytes,
+		spanSampleRate:       int64(opts.SpanSampleRate),
+		sampleRateOverrides:  opts.SampleRateOverrides,
+		keepRules:            keepRules,
+		decisionTag:          opts.SampleDecisionTag,
+		tags:                 newTagFilter(opts.TagAllowlist, opts.TagDenylist),
+		tokenFile:            opts.TokenFile,
+		tokenRefreshInterval: opts.TokenRefreshInterval,
+		rand:                 mrand.New(mrand.NewSource(seed.Int64())),
+		maxConnLifetime:      opts.MaxConnLifetime,
+		connLifetimeJitter:   opts.ConnLifetimeJitter,
+		maxBatchAge:          opts.MaxBatchAge,
+		healthCheck:          opts.HealthCheck,
+		healthCheckWarnOnly:  opts.HealthCheckWarnOnly,
+		indexTag:             opts.IndexTag,
+		indexes:              opts.Indexes,
+		source:               source,
+		sourceType:           sourceType,
+		acks:                 acks,
+		retrier:              opts.Retrier,
+		retries:              retries,
+		spill:                spill,
+		stop:                 make(chan struct{}),
 	}, nil
 }
 
+// newHTTPClient returns the client that a sink connects to the HEC
+// with, keeping up to idleConns idle connections to each server.
+func newHTTPClient(idleConns int, validateServerName string, sendTimeout time.Duration, tlsConfig *tls.Config) *http.Client {
+	trnsp := newTransport(validateServerName, sendTimeout, tlsConfig)
+	trnsp.MaxIdleConnsPerHost = idleConns
+	return &http.Client{Transport: trnsp}
+}
+
+// workerIdleConns is how many idle HTTP/1.1 connections a submission
+// worker keeps to each server: a worker starts its next batch while
+// the HEC is still responding to the last one.
+const workerIdleConns = 2
+
+// keepalivePingTimeout bounds how long an HTTP/2 connection waits for
+// the answer to a keepalive ping before it's closed.
+const keepalivePingTimeout = 15 * time.Second
+
+// newWorkerHTTPClient returns the client of a single submission
+// worker. Its connections are its own, and it negotiates HTTP/2 with
+// servers that support it, so that the worker's batches share a
+// single long-lived connection to each server. If keepaliveInterval is
+// positive, HTTP/2 connections are pinged after they've been idle that
+// long, and closed if the ping goes unanswered, and TCP keepalives are
+// sent as often.
+func newWorkerHTTPClient(validateServerName string, sendTimeout time.Duration, tlsConfig *tls.Config, keepaliveInterval time.Duration) *http.Client {
+	trnsp := newTransport(validateServerName, sendTimeout, tlsConfig)
+	trnsp.MaxIdleConnsPerHost = workerIdleConns
+	trnsp.ForceAttemptHTTP2 = true
+	if keepaliveInterval > 0 {
+		dialer := &net.Dialer{KeepAlive: keepaliveInterval}
+		trnsp.DialContext = dialer.DialContext
+		trnsp.HTTP2 = &http.HTTP2Config{
+			SendPingTimeout: keepaliveInterval,
+			PingTimeout:     keepalivePingTimeout,
+		}
+	}
+	return &http.Client{Transport: trnsp}
+}
+
+// newTransport returns a transport that connects to the HEC, checking
+// its certificate against validateServerName if set.
+func newTransport(validateServerName string, sendTimeout time.Duration, tlsConfig *tls.Config) *http.Transport {
+	trnsp := &http.Transport{}
+	if tlsConfig != nil {
+		trnsp.TLSClientConfig = tlsConfig.Clone()
+	}
+	if validateServerName != "" {
+		if trnsp.TLSClientConfig == nil {
+			trnsp.TLSClientConfig = &tls.Config{}
+		}
+		trnsp.TLSClientConfig.ServerName = validateServerName
+	}
+	if sendTimeout > 0 {
+		trnsp.ResponseHeaderTimeout = sendTimeout
+	}
+	return trnsp
+}
+
 // Name returns this sink's name
 func (*splunkSpanSink) Name() string {
 	return "splunk"
 }
 
+// healthCheckTimeout bounds the requests that check the HEC's health
+// and the validity of the token.
+const healthCheckTimeout = 10 * time.Second
+
 func (sss *splunkSpanSink) Start(cl *trace.Client) error {
 	sss.traceClient = cl
 
+	if sss.healthCheck {
+		if err := sss.probe(); err != nil {
+			if !sss.healthCheckWarnOnly {
+				return err
+			}
+			sss.log.WithError(err).
+				Warn("Splunk HEC failed the startup checks; starting anyway, but its batches will likely be rejected until it's fixed")
+		}
+	}
+
 	workers := 1
 	if sss.workers > 0 {
 		workers = sss.workers
@@ -155,31 +529,78 @@ func (sss *splunkSpanSink) Start(cl *trace.Client) error {
 	var signalReady sync.Once
 	for i := 0; i < workers; i++ {
 		ch := make(chan struct{})
-		go sss.submitter(ch, signalReady, ready)
+		go sss.submitter(sss.workerClients[i], ch, nil, &signalReady, ready)
 		sss.sync[i] = ch
 	}
 
 	<-ready
+	if sss.scaler != nil {
+		go sss.autoscale(sss.stop)
+	}
+	if sss.acks != nil {
+		go sss.pollAcks(sss.stop)
+	}
+	if sss.retries != nil {
+		go sss.retryBatches(sss.stop)
+	}
+	if sss.spill != nil {
+		go sss.drainSpill(sss.stop)
+	}
+	if sss.tokenFile != "" && sss.tokenRefreshInterval > 0 {
+		go sss.hec.watchTokenFile(sss.tokenFile, sss.tokenRefreshInterval, sss.stop, sss.log, sss.traceClient)
+	}
 	return nil
 }
 
+// probe checks that the HEC is reachable and healthy, and that it
+// accepts the token, before the sink starts submitting batches to it.
+func (sss *splunkSpanSink) probe() error {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	// Start as long as one endpoint is healthy; the others stay out
+	// of the rotation until they are:
+	errs := sss.checkHealth(ctx)
+	healthy := false
+	for _, err := range errs {
+		healthy = healthy || err == nil
+	}
+	if !healthy {
+		return errs[0]
+	}
+	return sss.hec.validateToken(ctx, sss.httpClient)
+}
+
 func (sss *splunkSpanSink) Stop() {
+	sss.workersMtx.Lock()
+	defer sss.workersMtx.Unlock()
 	for _, signal := range sss.sync {
 		close(signal)
 	}
+	close(sss.stop)
 }
 
 func (sss *splunkSpanSink) Sync() {
+	sss.workersMtx.Lock()
 	sss.synced.Add(len(sss.sync))
 	for _, signal := range sss.sync {
 		signal <- struct{}{}
 	}
+	sss.workersMtx.Unlock()
 	sss.synced.Wait()
 }
 
-func (sss *splunkSpanSink) submitter(sync chan struct{}, signalReady sync.Once, ready chan struct{}) {
+// submitter is a submission worker: it submits the events ingested
+// into the sink in batches, until its sync channel is closed, which
+// cancels its last submission, or its retire channel is, which lets it
+// finish.
+func (sss *splunkSpanSink) submitter(client *http.Client, sync chan struct{}, retire <-chan struct{}, signalReady *sync.Once, ready chan struct{}) {
 	timedOut := false
 	batchTimeout := time.NewTimer(time.Duration(0))
+	events := newEventEncoder()
+	events.raw = sss.hec.raw != nil
+	// carried is the encoded event that didn't fit into the last
+	// batch, and starts the next one.
+	var carried []byte
 	for {
 		// We're not using cancelation for anything other than
 		// tests, but does allow neat control over the
@@ -190,7 +611,7 @@ func (sss *splunkSpanSink) submitter(sync chan struct{}, signalReady sync.Once,
 		hecReq, err := sss.hec.newRequest()
 
 		ingested := 0
-		req, enc, err := hecReq.Start(ctx)
+		req, w, err := hecReq.Start(ctx)
 		if err != nil {
 			sss.log.WithError(err).
 				Warn("Could not create HEC request")
@@ -198,9 +619,22 @@ func (sss *splunkSpanSink) submitter(sync chan struct{}, signalReady sync.Once,
 			continue
 		}
 
+		// With indexer acknowledgement, retries or spilling,
+		// keep a copy of the batch, so it can be submitted again
+		// if the HEC never acknowledges it, rejects it or can't
+		// be reached. The copy comes first, so that it keeps
+		// every event even once the request failed:
+		var batch *bytes.Buffer
+		var batchDone chan []byte
+		if sss.acks != nil || sss.retries != nil || sss.spill != nil {
+			batch = &bytes.Buffer{}
+			batchDone = make(chan []byte, 1)
+			w = io.MultiWriter(batch, hecReq.writer())
+		}
+
 		// At this point, we have a workable HTTP connection;
 		// open it in the background:
-		go sss.makeHTTPRequest(req, cancel)
+		go sss.makeHTTPRequest(client, req, hecReq.endpoint, hecReq.token, cancel, batchDone)
 
 		// Set the maximum lifetime of the connection:
 		lifetime := sss.maxConnLifetime
@@ -217,6 +651,26 @@ func (sss *splunkSpanSink) submitter(sync chan struct{}, signalReady sync.Once,
 		}
 		timedOut = false
 		signalReady.Do(func() { close(ready) })
+
+		// The batch's age is measured from its first span, so
+		// that spans ingested at a trickle aren't held back
+		// until the batch fills up or the connection expires:
+		var batchAge *time.Timer
+		var batchAgeC <-chan time.Time
+		batchBytes := 0
+		if carried != nil {
+			if _, err = w.Write(carried); err == nil {
+				ingested++
+				batchBytes += len(carried)
+				if sss.maxBatchAge > 0 {
+					batchAge = time.NewTimer(sss.maxBatchAge)
+					batchAgeC = batchAge.C
+				}
+			} else {
+				sss.log.WithError(err).Warn("Could not write HEC event")
+			}
+			carried = nil
+		}
 	Batch:
 		for {
 			select {
@@ -224,24 +678,71 @@ func (sss *splunkSpanSink) submitter(sync chan struct{}, signalReady sync.Once,
 				hecReq.Close()
 				if !ok {
 					// sink is shutting down, exit forever:
+					if batchDone != nil {
+						batchDone <- batch.Bytes()
+					}
 					cancel()
 					return
 				}
 				sss.synced.Done()
 				break Batch
+			case <-retire:
+				hecReq.Close()
+				if batchDone != nil {
+					batchDone <- batch.Bytes()
+				}
+				return
 			case <-batchTimeout.C:
 				timedOut = true
 				hecReq.Close()
 				break Batch
+			case <-batchAgeC:
+				hecReq.Close()
+				break Batch
 			case ev := <-sss.ingest:
+				var encoded []byte
+				encoded, err = events.encode(ev)
+				if err == nil && sss.maxBatchBytes > 0 {
+					if len(encoded) > sss.maxBatchBytes {
+						atomic.AddUint32(&sss.oversizedSpans, 1)
+						sss.log.WithField("bytes", len(encoded)).
+							Warn("Dropping a span that is larger than the maximum HEC batch")
+						continue Batch
+					}
+					if batchBytes+len(encoded) > sss.maxBatchBytes {
+						// the batch is as full as it gets,
+						// submit it and start the next one
+						// with this event:
+						carried = append([]byte(nil), encoded...)
+						hecReq.Close()
+						break Batch
+					}
+				}
 				ingested++
-				err = enc.Encode(ev)
+				if ingested == 1 && sss.maxBatchAge > 0 {
+					batchAge = time.NewTimer(sss.maxBatchAge)
+					batchAgeC = batchAge.C
+				}
 				if err != nil {
 					sss.log.WithError(err).
 						WithField("event", ev).
 						Warn("Could not json-encode HEC event")
 					continue Batch
 				}
+				batchBytes += len(encoded)
+				if _, err = w.Write(encoded); err != nil {
+					// The HEC answered before the batch was
+					// complete, likely with a 429 or 5xx
+					// status. The copy of the batch, if
+					// there is one, holds the event; start
+					// the next request with it otherwise:
+					sss.log.WithError(err).Warn("Could not write HEC event, starting a new request")
+					if batch == nil {
+						carried = append([]byte(nil), encoded...)
+					}
+					hecReq.Close()
+					break Batch
+				}
 				if ingested >= sss.batchSize {
 					// we consumed the batch size's worth, let's send it:
 					hecReq.Close()
@@ -249,14 +750,27 @@ func (sss *splunkSpanSink) submitter(sync chan struct{}, signalReady sync.Once,
 				}
 			}
 		}
+		if batchAge != nil {
+			batchAge.Stop()
+		}
+		if batchDone != nil {
+			batchDone <- batch.Bytes()
+		}
 	}
 }
 
-func (sss *splunkSpanSink) makeHTTPRequest(req *http.Request, cancel func()) {
+// makeHTTPRequest submits a batch to an endpoint, and tracks the
+// outcome towards its ejection. With indexer acknowledgement, retries
+// or spilling, the encoded batch arrives on batchDone once it's
+// complete, and is tracked until the HEC acknowledges it, or queued to
+// be submitted again if the HEC rejects it with a retryable status or
+// can't be reached.
+func (sss *splunkSpanSink) makeHTTPRequest(client *http.Client, req *http.Request, ep *hecEndpoint, token string, cancel func(), batchDone <-chan []byte) {
 	samples := &ssf.Samples{}
 	defer metrics.Report(sss.traceClient, samples)
 	const successMetric = "splunk.hec_submission_success_total"
 	const failureMetric = "splunk.hec_submission_failed_total"
+	const responseMetric = "splunk.hec_submission_responses_total"
 	const timingMetric = "splunk.span_submission_lifetime_ns"
 	start := time.Now()
 	defer func() {
@@ -265,18 +779,32 @@ func (sss *splunkSpanSink) makeHTTPRequest(req *http.Request, cancel func()) {
 			time.Nanosecond, map[string]string{}))
 	}()
 
-	resp, err := sss.httpClient.Do(req)
+	resp, err := client.Do(req)
+	status := 0
+	if err == nil {
+		status = resp.StatusCode
+	}
+	if sss.hec.endpoints.record(ep, status, err, time.Now()) {
+		sss.log.WithField("endpoint", ep.url.Host).
+			Warn("Splunk HEC endpoint keeps failing, ejecting it from the rotation")
+	}
 	if uerr, ok := err.(*url.Error); ok && uerr.Timeout() {
 		// don't report a sentry-able error for timeouts:
 		samples.Add(ssf.Count(failureMetric, 1, map[string]string{
-			"cause": "submission_timeout",
-		}))
+			"reason": "submission_timeout",
+		}, ssf.Failure(sss.Name(), ssf.CauseSinkTimeout)))
+		if sss.spill != nil {
+			sss.spillBatch(<-batchDone)
+		}
 		return
 	}
 	if err != nil {
 		samples.Add(ssf.Count(failureMetric, 1, map[string]string{
-			"cause": "execution",
-		}))
+			"reason": "execution",
+		}, ssf.Failure(sss.Name(), ssf.CauseIOError)))
+		if sss.spill != nil {
+			sss.spillBatch(<-batchDone)
+		}
 		return
 	}
 
@@ -285,37 +813,56 @@ func (sss *splunkSpanSink) makeHTTPRequest(req *http.Request, cancel func()) {
 		resp.Body.Close()
 	}()
 
-	var cause string
-	var statusCode int
+	if sinks.IsAuthFailure(resp.StatusCode) && sss.hec.tokens.Rejected(token) {
+		sss.log.WithField("http_status_code", resp.StatusCode).
+			Warn("Splunk HEC rejected the token, switching to the other one")
+	}
 
-	switch resp.StatusCode {
-	case http.StatusOK:
+	if resp.StatusCode == http.StatusOK {
 		// Everything went well - discard the body so the
 		// connection stays alive and early-return (the rest
 		// of this function is dedicated to error handling):
-		samples.Add(ssf.Count(successMetric, 1, map[string]string{}))
+		samples.Add(ssf.Count(successMetric, 1, map[string]string{}),
+			ssf.Count(responseMetric, 1, responseTags(resp.StatusCode, strconv.Itoa(hecCodeSuccess))))
+		if sss.acks != nil {
+			sss.trackBatch(resp.Body, ep, <-batchDone, start)
+		}
+		if sss.spill != nil {
+			// the HEC is back, submit what was spilled:
+			sss.spill.wake()
+		}
 		return
+	}
+
+	// The HEC explains every error with a code in the body, which
+	// tells token problems apart from indexer backpressure:
+	var parsed Response
+	hecCode := hecCodeUnknown
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err == nil {
+		hecCode = strconv.Itoa(parsed.Code)
+	}
+	samples.Add(ssf.Count(responseMetric, 1, responseTags(resp.StatusCode, hecCode)))
+
+	var reason string
+	var statusCode int
+
+	switch resp.StatusCode {
 	case http.StatusInternalServerError:
-		cause = "internal_server_error"
+		reason = "internal_server_error"
 		statusCode = 8
 	case http.StatusServiceUnavailable:
 		// This status happens when splunk is out of capacity,
-		// no need to report a bug or parse the body for it:
-		cause = "service_unavailable"
+		// no need to report a bug for it:
+		reason = "service_unavailable"
 		statusCode = 9
 	default:
-		// Something else is wrong, let's parse the body and
-		// report a detailed error:
-		var parsed Response
-		dec := json.NewDecoder(resp.Body)
-		err := dec.Decode(&parsed)
-		if err != nil {
-			sss.log.WithError(err).
-				WithField("http_status_code", resp.StatusCode).
+		// Something else is wrong, report a detailed error:
+		if hecCode == hecCodeUnknown {
+			sss.log.WithField("http_status_code", resp.StatusCode).
 				Warn("Could not parse response from splunk HEC")
 			return
 		}
-		cause = "error"
+		reason = "error"
 		statusCode = parsed.Code
 		sss.log.WithFields(logrus.Fields{
 			"http_status_code":  resp.StatusCode,
@@ -325,15 +872,41 @@ func (sss *splunkSpanSink) makeHTTPRequest(req *http.Request, cancel func()) {
 		}).Error("Error response from Splunk HEC")
 	}
 	samples.Add(ssf.Count(failureMetric, 1, map[string]string{
-		"cause":       cause,
+		"reason":      reason,
 		"status_code": strconv.Itoa(statusCode),
-	}))
+	}, ssf.Failure(sss.Name(), vhttp.StatusCause(resp.StatusCode))))
+	if retryable(resp.StatusCode) && (sss.retries != nil || sss.spill != nil) {
+		batch := <-batchDone
+		switch {
+		case sss.retries != nil && sss.retries.push(batch):
+			samples.Add(ssf.Count("splunk.hec_retried_batches_total", 1, nil))
+		case sss.spill != nil:
+			sss.spillBatch(batch)
+		default:
+			sss.log.Warn("Splunk HEC retry buffer is full, dropping batch")
+		}
+	}
+}
+
+// hecCodeUnknown is the HEC code of responses whose body isn't a HEC
+// response.
+const hecCodeUnknown = "unknown"
+
+// responseTags returns the tags of the counter of HEC responses: the
+// response's HTTP status, its class ("2xx" to "5xx"), and the HEC's
+// code from its body.
+func responseTags(status int, hecCode string) map[string]string {
+	return map[string]string{
+		"status_class":     strconv.Itoa(status/100) + "xx",
+		"http_status_code": strconv.Itoa(status),
+		"hec_code":         hecCode,
+	}
 }
 
 // Flush takes the batched-up events and sends them to the HEC
 // endpoint for ingestion. If set, it uses the send timeout configured
 // for the span batch.
-func (sss *splunkSpanSink) Flush() {
+func (sss *splunkSpanSink) Flush(context.Context) {
 	// report the sink stats:
 	samples := &ssf.Samples{}
 	samples.Add(
@@ -345,6 +918,13 @@ func (sss *splunkSpanSink) Flush() {
 			sinks.MetricKeyTotalSpansDropped,
 			float32(atomic.SwapUint32(&sss.droppedSpans, 0)),
 			map[string]string{"sink": sss.Name()},
+			ssf.Failure(sss.Name(), ssf.CauseQueueFull),
+		),
+		ssf.Count(
+			"splunk.hec_oversized_spans_dropped_total",
+			float32(atomic.SwapUint32(&sss.oversizedSpans, 0)),
+			nil,
+			ssf.Failure(sss.Name(), ssf.CauseRejected),
 		),
 		ssf.Count(
 			sinks.MetricKeyTotalSpansSkipped,
@@ -352,11 +932,91 @@ func (sss *splunkSpanSink) Flush() {
 			map[string]string{"sink": sss.Name()},
 		),
 	)
+	if sss.tags != nil {
+		samples.Add(ssf.Count("splunk.span_tags_stripped_total",
+			float32(atomic.SwapUint32(&sss.strippedTags, 0)), nil))
+	}
+	samples.Add(sss.hec.tokens.Report(sss.Name())...)
+	samples.Add(sss.hec.endpoints.report(sss.Name(), time.Now())...)
+	if sss.acks != nil {
+		samples.Add(sss.acks.report(sss.Name())...)
+	}
+	if sss.retries != nil {
+		samples.Add(sss.retries.report(sss.Name())...)
+	}
+	if sss.spill != nil {
+		if err := sss.spill.flush(); err != nil && err != errSpillFull {
+			sss.log.WithError(err).Warn("Could not spill spans to disk")
+		}
+		samples.Add(sss.spill.report(sss.Name())...)
+	}
+	if sss.scaler != nil {
+		samples.Add(sss.scaler.report(sss.Name(), sss.Workers())...)
+	}
 
 	metrics.Report(sss.traceClient, samples)
+	if sss.healthCheck {
+		go sss.reportHealth()
+	}
 	return
 }
 
+// trackBatch tracks a batch that an endpoint accepted until it's
+// acknowledged, by the ackId in the endpoint's response.
+func (sss *splunkSpanSink) trackBatch(body io.Reader, ep *hecEndpoint, batch []byte, sent time.Time) {
+	var parsed Response
+	if err := json.NewDecoder(body).Decode(&parsed); err != nil || parsed.AckID == nil {
+		sss.log.WithError(err).
+			Warn("Splunk HEC didn't return an ackId, is indexer acknowledgement enabled for the token?")
+		return
+	}
+	sss.acks.add(ackKey{endpoint: ep, id: *parsed.AckID}, &pendingBatch{body: batch, sent: sent})
+}
+
+// checkHealth checks the health of every HEC endpoint, ejecting the
+// unhealthy ones from the rotation and putting the healthy ones back.
+// It returns each endpoint's error, in the order of the endpoints.
+func (sss *splunkSpanSink) checkHealth(ctx context.Context) []error {
+	endpoints := sss.hec.endpoints.all()
+	errs := make([]error, len(endpoints))
+	var wg sync.WaitGroup
+	for i, ep := range endpoints {
+		wg.Add(1)
+		go func(i int, ep *hecEndpoint) {
+			defer wg.Done()
+			errs[i] = sss.hec.checkHealth(ctx, sss.httpClient, ep)
+		}(i, ep)
+	}
+	wg.Wait()
+
+	now := time.Now()
+	for i, ep := range endpoints {
+		sss.hec.endpoints.setHealthy(ep, errs[i] == nil, now)
+		if errs[i] != nil {
+			sss.log.WithError(errs[i]).WithField("endpoint", ep.url.Host).
+				Warn("Splunk HEC health check failed")
+		}
+	}
+	return errs
+}
+
+// reportHealth checks the health of every HEC endpoint, and reports it
+// as a gauge per endpoint that is 1 if it is healthy and 0 otherwise.
+func (sss *splunkSpanSink) reportHealth() {
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+	samples := &ssf.Samples{}
+	for i, err := range sss.checkHealth(ctx) {
+		healthy := float32(1)
+		if err != nil {
+			healthy = 0
+		}
+		samples.Add(ssf.Gauge("splunk.hec_healthy", healthy,
+			map[string]string{"endpoint": sss.hec.endpoints.all()[i].url.Host}))
+	}
+	metrics.Report(sss.traceClient, samples)
+}
+
 // Ingest takes in a span and batches it up to be sent in the next
 // Flush() iteration.
 func (sss *splunkSpanSink) Ingest(ssfSpan *ssf.SSFSpan) error {
@@ -365,10 +1025,7 @@ func (sss *splunkSpanSink) Ingest(ssfSpan *ssf.SSFSpan) error {
 		return err
 	}
 
-	// choose (1/spanSampleRate) spans for sampling if any spans
-	// have the traceID of 0 or are declared indicator spans, they
-	// will always be chosen, regardless of the sample rate.
-	if !ssfSpan.Indicator && ssfSpan.TraceId%sss.spanSampleRate != 0 {
+	if !sss.sampled(ssfSpan) {
 		atomic.AddUint32(&sss.skippedSpans, 1)
 		return nil
 	}
@@ -380,6 +1037,13 @@ func (sss *splunkSpanSink) Ingest(ssfSpan *ssf.SSFSpan) error {
 		defer cancel()
 	}
 
+	tags := ssfSpan.Tags
+	if sss.tags != nil {
+		var stripped int
+		tags, stripped = sss.tags.filter(tags)
+		atomic.AddUint32(&sss.strippedTags, uint32(stripped))
+	}
+
 	serialized := SerializedSSF{
 		TraceId:        strconv.FormatInt(ssfSpan.TraceId, 10),
 		Id:             strconv.FormatInt(ssfSpan.Id, 10),
@@ -389,7 +1053,7 @@ func (sss *splunkSpanSink) Ingest(ssfSpan *ssf.SSFSpan) error {
 		Duration:       ssfSpan.EndTimestamp - ssfSpan.StartTimestamp,
 		Error:          ssfSpan.Error,
 		Service:        ssfSpan.Service,
-		Tags:           ssfSpan.Tags,
+		Tags:           tags,
 		Indicator:      ssfSpan.Indicator,
 		Name:           ssfSpan.Name,
 	}
@@ -399,18 +1063,41 @@ func (sss *splunkSpanSink) Ingest(ssfSpan *ssf.SSFSpan) error {
 	}
 	event.SetTime(time.Unix(0, ssfSpan.StartTimestamp))
 	event.SetHost(sss.hostname)
-	event.SetSourceType(ssfSpan.Service)
+	if sss.source != nil {
+		event.SetSource(sss.source.render(ssfSpan))
+	}
+	if sss.sourceType != nil {
+		event.SetSourceType(sss.sourceType.render(ssfSpan))
+	} else {
+		event.SetSourceType(ssfSpan.Service)
+	}
+	if index := sss.index(ssfSpan); index != "" {
+		event.SetIndex(index)
+	}
 
 	event.SetTime(time.Unix(0, ssfSpan.StartTimestamp))
 	select {
 	case sss.ingest <- event:
 		atomic.AddUint32(&sss.ingestedSpans, 1)
 	case <-ctx.Done():
-		atomic.AddUint32(&sss.droppedSpans, 1)
+		if sss.spill != nil {
+			sss.spillEvent(event)
+		} else {
+			atomic.AddUint32(&sss.droppedSpans, 1)
+		}
 	}
 	return nil
 }
 
+// index returns the index that a span's event is stored in, or "" for
+// the token's default index.
+func (sss *splunkSpanSink) index(span *ssf.SSFSpan) string {
+	if sss.indexTag != "" {
+		return sss.indexes[span.Tags[sss.indexTag]]
+	}
+	return sss.indexes[span.Service]
+}
+
 // SerializedSSF holds a set of fields in a format that Splunk can
 // handle (it can't handle int64s, and we don't want to round our
 // traceID to the thousands place).  This is mildly redundant, but oh
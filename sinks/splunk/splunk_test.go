@@ -1,19 +1,38 @@
 package splunk_test
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/json"
+	"encoding/pem"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/sinks/retry"
 	"github.com/stripe/veneur/sinks/splunk"
 	"github.com/stripe/veneur/ssf"
 	"github.com/stripe/veneur/trace"
@@ -60,6 +79,26 @@ func jsonEndpoint(t testing.TB, ch chan<- splunk.Event) http.Handler {
 	})
 }
 
+// gzipEndpoint decompresses gzipped requests before handing them to
+// the handler.
+func gzipEndpoint(t testing.TB, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("Request isn't gzipped: %v", r.Header)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("Reading gzipped request: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		r.Body = body
+		handler.ServeHTTP(w, r)
+	})
+}
+
 func TestSpanIngestBatch(t *testing.T) {
 	const nToFlush = 10
 	logger := logrus.StandardLogger()
@@ -67,8 +106,15 @@ func TestSpanIngestBatch(t *testing.T) {
 	ch := make(chan splunk.Event, nToFlush)
 	ts := httptest.NewServer(jsonEndpoint(t, ch))
 	defer ts.Close()
-	gsink, err := splunk.NewSplunkSpanSink(ts.URL, "00000000-0000-0000-0000-000000000000",
-		"test-host", "", logger, time.Duration(0), time.Duration(0), nToFlush, 0, 1, 1*time.Second, 0)
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:         []string{ts.URL},
+		Token:           "00000000-0000-0000-0000-000000000000",
+		Hostname:        "test-host",
+		Log:             logger,
+		BatchSize:       nToFlush,
+		SpanSampleRate:  1,
+		MaxConnLifetime: 1 * time.Second,
+	})
 	require.NoError(t, err)
 	sink := gsink.(splunk.TestableSplunkSpanSink)
 	err = sink.Start(nil)
@@ -134,6 +180,328 @@ func TestSpanIngestBatch(t *testing.T) {
 	sink.Stop()
 }
 
+func TestMaxBatchAge(t *testing.T) {
+	const nToFlush = 10
+	logger := logrus.StandardLogger()
+
+	// report the number of events in each submission once it's done:
+	batches := make(chan int, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		j := json.NewDecoder(r.Body)
+		n := 0
+		for {
+			input := splunk.Event{}
+			if err := j.Decode(&input); err != nil {
+				break
+			}
+			n++
+		}
+		w.Write([]byte(`{"text":"Success","code":0}`))
+		if n > 0 {
+			batches <- n
+		}
+	}))
+	defer ts.Close()
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:         []string{ts.URL},
+		Token:           "00000000-0000-0000-0000-000000000000",
+		Hostname:        "test-host",
+		Log:             logger,
+		BatchSize:       nToFlush,
+		SpanSampleRate:  1,
+		MaxConnLifetime: 10 * time.Second,
+		MaxBatchAge:     50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	sink := gsink.(splunk.TestableSplunkSpanSink)
+	err = sink.Start(nil)
+	require.NoError(t, err)
+	defer sink.Stop()
+
+	start := time.Unix(100000, 1000000)
+	span := &ssf.SSFSpan{
+		TraceId:        6,
+		StartTimestamp: start.UnixNano(),
+		EndTimestamp:   start.Add(5 * time.Second).UnixNano(),
+		Service:        "test-srv",
+		Name:           "test-span",
+	}
+	for i := 0; i < 3; i++ {
+		span.Id = int64(i + 1)
+		require.NoError(t, sink.Ingest(span))
+	}
+
+	// well before the connection's lifetime is up:
+	select {
+	case n := <-batches:
+		assert.Equal(t, 3, n, "the partially-filled batch should be submitted whole")
+	case <-time.After(5 * time.Second):
+		t.Fatal("the partially-filled batch wasn't submitted")
+	}
+}
+
+func TestMaxBatchBytes(t *testing.T) {
+	const maxBatchBytes = 1200
+	logger := logrus.StandardLogger()
+
+	// report the size and the number of events of each submission:
+	type submission struct{ bytes, events int }
+	submissions := make(chan submission, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		w.Write([]byte(`{"text":"Success","code":0}`))
+		if n := bytes.Count(body, []byte("\n")); n > 0 {
+			submissions <- submission{len(body), n}
+		}
+	}))
+	defer ts.Close()
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:         []string{ts.URL},
+		Token:           "00000000-0000-0000-0000-000000000000",
+		Hostname:        "test-host",
+		Log:             logger,
+		BatchSize:       100,
+		SpanSampleRate:  1,
+		MaxConnLifetime: 10 * time.Second,
+		MaxBatchBytes:   maxBatchBytes,
+	})
+	require.NoError(t, err)
+	sink := gsink.(splunk.TestableSplunkSpanSink)
+	require.NoError(t, sink.Start(nil))
+	defer sink.Stop()
+
+	start := time.Unix(100000, 1000000)
+	for i := 0; i < 6; i++ {
+		name := "test-span"
+		if i == 2 {
+			name = strings.Repeat("x", maxBatchBytes)
+		}
+		require.NoError(t, sink.Ingest(&ssf.SSFSpan{
+			Id:             int64(i + 1),
+			TraceId:        6,
+			StartTimestamp: start.UnixNano(),
+			EndTimestamp:   start.Add(5 * time.Second).UnixNano(),
+			Service:        "test-srv",
+			Name:           name,
+		}))
+	}
+	sink.Sync()
+
+	events, batches := 0, 0
+	for events < 5 {
+		select {
+		case sub := <-submissions:
+			assert.True(t, sub.bytes <= maxBatchBytes, "submitted %d bytes", sub.bytes)
+			events += sub.events
+			batches++
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received only %d of 5 events", events)
+		}
+	}
+	assert.Equal(t, 5, events, "the oversized span should be dropped")
+	assert.True(t, batches > 1, "the spans should be split across batches")
+}
+
+// hecEndpoint serves the HEC's health endpoint, and accepts requests
+// with the token "good".
+func hecEndpoint(healthy bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/services/collector/health" {
+			if !healthy {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				w.Write([]byte(`{"text":"HEC is unhealthy","code":17}`))
+				return
+			}
+			w.Write([]byte(`{"text":"HEC is healthy","code":17}`))
+			return
+		}
+		if r.Header.Get("Authorization") != "Splunk good" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"text":"Invalid token","code":4}`))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"text":"No data","code":5}`))
+	})
+}
+
+func TestHealthCheck(t *testing.T) {
+	logger := logrus.StandardLogger()
+	tests := []struct {
+		name      string
+		healthy   bool
+		token     string
+		secondary string
+		err       string
+	}{
+		{"healthy", true, "good", "", ""},
+		{"bad_token", true, "bad", "", "rejected the token"},
+		{"unhealthy", false, "good", "", "unhealthy"},
+		{"good_secondary_token", true, "bad", "good", ""},
+		{"bad_secondary_token", true, "bad", "worse", "rejected the token"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ts := httptest.NewServer(hecEndpoint(test.healthy))
+			defer ts.Close()
+			gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+				Servers:         []string{ts.URL},
+				Token:           test.token,
+				Hostname:        "test-host",
+				Log:             logger,
+				BatchSize:       10,
+				SpanSampleRate:  1,
+				MaxConnLifetime: 1 * time.Second,
+				HealthCheck:     true,
+				SecondaryToken:  test.secondary,
+			})
+			require.NoError(t, err)
+			sink := gsink.(splunk.TestableSplunkSpanSink)
+
+			err = sink.Start(nil)
+			if test.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.err)
+				return
+			}
+			require.NoError(t, err)
+			sink.Stop()
+		})
+	}
+}
+
+func TestHealthCheckWarnOnly(t *testing.T) {
+	logger := logrus.StandardLogger()
+	ts := httptest.NewServer(hecEndpoint(true))
+	defer ts.Close()
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:             []string{ts.URL},
+		Token:               "bad",
+		Hostname:            "test-host",
+		Log:                 logger,
+		BatchSize:           10,
+		SpanSampleRate:      1,
+		MaxConnLifetime:     1 * time.Second,
+		HealthCheck:         true,
+		HealthCheckWarnOnly: true,
+	})
+	require.NoError(t, err)
+	sink := gsink.(splunk.TestableSplunkSpanSink)
+	require.NoError(t, sink.Start(nil), "a rejected token should only be logged")
+	sink.Stop()
+
+	// a HEC URL that nothing listens on:
+	down := httptest.NewServer(hecEndpoint(true))
+	down.Close()
+	gsink, err = splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:         []string{down.URL},
+		Token:           "good",
+		Hostname:        "test-host",
+		Log:             logger,
+		BatchSize:       10,
+		SpanSampleRate:  1,
+		MaxConnLifetime: 1 * time.Second,
+		HealthCheck:     true,
+	})
+	require.NoError(t, err)
+	err = gsink.Start(nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "could not reach splunk HEC")
+}
+
+func TestHealthGauge(t *testing.T) {
+	logger := logrus.StandardLogger()
+	ts := httptest.NewServer(hecEndpoint(true))
+	defer ts.Close()
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:         []string{ts.URL},
+		Token:           "good",
+		Hostname:        "test-host",
+		Log:             logger,
+		BatchSize:       10,
+		SpanSampleRate:  1,
+		MaxConnLifetime: 1 * time.Second,
+		HealthCheck:     true,
+	})
+	require.NoError(t, err)
+	sink := gsink.(splunk.TestableSplunkSpanSink)
+
+	spans := make(chan *ssf.SSFSpan, 2)
+	traceClient, err := trace.NewBackendClient(&testBackend{spans})
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(traceClient))
+	defer sink.Stop()
+
+	sink.Flush(context.Background())
+	for i := 0; i < 2; i++ {
+		select {
+		case span := <-spans:
+			for _, sample := range span.Metrics {
+				if strings.HasSuffix(sample.Name, "splunk.hec_healthy") {
+					assert.Equal(t, float32(1), sample.Value)
+					return
+				}
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the health gauge")
+		}
+	}
+	t.Fatal("the health gauge wasn't reported")
+}
+
+func TestTokenFailover(t *testing.T) {
+	logger := logrus.StandardLogger()
+	ch := make(chan splunk.Event, 10)
+	accept := jsonEndpoint(t, ch)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Splunk good" {
+			io.Copy(ioutil.Discard, r.Body)
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"text":"Invalid token","code":4}`))
+			return
+		}
+		accept.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:         []string{ts.URL},
+		Token:           "revoked",
+		Hostname:        "test-host",
+		Log:             logger,
+		BatchSize:       1,
+		SpanSampleRate:  1,
+		MaxConnLifetime: 1 * time.Second,
+		SecondaryToken:  "good",
+	})
+	require.NoError(t, err)
+	sink := gsink.(splunk.TestableSplunkSpanSink)
+	require.NoError(t, sink.Start(nil))
+	defer sink.Stop()
+
+	span := &ssf.SSFSpan{
+		Id:             1,
+		TraceId:        2,
+		StartTimestamp: time.Now().UnixNano(),
+		EndTimestamp:   time.Now().Add(time.Second).UnixNano(),
+		Service:        "test-srv",
+		Name:           "test-span",
+	}
+	// The batches in flight when the HEC rejects the primary token
+	// are lost, but later ones are submitted with the secondary:
+	for i := 0; i < 50; i++ {
+		require.NoError(t, sink.Ingest(span))
+		select {
+		case event := <-ch:
+			assert.Equal(t, "test-srv", *event.SourceType)
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	t.Fatal("no spans were submitted with the secondary token")
+}
+
 type testBackend struct {
 	spans chan *ssf.SSFSpan
 }
@@ -159,8 +527,16 @@ func TestTimeout(t *testing.T) {
 		time.Sleep(time.Duration(100 * time.Millisecond))
 	}))
 	defer ts.Close()
-	gsink, err := splunk.NewSplunkSpanSink(ts.URL, "00000000-0000-0000-0000-000000000000",
-		"test-host", "", logger, time.Duration(0), time.Duration(10*time.Millisecond), nToFlush, 0, 1, 1*time.Second, 0)
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:         []string{ts.URL},
+		Token:           "00000000-0000-0000-0000-000000000000",
+		Hostname:        "test-host",
+		Log:             logger,
+		SendTimeout:     time.Duration(10 * time.Millisecond),
+		BatchSize:       nToFlush,
+		SpanSampleRate:  1,
+		MaxConnLifetime: 1 * time.Second,
+	})
 	require.NoError(t, err)
 	sink := gsink.(splunk.TestableSplunkSpanSink)
 
@@ -206,10 +582,74 @@ func TestTimeout(t *testing.T) {
 		}
 	}
 	require.NotNil(t, found, "Expected a timeout metric to be reported")
-	assert.Equal(t, found.Tags["cause"], "submission_timeout")
+	assert.Equal(t, found.Tags["reason"], "submission_timeout")
+	assert.Equal(t, found.Tags["cause"], "sink_timeout")
+	assert.Equal(t, found.Tags["component"], "splunk")
 	sink.Stop()
 }
 
+func TestSubmissionResponses(t *testing.T) {
+	const nToFlush = 10
+	logger := logrus.StandardLogger()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(ioutil.Discard, r.Body)
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"text":"Invalid token","code":4}`))
+	}))
+	defer ts.Close()
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:         []string{ts.URL},
+		Token:           "00000000-0000-0000-0000-000000000000",
+		Hostname:        "test-host",
+		Log:             logger,
+		BatchSize:       nToFlush,
+		SpanSampleRate:  1,
+		MaxConnLifetime: 1 * time.Second,
+	})
+	require.NoError(t, err)
+	sink := gsink.(splunk.TestableSplunkSpanSink)
+
+	spans := make(chan *ssf.SSFSpan)
+	traceClient, err := trace.NewBackendClient(&testBackend{spans})
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(traceClient))
+	defer sink.Stop()
+
+	start := time.Now()
+	for i := 0; i < nToFlush; i++ {
+		require.NoError(t, sink.Ingest(&ssf.SSFSpan{
+			Id:             int64(i + 1),
+			TraceId:        6,
+			StartTimestamp: start.UnixNano(),
+			EndTimestamp:   start.Add(time.Second).UnixNano(),
+			Service:        "test-srv",
+			Name:           "test-span",
+		}))
+	}
+	sink.Sync()
+
+	var found *ssf.SSFSample
+	timeout := time.After(5 * time.Second)
+	for found == nil {
+		select {
+		case ms := <-spans:
+			for _, sample := range ms.Metrics {
+				if strings.HasSuffix(sample.Name, "splunk.hec_submission_responses_total") {
+					found = sample
+				}
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for the response metric")
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"status_class":     "4xx",
+		"http_status_code": "403",
+		"hec_code":         "4",
+	}, found.Tags)
+}
+
 const benchmarkCapacity = 100
 const benchmarkWorkers = 3
 
@@ -219,8 +659,16 @@ func BenchmarkBatchIngest(b *testing.B) {
 	// set up a null responder that we can flush to:
 	ts := httptest.NewServer(jsonEndpoint(b, nil))
 	defer ts.Close()
-	gsink, err := splunk.NewSplunkSpanSink(ts.URL, "00000000-0000-0000-0000-000000000000",
-		"test-host", "", logger, time.Duration(0), time.Duration(0), benchmarkCapacity, benchmarkWorkers, 1, 1*time.Second, 0)
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:         []string{ts.URL},
+		Token:           "00000000-0000-0000-0000-000000000000",
+		Hostname:        "test-host",
+		Log:             logger,
+		BatchSize:       benchmarkCapacity,
+		Workers:         benchmarkWorkers,
+		SpanSampleRate:  1,
+		MaxConnLifetime: 1 * time.Second,
+	})
 	require.NoError(b, err)
 	sink := gsink.(splunk.TestableSplunkSpanSink)
 
@@ -264,8 +712,15 @@ func TestSampling(t *testing.T) {
 
 	ch := make(chan splunk.Event, nToFlush)
 	ts := httptest.NewServer(jsonEndpoint(t, ch))
-	gsink, err := splunk.NewSplunkSpanSink(ts.URL, "00000000-0000-0000-0000-000000000000",
-		"test-host", "", logger, time.Duration(0), time.Duration(0), nToFlush, 0, 10, 1*time.Second, 0)
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:         []string{ts.URL},
+		Token:           "00000000-0000-0000-0000-000000000000",
+		Hostname:        "test-host",
+		Log:             logger,
+		BatchSize:       nToFlush,
+		SpanSampleRate:  10,
+		MaxConnLifetime: 1 * time.Second,
+	})
 	require.NoError(t, err)
 	sink := gsink.(splunk.TestableSplunkSpanSink)
 	err = sink.Start(nil)
@@ -280,7 +735,7 @@ func TestSampling(t *testing.T) {
 		Service:        "test-srv",
 		Name:           "test-span",
 		Indicator:      false,
-		Error:          true,
+		Error:          false,
 		Tags: map[string]string{
 			"farts": "mandatory",
 		},
@@ -325,8 +780,15 @@ func TestSamplingIndicators(t *testing.T) {
 
 	ch := make(chan splunk.Event, nToFlush)
 	ts := httptest.NewServer(jsonEndpoint(t, ch))
-	gsink, err := splunk.NewSplunkSpanSink(ts.URL, "00000000-0000-0000-0000-000000000000",
-		"test-host", "", logger, time.Duration(0), time.Duration(0), nToFlush, 0, 10, 1*time.Second, 0)
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:         []string{ts.URL},
+		Token:           "00000000-0000-0000-0000-000000000000",
+		Hostname:        "test-host",
+		Log:             logger,
+		BatchSize:       nToFlush,
+		SpanSampleRate:  10,
+		MaxConnLifetime: 1 * time.Second,
+	})
 	require.NoError(t, err)
 	sink := gsink.(splunk.TestableSplunkSpanSink)
 	err = sink.Start(nil)
@@ -378,3 +840,1469 @@ func TestSamplingIndicators(t *testing.T) {
 	assert.Equal(t, events, nToFlush, "Should have sent all the spans, but received %d of %d", events, nToFlush)
 	t.Logf("Received %d of %d events", events, nToFlush)
 }
+
+func TestIndexerAck(t *testing.T) {
+	logger := logrus.StandardLogger()
+	var mtx sync.Mutex
+	var nextAckID int64
+	submissions := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the sink streams its batches, so don't hold the lock
+		// while reading them:
+		body, _ := ioutil.ReadAll(r.Body)
+		mtx.Lock()
+		defer mtx.Unlock()
+		if r.URL.Path == "/services/collector/ack" {
+			var req struct {
+				Acks []int64 `json:"acks"`
+			}
+			require.NoError(t, json.Unmarshal(body, &req))
+			acks := map[string]bool{}
+			for _, id := range req.Acks {
+				// the first submission is never indexed:
+				acks[strconv.FormatInt(id, 10)] = id > 0
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"acks": acks})
+			return
+		}
+		if len(body) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"text":"No data","code":5}`))
+			return
+		}
+		submissions++
+		fmt.Fprintf(w, `{"text":"Success","code":0,"ackId":%d}`, nextAckID)
+		nextAckID++
+	}))
+	defer ts.Close()
+
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:         []string{ts.URL},
+		Token:           "00000000-0000-0000-0000-000000000000",
+		Hostname:        "test-host",
+		Log:             logger,
+		BatchSize:       1,
+		SpanSampleRate:  1,
+		MaxConnLifetime: 10 * time.Second,
+		AckTimeout:      100 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	sink := gsink.(splunk.TestableSplunkSpanSink)
+	require.NoError(t, sink.Start(nil))
+	defer sink.Stop()
+
+	start := time.Now()
+	require.NoError(t, sink.Ingest(&ssf.SSFSpan{
+		Id:             1,
+		TraceId:        2,
+		StartTimestamp: start.UnixNano(),
+		EndTimestamp:   start.Add(time.Second).UnixNano(),
+		Service:        "test-srv",
+		Name:           "test-span",
+	}))
+
+	submitted := func() int {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return submissions
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for submitted() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Equal(t, 2, submitted(), "the unacknowledged batch should be submitted again")
+
+	// the second submission is acknowledged, so it isn't submitted
+	// a third time:
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, 2, submitted())
+}
+
+func TestGzipPayloads(t *testing.T) {
+	const nToFlush = 10
+	logger := logrus.StandardLogger()
+
+	ch := make(chan splunk.Event, nToFlush)
+	ts := httptest.NewServer(gzipEndpoint(t, jsonEndpoint(t, ch)))
+	defer ts.Close()
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:         []string{ts.URL},
+		Token:           "00000000-0000-0000-0000-000000000000",
+		Hostname:        "test-host",
+		Log:             logger,
+		BatchSize:       nToFlush,
+		SpanSampleRate:  1,
+		MaxConnLifetime: 1 * time.Second,
+		Gzip:            true,
+	})
+	require.NoError(t, err)
+	sink := gsink.(splunk.TestableSplunkSpanSink)
+	require.NoError(t, sink.Start(nil))
+	defer sink.Stop()
+
+	start := time.Now()
+	for i := 0; i < nToFlush; i++ {
+		require.NoError(t, sink.Ingest(&ssf.SSFSpan{
+			Id:             int64(i + 1),
+			TraceId:        6,
+			StartTimestamp: start.UnixNano(),
+			EndTimestamp:   start.Add(time.Second).UnixNano(),
+			Service:        "test-srv",
+			Name:           "test-span",
+		}))
+	}
+	sink.Sync()
+
+	for i := 0; i < nToFlush; i++ {
+		select {
+		case event := <-ch:
+			assert.Equal(t, "test-srv", *event.SourceType)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received only %d of %d events", i, nToFlush)
+		}
+	}
+}
+
+func TestRawEndpoint(t *testing.T) {
+	const nToFlush = 10
+	logger := logrus.StandardLogger()
+
+	ch := make(chan splunk.SerializedSSF, nToFlush)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/services/collector/raw" || query.Get("channel") == "" {
+			t.Errorf("Unexpected raw endpoint URL: %q", r.URL.String())
+		}
+		assert.Equal(t, "veneur:span", query.Get("sourcetype"))
+		assert.Equal(t, "test-host", query.Get("host"))
+		lines := bufio.NewScanner(r.Body)
+		for lines.Scan() {
+			// every line is a span on its own, without the
+			// event envelope:
+			span := splunk.SerializedSSF{}
+			dec := json.NewDecoder(bytes.NewReader(lines.Bytes()))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&span); err != nil {
+				t.Errorf("Decoding raw line %q: %v", lines.Text(), err)
+				continue
+			}
+			ch <- span
+		}
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer ts.Close()
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:         []string{ts.URL},
+		Token:           "00000000-0000-0000-0000-000000000000",
+		Hostname:        "test-host",
+		Log:             logger,
+		BatchSize:       nToFlush,
+		SpanSampleRate:  1,
+		MaxConnLifetime: 1 * time.Second,
+		RawEndpoint:     true,
+		RawSourceType:   "veneur:span",
+	})
+	require.NoError(t, err)
+	sink := gsink.(splunk.TestableSplunkSpanSink)
+	require.NoError(t, sink.Start(nil))
+	defer sink.Stop()
+
+	start := time.Now()
+	for i := 0; i < nToFlush; i++ {
+		require.NoError(t, sink.Ingest(&ssf.SSFSpan{
+			Id:             int64(i + 1),
+			TraceId:        6,
+			StartTimestamp: start.UnixNano(),
+			EndTimestamp:   start.Add(time.Second).UnixNano(),
+			Service:        "test-srv",
+			Name:           "test-span",
+		}))
+	}
+	sink.Sync()
+
+	for i := 0; i < nToFlush; i++ {
+		select {
+		case span := <-ch:
+			assert.Equal(t, "test-srv", span.Service)
+			assert.Equal(t, "6", span.TraceId)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received only %d of %d spans", i, nToFlush)
+		}
+	}
+
+	_, err = splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:         []string{ts.URL},
+		Token:           "00000000-0000-0000-0000-000000000000",
+		Hostname:        "test-host",
+		Log:             logger,
+		BatchSize:       nToFlush,
+		SpanSampleRate:  1,
+		MaxConnLifetime: 1 * time.Second,
+		Indexes:         map[string]string{"test-srv": "team"},
+		RawEndpoint:     true,
+	})
+	assert.Error(t, err, "index routing shouldn't be possible on the raw endpoint")
+}
+
+func TestRetryRejectedBatches(t *testing.T) {
+	const nToFlush = 10
+	logger := logrus.StandardLogger()
+
+	var mtx sync.Mutex
+	var received []int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		j := json.NewDecoder(r.Body)
+		n := 0
+		for {
+			input := splunk.Event{}
+			if err := j.Decode(&input); err != nil {
+				break
+			}
+			n++
+		}
+		if n == 0 {
+			w.Write([]byte(`{"text":"Success","code":0}`))
+			return
+		}
+		mtx.Lock()
+		received = append(received, n)
+		first := len(received) == 1
+		mtx.Unlock()
+		if first {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"text":"Server is busy","code":9}`))
+			return
+		}
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer ts.Close()
+
+	policy, err := retry.ParsePolicy(retry.PolicyConfig{InitialBackoff: "1ms"})
+	require.NoError(t, err)
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:          []string{ts.URL},
+		Token:            "00000000-0000-0000-0000-000000000000",
+		Hostname:         "test-host",
+		Log:              logger,
+		BatchSize:        nToFlush,
+		SpanSampleRate:   1,
+		MaxConnLifetime:  1 * time.Second,
+		Retrier:          retry.New("splunk", policy, nil, logger),
+		RetryBufferBytes: 1024 * 1024,
+	})
+	require.NoError(t, err)
+	sink := gsink.(splunk.TestableSplunkSpanSink)
+	require.NoError(t, sink.Start(nil))
+	defer sink.Stop()
+
+	start := time.Now()
+	for i := 0; i < nToFlush; i++ {
+		require.NoError(t, sink.Ingest(&ssf.SSFSpan{
+			Id:             int64(i + 1),
+			TraceId:        6,
+			StartTimestamp: start.UnixNano(),
+			EndTimestamp:   start.Add(time.Second).UnixNano(),
+			Service:        "test-srv",
+			Name:           "test-span",
+		}))
+	}
+	sink.Sync()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mtx.Lock()
+		n := len(received)
+		mtx.Unlock()
+		if n >= 2 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	assert.Equal(t, []int{nToFlush, nToFlush}, received,
+		"the rejected batch should be submitted again whole")
+}
+
+func TestRetryBatchesAnsweredEarly(t *testing.T) {
+	const nToFlush = 10
+	logger := logrus.StandardLogger()
+
+	var mtx sync.Mutex
+	answered := false
+	accepted := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		j := json.NewDecoder(r.Body)
+		n := 0
+		for {
+			input := splunk.Event{}
+			if err := j.Decode(&input); err != nil {
+				break
+			}
+			n++
+
+			mtx.Lock()
+			early := !answered
+			answered = true
+			mtx.Unlock()
+			if early {
+				// answer the first batch after its first
+				// event, without reading the rest of it:
+				conn, _, err := w.(http.Hijacker).Hijack()
+				require.NoError(t, err)
+				body := `{"text":"Server is busy","code":9}`
+				fmt.Fprintf(conn, "HTTP/1.1 503 Service Unavailable\r\n"+
+					"Content-Type: application/json\r\nContent-Length: %d\r\n"+
+					"Connection: close\r\n\r\n%s", len(body), body)
+				conn.Close()
+				return
+			}
+		}
+		mtx.Lock()
+		accepted += n
+		mtx.Unlock()
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer ts.Close()
+
+	policy, err := retry.ParsePolicy(retry.PolicyConfig{InitialBackoff: "1ms"})
+	require.NoError(t, err)
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:          []string{ts.URL},
+		Token:            "00000000-0000-0000-0000-000000000000",
+		Hostname:         "test-host",
+		Log:              logger,
+		BatchSize:        nToFlush,
+		SpanSampleRate:   1,
+		MaxConnLifetime:  1 * time.Second,
+		Retrier:          retry.New("splunk", policy, nil, logger),
+		RetryBufferBytes: 1024 * 1024,
+	})
+	require.NoError(t, err)
+	sink := gsink.(splunk.TestableSplunkSpanSink)
+	require.NoError(t, sink.Start(nil))
+	defer sink.Stop()
+
+	start := time.Now()
+	for i := 0; i < nToFlush; i++ {
+		if i == 1 {
+			// let the HEC answer before the batch is
+			// complete:
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				mtx.Lock()
+				early := answered
+				mtx.Unlock()
+				if early || time.Now().After(deadline) {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		require.NoError(t, sink.Ingest(&ssf.SSFSpan{
+			Id:             int64(i + 1),
+			TraceId:        7,
+			StartTimestamp: start.UnixNano(),
+			EndTimestamp:   start.Add(time.Second).UnixNano(),
+			Service:        "test-srv",
+			Name:           "test-span",
+		}))
+	}
+	sink.Sync()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mtx.Lock()
+		n := accepted
+		mtx.Unlock()
+		if n >= nToFlush || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	assert.True(t, answered, "the first batch should have been answered early")
+	assert.Equal(t, nToFlush, accepted,
+		"every span of a batch answered early should be submitted again")
+}
+
+func TestSpillToDisk(t *testing.T) {
+	const nToFlush = 10
+	logger := logrus.StandardLogger()
+	dir, err := ioutil.TempDir("", "splunk-spill")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var mtx sync.Mutex
+	var received []int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		j := json.NewDecoder(r.Body)
+		n := 0
+		for {
+			input := splunk.Event{}
+			if err := j.Decode(&input); err != nil {
+				break
+			}
+			n++
+		}
+		if n == 0 {
+			w.Write([]byte(`{"text":"Success","code":0}`))
+			return
+		}
+		mtx.Lock()
+		received = append(received, n)
+		first := len(received) == 1
+		mtx.Unlock()
+		if first {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"text":"Server is busy","code":9}`))
+			return
+		}
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer ts.Close()
+
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:         []string{ts.URL},
+		Token:           "00000000-0000-0000-0000-000000000000",
+		Hostname:        "test-host",
+		Log:             logger,
+		BatchSize:       nToFlush,
+		SpanSampleRate:  1,
+		MaxConnLifetime: 1 * time.Second,
+		SpillDir:        dir,
+		SpillMaxBytes:   1024 * 1024,
+	})
+	require.NoError(t, err)
+	sink := gsink.(splunk.TestableSplunkSpanSink)
+	require.NoError(t, sink.Start(nil))
+	defer sink.Stop()
+
+	start := time.Now()
+	for i := 0; i < nToFlush; i++ {
+		require.NoError(t, sink.Ingest(&ssf.SSFSpan{
+			Id:             int64(i + 1),
+			TraceId:        6,
+			StartTimestamp: start.UnixNano(),
+			EndTimestamp:   start.Add(time.Second).UnixNano(),
+			Service:        "test-srv",
+			Name:           "test-span",
+		}))
+	}
+	sink.Sync()
+
+	// the HEC rejects the batch, which is spilled to disk and
+	// submitted from there once the HEC accepts requests again:
+	spilled := func() []os.FileInfo {
+		files, err := ioutil.ReadDir(dir)
+		require.NoError(t, err)
+		return files
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mtx.Lock()
+		n := len(received)
+		mtx.Unlock()
+		if (n >= 2 && len(spilled()) == 0) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	assert.Equal(t, []int{nToFlush, nToFlush}, received,
+		"the spilled batch should be submitted again whole")
+	assert.Empty(t, spilled(), "the drained batch should be removed")
+}
+
+func TestSpillLeftovers(t *testing.T) {
+	logger := logrus.StandardLogger()
+	dir, err := ioutil.TempDir("", "splunk-spill")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// a batch left over by a previous run:
+	batch := `{"host":"test-host","sourcetype":"test-srv","event":{"id":"1","name":"test-span"}}` + "\n"
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "00000000000000000007.batch"), []byte(batch), 0644))
+	// and a file that isn't a batch:
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README"), []byte("hi"), 0644))
+
+	received := make(chan string, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if len(body) > 0 {
+			received <- string(body)
+		}
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer ts.Close()
+
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:         []string{ts.URL},
+		Token:           "00000000-0000-0000-0000-000000000000",
+		Hostname:        "test-host",
+		Log:             logger,
+		BatchSize:       10,
+		SpanSampleRate:  1,
+		MaxConnLifetime: 1 * time.Second,
+		SpillDir:        dir,
+		SpillMaxBytes:   1024 * 1024,
+	})
+	require.NoError(t, err)
+	sink := gsink.(splunk.TestableSplunkSpanSink)
+	require.NoError(t, sink.Start(nil))
+	defer sink.Stop()
+
+	select {
+	case body := <-received:
+		assert.Equal(t, batch, body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the leftover batch wasn't submitted")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	var names []string
+	for time.Now().Before(deadline) {
+		files, err := ioutil.ReadDir(dir)
+		require.NoError(t, err)
+		names = names[:0]
+		for _, f := range files {
+			names = append(names, f.Name())
+		}
+		if len(names) == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, []string{"README"}, names)
+}
+
+func TestIndexRouting(t *testing.T) {
+	tests := []struct {
+		name     string
+		indexTag string
+		indexes  map[string]string
+	}{
+		{"by service", "", map[string]string{"srv-a": "team-a"}},
+		{"by tag", "team", map[string]string{"a": "team-a"}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			logger := logrus.StandardLogger()
+			ch := make(chan splunk.Event, 2)
+			ts := httptest.NewServer(jsonEndpoint(t, ch))
+			defer ts.Close()
+			gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+				Servers:         []string{ts.URL},
+				Token:           "00000000-0000-0000-0000-000000000000",
+				Hostname:        "test-host",
+				Log:             logger,
+				BatchSize:       2,
+				SpanSampleRate:  1,
+				MaxConnLifetime: 1 * time.Second,
+				IndexTag:        test.indexTag,
+				Indexes:         test.indexes,
+			})
+			require.NoError(t, err)
+			sink := gsink.(splunk.TestableSplunkSpanSink)
+			require.NoError(t, sink.Start(nil))
+			defer sink.Stop()
+
+			start := time.Now()
+			for i, service := range []string{"srv-a", "srv-b"} {
+				require.NoError(t, sink.Ingest(&ssf.SSFSpan{
+					Id:             int64(i + 1),
+					TraceId:        6,
+					StartTimestamp: start.UnixNano(),
+					EndTimestamp:   start.Add(time.Second).UnixNano(),
+					Service:        service,
+					Name:           "test-span",
+					Tags:           map[string]string{"team": service[len(service)-1:]},
+				}))
+			}
+			sink.Sync()
+
+			indexes := map[string]string{}
+			for i := 0; i < 2; i++ {
+				select {
+				case event := <-ch:
+					index := ""
+					if event.Index != nil {
+						index = *event.Index
+					}
+					indexes[*event.SourceType] = index
+				case <-time.After(5 * time.Second):
+					t.Fatalf("received only %d of 2 events", i)
+				}
+			}
+			assert.Equal(t, map[string]string{"srv-a": "team-a", "srv-b": ""}, indexes)
+		})
+	}
+}
+
+func TestSampleAlwaysKeep(t *testing.T) {
+	logger := logrus.StandardLogger()
+	ch := make(chan splunk.Event, 10)
+	ts := httptest.NewServer(jsonEndpoint(t, ch))
+	defer ts.Close()
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:         []string{ts.URL},
+		Token:           "00000000-0000-0000-0000-000000000000",
+		Hostname:        "test-host",
+		Log:             logger,
+		BatchSize:       10,
+		SpanSampleRate:  1000,
+		MaxConnLifetime: 1 * time.Second,
+		AlwaysKeep:      []string{"error", "tag:debug=true"},
+	})
+	require.NoError(t, err)
+	sink := gsink.(splunk.TestableSplunkSpanSink)
+	require.NoError(t, sink.Start(nil))
+	defer sink.Stop()
+
+	start := time.Now()
+	spans := []*ssf.SSFSpan{
+		{Name: "sampled-out"},
+		{Name: "indicator", Indicator: true},
+		{Name: "error", Error: true},
+		{Name: "debug", Tags: map[string]string{"debug": "true"}},
+		{Name: "not-debug", Tags: map[string]string{"debug": "false"}},
+	}
+	for i, span := range spans {
+		span.Id = int64(i + 1)
+		span.TraceId = 7
+		span.Service = "test-srv"
+		span.StartTimestamp = start.UnixNano()
+		span.EndTimestamp = start.Add(time.Second).UnixNano()
+		require.NoError(t, sink.Ingest(span))
+	}
+	sink.Sync()
+
+	var names []string
+	for i := 0; i < 2; i++ {
+		select {
+		case event := <-ch:
+			output := event.Event.(map[string]interface{})
+			names = append(names, output["name"].(string))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received only %d of 2 events", i)
+		}
+	}
+	assert.ElementsMatch(t, []string{"error", "debug"}, names)
+
+	_, err = splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:         []string{ts.URL},
+		Token:           "00000000-0000-0000-0000-000000000000",
+		Hostname:        "test-host",
+		Log:             logger,
+		BatchSize:       10,
+		SpanSampleRate:  1000,
+		MaxConnLifetime: 1 * time.Second,
+		AlwaysKeep:      []string{"slow"},
+	})
+	assert.Error(t, err)
+}
+
+func TestSampleDecisionTag(t *testing.T) {
+	logger := logrus.StandardLogger()
+	ch := make(chan splunk.Event, 10)
+	ts := httptest.NewServer(jsonEndpoint(t, ch))
+	defer ts.Close()
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:           []string{ts.URL},
+		Token:             "00000000-0000-0000-0000-000000000000",
+		Hostname:          "test-host",
+		Log:               logger,
+		BatchSize:         10,
+		SpanSampleRate:    1000,
+		MaxConnLifetime:   1 * time.Second,
+		SampleDecisionTag: "veneur.sampling.keep",
+	})
+	require.NoError(t, err)
+	sink := gsink.(splunk.TestableSplunkSpanSink)
+	require.NoError(t, sink.Start(nil))
+	defer sink.Stop()
+
+	start := time.Now()
+	spans := []*ssf.SSFSpan{
+		{Name: "kept", Tags: map[string]string{"veneur.sampling.keep": "true"}},
+		{Name: "dropped", Error: true, Tags: map[string]string{"veneur.sampling.keep": "0"}},
+		{Name: "undecided"},
+		{Name: "error", Error: true},
+		{Name: "invalid", Tags: map[string]string{"veneur.sampling.keep": "maybe"}},
+	}
+	for i, span := range spans {
+		span.Id = int64(i + 1)
+		span.TraceId = 7
+		span.Service = "test-srv"
+		span.StartTimestamp = start.UnixNano()
+		span.EndTimestamp = start.Add(time.Second).UnixNano()
+		require.NoError(t, sink.Ingest(span))
+	}
+	sink.Sync()
+
+	var names []string
+	for i := 0; i < 2; i++ {
+		select {
+		case event := <-ch:
+			output := event.Event.(map[string]interface{})
+			names = append(names, output["name"].(string))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received only %d of 2 events", i)
+		}
+	}
+	assert.ElementsMatch(t, []string{"kept", "error"}, names,
+		"the upstream decision should override the sample rate and the spans that are always kept")
+}
+
+func TestSampleRateOverrides(t *testing.T) {
+	logger := logrus.StandardLogger()
+	_, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:             []string{"http://localhost"},
+		Token:               "00000000-0000-0000-0000-000000000000",
+		Hostname:            "test-host",
+		Log:                 logger,
+		BatchSize:           10,
+		SpanSampleRate:      1000,
+		MaxConnLifetime:     1 * time.Second,
+		SampleRateOverrides: []splunk.SampleRateOverride{{Service: "*", SampleRate: 1}},
+	})
+	assert.Error(t, err, "an override can't match every span")
+
+	ch := make(chan splunk.Event, 10)
+	ts := httptest.NewServer(jsonEndpoint(t, ch))
+	defer ts.Close()
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:         []string{ts.URL},
+		Token:           "00000000-0000-0000-0000-000000000000",
+		Hostname:        "test-host",
+		Log:             logger,
+		BatchSize:       10,
+		SpanSampleRate:  1000,
+		MaxConnLifetime: 1 * time.Second,
+		AlwaysKeep:      []string{},
+		SampleRateOverrides: []splunk.SampleRateOverride{
+			{Name: "checkout.charge", SampleRate: 1},
+			{Name: "healthcheck", SampleRate: 100},
+			{Service: "search", SampleRate: 5},
+		},
+	})
+	require.NoError(t, err)
+	sink := gsink.(splunk.TestableSplunkSpanSink)
+	require.NoError(t, sink.Start(nil))
+	defer sink.Stop()
+
+	start := time.Now()
+	spans := []*ssf.SSFSpan{
+		{Service: "checkout", Name: "checkout.charge"},
+		{Service: "checkout", Name: "healthcheck"},
+		{Service: "search", Name: "query"},
+		{Service: "search", Name: "healthcheck"},
+		{Service: "checkout", Name: "cart"},
+	}
+	for i, span := range spans {
+		span.Id = int64(i + 1)
+		span.TraceId = 10
+		span.StartTimestamp = start.UnixNano()
+		span.EndTimestamp = start.Add(time.Second).UnixNano()
+		require.NoError(t, sink.Ingest(span))
+	}
+	sink.Sync()
+
+	var names []string
+	for i := 0; i < 2; i++ {
+		select {
+		case event := <-ch:
+			output := event.Event.(map[string]interface{})
+			names = append(names, output["service"].(string)+"/"+output["name"].(string))
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received only %d of 2 events", i)
+		}
+	}
+	assert.ElementsMatch(t, []string{"checkout/checkout.charge", "search/query"}, names,
+		"the first override that matches a span should set its sample rate")
+}
+
+func TestWorkerAutoscaling(t *testing.T) {
+	logger := logrus.StandardLogger()
+	ch := make(chan splunk.Event, 10)
+	ts := httptest.NewServer(jsonEndpoint(t, ch))
+	defer ts.Close()
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:             []string{ts.URL},
+		Token:               "00000000-0000-0000-0000-000000000000",
+		Hostname:            "test-host",
+		Log:                 logger,
+		BatchSize:           10,
+		Workers:             1,
+		SpanSampleRate:      1,
+		MaxConnLifetime:     1 * time.Second,
+		MaxWorkers:          3,
+		WorkerScaleInterval: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	sink := gsink.(splunk.TestableSplunkSpanSink)
+	require.NoError(t, sink.Start(nil))
+	defer sink.Stop()
+	assert.Equal(t, 1, sink.Workers())
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		require.NoError(t, sink.Ingest(&ssf.SSFSpan{
+			TraceId:        1,
+			Id:             int64(i + 1),
+			Service:        "test-srv",
+			Name:           "autoscaled",
+			StartTimestamp: start.UnixNano(),
+			EndTimestamp:   start.Add(time.Second).UnixNano(),
+		}))
+	}
+	sink.Sync()
+	for i := 0; i < 5; i++ {
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received only %d of 5 events", i)
+		}
+	}
+
+	// idle checks don't stop the workers that the sink starts with:
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, 1, sink.Workers())
+}
+
+func TestTagFilter(t *testing.T) {
+	tests := []struct {
+		name      string
+		allowlist []string
+		denylist  []string
+		expected  map[string]string
+	}{
+		{"none", nil, nil, map[string]string{"user_id": "42", "auth.token": "s3cr3t", "region": "us", "team": "a"}},
+		{"denylist", nil, []string{"user_id", "auth.*"}, map[string]string{"region": "us", "team": "a"}},
+		{"allowlist", []string{"region", "team"}, nil, map[string]string{"region": "us", "team": "a"}},
+		{"both", []string{"region", "auth.*"}, []string{"auth.token"}, map[string]string{"region": "us"}},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			logger := logrus.StandardLogger()
+			ch := make(chan splunk.Event, 1)
+			ts := httptest.NewServer(jsonEndpoint(t, ch))
+			defer ts.Close()
+			gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+				Servers:         []string{ts.URL},
+				Token:           "00000000-0000-0000-0000-000000000000",
+				Hostname:        "test-host",
+				Log:             logger,
+				BatchSize:       1,
+				SpanSampleRate:  1,
+				MaxConnLifetime: 1 * time.Second,
+				IndexTag:        "team",
+				Indexes:         map[string]string{"a": "team-a"},
+				TagAllowlist:    test.allowlist,
+				TagDenylist:     test.denylist,
+			})
+			require.NoError(t, err)
+			sink := gsink.(splunk.TestableSplunkSpanSink)
+			require.NoError(t, sink.Start(nil))
+			defer sink.Stop()
+
+			start := time.Now()
+			tags := map[string]string{"user_id": "42", "auth.token": "s3cr3t", "region": "us", "team": "a"}
+			require.NoError(t, sink.Ingest(&ssf.SSFSpan{
+				Id:             1,
+				TraceId:        8,
+				StartTimestamp: start.UnixNano(),
+				EndTimestamp:   start.Add(time.Second).UnixNano(),
+				Service:        "test-srv",
+				Name:           "test-span",
+				Tags:           tags,
+			}))
+			sink.Sync()
+			assert.Len(t, tags, 4, "the span's own tags shouldn't be modified")
+
+			select {
+			case event := <-ch:
+				output := event.Event.(map[string]interface{})
+				actual := map[string]string{}
+				for k, v := range output["tags"].(map[string]interface{}) {
+					actual[k] = v.(string)
+				}
+				assert.Equal(t, test.expected, actual)
+				require.NotNil(t, event.Index)
+				assert.Equal(t, "team-a", *event.Index, "stripped tags should still route the span")
+			case <-time.After(5 * time.Second):
+				t.Fatal("received no event")
+			}
+		})
+	}
+}
+
+func TestSourceTemplates(t *testing.T) {
+	tests := []struct {
+		name               string
+		source, sourceType string
+		expectedSource     *string
+		expectedSourceType string
+	}{
+		{"default", "", "", nil, "test-srv"},
+		{"sourcetype", "", "veneur:span:{service}", nil, "veneur:span:test-srv"},
+		{"both", "veneur:{tag:region}", "{service}:{name}:{tag:missing}", splunk.String("veneur:us"), "test-srv:test-span:"},
+		{"literal", "traces", "veneur", splunk.String("traces"), "veneur"},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			logger := logrus.StandardLogger()
+			ch := make(chan splunk.Event, 1)
+			ts := httptest.NewServer(jsonEndpoint(t, ch))
+			defer ts.Close()
+			gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+				Servers:            []string{ts.URL},
+				Token:              "00000000-0000-0000-0000-000000000000",
+				Hostname:           "test-host",
+				Log:                logger,
+				BatchSize:          1,
+				SpanSampleRate:     1,
+				MaxConnLifetime:    1 * time.Second,
+				SourceTemplate:     test.source,
+				SourceTypeTemplate: test.sourceType,
+			})
+			require.NoError(t, err)
+			sink := gsink.(splunk.TestableSplunkSpanSink)
+			require.NoError(t, sink.Start(nil))
+			defer sink.Stop()
+
+			start := time.Now()
+			require.NoError(t, sink.Ingest(&ssf.SSFSpan{
+				Id:             1,
+				TraceId:        8,
+				StartTimestamp: start.UnixNano(),
+				EndTimestamp:   start.Add(time.Second).UnixNano(),
+				Service:        "test-srv",
+				Name:           "test-span",
+				Tags:           map[string]string{"region": "us"},
+			}))
+			sink.Sync()
+
+			select {
+			case event := <-ch:
+				assert.Equal(t, test.expectedSource, event.Source)
+				require.NotNil(t, event.SourceType)
+				assert.Equal(t, test.expectedSourceType, *event.SourceType)
+			case <-time.After(5 * time.Second):
+				t.Fatal("received no event")
+			}
+		})
+	}
+
+	for _, template := range []string{"veneur:{service", "{host}", "{tag:}"} {
+		_, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+			Servers:            []string{"http://localhost:8088"},
+			Token:              "00000000-0000-0000-0000-000000000000",
+			Hostname:           "test-host",
+			Log:                logrus.StandardLogger(),
+			BatchSize:          1,
+			SpanSampleRate:     1,
+			MaxConnLifetime:    1 * time.Second,
+			SourceTypeTemplate: template,
+		})
+		assert.Error(t, err, template)
+	}
+}
+
+func TestTokenFileRotation(t *testing.T) {
+	logger := logrus.StandardLogger()
+	dir, err := ioutil.TempDir("", "veneur-splunk")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte("old\n"), 0600))
+
+	var mtx sync.Mutex
+	var tokens []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		mtx.Lock()
+		tokens = append(tokens, r.Header.Get("Authorization"))
+		mtx.Unlock()
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	defer ts.Close()
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:              []string{ts.URL},
+		Hostname:             "test-host",
+		Log:                  logger,
+		BatchSize:            10,
+		SpanSampleRate:       1,
+		MaxConnLifetime:      1 * time.Second,
+		TokenFile:            tokenFile,
+		TokenRefreshInterval: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	sink := gsink.(splunk.TestableSplunkSpanSink)
+	require.NoError(t, sink.Start(nil))
+	defer sink.Stop()
+
+	start := time.Now()
+	ingest := func(id int64) {
+		require.NoError(t, sink.Ingest(&ssf.SSFSpan{
+			Id:             id,
+			TraceId:        6,
+			StartTimestamp: start.UnixNano(),
+			EndTimestamp:   start.Add(time.Second).UnixNano(),
+			Service:        "test-srv",
+			Name:           "test-span",
+		}))
+		sink.Sync()
+	}
+	lastToken := func() string {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if len(tokens) == 0 {
+			return ""
+		}
+		return tokens[len(tokens)-1]
+	}
+
+	// Batches are submitted with the token in use when their
+	// request started, so wait for the HEC to see the one wanted:
+	id := int64(0)
+	waitToken := func(want string) {
+		deadline := time.Now().Add(5 * time.Second)
+		for lastToken() != want && time.Now().Before(deadline) {
+			id++
+			ingest(id)
+			time.Sleep(20 * time.Millisecond)
+		}
+		assert.Equal(t, want, lastToken())
+	}
+
+	waitToken("Splunk old")
+	require.NoError(t, ioutil.WriteFile(tokenFile, []byte("new\n"), 0600))
+	waitToken("Splunk new")
+}
+
+// reflectedEvent has Event's fields but not its MarshalJSON method, so
+// encoding/json marshals it with reflection.
+type reflectedEvent splunk.Event
+
+func TestEventMarshalJSON(t *testing.T) {
+	spans := []splunk.SerializedSSF{
+		{
+			TraceId:        "6",
+			Id:             "7",
+			ParentId:       "4",
+			StartTimestamp: 100000.001,
+			EndTimestamp:   100005.001,
+			Duration:       5000000000,
+			Error:          true,
+			Service:        "test-srv",
+			Tags: map[string]string{
+				"farts":       "mandatory",
+				"html":        "<a href=\"x\">&amp;</a>",
+				"control":     "line\nbreak\ttab\r\b\f\x00\x1f",
+				"unicode":     "héllo 世界 \u2028\u2029",
+				"invalid":     "\xff\xfe",
+				"stack\\path": "C:\\veneur",
+			},
+			Indicator: true,
+			Name:      "test-span",
+		},
+		{
+			StartTimestamp: 1e-7,
+			EndTimestamp:   1e22,
+			Service:        "",
+		},
+		{},
+	}
+	for i, span := range spans {
+		events := []*splunk.Event{
+			{Event: span},
+			{Event: &span},
+		}
+		events[0].SetHost("test-host")
+		events[0].SetSourceType(span.Service)
+		events[0].SetIndex("team-a")
+		events[0].SetSource("veneur")
+		events[0].SetTime(time.Unix(100000, 1000000))
+		for _, event := range events {
+			expected, err := json.Marshal((*reflectedEvent)(event))
+			require.NoError(t, err)
+			actual, err := json.Marshal(event)
+			require.NoError(t, err)
+			assert.Equal(t, string(expected), string(actual), "span %d", i)
+		}
+	}
+
+	// Events that aren't spans are encoded as usual:
+	event := splunk.NewEvent(map[string]int{"a": 1})
+	actual, err := json.Marshal(event)
+	require.NoError(t, err)
+	assert.Equal(t, `{"event":{"a":1}}`, string(actual))
+
+	// and so are the fields of metrics:
+	metric := &splunk.Event{Event: "metric", Fields: map[string]interface{}{
+		"metric_name": "a.b.c",
+		"_value":      1.5,
+		"<tag>":       "value",
+	}}
+	metric.SetTime(time.Unix(100000, 0))
+	expected, err := json.Marshal((*reflectedEvent)(metric))
+	require.NoError(t, err)
+	actual, err = json.Marshal(metric)
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), string(actual))
+}
+
+func BenchmarkEventMarshalJSON(b *testing.B) {
+	event := &splunk.Event{Event: splunk.SerializedSSF{
+		TraceId:        "6",
+		Id:             "7",
+		ParentId:       "4",
+		StartTimestamp: 100000.001,
+		EndTimestamp:   100005.001,
+		Duration:       5000000000,
+		Service:        "test-srv",
+		Tags:           map[string]string{"farts": "mandatory", "purpose": "testing"},
+		Name:           "test-span",
+	}}
+	event.SetHost("test-host")
+	event.SetSourceType("test-srv")
+	event.SetTime(time.Unix(100000, 1000000))
+
+	b.Run("reflection", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			json.Marshal((*reflectedEvent)(event))
+		}
+	})
+	b.Run("hand-rolled", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			event.MarshalJSON()
+		}
+	})
+}
+
+// testCertificate returns a PEM-encoded certificate and key signed by
+// parent (or self-signed, if parent is nil).
+func testCertificate(t *testing.T, name string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), crand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(crand.Reader, template, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return cert, key,
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca, caKey, caPEM, _ := testCertificate(t, "test-ca", true, nil, nil)
+	_, _, serverCert, serverKey := testCertificate(t, "splunk", false, ca, caKey)
+	_, _, clientCert, clientKey := testCertificate(t, "veneur", false, ca, caKey)
+
+	serverPair, err := tls.X509KeyPair([]byte(serverCert), []byte(serverKey))
+	require.NoError(t, err)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+
+	ch := make(chan splunk.Event, 1)
+	ts := httptest.NewUnstartedServer(jsonEndpoint(t, ch))
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverPair},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	tlsConfig, err := splunk.NewTLSConfig(caPEM, clientCert, clientKey, "1.2")
+	require.NoError(t, err)
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:         []string{ts.URL},
+		Token:           "00000000-0000-0000-0000-000000000000",
+		Hostname:        "test-host",
+		Log:             logrus.StandardLogger(),
+		BatchSize:       1,
+		SpanSampleRate:  1,
+		MaxConnLifetime: 1 * time.Second,
+		TLSConfig:       tlsConfig,
+	})
+	require.NoError(t, err)
+	sink := gsink.(splunk.TestableSplunkSpanSink)
+	require.NoError(t, sink.Start(nil))
+	defer sink.Stop()
+
+	start := time.Now()
+	require.NoError(t, sink.Ingest(&ssf.SSFSpan{
+		Id:             1,
+		TraceId:        6,
+		StartTimestamp: start.UnixNano(),
+		EndTimestamp:   start.Add(time.Second).UnixNano(),
+		Service:        "test-srv",
+		Name:           "test-span",
+	}))
+	select {
+	case event := <-ch:
+		assert.Equal(t, "test-srv", *event.SourceType)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the HEC didn't receive the span over mutual TLS")
+	}
+}
+
+func TestWorkerConnections(t *testing.T) {
+	const workers = 2
+	var mtx sync.Mutex
+	protos := map[int]int{}
+	conns := map[string]bool{}
+	ch := make(chan splunk.Event, 8)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		protos[r.ProtoMajor]++
+		conns[r.RemoteAddr] = true
+		mtx.Unlock()
+		// every worker keeps a request open, which is canceled
+		// when the sink stops, so errors are expected here:
+		dec := json.NewDecoder(r.Body)
+		for {
+			var event splunk.Event
+			if err := dec.Decode(&event); err != nil {
+				break
+			}
+			ch <- event
+		}
+		w.Write([]byte(`{"text":"Success","code":0}`))
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:           []string{ts.URL},
+		Token:             "00000000-0000-0000-0000-000000000000",
+		Hostname:          "test-host",
+		Log:               logrus.StandardLogger(),
+		BatchSize:         1,
+		Workers:           workers,
+		SpanSampleRate:    1,
+		MaxConnLifetime:   10 * time.Second,
+		TLSConfig:         &tls.Config{RootCAs: roots},
+		KeepaliveInterval: 30 * time.Second,
+	})
+	require.NoError(t, err)
+	sink := gsink.(splunk.TestableSplunkSpanSink)
+	require.NoError(t, sink.Start(nil))
+	defer sink.Stop()
+
+	start := time.Now()
+	for i := 0; i < 8; i++ {
+		require.NoError(t, sink.Ingest(&ssf.SSFSpan{
+			Id:             int64(i + 1),
+			TraceId:        6,
+			StartTimestamp: start.UnixNano(),
+			EndTimestamp:   start.Add(time.Second).UnixNano(),
+			Service:        "test-srv",
+			Name:           "test-span",
+		}))
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the HEC didn't receive span %d", i+1)
+		}
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	assert.Len(t, protos, 1)
+	assert.True(t, protos[2] >= 8, "the batches should be submitted over HTTP/2, got %v", protos)
+	assert.True(t, len(conns) <= workers,
+		"every worker should submit its batches over a single connection, got %d connections", len(conns))
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	cfg, err := splunk.NewTLSConfig("", "", "", "")
+	require.NoError(t, err)
+	assert.Nil(t, cfg)
+
+	cfg, err = splunk.NewTLSConfig("", "", "", "1.3")
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), cfg.MinVersion)
+
+	_, err = splunk.NewTLSConfig("", "", "", "1.4")
+	assert.Error(t, err)
+	_, err = splunk.NewTLSConfig("not a certificate", "", "", "")
+	assert.Error(t, err)
+	_, _, cert, _ := testCertificate(t, "veneur", false, nil, nil)
+	_, err = splunk.NewTLSConfig("", cert, "", "")
+	assert.Error(t, err, "a client certificate needs a key")
+}
+
+// countingEndpoint counts the events in the requests it receives, and
+// responds to them with status.
+func countingEndpoint(status int, events *int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		j := json.NewDecoder(r.Body)
+		for {
+			input := splunk.Event{}
+			if err := j.Decode(&input); err != nil {
+				break
+			}
+			atomic.AddInt64(events, 1)
+		}
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			w.Write([]byte(`{"text":"Success","code":0}`))
+		} else {
+			w.Write([]byte(`{"text":"Server is busy","code":9}`))
+		}
+	})
+}
+
+// ingestSpans ingests n spans, and waits until the received counts add
+// up to n.
+func ingestSpans(t *testing.T, sink splunk.TestableSplunkSpanSink, n int, received ...*int64) {
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		require.NoError(t, sink.Ingest(&ssf.SSFSpan{
+			Id:             int64(i + 1),
+			TraceId:        6,
+			StartTimestamp: start.UnixNano(),
+			EndTimestamp:   start.Add(time.Second).UnixNano(),
+			Service:        "test-srv",
+			Name:           "test-span",
+		}))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var total int64
+		for _, count := range received {
+			total += atomic.LoadInt64(count)
+		}
+		if total >= int64(n) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d of %d spans were received", total, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLoadBalancing(t *testing.T) {
+	logger := logrus.StandardLogger()
+	var first, second int64
+	ts1 := httptest.NewServer(countingEndpoint(http.StatusOK, &first))
+	defer ts1.Close()
+	ts2 := httptest.NewServer(countingEndpoint(http.StatusOK, &second))
+	defer ts2.Close()
+
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:         []string{ts1.URL, ts2.URL},
+		Token:           "00000000-0000-0000-0000-000000000000",
+		Hostname:        "test-host",
+		Log:             logger,
+		BatchSize:       1,
+		SpanSampleRate:  1,
+		MaxConnLifetime: 10 * time.Second,
+	})
+	require.NoError(t, err)
+	sink := gsink.(splunk.TestableSplunkSpanSink)
+	require.NoError(t, sink.Start(nil))
+	defer sink.Stop()
+
+	ingestSpans(t, sink, 10, &first, &second)
+	assert.Equal(t, int64(5), atomic.LoadInt64(&first))
+	assert.Equal(t, int64(5), atomic.LoadInt64(&second))
+}
+
+func TestEndpointEjection(t *testing.T) {
+	logger := logrus.StandardLogger()
+	var up, down int64
+	tsUp := httptest.NewServer(countingEndpoint(http.StatusOK, &up))
+	defer tsUp.Close()
+	tsDown := httptest.NewServer(countingEndpoint(http.StatusServiceUnavailable, &down))
+	defer tsDown.Close()
+
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:         []string{tsUp.URL, tsDown.URL},
+		Token:           "00000000-0000-0000-0000-000000000000",
+		Hostname:        "test-host",
+		Log:             logger,
+		BatchSize:       1,
+		SpanSampleRate:  1,
+		MaxConnLifetime: 10 * time.Second,
+	})
+	require.NoError(t, err)
+	sink := gsink.(splunk.TestableSplunkSpanSink)
+	require.NoError(t, sink.Start(nil))
+	defer sink.Stop()
+
+	// Without ejection, the failing endpoint would get half of the
+	// spans:
+	ingestSpans(t, sink, 40, &up, &down)
+	assert.True(t, atomic.LoadInt64(&down) < 10,
+		"the failing endpoint should be ejected, but got %d spans", atomic.LoadInt64(&down))
+}
+
+func TestHealthCheckEjection(t *testing.T) {
+	logger := logrus.StandardLogger()
+	var events int64
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/services/collector/health" {
+			hecEndpoint(true).ServeHTTP(w, r)
+			return
+		}
+		countingEndpoint(http.StatusOK, &events).ServeHTTP(w, r)
+	}))
+	defer healthy.Close()
+	var unexpected int64
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/services/collector/health" {
+			hecEndpoint(false).ServeHTTP(w, r)
+			return
+		}
+		countingEndpoint(http.StatusOK, &unexpected).ServeHTTP(w, r)
+	}))
+	defer unhealthy.Close()
+
+	gsink, err := splunk.NewSplunkSpanSink(splunk.SpanSinkOptions{
+		Servers:         []string{unhealthy.URL, healthy.URL},
+		Token:           "00000000-0000-0000-0000-000000000000",
+		Hostname:        "test-host",
+		Log:             logger,
+		BatchSize:       1,
+		SpanSampleRate:  1,
+		MaxConnLifetime: 10 * time.Second,
+		HealthCheck:     true,
+	})
+	require.NoError(t, err)
+	sink := gsink.(splunk.TestableSplunkSpanSink)
+	require.NoError(t, sink.Start(nil), "one healthy endpoint should be enough to start")
+	defer sink.Stop()
+
+	ingestSpans(t, sink, 10, &events, &unexpected)
+	assert.Equal(t, int64(0), atomic.LoadInt64(&unexpected),
+		"the unhealthy endpoint should be out of the rotation")
+}
@@ -0,0 +1,96 @@
+package splunk
+
+import (
+	"strings"
+)
+
+// tagFilter decides which span tags are submitted to the HEC, so that
+// high-cardinality or sensitive tags, like user IDs or URLs carrying
+// tokens, never leave veneur for Splunk. Other sinks still get every
+// tag.
+type tagFilter struct {
+	// allow, if non-empty, matches the only tags that are submitted.
+	allow []tagPattern
+	// deny matches tags that are never submitted, even if they are
+	// allowed.
+	deny []tagPattern
+}
+
+// tagPattern matches a tag name exactly, or, if it ends in "*", every
+// tag name with the prefix before it.
+type tagPattern struct {
+	name   string
+	prefix bool
+}
+
+func (p tagPattern) match(name string) bool {
+	if p.prefix {
+		return strings.HasPrefix(name, p.name)
+	}
+	return name == p.name
+}
+
+func parseTagPatterns(patterns []string) []tagPattern {
+	parsed := make([]tagPattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "*") {
+			parsed = append(parsed, tagPattern{name: strings.TrimSuffix(pattern, "*"), prefix: true})
+			continue
+		}
+		parsed = append(parsed, tagPattern{name: pattern})
+	}
+	return parsed
+}
+
+// newTagFilter returns a filter that submits only the tags matching
+// allowlist, if it isn't empty, and none of those matching denylist. It
+// returns nil if both are empty, which submits every tag.
+func newTagFilter(allowlist, denylist []string) *tagFilter {
+	if len(allowlist) == 0 && len(denylist) == 0 {
+		return nil
+	}
+	return &tagFilter{
+		allow: parseTagPatterns(allowlist),
+		deny:  parseTagPatterns(denylist),
+	}
+}
+
+// keep returns whether a tag is submitted.
+func (f *tagFilter) keep(name string) bool {
+	for _, p := range f.deny {
+		if p.match(name) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, p := range f.allow {
+		if p.match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// filter returns the tags that are submitted, and how many were
+// stripped. The span's tags are shared with the other sinks, so they're
+// copied rather than modified if any are stripped.
+func (f *tagFilter) filter(tags map[string]string) (map[string]string, int) {
+	stripped := 0
+	for name := range tags {
+		if !f.keep(name) {
+			stripped++
+		}
+	}
+	if stripped == 0 {
+		return tags, 0
+	}
+	kept := make(map[string]string, len(tags)-stripped)
+	for name, value := range tags {
+		if f.keep(name) {
+			kept[name] = value
+		}
+	}
+	return kept, stripped
+}
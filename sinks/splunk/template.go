@@ -0,0 +1,79 @@
+package splunk
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/stripe/veneur/ssf"
+)
+
+// fieldTemplate renders the source or sourcetype of a span's event
+// from a template like "veneur:span:{service}". The placeholders are
+// {service}, {name} and {tag:name}, which is the value of the span's
+// tag, or "" if it doesn't have it. Everything else is copied as is.
+type fieldTemplate struct {
+	parts []templatePart
+}
+
+// templatePart is either literal text, or a span field or tag.
+type templatePart struct {
+	literal string
+	field   string
+	tag     string
+}
+
+// parseFieldTemplate parses a template. It returns nil for the empty
+// template.
+func parseFieldTemplate(template string) (*fieldTemplate, error) {
+	if template == "" {
+		return nil, nil
+	}
+	t := &fieldTemplate{}
+	rest := template
+	for rest != "" {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			t.parts = append(t.parts, templatePart{literal: rest})
+			break
+		}
+		if open > 0 {
+			t.parts = append(t.parts, templatePart{literal: rest[:open]})
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated placeholder in template %q", template)
+		}
+		placeholder := rest[open+1 : open+end]
+		switch {
+		case placeholder == "service" || placeholder == "name":
+			t.parts = append(t.parts, templatePart{field: placeholder})
+		case strings.HasPrefix(placeholder, "tag:") && len(placeholder) > len("tag:"):
+			t.parts = append(t.parts, templatePart{tag: strings.TrimPrefix(placeholder, "tag:")})
+		default:
+			return nil, fmt.Errorf("unknown placeholder {%s} in template %q; use {service}, {name} or {tag:name}", placeholder, template)
+		}
+		rest = rest[open+end+1:]
+	}
+	return t, nil
+}
+
+// render returns the template's text for a span.
+func (t *fieldTemplate) render(span *ssf.SSFSpan) string {
+	if len(t.parts) == 1 && t.parts[0].literal != "" {
+		return t.parts[0].literal
+	}
+	var b strings.Builder
+	for _, part := range t.parts {
+		switch {
+		case part.field == "service":
+			b.WriteString(span.Service)
+		case part.field == "name":
+			b.WriteString(span.Name)
+		case part.tag != "":
+			b.WriteString(span.Tags[part.tag])
+		default:
+			b.WriteString(part.literal)
+		}
+	}
+	return b.String()
+}
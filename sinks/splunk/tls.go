@@ -0,0 +1,57 @@
+package splunk
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// tlsVersions maps the names of the TLS versions that the HEC
+// connection can be required to use at least to their IDs. TLS 1.3
+// needs Go 1.12 or later, which building veneur requires anyway (see
+// the README).
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// NewTLSConfig returns the TLS configuration of the connection to the
+// HEC: authorityCert is a PEM bundle of the certificate authorities
+// that the HEC's certificate must be signed by, in place of the
+// system's; certificate and key are the PEM-encoded client certificate
+// and key to present to the HEC, for mutual TLS; and minVersion is the
+// lowest TLS version to accept, like "1.2". Empty arguments keep Go's
+// defaults, and if all are empty, NewTLSConfig returns nil.
+func NewTLSConfig(authorityCert, certificate, key, minVersion string) (*tls.Config, error) {
+	if authorityCert == "" && certificate == "" && key == "" && minVersion == "" {
+		return nil, nil
+	}
+	cfg := &tls.Config{}
+	if authorityCert != "" {
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM([]byte(authorityCert)) {
+			return nil, errors.New("splunk_hec_tls_authority_certificate: Could not load any certificates")
+		}
+	}
+	if certificate != "" || key != "" {
+		if certificate == "" || key == "" {
+			return nil, errors.New("splunk_hec_tls_certificate and splunk_hec_tls_key must be set together")
+		}
+		cert, err := tls.X509KeyPair([]byte(certificate), []byte(key))
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if minVersion != "" {
+		version, ok := tlsVersions[minVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version %q, must be 1.0, 1.1, 1.2 or 1.3", minVersion)
+		}
+		cfg.MinVersion = version
+	}
+	return cfg, nil
+}
@@ -0,0 +1,43 @@
+package splunk
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/sinks"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// watchTokenFile re-reads the token file every interval, and switches
+// to the tokens it holds whenever they change, until stop is closed.
+func (c *hecClient) watchTokenFile(file string, interval time.Duration, stop <-chan struct{}, log *logrus.Logger, cl *trace.Client) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			c.reloadToken(file, log, cl)
+		}
+	}
+}
+
+// reloadToken switches to the tokens in the token file if they
+// changed. If the file can't be read, the tokens in use stay in use.
+func (c *hecClient) reloadToken(file string, log *logrus.Logger, cl *trace.Client) {
+	token, secondaryToken, err := sinks.ReadCredentialsFile(file)
+	if err != nil {
+		log.WithError(err).WithField("file", file).
+			Warn("Could not reload Splunk HEC token, keeping the previous one")
+		metrics.ReportOne(cl, ssf.Count("splunk.hec_token_reload_errors_total", 1, nil,
+			ssf.Failure("splunk", ssf.CauseIOError)))
+		return
+	}
+	if c.tokens.Replace(token, secondaryToken) {
+		log.WithField("file", file).Info("Loaded new Splunk HEC token")
+		metrics.ReportOne(cl, ssf.Count("splunk.hec_token_reloads_total", 1, nil))
+	}
+}
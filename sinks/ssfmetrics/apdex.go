@@ -0,0 +1,145 @@
+package ssfmetrics
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/ssf"
+)
+
+// ApdexThreshold is the Apdex threshold T of the indicator spans of a
+// service's operation: spans that took T or less satisfy their users,
+// and spans that took up to 4T are tolerated. An empty or "*"
+// Operation matches every operation of the service, and a "*" Service
+// every service.
+type ApdexThreshold struct {
+	Service   string
+	Operation string
+	Threshold time.Duration
+}
+
+type apdexKey struct {
+	service, operation string
+}
+
+// apdexCounts are the indicator spans of an operation in an interval,
+// by how satisfied their users were.
+type apdexCounts struct {
+	satisfied, tolerating, frustrated int64
+}
+
+// apdex counts the indicator spans of operations by how satisfied
+// their users were in each interval, so that their Apdex score can be
+// computed from the counts over any period and any number of veneurs.
+type apdex struct {
+	metricName string
+	thresholds []ApdexThreshold
+
+	mtx    sync.Mutex
+	counts map[apdexKey]*apdexCounts
+}
+
+func newApdex(metricName string, thresholds []ApdexThreshold) (*apdex, error) {
+	if metricName == "" || len(thresholds) == 0 {
+		return nil, nil
+	}
+	for _, t := range thresholds {
+		if t.Service == "" {
+			return nil, fmt.Errorf("apdex threshold for operation %q has no service", t.Operation)
+		}
+		if t.Threshold <= 0 {
+			return nil, fmt.Errorf("apdex threshold of service %q must be positive, got %v", t.Service, t.Threshold)
+		}
+	}
+	return &apdex{
+		metricName: metricName,
+		thresholds: thresholds,
+		counts:     map[apdexKey]*apdexCounts{},
+	}, nil
+}
+
+// threshold returns the threshold of the first rule that matches the
+// span's service and operation.
+func (a *apdex) threshold(span *ssf.SSFSpan) (time.Duration, bool) {
+	for _, t := range a.thresholds {
+		if t.Service != "*" && t.Service != span.Service {
+			continue
+		}
+		if t.Operation != "" && t.Operation != "*" && t.Operation != span.Name {
+			continue
+		}
+		return t.Threshold, true
+	}
+	return 0, false
+}
+
+// record counts an indicator span towards its operation's score. Spans
+// that failed frustrate their users, whatever their duration.
+func (a *apdex) record(span *ssf.SSFSpan) {
+	if !span.Indicator {
+		return
+	}
+	threshold, ok := a.threshold(span)
+	if !ok {
+		return
+	}
+	duration := time.Duration(span.EndTimestamp - span.StartTimestamp)
+	key := apdexKey{service: span.Service, operation: span.Name}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	counts, ok := a.counts[key]
+	if !ok {
+		counts = &apdexCounts{}
+		a.counts[key] = counts
+	}
+	switch {
+	case span.Error || duration > 4*threshold:
+		counts.frustrated++
+	case duration > threshold:
+		counts.tolerating++
+	default:
+		counts.satisfied++
+	}
+}
+
+// flush returns counters of the satisfied, tolerating and total
+// indicator spans of every operation that had some in the interval,
+// named after the metric name with a suffix of .satisfied, .tolerating
+// and .total and tagged by service and operation, and starts the next
+// interval. The Apdex score is the satisfied spans plus half the
+// tolerating ones, over the total.
+func (a *apdex) flush() ([]samplers.UDPMetric, error) {
+	a.mtx.Lock()
+	counts := a.counts
+	a.counts = map[apdexKey]*apdexCounts{}
+	a.mtx.Unlock()
+
+	metrics := make([]samplers.UDPMetric, 0, 3*len(counts))
+	for key, c := range counts {
+		tags := map[string]string{
+			"service":   key.service,
+			"operation": key.operation,
+		}
+		for _, count := range []struct {
+			suffix string
+			value  int64
+		}{
+			{"satisfied", c.satisfied},
+			{"tolerating", c.tolerating},
+			{"total", c.satisfied + c.tolerating + c.frustrated},
+		} {
+			name := a.metricName + "." + count.suffix
+			counter := ssf.Count(name, float32(count.value), tags)
+			counter.Name = name // Ensure the name is free from any name prefixes, like "veneur."
+			metric, err := samplers.ParseMetricSSF(counter)
+			if err != nil {
+				return nil, err
+			}
+			metrics = append(metrics, metric)
+		}
+	}
+	return metrics, nil
+}
@@ -2,6 +2,7 @@
 package ssfmetrics
 
 import (
+	"context"
 	"sync/atomic"
 
 	"github.com/sirupsen/logrus"
@@ -16,7 +17,11 @@ import (
 // metricExtractionSink enqueues ssf spans or udp metrics for processing in the next pipeline iteration.
 type metricExtractionSink struct {
 	workers                []Processor
+	parser                 samplers.Parser
 	indicatorSpanTimerName string
+	durationTimerName      string
+	durationServices       map[string]struct{}
+	apdex                  *apdex
 	log                    *logrus.Logger
 	traceClient            *trace.Client
 	spansProcessed         int64
@@ -39,16 +44,53 @@ type DerivedMetricsSink interface {
 
 // NewMetricExtractionSink sets up and creates a span sink that
 // extracts metrics ("samples") from SSF spans and reports them to a
-// veneur's metrics workers.
-func NewMetricExtractionSink(mw []Processor, timerName string, cl *trace.Client, log *logrus.Logger) (DerivedMetricsSink, error) {
+// veneur's metrics workers. parser parses the metrics that spans
+// carry.
+//
+// If durationTimerName is set, the spans of the services listed in
+// durationServices (or of all services, if it contains "*") are
+// converted into timers of that name, tagged by service and
+// operation.
+//
+// If apdexMetricName is set, the indicator spans of every operation
+// that matches one of apdexThresholds are counted towards its Apdex
+// score, and reported on every flush as counters of that name with a
+// suffix of .satisfied, .tolerating and .total, tagged by service and
+// operation.
+func NewMetricExtractionSink(mw []Processor, parser samplers.Parser, timerName, durationTimerName string, durationServices []string, apdexMetricName string, apdexThresholds []ApdexThreshold, cl *trace.Client, log *logrus.Logger) (DerivedMetricsSink, error) {
+	services := make(map[string]struct{}, len(durationServices))
+	for _, service := range durationServices {
+		services[service] = struct{}{}
+	}
+	apdex, err := newApdex(apdexMetricName, apdexThresholds)
+	if err != nil {
+		return nil, err
+	}
 	return &metricExtractionSink{
 		workers:                mw,
+		parser:                 parser,
 		indicatorSpanTimerName: timerName,
+		durationTimerName:      durationTimerName,
+		durationServices:       services,
+		apdex:                  apdex,
 		traceClient:            cl,
 		log:                    log,
 	}, nil
 }
 
+// extractsDuration returns whether the sink should convert a span
+// of the service into a duration timer.
+func (m *metricExtractionSink) extractsDuration(service string) bool {
+	if m.durationTimerName == "" || service == "" {
+		return false
+	}
+	if _, ok := m.durationServices["*"]; ok {
+		return true
+	}
+	_, ok := m.durationServices[service]
+	return ok
+}
+
 // Name returns "metric_extraction".
 func (m *metricExtractionSink) Name() string {
 	return "metric_extraction"
@@ -67,7 +109,7 @@ func (m *metricExtractionSink) sendMetrics(metrics []samplers.UDPMetric) {
 }
 
 func (m *metricExtractionSink) SendSample(sample *ssf.SSFSample) error {
-	metric, err := samplers.ParseMetricSSF(sample)
+	metric, err := m.parser.ParseMetricSSF(sample)
 	if err != nil {
 		return err
 	}
@@ -83,7 +125,7 @@ func (m *metricExtractionSink) Ingest(span *ssf.SSFSpan) error {
 		atomic.AddInt64(&m.metricsGenerated, int64(metricsCount))
 		atomic.AddInt64(&m.spansProcessed, 1)
 	}()
-	metrics, err := samplers.ConvertMetrics(span)
+	metrics, err := m.parser.ConvertMetrics(span)
 	if err != nil {
 		if _, ok := err.(samplers.InvalidMetrics); ok {
 			m.log.WithError(err).
@@ -92,7 +134,7 @@ func (m *metricExtractionSink) Ingest(span *ssf.SSFSpan) error {
 				"packet_type": "ssf_metric",
 				"step":        "extract_metrics",
 				"reason":      "invalid_metrics",
-			}))
+			}, ssf.Failure(m.Name(), ssf.CauseParseError)))
 		} else {
 			m.log.WithError(err).Error("Unexpected error extracting metrics from SSF Message")
 			m.SendSample(ssf.Count("ssf.error_total", 1, map[string]string{
@@ -100,7 +142,7 @@ func (m *metricExtractionSink) Ingest(span *ssf.SSFSpan) error {
 				"step":        "extract_metrics",
 				"reason":      "unexpected_error",
 				"error":       err.Error(),
-			}))
+			}, ssf.Failure(m.Name(), ssf.CauseParseError)))
 			return err
 		}
 	}
@@ -131,11 +173,35 @@ func (m *metricExtractionSink) Ingest(span *ssf.SSFSpan) error {
 	}
 	metricsCount += len(spanMetrics)
 
-	m.sendMetrics(append(indicatorMetrics, spanMetrics...))
+	var durationMetrics []samplers.UDPMetric
+	if m.extractsDuration(span.Service) {
+		durationMetrics, err = samplers.ConvertSpanDurationMetrics(span, m.durationTimerName)
+		if err != nil {
+			m.log.WithError(err).
+				WithField("span_name", span.Name).
+				Warn("Couldn't extract duration metrics for span")
+			return err
+		}
+		metricsCount += len(durationMetrics)
+	}
+
+	if m.apdex != nil {
+		m.apdex.record(span)
+	}
+
+	m.sendMetrics(append(append(indicatorMetrics, spanMetrics...), durationMetrics...))
 	return nil
 }
 
-func (m *metricExtractionSink) Flush() {
+func (m *metricExtractionSink) Flush(context.Context) {
+	if m.apdex != nil {
+		counts, err := m.apdex.flush()
+		if err != nil {
+			m.log.WithError(err).Warn("Couldn't report Apdex counts")
+		}
+		m.sendMetrics(counts)
+	}
+
 	tags := map[string]string{"sink": m.Name()}
 	metrics.ReportBatch(m.traceClient, []*ssf.SSFSample{
 		ssf.Count(sinks.MetricKeyTotalSpansFlushed, float32(atomic.SwapInt64(&m.spansProcessed, 0)), tags),
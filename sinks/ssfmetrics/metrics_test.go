@@ -1,6 +1,8 @@
 package ssfmetrics_test
 
 import (
+	"context"
+	"strings"
 	"testing"
 	"time"
 
@@ -8,6 +10,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stripe/veneur"
+	"github.com/stripe/veneur/samplers"
 	"github.com/stripe/veneur/sinks"
 	"github.com/stripe/veneur/sinks/ssfmetrics"
 	"github.com/stripe/veneur/ssf"
@@ -17,7 +20,7 @@ func TestMetricExtractor(t *testing.T) {
 	logger := logrus.StandardLogger()
 	worker := veneur.NewWorker(0, nil, logger, nil)
 	workers := []ssfmetrics.Processor{worker}
-	sink, err := ssfmetrics.NewMetricExtractionSink(workers, "foo", nil, logger)
+	sink, err := ssfmetrics.NewMetricExtractionSink(workers, samplers.Parser{}, "foo", "", nil, "", nil, nil, logger)
 	require.NoError(t, err)
 
 	start := time.Now()
@@ -57,7 +60,7 @@ func setupBench() (*ssf.SSFSpan, sinks.SpanSink) {
 	logger := logrus.StandardLogger()
 	worker := veneur.NewWorker(0, nil, logger, nil)
 	workers := []ssfmetrics.Processor{worker}
-	sink, err := ssfmetrics.NewMetricExtractionSink(workers, "foo", nil, logger)
+	sink, err := ssfmetrics.NewMetricExtractionSink(workers, samplers.Parser{}, "foo", "", nil, "", nil, nil, logger)
 	if err != nil {
 		panic(err)
 	}
@@ -109,7 +112,7 @@ func TestIndicatorMetricExtractor(t *testing.T) {
 	logger := logrus.StandardLogger()
 	worker := veneur.NewWorker(0, nil, logger, nil)
 	workers := []ssfmetrics.Processor{worker}
-	sink, err := ssfmetrics.NewMetricExtractionSink(workers, "foo", nil, logger)
+	sink, err := ssfmetrics.NewMetricExtractionSink(workers, samplers.Parser{}, "foo", "", nil, "", nil, nil, logger)
 	require.NoError(t, err)
 
 	start := time.Now()
@@ -138,3 +141,120 @@ func TestIndicatorMetricExtractor(t *testing.T) {
 	close(worker.PacketChan)
 	assert.Equal(t, 1, <-done, "Should have sent the right number of metrics")
 }
+
+func TestSpanDurationMetricExtractor(t *testing.T) {
+	logger := logrus.StandardLogger()
+	worker := veneur.NewWorker(0, nil, logger, nil)
+	workers := []ssfmetrics.Processor{worker}
+	sink, err := ssfmetrics.NewMetricExtractionSink(workers, samplers.Parser{}, "", "span.duration_ns", []string{"checkout"}, "", nil, nil, logger)
+	require.NoError(t, err)
+
+	start := time.Now()
+	span := func(id int64, service string, duration time.Duration) *ssf.SSFSpan {
+		return &ssf.SSFSpan{
+			Id:             id,
+			TraceId:        id + 100,
+			Service:        service,
+			Name:           "pay",
+			StartTimestamp: start.UnixNano(),
+			EndTimestamp:   start.Add(duration).UnixNano(),
+		}
+	}
+	done := make(chan []samplers.UDPMetric)
+	go func() {
+		var timers []samplers.UDPMetric
+		for m := range worker.PacketChan {
+			if m.Name == "span.duration_ns" {
+				timers = append(timers, m)
+			}
+		}
+		done <- timers
+	}()
+	assert.NoError(t, sink.Ingest(span(1, "checkout", time.Second)))
+	assert.NoError(t, sink.Ingest(span(2, "search", time.Second)))
+	close(worker.PacketChan)
+
+	timers := <-done
+	require.Len(t, timers, 1, "only allowlisted services should get duration timers")
+	timer := timers[0]
+	assert.Equal(t, "histogram", timer.Type, "SSF timings become histograms")
+	assert.Equal(t, float64(time.Second), timer.Value)
+	assert.Contains(t, timer.Tags, "service:checkout")
+	assert.Contains(t, timer.Tags, "operation:pay")
+	assert.Contains(t, timer.Tags, "error:false")
+	require.NotNil(t, timer.Exemplar)
+	assert.Equal(t, samplers.Exemplar{TraceID: 101, SpanID: 1, Value: float64(time.Second)}, *timer.Exemplar)
+}
+
+func TestApdex(t *testing.T) {
+	logger := logrus.StandardLogger()
+	worker := veneur.NewWorker(0, nil, logger, nil)
+	workers := []ssfmetrics.Processor{worker}
+	sink, err := ssfmetrics.NewMetricExtractionSink(workers, samplers.Parser{}, "", "", nil, "apdex", []ssfmetrics.ApdexThreshold{
+		{Service: "checkout", Operation: "charge", Threshold: time.Second},
+		{Service: "checkout", Threshold: 100 * time.Millisecond},
+	}, nil, logger)
+	require.NoError(t, err)
+
+	start := time.Now()
+	id := int64(0)
+	span := func(name string, duration time.Duration, failed bool) *ssf.SSFSpan {
+		id++
+		return &ssf.SSFSpan{
+			Id:             id,
+			TraceId:        id,
+			Service:        "checkout",
+			Name:           name,
+			Indicator:      true,
+			Error:          failed,
+			StartTimestamp: start.UnixNano(),
+			EndTimestamp:   start.Add(duration).UnixNano(),
+		}
+	}
+	done := make(chan map[string]float64)
+	go func() {
+		counts := map[string]float64{}
+		for m := range worker.PacketChan {
+			if !strings.HasPrefix(m.Name, "apdex.") {
+				continue
+			}
+			assert.Equal(t, "counter", m.Type)
+			for _, tag := range m.Tags {
+				if strings.HasPrefix(tag, "operation:") {
+					counts[m.Name+"|"+tag] += m.Value.(float64)
+				}
+			}
+		}
+		done <- counts
+	}()
+	for _, s := range []*ssf.SSFSpan{
+		span("charge", 500*time.Millisecond, false), // satisfied
+		span("charge", 2*time.Second, false),        // tolerating
+		span("charge", 5*time.Second, false),        // frustrated
+		span("charge", 10*time.Millisecond, true),   // failed
+		span("refund", 50*time.Millisecond, false),  // satisfied
+	} {
+		require.NoError(t, sink.Ingest(s))
+	}
+	notIndicator := span("refund", 5*time.Second, false)
+	notIndicator.Indicator = false
+	require.NoError(t, sink.Ingest(notIndicator))
+	sink.Flush(context.Background())
+	// the scores start over every interval:
+	sink.Flush(context.Background())
+	close(worker.PacketChan)
+
+	assert.Equal(t, map[string]float64{
+		"apdex.satisfied|operation:charge":  1,
+		"apdex.tolerating|operation:charge": 1,
+		"apdex.total|operation:charge":      4,
+		"apdex.satisfied|operation:refund":  1,
+		"apdex.tolerating|operation:refund": 0,
+		"apdex.total|operation:refund":      1,
+	}, <-done)
+
+	_, err = ssfmetrics.NewMetricExtractionSink(workers, samplers.Parser{}, "", "", nil, "apdex", []ssfmetrics.ApdexThreshold{
+		{Service: "checkout"},
+	}, nil, logger)
+	assert.Error(t, err, "thresholds must be positive")
+}
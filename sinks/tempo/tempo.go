@@ -0,0 +1,167 @@
+package tempo
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	vhttp "github.com/stripe/veneur/http"
+	"github.com/stripe/veneur/protocol"
+	"github.com/stripe/veneur/sinks"
+	"github.com/stripe/veneur/spanconv"
+	"github.com/stripe/veneur/ssf"
+	"github.com/stripe/veneur/trace"
+	"github.com/stripe/veneur/trace/metrics"
+)
+
+// TenantHeader is the header that Tempo reads the tenant of a request
+// from, when it runs with multi-tenancy enabled.
+const TenantHeader = "X-Scope-OrgID"
+
+// tempoSpanBufferSize is the default maximum number of spans that the
+// sink holds between flushes.
+const tempoSpanBufferSize = 1 << 14
+
+// tracesPath is the path of the OTLP/HTTP endpoint for traces.
+const tracesPath = "/v1/traces"
+
+var _ sinks.SpanSink = &TempoSpanSink{}
+
+// TempoSpanSink sends spans to Grafana Tempo's OTLP/HTTP endpoint,
+// encoded as JSON. Spans are sent on every flush, in one request per
+// tenant.
+type TempoSpanSink struct {
+	HTTPClient     *http.Client
+	address        string
+	defaultTenant  string
+	serviceTenants map[string]string
+	bufferSize     int
+	traceClient    *trace.Client
+	log            *logrus.Logger
+
+	mutex   sync.Mutex
+	spans   []*ssf.SSFSpan
+	dropped int64
+}
+
+// NewTempoSpanSink creates a sink that sends spans to the Tempo
+// distributor at address. Spans of the services in serviceTenants are
+// sent with their tenant in the X-Scope-OrgID header, and all others
+// with defaultTenant, or with no tenant if it's empty.
+func NewTempoSpanSink(address string, defaultTenant string, serviceTenants map[string]string, bufferSize int, httpClient *http.Client, log *logrus.Logger) (*TempoSpanSink, error) {
+	if address == "" {
+		return nil, fmt.Errorf("Tempo sink needs an address")
+	}
+	if bufferSize <= 0 {
+		bufferSize = tempoSpanBufferSize
+	}
+	return &TempoSpanSink{
+		HTTPClient:     httpClient,
+		address:        strings.TrimRight(address, "/"),
+		defaultTenant:  defaultTenant,
+		serviceTenants: serviceTenants,
+		bufferSize:     bufferSize,
+		log:            log,
+	}, nil
+}
+
+// Name returns the name of this sink.
+func (t *TempoSpanSink) Name() string {
+	return "tempo"
+}
+
+// Start performs final adjustments on the sink.
+func (t *TempoSpanSink) Start(cl *trace.Client) error {
+	t.traceClient = cl
+	return nil
+}
+
+// Ingest buffers the span until the next flush. Spans that arrive
+// while the buffer is full are dropped.
+func (t *TempoSpanSink) Ingest(span *ssf.SSFSpan) error {
+	if err := protocol.ValidateTrace(span); err != nil {
+		return err
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if len(t.spans) >= t.bufferSize {
+		t.dropped++
+		return nil
+	}
+	t.spans = append(t.spans, span)
+	return nil
+}
+
+// tenant returns the tenant that the span is sent with.
+func (t *TempoSpanSink) tenant(span *ssf.SSFSpan) string {
+	if tenant, ok := t.serviceTenants[span.Service]; ok {
+		return tenant
+	}
+	return t.defaultTenant
+}
+
+// Flush sends the buffered spans to Tempo.
+func (t *TempoSpanSink) Flush(ctx context.Context) {
+	samples := &ssf.Samples{}
+	defer metrics.Report(t.traceClient, samples)
+
+	t.mutex.Lock()
+	spans := t.spans
+	dropped := t.dropped
+	t.spans = nil
+	t.dropped = 0
+	t.mutex.Unlock()
+
+	if dropped > 0 {
+		samples.Add(ssf.Count(sinks.MetricKeyTotalSpansDropped, float32(dropped), map[string]string{"sink": t.Name()},
+			ssf.Failure(t.Name(), ssf.CauseQueueFull)))
+	}
+	if len(spans) == 0 {
+		t.log.Debug("No spans to flush to Tempo, skipping.")
+		return
+	}
+
+	flushStart := time.Now()
+	byTenant := map[string][]*ssf.SSFSpan{}
+	for _, span := range spans {
+		tenant := t.tenant(span)
+		byTenant[tenant] = append(byTenant[tenant], span)
+	}
+	tenants := make([]string, 0, len(byTenant))
+	for tenant := range byTenant {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	for _, tenant := range tenants {
+		tenantSpans := byTenant[tenant]
+		client := t.HTTPClient
+		if tenant != "" {
+			client = vhttp.WithHeader(t.HTTPClient, TenantHeader, tenant)
+		}
+		err := vhttp.PostHelper(ctx, client, t.traceClient, http.MethodPost, t.address+tracesPath, spanconv.ToOTLP(tenantSpans), "flush_traces", false, map[string]string{"sink": t.Name()}, t.log)
+		if err != nil {
+			t.log.WithFields(logrus.Fields{
+				"spans":         len(tenantSpans),
+				"tenant":        tenant,
+				logrus.ErrorKey: err}).Warn("Error flushing spans to Tempo")
+			continue
+		}
+		serviceCount := map[string]int64{}
+		for _, span := range tenantSpans {
+			serviceCount[span.Service]++
+		}
+		for service, count := range serviceCount {
+			samples.Add(ssf.Count(sinks.MetricKeyTotalSpansFlushed, float32(count), map[string]string{"sink": t.Name(), "service": service}))
+		}
+		t.log.WithFields(logrus.Fields{
+			"spans":  len(tenantSpans),
+			"tenant": tenant,
+		}).Debug("Completed flushing spans to Tempo")
+	}
+	samples.Add(ssf.Timing(sinks.MetricKeySpanFlushDuration, time.Since(flushStart), time.Nanosecond, map[string]string{"sink": t.Name()}))
+}
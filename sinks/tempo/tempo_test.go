@@ -0,0 +1,131 @@
+package tempo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/internal/sinktest"
+	"github.com/stripe/veneur/spanconv"
+	"github.com/stripe/veneur/ssf"
+)
+
+func testSpan(id int64, service string) *ssf.SSFSpan {
+	start := time.Now()
+	return &ssf.SSFSpan{
+		Id:             id,
+		TraceId:        1,
+		ParentId:       -1,
+		StartTimestamp: start.UnixNano(),
+		EndTimestamp:   start.Add(time.Second).UnixNano(),
+		Name:           "test.span",
+		Service:        service,
+		Tags:           map[string]string{"foo": "bar"},
+	}
+}
+
+func TestTempoFlushTenants(t *testing.T) {
+	var mtx sync.Mutex
+	received := map[string]spanconv.OTLPExportRequest{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, tracesPath, r.URL.Path)
+		var req spanconv.OTLPExportRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		mtx.Lock()
+		received[r.Header.Get(TenantHeader)] = req
+		mtx.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	sink, err := NewTempoSpanSink(srv.URL, "default", map[string]string{"billing": "payments"}, 0, &http.Client{}, logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+
+	require.NoError(t, sink.Ingest(testSpan(1, "billing")))
+	require.NoError(t, sink.Ingest(testSpan(2, "search")))
+	require.NoError(t, sink.Ingest(testSpan(3, "search")))
+	sink.Flush(context.Background())
+
+	require.Len(t, received, 2)
+	payments := received["payments"]
+	require.Len(t, payments.ResourceSpans, 1)
+	assert.Equal(t, "billing", *payments.ResourceSpans[0].Resource.Attributes[0].Value.StringValue)
+	require.Len(t, payments.ResourceSpans[0].ScopeSpans[0].Spans, 1)
+
+	span := payments.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	assert.Equal(t, "00000000000000000000000000000001", span.TraceID)
+	assert.Equal(t, "0000000000000001", span.SpanID)
+	assert.Empty(t, span.ParentSpanID)
+	assert.Equal(t, "test.span", span.Name)
+	assert.Nil(t, span.Status)
+	require.Len(t, span.Attributes, 1)
+	assert.Equal(t, "foo", span.Attributes[0].Key)
+
+	def := received["default"]
+	require.Len(t, def.ResourceSpans, 1)
+	assert.Len(t, def.ResourceSpans[0].ScopeSpans[0].Spans, 2)
+
+	// Flushed spans aren't sent again:
+	received = map[string]spanconv.OTLPExportRequest{}
+	sink.Flush(context.Background())
+	assert.Empty(t, received)
+}
+
+func TestTempoNoTenant(t *testing.T) {
+	tenants := make(chan []string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenants <- r.Header[TenantHeader]
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	sink, err := NewTempoSpanSink(srv.URL+"/", "", nil, 0, &http.Client{}, logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+
+	span := testSpan(1, "search")
+	span.ParentId = 7
+	span.Error = true
+	require.NoError(t, sink.Ingest(span))
+	sink.Flush(context.Background())
+	assert.Empty(t, <-tenants)
+
+	converted := spanconv.ToOTLPSpan(span)
+	assert.Equal(t, "0000000000000007", converted.ParentSpanID)
+	require.NotNil(t, converted.Status)
+	assert.Equal(t, spanconv.OTLPStatusError, converted.Status.Code)
+}
+
+func TestTempoFlushDeadline(t *testing.T) {
+	srv, stop := sinktest.HangingServer()
+	defer stop()
+
+	sink, err := NewTempoSpanSink(srv.URL, "", nil, 0, &http.Client{}, logrus.New())
+	require.NoError(t, err)
+	require.NoError(t, sink.Start(nil))
+	require.NoError(t, sink.Ingest(testSpan(1, "search")))
+
+	sinktest.AssertFlushDeadline(t, func(ctx context.Context) {
+		sink.Flush(ctx)
+	})
+}
+
+func TestTempoBufferFull(t *testing.T) {
+	sink, err := NewTempoSpanSink("http://localhost", "", nil, 2, &http.Client{}, logrus.New())
+	require.NoError(t, err)
+	for i := int64(1); i <= 3; i++ {
+		require.NoError(t, sink.Ingest(testSpan(i, "search")))
+	}
+	assert.Len(t, sink.spans, 2)
+	assert.Equal(t, int64(1), sink.dropped)
+
+	assert.Error(t, sink.Ingest(&ssf.SSFSpan{}))
+}
@@ -0,0 +1,350 @@
+package veneur
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/segmentio/fasthash/fnv1a"
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/samplers"
+	"github.com/stripe/veneur/ssf"
+)
+
+// samplerSnapshot is the on-disk representation of a single sampler's
+// state. Value holds the same encoding that the sampler produces for
+// forwarding (see the samplers' Export methods), so restoring a
+// sampler is the same operation as importing it from another veneur.
+type samplerSnapshot struct {
+	samplers.MetricKey
+	Tags     []string
+	Scope    samplers.MetricScope
+	Value    []byte
+	Unit     string
+	Priority samplers.Priority
+
+	// Counters additionally carry the state behind their
+	// summaries, which isn't forwarded.
+	CounterSummary samplers.CounterSummary
+
+	// Histograms and timers additionally carry their exemplar:
+	Exemplar *samplers.Exemplar
+
+	// Histograms additionally carry the aggregates that were
+	// computed from locally-sampled values only; these are not
+	// part of the exported digest.
+	LocalWeight        float64
+	LocalMin           float64
+	LocalMax           float64
+	LocalSum           float64
+	LocalReciprocalSum float64
+}
+
+// workerSnapshot is the set of sampler snapshots taken from all
+// workers at one point in time.
+type workerSnapshot struct {
+	Taken    time.Time
+	Interval time.Duration
+	Samplers []samplerSnapshot
+}
+
+// snapshotExporter is implemented by every sampler that can be
+// snapshotted.
+type snapshotExporter interface {
+	Export() (samplers.JSONMetric, error)
+}
+
+func appendSamplerSnapshot(res []samplerSnapshot, mk samplers.MetricKey, exp snapshotExporter, scope samplers.MetricScope) []samplerSnapshot {
+	jm, err := exp.Export()
+	if err != nil {
+		log.WithError(err).WithFields(logrus.Fields{
+			"type": mk.Type,
+			"name": mk.Name,
+		}).Warn("Could not snapshot sampler")
+		return res
+	}
+	snap := samplerSnapshot{
+		MetricKey: mk,
+		Tags:      jm.Tags,
+		Scope:     scope,
+		Value:     jm.Value,
+		Unit:      jm.Unit,
+		Priority:  jm.Priority,
+		Exemplar:  jm.Exemplar,
+	}
+	if c, ok := exp.(*samplers.Counter); ok {
+		snap.CounterSummary = c.Summary()
+	}
+	if h, ok := exp.(*samplers.Histo); ok {
+		snap.LocalWeight = h.LocalWeight
+		snap.LocalMin = h.LocalMin
+		snap.LocalMax = h.LocalMax
+		snap.LocalSum = h.LocalSum
+		snap.LocalReciprocalSum = h.LocalReciprocalSum
+	}
+	return append(res, snap)
+}
+
+// Snapshot returns the state of all samplers held by the worker,
+// without resetting them. Status checks are not included, as they
+// are re-sent by clients on every check anyway.
+func (w *Worker) Snapshot() []samplerSnapshot {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.wm.samplerSnapshots()
+}
+
+// RestoreSnapshot merges a previously-taken sampler snapshot into
+// the worker's current state.
+func (w *Worker) RestoreSnapshot(snap samplerSnapshot) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.wm.restoreSamplerSnapshot(snap)
+}
+
+// samplerSnapshots returns snapshots of all samplers except status
+// checks.
+func (wm WorkerMetrics) samplerSnapshots() []samplerSnapshot {
+	var res []samplerSnapshot
+	for mk, c := range wm.counters {
+		res = appendSamplerSnapshot(res, mk, c, samplers.MixedScope)
+	}
+	for mk, c := range wm.globalCounters {
+		res = appendSamplerSnapshot(res, mk, c, samplers.GlobalOnly)
+	}
+	for mk, g := range wm.gauges {
+		res = appendSamplerSnapshot(res, mk, g, samplers.MixedScope)
+	}
+	for mk, g := range wm.globalGauges {
+		res = appendSamplerSnapshot(res, mk, g, samplers.GlobalOnly)
+	}
+	for mk, h := range wm.histograms {
+		res = appendSamplerSnapshot(res, mk, h, samplers.MixedScope)
+	}
+	for mk, h := range wm.globalHistograms {
+		res = appendSamplerSnapshot(res, mk, h, samplers.GlobalOnly)
+	}
+	for mk, h := range wm.localHistograms {
+		res = appendSamplerSnapshot(res, mk, h, samplers.LocalOnly)
+	}
+	for mk, t := range wm.timers {
+		res = appendSamplerSnapshot(res, mk, t, samplers.MixedScope)
+	}
+	for mk, t := range wm.globalTimers {
+		res = appendSamplerSnapshot(res, mk, t, samplers.GlobalOnly)
+	}
+	for mk, t := range wm.localTimers {
+		res = appendSamplerSnapshot(res, mk, t, samplers.LocalOnly)
+	}
+	for mk, s := range wm.sets {
+		res = appendSamplerSnapshot(res, mk, s, samplers.MixedScope)
+	}
+	for mk, s := range wm.localSets {
+		res = appendSamplerSnapshot(res, mk, s, samplers.LocalOnly)
+	}
+	return res
+}
+
+// restoreSamplerSnapshot merges a sampler snapshot into the
+// corresponding sampler, creating it if necessary.
+func (wm WorkerMetrics) restoreSamplerSnapshot(snap samplerSnapshot) error {
+	wm.Upsert(snap.MetricKey, snap.Scope, snap.Tags)
+	wm.setUnit(snap.MetricKey, snap.Scope, snap.Unit)
+	if snap.Priority != samplers.PriorityNormal {
+		wm.setPriority(snap.MetricKey, snap.Scope, snap.Priority)
+	}
+
+	var histo *samplers.Histo
+	switch snap.Type {
+	case counterTypeName:
+		counter := wm.counters[snap.MetricKey]
+		if snap.Scope == samplers.GlobalOnly {
+			counter = wm.globalCounters[snap.MetricKey]
+		}
+		if err := counter.Combine(snap.Value); err != nil {
+			return err
+		}
+		counter.AddSummary(snap.CounterSummary)
+		return nil
+	case gaugeTypeName:
+		if snap.Scope == samplers.GlobalOnly {
+			return wm.globalGauges[snap.MetricKey].Combine(snap.Value)
+		}
+		return wm.gauges[snap.MetricKey].Combine(snap.Value)
+	case setTypeName:
+		if snap.Scope == samplers.LocalOnly {
+			return wm.localSets[snap.MetricKey].Combine(snap.Value)
+		}
+		return wm.sets[snap.MetricKey].Combine(snap.Value)
+	case histogramTypeName:
+		switch snap.Scope {
+		case samplers.LocalOnly:
+			histo = wm.localHistograms[snap.MetricKey]
+		case samplers.GlobalOnly:
+			histo = wm.globalHistograms[snap.MetricKey]
+		default:
+			histo = wm.histograms[snap.MetricKey]
+		}
+	case timerTypeName:
+		switch snap.Scope {
+		case samplers.LocalOnly:
+			histo = wm.localTimers[snap.MetricKey]
+		case samplers.GlobalOnly:
+			histo = wm.globalTimers[snap.MetricKey]
+		default:
+			histo = wm.timers[snap.MetricKey]
+		}
+	default:
+		return fmt.Errorf("unknown metric type %q in snapshot", snap.Type)
+	}
+
+	if err := histo.Combine(snap.Value); err != nil {
+		return err
+	}
+	histo.LocalWeight += snap.LocalWeight
+	histo.LocalMin = minFloat(histo.LocalMin, snap.LocalMin)
+	histo.LocalMax = maxFloat(histo.LocalMax, snap.LocalMax)
+	histo.LocalSum += snap.LocalSum
+	histo.LocalReciprocalSum += snap.LocalReciprocalSum
+	if snap.Exemplar != nil {
+		histo.SampleExemplar(*snap.Exemplar)
+	}
+	return nil
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// writeSnapshot writes the state of all workers to the configured
+// snapshot path. The file is replaced atomically, so a crash during
+// a write leaves the previous snapshot intact.
+func (s *Server) writeSnapshot() error {
+	s.snapshotMtx.Lock()
+	defer s.snapshotMtx.Unlock()
+
+	snap := workerSnapshot{
+		Taken:    time.Now(),
+		Interval: s.interval,
+	}
+	for _, w := range s.Workers {
+		snap.Samplers = append(snap.Samplers, w.Snapshot()...)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.snapshotPath), filepath.Base(s.snapshotPath)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := gob.NewEncoder(tmp).Encode(&snap); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.snapshotPath)
+}
+
+// discardSnapshot removes the snapshot file. It must be called with
+// snapshotMtx held, right after the workers' state was flushed, so
+// that a restart never restores data that was already flushed.
+func (s *Server) discardSnapshot() {
+	err := os.Remove(s.snapshotPath)
+	if err != nil && !os.IsNotExist(err) {
+		log.WithError(err).WithField("path", s.snapshotPath).
+			Warn("Could not remove sampler snapshot")
+	}
+}
+
+// restoreSnapshot reads the snapshot file (if one exists) and merges
+// its contents into the workers. Snapshots older than one flush
+// interval are discarded, as their data belongs to an interval that
+// has already ended. The snapshot file is removed after it was read,
+// so that it can not be restored twice.
+func (s *Server) restoreSnapshot() error {
+	s.snapshotMtx.Lock()
+	defer s.snapshotMtx.Unlock()
+
+	f, err := os.Open(s.snapshotPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer s.discardSnapshot()
+	defer f.Close()
+
+	var snap workerSnapshot
+	if err := gob.NewDecoder(f).Decode(&snap); err != nil {
+		return err
+	}
+
+	entry := log.WithFields(logrus.Fields{
+		"path":     s.snapshotPath,
+		"taken":    snap.Taken,
+		"samplers": len(snap.Samplers),
+	})
+	if time.Since(snap.Taken) > s.interval || snap.Interval != s.interval {
+		entry.Info("Discarding stale sampler snapshot")
+		return nil
+	}
+
+	failed := 0
+	for _, sampler := range snap.Samplers {
+		// Route each sampler to the worker that would receive its
+		// samples, so that the restored state and new samples end
+		// up in the same place:
+		h := fnv1a.Init32
+		h = fnv1a.AddString32(h, sampler.Name)
+		h = fnv1a.AddString32(h, sampler.Type)
+		h = fnv1a.AddString32(h, sampler.JoinedTags)
+		w := s.Workers[h%uint32(len(s.Workers))]
+		if err := w.RestoreSnapshot(sampler); err != nil {
+			failed++
+			entry.WithError(err).WithField("name", sampler.Name).
+				Debug("Could not restore sampler")
+		}
+	}
+	s.Statsd.Count("snapshot.samplers_restored_total", int64(len(snap.Samplers)-failed), nil, 1.0)
+	s.Statsd.Count("snapshot.restore_errors_total", int64(failed), failureTags("snapshot", ssf.CauseParseError), 1.0)
+	entry.WithField("failed", failed).Info("Restored sampler snapshot")
+	return nil
+}
+
+// snapshotPeriodically writes a snapshot of the server's sampler
+// state at every snapshot interval until the server shuts down.
+func (s *Server) snapshotPeriodically() {
+	ticker := time.NewTicker(s.snapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.shutdown:
+			return
+		case <-ticker.C:
+			start := time.Now()
+			if err := s.writeSnapshot(); err != nil {
+				log.WithError(err).WithField("path", s.snapshotPath).
+					Warn("Could not write sampler snapshot")
+				s.Statsd.Count("snapshot.write_errors_total", 1, failureTags("snapshot", ssf.CauseIOError), 1.0)
+				continue
+			}
+			s.Statsd.Timing("snapshot.write_duration_ns", time.Since(start), nil, 1.0)
+		}
+	}
+}
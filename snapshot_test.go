@@ -0,0 +1,188 @@
+package veneur
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/samplers"
+)
+
+func snapshotTestServer(t *testing.T, dir string) *Server {
+	s := &Server{
+		interval:     10 * time.Second,
+		snapshotPath: filepath.Join(dir, "samplers.snapshot"),
+	}
+	for i := 0; i < 4; i++ {
+		s.Workers = append(s.Workers, NewWorker(i+1, nil, logrus.New(), nil))
+	}
+	return s
+}
+
+func snapshotTestMetrics(t *testing.T) []*samplers.UDPMetric {
+	packets := []string{
+		"a.b.c:1|c|#foo:bar",
+		"a.b.c:2|c|#foo:bar",
+		"a.b.d:5|c|#veneurglobalonly",
+		"a.gauge:3|g",
+		"a.histo:1|h",
+		"a.histo:100|h",
+		"a.timer:20|ms|#veneurlocalonly",
+		"a.set:hi|s",
+		"a.set:there|s",
+	}
+	var res []*samplers.UDPMetric
+	for _, p := range packets {
+		m, err := samplers.ParseMetric([]byte(p))
+		require.NoError(t, err)
+		res = append(res, m)
+	}
+	return res
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "veneur-snapshot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	orig := snapshotTestServer(t, dir)
+	for _, m := range snapshotTestMetrics(t) {
+		orig.Workers[m.Digest%uint32(len(orig.Workers))].ProcessMetric(m)
+	}
+	require.NoError(t, orig.writeSnapshot())
+
+	restored := snapshotTestServer(t, dir)
+	require.NoError(t, restored.restoreSnapshot())
+	_, err = os.Stat(restored.snapshotPath)
+	assert.True(t, os.IsNotExist(err), "snapshot should be removed after restoring")
+
+	for i := range orig.Workers {
+		expected := orig.Workers[i].Flush()
+		actual := restored.Workers[i].Flush()
+
+		assert.Len(t, actual.counters, len(expected.counters))
+		for k, c := range expected.counters {
+			require.Contains(t, actual.counters, k)
+			assert.Equal(t, c.Flush(time.Second)[0].Value, actual.counters[k].Flush(time.Second)[0].Value)
+		}
+		assert.Len(t, actual.globalCounters, len(expected.globalCounters))
+		assert.Len(t, actual.gauges, len(expected.gauges))
+		for k, g := range expected.gauges {
+			require.Contains(t, actual.gauges, k)
+			assert.Equal(t, g.Flush()[0].Value, actual.gauges[k].Flush()[0].Value)
+		}
+		assert.Len(t, actual.sets, len(expected.sets))
+		for k, s := range expected.sets {
+			require.Contains(t, actual.sets, k)
+			assert.Equal(t, s.Flush()[0].Value, actual.sets[k].Flush()[0].Value)
+		}
+		assert.Len(t, actual.histograms, len(expected.histograms))
+		for k, h := range expected.histograms {
+			require.Contains(t, actual.histograms, k)
+			ah := actual.histograms[k]
+			assert.Equal(t, h.LocalWeight, ah.LocalWeight)
+			assert.Equal(t, h.LocalMin, ah.LocalMin)
+			assert.Equal(t, h.LocalMax, ah.LocalMax)
+			assert.Equal(t, h.Value.Quantile(0.5), ah.Value.Quantile(0.5))
+		}
+		assert.Len(t, actual.localTimers, len(expected.localTimers))
+	}
+}
+
+func TestSnapshotRoundTripMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "veneur-snapshot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	metrics := snapshotTestMetrics(t)
+	for _, m := range metrics {
+		m.Unit = "byte"
+		m.Priority = samplers.PriorityHigh
+		if m.Type == "histogram" {
+			m.Exemplar = &samplers.Exemplar{TraceID: 1, SpanID: 2, Value: m.Value.(float64)}
+		}
+	}
+
+	orig := snapshotTestServer(t, dir)
+	for _, w := range orig.Workers {
+		w.setCounterSummaries(true)
+	}
+	for _, m := range metrics {
+		orig.Workers[m.Digest%uint32(len(orig.Workers))].ProcessMetric(m)
+	}
+	require.NoError(t, orig.writeSnapshot())
+
+	restored := snapshotTestServer(t, dir)
+	for _, w := range restored.Workers {
+		w.setCounterSummaries(true)
+	}
+	require.NoError(t, restored.restoreSnapshot())
+
+	for i := range orig.Workers {
+		expected := orig.Workers[i].Flush()
+		actual := restored.Workers[i].Flush()
+
+		for k, c := range expected.counters {
+			require.Contains(t, actual.counters, k)
+			ac := actual.counters[k]
+			assert.Equal(t, "byte", ac.Unit)
+			assert.Equal(t, samplers.PriorityHigh, ac.Priority)
+			assert.Equal(t, c.Summary(), ac.Summary())
+			expectedFlush, actualFlush := c.Flush(time.Second), ac.Flush(time.Second)
+			require.Len(t, actualFlush, len(expectedFlush))
+			for j := range expectedFlush {
+				assert.Equal(t, expectedFlush[j].Name, actualFlush[j].Name)
+				assert.Equal(t, expectedFlush[j].Value, actualFlush[j].Value)
+			}
+		}
+		for k, h := range expected.histograms {
+			require.Contains(t, actual.histograms, k)
+			ah := actual.histograms[k]
+			assert.Equal(t, "byte", ah.Unit)
+			assert.Equal(t, samplers.PriorityHigh, ah.Priority)
+			require.NotNil(t, ah.Exemplar)
+			assert.Equal(t, *h.Exemplar, *ah.Exemplar)
+		}
+	}
+}
+
+func TestSnapshotStale(t *testing.T) {
+	dir, err := ioutil.TempDir("", "veneur-snapshot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	orig := snapshotTestServer(t, dir)
+	for _, m := range snapshotTestMetrics(t) {
+		orig.Workers[m.Digest%uint32(len(orig.Workers))].ProcessMetric(m)
+	}
+	require.NoError(t, orig.writeSnapshot())
+
+	// A server with a different interval must not pick up the
+	// snapshot, as its data can't belong to the same interval:
+	restored := snapshotTestServer(t, dir)
+	restored.interval = time.Second
+	require.NoError(t, restored.restoreSnapshot())
+	for _, w := range restored.Workers {
+		assert.Len(t, w.Flush().counters, 0)
+	}
+}
+
+func TestSnapshotDiscardedOnFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "veneur-snapshot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s := snapshotTestServer(t, dir)
+	require.NoError(t, s.writeSnapshot())
+	_, err = os.Stat(s.snapshotPath)
+	require.NoError(t, err)
+
+	s.tallyMetrics(nil)
+	_, err = os.Stat(s.snapshotPath)
+	assert.True(t, os.IsNotExist(err), "flushing should remove the snapshot")
+}
@@ -0,0 +1,118 @@
+package veneur
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync/atomic"
+
+	"github.com/stripe/veneur/ssf"
+)
+
+// spanBlocklistRule drops the spans whose service matches Service,
+// whose name matches Name and that have all of Tags, ignoring the
+// fields that are left empty. Like metricBlocklistRule's, its fields
+// are shell-style glob patterns (see path.Match), and a tag pattern
+// without a colon matches the tag's key, whatever its value.
+type spanBlocklistRule struct {
+	Service string   `yaml:"service" json:"service,omitempty"`
+	Name    string   `yaml:"name" json:"name,omitempty"`
+	Tags    []string `yaml:"tags" json:"tags,omitempty"`
+
+	// drops counts the spans the rule dropped since the last
+	// report, updated atomically
+	drops int64
+}
+
+func (r *spanBlocklistRule) validate() error {
+	if r.Service == "" && r.Name == "" && len(r.Tags) == 0 {
+		return fmt.Errorf("span blocklist rules need a service, a name or tags")
+	}
+	for _, pattern := range append([]string{r.Service, r.Name}, r.Tags...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid span blocklist pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+func (r *spanBlocklistRule) dropped() *int64 {
+	return &r.drops
+}
+
+// String describes the rule in the tags of its counter.
+func (r *spanBlocklistRule) String() string {
+	var parts []string
+	if r.Service != "" {
+		parts = append(parts, "service="+r.Service)
+	}
+	if r.Name != "" {
+		parts = append(parts, "name="+r.Name)
+	}
+	if len(r.Tags) > 0 {
+		parts = append(parts, "tags="+strings.Join(r.Tags, ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+// matches returns whether the rule drops a span.
+func (r *spanBlocklistRule) matches(span *ssf.SSFSpan) bool {
+	if r.Service != "" {
+		if ok, _ := path.Match(r.Service, span.Service); !ok {
+			return false
+		}
+	}
+	if r.Name != "" {
+		if ok, _ := path.Match(r.Name, span.Name); !ok {
+			return false
+		}
+	}
+	for _, pattern := range r.Tags {
+		if !hasMatchingSpanTag(pattern, span.Tags) {
+			return false
+		}
+	}
+	return true
+}
+
+func hasMatchingSpanTag(pattern string, tags map[string]string) bool {
+	kv := strings.SplitN(pattern, ":", 2)
+	for k, v := range tags {
+		if ok, _ := path.Match(kv[0], k); !ok {
+			continue
+		}
+		if len(kv) == 1 {
+			return true
+		}
+		if ok, _ := path.Match(kv[1], v); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// spanBlocklist drops the spans that match any of its rules as soon as
+// they are received, before span sinks ingest them or metrics are
+// extracted from them. Like the metric blocklist, it's meant to cut
+// off a runaway tracer.
+type spanBlocklist struct {
+	*blocklist[*spanBlocklistRule]
+}
+
+// newSpanBlocklist returns a blocklist without rules that watches
+// file, if it's not empty.
+func newSpanBlocklist(file string) *spanBlocklist {
+	return &spanBlocklist{newBlocklist[*spanBlocklistRule]("span", "blocklist.spans_dropped_total", file)}
+}
+
+// blocks returns whether a span should be dropped, and counts it
+// against the rule that drops it.
+func (b *spanBlocklist) blocks(span *ssf.SSFSpan) bool {
+	for _, rule := range b.inEffect() {
+		if rule.matches(span) {
+			atomic.AddInt64(&rule.drops, 1)
+			return true
+		}
+	}
+	return false
+}
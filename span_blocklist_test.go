@@ -0,0 +1,93 @@
+package veneur
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/ssf"
+)
+
+const testSpanBlocklist = `
+rules:
+  - service: "checkout"
+    name: "redis.*"
+  - tags: ["debug:tr*"]
+  - service: "search"
+    tags: ["user_id"]
+`
+
+func TestParseSpanBlocklist(t *testing.T) {
+	rules, err := parseBlocklist[*spanBlocklistRule]([]byte(testSpanBlocklist))
+	require.NoError(t, err)
+	require.Len(t, rules, 3)
+	assert.Equal(t, "service=checkout name=redis.*", rules[0].String())
+	assert.Equal(t, "tags=debug:tr*", rules[1].String())
+
+	_, err = parseBlocklist[*spanBlocklistRule]([]byte(`rules: [{}]`))
+	assert.Error(t, err, "rules should need something to match")
+	_, err = parseBlocklist[*spanBlocklistRule]([]byte(`rules: [{service: "[", name: "a"}]`))
+	assert.Error(t, err, "patterns should be valid")
+}
+
+func TestSpanBlocklistBlocks(t *testing.T) {
+	rules, err := parseBlocklist[*spanBlocklistRule]([]byte(testSpanBlocklist))
+	require.NoError(t, err)
+	b := newSpanBlocklist("")
+	b.replace(rules)
+
+	tests := []struct {
+		service string
+		name    string
+		tags    map[string]string
+		blocked bool
+	}{
+		{"checkout", "redis.get", nil, true},
+		{"checkout", "http.request", nil, false},
+		{"payments", "redis.get", nil, false},
+		{"payments", "http.request", map[string]string{"debug": "true"}, true},
+		{"payments", "http.request", map[string]string{"debug": "false"}, false},
+		{"search", "query", map[string]string{"user_id": "1234"}, true},
+		{"search", "query", map[string]string{"team": "search"}, false},
+	}
+	for _, test := range tests {
+		span := &ssf.SSFSpan{Service: test.service, Name: test.name, Tags: test.tags}
+		assert.Equal(t, test.blocked, b.blocks(span), "%s %s %v", test.service, test.name, test.tags)
+	}
+
+	samples := b.report()
+	require.Len(t, samples, 3)
+	assert.Equal(t, "blocklist.spans_dropped_total", samples[0].Name)
+	assert.Equal(t, "service=checkout name=redis.*", samples[0].Tags["rule"])
+	assert.Empty(t, b.report(), "counts should be reset after reporting")
+}
+
+func TestServerDropsBlockedSpans(t *testing.T) {
+	config := localConfig()
+	config.SpanBlocklistEnabled = true
+	s := setupVeneurServer(t, config, nil, nil, nil)
+	defer s.Shutdown()
+	handler := s.Handler()
+
+	put := httptest.NewRecorder()
+	handler.ServeHTTP(put, httptest.NewRequest(http.MethodPut, "/blocklist/spans", strings.NewReader(testSpanBlocklist)))
+	require.Equal(t, http.StatusOK, put.Code)
+
+	get := httptest.NewRecorder()
+	handler.ServeHTTP(get, httptest.NewRequest(http.MethodGet, "/blocklist/spans", nil))
+	var file blocklistFile[*spanBlocklistRule]
+	require.NoError(t, json.NewDecoder(get.Body).Decode(&file))
+	assert.Len(t, file.Rules, 3)
+
+	// Replace the span channel so the server's own spans don't
+	// interfere:
+	s.SpanChan = make(chan *ssf.SSFSpan, 2)
+	s.handleSSF(&ssf.SSFSpan{Id: 1, TraceId: 1, Service: "checkout", Name: "redis.get"}, "packet")
+	s.handleSSF(&ssf.SSFSpan{Id: 2, TraceId: 1, Service: "checkout", Name: "http.request"}, "packet")
+	require.Len(t, s.SpanChan, 1)
+	assert.Equal(t, "http.request", (<-s.SpanChan).Name)
+}
@@ -0,0 +1,41 @@
+package veneur
+
+import (
+	"time"
+
+	"github.com/stripe/veneur/ssf"
+)
+
+// spanDedupShards is the number of separately locked parts that a
+// spanDeduper's spans are spread across, so that the goroutines reading
+// SSF don't all contend for one lock.
+const spanDedupShards = 16
+
+type spanKey struct {
+	traceID, id int64
+}
+
+// spanDeduper drops spans whose trace and span ID it has already seen
+// within a short window, like those that clients submit again after
+// reconnecting, so that sinks which bill per span don't count them
+// twice.
+type spanDeduper struct {
+	window time.Duration
+	shards [spanDedupShards]dedupGenerations[spanKey]
+}
+
+func newSpanDeduper(window time.Duration) *spanDeduper {
+	return &spanDeduper{window: window}
+}
+
+// duplicate returns whether a span with the same trace and span ID was
+// seen within the window, and remembers the span if it wasn't. Spans
+// without IDs, like those that only carry metrics, are never
+// duplicates.
+func (d *spanDeduper) duplicate(span *ssf.SSFSpan, now time.Time) bool {
+	if span.Id == 0 || span.TraceId == 0 {
+		return false
+	}
+	key := spanKey{traceID: span.TraceId, id: span.Id}
+	return d.shards[uint64(span.Id)%spanDedupShards].seen(key, now, d.window)
+}
@@ -0,0 +1,52 @@
+package veneur
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/veneur/ssf"
+)
+
+func TestSpanDeduper(t *testing.T) {
+	d := newSpanDeduper(10 * time.Second)
+	start := time.Now()
+	span := &ssf.SSFSpan{TraceId: 1, Id: 2}
+	other := &ssf.SSFSpan{TraceId: 1, Id: 3}
+
+	assert.False(t, d.duplicate(span, start))
+	assert.True(t, d.duplicate(span, start.Add(time.Second)))
+	assert.False(t, d.duplicate(other, start.Add(time.Second)))
+	assert.False(t, d.duplicate(&ssf.SSFSpan{TraceId: 2, Id: 2}, start.Add(time.Second)),
+		"spans of other traces with the same ID aren't duplicates")
+
+	// A span is remembered for at least the window, even across a
+	// rotation:
+	assert.True(t, d.duplicate(span, start.Add(15*time.Second)))
+	// and forgotten after two windows:
+	assert.False(t, d.duplicate(span, start.Add(40*time.Second)))
+	assert.True(t, d.duplicate(span, start.Add(41*time.Second)))
+
+	// Spans without IDs only carry metrics:
+	metricsOnly := &ssf.SSFSpan{Metrics: []*ssf.SSFSample{ssf.Count("a.b", 1, nil)}}
+	assert.False(t, d.duplicate(metricsOnly, start))
+	assert.False(t, d.duplicate(metricsOnly, start))
+}
+
+func TestHandleSSFDuplicate(t *testing.T) {
+	s := &Server{
+		SpanChan:    make(chan *ssf.SSFSpan, 10),
+		spanDeduper: newSpanDeduper(time.Minute),
+	}
+	span := &ssf.SSFSpan{TraceId: 1, Id: 2, Service: "search"}
+	s.handleSSF(span, "packet")
+	s.handleSSF(span, "packet")
+	assert.Len(t, s.SpanChan, 1)
+
+	value, ok := s.ssfInternalMetrics.Load("service:search,ssf_format:packet")
+	if assert.True(t, ok) {
+		metrics := value.(*ssfServiceSpanMetrics)
+		assert.Equal(t, int64(2), metrics.ssfSpansReceivedTotal)
+		assert.Equal(t, int64(1), metrics.ssfSpansDuplicateTotal)
+	}
+}
@@ -0,0 +1,100 @@
+package veneur
+
+import (
+	"sort"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/stripe/veneur/ssf"
+)
+
+// The span fields that spanLimits truncates.
+const (
+	spanFieldTags = iota
+	spanFieldTagValue
+	spanFieldName
+	spanFields
+)
+
+var spanFieldNames = [spanFields]string{"tags", "tag_value", "name"}
+
+const (
+	// truncationMarker ends span names and tag values that were
+	// truncated.
+	truncationMarker = "...(truncated)"
+
+	// truncatedTagsKey is the tag that replaces the tags dropped from
+	// spans with too many of them. Its value is the number of tags
+	// that were dropped.
+	truncatedTagsKey = "veneur.truncated_tags"
+)
+
+// spanLimits bounds the size of the spans that veneur ingests, so that
+// one service that puts a stack trace into a tag value, or thousands
+// of tags on a span, doesn't burden every sink downstream. Spans over
+// the limits are truncated, with a marker that says so, rather than
+// dropped.
+type spanLimits struct {
+	// maxTags, maxTagValueLength and maxNameLength bound the number
+	// of tags on a span, the length of each tag value and the length
+	// of the span name, in bytes; 0 means unbounded.
+	maxTags           int
+	maxTagValueLength int
+	maxNameLength     int
+}
+
+func newSpanLimits(maxTags, maxTagValueLength, maxNameLength int) *spanLimits {
+	return &spanLimits{
+		maxTags:           maxTags,
+		maxTagValueLength: maxTagValueLength,
+		maxNameLength:     maxNameLength,
+	}
+}
+
+// truncate truncates the span in place and returns which of its
+// fields were truncated.
+func (l *spanLimits) truncate(span *ssf.SSFSpan) (truncated [spanFields]bool) {
+	if l.maxNameLength > 0 && len(span.Name) > l.maxNameLength {
+		span.Name = truncateString(span.Name, l.maxNameLength)
+		truncated[spanFieldName] = true
+	}
+	if l.maxTags > 0 && len(span.Tags) > l.maxTags {
+		// Keep the first tags in key order, so the same tags
+		// survive on every span of a service:
+		keys := make([]string, 0, len(span.Tags))
+		for k := range span.Tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		kept := l.maxTags - 1
+		for _, k := range keys[kept:] {
+			delete(span.Tags, k)
+		}
+		span.Tags[truncatedTagsKey] = strconv.Itoa(len(keys) - kept)
+		truncated[spanFieldTags] = true
+	}
+	if l.maxTagValueLength > 0 {
+		for k, v := range span.Tags {
+			if len(v) > l.maxTagValueLength {
+				span.Tags[k] = truncateString(v, l.maxTagValueLength)
+				truncated[spanFieldTagValue] = true
+			}
+		}
+	}
+	return truncated
+}
+
+// truncateString shortens s to at most max bytes, ending in the
+// truncation marker if there's room for it, without splitting a
+// UTF-8 sequence.
+func truncateString(s string, max int) string {
+	marker := truncationMarker
+	if max <= len(marker) {
+		marker = ""
+	}
+	cut := max - len(marker)
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + marker
+}
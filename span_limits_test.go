@@ -0,0 +1,65 @@
+package veneur
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stripe/veneur/ssf"
+)
+
+func TestSpanLimitsTruncate(t *testing.T) {
+	l := newSpanLimits(3, 20, 16)
+	span := &ssf.SSFSpan{
+		Name: "a.rather.long.operation.name",
+		Tags: map[string]string{
+			"a":     "short",
+			"b":     strings.Repeat("x", 100),
+			"c":     "dropped",
+			"d":     "dropped",
+			"stack": "dropped",
+		},
+	}
+	truncated := l.truncate(span)
+	assert.Equal(t, [spanFields]bool{true, true, true}, truncated)
+
+	assert.Equal(t, "a."+truncationMarker, span.Name)
+	assert.Len(t, span.Name, 16)
+	assert.Equal(t, map[string]string{
+		"a":              "short",
+		"b":              "xxxxxx" + truncationMarker,
+		truncatedTagsKey: "3",
+	}, span.Tags)
+
+	small := &ssf.SSFSpan{Name: "op", Tags: map[string]string{"a": "b"}}
+	assert.Equal(t, [spanFields]bool{}, l.truncate(small))
+	assert.Equal(t, "op", small.Name)
+	assert.Equal(t, map[string]string{"a": "b"}, small.Tags)
+}
+
+func TestTruncateString(t *testing.T) {
+	assert.Equal(t, "abc", truncateString("abcdef", 3), "no room for the marker")
+	s := "ab" + strings.Repeat("\u00e9", 10)
+	got := truncateString(s, len(truncationMarker)+3)
+	assert.Equal(t, "ab"+truncationMarker, got, "UTF-8 sequences aren't split")
+}
+
+func TestHandleSSFTruncates(t *testing.T) {
+	s := &Server{
+		SpanChan:   make(chan *ssf.SSFSpan, 10),
+		spanLimits: newSpanLimits(0, 10, 0),
+	}
+	s.handleSSF(&ssf.SSFSpan{TraceId: 1, Id: 2, Service: "search",
+		Tags: map[string]string{"stack": strings.Repeat("frame\n", 100)}}, "packet")
+	s.handleSSF(&ssf.SSFSpan{TraceId: 1, Id: 3, Service: "search"}, "packet")
+	if assert.Len(t, s.SpanChan, 2) {
+		assert.Len(t, (<-s.SpanChan).Tags["stack"], 10)
+	}
+
+	value, ok := s.ssfInternalMetrics.Load("service:search,ssf_format:packet")
+	if assert.True(t, ok) {
+		metrics := value.(*ssfServiceSpanMetrics)
+		assert.Equal(t, int64(1), metrics.ssfSpansTruncated[spanFieldTagValue])
+		assert.Equal(t, int64(0), metrics.ssfSpansTruncated[spanFieldName])
+	}
+}
@@ -0,0 +1,242 @@
+package spanconv
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/stripe/veneur/ssf"
+)
+
+// JaegerErrorTag is the tag that marks Jaeger spans that failed.
+const JaegerErrorTag = "error"
+
+// The types of values of Jaeger tags.
+const (
+	JaegerTypeString = "string"
+	JaegerTypeBool   = "bool"
+)
+
+// The types of references from a Jaeger span to others: CHILD_OF
+// references its parent, and FOLLOWS_FROM the spans it's linked to.
+const (
+	JaegerRefChildOf     = "CHILD_OF"
+	JaegerRefFollowsFrom = "FOLLOWS_FROM"
+)
+
+// JaegerTrace is a trace in Jaeger's JSON model, as its query service
+// serves them and its UI imports them.
+type JaegerTrace struct {
+	TraceID   string                   `json:"traceID"`
+	Spans     []JaegerSpan             `json:"spans"`
+	Processes map[string]JaegerProcess `json:"processes"`
+}
+
+// JaegerSpan is a span in Jaeger's JSON model. Its IDs are hex-encoded,
+// and its start time and duration are in microseconds.
+type JaegerSpan struct {
+	TraceID       string            `json:"traceID"`
+	SpanID        string            `json:"spanID"`
+	OperationName string            `json:"operationName"`
+	References    []JaegerReference `json:"references"`
+	StartTime     int64             `json:"startTime"`
+	Duration      int64             `json:"duration"`
+	Tags          []JaegerKeyValue  `json:"tags"`
+	// ProcessID is the key of the span's process in the trace's
+	// processes.
+	ProcessID string `json:"processID,omitempty"`
+	// Process is the span's process, for spans that aren't part of a
+	// JaegerTrace.
+	Process *JaegerProcess `json:"process,omitempty"`
+}
+
+// JaegerReference is a reference from a Jaeger span to another.
+type JaegerReference struct {
+	RefType string `json:"refType"`
+	TraceID string `json:"traceID"`
+	SpanID  string `json:"spanID"`
+}
+
+// JaegerProcess is the service that recorded a Jaeger span.
+type JaegerProcess struct {
+	ServiceName string           `json:"serviceName"`
+	Tags        []JaegerKeyValue `json:"tags"`
+}
+
+// JaegerKeyValue is a Jaeger tag. Its value's type is named by Type.
+type JaegerKeyValue struct {
+	Key   string      `json:"key"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// String formats the tag's value as a string.
+func (kv JaegerKeyValue) String() string {
+	switch v := kv.Value.(type) {
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(kv.Value)
+}
+
+// jaegerTraceID encodes an SSF trace ID the way Jaeger does for 64-bit
+// trace IDs.
+func jaegerTraceID(id int64) string {
+	return FormatID(id)
+}
+
+// ToJaeger converts spans to Jaeger traces, in the order their trace
+// IDs first appear in. Each trace has a process per service, whose key
+// is "p" followed by a number.
+func ToJaeger(spans []*ssf.SSFSpan) []JaegerTrace {
+	var traces []JaegerTrace
+	byTraceID := map[int64]int{}
+	processIDs := map[int64]map[string]string{}
+	for _, span := range spans {
+		i, ok := byTraceID[span.TraceId]
+		if !ok {
+			i = len(traces)
+			byTraceID[span.TraceId] = i
+			processIDs[span.TraceId] = map[string]string{}
+			traces = append(traces, JaegerTrace{
+				TraceID:   jaegerTraceID(span.TraceId),
+				Processes: map[string]JaegerProcess{},
+			})
+		}
+		trace := &traces[i]
+		processID, ok := processIDs[span.TraceId][span.Service]
+		if !ok {
+			processID = "p" + strconv.Itoa(len(trace.Processes)+1)
+			processIDs[span.TraceId][span.Service] = processID
+			trace.Processes[processID] = JaegerProcess{ServiceName: span.Service, Tags: []JaegerKeyValue{}}
+		}
+		out := ToJaegerSpan(span)
+		out.ProcessID = processID
+		trace.Spans = append(trace.Spans, out)
+	}
+	return traces
+}
+
+// ToJaegerSpan converts a span to Jaeger, with its own process.
+func ToJaegerSpan(span *ssf.SSFSpan) JaegerSpan {
+	out := JaegerSpan{
+		TraceID:       jaegerTraceID(span.TraceId),
+		SpanID:        FormatID(span.Id),
+		OperationName: span.Name,
+		References:    []JaegerReference{},
+		StartTime:     span.StartTimestamp / int64(time.Microsecond),
+		Duration:      (span.EndTimestamp - span.StartTimestamp) / int64(time.Microsecond),
+		Tags:          make([]JaegerKeyValue, 0, len(span.Tags)),
+		Process:       &JaegerProcess{ServiceName: span.Service, Tags: []JaegerKeyValue{}},
+	}
+	if parent := parentID(span); parent != 0 {
+		out.References = append(out.References, JaegerReference{
+			RefType: JaegerRefChildOf,
+			TraceID: out.TraceID,
+			SpanID:  FormatID(parent),
+		})
+	}
+	for _, link := range span.Links {
+		out.References = append(out.References, JaegerReference{
+			RefType: JaegerRefFollowsFrom,
+			TraceID: jaegerTraceID(link.TraceId),
+			SpanID:  FormatID(link.SpanId),
+		})
+	}
+	for _, k := range sortedTags(span.Tags) {
+		out.Tags = append(out.Tags, JaegerKeyValue{Key: k, Type: JaegerTypeString, Value: span.Tags[k]})
+	}
+	if span.Indicator {
+		out.Tags = append(out.Tags, JaegerKeyValue{Key: IndicatorTag, Type: JaegerTypeBool, Value: true})
+	}
+	if span.Error {
+		out.Tags = append(out.Tags, JaegerKeyValue{Key: JaegerErrorTag, Type: JaegerTypeBool, Value: true})
+	}
+	return out
+}
+
+// FromJaeger converts the spans of a Jaeger trace to SSF.
+func FromJaeger(trace JaegerTrace) ([]*ssf.SSFSpan, error) {
+	spans := make([]*ssf.SSFSpan, 0, len(trace.Spans))
+	for _, s := range trace.Spans {
+		process := s.Process
+		if process == nil {
+			if p, ok := trace.Processes[s.ProcessID]; ok {
+				process = &p
+			}
+		}
+		span, err := FromJaegerSpan(s, process)
+		if err != nil {
+			return nil, err
+		}
+		spans = append(spans, span)
+	}
+	return spans, nil
+}
+
+// FromJaegerSpan converts a Jaeger span of a process to SSF. The span's
+// parent is the span of its first CHILD_OF reference, or of its first
+// reference if it has none, like Jaeger's UI shows it; its other
+// references become links.
+func FromJaegerSpan(s JaegerSpan, process *JaegerProcess) (*ssf.SSFSpan, error) {
+	span := &ssf.SSFSpan{
+		Name:           s.OperationName,
+		StartTimestamp: s.StartTime * int64(time.Microsecond),
+		EndTimestamp:   (s.StartTime + s.Duration) * int64(time.Microsecond),
+		Tags:           map[string]string{},
+	}
+	if process != nil {
+		span.Service = process.ServiceName
+	}
+	var err error
+	if span.TraceId, err = ParseID(s.TraceID); err != nil {
+		return nil, err
+	}
+	if span.Id, err = ParseID(s.SpanID); err != nil {
+		return nil, err
+	}
+	if len(s.References) > 0 {
+		parent := 0
+		for i, ref := range s.References {
+			if ref.RefType == JaegerRefChildOf {
+				parent = i
+				break
+			}
+		}
+		for i, ref := range s.References {
+			if i == parent {
+				if span.ParentId, err = ParseID(ref.SpanID); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			link := &ssf.SSFSpanLink{}
+			if link.TraceId, err = ParseID(ref.TraceID); err != nil {
+				return nil, err
+			}
+			if link.SpanId, err = ParseID(ref.SpanID); err != nil {
+				return nil, err
+			}
+			span.Links = append(span.Links, link)
+		}
+	}
+	for _, kv := range s.Tags {
+		switch {
+		case kv.Key == IndicatorTag && kv.Value == true:
+			span.Indicator = true
+		case kv.Key == JaegerErrorTag && kv.Value == true:
+			span.Error = true
+		default:
+			span.Tags[kv.Key] = kv.String()
+		}
+	}
+	return span, nil
+}
@@ -0,0 +1,248 @@
+package spanconv
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/stripe/veneur/ssf"
+)
+
+// OTLPStatusError is the OTLP status code of spans that failed.
+const OTLPStatusError = 2
+
+// OTLPScopeName is the name of the instrumentation scope that spans
+// converted from SSF are in.
+const OTLPScopeName = "veneur"
+
+// The types below are the parts of the OTLP/JSON encoding of
+// ExportTraceServiceRequest that SSF spans map to.
+
+// OTLPExportRequest is an OTLP trace export request.
+type OTLPExportRequest struct {
+	ResourceSpans []OTLPResourceSpans `json:"resourceSpans"`
+}
+
+// OTLPResourceSpans holds the spans of a resource, which is a service.
+type OTLPResourceSpans struct {
+	Resource   OTLPResource     `json:"resource"`
+	ScopeSpans []OTLPScopeSpans `json:"scopeSpans"`
+}
+
+// OTLPResource is the entity that produced spans.
+type OTLPResource struct {
+	Attributes []OTLPAttribute `json:"attributes"`
+}
+
+// OTLPScopeSpans holds the spans of an instrumentation scope.
+type OTLPScopeSpans struct {
+	Scope OTLPScope  `json:"scope"`
+	Spans []OTLPSpan `json:"spans"`
+}
+
+// OTLPScope is an instrumentation scope.
+type OTLPScope struct {
+	Name string `json:"name"`
+}
+
+// OTLPSpan is an OTLP span. Its IDs are hex-encoded, and its
+// timestamps are nanoseconds since the Unix epoch, encoded as strings.
+type OTLPSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []OTLPAttribute `json:"attributes,omitempty"`
+	Links             []OTLPLink      `json:"links,omitempty"`
+	Status            *OTLPStatus     `json:"status,omitempty"`
+}
+
+// OTLPLink is a link from an OTLP span to another span, which may be
+// in another trace.
+type OTLPLink struct {
+	TraceID    string          `json:"traceId"`
+	SpanID     string          `json:"spanId"`
+	Attributes []OTLPAttribute `json:"attributes,omitempty"`
+}
+
+// OTLPStatus is the status of an OTLP span.
+type OTLPStatus struct {
+	Code int `json:"code"`
+}
+
+// OTLPAttribute is a key and its value.
+type OTLPAttribute struct {
+	Key   string    `json:"key"`
+	Value OTLPValue `json:"value"`
+}
+
+// OTLPValue is the value of an attribute. Exactly one of its fields is
+// set. OTLP/JSON encodes 64-bit integers as strings.
+type OTLPValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// String formats the value as a string.
+func (v OTLPValue) String() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	case v.IntValue != nil:
+		return *v.IntValue
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(*v.DoubleValue, 'g', -1, 64)
+	}
+	return ""
+}
+
+// StringAttribute returns an attribute with a string value.
+func StringAttribute(key, value string) OTLPAttribute {
+	return OTLPAttribute{Key: key, Value: OTLPValue{StringValue: &value}}
+}
+
+// BoolAttribute returns an attribute with a boolean value.
+func BoolAttribute(key string, value bool) OTLPAttribute {
+	return OTLPAttribute{Key: key, Value: OTLPValue{BoolValue: &value}}
+}
+
+// ToOTLP converts spans into an OTLP export request, with the spans of
+// each service under a resource of their own, in the order the services
+// first appear in.
+func ToOTLP(spans []*ssf.SSFSpan) *OTLPExportRequest {
+	byService := map[string][]OTLPSpan{}
+	var services []string
+	for _, span := range spans {
+		if _, ok := byService[span.Service]; !ok {
+			services = append(services, span.Service)
+		}
+		byService[span.Service] = append(byService[span.Service], ToOTLPSpan(span))
+	}
+
+	req := &OTLPExportRequest{}
+	for _, service := range services {
+		req.ResourceSpans = append(req.ResourceSpans, OTLPResourceSpans{
+			Resource: OTLPResource{
+				Attributes: []OTLPAttribute{StringAttribute("service.name", service)},
+			},
+			ScopeSpans: []OTLPScopeSpans{{
+				Scope: OTLPScope{Name: OTLPScopeName},
+				Spans: byService[service],
+			}},
+		})
+	}
+	return req
+}
+
+// ToOTLPSpan converts a span to OTLP. Its service is an attribute of
+// the resource it's under, so it's left out.
+func ToOTLPSpan(span *ssf.SSFSpan) OTLPSpan {
+	out := OTLPSpan{
+		TraceID:           FormatTraceID(span.TraceId),
+		SpanID:            FormatID(span.Id),
+		Name:              span.Name,
+		StartTimeUnixNano: strconv.FormatInt(span.StartTimestamp, 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.EndTimestamp, 10),
+	}
+	if parent := parentID(span); parent != 0 {
+		out.ParentSpanID = FormatID(parent)
+	}
+	for _, k := range sortedTags(span.Tags) {
+		out.Attributes = append(out.Attributes, StringAttribute(k, span.Tags[k]))
+	}
+	if span.Indicator {
+		out.Attributes = append(out.Attributes, BoolAttribute(IndicatorTag, true))
+	}
+	for _, link := range span.Links {
+		l := OTLPLink{
+			TraceID: FormatTraceID(link.TraceId),
+			SpanID:  FormatID(link.SpanId),
+		}
+		for _, k := range sortedTags(link.Attributes) {
+			l.Attributes = append(l.Attributes, StringAttribute(k, link.Attributes[k]))
+		}
+		out.Links = append(out.Links, l)
+	}
+	if span.Error {
+		out.Status = &OTLPStatus{Code: OTLPStatusError}
+	}
+	return out
+}
+
+// FromOTLP converts the spans in an OTLP export request to SSF.
+func FromOTLP(req *OTLPExportRequest) ([]*ssf.SSFSpan, error) {
+	var spans []*ssf.SSFSpan
+	for _, rs := range req.ResourceSpans {
+		service := ""
+		for _, attr := range rs.Resource.Attributes {
+			if attr.Key == "service.name" {
+				service = attr.Value.String()
+			}
+		}
+		for _, ss := range rs.ScopeSpans {
+			for _, s := range ss.Spans {
+				span, err := FromOTLPSpan(s, service)
+				if err != nil {
+					return nil, err
+				}
+				spans = append(spans, span)
+			}
+		}
+	}
+	return spans, nil
+}
+
+// FromOTLPSpan converts an OTLP span of a service to SSF.
+func FromOTLPSpan(s OTLPSpan, service string) (*ssf.SSFSpan, error) {
+	span := &ssf.SSFSpan{
+		Name:    s.Name,
+		Service: service,
+		Tags:    map[string]string{},
+	}
+	var err error
+	if span.TraceId, err = ParseID(s.TraceID); err != nil {
+		return nil, err
+	}
+	if span.Id, err = ParseID(s.SpanID); err != nil {
+		return nil, err
+	}
+	if span.ParentId, err = ParseID(s.ParentSpanID); err != nil {
+		return nil, err
+	}
+	if span.StartTimestamp, err = strconv.ParseInt(s.StartTimeUnixNano, 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid start time of span %q: %v", s.SpanID, err)
+	}
+	if span.EndTimestamp, err = strconv.ParseInt(s.EndTimeUnixNano, 10, 64); err != nil {
+		return nil, fmt.Errorf("invalid end time of span %q: %v", s.SpanID, err)
+	}
+	for _, attr := range s.Attributes {
+		if attr.Key == IndicatorTag && attr.Value.BoolValue != nil {
+			span.Indicator = *attr.Value.BoolValue
+			continue
+		}
+		span.Tags[attr.Key] = attr.Value.String()
+	}
+	for _, l := range s.Links {
+		link := &ssf.SSFSpanLink{}
+		if link.TraceId, err = ParseID(l.TraceID); err != nil {
+			return nil, err
+		}
+		if link.SpanId, err = ParseID(l.SpanID); err != nil {
+			return nil, err
+		}
+		if len(l.Attributes) > 0 {
+			link.Attributes = make(map[string]string, len(l.Attributes))
+			for _, attr := range l.Attributes {
+				link.Attributes[attr.Key] = attr.Value.String()
+			}
+		}
+		span.Links = append(span.Links, link)
+	}
+	span.Error = s.Status != nil && s.Status.Code == OTLPStatusError
+	return span, nil
+}
@@ -0,0 +1,97 @@
+// Package spanconv converts SSF spans to and from the span models of
+// other tracing systems: OpenTelemetry's OTLP, Zipkin's v2 API and
+// Jaeger's JSON model. Each model is represented by types that encode
+// to and decode from its JSON wire format with encoding/json, so that
+// spans can be sent to those systems, and spans archived from them can
+// be processed like SSF.
+//
+// The conversions map each SSF field to the closest field of the other
+// model, and the other way around:
+//
+//   - SSF's 64-bit IDs become the low 64 bits of 128-bit trace IDs, and
+//     only the low 64 bits of a 128-bit trace ID are kept when
+//     converting it to SSF. A parent ID of 0 (or -1, which some SSF
+//     clients use) means the span is a root span.
+//   - The service becomes the service name of the span's resource,
+//     endpoint or process.
+//   - Tags become attributes or tags. Tags of other types than strings
+//     are formatted as strings when converting to SSF.
+//   - A span with the error flag gets the model's error status or tag,
+//     and an indicator span gets a boolean "indicator" attribute or
+//     tag.
+//   - Links become OTLP span links, and Jaeger FOLLOWS_FROM references,
+//     which lose the links' attributes. A Jaeger span without a CHILD_OF
+//     reference has its first reference as its parent, so a root span's
+//     first link becomes its parent when converting it back to SSF.
+//
+// Zipkin and Jaeger measure time in microseconds, so converting SSF
+// spans to them truncates their timestamps to microseconds. Zipkin has
+// no links, so they aren't converted to it. SSF samples that are
+// attached to spans aren't converted.
+package spanconv
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/stripe/veneur/ssf"
+)
+
+// IndicatorTag is the attribute or tag that marks indicator spans in
+// the other models.
+const IndicatorTag = "indicator"
+
+// FormatID encodes an SSF span ID as 16 hexadecimal digits.
+func FormatID(id int64) string {
+	return fmt.Sprintf("%016x", uint64(id))
+}
+
+// FormatTraceID encodes an SSF trace ID as a 128-bit trace ID of 32
+// hexadecimal digits, whose high bits are zero.
+func FormatTraceID(id int64) string {
+	return fmt.Sprintf("%032x", uint64(id))
+}
+
+// ParseID decodes a span or trace ID of up to 32 hexadecimal digits.
+// Only the low 64 bits of longer IDs are kept. The empty string
+// decodes to 0.
+func ParseID(id string) (int64, error) {
+	if id == "" {
+		return 0, nil
+	}
+	if len(id) > 32 {
+		return 0, fmt.Errorf("ID %q is longer than 128 bits", id)
+	}
+	low := id
+	if len(low) > 16 {
+		low = id[len(id)-16:]
+		if _, err := strconv.ParseUint(id[:len(id)-16], 16, 64); err != nil {
+			return 0, fmt.Errorf("invalid ID %q: %v", id, err)
+		}
+	}
+	n, err := strconv.ParseUint(low, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ID %q: %v", id, err)
+	}
+	return int64(n), nil
+}
+
+// parentID returns the ID of a span's parent, or 0 for root spans.
+func parentID(span *ssf.SSFSpan) int64 {
+	if span.ParentId > 0 {
+		return span.ParentId
+	}
+	return 0
+}
+
+// sortedTags returns the keys of a span's tags in order, so that the
+// conversions are deterministic.
+func sortedTags(tags map[string]string) []string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,241 @@
+package spanconv
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/ssf"
+)
+
+func testSpans() []*ssf.SSFSpan {
+	start := time.Unix(1500000000, 123456000)
+	return []*ssf.SSFSpan{
+		{
+			TraceId:        1,
+			Id:             1,
+			ParentId:       0,
+			StartTimestamp: start.UnixNano(),
+			EndTimestamp:   start.Add(time.Second).UnixNano(),
+			Service:        "api",
+			Name:           "request",
+			Indicator:      true,
+			Tags:           map[string]string{"route": "/users"},
+		},
+		{
+			TraceId:        1,
+			Id:             2,
+			ParentId:       1,
+			StartTimestamp: start.Add(time.Millisecond).UnixNano(),
+			EndTimestamp:   start.Add(500 * time.Millisecond).UnixNano(),
+			Service:        "db",
+			Name:           "query",
+			Error:          true,
+			Tags:           map[string]string{},
+		},
+	}
+}
+
+// linkedSpan returns a span that processes a batch, linked to the
+// spans that produced it.
+func linkedSpan() *ssf.SSFSpan {
+	span := testSpans()[1]
+	span.Links = []*ssf.SSFSpanLink{
+		{TraceId: 7, SpanId: 8, Attributes: map[string]string{"queue": "payments"}},
+		{TraceId: 9, SpanId: 10},
+	}
+	return span
+}
+
+func TestParseID(t *testing.T) {
+	tests := []struct {
+		id       string
+		expected int64
+		err      bool
+	}{
+		{"", 0, false},
+		{"0000000000000007", 7, false},
+		{"7", 7, false},
+		{"ffffffffffffffff", -1, false},
+		{"00000000000000010000000000000007", 7, false},
+		{"000000000000000000000000000000000", 0, true},
+		{"xyz", 0, true},
+		{"zz000000000000000000000000000007", 0, true},
+	}
+	for _, test := range tests {
+		id, err := ParseID(test.id)
+		if test.err {
+			assert.Error(t, err, test.id)
+			continue
+		}
+		assert.NoError(t, err, test.id)
+		assert.Equal(t, test.expected, id, test.id)
+	}
+	assert.Equal(t, "00000000000000000000000000000007", FormatTraceID(7))
+	assert.Equal(t, "ffffffffffffffff", FormatID(-1))
+}
+
+func TestOTLPRoundTrip(t *testing.T) {
+	spans := testSpans()
+	req := ToOTLP(spans)
+	require.Len(t, req.ResourceSpans, 2, "each service should have a resource")
+	root := req.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	assert.Equal(t, "00000000000000000000000000000001", root.TraceID)
+	assert.Empty(t, root.ParentSpanID)
+	assert.Nil(t, root.Status)
+
+	encoded, err := json.Marshal(req)
+	require.NoError(t, err)
+	var decoded OTLPExportRequest
+	require.NoError(t, json.Unmarshal(encoded, &decoded))
+	converted, err := FromOTLP(&decoded)
+	require.NoError(t, err)
+	assert.Equal(t, spans, converted)
+}
+
+func TestOTLPLinks(t *testing.T) {
+	span := linkedSpan()
+	s := ToOTLPSpan(span)
+	require.Len(t, s.Links, 2)
+	assert.Equal(t, "00000000000000000000000000000007", s.Links[0].TraceID)
+	assert.Equal(t, "0000000000000008", s.Links[0].SpanID)
+	assert.Equal(t, []OTLPAttribute{StringAttribute("queue", "payments")}, s.Links[0].Attributes)
+	assert.Empty(t, s.Links[1].Attributes)
+
+	encoded, err := json.Marshal(s)
+	require.NoError(t, err)
+	var decoded OTLPSpan
+	require.NoError(t, json.Unmarshal(encoded, &decoded))
+	converted, err := FromOTLPSpan(decoded, span.Service)
+	require.NoError(t, err)
+	assert.Equal(t, span, converted)
+}
+
+func TestFromOTLP(t *testing.T) {
+	// as an OpenTelemetry SDK exports it:
+	payload := `{"resourceSpans":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"checkout"}}]},
+		"scopeSpans":[{"scope":{"name":"otel"},"spans":[{
+			"traceId":"5b8efff798038103d269b633813fc60c","spanId":"eee19b7ec3c1b174","parentSpanId":"eee19b7ec3c1b173",
+			"name":"charge","startTimeUnixNano":"1544712660000000000","endTimeUnixNano":"1544712661000000000",
+			"attributes":[{"key":"attempt","value":{"intValue":"3"}},{"key":"amount","value":{"doubleValue":1.5}},{"key":"retry","value":{"boolValue":true}}],
+			"status":{"code":2}}]}]}]}`
+	var req OTLPExportRequest
+	require.NoError(t, json.Unmarshal([]byte(payload), &req))
+	spans, err := FromOTLP(&req)
+	require.NoError(t, err)
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, int64(-3284894120862038516), span.TraceId, "only the low 64 bits of the trace ID should be kept")
+	assert.Equal(t, int64(-1233533854170369676), span.Id)
+	assert.Equal(t, int64(-1233533854170369677), span.ParentId)
+	assert.Equal(t, "checkout", span.Service)
+	assert.Equal(t, "charge", span.Name)
+	assert.Equal(t, time.Second.Nanoseconds(), span.EndTimestamp-span.StartTimestamp)
+	assert.True(t, span.Error)
+	assert.Equal(t, map[string]string{"attempt": "3", "amount": "1.5", "retry": "true"}, span.Tags)
+
+	req.ResourceSpans[0].ScopeSpans[0].Spans[0].StartTimeUnixNano = "yesterday"
+	_, err = FromOTLP(&req)
+	assert.Error(t, err)
+}
+
+func TestZipkinRoundTrip(t *testing.T) {
+	for _, span := range testSpans() {
+		z := ToZipkin(span)
+		encoded, err := json.Marshal(z)
+		require.NoError(t, err)
+		var decoded ZipkinSpan
+		require.NoError(t, json.Unmarshal(encoded, &decoded))
+		converted, err := FromZipkin(decoded)
+		require.NoError(t, err)
+		assert.Equal(t, span, converted)
+	}
+}
+
+func TestFromZipkin(t *testing.T) {
+	payload := `{"traceId":"463ac35c9f6413ad","id":"a2fb4a1d1a96d312","parentId":"72485a3953bb6124",
+		"name":"get /api","timestamp":1461750491274000,"duration":207000,"kind":"SERVER",
+		"localEndpoint":{"serviceName":"frontend","ipv4":"10.0.0.1"},
+		"tags":{"http.path":"/api","error":"connection refused"}}`
+	var z ZipkinSpan
+	require.NoError(t, json.Unmarshal([]byte(payload), &z))
+	span, err := FromZipkin(z)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0x463ac35c9f6413ad), span.TraceId)
+	assert.Equal(t, int64(0x72485a3953bb6124), span.ParentId)
+	assert.Equal(t, "frontend", span.Service)
+	assert.Equal(t, int64(1461750491274000000), span.StartTimestamp)
+	assert.Equal(t, (207 * time.Millisecond).Nanoseconds(), span.EndTimestamp-span.StartTimestamp)
+	assert.True(t, span.Error)
+	assert.Equal(t, map[string]string{"http.path": "/api", "error": "connection refused"}, span.Tags,
+		"the error's message should be kept")
+}
+
+func TestJaegerRoundTrip(t *testing.T) {
+	spans := testSpans()
+	traces := ToJaeger(spans)
+	require.Len(t, traces, 1)
+	trace := traces[0]
+	assert.Equal(t, "0000000000000001", trace.TraceID)
+	assert.Len(t, trace.Processes, 2)
+	require.Len(t, trace.Spans, 2)
+	assert.Equal(t, "db", trace.Processes[trace.Spans[1].ProcessID].ServiceName)
+	trace.Spans[0].Process = nil
+	trace.Spans[1].Process = nil
+
+	encoded, err := json.Marshal(trace)
+	require.NoError(t, err)
+	var decoded JaegerTrace
+	require.NoError(t, json.Unmarshal(encoded, &decoded))
+	converted, err := FromJaeger(decoded)
+	require.NoError(t, err)
+	assert.Equal(t, spans, converted)
+}
+
+func TestJaegerLinks(t *testing.T) {
+	span := linkedSpan()
+	s := ToJaegerSpan(span)
+	assert.Equal(t, []JaegerReference{
+		{RefType: JaegerRefChildOf, TraceID: "0000000000000001", SpanID: "0000000000000001"},
+		{RefType: JaegerRefFollowsFrom, TraceID: "0000000000000007", SpanID: "0000000000000008"},
+		{RefType: JaegerRefFollowsFrom, TraceID: "0000000000000009", SpanID: "000000000000000a"},
+	}, s.References)
+
+	converted, err := FromJaegerSpan(s, s.Process)
+	require.NoError(t, err)
+	// Jaeger references have no attributes:
+	span.Links[0].Attributes = nil
+	assert.Equal(t, span, converted)
+}
+
+func TestFromJaeger(t *testing.T) {
+	// as Jaeger's query service serves it:
+	payload := `{"traceID":"6fb0c5a9cd9fa7d1","spans":[{"traceID":"6fb0c5a9cd9fa7d1","spanID":"3b2f9c9e1e0d4a11",
+		"operationName":"HTTP GET","references":[{"refType":"FOLLOWS_FROM","traceID":"6fb0c5a9cd9fa7d1","spanID":"0000000000000001"},
+		{"refType":"CHILD_OF","traceID":"6fb0c5a9cd9fa7d1","spanID":"0000000000000002"}],
+		"startTime":1600000000000000,"duration":1500,
+		"tags":[{"key":"http.status_code","type":"int64","value":500},{"key":"error","type":"bool","value":true},{"key":"sampler.param","type":"float64","value":0.25}],
+		"logs":[],"processID":"p1","warnings":null}],
+		"processes":{"p1":{"serviceName":"frontend","tags":[{"key":"hostname","type":"string","value":"web-1"}]}},"warnings":null}`
+	var trace JaegerTrace
+	require.NoError(t, json.Unmarshal([]byte(payload), &trace))
+	spans, err := FromJaeger(trace)
+	require.NoError(t, err)
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, int64(0x6fb0c5a9cd9fa7d1), span.TraceId)
+	assert.Equal(t, int64(2), span.ParentId, "the CHILD_OF reference should be the parent")
+	assert.Equal(t, []*ssf.SSFSpanLink{{TraceId: 0x6fb0c5a9cd9fa7d1, SpanId: 1}}, span.Links,
+		"the other references should be links")
+	assert.Equal(t, "frontend", span.Service)
+	assert.Equal(t, "HTTP GET", span.Name)
+	assert.Equal(t, (1500 * time.Microsecond).Nanoseconds(), span.EndTimestamp-span.StartTimestamp)
+	assert.True(t, span.Error)
+	assert.Equal(t, map[string]string{"http.status_code": "500", "sampler.param": "0.25"}, span.Tags)
+
+	trace.Spans[0].SpanID = "span-1"
+	_, err = FromJaeger(trace)
+	assert.Error(t, err)
+}
@@ -0,0 +1,98 @@
+package spanconv
+
+import (
+	"time"
+
+	"github.com/stripe/veneur/ssf"
+)
+
+// ZipkinErrorTag is the tag that marks Zipkin spans that failed.
+const ZipkinErrorTag = "error"
+
+// ZipkinSpan is a span of Zipkin's v2 API. Its IDs are hex-encoded,
+// and its timestamp and duration are in microseconds.
+type ZipkinSpan struct {
+	TraceID       string            `json:"traceId"`
+	ID            string            `json:"id"`
+	ParentID      string            `json:"parentId,omitempty"`
+	Name          string            `json:"name,omitempty"`
+	Timestamp     int64             `json:"timestamp,omitempty"`
+	Duration      int64             `json:"duration,omitempty"`
+	LocalEndpoint *ZipkinEndpoint   `json:"localEndpoint,omitempty"`
+	Tags          map[string]string `json:"tags,omitempty"`
+}
+
+// ZipkinEndpoint is the service that recorded a Zipkin span.
+type ZipkinEndpoint struct {
+	ServiceName string `json:"serviceName,omitempty"`
+}
+
+// ToZipkin converts a span to Zipkin.
+func ToZipkin(span *ssf.SSFSpan) ZipkinSpan {
+	out := ZipkinSpan{
+		TraceID:   FormatTraceID(span.TraceId),
+		ID:        FormatID(span.Id),
+		Name:      span.Name,
+		Timestamp: span.StartTimestamp / int64(time.Microsecond),
+		Duration:  (span.EndTimestamp - span.StartTimestamp) / int64(time.Microsecond),
+	}
+	if parent := parentID(span); parent != 0 {
+		out.ParentID = FormatID(parent)
+	}
+	if span.Service != "" {
+		out.LocalEndpoint = &ZipkinEndpoint{ServiceName: span.Service}
+	}
+	if len(span.Tags) > 0 || span.Indicator || span.Error {
+		out.Tags = make(map[string]string, len(span.Tags)+2)
+		for k, v := range span.Tags {
+			out.Tags[k] = v
+		}
+		if span.Indicator {
+			out.Tags[IndicatorTag] = "true"
+		}
+		if span.Error {
+			out.Tags[ZipkinErrorTag] = "true"
+		}
+	}
+	return out
+}
+
+// FromZipkin converts a Zipkin span to SSF. Zipkin marks failed spans
+// with an error tag whose value is the error's message, so the span
+// fails if it has the tag at all; the tag is kept, unless its value is
+// just "true".
+func FromZipkin(s ZipkinSpan) (*ssf.SSFSpan, error) {
+	span := &ssf.SSFSpan{
+		Name:           s.Name,
+		StartTimestamp: s.Timestamp * int64(time.Microsecond),
+		EndTimestamp:   (s.Timestamp + s.Duration) * int64(time.Microsecond),
+		Tags:           map[string]string{},
+	}
+	var err error
+	if span.TraceId, err = ParseID(s.TraceID); err != nil {
+		return nil, err
+	}
+	if span.Id, err = ParseID(s.ID); err != nil {
+		return nil, err
+	}
+	if span.ParentId, err = ParseID(s.ParentID); err != nil {
+		return nil, err
+	}
+	if s.LocalEndpoint != nil {
+		span.Service = s.LocalEndpoint.ServiceName
+	}
+	for k, v := range s.Tags {
+		switch {
+		case k == IndicatorTag && v == "true":
+			span.Indicator = true
+		case k == ZipkinErrorTag:
+			span.Error = true
+			if v != "true" {
+				span.Tags[k] = v
+			}
+		default:
+			span.Tags[k] = v
+		}
+	}
+	return span, nil
+}
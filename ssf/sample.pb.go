@@ -2,14 +2,17 @@
 // source: ssf/sample.proto
 
 /*
-	Package ssf is a generated protocol buffer package.
+Package ssf is a generated protocol buffer package.
 
-	It is generated from these files:
-		ssf/sample.proto
+It is generated from these files:
 
-	It has these top-level messages:
-		SSFSample
-		SSFSpan
+	ssf/sample.proto
+
+It has these top-level messages:
+
+	SSFSample
+	SSFSpan
+	SSFSpanLink
 */
 package ssf
 
@@ -89,6 +92,33 @@ func (x SSFSample_Status) String() string {
 }
 func (SSFSample_Status) EnumDescriptor() ([]byte, []int) { return fileDescriptorSample, []int{0, 1} }
 
+// The priority class of a metric decides the order in which
+// metrics are shed when veneur is overloaded (low first) and
+// flushed to sinks (high first).
+type SSFSample_Priority int32
+
+const (
+	SSFSample_NORMAL SSFSample_Priority = 0
+	SSFSample_LOW    SSFSample_Priority = 1
+	SSFSample_HIGH   SSFSample_Priority = 2
+)
+
+var SSFSample_Priority_name = map[int32]string{
+	0: "NORMAL",
+	1: "LOW",
+	2: "HIGH",
+}
+var SSFSample_Priority_value = map[string]int32{
+	"NORMAL": 0,
+	"LOW":    1,
+	"HIGH":   2,
+}
+
+func (x SSFSample_Priority) String() string {
+	return proto.EnumName(SSFSample_Priority_name, int32(x))
+}
+func (SSFSample_Priority) EnumDescriptor() ([]byte, []int) { return fileDescriptorSample, []int{0, 2} }
+
 // SSFSample is similar of a StatsD-style, point in time metric. It has a Metric
 // type, a name, a value and a timestamp. Additionally it can contain a message,
 // a status, a sample rate, a map of tags as string keys and values and a unit
@@ -98,14 +128,15 @@ type SSFSample struct {
 	Metric SSFSample_Metric `protobuf:"varint,1,opt,name=metric,proto3,enum=ssf.SSFSample_Metric" json:"metric,omitempty"`
 	// no spaces, but . is allowed
 	// e.g.: veneur.bar.baz
-	Name       string            `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
-	Value      float32           `protobuf:"fixed32,3,opt,name=value,proto3" json:"value,omitempty"`
-	Timestamp  int64             `protobuf:"varint,4,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
-	Message    string            `protobuf:"bytes,5,opt,name=message,proto3" json:"message,omitempty"`
-	Status     SSFSample_Status  `protobuf:"varint,6,opt,name=status,proto3,enum=ssf.SSFSample_Status" json:"status,omitempty"`
-	SampleRate float32           `protobuf:"fixed32,7,opt,name=sample_rate,json=sampleRate,proto3" json:"sample_rate,omitempty"`
-	Tags       map[string]string `protobuf:"bytes,8,rep,name=tags" json:"tags,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
-	Unit       string            `protobuf:"bytes,9,opt,name=unit,proto3" json:"unit,omitempty"`
+	Name       string             `protobuf:"bytes,2,opt,name=name,proto3" json:"name,omitempty"`
+	Value      float32            `protobuf:"fixed32,3,opt,name=value,proto3" json:"value,omitempty"`
+	Timestamp  int64              `protobuf:"varint,4,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Message    string             `protobuf:"bytes,5,opt,name=message,proto3" json:"message,omitempty"`
+	Status     SSFSample_Status   `protobuf:"varint,6,opt,name=status,proto3,enum=ssf.SSFSample_Status" json:"status,omitempty"`
+	SampleRate float32            `protobuf:"fixed32,7,opt,name=sample_rate,json=sampleRate,proto3" json:"sample_rate,omitempty"`
+	Tags       map[string]string  `protobuf:"bytes,8,rep,name=tags" json:"tags,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Unit       string             `protobuf:"bytes,9,opt,name=unit,proto3" json:"unit,omitempty"`
+	Priority   SSFSample_Priority `protobuf:"varint,10,opt,name=priority,proto3,enum=ssf.SSFSample_Priority" json:"priority,omitempty"`
 }
 
 func (m *SSFSample) Reset()                    { *m = SSFSample{} }
@@ -176,6 +207,13 @@ func (m *SSFSample) GetUnit() string {
 	return ""
 }
 
+func (m *SSFSample) GetPriority() SSFSample_Priority {
+	if m != nil {
+		return m.Priority
+	}
+	return SSFSample_NORMAL
+}
+
 // SSFSpan is the primary unit of reporting in SSF. It embeds a set of
 // SSFSamples, as well as start/stop time stamps and a parent ID
 // (which allows assembling a span lineage for distributed tracing
@@ -185,7 +223,7 @@ func (m *SSFSample) GetUnit() string {
 // include metrics, just as it does not *have* to include information
 // necessary to reconstruct a trace.
 //
-// Compatibility
+// # Compatibility
 //
 // On ingestion, an SSFSpan with an empty string for a name field but
 // a tag "name" will have that name field replaced with the name tag,
@@ -194,7 +232,7 @@ func (m *SSFSample) GetUnit() string {
 // Metric SSFSamples with a zero sample_rate (indicating it was left
 // out) have the sample_rate field set to 1 on ingestion.
 //
-// Validity Criteria
+// # Validity Criteria
 //
 // Programs consuming SSFSpans should take care to only process spans
 // and metrics that fulfill the following criteria:
@@ -240,6 +278,10 @@ type SSFSpan struct {
 	// (/customer/:id), the function (class::name.method), a friendly name
 	// (foo middleware) or whatever makes sense in your context.
 	Name string `protobuf:"bytes,13,opt,name=name,proto3" json:"name,omitempty"`
+	// Links are the spans that this span is related to without being
+	// their child, in this trace or in others. For example, a span that
+	// processes a batch of messages links to the spans that sent them.
+	Links []*SSFSpanLink `protobuf:"bytes,14,rep,name=links" json:"links,omitempty"`
 }
 
 func (m *SSFSpan) Reset()                    { *m = SSFSpan{} }
@@ -331,11 +373,57 @@ func (m *SSFSpan) GetName() string {
 	return ""
 }
 
+func (m *SSFSpan) GetLinks() []*SSFSpanLink {
+	if m != nil {
+		return m.Links
+	}
+	return nil
+}
+
+// A link from a span to another span, which doesn't need to be in the
+// same trace.
+type SSFSpanLink struct {
+	// the trace_id and id of the linked span
+	TraceId int64 `protobuf:"varint,1,opt,name=trace_id,json=traceId,proto3" json:"trace_id,omitempty"`
+	SpanId  int64 `protobuf:"varint,2,opt,name=span_id,json=spanId,proto3" json:"span_id,omitempty"`
+	// Attributes are name value pairs that describe the link, like why
+	// the spans are related.
+	Attributes map[string]string `protobuf:"bytes,3,rep,name=attributes" json:"attributes,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+func (m *SSFSpanLink) Reset()                    { *m = SSFSpanLink{} }
+func (m *SSFSpanLink) String() string            { return proto.CompactTextString(m) }
+func (*SSFSpanLink) ProtoMessage()               {}
+func (*SSFSpanLink) Descriptor() ([]byte, []int) { return fileDescriptorSample, []int{2} }
+
+func (m *SSFSpanLink) GetTraceId() int64 {
+	if m != nil {
+		return m.TraceId
+	}
+	return 0
+}
+
+func (m *SSFSpanLink) GetSpanId() int64 {
+	if m != nil {
+		return m.SpanId
+	}
+	return 0
+}
+
+func (m *SSFSpanLink) GetAttributes() map[string]string {
+	if m != nil {
+		return m.Attributes
+	}
+	return nil
+}
+
 func init() {
 	proto.RegisterType((*SSFSample)(nil), "ssf.SSFSample")
 	proto.RegisterType((*SSFSpan)(nil), "ssf.SSFSpan")
+	proto.RegisterType((*SSFSpanLink)(nil), "ssf.SSFSpanLink")
 	proto.RegisterEnum("ssf.SSFSample_Metric", SSFSample_Metric_name, SSFSample_Metric_value)
 	proto.RegisterEnum("ssf.SSFSample_Status", SSFSample_Status_name, SSFSample_Status_value)
+	proto.RegisterEnum("ssf.SSFSample_Priority", SSFSample_Priority_name, SSFSample_Priority_value)
 }
 func (m *SSFSample) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
@@ -414,6 +502,11 @@ func (m *SSFSample) MarshalTo(dAtA []byte) (int, error) {
 		i = encodeVarintSample(dAtA, i, uint64(len(m.Unit)))
 		i += copy(dAtA[i:], m.Unit)
 	}
+	if m.Priority != 0 {
+		dAtA[i] = 0x50
+		i++
+		i = encodeVarintSample(dAtA, i, uint64(m.Priority))
+	}
 	return i, nil
 }
 
@@ -523,6 +616,63 @@ func (m *SSFSpan) MarshalTo(dAtA []byte) (int, error) {
 		i = encodeVarintSample(dAtA, i, uint64(len(m.Name)))
 		i += copy(dAtA[i:], m.Name)
 	}
+	if len(m.Links) > 0 {
+		for _, msg := range m.Links {
+			dAtA[i] = 0x72
+			i++
+			i = encodeVarintSample(dAtA, i, uint64(msg.Size()))
+			n, err := msg.MarshalTo(dAtA[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n
+		}
+	}
+	return i, nil
+}
+
+func (m *SSFSpanLink) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalTo(dAtA)
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *SSFSpanLink) MarshalTo(dAtA []byte) (int, error) {
+	var i int
+	_ = i
+	var l int
+	_ = l
+	if m.TraceId != 0 {
+		dAtA[i] = 0x8
+		i++
+		i = encodeVarintSample(dAtA, i, uint64(m.TraceId))
+	}
+	if m.SpanId != 0 {
+		dAtA[i] = 0x10
+		i++
+		i = encodeVarintSample(dAtA, i, uint64(m.SpanId))
+	}
+	if len(m.Attributes) > 0 {
+		for k, _ := range m.Attributes {
+			dAtA[i] = 0x1a
+			i++
+			v := m.Attributes[k]
+			mapSize := 1 + len(k) + sovSample(uint64(len(k))) + 1 + len(v) + sovSample(uint64(len(v)))
+			i = encodeVarintSample(dAtA, i, uint64(mapSize))
+			dAtA[i] = 0xa
+			i++
+			i = encodeVarintSample(dAtA, i, uint64(len(k)))
+			i += copy(dAtA[i:], k)
+			dAtA[i] = 0x12
+			i++
+			i = encodeVarintSample(dAtA, i, uint64(len(v)))
+			i += copy(dAtA[i:], v)
+		}
+	}
 	return i, nil
 }
 
@@ -573,6 +723,9 @@ func (m *SSFSample) Size() (n int) {
 	if l > 0 {
 		n += 1 + l + sovSample(uint64(l))
 	}
+	if m.Priority != 0 {
+		n += 1 + sovSample(uint64(m.Priority))
+	}
 	return n
 }
 
@@ -625,6 +778,32 @@ func (m *SSFSpan) Size() (n int) {
 	if l > 0 {
 		n += 1 + l + sovSample(uint64(l))
 	}
+	if len(m.Links) > 0 {
+		for _, e := range m.Links {
+			l = e.Size()
+			n += 1 + l + sovSample(uint64(l))
+		}
+	}
+	return n
+}
+
+func (m *SSFSpanLink) Size() (n int) {
+	var l int
+	_ = l
+	if m.TraceId != 0 {
+		n += 1 + sovSample(uint64(m.TraceId))
+	}
+	if m.SpanId != 0 {
+		n += 1 + sovSample(uint64(m.SpanId))
+	}
+	if len(m.Attributes) > 0 {
+		for k, v := range m.Attributes {
+			_ = k
+			_ = v
+			mapEntrySize := 1 + len(k) + sovSample(uint64(len(k))) + 1 + len(v) + sovSample(uint64(len(v)))
+			n += mapEntrySize + 1 + sovSample(uint64(mapEntrySize))
+		}
+	}
 	return n
 }
 
@@ -954,6 +1133,25 @@ func (m *SSFSample) Unmarshal(dAtA []byte) error {
 			}
 			m.Unit = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
+		case 10:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Priority", wireType)
+			}
+			m.Priority = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSample
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Priority |= (SSFSample_Priority(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipSample(dAtA[iNdEx:])
@@ -1365,6 +1563,243 @@ func (m *SSFSpan) Unmarshal(dAtA []byte) error {
 			}
 			m.Name = string(dAtA[iNdEx:postIndex])
 			iNdEx = postIndex
+		case 14:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Links", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSample
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthSample
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Links = append(m.Links, &SSFSpanLink{})
+			if err := m.Links[len(m.Links)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipSample(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthSample
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *SSFSpanLink) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowSample
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: SSFSpanLink: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: SSFSpanLink: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TraceId", wireType)
+			}
+			m.TraceId = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSample
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.TraceId |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SpanId", wireType)
+			}
+			m.SpanId = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSample
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.SpanId |= (int64(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Attributes", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowSample
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthSample
+			}
+			postIndex := iNdEx + msglen
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Attributes == nil {
+				m.Attributes = make(map[string]string)
+			}
+			var mapkey string
+			var mapvalue string
+			for iNdEx < postIndex {
+				entryPreIndex := iNdEx
+				var wire uint64
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowSample
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					wire |= (uint64(b) & 0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				fieldNum := int32(wire >> 3)
+				if fieldNum == 1 {
+					var stringLenmapkey uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowSample
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						stringLenmapkey |= (uint64(b) & 0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					intStringLenmapkey := int(stringLenmapkey)
+					if intStringLenmapkey < 0 {
+						return ErrInvalidLengthSample
+					}
+					postStringIndexmapkey := iNdEx + intStringLenmapkey
+					if postStringIndexmapkey > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapkey = string(dAtA[iNdEx:postStringIndexmapkey])
+					iNdEx = postStringIndexmapkey
+				} else if fieldNum == 2 {
+					var stringLenmapvalue uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowSample
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						stringLenmapvalue |= (uint64(b) & 0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					intStringLenmapvalue := int(stringLenmapvalue)
+					if intStringLenmapvalue < 0 {
+						return ErrInvalidLengthSample
+					}
+					postStringIndexmapvalue := iNdEx + intStringLenmapvalue
+					if postStringIndexmapvalue > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapvalue = string(dAtA[iNdEx:postStringIndexmapvalue])
+					iNdEx = postStringIndexmapvalue
+				} else {
+					iNdEx = entryPreIndex
+					skippy, err := skipSample(dAtA[iNdEx:])
+					if err != nil {
+						return err
+					}
+					if skippy < 0 {
+						return ErrInvalidLengthSample
+					}
+					if (iNdEx + skippy) > postIndex {
+						return io.ErrUnexpectedEOF
+					}
+					iNdEx += skippy
+				}
+			}
+			m.Attributes[mapkey] = mapvalue
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipSample(dAtA[iNdEx:])
@@ -1494,41 +1929,49 @@ var (
 func init() { proto.RegisterFile("ssf/sample.proto", fileDescriptorSample) }
 
 var fileDescriptorSample = []byte{
-	// 573 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x53, 0xdd, 0x6e, 0xd3, 0x30,
-	0x14, 0x5e, 0xe2, 0x36, 0x4d, 0x4e, 0xb7, 0x62, 0x59, 0x03, 0x19, 0x98, 0x4a, 0x55, 0x2e, 0xa8,
-	0x10, 0x14, 0x69, 0x5c, 0x30, 0x71, 0x57, 0x46, 0x29, 0x65, 0x2c, 0x95, 0x9c, 0x54, 0xbb, 0x9c,
-	0x4c, 0xe3, 0x4d, 0x11, 0x4b, 0x1a, 0xd9, 0xde, 0xa4, 0xbd, 0x05, 0x8f, 0xc2, 0x63, 0x70, 0xc9,
-	0x23, 0xa0, 0x71, 0xc1, 0x6b, 0x20, 0xdb, 0x6d, 0xc3, 0xdf, 0x15, 0x77, 0xfe, 0xce, 0xf7, 0xc9,
-	0x3a, 0xdf, 0xf9, 0xce, 0x01, 0xac, 0xd4, 0xd9, 0x33, 0xc5, 0x8b, 0xea, 0x42, 0x0c, 0x2b, 0xb9,
-	0xd4, 0x4b, 0x82, 0x94, 0x3a, 0xeb, 0xff, 0x40, 0x10, 0x25, 0xc9, 0x9b, 0xc4, 0x12, 0xe4, 0x29,
-	0x04, 0x85, 0xd0, 0x32, 0x5f, 0x50, 0xaf, 0xe7, 0x0d, 0x3a, 0xfb, 0xb7, 0x87, 0x4a, 0x9d, 0x0d,
-	0x37, 0xfc, 0xf0, 0xd8, 0x92, 0x6c, 0x25, 0x22, 0x04, 0x1a, 0x25, 0x2f, 0x04, 0xf5, 0x7b, 0xde,
-	0x20, 0x62, 0xf6, 0x4d, 0x76, 0xa1, 0x79, 0xc5, 0x2f, 0x2e, 0x05, 0x45, 0x3d, 0x6f, 0xe0, 0x33,
-	0x07, 0xc8, 0x1e, 0x44, 0x3a, 0x2f, 0x84, 0xd2, 0xbc, 0xa8, 0x68, 0xa3, 0xe7, 0x0d, 0x10, 0xab,
-	0x0b, 0x84, 0x42, 0xab, 0x10, 0x4a, 0xf1, 0x73, 0x41, 0x9b, 0xf6, 0xab, 0x35, 0x34, 0x0d, 0x29,
-	0xcd, 0xf5, 0xa5, 0xa2, 0xc1, 0x3f, 0x1b, 0x4a, 0x2c, 0xc9, 0x56, 0x22, 0xf2, 0x00, 0xda, 0xce,
-	0xe2, 0xa9, 0xe4, 0x5a, 0xd0, 0x96, 0x6d, 0x01, 0x5c, 0x89, 0x71, 0x2d, 0xc8, 0x13, 0x68, 0x68,
-	0x7e, 0xae, 0x68, 0xd8, 0x43, 0x83, 0xf6, 0x3e, 0xfd, 0xe3, 0xb7, 0x94, 0x9f, 0xab, 0x71, 0xa9,
-	0xe5, 0x35, 0xb3, 0x2a, 0xe3, 0xef, 0xb2, 0xcc, 0x35, 0x8d, 0x9c, 0x3f, 0xf3, 0xbe, 0xf7, 0x02,
-	0xa2, 0x8d, 0x8c, 0x60, 0x40, 0x1f, 0xc5, 0xb5, 0x1d, 0x56, 0xc4, 0xcc, 0xb3, 0xb6, 0xef, 0x66,
-	0xe2, 0xc0, 0x4b, 0xff, 0xc0, 0xeb, 0xbf, 0x86, 0xc0, 0x8d, 0x8f, 0xb4, 0xa1, 0x75, 0x38, 0x9b,
-	0xc7, 0xe9, 0x98, 0xe1, 0x2d, 0x12, 0x41, 0x73, 0x32, 0x9a, 0x4f, 0xc6, 0xd8, 0x23, 0x3b, 0x10,
-	0xbd, 0x9d, 0x26, 0xe9, 0x6c, 0xc2, 0x46, 0xc7, 0xd8, 0x27, 0x2d, 0x40, 0xc9, 0x38, 0xc5, 0x88,
-	0x00, 0x04, 0x49, 0x3a, 0x4a, 0xe7, 0x09, 0x6e, 0xf4, 0x0f, 0x20, 0x70, 0x9e, 0x49, 0x00, 0xfe,
-	0xec, 0x08, 0x6f, 0x99, 0xdf, 0x4e, 0x46, 0x2c, 0x9e, 0xc6, 0x13, 0xec, 0x91, 0x6d, 0x08, 0x0f,
-	0xd9, 0x34, 0x9d, 0x1e, 0x8e, 0xde, 0x63, 0xdf, 0x50, 0xf3, 0xf8, 0x28, 0x9e, 0x9d, 0xc4, 0x18,
-	0xf5, 0x3f, 0x23, 0x68, 0x19, 0xab, 0x15, 0x2f, 0xcd, 0xc0, 0xaf, 0x84, 0x54, 0xf9, 0xb2, 0xb4,
-	0xbd, 0x37, 0xd9, 0x1a, 0x92, 0xbb, 0x10, 0x6a, 0xc9, 0x17, 0xe2, 0x34, 0xcf, 0xac, 0x05, 0xc4,
-	0x5a, 0x16, 0x4f, 0x33, 0xd2, 0x01, 0x3f, 0xcf, 0x6c, 0xac, 0x88, 0xf9, 0x79, 0x46, 0xee, 0x43,
-	0x54, 0x71, 0x29, 0x4a, 0x6d, 0xb4, 0x2e, 0xd3, 0xd0, 0x15, 0xa6, 0x19, 0x79, 0x04, 0xb7, 0x94,
-	0xe6, 0x52, 0x9f, 0xd6, 0xb1, 0x37, 0xad, 0xa4, 0x63, 0xcb, 0xe9, 0x26, 0xfb, 0x87, 0xb0, 0x23,
-	0xca, 0xec, 0x17, 0x59, 0x60, 0x65, 0xdb, 0xa2, 0xcc, 0x6a, 0xd1, 0x2e, 0x34, 0x85, 0x94, 0x4b,
-	0x69, 0x13, 0x0d, 0x99, 0x03, 0xc6, 0x85, 0x12, 0xf2, 0x2a, 0x5f, 0x08, 0x1a, 0xba, 0xb5, 0x59,
-	0x41, 0x32, 0x30, 0x0b, 0x65, 0x66, 0xad, 0x28, 0xd8, 0xa4, 0x3b, 0xbf, 0x27, 0xcd, 0xd6, 0x34,
-	0x79, 0xbc, 0x5a, 0x88, 0xb6, 0x95, 0xdd, 0xd9, 0xc8, 0x2a, 0x5e, 0xfe, 0xb5, 0x0e, 0x7b, 0x10,
-	0xe5, 0x65, 0x96, 0x2f, 0xb8, 0x5e, 0x4a, 0xba, 0x6d, 0x3b, 0xa9, 0x0b, 0x9b, 0x63, 0xd8, 0xa9,
-	0x8f, 0xe1, 0xbf, 0x97, 0xe5, 0x5d, 0x23, 0x8c, 0x30, 0xbc, 0xc2, 0x5f, 0x6e, 0xba, 0xde, 0xd7,
-	0x9b, 0xae, 0xf7, 0xed, 0xa6, 0xeb, 0x7d, 0xfa, 0xde, 0xdd, 0xfa, 0x10, 0xd8, 0xd3, 0x7d, 0xfe,
-	0x33, 0x00, 0x00, 0xff, 0xff, 0xa8, 0x9e, 0x3e, 0x57, 0xce, 0x03, 0x00, 0x00,
+	// 702 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x9c, 0x54, 0x5d, 0x4f, 0xdb, 0x48,
+	0x14, 0x8d, 0xed, 0xc4, 0xb1, 0x6f, 0x20, 0x8c, 0x46, 0xec, 0x32, 0xcb, 0xa2, 0x6c, 0x94, 0x95,
+	0x76, 0xb3, 0xab, 0x36, 0x95, 0xe0, 0xa1, 0xa8, 0x52, 0xa5, 0xa6, 0x34, 0x0d, 0x2e, 0x21, 0xa9,
+	0xc6, 0x8e, 0x78, 0x44, 0x43, 0x3c, 0x20, 0x0b, 0xe2, 0x58, 0x33, 0x13, 0x24, 0xfe, 0x45, 0x7f,
+	0x53, 0x5f, 0xda, 0xc7, 0x3e, 0xf6, 0xb1, 0xa2, 0x7f, 0xa4, 0x9a, 0x71, 0xbe, 0x48, 0xfb, 0xd2,
+	0xbe, 0xcd, 0xbd, 0xe7, 0x70, 0xb8, 0xe7, 0xde, 0xe3, 0x00, 0x92, 0xf2, 0xf2, 0x89, 0x64, 0xe3,
+	0xec, 0x86, 0xb7, 0x32, 0x31, 0x51, 0x13, 0xec, 0x48, 0x79, 0xd9, 0xf8, 0x50, 0x04, 0x3f, 0x0c,
+	0x5f, 0x87, 0x06, 0xc0, 0x8f, 0xc1, 0x1d, 0x73, 0x25, 0x92, 0x11, 0xb1, 0xea, 0x56, 0xb3, 0xba,
+	0xff, 0x5b, 0x4b, 0xca, 0xcb, 0xd6, 0x02, 0x6f, 0x9d, 0x1a, 0x90, 0xce, 0x48, 0x18, 0x43, 0x31,
+	0x65, 0x63, 0x4e, 0xec, 0xba, 0xd5, 0xf4, 0xa9, 0x79, 0xe3, 0x6d, 0x28, 0xdd, 0xb2, 0x9b, 0x29,
+	0x27, 0x4e, 0xdd, 0x6a, 0xda, 0x34, 0x2f, 0xf0, 0x1e, 0xf8, 0x2a, 0x19, 0x73, 0xa9, 0xd8, 0x38,
+	0x23, 0xc5, 0xba, 0xd5, 0x74, 0xe8, 0xb2, 0x81, 0x09, 0x94, 0xc7, 0x5c, 0x4a, 0x76, 0xc5, 0x49,
+	0xc9, 0x48, 0xcd, 0x4b, 0x3d, 0x90, 0x54, 0x4c, 0x4d, 0x25, 0x71, 0x7f, 0x38, 0x50, 0x68, 0x40,
+	0x3a, 0x23, 0xe1, 0xbf, 0xa0, 0x92, 0x5b, 0x3c, 0x17, 0x4c, 0x71, 0x52, 0x36, 0x23, 0x40, 0xde,
+	0xa2, 0x4c, 0x71, 0xfc, 0x08, 0x8a, 0x8a, 0x5d, 0x49, 0xe2, 0xd5, 0x9d, 0x66, 0x65, 0x9f, 0xac,
+	0xa9, 0x45, 0xec, 0x4a, 0x76, 0x52, 0x25, 0xee, 0xa8, 0x61, 0x69, 0x7f, 0xd3, 0x34, 0x51, 0xc4,
+	0xcf, 0xfd, 0xe9, 0x37, 0x3e, 0x00, 0x2f, 0x13, 0xc9, 0x44, 0x24, 0xea, 0x8e, 0x80, 0x99, 0x69,
+	0x67, 0x4d, 0xe5, 0xed, 0x0c, 0xa6, 0x0b, 0xe2, 0xee, 0x53, 0xf0, 0x17, 0xda, 0x18, 0x81, 0x73,
+	0xcd, 0xef, 0xcc, 0x86, 0x7d, 0xaa, 0x9f, 0xcb, 0x9d, 0xe5, 0x8b, 0xcc, 0x8b, 0x67, 0xf6, 0xa1,
+	0xd5, 0x78, 0x05, 0x6e, 0xbe, 0x73, 0x5c, 0x81, 0xf2, 0xd1, 0x60, 0xd8, 0x8f, 0x3a, 0x14, 0x15,
+	0xb0, 0x0f, 0xa5, 0x6e, 0x7b, 0xd8, 0xed, 0x20, 0x0b, 0x6f, 0x82, 0x7f, 0x1c, 0x84, 0xd1, 0xa0,
+	0x4b, 0xdb, 0xa7, 0xc8, 0xc6, 0x65, 0x70, 0xc2, 0x4e, 0x84, 0x1c, 0x0c, 0xe0, 0x86, 0x51, 0x3b,
+	0x1a, 0x86, 0xa8, 0xd8, 0x38, 0x04, 0x37, 0x5f, 0x14, 0x76, 0xc1, 0x1e, 0x9c, 0xa0, 0x82, 0x56,
+	0x3b, 0x6b, 0xd3, 0x7e, 0xd0, 0xef, 0x22, 0x0b, 0x6f, 0x80, 0x77, 0x44, 0x83, 0x28, 0x38, 0x6a,
+	0xf7, 0x90, 0xad, 0xa1, 0x61, 0xff, 0xa4, 0x3f, 0x38, 0xeb, 0x23, 0xa7, 0xf1, 0x1f, 0x78, 0x73,
+	0x3b, 0x5a, 0xb1, 0x3f, 0xa0, 0xa7, 0xed, 0x1e, 0x2a, 0xe8, 0x7f, 0xd3, 0x1b, 0x9c, 0x21, 0x0b,
+	0x7b, 0x50, 0x3c, 0x0e, 0xba, 0xc7, 0xc8, 0x6e, 0x7c, 0x76, 0xa0, 0xac, 0x97, 0x90, 0xb1, 0x54,
+	0x1f, 0xf4, 0x96, 0x0b, 0x99, 0x4c, 0x52, 0x63, 0xb3, 0x44, 0xe7, 0x25, 0xfe, 0x03, 0x3c, 0x25,
+	0xd8, 0x88, 0x9f, 0x27, 0xb1, 0x71, 0xeb, 0xd0, 0xb2, 0xa9, 0x83, 0x18, 0x57, 0xc1, 0x4e, 0x62,
+	0x13, 0x1b, 0x87, 0xda, 0x49, 0x8c, 0xff, 0x04, 0x3f, 0x63, 0x82, 0xa7, 0x4a, 0x73, 0xf3, 0xcc,
+	0x78, 0x79, 0x23, 0x88, 0xf1, 0xbf, 0xb0, 0x25, 0x15, 0x13, 0xea, 0x7c, 0x19, 0xab, 0x92, 0xa1,
+	0x54, 0x4d, 0x3b, 0x9a, 0x77, 0xf1, 0xdf, 0xb0, 0xc9, 0xd3, 0x78, 0x85, 0xe6, 0x1a, 0xda, 0x06,
+	0x4f, 0xe3, 0x25, 0x69, 0x1b, 0x4a, 0x5c, 0x88, 0x89, 0x30, 0x89, 0xf1, 0x68, 0x5e, 0x68, 0x17,
+	0x92, 0x8b, 0xdb, 0x64, 0xc4, 0x89, 0x97, 0xc7, 0x72, 0x56, 0xe2, 0xa6, 0x0e, 0xac, 0x3e, 0x8b,
+	0x24, 0x60, 0x92, 0x54, 0x7d, 0x98, 0x01, 0x3a, 0x87, 0xf1, 0xff, 0xb3, 0xc0, 0x55, 0x0c, 0xed,
+	0xf7, 0x05, 0x2d, 0x63, 0xe9, 0x77, 0x71, 0xdb, 0x03, 0x3f, 0x49, 0xe3, 0x64, 0xc4, 0xd4, 0x44,
+	0x90, 0x0d, 0x33, 0xc9, 0xb2, 0xb1, 0xf8, 0xd8, 0x36, 0x57, 0x3e, 0xb6, 0x7f, 0xa0, 0x74, 0x93,
+	0xa4, 0xd7, 0x92, 0x54, 0x8d, 0x3c, 0x5a, 0x95, 0xef, 0x25, 0xe9, 0x35, 0xcd, 0xe1, 0x5f, 0xce,
+	0xdf, 0x9b, 0xa2, 0xe7, 0x23, 0x68, 0xbc, 0xb7, 0xa0, 0xb2, 0xa2, 0xfa, 0xe0, 0x88, 0xd6, 0xc3,
+	0x23, 0xee, 0x40, 0x59, 0x66, 0x2c, 0x5d, 0x9e, 0xd7, 0xd5, 0x65, 0x10, 0xe3, 0x17, 0x00, 0x4c,
+	0x29, 0x91, 0x5c, 0x4c, 0x15, 0x97, 0xc4, 0x31, 0xf3, 0xd6, 0xd7, 0xe7, 0x6d, 0xb5, 0x17, 0x94,
+	0x7c, 0x31, 0x2b, 0x7f, 0xb3, 0xfb, 0x1c, 0xb6, 0xd6, 0xe0, 0x9f, 0xb1, 0xf2, 0x12, 0x7d, 0xbc,
+	0xaf, 0x59, 0x9f, 0xee, 0x6b, 0xd6, 0x97, 0xfb, 0x9a, 0xf5, 0xee, 0x6b, 0xad, 0x70, 0xe1, 0x9a,
+	0xdf, 0xc1, 0x83, 0x6f, 0x03, 0x00, 0x12, 0x4d, 0xc2, 0x4d, 0x1b, 0x05, 0x00, 0x00,
 }
@@ -18,6 +18,13 @@ func defaultRandFloat32() float32 {
 	return rand.Float32()
 }
 
+// EventTag marks an SSF sample as an event or a service check, rather
+// than a metric: veneur doesn't aggregate such samples, but hands them
+// to its metric sinks as they are. A sample of the STATUS type is a
+// service check with its status and message; any other is an event
+// titled by its name, with its message as the text.
+const EventTag = "veneurevent"
+
 // Samples is a batch of SSFSamples, not attached to an SSF span, that
 // can be submitted with package metrics's Report function.
 type Samples struct {
@@ -52,6 +59,14 @@ func Unit(name string) SampleOption {
 	}
 }
 
+// Priority is a functional option for creating an SSFSample. It sets
+// the priority class that veneur sheds and flushes the sample by.
+func Priority(priority SSFSample_Priority) SampleOption {
+	return func(s *SSFSample) {
+		s.Priority = priority
+	}
+}
+
 // Timestamp is a functional option for creating an SSFSample. It sets
 // the timestamp field on the sample to the timestamp passed.
 func Timestamp(ts time.Time) SampleOption {
@@ -98,6 +113,57 @@ func TimeUnit(resolution time.Duration) SampleOption {
 	}
 }
 
+// Failure causes are the values of the "cause" tag that Failure
+// attaches to a sample. Every counter of failed or dropped work
+// should be tagged with exactly one of them, so that failures across
+// all of veneur's components can be broken down the same way.
+const (
+	// CauseParseError means input could not be parsed or decoded.
+	CauseParseError = "parse_error"
+	// CauseEncodeError means output could not be serialized.
+	CauseEncodeError = "encode_error"
+	// CauseIOError means a read from or write to a network peer,
+	// disk or other backend failed.
+	CauseIOError = "io_error"
+	// CauseSinkTimeout means a sink or upstream didn't respond
+	// before its deadline.
+	CauseSinkTimeout = "sink_timeout"
+	// CauseRejected means an upstream responded, but refused the
+	// data.
+	CauseRejected = "rejected"
+	// CauseRateLimited means an upstream refused the data because
+	// of a rate limit or quota.
+	CauseRateLimited = "rate_limited"
+	// CauseQueueFull means the data was dropped because a buffer or
+	// queue had no room for it.
+	CauseQueueFull = "queue_full"
+	// CauseCardinalityCap means the data was dropped because it
+	// would have exceeded a limit on the number of distinct series.
+	CauseCardinalityCap = "cardinality_cap"
+	// CauseSchemaViolation means the data was dropped because it
+	// didn't match its declared schema.
+	CauseSchemaViolation = "schema_violation"
+	// CauseClockSkew means the data was dropped because its
+	// timestamp was too far in the past or in the future.
+	CauseClockSkew = "clock_skew"
+)
+
+// Failure marks a sample as counting failures, tagging it with the
+// component that failed and the cause of the failure, which should be
+// one of the Cause constants. Any more detailed reason belongs in a
+// separate "reason" tag.
+func Failure(component, cause string) SampleOption {
+	return func(s *SSFSample) {
+		tags := make(map[string]string, len(s.Tags)+2)
+		for k, v := range s.Tags {
+			tags[k] = v
+		}
+		tags["component"] = component
+		tags["cause"] = cause
+		s.Tags = tags
+	}
+}
+
 func create(base *SSFSample, opts []SampleOption) *SSFSample {
 	base.Name = NamePrefix + base.Name
 	for _, opt := range opts {
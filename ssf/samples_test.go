@@ -87,6 +87,17 @@ func TestOptions(t *testing.T) {
 				assert.Equal(t, then.UnixNano(), s.Timestamp)
 			},
 		},
+		{
+			"failure",
+			Failure("tests", CauseQueueFull),
+			func(s *SSFSample) {
+				assert.Equal(t, map[string]string{
+					"purpose":   "testing",
+					"component": "tests",
+					"cause":     "queue_full",
+				}, s.Tags)
+			},
+		},
 	}
 	for _, name := range testTypes {
 		test := name
@@ -104,6 +115,18 @@ func TestOptions(t *testing.T) {
 	}
 }
 
+func TestFailureKeepsTags(t *testing.T) {
+	tags := map[string]string{"cause": "io", "sink": "test"}
+	sample := Count("foo", 1, tags, Failure("sink", CauseIOError))
+	assert.Equal(t, map[string]string{"cause": "io", "sink": "test"}, tags,
+		"the caller's tags should not be modified")
+	assert.Equal(t, map[string]string{
+		"cause":     "io_error",
+		"component": "sink",
+		"sink":      "test",
+	}, sample.Tags)
+}
+
 func TestPrefix(t *testing.T) {
 	NamePrefix = "testing.the.prefix."
 	for _, elt := range testTypes {
@@ -0,0 +1,219 @@
+package veneur
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/protocol"
+	"github.com/stripe/veneur/ssf"
+)
+
+// statsdRepeaterQueueSize is the number of metrics that can wait to be
+// repeated before more metrics are dropped.
+const statsdRepeaterQueueSize = 4096
+
+// statsdRepeaterMaxDatagram is the largest datagram the repeater sends
+// unless configured otherwise, which fits in the MTU of most networks.
+const statsdRepeaterMaxDatagram = 1432
+
+// statsdRepeaterRedialInterval is how long the repeater waits before
+// it dials its destination again after failing to.
+const statsdRepeaterRedialInterval = time.Second
+
+// statsdRepeater sends the raw statsd metrics whose names match one of
+// its patterns to another statsd address, unaggregated, as well as
+// having them aggregated as usual. This keeps legacy consumers of the
+// raw stream working while clients migrate to veneur. Metrics are
+// repeated in the background, several to a datagram of at most
+// maxDatagram bytes: when the destination can't keep up, they are
+// dropped rather than slowing down the listeners.
+//
+// repeat and repeats are safe to call on a nil *statsdRepeater, which
+// never repeats anything.
+type statsdRepeater struct {
+	destination net.Addr
+	patterns    []string
+	maxDatagram int
+	lines       chan []byte
+
+	// counters since the last report, updated atomically:
+	sent      int64
+	dropped   int64
+	errors    int64
+	split     int64
+	oversized int64
+}
+
+// newStatsdRepeater returns a repeater that sends the metrics whose
+// names match one of the shell-style glob patterns (see path.Match) to
+// the UDP or UNIX datagram socket address destination, in datagrams of
+// at most maxDatagram bytes (statsdRepeaterMaxDatagram, if it's 0).
+func newStatsdRepeater(destination string, patterns []string, maxDatagram int) (*statsdRepeater, error) {
+	addr, err := protocol.ResolveAddr(destination)
+	if err != nil {
+		return nil, err
+	}
+	switch addr.Network() {
+	case "udp", "udp4", "udp6", "unixgram":
+	default:
+		return nil, fmt.Errorf("statsd_repeater_address: can't repeat metrics to %q, only udp:// and unixgram:// addresses are supported", destination)
+	}
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("statsd_repeater_metrics: no metrics to repeat to %q", destination)
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("statsd_repeater_metrics: invalid pattern %q: %v", pattern, err)
+		}
+	}
+	if maxDatagram < 0 {
+		return nil, fmt.Errorf("statsd_repeater_max_datagram_size: can't be negative, got %d", maxDatagram)
+	}
+	if maxDatagram == 0 {
+		maxDatagram = statsdRepeaterMaxDatagram
+	}
+	return &statsdRepeater{
+		destination: addr,
+		patterns:    patterns,
+		maxDatagram: maxDatagram,
+		lines:       make(chan []byte, statsdRepeaterQueueSize),
+	}, nil
+}
+
+// repeats returns whether metrics with the given name are repeated.
+func (r *statsdRepeater) repeats(name string) bool {
+	if r == nil {
+		return false
+	}
+	for _, pattern := range r.patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// repeat queues a copy of a metric's line to be sent to the
+// destination, or drops it if the queue is full.
+func (r *statsdRepeater) repeat(line []byte) {
+	if r == nil {
+		return
+	}
+	select {
+	case r.lines <- append([]byte(nil), line...):
+	default:
+		atomic.AddInt64(&r.dropped, 1)
+	}
+}
+
+// run sends the queued lines to the destination until shutdown is
+// closed, joining as many of them into each datagram as fit. Lines
+// that don't fit in a datagram on their own are dropped, since the
+// network would drop them anyway.
+func (r *statsdRepeater) run(shutdown <-chan struct{}) {
+	var conn net.Conn
+	var lastDial time.Time
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	datagram := make([]byte, 0, r.maxDatagram)
+	var next []byte
+	for {
+		if next == nil {
+			select {
+			case <-shutdown:
+				return
+			case next = <-r.lines:
+			}
+		}
+		if len(next) > r.maxDatagram {
+			atomic.AddInt64(&r.oversized, 1)
+			next = nil
+			continue
+		}
+		datagram = append(datagram[:0], next...)
+		lines := int64(1)
+		next = nil
+	batch:
+		for {
+			select {
+			case line := <-r.lines:
+				if len(datagram)+1+len(line) > r.maxDatagram {
+					// the rest go in the next datagram:
+					atomic.AddInt64(&r.split, 1)
+					next = line
+					break batch
+				}
+				datagram = append(append(datagram, '\n'), line...)
+				lines++
+			default:
+				break batch
+			}
+		}
+
+		if conn == nil {
+			if time.Since(lastDial) < statsdRepeaterRedialInterval {
+				atomic.AddInt64(&r.dropped, lines)
+				continue
+			}
+			lastDial = time.Now()
+			var err error
+			conn, err = net.Dial(r.destination.Network(), r.destination.String())
+			if err != nil {
+				log.WithError(err).WithField("destination", listenerName(r.destination)).
+					Warn("Could not dial statsd repeater destination")
+				atomic.AddInt64(&r.errors, lines)
+				conn = nil
+				continue
+			}
+		}
+		if _, err := conn.Write(datagram); err != nil {
+			atomic.AddInt64(&r.errors, lines)
+			conn.Close()
+			conn = nil
+			continue
+		}
+		atomic.AddInt64(&r.sent, lines)
+	}
+}
+
+// report returns counters of the metrics that were repeated, dropped,
+// failed to be written, or were too long to send since the last
+// report, and of the batches of metrics that were split across
+// datagrams, for those that are not zero.
+func (r *statsdRepeater) report() []*ssf.SSFSample {
+	var samples []*ssf.SSFSample
+	if n := atomic.SwapInt64(&r.sent, 0); n > 0 {
+		samples = append(samples, ssf.Count("repeater.metrics_total", float32(n), nil))
+	}
+	if n := atomic.SwapInt64(&r.dropped, 0); n > 0 {
+		samples = append(samples, ssf.Count("repeater.dropped_total", float32(n), nil,
+			ssf.Failure("repeater", ssf.CauseQueueFull)))
+	}
+	if n := atomic.SwapInt64(&r.errors, 0); n > 0 {
+		samples = append(samples, ssf.Count("repeater.errors_total", float32(n), nil,
+			ssf.Failure("repeater", ssf.CauseIOError)))
+	}
+	if n := atomic.SwapInt64(&r.oversized, 0); n > 0 {
+		samples = append(samples, ssf.Count("repeater.oversized_total", float32(n), nil,
+			ssf.Failure("repeater", ssf.CauseRejected)))
+	}
+	if n := atomic.SwapInt64(&r.split, 0); n > 0 {
+		samples = append(samples, ssf.Count("repeater.datagrams_split_total", float32(n), nil))
+	}
+	return samples
+}
+
+// logFields describes the repeater in log messages.
+func (r *statsdRepeater) logFields() logrus.Fields {
+	return logrus.Fields{
+		"destination": listenerName(r.destination),
+		"metrics":     r.patterns,
+	}
+}
@@ -0,0 +1,120 @@
+package veneur
+
+import (
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStatsdRepeater(t *testing.T) {
+	tests := []struct {
+		name        string
+		destination string
+		patterns    []string
+		maxDatagram int
+		err         bool
+	}{
+		{"udp", "udp://127.0.0.1:9125", []string{"legacy.*"}, 0, false},
+		{"unixgram", "unixgram:///tmp/legacy.sock", []string{"*"}, 8192, false},
+		{"tcp", "tcp://127.0.0.1:9125", []string{"legacy.*"}, 0, true},
+		{"no_patterns", "udp://127.0.0.1:9125", nil, 0, true},
+		{"bad_pattern", "udp://127.0.0.1:9125", []string{"legacy.[a"}, 0, true},
+		{"negative_size", "udp://127.0.0.1:9125", []string{"legacy.*"}, -1, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newStatsdRepeater(test.destination, test.patterns, test.maxDatagram)
+			if test.err {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestStatsdRepeaterRepeats(t *testing.T) {
+	r, err := newStatsdRepeater("udp://127.0.0.1:9125", []string{"legacy.*", "api.*.latency"}, 0)
+	require.NoError(t, err)
+	assert.True(t, r.repeats("legacy.requests"))
+	assert.True(t, r.repeats("api.search.latency"))
+	assert.False(t, r.repeats("api.search.requests"))
+	assert.False(t, r.repeats("new.requests"))
+
+	var nilRepeater *statsdRepeater
+	assert.False(t, nilRepeater.repeats("legacy.requests"))
+	nilRepeater.repeat([]byte("nothing"))
+}
+
+func TestStatsdRepeaterHandlePacket(t *testing.T) {
+	r, err := newStatsdRepeater("udp://127.0.0.1:9125", []string{"legacy.*"}, 0)
+	require.NoError(t, err)
+	s := &Server{statsdRepeater: r}
+	s.Workers = []*Worker{NewWorker(1, nil, logrus.New(), nil)}
+
+	for _, packet := range []string{"legacy.requests:1|c|#foo:bar", "new.requests:1|c", "_sc|legacy.check|0"} {
+		require.NoError(t, s.handleMetricPacket([]byte(packet), s.Workers))
+	}
+	// Repeated metrics are still aggregated:
+	assert.Len(t, s.Workers[0].PacketChan, 3)
+	require.Len(t, r.lines, 1)
+	assert.Equal(t, "legacy.requests:1|c|#foo:bar", string(<-r.lines))
+}
+
+func TestStatsdRepeaterRun(t *testing.T) {
+	legacy, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer legacy.Close()
+
+	const maxDatagram = 512
+	r, err := newStatsdRepeater("udp://"+legacy.LocalAddr().String(), []string{"*"}, maxDatagram)
+	require.NoError(t, err)
+
+	// A line too long for any datagram, and enough lines that they
+	// don't all fit in one:
+	r.repeat([]byte("legacy." + strings.Repeat("x", maxDatagram) + ":1|c"))
+	line := "legacy." + strings.Repeat("x", 90) + ":1|c"
+	lines := maxDatagram/len(line) + 1
+	for i := 0; i < lines; i++ {
+		buf := []byte(line)
+		r.repeat(buf)
+		// the repeater should keep its own copy:
+		buf[0] = 'x'
+	}
+	shutdown := make(chan struct{})
+	defer close(shutdown)
+	go r.run(shutdown)
+
+	buf := make([]byte, 2*maxDatagram)
+	var received []string
+	for len(received) < lines {
+		require.NoError(t, legacy.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, err := legacy.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.True(t, n <= maxDatagram)
+		received = append(received, strings.Split(string(buf[:n]), "\n")...)
+	}
+	assert.Len(t, received, lines)
+	for _, l := range received {
+		assert.Equal(t, line, l)
+	}
+
+	// the lines are counted after they're written:
+	for i := 0; atomic.LoadInt64(&r.sent) < int64(lines) && i < 1000; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	samples := r.report()
+	require.Len(t, samples, 3)
+	assert.Equal(t, "repeater.metrics_total", samples[0].Name)
+	assert.Equal(t, float32(lines), samples[0].Value)
+	assert.Equal(t, "repeater.oversized_total", samples[1].Name)
+	assert.Equal(t, float32(1), samples[1].Value)
+	assert.Equal(t, "repeater.datagrams_split_total", samples[2].Name)
+	assert.Equal(t, float32(1), samples[2].Value)
+}
@@ -0,0 +1,232 @@
+package tdigest
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// accuracyCompressions are the compression settings whose error bounds
+// are published in the README's "Approximate Histograms" section.
+var accuracyCompressions = []float64{20, 50, 100, 200, 500, 1000}
+
+// accuracyQuantiles are the quantiles that the accuracy tests check.
+var accuracyQuantiles = []float64{0.01, 0.1, 0.5, 0.9, 0.99, 0.999}
+
+// referenceDistributions are sample generators for the shapes of data
+// we commonly see in timers and histograms.
+var referenceDistributions = []struct {
+	name string
+	gen  func(r *rand.Rand) float64
+	// Whether the distribution only has few distinct values
+	discrete bool
+}{
+	{"uniform", func(r *rand.Rand) float64 { return r.Float64() }, false},
+	{"normal", func(r *rand.Rand) float64 { return r.NormFloat64() }, false},
+	{"exponential", func(r *rand.Rand) float64 { return r.ExpFloat64() }, false},
+	// Long-tailed, like request latencies:
+	{"lognormal", func(r *rand.Rand) float64 { return math.Exp(2 * r.NormFloat64()) }, false},
+	// Like latencies with millisecond resolution:
+	{"discrete", func(r *rand.Rand) float64 { return math.Floor(r.ExpFloat64() * 10) }, true},
+}
+
+// accuracyBounds are the published worst-case quantile rank errors,
+// in absolute quantile terms (0.01 is one percentile), by compression
+// and quantile. They hold for all the continuous reference
+// distributions, for single digests as well as for digests merged
+// from many others (like on a global veneur) with either Merge or
+// MergeWeighted, except where weightedAccuracyBounds says otherwise.
+var accuracyBounds = map[float64]map[float64]float64{
+	20:   {0.01: 0.015, 0.1: 0.02, 0.5: 0.02, 0.9: 0.02, 0.99: 0.01, 0.999: 0.004},
+	50:   {0.01: 0.0025, 0.1: 0.003, 0.5: 0.005, 0.9: 0.004, 0.99: 0.0025, 0.999: 0.0015},
+	100:  {0.01: 0.001, 0.1: 0.0015, 0.5: 0.003, 0.9: 0.0015, 0.99: 0.001, 0.999: 0.0005},
+	200:  {0.01: 0.0005, 0.1: 0.0005, 0.5: 0.001, 0.9: 0.001, 0.99: 0.0005, 0.999: 0.0002},
+	500:  {0.01: 0.0002, 0.1: 0.0003, 0.5: 0.0005, 0.9: 0.0005, 0.99: 0.0002, 0.999: 0.0001},
+	1000: {0.01: 0.0002, 0.1: 0.0002, 0.5: 0.0003, 0.9: 0.0002, 0.99: 0.0001, 0.999: 0.0001},
+}
+
+// weightedAccuracyBounds replace accuracyBounds for digests merged
+// with MergeWeighted where those don't hold. At low compression, every
+// merge compresses the whole merged digest again, which can move the
+// outermost centroids of a dense tail (like the exponential
+// distribution's lower one) a little more than re-adding them
+// randomly does.
+var weightedAccuracyBounds = map[float64]map[float64]float64{
+	50: {0.01: 0.003},
+}
+
+// discreteAccuracyBound is the worst-case quantile rank error for
+// data with few distinct values, at any compression: the digest
+// interpolates between centroids, so its estimates can fall between
+// two adjacent values.
+const discreteAccuracyBound = 0.01
+
+// rankError returns how far off, in quantile terms, the estimate of
+// the quantile q is from the samples' actual quantile. sorted must be
+// sorted in ascending order. Ties count as correct for any quantile
+// they span.
+func rankError(sorted []float64, q, estimate float64) float64 {
+	n := float64(len(sorted))
+	below := float64(sort.SearchFloat64s(sorted, estimate)) / n
+	atOrBelow := float64(sort.Search(len(sorted), func(i int) bool { return sorted[i] > estimate })) / n
+	switch {
+	case q < below:
+		return below - q
+	case q > atOrBelow:
+		return q - atOrBelow
+	default:
+		return 0
+	}
+}
+
+// measureAccuracy fills digests of the given compression with samples
+// from gen and returns the worst rank error for each quantile. If
+// parts is greater than 1, the samples are spread across that many
+// digests, which are then merged, with MergeWeighted if weighted is
+// set.
+func measureAccuracy(compression float64, gen func(r *rand.Rand) float64, samples, parts int, weighted bool, seed int64) map[float64]float64 {
+	r := rand.New(rand.NewSource(seed))
+	digests := make([]*MergingDigest, parts)
+	for i := range digests {
+		digests[i] = NewMerging(compression, false)
+	}
+	all := make([]float64, samples)
+	for i := range all {
+		all[i] = gen(r)
+		digests[i%parts].Add(all[i], 1)
+	}
+	sort.Float64s(all)
+
+	td := digests[0]
+	for _, other := range digests[1:] {
+		if weighted {
+			td.MergeWeighted(other)
+		} else {
+			td.Merge(other)
+		}
+	}
+
+	errs := map[float64]float64{}
+	for _, q := range accuracyQuantiles {
+		errs[q] = rankError(all, q, td.Quantile(q))
+	}
+	return errs
+}
+
+func TestRankError(t *testing.T) {
+	sorted := []float64{1, 2, 2, 2, 3}
+	assert.Equal(t, 0.0, rankError(sorted, 0.5, 2))
+	assert.InDelta(t, 0.3, rankError(sorted, 0.5, 3), 1e-9)
+	assert.InDelta(t, 0.3, rankError(sorted, 0.5, 1), 1e-9)
+}
+
+// TestAccuracyBounds checks that the t-digest's percentiles are within
+// the published error bounds for all reference distributions and
+// compression settings.
+func TestAccuracyBounds(t *testing.T) {
+	seeds := []int64{1, 2, 3}
+	if testing.Short() {
+		seeds = seeds[:1]
+	}
+
+	for _, compression := range accuracyCompressions {
+		for _, dist := range referenceDistributions {
+			for _, merge := range []struct {
+				parts    int
+				weighted bool
+			}{{1, false}, {20, false}, {20, true}} {
+				name := fmt.Sprintf("%v/%s/parts=%d/weighted=%v", compression, dist.name, merge.parts, merge.weighted)
+				t.Run(name, func(t *testing.T) {
+					for _, seed := range seeds {
+						errs := measureAccuracy(compression, dist.gen, 100000, merge.parts, merge.weighted, seed)
+						for _, q := range accuracyQuantiles {
+							bound := accuracyBounds[compression][q]
+							if b, ok := weightedAccuracyBounds[compression][q]; ok && merge.weighted {
+								bound = b
+							}
+							if dist.discrete {
+								bound = discreteAccuracyBound
+							}
+							assert.True(t, errs[q] <= bound,
+								"quantile %v is off by %v, more than the bound of %v (seed %d)",
+								q, errs[q], bound, seed)
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+// TestAccuracyImprovesWithCompression checks that raising the
+// compression never makes the digest less accurate overall.
+func TestAccuracyImprovesWithCompression(t *testing.T) {
+	for _, dist := range referenceDistributions {
+		if dist.discrete {
+			continue
+		}
+		previous := math.Inf(1)
+		for _, compression := range accuracyCompressions {
+			errs := measureAccuracy(compression, dist.gen, 50000, 1, false, 42)
+			total := 0.0
+			for _, q := range accuracyQuantiles {
+				total += errs[q]
+			}
+			assert.True(t, total <= previous*1.1,
+				"%s: compression %v had a total error of %v, previous compression had %v",
+				dist.name, compression, total, previous)
+			previous = total
+		}
+	}
+}
+
+// TestCentroidCountBound checks the memory bound that the compression
+// setting promises: a digest never keeps more than about
+// pi*compression/2 centroids.
+func TestCentroidCountBound(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, compression := range accuracyCompressions {
+		td := NewMerging(compression, true)
+		for i := 0; i < 100000; i++ {
+			td.Add(r.NormFloat64(), 1)
+		}
+		assert.True(t, len(td.Centroids()) <= int(math.Pi*compression/2+0.5),
+			"compression %v kept %d centroids", compression, len(td.Centroids()))
+	}
+}
+
+func BenchmarkAddByCompression(b *testing.B) {
+	for _, compression := range accuracyCompressions {
+		b.Run(fmt.Sprint(compression), func(b *testing.B) {
+			r := rand.New(rand.NewSource(1))
+			td := NewMerging(compression, false)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				td.Add(r.NormFloat64(), 1.0)
+			}
+		})
+	}
+}
+
+func BenchmarkMergeByCompression(b *testing.B) {
+	for _, compression := range accuracyCompressions {
+		b.Run(fmt.Sprint(compression), func(b *testing.B) {
+			r := rand.New(rand.NewSource(1))
+			other := NewMerging(compression, false)
+			for i := 0; i < 10000; i++ {
+				other.Add(r.NormFloat64(), 1.0)
+			}
+			td := NewMerging(compression, false)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				td.Merge(other)
+			}
+		})
+	}
+}
@@ -200,3 +200,32 @@ func BenchmarkQuantile(b *testing.B) {
 		td.Quantile(rand.Float64())
 	}
 }
+
+func TestMergeWeighted(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	td := NewMerging(100, false)
+	other := NewMerging(100, false)
+	for i := 0; i < 10000; i++ {
+		td.Add(r.NormFloat64(), 1.0)
+		other.Add(r.NormFloat64()+2, 1.0)
+	}
+	other.Add(100, 1.0)
+	validateMergingDigest(t, other)
+
+	errEstimate := td.MergeWeighted(other)
+	validateMergingDigest(t, td)
+	assert.True(t, errEstimate >= 0 && errEstimate < 0.01, "merge error estimate was %v", errEstimate)
+	assert.Equal(t, 20001.0, td.Count(), "weights should be kept")
+	assert.Equal(t, 100.0, td.Max(), "the other digest's exact maximum should be kept")
+	assert.InDelta(t, 1, td.Quantile(0.5), 0.05, "median was %v", td.Quantile(0.5))
+
+	// Merging is deterministic:
+	again := NewMerging(100, false)
+	again.MergeWeighted(other)
+	assert.Equal(t, other.Data(), again.Data())
+
+	// Merging an empty digest changes nothing:
+	before := td.Data().MainCentroids
+	assert.Equal(t, 0.0, td.MergeWeighted(NewMerging(100, false)))
+	assert.Equal(t, before, td.Data().MainCentroids)
+}
@@ -388,6 +388,61 @@ func (td *MergingDigest) Merge(other *MergingDigest) {
 	td.reciprocalSum = oldReciprocalSum + other.reciprocalSum
 }
 
+// mergeErrorQuantiles are the quantiles at which MergeWeighted
+// estimates the error it introduced.
+var mergeErrorQuantiles = []float64{0.01, 0.1, 0.25, 0.5, 0.75, 0.9, 0.99, 0.999}
+
+// MergeWeighted merges another digest into this one in a single pass
+// over both digests' centroids, keeping their weights and the other
+// digest's exact minimum and maximum. Unlike Merge, it does not
+// re-add the other digest's centroids as if they were samples, which
+// compresses them again in small batches in a random order.
+//
+// It returns an estimate of the error that merging introduced: the
+// largest difference, in quantile terms, between the merged digest
+// and the weighted combination of the two original digests, checked
+// at several quantiles. Neither td nor other can be shared
+// concurrently during the execution of this method.
+func (td *MergingDigest) MergeWeighted(other *MergingDigest) float64 {
+	other.mergeAllTemps()
+	if other.mainWeight == 0 {
+		return 0
+	}
+	before := td.clone()
+
+	td.mergeAllTemps()
+	temps := td.tempCentroids
+	td.tempCentroids = append(make([]Centroid, 0, len(other.mainCentroids)), other.mainCentroids...)
+	td.tempWeight = other.mainWeight
+	td.mergeAllTemps()
+	td.tempCentroids = temps[:0]
+
+	td.min = math.Min(td.min, other.min)
+	td.max = math.Max(td.max, other.max)
+	td.reciprocalSum += other.reciprocalSum
+
+	maxError := 0.0
+	for _, q := range mergeErrorQuantiles {
+		v := td.Quantile(q)
+		expected := other.CDF(v) * other.mainWeight
+		if before.mainWeight > 0 {
+			expected += before.CDF(v) * before.mainWeight
+		}
+		expected /= before.mainWeight + other.mainWeight
+		maxError = math.Max(maxError, math.Abs(expected-q))
+	}
+	return maxError
+}
+
+// clone returns a copy of td that shares no state with it.
+func (td *MergingDigest) clone() *MergingDigest {
+	td.mergeAllTemps()
+	c := *td
+	c.mainCentroids = append([]Centroid(nil), td.mainCentroids...)
+	c.tempCentroids = nil
+	return &c
+}
+
 var _ gob.GobEncoder = &MergingDigest{}
 var _ gob.GobDecoder = &MergingDigest{}
 
@@ -2,14 +2,16 @@
 // source: tdigest/tdigest.proto
 
 /*
-	Package tdigest is a generated protocol buffer package.
+Package tdigest is a generated protocol buffer package.
 
-	It is generated from these files:
-		tdigest/tdigest.proto
+It is generated from these files:
 
-	It has these top-level messages:
-		MergingDigestData
-		Centroid
+	tdigest/tdigest.proto
+
+It has these top-level messages:
+
+	MergingDigestData
+	Centroid
 */
 package tdigest
 
@@ -0,0 +1,97 @@
+package veneur
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/stripe/veneur/ssf"
+)
+
+// The kinds of data whose timestamps a timestampSkew checks.
+const (
+	skewKindSpan = iota
+	skewKindEvent
+	skewKindServiceCheck
+	skewKinds
+)
+
+var skewKindNames = [skewKinds]string{"span", "event", "service_check"}
+
+// timestampSkew checks the timestamps that clients attach to spans,
+// events and service checks against the server's clock, and counts
+// the data that's timestamped too far in the past or in the future,
+// like that from a host whose clock isn't synchronized. If it's
+// configured to, it rejects that data too, so it doesn't end up on
+// dashboards at the wrong time.
+type timestampSkew struct {
+	// maxAge and maxFuture bound how far in the past and in the
+	// future timestamps can be; 0 means unbounded.
+	maxAge    time.Duration
+	maxFuture time.Duration
+	reject    bool
+
+	// past and future count the skewed data of each kind since the
+	// last report, updated atomically
+	past   [skewKinds]int64
+	future [skewKinds]int64
+}
+
+func newTimestampSkew(maxAge, maxFuture time.Duration, reject bool) *timestampSkew {
+	return &timestampSkew{maxAge: maxAge, maxFuture: maxFuture, reject: reject}
+}
+
+// rejects checks the time range that a piece of data covers (which
+// for most data is a single point in time) against now, counts the
+// data if it's skewed, and returns whether it should be dropped.
+func (ts *timestampSkew) rejects(kind int, oldest, newest, now time.Time) bool {
+	switch {
+	case ts.maxAge > 0 && now.Sub(newest) > ts.maxAge:
+		atomic.AddInt64(&ts.past[kind], 1)
+	case ts.maxFuture > 0 && oldest.Sub(now) > ts.maxFuture:
+		atomic.AddInt64(&ts.future[kind], 1)
+	default:
+		return false
+	}
+	return ts.reject
+}
+
+// rejectsSpan checks a span's start and end timestamps. Spans without
+// timestamps, like those that only carry metrics, are never skewed.
+func (ts *timestampSkew) rejectsSpan(span *ssf.SSFSpan, now time.Time) bool {
+	if span.StartTimestamp == 0 && span.EndTimestamp == 0 {
+		return false
+	}
+	return ts.rejects(skewKindSpan, time.Unix(0, span.StartTimestamp), time.Unix(0, span.EndTimestamp), now)
+}
+
+// rejectsUnix checks a timestamp in seconds since the epoch, like
+// those of events and service checks.
+func (ts *timestampSkew) rejectsUnix(kind int, timestamp int64, now time.Time) bool {
+	t := time.Unix(timestamp, 0)
+	return ts.rejects(kind, t, t, now)
+}
+
+// report returns counters of the skewed data of each kind since the
+// last report. If skewed data is rejected, the counters are tagged as
+// failures.
+func (ts *timestampSkew) report() []*ssf.SSFSample {
+	var samples []*ssf.SSFSample
+	add := func(counts *[skewKinds]int64, direction string) {
+		for kind := range counts {
+			n := atomic.SwapInt64(&counts[kind], 0)
+			if n == 0 {
+				continue
+			}
+			tags := map[string]string{"kind": skewKindNames[kind], "direction": direction}
+			if ts.reject {
+				samples = append(samples, ssf.Count("timestamp.skewed_total", float32(n), tags,
+					ssf.Failure("timestamp", ssf.CauseClockSkew)))
+			} else {
+				samples = append(samples, ssf.Count("timestamp.skewed_total", float32(n), tags))
+			}
+		}
+	}
+	add(&ts.past, "past")
+	add(&ts.future, "future")
+	return samples
+}
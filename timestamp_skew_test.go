@@ -0,0 +1,84 @@
+package veneur
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/ssf"
+)
+
+func TestTimestampSkewRejects(t *testing.T) {
+	now := time.Now()
+	ts := newTimestampSkew(time.Hour, time.Minute, true)
+
+	tests := []struct {
+		name     string
+		oldest   time.Time
+		newest   time.Time
+		rejected bool
+	}{
+		{"current", now, now, false},
+		{"recent", now.Add(-59 * time.Minute), now.Add(-59 * time.Minute), false},
+		{"ancient", now.Add(-2 * time.Hour), now.Add(-2 * time.Hour), true},
+		{"started long ago", now.Add(-2 * time.Hour), now, false},
+		{"slightly ahead", now.Add(30 * time.Second), now.Add(30 * time.Second), false},
+		{"future", now.Add(time.Hour), now.Add(time.Hour), true},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.rejected, ts.rejects(skewKindEvent, test.oldest, test.newest, now), test.name)
+	}
+
+	samples := ts.report()
+	require.Len(t, samples, 2)
+	assert.Equal(t, "timestamp.skewed_total", samples[0].Name)
+	assert.Equal(t, "past", samples[0].Tags["direction"])
+	assert.Equal(t, "event", samples[0].Tags["kind"])
+	assert.Equal(t, ssf.CauseClockSkew, samples[0].Tags["cause"])
+	assert.Equal(t, "future", samples[1].Tags["direction"])
+	assert.Empty(t, ts.report(), "counts should be reset after reporting")
+}
+
+func TestTimestampSkewCountsOnly(t *testing.T) {
+	now := time.Now()
+	ts := newTimestampSkew(time.Hour, 0, false)
+
+	assert.False(t, ts.rejectsUnix(skewKindServiceCheck, now.Add(-2*time.Hour).Unix(), now))
+	assert.False(t, ts.rejectsUnix(skewKindServiceCheck, now.Add(24*time.Hour).Unix(), now),
+		"the future should be unbounded")
+	assert.False(t, ts.rejectsSpan(&ssf.SSFSpan{}, now), "spans without timestamps are never skewed")
+
+	samples := ts.report()
+	require.Len(t, samples, 1)
+	assert.Equal(t, float32(1), samples[0].Value)
+	assert.Equal(t, "service_check", samples[0].Tags["kind"])
+	assert.NotContains(t, samples[0].Tags, "cause", "data that isn't dropped isn't a failure")
+}
+
+func TestServerRejectsSkewedSpans(t *testing.T) {
+	config := localConfig()
+	config.TimestampMaxAge = "1h"
+	config.TimestampMaxFuture = "1m"
+	config.TimestampRejectSkewed = true
+	s := setupVeneurServer(t, config, nil, nil, nil)
+	defer s.Shutdown()
+
+	now := time.Now()
+	span := func(id int64, start time.Time) *ssf.SSFSpan {
+		return &ssf.SSFSpan{
+			Id: id, TraceId: 1, Service: "test", Name: "test",
+			StartTimestamp: start.UnixNano(),
+			EndTimestamp:   start.Add(time.Second).UnixNano(),
+		}
+	}
+
+	// Replace the span channel so the server's own spans don't
+	// interfere:
+	s.SpanChan = make(chan *ssf.SSFSpan, 3)
+	s.handleSSF(span(1, now.Add(-7*24*time.Hour)), "packet")
+	s.handleSSF(span(2, now.Add(time.Hour)), "packet")
+	s.handleSSF(span(3, now), "packet")
+	require.Len(t, s.SpanChan, 1)
+	assert.Equal(t, int64(3), (<-s.SpanChan).Id)
+}
@@ -2,9 +2,11 @@ package trace
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"io"
 	"net"
+	"sync/atomic"
 	"time"
 
 	"github.com/golang/protobuf/proto"
@@ -36,6 +38,13 @@ type backendParams struct {
 	maxBackoff     time.Duration
 	connectTimeout time.Duration
 	bufferSize     uint
+	batchSize      uint
+	batchSpans     uint
+	compress       bool
+	maxPacketSize  uint
+
+	// splits counts the spans that were sent in several packets.
+	splits *int64
 }
 
 func (p *backendParams) params() *backendParams {
@@ -90,7 +99,8 @@ type networkBackend interface {
 }
 
 // packetBackend represents a UDP connection to a veneur server. It
-// does no buffering.
+// does no buffering. If it has a maxPacketSize, it splits the metrics
+// of spans that would take up a larger packet across several packets.
 type packetBackend struct {
 	backendParams
 	conn net.Conn
@@ -118,8 +128,67 @@ func (s *packetBackend) SendSync(ctx context.Context, span *ssf.SSFSpan) error {
 	if err != nil {
 		return err
 	}
-	_, err = s.conn.Write(data)
-	return err
+	if s.maxPacketSize == 0 || uint(len(data)) <= s.maxPacketSize {
+		_, err = s.conn.Write(data)
+		return err
+	}
+
+	packets, err := splitSpan(span, int(s.maxPacketSize))
+	if err != nil {
+		return err
+	}
+	if s.splits != nil {
+		atomic.AddInt64(s.splits, 1)
+	}
+	for _, packet := range packets {
+		if _, err := s.conn.Write(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitSpan encodes a span into packets of at most size bytes, by
+// spreading its metrics across them: the first packet holds the span
+// with as many of its metrics as fit, and the others hold metrics-only
+// spans with the rest, so that the span is only reported once.
+func splitSpan(span *ssf.SSFSpan, size int) ([][]byte, error) {
+	first := *span
+	first.Metrics = nil
+	pieces := []*ssf.SSFSpan{&first}
+	// An SSFSpan with no fields set encodes to nothing, so a metric
+	// takes up the same room in every piece as in a span of its own:
+	pieceSize := proto.Size(&first)
+	if pieceSize > size {
+		return nil, ErrPacketTooLarge
+	}
+	for _, metric := range span.Metrics {
+		metricSize := proto.Size(&ssf.SSFSpan{Metrics: []*ssf.SSFSample{metric}})
+		if metricSize > size {
+			return nil, ErrPacketTooLarge
+		}
+		if pieceSize+metricSize > size {
+			pieces = append(pieces, &ssf.SSFSpan{})
+			pieceSize = 0
+		}
+		piece := pieces[len(pieces)-1]
+		piece.Metrics = append(piece.Metrics, metric)
+		pieceSize += metricSize
+	}
+
+	packets := make([][]byte, 0, len(pieces))
+	for _, piece := range pieces {
+		data, err := proto.Marshal(piece)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 {
+			// a metrics-only span whose first metric didn't fit
+			continue
+		}
+		packets = append(packets, data)
+	}
+	return packets, nil
 }
 
 var _ networkBackend = &packetBackend{}
@@ -130,6 +199,13 @@ type streamBackend struct {
 	conn   net.Conn
 	output io.Writer
 	buffer *bufio.Writer
+
+	// pending holds the spans of the batch that hasn't been written
+	// yet, if the backend writes batches.
+	pending     []*ssf.SSFSpan
+	pendingSize uint
+	// frame holds the encoded batch while it's written.
+	frame bytes.Buffer
 }
 
 func connect(ctx context.Context, s networkBackend) error {
@@ -192,12 +268,18 @@ func (ds *streamBackend) connection(conn net.Conn) {
 // connection to the upstream veneur directly. If it encounters a
 // protocol error, SendSync will return the original protocol error once
 // the connection is re-established.
+//
+// If the backend writes batches, SendSync adds the span to the pending
+// batch instead, and only writes the batch once it's large enough.
 func (ds *streamBackend) SendSync(ctx context.Context, span *ssf.SSFSpan) error {
 	if ds.conn == nil {
 		if err := connect(ctx, ds); err != nil {
 			return err
 		}
 	}
+	if ds.batchSize > 0 {
+		return ds.addToBatch(span)
+	}
 	_, err := protocol.WriteSSF(ds.output, span)
 	if err != nil {
 		if protocol.IsFramingError(err) {
@@ -208,6 +290,59 @@ func (ds *streamBackend) SendSync(ctx context.Context, span *ssf.SSFSpan) error
 	return err
 }
 
+// addToBatch adds a span to the pending batch, writing the batch first
+// if the span would make it too long to be read, and after if the span
+// makes it large enough or gives it enough spans.
+func (ds *streamBackend) addToBatch(span *ssf.SSFSpan) error {
+	size := uint(span.Size()) + uint(protocol.SSFFrameLength)
+	if ds.pendingSize > 0 && ds.pendingSize+size > uint(protocol.MaxSSFPacketLength) {
+		if err := ds.writeBatch(); err != nil {
+			return err
+		}
+	}
+	ds.pending = append(ds.pending, span)
+	ds.pendingSize += size
+	if ds.pendingSize >= ds.batchSize || (ds.batchSpans > 0 && uint(len(ds.pending)) >= ds.batchSpans) {
+		return ds.writeBatch()
+	}
+	return nil
+}
+
+// writeBatch writes the pending batch, if there is one, with a single
+// write: either as one compressed batch frame, or as the spans' frames
+// one after the other. The batch is discarded even if writing it
+// fails.
+func (ds *streamBackend) writeBatch() error {
+	if len(ds.pending) == 0 {
+		return nil
+	}
+	defer func() {
+		ds.pending = nil
+		ds.pendingSize = 0
+		ds.frame.Reset()
+	}()
+
+	if ds.compress {
+		if _, err := protocol.WriteSSFBatch(&ds.frame, ds.pending); err != nil {
+			return err
+		}
+	} else {
+		for _, span := range ds.pending {
+			if _, err := protocol.WriteSSF(&ds.frame, span); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := ds.output.Write(ds.frame.Bytes())
+	if err != nil {
+		// We have no idea how much of the batch made it, so the
+		// connection's framing may be broken. Reconnect.
+		_ = ds.conn.Close()
+		ds.conn = nil
+	}
+	return err
+}
+
 func (ds *streamBackend) Close() error {
 	if ds.conn == nil {
 		return nil
@@ -215,11 +350,11 @@ func (ds *streamBackend) Close() error {
 	return ds.conn.Close()
 }
 
-// FlushSync on a streamBackend flushes the buffer if one exists. If the
-// connection was disconnected prior to flushing, FlushSync re-establishes
-// it and discards the buffer.
+// FlushSync on a streamBackend writes the pending batch and flushes the
+// buffer if either exists. If the connection was disconnected prior to
+// flushing, FlushSync re-establishes it and discards the buffer.
 func (ds *streamBackend) FlushSync(ctx context.Context) error {
-	if ds.buffer == nil {
+	if ds.buffer == nil && len(ds.pending) == 0 {
 		return nil
 	}
 	if ds.conn == nil {
@@ -227,6 +362,12 @@ func (ds *streamBackend) FlushSync(ctx context.Context) error {
 			return err
 		}
 	}
+	if err := ds.writeBatch(); err != nil {
+		return err
+	}
+	if ds.buffer == nil {
+		return nil
+	}
 	err := ds.buffer.Flush()
 	if err != nil {
 		// buffer is poisoned, and we have no idea if the
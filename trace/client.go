@@ -48,7 +48,7 @@ type flushNotifier struct {
 // represents a pump for span packets from user code to the network
 // (whether it be UDP or streaming sockets, with or without buffers).
 //
-// Structure
+// # Structure
 //
 // A Client is composed of two parts (each with its own purpose): A
 // serialization part providing backpressure (the front end) and a
@@ -65,12 +65,15 @@ type Client struct {
 	report        func(context.Context)
 	records       chan *recordOp
 	spans         chan<- *ssf.SSFSpan
+	limiter       *spanLimiter
 
 	// statistics:
-	failedFlushes     int64
-	successfulFlushes int64
-	failedRecords     int64
-	successfulRecords int64
+	failedFlushes      int64
+	successfulFlushes  int64
+	failedRecords      int64
+	successfulRecords  int64
+	rateLimitedRecords int64
+	splitSpans         int64
 }
 
 // Close tears down the entire client. It waits until the backend has
@@ -209,6 +212,76 @@ func FlushChannel(ch <-chan time.Time, stop func()) ClientParam {
 	}
 }
 
+// CompressedBatches makes a client on a streaming (unix://) address
+// collect spans into snappy-compressed batches, and send each batch
+// once its spans take up size bytes or more when encoded, or when the
+// client gets flushed. If size is 0, the client uses DefaultBatchSize.
+// Sending many spans in batches is much cheaper, but only veneurs that
+// read batches (see protocol.ReadSSFBatch) can receive them.
+//
+// As with Buffered clients, code using batches should ensure that the
+// client gets flushed, and before it's closed; spans that are recorded
+// successfully may still be waiting for their batch to be sent.
+// Clients on packet (udp://) addresses send every span on its own.
+func CompressedBatches(size uint) ClientParam {
+	return func(cl *Client) error {
+		if cl.backendParams == nil {
+			return ErrClientNotNetworked
+		}
+		if size == 0 {
+			size = DefaultBatchSize
+		}
+		cl.backendParams.batchSize = size
+		cl.backendParams.compress = true
+		return nil
+	}
+}
+
+// BatchSpans makes a client on a streaming (unix://) address collect
+// spans into batches, and send each batch with a single write once it
+// holds count spans, once its spans take up DefaultBatchSize bytes or
+// more when encoded, or when the client gets flushed (e.g. with
+// FlushInterval). This saves the syscalls of writing every span on its
+// own, for processes that record thousands of spans per second.
+//
+// Unless the client also uses CompressedBatches, whose size limit then
+// applies too, batches are sent as the spans' frames one after the
+// other, so every veneur can read them. As with Buffered clients, code
+// using batches should ensure that the client gets flushed. Clients on
+// packet (udp://) addresses send every span on its own.
+func BatchSpans(count uint) ClientParam {
+	return func(cl *Client) error {
+		if cl.backendParams == nil {
+			return ErrClientNotNetworked
+		}
+		if cl.backendParams.batchSize == 0 {
+			cl.backendParams.batchSize = DefaultBatchSize
+		}
+		cl.backendParams.batchSpans = count
+		return nil
+	}
+}
+
+// MaxPacketSize makes a client on a packet (udp://) address send no
+// packets larger than size bytes, which networks with a small MTU (or
+// a veneur with a small trace_max_length_bytes) would drop without an
+// error. Spans that would take up a larger packet have their metrics
+// spread across several packets, and are counted in
+// trace_client.spans_split_total by ReportStatistics. Spans that can't
+// be split small enough fail to send with ErrPacketTooLarge.
+//
+// 1432 bytes fit in the MTU of most networks. If this option is not
+// used, packets can be as large as a span is.
+func MaxPacketSize(size uint) ClientParam {
+	return func(cl *Client) error {
+		if cl.backendParams == nil {
+			return ErrClientNotNetworked
+		}
+		cl.backendParams.maxPacketSize = size
+		return nil
+	}
+}
+
 // BackoffTime sets the time increment that backoff time is increased
 // (linearly) between every reconnection attempt the backend makes. If
 // this option is not used, the backend uses DefaultBackoff.
@@ -272,6 +345,22 @@ func ReportStatistics(stats *statsd.Client, interval time.Duration, tags []strin
 	}
 }
 
+// RateLimit limits how many spans per second each service (see
+// ssf.SSFSpan's Service field) can record on the client, allowing
+// bursts of up to burst spans. Spans over the limit are dropped:
+// recording them returns ErrRateLimited, and they are counted in
+// trace_client.records_rate_limited_total by ReportStatistics. This
+// keeps a pathological loop in one part of an application from
+// flooding the host's veneur, and starving the other services on it.
+//
+// Unlike most ClientParams, RateLimit applies to all kinds of clients.
+func RateLimit(spansPerSecond float64, burst uint) ClientParam {
+	return func(cl *Client) error {
+		cl.limiter = newSpanLimiter(spansPerSecond, burst)
+		return nil
+	}
+}
+
 // ParallelBackends sets the number of parallel network backend
 // connections to send spans with. Each backend holds a connection to
 // an SSF receiver open.
@@ -310,6 +399,7 @@ func NewClient(addrStr string, opts ...ClientParam) (*Client, error) {
 	cl := &Client{}
 	cl.backendParams = &backendParams{}
 	cl.backendParams.addr = addr
+	cl.backendParams.splits = &cl.splitSpans
 	cl.cap = DefaultCapacity
 	cl.nBackends = DefaultParallelism
 	for _, opt := range opts {
@@ -427,6 +517,10 @@ const DefaultCapacity = 64
 // veneur client runs in parallel.
 const DefaultParallelism = 8
 
+// DefaultBatchSize is how many bytes of encoded spans a client using
+// CompressedBatches or BatchSpans collects in a batch by default.
+const DefaultBatchSize uint = 1024 * 1024
+
 // DefaultVeneurAddress is the address that a reasonable veneur should
 // listen on. Currently it defaults to UDP port 8128.
 const DefaultVeneurAddress string = "udp://127.0.0.1:8128"
@@ -438,19 +532,46 @@ var ErrNoClient = errors.New("client is not initialized")
 // the current time.
 var ErrWouldBlock = errors.New("sending span would block")
 
+// ErrRateLimited indicates that a span was dropped because its service
+// recorded more spans than the client's RateLimit allows.
+var ErrRateLimited = errors.New("span dropped by the client's rate limit")
+
+// ErrPacketTooLarge indicates that a span couldn't be split into
+// packets no larger than the client's MaxPacketSize.
+var ErrPacketTooLarge = errors.New("span doesn't fit in the client's maximum packet size")
+
 // SendClientStatistics uses the client's recorded backpressure
 // statistics (failed/successful flushes, failed/successful records)
 // and reports them with the given statsd client, and resets the
 // statistics to zero again.
 func SendClientStatistics(cl *Client, stats *statsd.Client, tags []string) {
 	if atomic.LoadInt64(&cl.failedFlushes) != 0 {
-		stats.Count("trace_client.flushes_failed_total", atomic.SwapInt64(&cl.failedFlushes, 0), tags, 1.0)
+		stats.Count("trace_client.flushes_failed_total", atomic.SwapInt64(&cl.failedFlushes, 0),
+			append([]string{"component:trace_client", "cause:" + ssf.CauseIOError}, tags...), 1.0)
 	}
 	stats.Count("trace_client.flushes_succeeded_total", atomic.SwapInt64(&cl.successfulFlushes, 0), tags, 1.0)
 	if atomic.LoadInt64(&cl.failedRecords) != 0 {
-		stats.Count("trace_client.records_failed_total", atomic.SwapInt64(&cl.failedRecords, 0), tags, 1.0)
+		stats.Count("trace_client.records_failed_total", atomic.SwapInt64(&cl.failedRecords, 0),
+			append([]string{"component:trace_client", "cause:" + ssf.CauseQueueFull}, tags...), 1.0)
 	}
 	stats.Count("trace_client.records_succeeded_total", atomic.SwapInt64(&cl.successfulRecords, 0), tags, 1.0)
+	if atomic.LoadInt64(&cl.rateLimitedRecords) != 0 {
+		stats.Count("trace_client.records_rate_limited_total", atomic.SwapInt64(&cl.rateLimitedRecords, 0),
+			append([]string{"component:trace_client", "cause:" + ssf.CauseRateLimited}, tags...), 1.0)
+	}
+	if atomic.LoadInt64(&cl.splitSpans) != 0 {
+		stats.Count("trace_client.spans_split_total", atomic.SwapInt64(&cl.splitSpans, 0), tags, 1.0)
+	}
+}
+
+// limited returns whether the span's service is over the client's
+// rate limit, and counts the span if it is.
+func (cl *Client) limited(span *ssf.SSFSpan) bool {
+	if cl.limiter == nil || cl.limiter.allow(span.Service) {
+		return false
+	}
+	atomic.AddInt64(&cl.rateLimitedRecords, 1)
+	return true
 }
 
 // Record instructs the client to serialize and send a span. It does
@@ -458,12 +579,16 @@ func SendClientStatistics(cl *Client, stats *statsd.Client, tags []string) {
 // result from serializing and submitting the span to the channel
 // done, if it is non-nil.
 //
-// Record returns ErrNoClient if client is nil and ErrWouldBlock if
+// Record returns ErrNoClient if client is nil, ErrRateLimited if the
+// span's service is over the client's rate limit, and ErrWouldBlock if
 // the client is not able to accomodate another span.
 func Record(cl *Client, span *ssf.SSFSpan, done chan<- error) error {
 	if cl == nil {
 		return ErrNoClient
 	}
+	if cl.limited(span) {
+		return ErrRateLimited
+	}
 
 	op := &recordOp{span: span, result: done}
 	select {
@@ -482,6 +607,66 @@ func Record(cl *Client, span *ssf.SSFSpan, done chan<- error) error {
 	return ErrWouldBlock
 }
 
+// RecordSync instructs the client to serialize and send a span, and
+// waits until it is sent, or until ctx is done. It is meant for the
+// few spans whose delivery matters enough that the caller needs to
+// know it failed, e.g. to fall back to logging them on its own:
+// unlike Record, it waits for room in the client's queue instead of
+// giving up right away. If the client is buffered or sends batches,
+// RecordSync also flushes the buffer and the pending batch, so that the
+// span is written to the network before it returns.
+//
+// RecordSync returns ErrNoClient if client is nil, ErrRateLimited if
+// the span's service is over the client's rate limit, the error from
+// sending or flushing the span, or ctx's error if ctx is done first.
+// The client may still send a span after its context is done.
+func RecordSync(ctx context.Context, cl *Client, span *ssf.SSFSpan) error {
+	if cl == nil {
+		return ErrNoClient
+	}
+	if cl.limited(span) {
+		return ErrRateLimited
+	}
+
+	// The result must not block the backend if we stop waiting.
+	result := make(chan error, 1)
+	op := &recordOp{span: span, result: result}
+	select {
+	case cl.spans <- span:
+		atomic.AddInt64(&cl.successfulRecords, 1)
+		return nil
+	case cl.records <- op:
+		atomic.AddInt64(&cl.successfulRecords, 1)
+	case <-ctx.Done():
+		atomic.AddInt64(&cl.failedRecords, 1)
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-result:
+		if err != nil {
+			return err
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if cl.backendParams == nil ||
+		(cl.backendParams.bufferSize == 0 && cl.backendParams.batchSize == 0) {
+		return nil
+	}
+
+	flushed := make(chan error, 1)
+	if err := FlushAsync(cl, flushed); err != nil {
+		return err
+	}
+	select {
+	case err := <-flushed:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Flush instructs a client to flush to the upstream veneur all the
 // spans that were serialized up until the moment that the flush was
 // received. It will wait until the flush is completed (including all
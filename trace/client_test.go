@@ -1,6 +1,7 @@
 package trace
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -9,6 +10,8 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -103,6 +106,45 @@ func TestUDP(t *testing.T) {
 	}
 }
 
+func TestUDPMaxPacketSize(t *testing.T) {
+	serverConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	require.NoError(t, err)
+	defer serverConn.Close()
+
+	const maxSize = 300
+	client, err := NewClient(fmt.Sprintf("udp://%s", serverConn.LocalAddr().String()), MaxPacketSize(maxSize))
+	require.NoError(t, err)
+	defer client.Close()
+
+	span := &ssf.SSFSpan{Id: 1, TraceId: 1, Name: "split", Service: "testing"}
+	for i := 0; i < 20; i++ {
+		span.Metrics = append(span.Metrics, ssf.Count(fmt.Sprintf("a.counter.%d", i), 1, map[string]string{"purpose": "testing"}))
+	}
+	require.NoError(t, RecordSync(context.Background(), client, span))
+
+	buf := make([]byte, 65536)
+	var metrics int
+	for packets := 0; metrics < len(span.Metrics); packets++ {
+		require.NoError(t, serverConn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, err := serverConn.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.True(t, n <= maxSize, "packet of %d bytes", n)
+		received, err := protocol.ParseSSF(buf[:n])
+		require.NoError(t, err)
+		if packets == 0 {
+			assert.Equal(t, int64(1), received.Id, "the first packet should hold the span")
+		} else {
+			assert.Equal(t, int64(0), received.Id, "the other packets should only hold metrics")
+		}
+		metrics += len(received.Metrics)
+	}
+	assert.Equal(t, len(span.Metrics), metrics)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&client.splitSpans))
+
+	span.Metrics = []*ssf.SSFSample{ssf.Count("a.counter", 1, map[string]string{"huge": strings.Repeat("x", maxSize)})}
+	assert.Equal(t, ErrPacketTooLarge, RecordSync(context.Background(), client, span))
+}
+
 func TestUNIX(t *testing.T) {
 	dir, err := ioutil.TempDir("", "test_unix")
 	require.NoError(t, err)
@@ -189,6 +231,104 @@ func TestUNIXBuffered(t *testing.T) {
 	}
 }
 
+func TestUNIXCompressedBatches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test_unix")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sockName := filepath.Join(dir, "sock")
+	laddr, err := net.ResolveUnixAddr("unix", sockName)
+	require.NoError(t, err)
+
+	batches := make(chan []*ssf.SSFSpan, 4)
+	cleanup := serveUNIX(t, laddr, func(in net.Conn) {
+		for {
+			spans, err := protocol.ReadSSFBatch(in)
+			if err == io.EOF {
+				return
+			}
+			assert.NoError(t, err)
+			batches <- spans
+		}
+	})
+	defer cleanup()
+
+	client, err := NewClient((&url.URL{Scheme: "unix", Path: sockName}).String(),
+		Capacity(4),
+		ParallelBackends(1),
+		CompressedBatches(0))
+	require.NoError(t, err)
+	defer client.Close()
+
+	sentCh := make(chan error)
+	for i := 0; i < 4; i++ {
+		name := fmt.Sprintf("Testing-%d", i)
+		tr := StartTrace(name)
+		tr.Sent = sentCh
+		mustRecord(t, client, tr)
+	}
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, <-sentCh)
+	}
+	assert.Equal(t, 0, len(batches), "Should not have sent any batches yet")
+
+	mustFlush(t, client)
+	spans := <-batches
+	assert.Len(t, spans, 4, "all spans should be sent in one batch")
+}
+
+// countingWriter records the writes made to it.
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	cw.writes++
+	return cw.Buffer.Write(p)
+}
+
+func TestBatchSpans(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compress=%v", compress), func(t *testing.T) {
+			conn, other := net.Pipe()
+			defer conn.Close()
+			defer other.Close()
+			out := &countingWriter{}
+			ds := &streamBackend{
+				backendParams: backendParams{batchSize: DefaultBatchSize, batchSpans: 3, compress: compress},
+				conn:          conn,
+				output:        out,
+			}
+
+			for i := 0; i < 5; i++ {
+				span := StartTrace("batched").SSFSpan()
+				span.Name = fmt.Sprintf("span-%d", i)
+				require.NoError(t, ds.SendSync(context.Background(), span))
+			}
+			assert.Equal(t, 1, out.writes, "the first 3 spans should be written at once")
+			require.NoError(t, ds.FlushSync(context.Background()))
+			assert.Equal(t, 2, out.writes, "flushing should write the remaining spans at once")
+
+			var names []string
+			for out.Len() > 0 {
+				if compress {
+					spans, err := protocol.ReadSSFBatch(out)
+					require.NoError(t, err)
+					for _, span := range spans {
+						names = append(names, span.Name)
+					}
+				} else {
+					span, err := protocol.ReadSSF(out)
+					require.NoError(t, err)
+					names = append(names, span.Name)
+				}
+			}
+			assert.Equal(t, []string{"span-0", "span-1", "span-2", "span-3", "span-4"}, names)
+		})
+	}
+}
+
 func serveUNIX(t testing.TB, laddr *net.UnixAddr, onconnect func(conn net.Conn)) (cleanup func() error) {
 	srv, err := net.ListenUnix(laddr.Network(), laddr)
 	require.NoError(t, err)
@@ -533,3 +673,148 @@ func TestDropStatistics(t *testing.T) {
 	close(done)
 	close(blockNext)
 }
+
+type errorTestBackend struct {
+	err error
+}
+
+func (tb *errorTestBackend) Close() error {
+	return nil
+}
+
+func (tb *errorTestBackend) SendSync(ctx context.Context, span *ssf.SSFSpan) error {
+	return tb.err
+}
+
+func TestRecordSync(t *testing.T) {
+	assert.Equal(t, ErrNoClient, RecordSync(context.Background(), nil, nil))
+
+	received := make(chan *ssf.SSFSpan, 1)
+	cl, err := NewBackendClient(&testBackend{t, received}, Capacity(1))
+	require.NoError(t, err)
+	defer cl.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sent := make(chan error, 1)
+	tr := StartTrace("audit")
+	tr.Sent = sent
+	require.NoError(t, tr.ClientRecordSync(ctx, cl, "audit", nil))
+	require.Len(t, received, 1, "the span should be sent before RecordSync returns")
+	assert.Equal(t, "audit", (<-received).Name)
+	assert.NoError(t, <-sent)
+}
+
+func TestRecordSyncError(t *testing.T) {
+	failure := fmt.Errorf("connection refused")
+	cl, err := NewBackendClient(&errorTestBackend{failure})
+	require.NoError(t, err)
+	defer cl.Close()
+
+	tracer := Tracer{}
+	span := tracer.StartSpan("audit").(*Span)
+	assert.Equal(t, failure, span.ClientFinishSync(context.Background(), cl))
+}
+
+func TestRecordSyncTimeout(t *testing.T) {
+	blockNext := make(chan chan struct{}, 1)
+	tb := successTestBackend{t: t, block: blockNext}
+	cl, err := NewBackendClient(&tb, Capacity(0))
+	require.NoError(t, err)
+	defer cl.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	blockNext <- done
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = RecordSync(ctx, cl, StartTrace("audit").SSFSpan())
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestRecordSyncBuffered(t *testing.T) {
+	tests := map[string]ClientParam{
+		"buffered": Buffered,
+		"batched":  BatchSpans(10),
+	}
+	for name, param := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "test_unix")
+			require.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			sockName := filepath.Join(dir, "sock")
+			laddr, err := net.ResolveUnixAddr("unix", sockName)
+			require.NoError(t, err)
+
+			outPkg := make(chan *ssf.SSFSpan, 4)
+			cleanup := serveUNIX(t, laddr, func(in net.Conn) {
+				for {
+					pkg, err := protocol.ReadSSF(in)
+					if err == io.EOF {
+						return
+					}
+					assert.NoError(t, err)
+					outPkg <- pkg
+				}
+			})
+			defer cleanup()
+
+			client, err := NewClient((&url.URL{Scheme: "unix", Path: sockName}).String(),
+				Capacity(4),
+				ParallelBackends(1),
+				param)
+			require.NoError(t, err)
+			defer client.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			span := StartTrace("audit").SSFSpan()
+			span.Name = "audit"
+			require.NoError(t, client.RecordSync(ctx, span))
+			select {
+			case pkg := <-outPkg:
+				assert.Equal(t, "audit", pkg.Name)
+			case <-ctx.Done():
+				t.Fatal("the span should have been flushed")
+			}
+		})
+	}
+}
+
+func TestSpanLimiter(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newSpanLimiter(2, 3)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, l.allow("api"), "the burst should be allowed")
+	}
+	assert.False(t, l.allow("api"))
+	assert.True(t, l.allow("worker"), "services should be limited separately")
+
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, l.allow("api"), "a token should be added every 1/rate seconds")
+	assert.False(t, l.allow("api"))
+
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		assert.True(t, l.allow("api"))
+	}
+	assert.False(t, l.allow("api"), "tokens shouldn't accumulate beyond the burst")
+}
+
+func TestRateLimit(t *testing.T) {
+	received := make(chan *ssf.SSFSpan, 4)
+	cl, err := NewBackendClient(&testBackend{t, received}, Capacity(4), RateLimit(0.001, 1))
+	require.NoError(t, err)
+	defer cl.Close()
+
+	assert.NoError(t, Record(cl, &ssf.SSFSpan{Service: "api"}, nil))
+	assert.Equal(t, ErrRateLimited, Record(cl, &ssf.SSFSpan{Service: "api"}, nil))
+	assert.Equal(t, ErrRateLimited, RecordSync(context.Background(), cl, &ssf.SSFSpan{Service: "api"}))
+	assert.NoError(t, Record(cl, &ssf.SSFSpan{Service: "worker"}, nil))
+	assert.Equal(t, int64(2), atomic.LoadInt64(&cl.rateLimitedRecords))
+	assert.Equal(t, int64(2), atomic.LoadInt64(&cl.successfulRecords))
+}
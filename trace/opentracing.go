@@ -202,9 +202,14 @@ type Span struct {
 	logLines []opentracinglog.Field
 }
 
-// Finish ends a trace end records it with DefaultClient.
+// Finish ends a trace end records it with DefaultClient, or with the
+// Recorder of the Tracer that started it.
 func (s *Span) Finish() {
-	s.ClientFinish(DefaultClient)
+	s.FinishWithOptions(opentracing.FinishOptions{
+		FinishTime:  time.Now(),
+		LogRecords:  nil,
+		BulkLogData: nil,
+	})
 }
 
 // ClientFinish ends a trace and records it with the given Client.
@@ -222,10 +227,45 @@ func (s *Span) ClientFinish(cl *Client) {
 	})
 }
 
+// FinishSync ends a trace and records it with DefaultClient, or with
+// the Tracer's Recorder if it's a Client, waiting until it is sent or ctx is done, and returns the error from sending
+// it. Use it for the spans whose delivery the caller needs to know
+// about; see RecordSync.
+func (s *Span) FinishSync(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	if cl, ok := s.tracer.recorder.(*Client); ok {
+		return s.ClientFinishSync(ctx, cl)
+	}
+	return s.ClientFinishSync(ctx, DefaultClient)
+}
+
+// ClientFinishSync ends a trace and records it with the given Client,
+// waiting until it is sent or ctx is done, and returns the error from
+// sending it. See RecordSync.
+func (s *Span) ClientFinishSync(ctx context.Context, cl *Client) error {
+	if s == nil {
+		return nil
+	}
+	s.recordErr = s.ClientRecordSync(ctx, cl, s.Name, s.Tags)
+	return s.recordErr
+}
+
 // FinishWithOptions finishes the span, but with explicit
 // control over timestamps and log data.
 // The BulkLogData field is deprecated and ignored.
 func (s *Span) FinishWithOptions(opts opentracing.FinishOptions) {
+	// This should never happen,
+	// but calling defer span.FinishWithOptions() should always be
+	// a safe operation.
+	if s == nil {
+		return
+	}
+	if s.tracer.recorder != nil {
+		s.recordErr = s.record(s.tracer.recorder, s.Name, s.Tags)
+		return
+	}
 	s.ClientFinishWithOptions(DefaultClient, opts)
 }
 
@@ -343,8 +383,11 @@ func (s *Span) LogEventWithPayload(event string, payload interface{}) {
 func (s *Span) Log(data opentracing.LogData) {
 }
 
-// Tracer is a tracer
+// Tracer is a tracer. The zero Tracer, like GlobalTracer, uses the
+// package-level Service and DefaultClient; see NewTracer.
 type Tracer struct {
+	service  string
+	recorder Recorder
 }
 
 type spanOption struct {
@@ -454,6 +497,8 @@ func (t Tracer) StartSpan(operationName string, opts ...opentracing.StartSpanOpt
 		}
 	}
 
+	span.service = t.service
+
 	for k, v := range sso.Tags {
 		span.SetTag(k, v)
 		if k == "name" {
@@ -0,0 +1,60 @@
+package trace
+
+import (
+	"sync"
+	"time"
+)
+
+// spanLimiter limits the rate at which each service can record spans,
+// with a token bucket per service name.
+type spanLimiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mtx     sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newSpanLimiter(spansPerSecond float64, burst uint) *spanLimiter {
+	if burst == 0 {
+		burst = 1
+	}
+	return &spanLimiter{
+		rate:    spansPerSecond,
+		burst:   float64(burst),
+		now:     time.Now,
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+// allow returns whether the service may record another span now, and
+// takes a token from its bucket if so.
+func (l *spanLimiter) allow(service string) bool {
+	now := l.now()
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	b, ok := l.buckets[service]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[service] = b
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * l.rate
+		if b.tokens > l.burst {
+			b.tokens = l.burst
+		}
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -97,7 +97,15 @@ type Trace struct {
 	// For more information, see the SSF definition at https://github.com/stripe/veneur/tree/master/ssf
 	Indicator bool
 
+	// Links holds references to spans that this span is related
+	// to without being their child, like the spans that produced
+	// the messages that this span processes in a batch.
+	Links []*ssf.SSFSpanLink
+
 	error bool
+
+	// service overrides the package-level Service, if set.
+	service string
 }
 
 // Set the end timestamp and finalize Span state
@@ -148,6 +156,10 @@ func (t *Trace) Duration() time.Duration {
 // field will be invalid)
 func (t *Trace) SSFSpan() *ssf.SSFSpan {
 	name := t.Name
+	service := t.service
+	if service == "" {
+		service = Service
+	}
 
 	span := &ssf.SSFSpan{
 		StartTimestamp: t.Start.UnixNano(),
@@ -158,14 +170,25 @@ func (t *Trace) SSFSpan() *ssf.SSFSpan {
 		EndTimestamp:   t.End.UnixNano(),
 		Name:           name,
 		Tags:           t.Tags,
-		Service:        Service,
+		Service:        service,
 		Metrics:        t.Samples,
 		Indicator:      t.Indicator,
+		Links:          t.Links,
 	}
 
 	return span
 }
 
+// Link adds a link from the Trace to the span spanID in the trace
+// traceID, with attributes that describe the link.
+func (t *Trace) Link(traceID, spanID int64, attributes map[string]string) {
+	t.Links = append(t.Links, &ssf.SSFSpanLink{
+		TraceId:    traceID,
+		SpanId:     spanID,
+		Attributes: attributes,
+	})
+}
+
 // Add adds a number of metrics/samples to a Trace.
 func (t *Trace) Add(samples ...*ssf.SSFSample) {
 	t.Samples = append(t.Samples, samples...)
@@ -190,6 +213,28 @@ func (t *Trace) Record(name string, tags map[string]string) error {
 // ClientRecord uses the given client to send a trace to a veneur
 // instance.
 func (t *Trace) ClientRecord(cl *Client, name string, tags map[string]string) error {
+	return Record(cl, t.finishedSpan(name, tags), t.Sent)
+}
+
+// ClientRecordSync uses the given client to send a trace to a veneur
+// instance, and waits until it is sent or ctx is done. See the
+// package-level RecordSync function.
+func (t *Trace) ClientRecordSync(ctx context.Context, cl *Client, name string, tags map[string]string) error {
+	err := RecordSync(ctx, cl, t.finishedSpan(name, tags))
+	if t.Sent != nil {
+		go func() { t.Sent <- err }()
+	}
+	return err
+}
+
+// record uses the given Recorder to send a trace.
+func (t *Trace) record(r Recorder, name string, tags map[string]string) error {
+	return r.Record(t.finishedSpan(name, tags), t.Sent)
+}
+
+// finishedSpan ends a trace and converts it to an SSFSpan with the
+// given name (or the trace's, if it's empty) and additional tags.
+func (t *Trace) finishedSpan(name string, tags map[string]string) *ssf.SSFSpan {
 	if t.Tags == nil {
 		t.Tags = map[string]string{}
 	}
@@ -205,8 +250,7 @@ func (t *Trace) ClientRecord(cl *Client, name string, tags map[string]string) er
 
 	span := t.SSFSpan()
 	span.Name = name
-
-	return Record(cl, span, t.Sent)
+	return span
 }
 
 func (t *Trace) Error(err error) {
@@ -245,7 +289,9 @@ func SpanFromContext(c context.Context) *Trace {
 }
 
 // StartSpanFromContext is used to create a child span
-// when the parent trace is in the context
+// when the parent trace is in the context. Children of spans started
+// by a Tracer are started by the same Tracer; other spans are started
+// by the opentracing global tracer.
 func StartSpanFromContext(ctx context.Context, name string, opts ...opentracing.StartSpanOption) (s *Span, c context.Context) {
 	defer func() {
 		if r := recover(); r != nil {
@@ -262,6 +308,12 @@ func StartSpanFromContext(ctx context.Context, name string, opts ...opentracing.
 		}
 	}
 
+	// children of spans started by a Tracer from NewTracer are
+	// started by the same Tracer:
+	if parent, ok := opentracing.SpanFromContext(ctx).(*Span); ok && parent != nil {
+		return parent.tracer.StartSpanFromContext(ctx, name, opts...)
+	}
+
 	sp, c := opentracing.StartSpanFromContext(ctx, name, opts...)
 
 	s = sp.(*Span)
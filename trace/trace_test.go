@@ -128,6 +128,21 @@ func TestRecord(t *testing.T) {
 	assert.Equal(t, tags, sample.Tags)
 }
 
+func TestLink(t *testing.T) {
+	trace := StartTrace("consume")
+	trace.Link(1, 2, map[string]string{"queue": "payments"})
+	trace.Link(3, 4, nil)
+
+	sample, _ := testRecord(t, trace, "veneur.trace.test", nil)
+	require.Len(t, sample.Links, 2)
+	assert.Equal(t, int64(1), sample.Links[0].TraceId)
+	assert.Equal(t, int64(2), sample.Links[0].SpanId)
+	assert.Equal(t, map[string]string{"queue": "payments"}, sample.Links[0].Attributes)
+	assert.Equal(t, int64(3), sample.Links[1].TraceId)
+	assert.Equal(t, int64(4), sample.Links[1].SpanId)
+	assert.Empty(t, sample.Links[1].Attributes)
+}
+
 func TestRecordManualTime(t *testing.T) {
 	trace := StartTrace("test-resource")
 	end := time.Now()
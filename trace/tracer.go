@@ -0,0 +1,104 @@
+package trace
+
+import (
+	"context"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/stripe/veneur/ssf"
+)
+
+// Recorder records finished spans. *Client is a Recorder; tests of
+// code that uses a Tracer can substitute their own.
+type Recorder interface {
+	// Record sends a span like the package-level Record function
+	// does: without waiting for it to be delivered, sending the
+	// result of delivering it to done if done is non-nil.
+	Record(span *ssf.SSFSpan, done chan<- error) error
+}
+
+var _ Recorder = &Client{}
+
+// Record sends a span on the client. See the package-level Record
+// function.
+func (c *Client) Record(span *ssf.SSFSpan, done chan<- error) error {
+	return Record(c, span, done)
+}
+
+// RecordSync sends a span on the client and waits until it is sent.
+// See the package-level RecordSync function.
+func (c *Client) RecordSync(ctx context.Context, span *ssf.SSFSpan) error {
+	return RecordSync(ctx, c, span)
+}
+
+// Flush flushes the spans recorded on the client. See the
+// package-level Flush function.
+func (c *Client) Flush() error {
+	return Flush(c)
+}
+
+// TracerOption configures a Tracer constructed with NewTracer.
+type TracerOption func(*Tracer) error
+
+// NewTracer constructs a Tracer configured by opts. Unlike
+// GlobalTracer, whose spans carry the package-level Service name and
+// are recorded on DefaultClient, the spans of a Tracer constructed
+// with WithService or WithRecorder carry its own service name and are
+// recorded on its own Recorder, so that libraries can trace without
+// colliding with the package-level configuration of their users. The
+// children of its spans are started by the same Tracer.
+func NewTracer(opts ...TracerOption) (*Tracer, error) {
+	t := &Tracer{}
+	for _, opt := range opts {
+		if err := opt(t); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// WithService sets the service name of the Tracer's spans, in place
+// of the package-level Service.
+func WithService(name string) TracerOption {
+	return func(t *Tracer) error {
+		t.service = name
+		return nil
+	}
+}
+
+// WithRecorder records the Tracer's spans on r when they are finished
+// with Finish or FinishWithOptions, in place of DefaultClient.
+func WithRecorder(r Recorder) TracerOption {
+	return func(t *Tracer) error {
+		t.recorder = r
+		return nil
+	}
+}
+
+// WithVeneurAddress records the Tracer's spans on a new Client sending
+// to addrStr (an address in veneur URL format, like
+// DefaultVeneurAddress), configured with params.
+func WithVeneurAddress(addrStr string, params ...ClientParam) TracerOption {
+	return func(t *Tracer) error {
+		cl, err := NewClient(addrStr, params...)
+		if err != nil {
+			return err
+		}
+		t.recorder = cl
+		return nil
+	}
+}
+
+// StartSpanFromContext starts a span with the tracer, as a child of
+// the span in ctx if there is one, and returns it along with a copy of
+// ctx that holds it. It is the counterpart of the package-level
+// StartSpanFromContext, which starts root spans on GlobalTracer.
+func (t Tracer) StartSpanFromContext(ctx context.Context, name string, opts ...opentracing.StartSpanOption) (*Span, context.Context) {
+	if parent := opentracing.SpanFromContext(ctx); parent != nil {
+		opts = append(opts, opentracing.ChildOf(parent.Context()))
+	}
+	span := t.StartSpan(name, opts...).(*Span)
+	if name != "" {
+		span.Name = name
+	}
+	return span, span.Attach(ctx)
+}
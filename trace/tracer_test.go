@@ -0,0 +1,56 @@
+package trace
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stripe/veneur/ssf"
+)
+
+type testRecorder struct {
+	spans []*ssf.SSFSpan
+}
+
+func (r *testRecorder) Record(span *ssf.SSFSpan, done chan<- error) error {
+	r.spans = append(r.spans, span)
+	return nil
+}
+
+func TestNewTracer(t *testing.T) {
+	rec := &testRecorder{}
+	tracer, err := NewTracer(WithService("library"), WithRecorder(rec))
+	require.NoError(t, err)
+
+	root, ctx := tracer.StartSpanFromContext(context.Background(), "root")
+	child, _ := tracer.StartSpanFromContext(ctx, "child")
+	child.Finish()
+	root.Finish()
+
+	require.Len(t, rec.spans, 2)
+	assert.Equal(t, "child", rec.spans[0].Name)
+	assert.Equal(t, "root", rec.spans[1].Name)
+	for _, span := range rec.spans {
+		assert.Equal(t, "library", span.Service)
+		assert.Equal(t, root.TraceID, span.TraceId)
+	}
+	assert.Equal(t, root.SpanID, rec.spans[0].ParentId)
+
+	// children started through the package-level API keep their
+	// parent's tracer:
+	grandchild, _ := StartSpanFromContext(child.Attach(ctx), "grandchild")
+	grandchild.Finish()
+	require.Len(t, rec.spans, 3)
+	assert.Equal(t, "library", rec.spans[2].Service)
+	assert.Equal(t, child.SpanID, rec.spans[2].ParentId)
+}
+
+func TestZeroTracerUsesPackageService(t *testing.T) {
+	old := Service
+	Service = "global"
+	defer func() { Service = old }()
+
+	span := GlobalTracer.StartSpan("op").(*Span)
+	assert.Equal(t, "global", span.SSFSpan().Service)
+}
@@ -0,0 +1,208 @@
+package veneur
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stripe/veneur/protocol"
+	"github.com/stripe/veneur/ssf"
+)
+
+// trafficMirrorQueueSize is the number of packets that can wait to
+// be mirrored before more packets are dropped.
+const trafficMirrorQueueSize = 4096
+
+// trafficMirrorRedialInterval is how long a mirror waits before it
+// dials its destination again after failing to.
+const trafficMirrorRedialInterval = time.Second
+
+// trafficMirror copies the raw packets (or SSF frames) received on one
+// listener to another address, such as a candidate release of veneur,
+// so that it can be soak-tested against production traffic without
+// clients having to send their metrics twice. Packets are mirrored in
+// the background: when the destination can't keep up, they are
+// dropped rather than slowing down the listener.
+//
+// mirror is safe to call on a nil *trafficMirror, which never mirrors
+// anything.
+type trafficMirror struct {
+	listener    string
+	listenAddr  net.Addr
+	destination net.Addr
+	packets     chan []byte
+
+	// counters since the last report, updated atomically:
+	sent    int64
+	dropped int64
+	errors  int64
+}
+
+func newTrafficMirror(listener string, listenAddr, destination net.Addr) *trafficMirror {
+	return &trafficMirror{
+		listener:    listener,
+		listenAddr:  listenAddr,
+		destination: destination,
+		packets:     make(chan []byte, trafficMirrorQueueSize),
+	}
+}
+
+// mirror queues a copy of packet to be sent to the destination, or
+// drops it if the queue is full.
+func (tm *trafficMirror) mirror(packet []byte) {
+	if tm == nil {
+		return
+	}
+	select {
+	case tm.packets <- append([]byte(nil), packet...):
+	default:
+		atomic.AddInt64(&tm.dropped, 1)
+	}
+}
+
+// run sends the queued packets to the destination until shutdown is
+// closed, dialing it again whenever writing to it fails.
+func (tm *trafficMirror) run(shutdown <-chan struct{}) {
+	var conn net.Conn
+	var lastDial time.Time
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	for {
+		var packet []byte
+		select {
+		case <-shutdown:
+			return
+		case packet = <-tm.packets:
+		}
+
+		if conn == nil {
+			if time.Since(lastDial) < trafficMirrorRedialInterval {
+				atomic.AddInt64(&tm.dropped, 1)
+				continue
+			}
+			lastDial = time.Now()
+			var err error
+			conn, err = net.Dial(tm.destination.Network(), tm.destination.String())
+			if err != nil {
+				log.WithError(err).WithFields(logrus.Fields{
+					"listener":    tm.listener,
+					"destination": listenerName(tm.destination),
+				}).Warn("Could not dial traffic mirror destination")
+				atomic.AddInt64(&tm.errors, 1)
+				conn = nil
+				continue
+			}
+		}
+		if _, err := conn.Write(packet); err != nil {
+			atomic.AddInt64(&tm.errors, 1)
+			conn.Close()
+			conn = nil
+			continue
+		}
+		atomic.AddInt64(&tm.sent, 1)
+	}
+}
+
+// report returns counters of the packets that were mirrored, dropped,
+// or failed to be written since the last report, for those that are
+// not zero.
+func (tm *trafficMirror) report() []*ssf.SSFSample {
+	var samples []*ssf.SSFSample
+	tags := map[string]string{"listener": tm.listener}
+	if n := atomic.SwapInt64(&tm.sent, 0); n > 0 {
+		samples = append(samples, ssf.Count("mirror.packets_total", float32(n), tags))
+	}
+	if n := atomic.SwapInt64(&tm.dropped, 0); n > 0 {
+		samples = append(samples, ssf.Count("mirror.dropped_total", float32(n), tags,
+			ssf.Failure("mirror", ssf.CauseQueueFull)))
+	}
+	if n := atomic.SwapInt64(&tm.errors, 0); n > 0 {
+		samples = append(samples, ssf.Count("mirror.errors_total", float32(n), tags,
+			ssf.Failure("mirror", ssf.CauseIOError)))
+	}
+	return samples
+}
+
+// mirrors returns whether tm mirrors the traffic of the listener that
+// is bound to addr. A listener that was configured without an IP
+// address is bound to every address, on the same port.
+func (tm *trafficMirror) mirrors(addr net.Addr) bool {
+	switch listen := tm.listenAddr.(type) {
+	case *net.UDPAddr:
+		bound, ok := addr.(*net.UDPAddr)
+		if !ok || bound.Port != listen.Port {
+			return false
+		}
+		return listen.IP == nil || listen.IP.IsUnspecified() || listen.IP.Equal(bound.IP)
+	case *net.UnixAddr:
+		bound, ok := addr.(*net.UnixAddr)
+		return ok && bound.Net == listen.Net && bound.Name == listen.Name
+	}
+	return false
+}
+
+// newTrafficMirrors returns the traffic mirrors that the rules
+// configure. Every rule's listener must be one of listenAddrs, and its
+// destination must use the same network, so that the destination
+// receives the same kind of packets that the listener does.
+func newTrafficMirrors(rules []struct {
+	Destination string `yaml:"destination"`
+	Listener    string `yaml:"listener"`
+}, listenAddrs []string) ([]*trafficMirror, error) {
+	var mirrors []*trafficMirror
+	for _, rule := range rules {
+		found := false
+		for _, addr := range listenAddrs {
+			if addr == rule.Listener {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("traffic_mirrors: %q is not one of the statsd, SSF or combined listen addresses", rule.Listener)
+		}
+		listener, err := protocol.ResolveAddr(rule.Listener)
+		if err != nil {
+			return nil, err
+		}
+		switch listener.(type) {
+		case *net.UDPAddr, *net.UnixAddr:
+		default:
+			return nil, fmt.Errorf("traffic_mirrors: can't mirror %q, only UDP and UNIX domain socket listeners can be mirrored", rule.Listener)
+		}
+		destination, err := protocol.ResolveAddr(rule.Destination)
+		if err != nil {
+			return nil, err
+		}
+		if destination.Network() != listener.Network() {
+			return nil, fmt.Errorf("traffic_mirrors: can't mirror %s traffic from %q to %q",
+				listener.Network(), rule.Listener, rule.Destination)
+		}
+		for _, other := range mirrors {
+			if other.listener == rule.Listener {
+				return nil, fmt.Errorf("traffic_mirrors: %q is mirrored more than once", rule.Listener)
+			}
+		}
+		mirrors = append(mirrors, newTrafficMirror(rule.Listener, listener, destination))
+	}
+	return mirrors, nil
+}
+
+// trafficMirrorFor returns the traffic mirror for the listener bound
+// to addr, or nil if its traffic isn't mirrored.
+func (s *Server) trafficMirrorFor(addr net.Addr) *trafficMirror {
+	if addr == nil {
+		return nil
+	}
+	for _, tm := range s.trafficMirrors {
+		if tm.mirrors(addr) {
+			return tm
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package veneur
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mirrorRules []struct {
+	Destination string `yaml:"destination"`
+	Listener    string `yaml:"listener"`
+}
+
+func TestNewTrafficMirrors(t *testing.T) {
+	listenAddrs := []string{"udp://:8126", "tcp://127.0.0.1:8126", "unix:///tmp/veneur-ssf.sock"}
+	tests := []struct {
+		name  string
+		rules mirrorRules
+		err   bool
+	}{
+		{"udp", mirrorRules{{Listener: "udp://:8126", Destination: "udp://127.0.0.1:9126"}}, false},
+		{"unix", mirrorRules{{Listener: "unix:///tmp/veneur-ssf.sock", Destination: "unix:///tmp/candidate.sock"}}, false},
+		{"unknown_listener", mirrorRules{{Listener: "udp://:8127", Destination: "udp://127.0.0.1:9126"}}, true},
+		{"tcp", mirrorRules{{Listener: "tcp://127.0.0.1:8126", Destination: "tcp://127.0.0.1:9126"}}, true},
+		{"network_mismatch", mirrorRules{{Listener: "udp://:8126", Destination: "unix:///tmp/candidate.sock"}}, true},
+		{"duplicate", mirrorRules{
+			{Listener: "udp://:8126", Destination: "udp://127.0.0.1:9126"},
+			{Listener: "udp://:8126", Destination: "udp://127.0.0.1:9127"},
+		}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mirrors, err := newTrafficMirrors(test.rules, listenAddrs)
+			if test.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, mirrors, len(test.rules))
+		})
+	}
+}
+
+func TestTrafficMirrorFor(t *testing.T) {
+	mirrors, err := newTrafficMirrors(mirrorRules{
+		{Listener: "udp://:8126", Destination: "udp://127.0.0.1:9126"},
+		{Listener: "udp://127.0.0.1:8128", Destination: "udp://127.0.0.1:9128"},
+		{Listener: "unix:///tmp/veneur-ssf.sock", Destination: "unix:///tmp/candidate.sock"},
+	}, []string{"udp://:8126", "udp://127.0.0.1:8128", "unix:///tmp/veneur-ssf.sock"})
+	require.NoError(t, err)
+	s := &Server{trafficMirrors: mirrors}
+
+	assert.Equal(t, mirrors[0], s.trafficMirrorFor(&net.UDPAddr{IP: net.IPv6zero, Port: 8126}),
+		"a listener without an IP should match the address it's bound to")
+	assert.Equal(t, mirrors[1], s.trafficMirrorFor(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8128}))
+	assert.Nil(t, s.trafficMirrorFor(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 8128}))
+	assert.Equal(t, mirrors[2], s.trafficMirrorFor(&net.UnixAddr{Net: "unix", Name: "/tmp/veneur-ssf.sock"}))
+	assert.Nil(t, s.trafficMirrorFor(&net.UnixAddr{Net: "unix", Name: "/tmp/other.sock"}))
+	assert.Nil(t, s.trafficMirrorFor(nil))
+
+	var nilMirror *trafficMirror
+	nilMirror.mirror([]byte("nothing"))
+}
+
+func TestTrafficMirrorRun(t *testing.T) {
+	candidate, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer candidate.Close()
+
+	tm := newTrafficMirror("udp://127.0.0.1:8126", &net.UDPAddr{Port: 8126}, candidate.LocalAddr())
+	shutdown := make(chan struct{})
+	defer close(shutdown)
+	go tm.run(shutdown)
+
+	packets := []string{"a.b.c:1|c", "a.b.c:2|c\na.b.d:3|g"}
+	for _, packet := range packets {
+		buf := []byte(packet)
+		tm.mirror(buf)
+		// the mirror should keep its own copy:
+		buf[0] = 'x'
+	}
+
+	buf := make([]byte, 1024)
+	for _, expected := range packets {
+		require.NoError(t, candidate.SetReadDeadline(time.Now().Add(5*time.Second)))
+		n, _, err := candidate.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(buf[:n]))
+	}
+
+	// the packets are counted after they're written:
+	for i := 0; atomic.LoadInt64(&tm.sent) < 2 && i < 1000; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	samples := tm.report()
+	require.Len(t, samples, 1)
+	assert.Equal(t, "mirror.packets_total", samples[0].Name)
+	assert.Equal(t, float32(2), samples[0].Value)
+	assert.Equal(t, "udp://127.0.0.1:8126", samples[0].Tags["listener"])
+}
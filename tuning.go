@@ -0,0 +1,251 @@
+package veneur
+
+import (
+	"net"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// tuningGCInterval is how often the tuner aims to have the
+	// garbage collector run: GOGC is set so that the heap grows by
+	// about this much allocation between collections.
+	tuningGCInterval = time.Second
+
+	// tuningReadBufferWindow is how much traffic a UDP socket's
+	// receive buffer should be able to hold, for when the readers
+	// fall behind in bursts.
+	tuningReadBufferWindow = 250 * time.Millisecond
+
+	// minHeapBytes is the smallest heap size the Go runtime will
+	// garbage collect at, which is also the smallest live heap the
+	// tuner assumes.
+	minHeapBytes = 4 * 1024 * 1024
+)
+
+// readBufferSetter is a socket whose receive buffer size can be
+// changed, like *net.UDPConn.
+type readBufferSetter interface {
+	SetReadBuffer(bytes int) error
+}
+
+// tuner adapts the garbage collector's target, the UDP receive buffer
+// sizes and the forwarding batch size to the ingest rate and
+// allocation pressure observed by a server, within configured bounds.
+type tuner struct {
+	memoryTarget    uint64
+	gogcMin         int
+	gogcMax         int
+	readBufferMin   int
+	readBufferMax   int
+	forwardBatchMin int
+	forwardBatchMax int
+
+	mtx        sync.Mutex
+	gogc       int
+	readBuffer int
+	sockets    []readBufferSetter
+	lastSample time.Time
+	lastAlloc  uint64
+	lastBytes  int64
+}
+
+// tuningInputs are the observations the tuner bases its decisions
+// on.
+type tuningInputs struct {
+	// Bytes allocated and received on UDP sockets per second
+	allocRate    float64
+	receivedRate float64
+	// Live heap as of the last garbage collection
+	liveHeap uint64
+}
+
+// tuningDecision is what the tuner decided to set.
+type tuningDecision struct {
+	gogc         int
+	readBuffer   int
+	forwardBatch int
+}
+
+func newTuner(conf Config) *tuner {
+	t := &tuner{
+		memoryTarget:    uint64(conf.TuningMemoryTargetBytes),
+		gogcMin:         conf.TuningGogcMin,
+		gogcMax:         conf.TuningGogcMax,
+		readBufferMin:   conf.ReadBufferSizeBytes,
+		readBufferMax:   conf.TuningReadBufferMaxBytes,
+		forwardBatchMin: conf.TuningForwardBatchMin,
+		forwardBatchMax: conf.TuningForwardBatchMax,
+		readBuffer:      conf.ReadBufferSizeBytes,
+	}
+	// Find out what GOGC the process started with:
+	t.gogc = debug.SetGCPercent(-1)
+	debug.SetGCPercent(t.gogc)
+	if t.gogc < 0 {
+		t.gogc = 100
+	}
+	if t.gogcMax < t.gogcMin {
+		t.gogcMax = t.gogcMin
+	}
+	if t.readBufferMax < t.readBufferMin {
+		t.readBufferMax = t.readBufferMin
+	}
+	if t.forwardBatchMin < 1 {
+		t.forwardBatchMin = 1
+	}
+	if t.forwardBatchMax > 0 && t.forwardBatchMax < t.forwardBatchMin {
+		t.forwardBatchMax = t.forwardBatchMin
+	}
+	return t
+}
+
+// addSocket registers a UDP socket whose receive buffer the tuner
+// manages.
+func (t *tuner) addSocket(sock net.PacketConn) {
+	setter, ok := sock.(readBufferSetter)
+	if !ok {
+		return
+	}
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	t.sockets = append(t.sockets, setter)
+}
+
+// decide computes the settings for the given observations.
+func (t *tuner) decide(in tuningInputs) tuningDecision {
+	live := in.liveHeap
+	if live < minHeapBytes {
+		live = minHeapBytes
+	}
+
+	// Let the heap grow by about one GC interval's worth of
+	// allocations before collecting, but never past the memory
+	// target:
+	gogc := int(100 * in.allocRate * tuningGCInterval.Seconds() / float64(live))
+	if t.memoryTarget > 0 {
+		limit := 0
+		if t.memoryTarget > live {
+			limit = int((t.memoryTarget - live) * 100 / live)
+		}
+		if gogc > limit {
+			gogc = limit
+		}
+	}
+	d := tuningDecision{gogc: clampInt(gogc, t.gogcMin, t.gogcMax)}
+
+	t.mtx.Lock()
+	sockets := len(t.sockets)
+	t.mtx.Unlock()
+	if sockets > 0 {
+		perSocket := in.receivedRate / float64(sockets)
+		d.readBuffer = clampInt(int(perSocket*tuningReadBufferWindow.Seconds()),
+			t.readBufferMin, t.readBufferMax)
+	}
+
+	// Forwarding serializes whole batches at once; shrink them as
+	// the heap approaches the memory target:
+	if t.forwardBatchMax > 0 {
+		headroom := 1.0
+		if t.memoryTarget > 0 {
+			headroom = maxFloat(0, 1-float64(live)/float64(t.memoryTarget))
+		}
+		d.forwardBatch = t.forwardBatchMin +
+			int(float64(t.forwardBatchMax-t.forwardBatchMin)*headroom)
+	}
+	return d
+}
+
+// apply puts the decided settings in place, returning any error
+// setting the receive buffer sizes.
+func (t *tuner) apply(d tuningDecision) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if d.gogc != t.gogc {
+		debug.SetGCPercent(d.gogc)
+		t.gogc = d.gogc
+	}
+	if d.readBuffer > 0 && d.readBuffer != t.readBuffer {
+		for _, sock := range t.sockets {
+			if err := sock.SetReadBuffer(d.readBuffer); err != nil {
+				return err
+			}
+		}
+		t.readBuffer = d.readBuffer
+	}
+	return nil
+}
+
+// observe turns cumulative counters into the tuner's inputs.
+func (t *tuner) observe(now time.Time, mem *runtime.MemStats, receivedBytes int64) (tuningInputs, bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	in := tuningInputs{
+		liveHeap: mem.NextGC * 100 / uint64(100+t.gogc),
+	}
+	first := t.lastSample.IsZero()
+	elapsed := now.Sub(t.lastSample).Seconds()
+	if !first && elapsed > 0 {
+		in.allocRate = float64(mem.TotalAlloc-t.lastAlloc) / elapsed
+		in.receivedRate = float64(receivedBytes-t.lastBytes) / elapsed
+	}
+	t.lastSample = now
+	t.lastAlloc = mem.TotalAlloc
+	t.lastBytes = receivedBytes
+	return in, !first && elapsed > 0
+}
+
+// tune runs one round of adaptive tuning, if it is enabled. It is
+// called on every flush with the memory statistics read for it.
+func (s *Server) tune(mem *runtime.MemStats) {
+	if s.tuner == nil {
+		return
+	}
+	in, ok := s.tuner.observe(time.Now(), mem, atomic.LoadInt64(&s.receivedBytes))
+	if !ok {
+		return
+	}
+	d := s.tuner.decide(in)
+	if err := s.tuner.apply(d); err != nil {
+		log.WithError(err).WithField("bytes", d.readBuffer).
+			Warn("Could not set UDP receive buffer size")
+	}
+	atomic.StoreInt64(&s.forwardBatchSize, int64(d.forwardBatch))
+
+	s.Statsd.Gauge("tuning.gogc", float64(d.gogc), nil, 1.0)
+	s.Statsd.Gauge("tuning.read_buffer_bytes", float64(d.readBuffer), nil, 1.0)
+	s.Statsd.Gauge("tuning.forward_batch_size", float64(d.forwardBatch), nil, 1.0)
+	s.Statsd.Gauge("tuning.alloc_bytes_per_second", in.allocRate, nil, 1.0)
+}
+
+// forwardBatches splits n metrics into the batches that forwarding
+// should send, as [start, end) index pairs.
+func (s *Server) forwardBatches(n int) [][2]int {
+	size := int(atomic.LoadInt64(&s.forwardBatchSize))
+	if size <= 0 || size >= n {
+		return [][2]int{{0, n}}
+	}
+	batches := make([][2]int, 0, n/size+1)
+	for start := 0; start < n; start += size {
+		end := start + size
+		if end > n {
+			end = n
+		}
+		batches = append(batches, [2]int{start, end})
+	}
+	return batches
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
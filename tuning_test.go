@@ -0,0 +1,118 @@
+package veneur
+
+import (
+	"runtime/debug"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeReadBufferSetter struct {
+	size int
+}
+
+func (f *fakeReadBufferSetter) SetReadBuffer(bytes int) error {
+	f.size = bytes
+	return nil
+}
+
+func testTuner() *tuner {
+	return newTuner(Config{
+		ReadBufferSizeBytes:      1024,
+		TuningGogcMin:            50,
+		TuningGogcMax:            400,
+		TuningReadBufferMaxBytes: 8192,
+		TuningForwardBatchMin:    100,
+		TuningForwardBatchMax:    1000,
+	})
+}
+
+func TestTunerGOGC(t *testing.T) {
+	tn := testTuner()
+	const mb = 1024 * 1024
+
+	// A small heap allocating quickly can afford to grow a lot:
+	d := tn.decide(tuningInputs{liveHeap: 10 * mb, allocRate: 20 * mb})
+	assert.Equal(t, 200, d.gogc)
+
+	d = tn.decide(tuningInputs{liveHeap: 10 * mb, allocRate: 100 * mb})
+	assert.Equal(t, 400, d.gogc, "should be capped at tuning_gogc_max")
+
+	d = tn.decide(tuningInputs{liveHeap: 1000 * mb, allocRate: 10 * mb})
+	assert.Equal(t, 50, d.gogc, "should be at least tuning_gogc_min")
+
+	// Tiny heaps count as the runtime's minimum heap size:
+	d = tn.decide(tuningInputs{liveHeap: 1, allocRate: 4 * mb})
+	assert.Equal(t, 100, d.gogc)
+
+	// The memory target caps how far the heap may grow:
+	tn.memoryTarget = 15 * mb
+	d = tn.decide(tuningInputs{liveHeap: 10 * mb, allocRate: 20 * mb})
+	assert.Equal(t, 50, d.gogc)
+	tn.memoryTarget = 12 * mb
+	d = tn.decide(tuningInputs{liveHeap: 10 * mb, allocRate: 20 * mb})
+	assert.Equal(t, 50, d.gogc, "should be at least tuning_gogc_min even near the memory target")
+	tn.gogcMin = 10
+	d = tn.decide(tuningInputs{liveHeap: 10 * mb, allocRate: 20 * mb})
+	assert.Equal(t, 20, d.gogc)
+}
+
+func TestTunerReadBuffer(t *testing.T) {
+	tn := testTuner()
+	d := tn.decide(tuningInputs{receivedRate: 100000})
+	assert.Equal(t, 0, d.readBuffer, "without sockets, there's nothing to size")
+
+	socks := []*fakeReadBufferSetter{{}, {}}
+	for _, sock := range socks {
+		tn.sockets = append(tn.sockets, sock)
+	}
+
+	d = tn.decide(tuningInputs{receivedRate: 40000})
+	assert.Equal(t, 5000, d.readBuffer, "each socket should hold a quarter second of its traffic")
+	require.NoError(t, tn.apply(d))
+	for _, sock := range socks {
+		assert.Equal(t, 5000, sock.size)
+	}
+
+	d = tn.decide(tuningInputs{receivedRate: 10})
+	assert.Equal(t, 1024, d.readBuffer, "should not shrink below read_buffer_size_bytes")
+	d = tn.decide(tuningInputs{receivedRate: 1000000})
+	assert.Equal(t, 8192, d.readBuffer, "should not grow beyond tuning_read_buffer_max_bytes")
+}
+
+func TestTunerForwardBatch(t *testing.T) {
+	tn := testTuner()
+	const mb = 1024 * 1024
+
+	d := tn.decide(tuningInputs{liveHeap: 10 * mb})
+	assert.Equal(t, 1000, d.forwardBatch, "without a memory target, batches should be as large as allowed")
+
+	tn.memoryTarget = 40 * mb
+	d = tn.decide(tuningInputs{liveHeap: 10 * mb})
+	assert.Equal(t, 775, d.forwardBatch)
+	d = tn.decide(tuningInputs{liveHeap: 50 * mb})
+	assert.Equal(t, 100, d.forwardBatch)
+
+	tn.forwardBatchMax = 0
+	d = tn.decide(tuningInputs{liveHeap: 10 * mb})
+	assert.Equal(t, 0, d.forwardBatch, "forwards should not be split by default")
+}
+
+func TestTunerApplyGOGC(t *testing.T) {
+	original := debug.SetGCPercent(100)
+	defer debug.SetGCPercent(original)
+
+	tn := testTuner()
+	require.NoError(t, tn.apply(tuningDecision{gogc: 150}))
+	assert.Equal(t, 150, debug.SetGCPercent(100))
+}
+
+func TestForwardBatches(t *testing.T) {
+	s := &Server{}
+	assert.Equal(t, [][2]int{{0, 10}}, s.forwardBatches(10))
+
+	s.forwardBatchSize = 4
+	assert.Equal(t, [][2]int{{0, 4}, {4, 8}, {8, 10}}, s.forwardBatches(10))
+	assert.Equal(t, [][2]int{{0, 4}}, s.forwardBatches(4))
+}
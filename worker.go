@@ -1,6 +1,7 @@
 package veneur
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
@@ -39,17 +40,48 @@ type Worker struct {
 	logger           *logrus.Logger
 	wm               WorkerMetrics
 	stats            *statsd.Client
+	schemas          *schemaRegistry
+	priorities       *metricPriorities
+	scopes           *metricScopes
+	blocklist        *metricBlocklist
+	accounting       *workerAccounting
 }
 
 // IngestUDP on a Worker feeds the metric into the worker's PacketChan.
+// If the worker has a blocklist, it first drops the metric if the
+// blocklist blocks it, and overrides its scope if the worker's scope
+// rules match it. If the worker assigns priorities, it then classifies
+// the metric, and sheds it if its priority is too low for how busy the
+// worker is.
 func (w *Worker) IngestUDP(metric samplers.UDPMetric) {
+	if w.blocklist != nil && w.blocklist.blocks(metric.Name, metric.Tags) {
+		return
+	}
+	if w.scopes != nil {
+		w.scopes.override(&metric)
+	}
+	if w.priorities != nil {
+		metric.Priority = w.priorities.classify(metric.Name, metric.Tags, metric.Priority)
+		if w.priorities.shed(metric.Priority, len(w.PacketChan), cap(w.PacketChan)) {
+			return
+		}
+	}
+	w.ingest(1)
 	w.PacketChan <- metric
 }
 
 func (w *Worker) IngestMetrics(ms []*metricpb.Metric) {
+	w.ingest(len(ms))
 	w.ImportMetricChan <- ms
 }
 
+// IngestJSON feeds metrics imported from another veneur into the
+// worker's ImportChan.
+func (w *Worker) IngestJSON(ms []samplers.JSONMetric) {
+	w.ingest(len(ms))
+	w.ImportChan <- ms
+}
+
 // WorkerMetrics is just a plain struct bundling together the flushed contents of a worker
 type WorkerMetrics struct {
 	// we do not want to key on the metric's Digest here, because those could
@@ -75,6 +107,24 @@ type WorkerMetrics struct {
 	localSets         map[samplers.MetricKey]*samplers.Set
 	localTimers       map[samplers.MetricKey]*samplers.Histo
 	localStatusChecks map[samplers.MetricKey]*samplers.StatusCheck
+
+	// the number of metrics that were processed or imported since
+	// the previous flush
+	ingested int64
+
+	// the t-digest compression of new histograms and timers, or
+	// zero for samplers.DefaultHistogramCompression
+	histogramCompression float64
+	// whether new histograms and timers merge imported t-digests
+	// with their weights
+	weightedDigestMerging bool
+	// whether new counters report their sample counts and sample
+	// rate corrections
+	counterSummaries bool
+
+	// the accounting of the samples since the previous flush, if the
+	// worker is accounting
+	accounting *accountingReport
 }
 
 // NewWorkerMetrics initializes a WorkerMetrics struct
@@ -105,11 +155,11 @@ func (wm WorkerMetrics) Upsert(mk samplers.MetricKey, Scope samplers.MetricScope
 	case counterTypeName:
 		if Scope == samplers.GlobalOnly {
 			if _, present = wm.globalCounters[mk]; !present {
-				wm.globalCounters[mk] = samplers.NewCounter(mk.Name, tags)
+				wm.globalCounters[mk] = wm.newCounter(mk.Name, tags)
 			}
 		} else {
 			if _, present = wm.counters[mk]; !present {
-				wm.counters[mk] = samplers.NewCounter(mk.Name, tags)
+				wm.counters[mk] = wm.newCounter(mk.Name, tags)
 			}
 		}
 	case gaugeTypeName:
@@ -125,15 +175,15 @@ func (wm WorkerMetrics) Upsert(mk samplers.MetricKey, Scope samplers.MetricScope
 	case histogramTypeName:
 		if Scope == samplers.LocalOnly {
 			if _, present = wm.localHistograms[mk]; !present {
-				wm.localHistograms[mk] = samplers.NewHist(mk.Name, tags)
+				wm.localHistograms[mk] = wm.newHist(mk.Name, tags)
 			}
 		} else if Scope == samplers.GlobalOnly {
 			if _, present = wm.globalHistograms[mk]; !present {
-				wm.globalHistograms[mk] = samplers.NewHist(mk.Name, tags)
+				wm.globalHistograms[mk] = wm.newHist(mk.Name, tags)
 			}
 		} else {
 			if _, present = wm.histograms[mk]; !present {
-				wm.histograms[mk] = samplers.NewHist(mk.Name, tags)
+				wm.histograms[mk] = wm.newHist(mk.Name, tags)
 			}
 		}
 	case setTypeName:
@@ -149,15 +199,15 @@ func (wm WorkerMetrics) Upsert(mk samplers.MetricKey, Scope samplers.MetricScope
 	case timerTypeName:
 		if Scope == samplers.LocalOnly {
 			if _, present = wm.localTimers[mk]; !present {
-				wm.localTimers[mk] = samplers.NewHist(mk.Name, tags)
+				wm.localTimers[mk] = wm.newHist(mk.Name, tags)
 			}
 		} else if Scope == samplers.GlobalOnly {
 			if _, present = wm.globalTimers[mk]; !present {
-				wm.globalTimers[mk] = samplers.NewHist(mk.Name, tags)
+				wm.globalTimers[mk] = wm.newHist(mk.Name, tags)
 			}
 		} else {
 			if _, present = wm.timers[mk]; !present {
-				wm.timers[mk] = samplers.NewHist(mk.Name, tags)
+				wm.timers[mk] = wm.newHist(mk.Name, tags)
 			}
 		}
 	case statusTypeName:
@@ -170,6 +220,111 @@ func (wm WorkerMetrics) Upsert(mk samplers.MetricKey, Scope samplers.MetricScope
 	return !present
 }
 
+// setUnit records the unit of the metric with the given key and scope,
+// which must have been upserted already. Sets and status checks don't
+// have units.
+func (wm WorkerMetrics) setUnit(mk samplers.MetricKey, scope samplers.MetricScope, unit string) {
+	if unit == "" {
+		return
+	}
+	switch mk.Type {
+	case counterTypeName:
+		if scope == samplers.GlobalOnly {
+			wm.globalCounters[mk].Unit = unit
+		} else {
+			wm.counters[mk].Unit = unit
+		}
+	case gaugeTypeName:
+		if scope == samplers.GlobalOnly {
+			wm.globalGauges[mk].Unit = unit
+		} else {
+			wm.gauges[mk].Unit = unit
+		}
+	case histogramTypeName:
+		if scope == samplers.LocalOnly {
+			wm.localHistograms[mk].Unit = unit
+		} else if scope == samplers.GlobalOnly {
+			wm.globalHistograms[mk].Unit = unit
+		} else {
+			wm.histograms[mk].Unit = unit
+		}
+	case timerTypeName:
+		if scope == samplers.LocalOnly {
+			wm.localTimers[mk].Unit = unit
+		} else if scope == samplers.GlobalOnly {
+			wm.globalTimers[mk].Unit = unit
+		} else {
+			wm.timers[mk].Unit = unit
+		}
+	}
+}
+
+// setPriority records the priority class of the metric with the given
+// key and scope, which must have been upserted already. The last
+// priority other than normal that a metric is sampled with sticks.
+func (wm WorkerMetrics) setPriority(mk samplers.MetricKey, scope samplers.MetricScope, priority samplers.Priority) {
+	if priority == samplers.PriorityNormal {
+		return
+	}
+	switch mk.Type {
+	case counterTypeName:
+		if scope == samplers.GlobalOnly {
+			wm.globalCounters[mk].Priority = priority
+		} else {
+			wm.counters[mk].Priority = priority
+		}
+	case gaugeTypeName:
+		if scope == samplers.GlobalOnly {
+			wm.globalGauges[mk].Priority = priority
+		} else {
+			wm.gauges[mk].Priority = priority
+		}
+	case histogramTypeName:
+		if scope == samplers.LocalOnly {
+			wm.localHistograms[mk].Priority = priority
+		} else if scope == samplers.GlobalOnly {
+			wm.globalHistograms[mk].Priority = priority
+		} else {
+			wm.histograms[mk].Priority = priority
+		}
+	case setTypeName:
+		if scope == samplers.LocalOnly {
+			wm.localSets[mk].Priority = priority
+		} else {
+			wm.sets[mk].Priority = priority
+		}
+	case timerTypeName:
+		if scope == samplers.LocalOnly {
+			wm.localTimers[mk].Priority = priority
+		} else if scope == samplers.GlobalOnly {
+			wm.globalTimers[mk].Priority = priority
+		} else {
+			wm.timers[mk].Priority = priority
+		}
+	case statusTypeName:
+		wm.localStatusChecks[mk].Priority = priority
+	}
+}
+
+// newCounter creates a counter that reports summaries if configured.
+func (wm WorkerMetrics) newCounter(name string, tags []string) *samplers.Counter {
+	c := samplers.NewCounter(name, tags)
+	c.Summaries = wm.counterSummaries
+	return c
+}
+
+// newHist creates a histogram or timer with the configured
+// compression and merge mode.
+func (wm WorkerMetrics) newHist(name string, tags []string) *samplers.Histo {
+	compression := float64(samplers.DefaultHistogramCompression)
+	if wm.histogramCompression > 0 {
+		compression = wm.histogramCompression
+	}
+	h := samplers.NewHistWithCompression(name, tags, compression)
+	h.WeightedMerge = wm.weightedDigestMerging
+	return h
+}
+
 // ForwardableMetrics converts all metrics that should be forwarded to
 // metricpb.Metric (protobuf-compatible).
 func (wm WorkerMetrics) ForwardableMetrics(cl *trace.Client) []*metricpb.Metric {
@@ -223,7 +378,7 @@ func (wm WorkerMetrics) appendExportedMetric(res []*metricpb.Metric, exp metricE
 		metrics.ReportOne(cl,
 			ssf.Count("worker_metrics.export_metric.errors", 1, map[string]string{
 				"type": mType.String(),
-			}),
+			}, ssf.Failure("worker", ssf.CauseEncodeError)),
 		)
 		return res
 	}
@@ -250,6 +405,59 @@ func NewWorker(id int, cl *trace.Client, logger *logrus.Logger, stats *statsd.Cl
 	}
 }
 
+// setHistogramCompression sets the t-digest compression of the
+// histograms and timers that the worker creates from now on.
+func (w *Worker) setHistogramCompression(compression float64) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.wm.histogramCompression = compression
+}
+
+// setCounterSummaries sets whether the counters that the worker
+// creates from now on report their sample counts and sample rate
+// corrections.
+func (w *Worker) setCounterSummaries(summaries bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.wm.counterSummaries = summaries
+}
+
+// setSchemaRegistry makes the worker check every metric it processes
+// against the schemas in r, and drop the ones r rejects. It must be
+// called before the worker starts working.
+func (w *Worker) setSchemaRegistry(r *schemaRegistry) {
+	w.schemas = r
+}
+
+// setMetricPriorities makes the worker classify the metrics it ingests
+// with p, and shed them by priority. It must be called before the
+// worker starts working.
+func (w *Worker) setMetricPriorities(p *metricPriorities) {
+	w.priorities = p
+}
+
+// setMetricScopes makes the worker override the scopes of the metrics
+// it ingests with s. It must be called before the worker starts
+// working.
+func (w *Worker) setMetricScopes(s *metricScopes) {
+	w.scopes = s
+}
+
+// setMetricBlocklist makes the worker drop the metrics it ingests
+// that b blocks. It must be called before the worker starts working.
+func (w *Worker) setMetricBlocklist(b *metricBlocklist) {
+	w.blocklist = b
+}
+
+// setWeightedDigestMerging sets whether the histograms and timers
+// that the worker creates from now on merge imported t-digests with
+// their weights.
+func (w *Worker) setWeightedDigestMerging(weighted bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.wm.weightedDigestMerging = weighted
+}
+
 // Work will start the worker listening for metrics to process or import.
 // It will not return until the worker is sent a message to terminate using Stop()
 func (w *Worker) Work() {
@@ -257,14 +465,17 @@ func (w *Worker) Work() {
 		select {
 		case m := <-w.PacketChan:
 			w.ProcessMetric(&m)
+			w.settle(1)
 		case m := <-w.ImportChan:
 			for _, j := range m {
 				w.ImportMetric(j)
 			}
+			w.settle(len(m))
 		case ms := <-w.ImportMetricChan:
 			for _, m := range ms {
 				w.ImportMetricGRPC(m)
 			}
+			w.settle(len(ms))
 		case <-w.QuitChan:
 			// We have been asked to stop.
 			log.WithField("worker", w.id).Error("Stopping")
@@ -284,10 +495,20 @@ func (w *Worker) MetricsProcessedCount() int64 {
 
 // ProcessMetric takes a Metric and samples it
 func (w *Worker) ProcessMetric(m *samplers.UDPMetric) {
+	if w.schemas != nil && !w.schemas.check(m) {
+		if w.accounting != nil {
+			w.mutex.Lock()
+			w.reject()
+			w.mutex.Unlock()
+		}
+		return
+	}
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 	w.processed++
 	w.wm.Upsert(m.MetricKey, m.Scope, m.Tags)
+	w.wm.setUnit(m.MetricKey, m.Scope, m.Unit)
+	w.wm.setPriority(m.MetricKey, m.Scope, m.Priority)
 
 	switch m.Type {
 	case counterTypeName:
@@ -304,11 +525,11 @@ func (w *Worker) ProcessMetric(m *samplers.UDPMetric) {
 		}
 	case histogramTypeName:
 		if m.Scope == samplers.LocalOnly {
-			w.wm.localHistograms[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
+			sampleHisto(w.wm.localHistograms[m.MetricKey], m)
 		} else if m.Scope == samplers.GlobalOnly {
-			w.wm.globalHistograms[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
+			sampleHisto(w.wm.globalHistograms[m.MetricKey], m)
 		} else {
-			w.wm.histograms[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
+			sampleHisto(w.wm.histograms[m.MetricKey], m)
 		}
 	case setTypeName:
 		if m.Scope == samplers.LocalOnly {
@@ -318,11 +539,11 @@ func (w *Worker) ProcessMetric(m *samplers.UDPMetric) {
 		}
 	case timerTypeName:
 		if m.Scope == samplers.LocalOnly {
-			w.wm.localTimers[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
+			sampleHisto(w.wm.localTimers[m.MetricKey], m)
 		} else if m.Scope == samplers.GlobalOnly {
-			w.wm.globalTimers[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
+			sampleHisto(w.wm.globalTimers[m.MetricKey], m)
 		} else {
-			w.wm.timers[m.MetricKey].Sample(m.Value.(float64), m.SampleRate)
+			sampleHisto(w.wm.timers[m.MetricKey], m)
 		}
 	case statusTypeName:
 		v := float64(m.Value.(ssf.SSFSample_Status))
@@ -332,55 +553,106 @@ func (w *Worker) ProcessMetric(m *samplers.UDPMetric) {
 	}
 }
 
-// ImportMetric receives a metric from another veneur instance
+// importedPriority returns the priority class of a metric imported
+// from another veneur: the one it was forwarded with, or the one this
+// worker's rules assign.
+func (w *Worker) importedPriority(name string, tags []string, priority samplers.Priority) samplers.Priority {
+	if w.priorities == nil {
+		return priority
+	}
+	return w.priorities.classify(name, tags, priority)
+}
+
+// sampleHisto samples a histogram or timer metric into h.
+func sampleHisto(h *samplers.Histo, m *samplers.UDPMetric) {
+	h.Sample(m.Value.(float64), m.SampleRate)
+	if m.Exemplar != nil {
+		h.SampleExemplar(*m.Exemplar)
+	}
+}
+
+// combineHisto merges an imported histogram or timer, and its
+// exemplar, into h.
+func combineHisto(h *samplers.Histo, other samplers.JSONMetric) error {
+	if err := h.Combine(other.Value); err != nil {
+		return err
+	}
+	if other.Exemplar != nil {
+		h.SampleExemplar(*other.Exemplar)
+	}
+	return nil
+}
+
+// ImportMetric receives a metric from another veneur instance. If the
+// worker has a blocklist, it drops the metric if the blocklist blocks
+// it.
 func (w *Worker) ImportMetric(other samplers.JSONMetric) {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
-	// we don't increment the processed metric counter here, it was already
-	// counted by the original veneur that sent this to us
-	w.imported++
+	if w.blocklist != nil && w.blocklist.blocks(other.Name, other.Tags) {
+		w.reject()
+		return
+	}
+
+	scope := samplers.MixedScope
 	if other.Type == counterTypeName || other.Type == gaugeTypeName {
 		// this is an odd special case -- counters that are imported are global
-		w.wm.Upsert(other.MetricKey, samplers.GlobalOnly, other.Tags)
-	} else {
-		w.wm.Upsert(other.MetricKey, samplers.MixedScope, other.Tags)
+		scope = samplers.GlobalOnly
 	}
+	w.wm.Upsert(other.MetricKey, scope, other.Tags)
+	w.wm.setUnit(other.MetricKey, scope, other.Unit)
+	w.wm.setPriority(other.MetricKey, scope, w.importedPriority(other.Name, other.Tags, other.Priority))
 
+	var err error
 	switch other.Type {
 	case counterTypeName:
-		if err := w.wm.globalCounters[other.MetricKey].Combine(other.Value); err != nil {
+		if err = w.wm.globalCounters[other.MetricKey].Combine(other.Value); err != nil {
 			log.WithError(err).Error("Could not merge counters")
 		}
 	case gaugeTypeName:
-		if err := w.wm.globalGauges[other.MetricKey].Combine(other.Value); err != nil {
+		if err = w.wm.globalGauges[other.MetricKey].Combine(other.Value); err != nil {
 			log.WithError(err).Error("Could not merge gauges")
 		}
 	case setTypeName:
-		if err := w.wm.sets[other.MetricKey].Combine(other.Value); err != nil {
+		if err = w.wm.sets[other.MetricKey].Combine(other.Value); err != nil {
 			log.WithError(err).Error("Could not merge sets")
 		}
 	case histogramTypeName:
-		if err := w.wm.histograms[other.MetricKey].Combine(other.Value); err != nil {
+		if err = combineHisto(w.wm.histograms[other.MetricKey], other); err != nil {
 			log.WithError(err).Error("Could not merge histograms")
 		}
 	case timerTypeName:
-		if err := w.wm.timers[other.MetricKey].Combine(other.Value); err != nil {
+		if err = combineHisto(w.wm.timers[other.MetricKey], other); err != nil {
 			log.WithError(err).Error("Could not merge timers")
 		}
 	default:
+		err = fmt.Errorf("unknown metric type %q", other.Type)
 		log.WithField("type", other.Type).Error("Unknown metric type for importing")
 	}
+	if err != nil {
+		w.reject()
+		return
+	}
+	// we don't increment the processed metric counter here, it was already
+	// counted by the original veneur that sent this to us
+	w.imported++
 }
 
 // ImportMetricGRPC receives a metric from another veneur instance over gRPC.
 //
 // In practice, this is only called when in the aggregation tier, so we don't
-// handle LocalOnly scope.
+// handle LocalOnly scope. If the worker has a blocklist, it drops the
+// metric if the blocklist blocks it.
 func (w *Worker) ImportMetricGRPC(other *metricpb.Metric) (err error) {
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
+	if w.blocklist != nil && w.blocklist.blocks(other.Name, other.Tags) {
+		w.reject()
+		return nil
+	}
+
 	key := samplers.NewMetricKeyFromMetric(other)
 
 	scope := samplers.ScopeFromPB(other.Scope)
@@ -389,11 +661,13 @@ func (w *Worker) ImportMetricGRPC(other *metricpb.Metric) (err error) {
 	}
 
 	if scope == samplers.LocalOnly {
+		w.reject()
 		return fmt.Errorf("gRPC import does not accept local metrics")
 	}
 
 	w.wm.Upsert(key, scope, other.Tags)
-	w.imported++
+	w.wm.setUnit(key, scope, other.Unit)
+	w.wm.setPriority(key, scope, w.importedPriority(other.Name, other.Tags, samplers.Priority(other.Priority)))
 
 	switch v := other.GetValue().(type) {
 	case *metricpb.Metric_Counter:
@@ -402,7 +676,7 @@ func (w *Worker) ImportMetricGRPC(other *metricpb.Metric) (err error) {
 		w.wm.globalGauges[key].Merge(v.Gauge)
 	case *metricpb.Metric_Set:
 		if merr := w.wm.sets[key].Merge(v.Set); merr != nil {
-			err = fmt.Errorf("could not merge a set: %v", err)
+			err = fmt.Errorf("could not merge a set: %v", merr)
 		}
 	case *metricpb.Metric_Histogram:
 		switch other.Type {
@@ -431,9 +705,11 @@ func (w *Worker) ImportMetricGRPC(other *metricpb.Metric) (err error) {
 			"name":     other.Name,
 			"protocol": "grpc",
 		}).Error("Failed to import a metric")
+		w.reject()
+		return err
 	}
-
-	return err
+	w.imported++
+	return nil
 }
 
 // Flush resets the worker's internal metrics and returns their contents.
@@ -444,13 +720,20 @@ func (w *Worker) Flush() WorkerMetrics {
 	wm := NewWorkerMetrics()
 	w.mutex.Lock()
 	ret := w.wm
+	wm.histogramCompression = ret.histogramCompression
+	wm.weightedDigestMerging = ret.weightedDigestMerging
+	wm.counterSummaries = ret.counterSummaries
 	processed := w.processed
 	imported := w.imported
 
 	w.wm = wm
 	w.processed = 0
 	w.imported = 0
+	if w.accounting != nil {
+		ret.accounting = w.accounting.report(processed, imported)
+	}
 	w.mutex.Unlock()
+	ret.ingested = processed + imported
 
 	w.stats.Count("worker.metrics_processed_total", processed, []string{}, 1.0)
 	w.stats.Count("worker.metrics_imported_total", imported, []string{}, 1.0)
@@ -520,6 +803,16 @@ type SpanWorker struct {
 	commonTags map[string]string
 	sinks      []sinks.SpanSink
 
+	// sinkQueues, if non-nil, holds one queue per sink, which
+	// decouples the sinks' ingestion from each other.
+	sinkQueues []*spanSinkQueue
+
+	// pauses, if non-nil, holds the pause state of each sink.
+	pauses []*sinkPause
+
+	// canary, if non-nil, measures the latency of canary spans.
+	canary *canary
+
 	// cumulative time spent per sink, in nanoseconds
 	cumulativeTimes []int64
 	traceClient     *trace.Client
@@ -527,6 +820,15 @@ type SpanWorker struct {
 	capCount        int64
 }
 
+// spanSinkQueue holds the spans that are waiting to be ingested by a
+// single span sink.
+type spanSinkQueue struct {
+	spans chan *ssf.SSFSpan
+	// dropped counts the spans that didn't fit in the queue since
+	// the last flush, updated atomically
+	dropped int64
+}
+
 // NewSpanWorker creates a SpanWorker ready to collect events and service checks.
 func NewSpanWorker(sinks []sinks.SpanSink, cl *trace.Client, statsd *statsd.Client, spanChan <-chan *ssf.SSFSpan, commonTags map[string]string) *SpanWorker {
 	tags := make([]map[string]string, len(sinks))
@@ -547,10 +849,52 @@ func NewSpanWorker(sinks []sinks.SpanSink, cl *trace.Client, statsd *statsd.Clie
 	}
 }
 
+// setSinkQueues gives each sink a queue of spans that can hold size
+// spans. Work then hands spans to the queues instead of waiting for
+// every sink to ingest them, and drops the spans that don't fit in a
+// sink's queue, so that a slow sink only holds up its own spans. The
+// queues must be drained by calling drainSinkQueue for each sink. It
+// must be called before the worker starts working.
+func (tw *SpanWorker) setSinkQueues(size int) {
+	tw.sinkQueues = make([]*spanSinkQueue, len(tw.sinks))
+	for i := range tw.sinks {
+		tw.sinkQueues[i] = &spanSinkQueue{spans: make(chan *ssf.SSFSpan, size)}
+	}
+}
+
+// setSinkPauses has the worker skip the sinks that are paused: paused
+// sinks aren't flushed and don't ingest spans, which are buffered or
+// dropped instead. The buffered spans are ingested at the first flush
+// after the sink is resumed. It must be called before the worker starts
+// working.
+func (tw *SpanWorker) setSinkPauses(p *sinkPauses) {
+	tw.pauses = make([]*sinkPause, len(tw.sinks))
+	for i, sink := range tw.sinks {
+		tw.pauses[i] = p.lookup(sink.Name())
+	}
+}
+
+// drainSinkQueue has the i-th sink ingest the spans in its queue.
+// This function will never return.
+func (tw *SpanWorker) drainSinkQueue(i int) {
+	for span := range tw.sinkQueues[i].spans {
+		tw.ingest(i, span)
+	}
+}
+
+// pending returns the number of spans that the worker received but
+// didn't hand to its sinks yet.
+func (tw *SpanWorker) pending() int {
+	n := len(tw.SpanChan)
+	for _, q := range tw.sinkQueues {
+		n += len(q.spans)
+	}
+	return n
+}
+
 // Work will start the SpanWorker listening for spans.
 // This function will never return.
 func (tw *SpanWorker) Work() {
-	const Timeout = 9 * time.Second
 	capcmp := cap(tw.SpanChan) - 1
 	for m := range tw.SpanChan {
 		// If we are at or one below cap, increment the counter.
@@ -558,6 +902,10 @@ func (tw *SpanWorker) Work() {
 			atomic.AddInt64(&tw.capCount, 1)
 		}
 
+		if tw.canary != nil {
+			tw.canary.observeSpan(m, time.Now())
+		}
+
 		if m.Tags == nil && len(tw.commonTags) != 0 {
 			m.Tags = make(map[string]string, len(tw.commonTags))
 		}
@@ -568,61 +916,84 @@ func (tw *SpanWorker) Work() {
 			}
 		}
 
+		if tw.sinkQueues != nil {
+			for _, q := range tw.sinkQueues {
+				select {
+				case q.spans <- m:
+				default:
+					atomic.AddInt64(&q.dropped, 1)
+				}
+			}
+			continue
+		}
+
 		var wg sync.WaitGroup
-		for i, s := range tw.sinks {
-			tags := tw.sinkTags[i]
+		for i := range tw.sinks {
 			wg.Add(1)
-			go func(i int, sink sinks.SpanSink, span *ssf.SSFSpan, wg *sync.WaitGroup) {
+			go func(i int, span *ssf.SSFSpan, wg *sync.WaitGroup) {
 				defer wg.Done()
+				tw.ingest(i, span)
+			}(i, m, &wg)
+		}
+		wg.Wait()
+	}
+}
 
-				done := make(chan struct{})
-				start := time.Now()
-
-				go func() {
-					// Give each sink a change to ingest.
-					err := sink.Ingest(span)
-					if err != nil {
-						if _, isNoTrace := err.(*protocol.InvalidTrace); !isNoTrace {
-							// If a sink goes wacko and errors a lot, we stand to emit a
-							// loooot of metrics towards all span workers here since
-							// span ingest rates can be very high. C'est la vie.
-							t := make([]string, 0, len(tags)+1)
-							for k, v := range tags {
-								t = append(t, k+":"+v)
-							}
-
-							t = append(t, "sink:"+sink.Name())
-							tw.statsd.Incr("worker.span.ingest_error_total", t, 1.0)
-						}
-					}
-					done <- struct{}{}
-				}()
+// ingest gives the i-th sink a chance to ingest a span, and gives up
+// waiting for it after a timeout.
+func (tw *SpanWorker) ingest(i int, span *ssf.SSFSpan) {
+	const Timeout = 9 * time.Second
+	sink := tw.sinks[i]
+	tags := tw.sinkTags[i]
+	if tw.pauses != nil && tw.pauses[i].holdSpan(span) {
+		return
+	}
 
-				select {
-				case _ = <-done:
-				case <-time.After(Timeout):
-					log.WithFields(logrus.Fields{
-						"sink":  sink.Name(),
-						"index": i,
-					}).Error("Timed out on sink ingestion")
-
-					t := make([]string, 0, len(tags)+1)
-					for k, v := range tags {
-						t = append(t, k+":"+v)
-					}
-
-					t = append(t, "sink:"+sink.Name())
-					tw.statsd.Incr("worker.span.ingest_timeout_total", t, 1.0)
+	done := make(chan struct{})
+	start := time.Now()
+
+	go func() {
+		// Give each sink a change to ingest.
+		err := sink.Ingest(span)
+		if err != nil {
+			if _, isNoTrace := err.(*protocol.InvalidTrace); !isNoTrace {
+				// If a sink goes wacko and errors a lot, we stand to emit a
+				// loooot of metrics towards all span workers here since
+				// span ingest rates can be very high. C'est la vie.
+				t := make([]string, 0, len(tags)+1)
+				for k, v := range tags {
+					t = append(t, k+":"+v)
 				}
-				atomic.AddInt64(&tw.cumulativeTimes[i], int64(time.Since(start)/time.Nanosecond))
-			}(i, s, m, &wg)
+
+				t = append(t, "sink:"+sink.Name())
+				tw.statsd.Incr("worker.span.ingest_error_total", t, 1.0)
+			}
 		}
-		wg.Wait()
+		done <- struct{}{}
+	}()
+
+	select {
+	case _ = <-done:
+	case <-time.After(Timeout):
+		log.WithFields(logrus.Fields{
+			"sink":  sink.Name(),
+			"index": i,
+		}).Error("Timed out on sink ingestion")
+
+		t := make([]string, 0, len(tags)+1)
+		for k, v := range tags {
+			t = append(t, k+":"+v)
+		}
+
+		t = append(t, "sink:"+sink.Name())
+		tw.statsd.Incr("worker.span.ingest_timeout_total", t, 1.0)
 	}
+	atomic.AddInt64(&tw.cumulativeTimes[i], int64(time.Since(start)/time.Nanosecond))
 }
 
-// Flush invokes flush on each sink.
-func (tw *SpanWorker) Flush() {
+// Flush invokes flush on each sink, with a context that carries the
+// flush's deadline.
+func (tw *SpanWorker) Flush(ctx context.Context) {
 	samples := &ssf.Samples{}
 
 	// Flush and time each sink.
@@ -631,13 +1002,26 @@ func (tw *SpanWorker) Flush() {
 		for k, v := range tw.sinkTags[i] {
 			tags = append(tags, fmt.Sprintf("%s:%s", k, v))
 		}
-		sinkFlushStart := time.Now()
-		s.Flush()
-		tw.statsd.Timing("worker.span.flush_duration_ns", time.Since(sinkFlushStart), tags, 1.0)
+		if tw.pauses == nil || !tw.pauses[i].isPaused() {
+			if tw.pauses != nil {
+				for _, span := range tw.pauses[i].spansToIngest() {
+					tw.ingest(i, span)
+				}
+			}
+			sinkFlushStart := time.Now()
+			s.Flush(ctx)
+			tw.statsd.Timing("worker.span.flush_duration_ns", time.Since(sinkFlushStart), tags, 1.0)
+		}
 
 		// cumulative time is measured in nanoseconds
 		cumulative := time.Duration(atomic.SwapInt64(&tw.cumulativeTimes[i], 0)) * time.Nanosecond
 		tw.statsd.Timing(sinks.MetricKeySpanIngestDuration, cumulative, tags, 1.0)
+
+		if tw.sinkQueues != nil {
+			q := tw.sinkQueues[i]
+			tw.statsd.Gauge("worker.span.sink_queue_length", float64(len(q.spans)), tags, 1.0)
+			tw.statsd.Count("worker.span.sink_queue_dropped_total", atomic.SwapInt64(&q.dropped, 0), tags, 1.0)
+		}
 	}
 
 	metrics.Report(tw.traceClient, samples)
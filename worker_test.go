@@ -4,6 +4,7 @@ import (
 	"context"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -122,6 +123,111 @@ func TestWorkerImportHistogram(t *testing.T) {
 	assert.Len(t, wm.histograms, 1, "number of flushed histograms")
 }
 
+func TestWorkerImportTimerExemplar(t *testing.T) {
+	w := NewWorker(1, nil, logrus.New(), nil)
+	timer := samplers.NewHist("a.b.c", nil)
+	timer.Sample(2.0, 1.0)
+	timer.SampleExemplar(samplers.Exemplar{TraceID: 1, SpanID: 2, Value: 2.0})
+
+	jsonMetric, err := timer.Export()
+	assert.NoError(t, err, "should have exported successfully")
+	jsonMetric.Type = "timer"
+	w.ImportMetric(jsonMetric)
+
+	wm := w.Flush()
+	require.Len(t, wm.timers, 1, "number of flushed timers")
+	for _, imported := range wm.timers {
+		assert.Equal(t, &samplers.Exemplar{TraceID: 1, SpanID: 2, Value: 2.0}, imported.Exemplar)
+	}
+}
+
+func TestWorkerHistogramCompression(t *testing.T) {
+	w := NewWorker(1, nil, logrus.New(), nil)
+	w.setHistogramCompression(500)
+
+	for i := 0; i < 2; i++ {
+		w.ProcessMetric(&samplers.UDPMetric{
+			MetricKey:  samplers.MetricKey{Name: "a.b.c", Type: "histogram"},
+			Value:      1.0,
+			Digest:     12345,
+			SampleRate: 1.0,
+			Scope:      samplers.MixedScope,
+		})
+		wm := w.Flush()
+		require.Len(t, wm.histograms, 1, "number of flushed histograms")
+		for _, h := range wm.histograms {
+			assert.Equal(t, 500.0, h.Value.Data().Compression,
+				"flush %d should keep the configured compression", i)
+		}
+	}
+}
+
+func TestWorkerWeightedDigestMerging(t *testing.T) {
+	w := NewWorker(1, nil, logrus.New(), nil)
+	w.setWeightedDigestMerging(true)
+
+	h := samplers.NewHist("a.b.c", nil)
+	for i := 0; i < 100; i++ {
+		h.Sample(float64(i), 1.0)
+	}
+	m, err := h.Metric()
+	require.NoError(t, err)
+	m.Scope = metricpb.Scope_Mixed
+
+	for i := 0; i < 2; i++ {
+		require.NoError(t, w.ImportMetricGRPC(m))
+		require.NoError(t, w.ImportMetricGRPC(m))
+		wm := w.Flush()
+		require.Len(t, wm.histograms, 1, "number of flushed histograms")
+		for _, h := range wm.histograms {
+			assert.True(t, h.WeightedMerge, "flush %d should keep merging with weights", i)
+			assert.Equal(t, 200.0, h.Value.Count())
+			assert.Equal(t, 99.0, h.Value.Max())
+		}
+	}
+}
+
+func TestWorkerUnits(t *testing.T) {
+	w := NewWorker(1, nil, logrus.New(), nil)
+	w.ProcessMetric(&samplers.UDPMetric{
+		MetricKey:  samplers.MetricKey{Name: "a.b.c", Type: "timer"},
+		Value:      5.0,
+		SampleRate: 1.0,
+		Scope:      samplers.LocalOnly,
+		Unit:       "ms",
+	})
+	wm := w.Flush()
+	require.Len(t, wm.localTimers, 1)
+	for _, h := range wm.localTimers {
+		assert.Equal(t, "ms", h.Unit)
+	}
+
+	h := samplers.NewHist("a.b.c", nil)
+	h.Unit = "ms"
+	h.Sample(5, 1.0)
+	m, err := h.Metric()
+	require.NoError(t, err)
+	m.Type = metricpb.Type_Timer
+	require.NoError(t, w.ImportMetricGRPC(m))
+
+	c := samplers.NewCounter("d.e.f", nil)
+	c.Unit = "B"
+	c.Sample(1, 1.0)
+	jm, err := c.Export()
+	require.NoError(t, err)
+	w.ImportMetric(jm)
+
+	wm = w.Flush()
+	require.Len(t, wm.timers, 1)
+	for _, h := range wm.timers {
+		assert.Equal(t, "ms", h.Unit, "the unit of forwarded timers should be kept")
+	}
+	require.Len(t, wm.globalCounters, 1)
+	for _, c := range wm.globalCounters {
+		assert.Equal(t, "B", c.Unit, "the unit of imported counters should be kept")
+	}
+}
+
 func TestWorkerStatusMetric(t *testing.T) {
 	w := NewWorker(1, nil, logrus.New(), nil)
 
@@ -236,6 +342,53 @@ func TestSpanWorkerTagApplication(t *testing.T) {
 	close(quitch)
 }
 
+// blockingSpanSink blocks on ingesting spans until it's unblocked.
+type blockingSpanSink struct {
+	unblock chan struct{}
+}
+
+func (s *blockingSpanSink) Start(*trace.Client) error { return nil }
+func (s *blockingSpanSink) Name() string              { return "blocking" }
+func (s *blockingSpanSink) Flush(context.Context)     {}
+func (s *blockingSpanSink) Ingest(span *ssf.SSFSpan) error {
+	<-s.unblock
+	return nil
+}
+
+func TestSpanWorkerSinkQueues(t *testing.T) {
+	blocking := &blockingSpanSink{unblock: make(chan struct{})}
+	defer close(blocking.unblock)
+	fake := &fakeSpanSink{wg: &sync.WaitGroup{}}
+	spanChan := make(chan *ssf.SSFSpan)
+
+	sw := NewSpanWorker([]sinks.SpanSink{blocking, fake}, nil, nil, spanChan, nil)
+	sw.setSinkQueues(1)
+	go sw.drainSinkQueue(0)
+	go sw.drainSinkQueue(1)
+	go sw.Work()
+
+	const nSpans = 5
+	for i := 0; i < nSpans; i++ {
+		fake.wg.Add(1)
+		spanChan <- &ssf.SSFSpan{TraceId: 1, Id: int64(i + 1), Name: "span"}
+		// wait for the unblocked sink, so that its queue never
+		// fills up:
+		fake.wg.Wait()
+		if i == 0 {
+			// and for the blocked sink to take the first span:
+			for len(sw.sinkQueues[0].spans) > 0 {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}
+
+	assert.Len(t, fake.spans, nSpans, "the blocked sink shouldn't hold up the other one")
+	assert.Equal(t, int64(0), atomic.LoadInt64(&sw.sinkQueues[1].dropped))
+	// the blocked sink holds one span, and its queue another:
+	assert.Equal(t, int64(nSpans-2), atomic.LoadInt64(&sw.sinkQueues[0].dropped),
+		"the spans that don't fit in the blocked sink's queue should be dropped")
+}
+
 type fakeSpanSink struct {
 	wg    *sync.WaitGroup
 	spans []*ssf.SSFSpan
@@ -243,7 +396,7 @@ type fakeSpanSink struct {
 
 func (s *fakeSpanSink) Start(*trace.Client) error { return nil }
 func (s *fakeSpanSink) Name() string              { return "fake" }
-func (s *fakeSpanSink) Flush()                    {}
+func (s *fakeSpanSink) Flush(context.Context)     {}
 func (s *fakeSpanSink) latestSpan() *ssf.SSFSpan  { return s.spans[len(s.spans)-1] }
 func (s *fakeSpanSink) Ingest(span *ssf.SSFSpan) error {
 	s.spans = append(s.spans, span)
@@ -444,3 +597,21 @@ func TestWorkerMetricsForwardableMetrics(t *testing.T) {
 		})
 	}
 }
+
+func TestWorkerCounterSummaries(t *testing.T) {
+	w := NewWorker(1, nil, logrus.New(), nil)
+	w.setCounterSummaries(true)
+	for i := 0; i < 2; i++ {
+		w.ProcessMetric(&samplers.UDPMetric{
+			MetricKey:  samplers.MetricKey{Name: "a.b.c", Type: "counter"},
+			Value:      1.0,
+			SampleRate: 0.01,
+			Scope:      samplers.MixedScope,
+		})
+		wm := w.Flush()
+		require.Len(t, wm.counters, 1)
+		for _, c := range wm.counters {
+			assert.True(t, c.Summaries, "flush %d should keep reporting summaries", i)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package xdp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// eBPF instruction encoding, see linux/bpf_common.h and linux/bpf.h.
+const (
+	bpfLdDW   = 0x18 // BPF_LD | BPF_IMM | BPF_DW
+	bpfLdxW   = 0x61 // BPF_LDX | BPF_MEM | BPF_W
+	bpfLdxH   = 0x69 // BPF_LDX | BPF_MEM | BPF_H
+	bpfLdxB   = 0x71 // BPF_LDX | BPF_MEM | BPF_B
+	bpfAddImm = 0x07 // BPF_ALU64 | BPF_ADD | BPF_K
+	bpfMovImm = 0xb7 // BPF_ALU64 | BPF_MOV | BPF_K
+	bpfMovReg = 0xbf // BPF_ALU64 | BPF_MOV | BPF_X
+	bpfJa     = 0x05 // BPF_JMP | BPF_JA
+	bpfJeqImm = 0x15 // BPF_JMP | BPF_JEQ | BPF_K
+	bpfJgtReg = 0x2d // BPF_JMP | BPF_JGT | BPF_X
+	bpfJsetIm = 0x45 // BPF_JMP | BPF_JSET | BPF_K
+	bpfJneImm = 0x55 // BPF_JMP | BPF_JNE | BPF_K
+	bpfCall   = 0x85 // BPF_JMP | BPF_CALL
+	bpfExit   = 0x95 // BPF_JMP | BPF_EXIT
+
+	bpfPseudoMapFD = 1
+
+	bpfFuncRedirectMap = 51
+
+	xdpPass = 2
+)
+
+// bpf(2) commands, map types, program types and attach types.
+const (
+	bpfMapCreate     = 0
+	bpfMapUpdateElem = 2
+	bpfProgLoad      = 5
+	bpfLinkCreate    = 28
+
+	bpfMapTypeXSKMap = 17
+	bpfProgTypeXDP   = 6
+	bpfAttachXDP     = 37
+)
+
+// Offsets in the packet and in struct xdp_md.
+const (
+	ethHeaderLen  = 14
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+	udpHeaderLen  = 8
+
+	xdpMdData          = 0
+	xdpMdDataEnd       = 4
+	xdpMdRxQueueIndex  = 16
+	ethTypeOffset      = 12
+	ipv4VersionOffset  = ethHeaderLen
+	ipv4FragOffset     = ethHeaderLen + 6
+	ipv4ProtocolOffset = ethHeaderLen + 9
+	ipv6NextHdrOffset  = ethHeaderLen + 6
+	udpDstPortOffset   = 2
+
+	ipProtoUDP = 17
+)
+
+type insn struct {
+	code     uint8
+	dst, src uint8
+	off      int16
+	imm      int32
+
+	// the label this (jump) instruction jumps to, resolved into
+	// off by assemble.
+	target string
+}
+
+// asm is a minimal eBPF assembler that supports forward jumps to
+// labels.
+type asm struct {
+	insns  []insn
+	labels map[string]int
+}
+
+func (a *asm) emit(i insn) {
+	a.insns = append(a.insns, i)
+}
+
+func (a *asm) label(name string) {
+	a.labels[name] = len(a.insns)
+}
+
+func (a *asm) assemble() ([]byte, error) {
+	buf := make([]byte, 0, len(a.insns)*8)
+	for pc, i := range a.insns {
+		if i.target != "" {
+			to, ok := a.labels[i.target]
+			if !ok {
+				return nil, fmt.Errorf("undefined label %q", i.target)
+			}
+			i.off = int16(to - pc - 1)
+		}
+		var raw [8]byte
+		raw[0] = i.code
+		raw[1] = i.src<<4 | i.dst
+		nativeEndian.PutUint16(raw[2:], uint16(i.off))
+		nativeEndian.PutUint32(raw[4:], uint32(i.imm))
+		buf = append(buf, raw[:]...)
+	}
+	return buf, nil
+}
+
+// nativeEndian is the byte order of the machine we run on, which
+// is the byte order that eBPF programs use.
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+// htons returns the value that a 16-bit load from network-order
+// memory with the value v yields.
+func htons(v uint16) int32 {
+	var buf [2]byte
+	binary.BigEndian.PutUint16(buf[:], v)
+	return int32(nativeEndian.Uint16(buf[:]))
+}
+
+// redirectProgram returns an XDP program that redirects IPv4 and
+// IPv6 UDP datagrams addressed to one of the given ports into the
+// AF_XDP socket registered in the XSKMAP for the receive queue the
+// datagram arrived on. Everything else (including IPv4 fragments and
+// IPv4 headers with options) is passed on to the kernel.
+func redirectProgram(xskMapFD int, ports []int) ([]byte, error) {
+	a := &asm{labels: map[string]int{}}
+	const (
+		r0 = iota
+		r1
+		r2
+		r3
+		r4
+		r5
+		r6
+	)
+
+	// r6 = ctx, r2 = data, r3 = data_end
+	a.emit(insn{code: bpfMovReg, dst: r6, src: r1})
+	a.emit(insn{code: bpfLdxW, dst: r2, src: r1, off: xdpMdData})
+	a.emit(insn{code: bpfLdxW, dst: r3, src: r1, off: xdpMdDataEnd})
+
+	// Every packet we're interested in is at least as large as an
+	// IPv4 UDP header.
+	a.emit(insn{code: bpfMovReg, dst: r4, src: r2})
+	a.emit(insn{code: bpfAddImm, dst: r4, imm: ethHeaderLen + ipv4HeaderLen + udpHeaderLen})
+	a.emit(insn{code: bpfJgtReg, dst: r4, src: r3, target: "pass"})
+
+	a.emit(insn{code: bpfLdxH, dst: r5, src: r2, off: ethTypeOffset})
+	a.emit(insn{code: bpfJeqImm, dst: r5, imm: htons(0x86dd), target: "ipv6"})
+	a.emit(insn{code: bpfJneImm, dst: r5, imm: htons(0x0800), target: "pass"})
+
+	// IPv4, without options, not fragmented, UDP:
+	a.emit(insn{code: bpfLdxB, dst: r5, src: r2, off: ipv4VersionOffset})
+	a.emit(insn{code: bpfJneImm, dst: r5, imm: 0x45, target: "pass"})
+	a.emit(insn{code: bpfLdxH, dst: r5, src: r2, off: ipv4FragOffset})
+	a.emit(insn{code: bpfJsetIm, dst: r5, imm: htons(0x3fff), target: "pass"})
+	a.emit(insn{code: bpfLdxB, dst: r5, src: r2, off: ipv4ProtocolOffset})
+	a.emit(insn{code: bpfJneImm, dst: r5, imm: ipProtoUDP, target: "pass"})
+	a.emit(insn{code: bpfLdxH, dst: r5, src: r2, off: ethHeaderLen + ipv4HeaderLen + udpDstPortOffset})
+	a.emit(insn{code: bpfJa, target: "port"})
+
+	// IPv6, with UDP as the first next header:
+	a.label("ipv6")
+	a.emit(insn{code: bpfMovReg, dst: r4, src: r2})
+	a.emit(insn{code: bpfAddImm, dst: r4, imm: ethHeaderLen + ipv6HeaderLen + udpHeaderLen})
+	a.emit(insn{code: bpfJgtReg, dst: r4, src: r3, target: "pass"})
+	a.emit(insn{code: bpfLdxB, dst: r5, src: r2, off: ipv6NextHdrOffset})
+	a.emit(insn{code: bpfJneImm, dst: r5, imm: ipProtoUDP, target: "pass"})
+	a.emit(insn{code: bpfLdxH, dst: r5, src: r2, off: ethHeaderLen + ipv6HeaderLen + udpDstPortOffset})
+
+	a.label("port")
+	for _, port := range ports {
+		a.emit(insn{code: bpfJeqImm, dst: r5, imm: htons(uint16(port)), target: "redirect"})
+	}
+	a.emit(insn{code: bpfJa, target: "pass"})
+
+	// return bpf_redirect_map(&xsks, ctx->rx_queue_index, XDP_PASS)
+	a.label("redirect")
+	a.emit(insn{code: bpfLdxW, dst: r2, src: r6, off: xdpMdRxQueueIndex})
+	a.emit(insn{code: bpfLdDW, dst: r1, src: bpfPseudoMapFD, imm: int32(xskMapFD)})
+	a.emit(insn{})
+	a.emit(insn{code: bpfMovImm, dst: r3, imm: xdpPass})
+	a.emit(insn{code: bpfCall, imm: bpfFuncRedirectMap})
+	a.emit(insn{code: bpfExit})
+
+	a.label("pass")
+	a.emit(insn{code: bpfMovImm, dst: r0, imm: xdpPass})
+	a.emit(insn{code: bpfExit})
+
+	return a.assemble()
+}
+
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(fd), nil
+}
+
+type mapCreateAttr struct {
+	mapType    uint32
+	keySize    uint32
+	valueSize  uint32
+	maxEntries uint32
+	mapFlags   uint32
+}
+
+func createXSKMap(entries int) (int, error) {
+	attr := mapCreateAttr{
+		mapType:    bpfMapTypeXSKMap,
+		keySize:    4,
+		valueSize:  4,
+		maxEntries: uint32(entries),
+	}
+	fd, err := bpf(bpfMapCreate, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil {
+		return 0, fmt.Errorf("xdp: creating XSKMAP: %v", err)
+	}
+	return fd, nil
+}
+
+type mapUpdateAttr struct {
+	mapFD uint32
+	_     uint32
+	key   uint64
+	value uint64
+	flags uint64
+}
+
+func updateXSKMap(mapFD int, queue int, socketFD int) error {
+	key := uint32(queue)
+	value := uint32(socketFD)
+	attr := mapUpdateAttr{
+		mapFD: uint32(mapFD),
+		key:   uint64(uintptr(unsafe.Pointer(&key))),
+		value: uint64(uintptr(unsafe.Pointer(&value))),
+	}
+	if _, err := bpf(bpfMapUpdateElem, unsafe.Pointer(&attr), unsafe.Sizeof(attr)); err != nil {
+		return fmt.Errorf("xdp: registering socket for queue %d: %v", queue, err)
+	}
+	return nil
+}
+
+type progLoadAttr struct {
+	progType           uint32
+	insnCnt            uint32
+	insns              uint64
+	license            uint64
+	logLevel           uint32
+	logSize            uint32
+	logBuf             uint64
+	kernVersion        uint32
+	progFlags          uint32
+	progName           [16]byte
+	progIfindex        uint32
+	expectedAttachType uint32
+}
+
+func loadProgram(code []byte) (int, error) {
+	license := []byte("GPL\x00")
+	verifierLog := make([]byte, 64*1024)
+	attr := progLoadAttr{
+		progType:           bpfProgTypeXDP,
+		insnCnt:            uint32(len(code) / 8),
+		insns:              uint64(uintptr(unsafe.Pointer(&code[0]))),
+		license:            uint64(uintptr(unsafe.Pointer(&license[0]))),
+		logLevel:           1,
+		logSize:            uint32(len(verifierLog)),
+		logBuf:             uint64(uintptr(unsafe.Pointer(&verifierLog[0]))),
+		expectedAttachType: bpfAttachXDP,
+	}
+	copy(attr.progName[:], "veneur_xdp")
+	fd, err := bpf(bpfProgLoad, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil {
+		return 0, fmt.Errorf("xdp: loading program: %v: %s", err, strings.TrimRight(string(verifierLog), "\x00"))
+	}
+	return fd, nil
+}
+
+type linkCreateAttr struct {
+	progFD      uint32
+	ifindex     uint32
+	attachType  uint32
+	flags       uint32
+	targetBTFID uint32
+}
+
+// attachProgram attaches the XDP program to the interface using a
+// BPF link, which detaches it automatically when the link's file
+// descriptor is closed (including when the process exits).
+func attachProgram(progFD int, ifindex int) (int, error) {
+	attr := linkCreateAttr{
+		progFD:     uint32(progFD),
+		ifindex:    uint32(ifindex),
+		attachType: bpfAttachXDP,
+	}
+	fd, err := bpf(bpfLinkCreate, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil {
+		return 0, fmt.Errorf("xdp: attaching program: %v", err)
+	}
+	return fd, nil
+}
@@ -0,0 +1,15 @@
+// Package xdp implements an experimental receive path for UDP
+// datagrams that bypasses the kernel's network stack, using Linux
+// AF_XDP sockets.
+//
+// A Listener loads a small XDP program onto a network interface that
+// redirects UDP datagrams addressed to a set of ports into one AF_XDP
+// socket per receive queue of the interface. All other traffic (and
+// traffic arriving on queues without a socket) passes on to the
+// kernel as usual. Datagrams received this way are never seen by
+// the kernel's socket layer, so they are neither subject to socket
+// buffer limits nor to firewall rules.
+//
+// This requires Linux 5.9 or later, and the CAP_NET_ADMIN and
+// CAP_BPF (or CAP_SYS_ADMIN) capabilities.
+package xdp
@@ -0,0 +1,394 @@
+package xdp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrClosed is returned when receiving from a closed socket.
+var ErrClosed = errors.New("xdp: socket closed")
+
+// AF_XDP socket constants, see linux/if_xdp.h.
+const (
+	afXDP  = 44
+	solXDP = 283
+
+	xdpMmapOffsets         = 1
+	xdpRxRing              = 2
+	xdpUmemReg             = 4
+	xdpUmemFillRing        = 5
+	xdpUmemCompletionRing  = 6
+	xdpPgoffRxRing         = 0
+	xdpUmemPgoffFillRing   = 0x100000000
+	xdpUmemPgoffCompletion = 0x180000000
+)
+
+const (
+	// frameSize is the size of each UMEM frame; it must be a
+	// power of two and large enough to hold a full MTU-sized
+	// ethernet frame plus the kernel's headroom.
+	frameSize = 4096
+	// numFrames is the number of UMEM frames per socket, and also
+	// the size of its fill and RX rings.
+	numFrames = 2048
+	// completionRingSize is the size of the completion ring, which
+	// is required even though we never transmit.
+	completionRingSize = 64
+
+	// pollTimeoutMs bounds how long Receive blocks without checking
+	// whether the socket was closed.
+	pollTimeoutMs = 100
+)
+
+type umemReg struct {
+	addr      uint64
+	len       uint64
+	chunkSize uint32
+	headroom  uint32
+}
+
+type ringOffset struct {
+	producer uint64
+	consumer uint64
+	desc     uint64
+	flags    uint64
+}
+
+type mmapOffsets struct {
+	rx, tx, fill, completion ringOffset
+}
+
+type sockaddrXDP struct {
+	family       uint16
+	flags        uint16
+	ifindex      uint32
+	queueID      uint32
+	sharedUmemFD uint32
+}
+
+type xdpDesc struct {
+	addr    uint64
+	len     uint32
+	options uint32
+}
+
+// ring is a single-producer, single-consumer ring shared with the
+// kernel.
+type ring struct {
+	mem      []byte
+	producer *uint32
+	consumer *uint32
+	mask     uint32
+}
+
+func mapRing(fd int, pgoff int64, off ringOffset, size int, entrySize int) (ring, error) {
+	mem, err := unix.Mmap(fd, pgoff, int(off.desc)+size*entrySize,
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		return ring{}, err
+	}
+	return ring{
+		mem:      mem,
+		producer: (*uint32)(unsafe.Pointer(&mem[off.producer])),
+		consumer: (*uint32)(unsafe.Pointer(&mem[off.consumer])),
+		mask:     uint32(size - 1),
+	}, nil
+}
+
+// A Socket is an AF_XDP socket that receives datagrams from one
+// receive queue of a network interface.
+type Socket struct {
+	fd    int
+	queue int
+	umem  []byte
+
+	rx         ring
+	rxDescs    []xdpDesc
+	fill       ring
+	fillAddrs  []uint64
+	completion ring
+
+	// mtx is held while the rings and UMEM are accessed, so
+	// Close can't unmap them from under Receive.
+	mtx    sync.Mutex
+	closed int32
+}
+
+func newSocket(ifindex int, queue int) (*Socket, error) {
+	fd, err := unix.Socket(afXDP, unix.SOCK_RAW|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("xdp: creating socket: %v", err)
+	}
+	s := &Socket{fd: fd, queue: queue}
+	if err := s.setup(ifindex); err != nil {
+		s.release()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Socket) setup(ifindex int) error {
+	var err error
+	s.umem, err = unix.Mmap(-1, 0, frameSize*numFrames,
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS|unix.MAP_POPULATE)
+	if err != nil {
+		return fmt.Errorf("xdp: allocating UMEM: %v", err)
+	}
+	reg := umemReg{
+		addr:      uint64(uintptr(unsafe.Pointer(&s.umem[0]))),
+		len:       uint64(len(s.umem)),
+		chunkSize: frameSize,
+	}
+	if err := setsockopt(s.fd, xdpUmemReg, unsafe.Pointer(&reg), unsafe.Sizeof(reg)); err != nil {
+		return fmt.Errorf("xdp: registering UMEM: %v", err)
+	}
+	for _, opt := range []struct {
+		name int
+		size int
+	}{
+		{xdpUmemFillRing, numFrames},
+		{xdpUmemCompletionRing, completionRingSize},
+		{xdpRxRing, numFrames},
+	} {
+		size := uint32(opt.size)
+		if err := setsockopt(s.fd, opt.name, unsafe.Pointer(&size), unsafe.Sizeof(size)); err != nil {
+			return fmt.Errorf("xdp: sizing ring %d: %v", opt.name, err)
+		}
+	}
+
+	var offsets mmapOffsets
+	optlen := uint32(unsafe.Sizeof(offsets))
+	_, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, uintptr(s.fd), solXDP, xdpMmapOffsets,
+		uintptr(unsafe.Pointer(&offsets)), uintptr(unsafe.Pointer(&optlen)), 0)
+	if errno != 0 {
+		return fmt.Errorf("xdp: getting ring offsets: %v", errno)
+	}
+
+	if s.fill, err = mapRing(s.fd, xdpUmemPgoffFillRing, offsets.fill, numFrames, 8); err != nil {
+		return fmt.Errorf("xdp: mapping fill ring: %v", err)
+	}
+	s.fillAddrs = (*[1 << 28]uint64)(unsafe.Pointer(&s.fill.mem[offsets.fill.desc]))[:numFrames:numFrames]
+	if s.completion, err = mapRing(s.fd, xdpUmemPgoffCompletion, offsets.completion, completionRingSize, 8); err != nil {
+		return fmt.Errorf("xdp: mapping completion ring: %v", err)
+	}
+	if s.rx, err = mapRing(s.fd, xdpPgoffRxRing, offsets.rx, numFrames, int(unsafe.Sizeof(xdpDesc{}))); err != nil {
+		return fmt.Errorf("xdp: mapping RX ring: %v", err)
+	}
+	s.rxDescs = (*[1 << 26]xdpDesc)(unsafe.Pointer(&s.rx.mem[offsets.rx.desc]))[:numFrames:numFrames]
+
+	// Hand all frames to the kernel:
+	for i := range s.fillAddrs {
+		s.fillAddrs[i] = uint64(i * frameSize)
+	}
+	atomic.StoreUint32(s.fill.producer, numFrames)
+
+	sa := sockaddrXDP{
+		family:  afXDP,
+		ifindex: uint32(ifindex),
+		queueID: uint32(s.queue),
+	}
+	_, _, errno = unix.Syscall(unix.SYS_BIND, uintptr(s.fd), uintptr(unsafe.Pointer(&sa)), unsafe.Sizeof(sa))
+	if errno != 0 {
+		return fmt.Errorf("xdp: binding to queue %d: %v", s.queue, errno)
+	}
+	return nil
+}
+
+func setsockopt(fd int, name int, val unsafe.Pointer, size uintptr) error {
+	_, _, errno := unix.Syscall6(unix.SYS_SETSOCKOPT, uintptr(fd), solXDP, uintptr(name), uintptr(val), size, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Receive blocks until datagrams are available on the socket and
+// calls fn with the UDP payload of each, returning the number of
+// datagrams received. The payload is only valid until fn returns.
+// Receive must not be called concurrently with itself.
+func (s *Socket) Receive(fn func(payload []byte)) (int, error) {
+	fds := []unix.PollFd{{Fd: int32(s.fd), Events: unix.POLLIN}}
+	for {
+		if atomic.LoadInt32(&s.closed) != 0 {
+			return 0, ErrClosed
+		}
+		if n := s.receiveAvailable(fn); n != 0 {
+			return n, nil
+		}
+		_, err := unix.Poll(fds, pollTimeoutMs)
+		if err != nil && err != unix.EINTR {
+			return 0, err
+		}
+	}
+}
+
+// receiveAvailable consumes all descriptors on the RX ring and hands
+// their frames back to the kernel via the fill ring.
+func (s *Socket) receiveAvailable(fn func(payload []byte)) int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if atomic.LoadInt32(&s.closed) != 0 {
+		return 0
+	}
+
+	consumer := atomic.LoadUint32(s.rx.consumer)
+	producer := atomic.LoadUint32(s.rx.producer)
+	n := int(producer - consumer)
+	if n == 0 {
+		return 0
+	}
+	fillProducer := atomic.LoadUint32(s.fill.producer)
+	for i := consumer; i != producer; i++ {
+		desc := s.rxDescs[i&s.rx.mask]
+		frame := s.umem[desc.addr : desc.addr+uint64(desc.len)]
+		if payload, ok := udpPayload(frame); ok {
+			fn(payload)
+		}
+		s.fillAddrs[fillProducer&s.fill.mask] = desc.addr &^ (frameSize - 1)
+		fillProducer++
+	}
+	atomic.StoreUint32(s.rx.consumer, producer)
+	atomic.StoreUint32(s.fill.producer, fillProducer)
+	return n
+}
+
+// udpPayload returns the UDP payload of an ethernet frame holding an
+// IPv4 or IPv6 UDP datagram.
+func udpPayload(frame []byte) ([]byte, bool) {
+	if len(frame) < ethHeaderLen {
+		return nil, false
+	}
+	var udp int
+	switch binary.BigEndian.Uint16(frame[ethTypeOffset:]) {
+	case 0x0800:
+		if len(frame) < ethHeaderLen+ipv4HeaderLen {
+			return nil, false
+		}
+		udp = ethHeaderLen + int(frame[ethHeaderLen]&0x0f)*4
+	case 0x86dd:
+		udp = ethHeaderLen + ipv6HeaderLen
+	default:
+		return nil, false
+	}
+	if len(frame) < udp+udpHeaderLen {
+		return nil, false
+	}
+	end := udp + int(binary.BigEndian.Uint16(frame[udp+4:]))
+	if end < udp+udpHeaderLen || end > len(frame) {
+		return nil, false
+	}
+	return frame[udp+udpHeaderLen : end], true
+}
+
+// Close closes the socket. A concurrent Receive returns ErrClosed.
+func (s *Socket) Close() error {
+	if !atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		return nil
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.release()
+}
+
+func (s *Socket) release() error {
+	// The kernel holds on to the UMEM until the socket is
+	// closed, so close it before unmapping anything.
+	err := unix.Close(s.fd)
+	for _, mem := range [][]byte{s.rx.mem, s.completion.mem, s.fill.mem, s.umem} {
+		if mem != nil {
+			unix.Munmap(mem)
+		}
+	}
+	return err
+}
+
+// A Listener receives UDP datagrams addressed to a set of ports on
+// a network interface through one AF_XDP socket per receive queue.
+type Listener struct {
+	link    int
+	prog    int
+	xskMap  int
+	sockets []*Socket
+}
+
+// Listen attaches an XDP program to the interface named ifname that
+// redirects UDP datagrams for any of the given ports to AF_XDP
+// sockets on the receive queues 0 through queues-1. Traffic on other
+// queues is handled by the kernel, so queues should usually match
+// the number of the interface's combined channels (see ethtool -l).
+func Listen(ifname string, queues int, ports []int) (*Listener, error) {
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, err
+	}
+	if queues < 1 {
+		return nil, fmt.Errorf("xdp: need at least one queue, got %d", queues)
+	}
+	if len(ports) == 0 {
+		return nil, errors.New("xdp: no ports to listen on")
+	}
+
+	l := &Listener{link: -1, prog: -1}
+	if l.xskMap, err = createXSKMap(queues); err != nil {
+		return nil, err
+	}
+	for q := 0; q < queues; q++ {
+		sock, err := newSocket(iface.Index, q)
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		l.sockets = append(l.sockets, sock)
+		if err := updateXSKMap(l.xskMap, q, sock.fd); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+
+	code, err := redirectProgram(l.xskMap, ports)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	if l.prog, err = loadProgram(code); err != nil {
+		l.Close()
+		return nil, err
+	}
+	if l.link, err = attachProgram(l.prog, iface.Index); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Sockets returns the listener's sockets, one per receive queue.
+func (l *Listener) Sockets() []*Socket {
+	return l.sockets
+}
+
+// Close detaches the XDP program from the interface and closes all
+// sockets.
+func (l *Listener) Close() error {
+	var firstErr error
+	for _, fd := range []int{l.link, l.prog, l.xskMap} {
+		if fd >= 0 {
+			if err := unix.Close(fd); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	l.link, l.prog, l.xskMap = -1, -1, -1
+	for _, sock := range l.sockets {
+		if err := sock.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,133 @@
+package xdp
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssembleJumps(t *testing.T) {
+	a := &asm{labels: map[string]int{}}
+	a.emit(insn{code: bpfJa, target: "end"})
+	a.emit(insn{code: bpfMovImm, imm: 1})
+	a.label("end")
+	a.emit(insn{code: bpfExit})
+	code, err := a.assemble()
+	require.NoError(t, err)
+	require.Len(t, code, 24)
+	assert.Equal(t, uint16(1), nativeEndian.Uint16(code[2:]), "jump should skip one instruction")
+
+	a.emit(insn{code: bpfJa, target: "nowhere"})
+	_, err = a.assemble()
+	assert.Error(t, err)
+}
+
+func udpFrame(ethType uint16, payload string) []byte {
+	var ip []byte
+	switch ethType {
+	case 0x0800:
+		ip = make([]byte, ipv4HeaderLen)
+		ip[0] = 0x45
+		ip[9] = ipProtoUDP
+	case 0x86dd:
+		ip = make([]byte, ipv6HeaderLen)
+		ip[6] = ipProtoUDP
+	}
+	frame := make([]byte, ethHeaderLen, ethHeaderLen+len(ip)+udpHeaderLen+len(payload))
+	binary.BigEndian.PutUint16(frame[ethTypeOffset:], ethType)
+	frame = append(frame, ip...)
+	udp := make([]byte, udpHeaderLen)
+	binary.BigEndian.PutUint16(udp[2:], 8125)
+	binary.BigEndian.PutUint16(udp[4:], uint16(udpHeaderLen+len(payload)))
+	frame = append(frame, udp...)
+	return append(frame, payload...)
+}
+
+func TestUDPPayload(t *testing.T) {
+	payload, ok := udpPayload(udpFrame(0x0800, "a.b.c:1|c"))
+	assert.True(t, ok)
+	assert.Equal(t, "a.b.c:1|c", string(payload))
+
+	payload, ok = udpPayload(udpFrame(0x86dd, "a.b.c:1|c"))
+	assert.True(t, ok)
+	assert.Equal(t, "a.b.c:1|c", string(payload))
+
+	// Ethernet padding after the datagram must be ignored:
+	payload, ok = udpPayload(append(udpFrame(0x0800, "x"), 0, 0, 0))
+	assert.True(t, ok)
+	assert.Equal(t, "x", string(payload))
+
+	_, ok = udpPayload(udpFrame(0x0806, ""))
+	assert.False(t, ok, "ARP is not UDP")
+	truncated := udpFrame(0x0800, "a.b.c:1|c")
+	_, ok = udpPayload(truncated[:len(truncated)-1])
+	assert.False(t, ok, "truncated datagrams must be dropped")
+}
+
+// TestListenLoopback receives datagrams over the loopback interface,
+// which uses the kernel's generic XDP implementation. It needs to
+// run as root.
+func TestListenLoopback(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("AF_XDP needs root privileges")
+	}
+
+	// Bind a regular socket to reserve a port:
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+
+	l, err := Listen("lo", 1, []int{port})
+	if err != nil {
+		t.Skipf("AF_XDP is not available: %v", err)
+	}
+	defer l.Close()
+	require.Len(t, l.Sockets(), 1)
+
+	received := make(chan string, 10)
+	go func() {
+		for {
+			_, err := l.Sockets()[0].Receive(func(payload []byte) {
+				received <- string(payload)
+			})
+			if err != nil {
+				close(received)
+				return
+			}
+		}
+	}()
+
+	for _, host := range []string{"127.0.0.1", "::1"} {
+		client, err := net.Dial("udp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			t.Logf("Can't send to %s: %v", host, err)
+			continue
+		}
+		_, err = client.Write([]byte("a.b.c:1|c"))
+		require.NoError(t, err)
+		client.Close()
+
+		select {
+		case payload := <-received:
+			assert.Equal(t, "a.b.c:1|c", payload)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for the datagram to %s", host)
+		}
+	}
+
+	// The regular socket must not have seen the datagram:
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err = conn.ReadFrom(make([]byte, 100))
+	assert.Error(t, err)
+
+	require.NoError(t, l.Close())
+	for range received {
+	}
+}
@@ -0,0 +1,43 @@
+//go:build !linux
+// +build !linux
+
+package xdp
+
+import "errors"
+
+// ErrClosed is returned when receiving from a closed socket.
+var ErrClosed = errors.New("xdp: socket closed")
+
+var errUnsupported = errors.New("xdp: AF_XDP is only supported on Linux")
+
+// A Listener receives UDP datagrams through AF_XDP sockets. It is
+// only supported on Linux.
+type Listener struct{}
+
+// Listen is only supported on Linux.
+func Listen(ifname string, queues int, ports []int) (*Listener, error) {
+	return nil, errUnsupported
+}
+
+// Sockets returns the listener's sockets.
+func (l *Listener) Sockets() []*Socket {
+	return nil
+}
+
+// Close closes the listener.
+func (l *Listener) Close() error {
+	return errUnsupported
+}
+
+// A Socket is an AF_XDP socket. It is only supported on Linux.
+type Socket struct{}
+
+// Receive is only supported on Linux.
+func (s *Socket) Receive(fn func(payload []byte)) (int, error) {
+	return 0, errUnsupported
+}
+
+// Close closes the socket.
+func (s *Socket) Close() error {
+	return errUnsupported
+}